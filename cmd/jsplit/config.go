@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables flags can be set with, e.g. JSPLIT_MAX_FILES for -max-files
+const envPrefix = "JSPLIT_"
+
+// configFlag names the flag giving the path of the config file, which can't itself be set in the file
+const configFlag = "config"
+
+// applyConfig sets the flags of fs which weren't given on the command line from the environment, or failing that from
+// the config file named by the -config flag or JSPLIT_CONFIG, so that flags take precedence over environment variables
+// and environment variables over the config file. getenv looks up environment variables.
+func applyConfig(fs *flag.FlagSet, getenv func(string) string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	values := make(map[string]string)
+
+	filename := fs.Lookup(configFlag).Value.String()
+	if !set[configFlag] && getenv(envName(configFlag)) != "" {
+		filename = getenv(envName(configFlag))
+	}
+
+	if filename != "" {
+		config, err := readConfig(filename, fs)
+		if err != nil {
+			return err
+		}
+
+		for name, val := range config {
+			values[name] = val
+		}
+	}
+
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == configFlag {
+			return
+		}
+
+		if val := getenv(envName(f.Name)); val != "" {
+			values[f.Name] = val
+		}
+	})
+
+	fs.VisitAll(func(f *flag.Flag) {
+		val, ok := values[f.Name]
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+
+		if ferr := fs.Set(f.Name, val); ferr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", val, f.Name, ferr)
+		}
+	})
+
+	return err
+}
+
+// envName returns the name of the environment variable setting the flag with the given name
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readConfig reads the values of flags from the config file, which holds a flag on each line as name = value, as in
+// TOML, or name: value, as in YAML. Names are those of the flags, and can also be written with underscores in place of
+// dashes. Values can be quoted, and blank lines and lines starting with # are skipped, as are [section] headers and
+// --- document markers so that simple TOML and YAML files can be read without a parser for either.
+func readConfig(filename string, fs *flag.FlagSet) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	values := make(map[string]string)
+	sc := bufio.NewScanner(f)
+
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" ||
+			(strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]")) {
+			continue
+		}
+
+		sep := strings.IndexAny(text, "=:")
+		if sep < 0 {
+			return nil, fmt.Errorf("%s:%d: expected name = value or name: value, got %q", filename, line, text)
+		}
+
+		name := strings.ReplaceAll(strings.TrimSpace(text[:sep]), "_", "-")
+		if name == configFlag || fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s:%d: unknown option %q", filename, line, name)
+		}
+
+		val, err := configValue(strings.TrimSpace(text[sep+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid value for %s: %w", filename, line, name, err)
+		}
+
+		values[name] = val
+	}
+
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// configValue returns the value of a line of a config file, unquoting it if it is quoted and otherwise dropping a
+// trailing # comment
+func configValue(val string) (string, error) {
+	switch {
+	case strings.HasPrefix(val, `"`):
+		end := strings.LastIndex(val, `"`)
+		if end == 0 || !isComment(val[end+1:]) {
+			return "", fmt.Errorf("unterminated string %s", val)
+		}
+
+		return strconv.Unquote(val[:end+1])
+	case strings.HasPrefix(val, "'"):
+		end := strings.LastIndex(val, "'")
+		if end == 0 || !isComment(val[end+1:]) {
+			return "", fmt.Errorf("unterminated string %s", val)
+		}
+
+		return val[1:end], nil
+	}
+
+	if i := strings.Index(val, " #"); i >= 0 {
+		val = strings.TrimSpace(val[:i])
+	}
+
+	return val, nil
+}
+
+// isComment reports whether the rest of a line following a value is empty or a comment
+func isComment(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
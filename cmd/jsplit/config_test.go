@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testFlags is a flag set holding a few flags of each type, as main declares them
+type testFlags struct {
+	fs       *flag.FlagSet
+	output   string
+	maxFiles int
+	compress bool
+	timeout  time.Duration
+	config   string
+}
+
+func newTestFlags(t *testing.T, args ...string) *testFlags {
+	tf := &testFlags{fs: flag.NewFlagSet("jsplit", flag.ContinueOnError)}
+	tf.fs.SetOutput(io.Discard)
+	tf.fs.StringVar(&tf.output, "output", "", "")
+	tf.fs.IntVar(&tf.maxFiles, "max-files", 0, "")
+	tf.fs.BoolVar(&tf.compress, "compress-output", false, "")
+	tf.fs.DurationVar(&tf.timeout, "read-timeout", 0, "")
+	tf.fs.StringVar(&tf.config, "config", "", "")
+	require.NoError(t, tf.fs.Parse(args))
+
+	return tf
+}
+
+// env returns a getenv function looking up vars
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func writeConfig(t *testing.T, contents string) string {
+	filename := filepath.Join(t.TempDir(), "jsplit.toml")
+	require.NoError(t, os.WriteFile(filename, []byte(contents), 0o600))
+
+	return filename
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	filename := writeConfig(t, "output = \"gs://bucket/config\"\nmax_files = 10\ncompress-output = true\n"+
+		"read-timeout = 30s\n")
+
+	// the config file sets flags which aren't given otherwise
+	tf := newTestFlags(t, "-config", filename)
+	require.NoError(t, applyConfig(tf.fs, env(nil)))
+	require.Equal(t, "gs://bucket/config", tf.output)
+	require.Equal(t, 10, tf.maxFiles)
+	require.True(t, tf.compress)
+	require.Equal(t, 30*time.Second, tf.timeout)
+
+	// environment variables override the config file
+	vars := map[string]string{"JSPLIT_OUTPUT": "gs://bucket/env", "JSPLIT_MAX_FILES": "20"}
+
+	tf = newTestFlags(t, "-config", filename)
+	require.NoError(t, applyConfig(tf.fs, env(vars)))
+	require.Equal(t, "gs://bucket/env", tf.output)
+	require.Equal(t, 20, tf.maxFiles)
+	require.True(t, tf.compress)
+
+	// and flags override both, even when set to their defaults
+	tf = newTestFlags(t, "-config", filename, "-output", "out", "-compress-output=false")
+	require.NoError(t, applyConfig(tf.fs, env(vars)))
+	require.Equal(t, "out", tf.output)
+	require.Equal(t, 20, tf.maxFiles)
+	require.False(t, tf.compress)
+
+	// the config file can be named in the environment too
+	tf = newTestFlags(t)
+	require.NoError(t, applyConfig(tf.fs, env(map[string]string{"JSPLIT_CONFIG": filename})))
+	require.Equal(t, "gs://bucket/config", tf.output)
+
+	// without either, only the environment applies
+	tf = newTestFlags(t)
+	require.NoError(t, applyConfig(tf.fs, env(map[string]string{"JSPLIT_READ_TIMEOUT": "1m"})))
+	require.Equal(t, time.Minute, tf.timeout)
+	require.Empty(t, tf.output)
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	tf := newTestFlags(t)
+	err := applyConfig(tf.fs, env(map[string]string{"JSPLIT_MAX_FILES": "many"}))
+	require.ErrorContains(t, err, `invalid value "many" for max-files`)
+
+	for contents, expected := range map[string]string{
+		"shards = 2\n":                `:1: unknown option "shards"`,
+		"output = gs://b\nconfig = x": `:2: unknown option "config"`,
+		"output\n":                    `:1: expected name = value or name: value, got "output"`,
+		`output = "out`:               `:1: invalid value for output: unterminated string "out`,
+	} {
+		tf = newTestFlags(t, "-config", writeConfig(t, contents))
+		require.ErrorContains(t, applyConfig(tf.fs, env(nil)), expected, contents)
+	}
+
+	tf = newTestFlags(t, "-config", filepath.Join(t.TempDir(), "missing.toml"))
+	require.ErrorIs(t, applyConfig(tf.fs, env(nil)), os.ErrNotExist)
+}
+
+func TestReadConfig(t *testing.T) {
+	// simple TOML and YAML files are both read
+	for _, contents := range []string{
+		"# jsplit options\n[jsplit]\noutput = 'out dir' # comment\nmax-files = 3\ncompress-output = true\n",
+		"---\n# jsplit options\noutput: \"out dir\"\nmax_files: 3 # comment\n\ncompress-output: true\n",
+	} {
+		tf := newTestFlags(t)
+
+		values, err := readConfig(writeConfig(t, contents), tf.fs)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"output": "out dir", "max-files": "3", "compress-output": "true"}, values)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readFilesFrom reads the list of input files of -files-from from the file at path, or from stdin when path is -
+func readFilesFrom(path string, stdin io.Reader) ([]string, error) {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		r = f
+	}
+
+	files, err := parseFileList(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading -files-from %s: %w", path, err)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("-files-from %s doesn't list any files", path)
+	}
+
+	return files, nil
+}
+
+// parseFileList returns the paths of the input files listed one a line, as local paths, gs:// or http(s) URLs, in the
+// order they are listed. Blank lines and lines starting with # are skipped, and the spaces around each path, along
+// with the \r of \r\n line endings, are trimmed.
+func parseFileList(r io.Reader) ([]string, error) {
+	var files []string
+
+	sc := bufio.NewScanner(r)
+
+	for sc.Scan() {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		files = append(files, text)
+	}
+
+	return files, sc.Err()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFilesFrom(t *testing.T) {
+	const list = `# exports of the 1st
+/data/part-0001.json
+  gs://bucket/exports/part-0002.json.gz
+
+# the last part was re-exported
+part-0003.json` + "\r\n" + `gs://bucket/exports/part 0004.json
+`
+
+	expected := []string{
+		"/data/part-0001.json",
+		"gs://bucket/exports/part-0002.json.gz",
+		"part-0003.json",
+		"gs://bucket/exports/part 0004.json",
+	}
+
+	path := filepath.Join(t.TempDir(), "files.txt")
+	require.NoError(t, os.WriteFile(path, []byte(list), 0o600))
+
+	files, err := readFilesFrom(path, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected, files)
+
+	// - reads the list from standard input
+	files, err = readFilesFrom("-", strings.NewReader(list))
+	require.NoError(t, err)
+	require.Equal(t, expected, files)
+}
+
+func TestReadFilesFromErrors(t *testing.T) {
+	_, err := readFilesFrom("-", strings.NewReader("\n# nothing to split\n"))
+	require.EqualError(t, err, "-files-from - doesn't list any files")
+
+	_, err = readFilesFrom(filepath.Join(t.TempDir(), "missing.txt"), nil)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// a line longer than the scanner's buffer
+	_, err = readFilesFrom("-", strings.NewReader(strings.Repeat("x", 70*1024)))
+	require.ErrorContains(t, err, "reading -files-from -: ")
+}
@@ -3,33 +3,1211 @@ package main
 import (
 	"github.com/danielchalef/jsplit/pkg/jsplit"
 
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
+// exitEmptyInput is the exit code when the input holds no json document, so that pipelines which are sometimes fed
+// empty files can tell them apart from other failures
+const exitEmptyInput = 3
+
+// maxProblems is the number of the problems found during a split, such as skipped items, which are listed once it ends
+const maxProblems = 20
+
 func main() {
 	var (
 		filename   string
+		follow     bool
 		outputPath string
 		overwrite  bool
+		appendOut  bool
+		existing   string
+		create     bool
+		fileMode   string
+		dirMode    string
+		atomicOut  bool
+		tmpDir     string
+		project    string
+		creds      string
+		gcsBuffer  int
+		fillReads  bool
+		readBuffer string
+		inputComp  string
+		partSize   int64
+		timeout    time.Duration
+		attempts   int
+		inFlight   int64
+		readRate   int64
+		offset     int64
+		length     int64
+		maxMemory  int64
+		shards     int
+		hashField  string
+		fallback   int
+		partition  string
+		fileField  string
+		dateField  string
+		dateFormat string
+		maxOpen    int
+		maxFiles   int
+		overflow   string
+		maxBytes   int64
+		perFile    int
+		compress   bool
+		gzipLevel  string
+		gzipCores  int
+		gzipBlock  int
+		checksums  bool
+		verify     bool
+		reassemble bool
+		config     string
+		peek       bool
+		countOnly  bool
+		distinct   string
+		approxDist bool
+		validate   bool
+		selfTest   bool
+		stdout     bool
+		mergeOut   string
+		keyField   string
+		peekBytes  int64
+		format     formatFlag
+		csvDelim   string
+		csvCRLF    bool
+		csvQuote   string
+		csvNull    string
+		nestedPq   bool
+		nameTmpl   string
+		ndjson     bool
+		flatten    bool
+		separator  string
+		flattenArr bool
+		uniform    bool
+		uniformIn  string
+		redact     string
+		redactWith string
+		normNums   bool
+		schema     bool
+		bqSchema   bool
+		root       string
+		inputStr   string
+		json5      bool
+		maxDepth   int
+		maxRecord  int64
+		tolerate   bool
+		include    string
+		exclude    string
+		includeRe  string
+		excludeRe  string
+		required   string
+		exactKeys  bool
+		concurrent int
+		rootList   string
+		pretty     bool
+		dryRun     bool
+		skipErrs   bool
+		strictUTF8 string
+		limit      int
+		dedup      string
+		sortBy     string
+		sortMemory int64
+		filter     string
+		since      string
+		keepUntime bool
+		annotate   bool
+		indexField string
+		sourceFld  string
+		normalize  bool
+		collisions string
+		routes     routeFlag
+		valueFiles bool
+		checkpoint time.Duration
+		flushEvery time.Duration
+		progress   time.Duration
+		summaryOut string
+		resume     bool
+		files      string
+		filesFrom  string
+		fileConc   int
+		fileErrs   string
+		logLevel   string
+		logFormat  string
+		quiet      bool
+		verbose    bool
 		err        error
 	)
 
-	flag.StringVar(&filename, "file", "", "Source JSON file")
+	flag.StringVar(&filename, "file", "", "Source JSON file, or - to read from standard input")
+	flag.BoolVar(&follow, "follow", false, "Keep reading -file as it grows, as tail -F does, splitting it as newline delimited json until interrupted")
+	flag.StringVar(&files, "files", "", "Comma separated JSON files to split as separate documents into the same output path, in place of -file")
+	flag.StringVar(&filesFrom, "files-from", "", "File listing the JSON files to split as -files does, one a line, or - to read the list from standard input")
+	flag.IntVar(&fileConc, "file-concurrency", 0, "Number of -files read and parsed at once (one after another when 0)")
+	flag.StringVar(&fileErrs, "file-errors", string(jsplit.FileErrorsFailFast), "What is done when one of -files can't be split, fail-fast stops every file and best-effort splits the others and lists the failures in the manifest")
 	flag.StringVar(&outputPath, "output", "", "Output path for parsed JSON files (can be an s3:// or gs:// URI")
-	flag.BoolVar(&overwrite, "overwrite", false, "Overwrite local filesystem output path if it exists")
+	flag.BoolVar(&stdout, "stdout", false, "Write the items of a single list, selected with -include, to standard output as ndjson instead of files")
+	flag.StringVar(&mergeOut, "merge-output", "", "Write the items of every list to this file, or - for standard output, as one ndjson stream instead of files")
+	flag.StringVar(&keyField, "merge-key-field", jsplit.DefaultKeyField, "Field -merge-output adds the key of each object's list as (none when empty)")
+	flag.BoolVar(&skipErrs, "skip-errors", false, "Skip list items which can't be parsed, logging them to rejects.jsonl in the output path")
+	flag.StringVar(&strictUTF8, "strict-utf8", "", "What is done with strings which aren't valid UTF-8, error or replace with U+FFFD (not checked when empty)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Parse the whole input and report the files which would be written, without writing any")
+	flag.BoolVar(&overwrite, "overwrite", false, "Overwrite local filesystem output path if it exists, the same as -existing-output overwrite")
+	flag.BoolVar(&appendOut, "append", false, "Append to the split recorded in the manifest of the output path, the same as -existing-output append")
+	flag.StringVar(&existing, "existing-output", "", "What is done with an output path which exists, error, overwrite or append (defaults to error)")
+	flag.StringVar(&tmpDir, "tmp-dir", "", "Directory temporary files are written to, such as the data spilled while writing csv (defaults to $TMPDIR or /tmp)")
+	flag.BoolVar(&create, "create", true, "Create a local output path which doesn't exist, along with its parents (with -create=false it must exist already)")
+	flag.StringVar(&fileMode, "file-mode", "", "Octal permissions of the output files, e.g. 0640, regardless of the umask (defaults to 0666 less the umask)")
+	flag.StringVar(&dirMode, "dir-mode", "", "Octal permissions of the output directories created, e.g. 0750 or 2750 to set the setgid bit (defaults to 0755 less the umask)")
+	flag.BoolVar(&atomicOut, "atomic-files", false, "Write each local output file under a temporary name, .name.tmp, and rename it once complete, removing it if the split fails")
+	flag.StringVar(&project, "gcs-user-project", "", "Project billed for reads from requester pays GCS buckets (defaults to $JSPLIT_GCS_USER_PROJECT)")
+	flag.Int64Var(&partSize, "gcs-compose-part-size", 0, "Upload files written to GCS in parts of this many bytes which are composed into each file (uploaded in one go when 0)")
+	flag.StringVar(&readBuffer, "read-buffer-size", "", "Read the input in chunks of this many bytes, or auto to grow them from 1MB up to 16MB while larger chunks read faster (1MB when not set)")
+	flag.BoolVar(&fillReads, "fill-read-buffers", false, "Fill each chunk of the input before it is split, for sources which return a little at a time, such as compressed input")
+	flag.IntVar(&gcsBuffer, "gcs-read-buffer-size", 0, "Read GCS objects in filled chunks of this many bytes, e.g. 16777216 for large objects (read as other input when 0)")
+	flag.StringVar(&creds, "gcs-credentials", "", "Credentials JSON file used to read from GCS (defaults to application default credentials)")
+	flag.StringVar(&inputComp, "input-compression", string(jsplit.CompressionAuto), "Compression format the input is decompressed as, auto to detect it from its first bytes, none, gzip, zstd, bzip2 or lz4")
+	flag.DurationVar(&timeout, "read-timeout", 0, "Fail if a read of the input returns no data for this long, e.g. 30s (disabled when 0)")
+	flag.IntVar(&attempts, "retry-attempts", jsplit.DefaultRetryPolicy.MaxAttempts, "Times a read of an http(s) URL or cloud storage object which fails with a transient error is attempted, resuming where it stopped (1 disables retries)")
+	flag.Int64Var(&readRate, "max-read-rate", 0, "Most bytes of the input read each second, to avoid saturating a shared link or being throttled (unlimited when 0)")
+	flag.Int64Var(&offset, "offset", 0, "Byte offset of -file to start reading at, to split only part of an uncompressed input")
+	flag.Int64Var(&length, "length", 0, "Number of bytes of -file to read from -offset (reads to the end when 0)")
+	flag.BoolVar(&json5, "json5", false, "Accept // and /* */ comments and trailing commas in the input, stripping them before parsing")
+	flag.Int64Var(&maxRecord, "max-record-bytes", 0, "Largest a list item or other value can be before the split fails, or it is skipped with -skip-errors (no limit when 0)")
+	flag.BoolVar(&tolerate, "tolerate-truncation", false, "Write the records before the end of input which stops part way through the document, warning where it ended, instead of failing")
+	flag.IntVar(&maxDepth, "max-depth", 0, "Deepest objects and arrays can be nested within a list item or other value before the split fails (defaults to 10000)")
+	flag.Int64Var(&inFlight, "max-in-flight-bytes", 0, "Pause reading while this many bytes of the input are waiting to be split, e.g. to bound memory when uploads are slow (unlimited when 0)")
+	flag.Int64Var(&maxMemory, "max-memory", 0, "Pause reading while the split holds this many bytes in memory, counting queued input and the buffers of open output files (unlimited when 0)")
+	flag.StringVar(&root, "root", "", "JSON pointer to the object within the document to split, e.g. /data/results")
+	flag.StringVar(&inputStr, "input-stream", "", "How input which is a run of json values is read: auto, values for a stream of values written like the elements of a list, or documents split by their keys (defaults to auto)")
+	flag.StringVar(&include, "include", "", "Comma separated keys to split, leaving out every other key")
+	flag.StringVar(&exclude, "exclude", "", "Comma separated keys to leave out of the split (ignored when -include is set)")
+	flag.StringVar(&includeRe, "include-regex", "", "Regular expression matching more keys to split, leaving out every other key, e.g. ^log_")
+	flag.StringVar(&excludeRe, "exclude-regex", "", "Regular expression matching more keys to leave out of the split (ignored when -include or -include-regex is set)")
+	flag.StringVar(&required, "require-keys", "", "Comma separated keys the object being split must have, failing the split when one is missing")
+	flag.BoolVar(&exactKeys, "exact-keys", false, "Also fail the split when the object has a key which isn't one of -require-keys")
+	flag.IntVar(&limit, "limit", 0, "Write at most this many items of each list, skipping the rest, to take a sample (disabled when 0)")
+	flag.BoolVar(&annotate, "annotate", false, "Add the index of each object in its list, and optionally its source file, as fields of the object")
+	flag.StringVar(&indexField, "index-field", jsplit.DefaultIndexField, "Field -annotate adds the index of each object as (none when empty)")
+	flag.StringVar(&sourceFld, "source-field", "", "Field -annotate adds the name of the file each object was read from as (none when empty)")
+	flag.StringVar(&sortBy, "sort-by", "", "Field the objects of each list are sorted by before they are written")
+	flag.Int64Var(&sortMemory, "max-sort-memory", 0, "Bytes of items held in memory for each list being sorted before they are spilled to -tmp-dir (defaults to 256MB)")
+	flag.StringVar(&dedup, "dedup-field", "", "Field identifying the objects of each list, writing only the first object with each value")
+	flag.StringVar(&filter, "filter", "", `Expression the items of each list must match to be written, e.g. 'status == "active" and age >= 18'`)
+	flag.StringVar(&since, "since", "", "Only write the items whose timestamp field is at or after a cutoff, as field=timestamp, e.g. updated_at=2024-05-01")
+	flag.BoolVar(&keepUntime, "keep-untimed", false, "Write the items without a timestamp in the -since field rather than dropping them")
+	flag.IntVar(&shards, "shards", 0, "Number of jsonl files to distribute the elements of a root level json array across")
+	flag.StringVar(&hashField, "hash-partition", "", "Field by whose hash the elements are distributed across -shards instead of round-robin, keeping equal values in the same shard")
+	flag.IntVar(&fallback, "fallback-shard", 0, "Shard the elements without the -hash-partition field are written to")
+	flag.StringVar(&partition, "partition-by", "", "Field by whose value the elements of a root level json array are partitioned into files named after the value")
+	flag.StringVar(&fileField, "filename-field", "", "Field of the elements of a root level json array naming the file each is written to, which is removed from them")
+	flag.StringVar(&dateField, "partition-by-date", "", "Timestamp field by whose date the elements of a root level json array are partitioned into directories named dt=<date>")
+	flag.StringVar(&dateFormat, "date-format", jsplit.DefaultDateFormat, "Go time layout the dates of -partition-by-date are formatted with, e.g. 2006-01 for monthly partitions")
+	flag.IntVar(&maxOpen, "max-open-partitions", jsplit.DefaultMaxOpenPartitions, "Number of partitions whose files are kept open at once, closing and later reopening the least recently written")
+	flag.IntVar(&maxFiles, "max-files", 0, "Most files of lists, shards and partitions to write, failing the split before writing one more (unlimited when 0)")
+	flag.StringVar(&overflow, "overflow-partition", "", "Partition the elements with new values are written to once -max-files is close to being reached, instead of failing")
+	flag.StringVar(&rootList, "root-list-key", jsplit.DefaultRootListKey, "Name of the files the elements of a json array at the root of the document are written to when -shards isn't set")
+	flag.IntVar(&concurrent, "concurrency", 1, "Number of lists written concurrently")
+	flag.Int64Var(&maxBytes, "max-file-bytes", jsplit.DefaultMaxFileBytes, "Size in bytes after which a new jsonl file is started")
+	flag.IntVar(&perFile, "records-per-file", 0, "Number of items written to each file of a list, the last holding the rest, in place of -max-file-bytes (disabled when 0)")
+	format.format = string(jsplit.FormatJSONL)
+	flag.StringVar(&csvDelim, "csv-delimiter", ",", "Character separating the fields of csv files, e.g. tab or |")
+	flag.BoolVar(&csvCRLF, "csv-crlf", false, "End the rows of csv files with \\r\\n rather than \\n")
+	flag.StringVar(&csvQuote, "csv-quote", "minimal", "How the fields of csv files are quoted, minimal, all, or none to escape them with a backslash instead")
+	flag.StringVar(&csvNull, "csv-null", "", "Text written for json nulls in csv files, e.g. \\N for Postgres COPY (empty by default)")
+	flag.Var(&format, "format", "Format lists are written in, jsonl, ndjson, csv, msgpack or parquet, or key=format for the lists of one key, which can be repeated")
+	flag.BoolVar(&nestedPq, "parquet-nested-json", false, "Write the nested objects and arrays of parquet files as json strings, rather than failing")
+	flag.StringVar(&collisions, "name-collisions", string(jsplit.NameCollisionsSuffix), "What is done when keys or partition values share a slug with -normalize-names, suffix names the later _2 and fail stops the split")
+	flag.Var(&routes, "route", "Write the lists of the keys matching a glob to a directory within the output, as pattern=dir, e.g. dim_*=dims, which can be repeated")
+	flag.BoolVar(&normalize, "normalize-names", false, "Name the files written for each key after a lowercase slug of the key, e.g. user_profiles for \"User Profiles\"")
+	flag.BoolVar(&valueFiles, "value-files", false, "Write each value in the root which isn't a list to <key>.json as it is, instead of collecting them in root.json")
+	flag.StringVar(&nameTmpl, "name-template", "", "Go template naming list files, e.g. raw_{{.Key}}_{{printf \"%04d\" .Shard}}.{{.Ext}} (defaults to <key>_%02d.<ext>)")
+	flag.BoolVar(&ndjson, "ndjson", false, "Write lists as newline terminated json, the same as -format ndjson")
+	flag.BoolVar(&flatten, "flatten", false, "Flatten the nested objects of list items, joining their keys with -flatten-separator")
+	flag.StringVar(&separator, "flatten-separator", jsplit.DefaultFlattenSeparator, "Separator joining the keys of flattened objects")
+	flag.BoolVar(&flattenArr, "flatten-arrays", false, "Index the elements of arrays of scalars when flattening, e.g. a.0, a.1, rather than leaving them as json")
+	flag.BoolVar(&uniform, "uniform-schema", false, "Write every object of a list with every field seen in the list, null when missing, reading the input twice")
+	flag.StringVar(&uniformIn, "uniform-schema-file", "", "Json file of the fields of each list, by key, e.g. {\"users\": [\"id\", \"name\"]}, making objects uniform without a first pass")
+	flag.StringVar(&redact, "redact", "", "Comma separated fields removed from list items, with dots between the names of nested fields, e.g. ssn,user.email")
+	flag.StringVar(&redactWith, "redact-placeholder", "", "Replace the values of -redact fields with this string, e.g. [REDACTED], rather than removing them")
+	flag.BoolVar(&normNums, "normalize-numbers", false, "Write every number in one canonical form, e.g. 1.0 and 1e0 as 1 and 2.50 as 2.5, keeping its digits")
+	flag.BoolVar(&pretty, "pretty", false, "Indent the output json with two spaces, so items span several lines")
+	flag.BoolVar(&schema, "infer-schema", false, "Write a JSON Schema inferred from the items of each list to <key>.schema.json")
+	flag.BoolVar(&bqSchema, "bq-schema", false, "Write a BigQuery schema inferred from the objects of each list to <key>.bq_schema.json")
+	flag.BoolVar(&compress, "compress-output", false, "Gzip compress the output files, appending .gz to their names")
+	flag.StringVar(&gzipLevel, "gzip-level", "default", "Level -compress-output compresses at, 1-9, default, best-speed, best-compression or huffman-only")
+	flag.IntVar(&gzipCores, "gzip-concurrency", 0, "Compress each file -compress-output writes in blocks on this many goroutines at once, still as standard gzip (on one when 0 or 1)")
+	flag.IntVar(&gzipBlock, "gzip-block-size", 0, "Size in bytes of the blocks -gzip-concurrency compresses at once, more than 16KB (defaults to 1MB)")
+	flag.BoolVar(&checksums, "checksums", false, "Record the SHA-256 digest of each output file in the manifest")
+	flag.BoolVar(&reassemble, "reassemble", false, "Write the document split into -output back to standard output as one json object, instead of splitting")
+	flag.BoolVar(&verify, "verify", false, "Verify the files in -output against the checksums in its manifest, instead of splitting")
+	flag.DurationVar(&progress, "progress", defaultProgress(), "Print the bytes read, records parsed, rate and estimated time left to standard error this often, by default only when it is a terminal (disabled when 0)")
+	flag.StringVar(&summaryOut, "summary-out", "", "Write a json summary of the run, with what was read and written, how long it took and why it ended, to this file however it ends")
+	flag.DurationVar(&checkpoint, "checkpoint-interval", 0, "Record the progress of the split in checkpoint.json this often, e.g. 1m, so it can be resumed (disabled when 0)")
+	flag.DurationVar(&flushEvery, "flush-interval", 0, "Sync the files being written to disk this often, e.g. 30s, so the items written survive a crash (disabled when 0)")
+	flag.BoolVar(&resume, "resume", false, "Resume the interrupted split recorded in checkpoint.json in the output path")
+	flag.BoolVar(&peek, "peek", false, "Print the keys in the root of -file, the types of their values and the lengths of arrays, instead of splitting")
+	flag.BoolVar(&validate, "validate", false, "Check that -file is well-formed json, printing valid or the first error, instead of splitting")
+	flag.BoolVar(&countOnly, "count-only", false, "Print the number of records in each list of -file and their total, instead of splitting")
+	flag.BoolVar(&selfTest, "selftest", false, "Check that input can be read from local files, each compression format, http and GCS, and -file if it is set, instead of splitting")
+	flag.StringVar(&distinct, "count-distinct", "", "Print the number of distinct values of these comma separated fields of the objects in each list of -file, instead of splitting")
+	flag.BoolVar(&approxDist, "approx-distinct", false, "Estimate the distinct values counted by -count-distinct with a HyperLogLog sketch of 16KB a field, once a field has more than 10000 of them")
+	flag.Int64Var(&peekBytes, "peek-bytes", 0, "Stop peeking after reading this many bytes of the input (reads the whole input when 0)")
+	flag.StringVar(&logLevel, "log-level", "", "Level of the messages printed, error, warn, info or debug (defaults to info when standard output is a terminal, warn otherwise)")
+	flag.BoolVar(&quiet, "quiet", false, "Only print errors, the same as -log-level error, and turn off -progress")
+	flag.BoolVar(&verbose, "verbose", false, "Also print the chunks read from the input and retried reads, the same as -log-level debug")
+	flag.StringVar(&logFormat, "log-format", "text", "Format messages are printed in, text or json")
+	flag.StringVar(&config, "config", "", "Config file setting flags as name = value or name: value lines, which flags and JSPLIT_* environment variables override")
 	flag.Parse()
 
-	if filename == "" || outputPath == "" {
-		fmt.Println("Usage: jsplit -file <json_file> -output <output_path>")
+	err = applyConfig(flag.CommandLine, os.Getenv)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	logger, err := newLogger(logLevel, logFormat, quiet, verbose)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	jsplit.SetLogger(logger)
+	slog.SetDefault(logger)
+
+	readerOpts := []jsplit.AsyncReaderOption{
+		jsplit.WithGCSUserProject(project),
+		jsplit.WithGCSCredentialsFile(creds),
+		jsplit.WithGCSReadBufferSize(gcsBuffer),
+		jsplit.WithReadTimeout(timeout),
+		jsplit.WithMaxInFlightBytes(inFlight),
+		jsplit.WithMaxReadRate(readRate),
+		jsplit.WithCompression(jsplit.Compression(inputComp)),
+	}
+
+	if attempts != jsplit.DefaultRetryPolicy.MaxAttempts {
+		retry := jsplit.DefaultRetryPolicy
+		retry.MaxAttempts = attempts
+		readerOpts = append(readerOpts, jsplit.WithRetryPolicy(retry))
+	}
+
+	bufferOpt, err := parseReadBufferSize(readBuffer)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if bufferOpt != nil {
+		readerOpts = append(readerOpts, bufferOpt)
+	}
+
+	if fillReads {
+		readerOpts = append(readerOpts, jsplit.WithFillBuffers())
+	}
+
+	if offset != 0 || length != 0 {
+		readerOpts = append(readerOpts, jsplit.WithByteRange(offset, length))
+	}
+
+	if verify && outputPath != "" {
+		verified, err := jsplit.VerifyChecksums(context.Background(), outputPath)
+		if err != nil {
+			slog.Error("Verification failed: "+err.Error(), "error", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%d files verified\n", verified)
+
+		return
+	}
+
+	if selfTest {
+		report := jsplit.SelfTest(context.Background(), jsplit.SelfTestOptions{Input: filename}, readerOpts...)
+
+		err = report.Print(os.Stdout)
+		if err != nil || report.Failed() {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if reassemble && outputPath != "" {
+		err = jsplit.Reassemble(context.Background(), outputPath, os.Stdout)
+		if err != nil {
+			slog.Error("Reassembly failed: "+err.Error(), "error", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if approxDist && distinct == "" {
+		fmt.Println("-approx-distinct can only be used with -count-distinct")
+		os.Exit(1)
+	}
+
+	if (peek || countOnly || distinct != "") && filename != "" {
+		peekOpts := jsplit.PeekOptions{Root: root, MaxBytes: peekBytes, ApproxDistinct: approxDist}
+		if distinct != "" {
+			peekOpts.DistinctFields = splitList(distinct)
+		}
+
+		err = peekFile(filename, peekOpts, countOnly, readerOpts)
+		if errors.Is(err, jsplit.ErrEmptyInput) {
+			slog.Error("Peek failed: "+err.Error(), "error", err)
+			os.Exit(exitEmptyInput)
+		}
+
+		if err != nil {
+			slog.Error("Peek failed: "+err.Error(), "error", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if validate && filename != "" {
+		err = validateFile(filename, jsplit.ValidateOptions{JSON5: json5}, readerOpts)
+		if err != nil {
+			slog.Error("Validation failed: "+err.Error(), "error", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("valid")
+
+		return
+	}
+
+	fileList := splitList(files)
+
+	if filesFrom != "" {
+		if files != "" {
+			fmt.Println("-files-from can't be used with -files")
+			os.Exit(1)
+		}
+
+		fileList, err = readFilesFrom(filesFrom, os.Stdin)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if filename != "" && len(fileList) > 0 {
+		fmt.Println("-file can't be used with -files")
+		os.Exit(1)
+	}
+
+	if follow && len(fileList) > 0 {
+		fmt.Println("-follow can't be used with -files, only a single -file can be followed")
+		os.Exit(1)
+	}
+
+	if (filename == "" && len(fileList) == 0) || (outputPath == "" && !stdout && mergeOut == "") {
+		fmt.Println("Usage: jsplit -file <json_file> -output <output_path>, jsplit -files <json_file>,... -output " +
+			"<output_path>, jsplit -files-from <list_file> -output <output_path>, jsplit -stdout -file <json_file>, " +
+			"jsplit -merge-output <ndjson_file> -file <json_file>, jsplit -peek -file <json_file>, " +
+			"jsplit -count-only -file <json_file>, jsplit -count-distinct <fields> -file <json_file>, " +
+			"jsplit -validate -file <json_file>, jsplit -verify -output <output_path>, " +
+			"jsplit -reassemble -output <output_path>, or jsplit -selftest")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	err = jsplit.Split(filename, outputPath, overwrite)
+	if stdout && (outputPath != "" || len(fileList) > 0 || shards > 0 || partition != "" || dateField != "" ||
+		format.writes(jsplit.FormatCSV) || format.writes(jsplit.FormatMsgpack) || format.writes(jsplit.FormatParquet) ||
+		compress || checkpoint > 0 || resume) {
+		fmt.Println("-stdout can't be used with -output, -files, -shards, -partition-by, -partition-by-date, -format csv, " +
+			"-format msgpack, -format parquet, -compress-output or checkpoints")
+		os.Exit(1)
+	}
+
+	if mergeOut != "" && (stdout || outputPath != "" || len(fileList) > 0 || shards > 0 || partition != "" ||
+		dateField != "" || format.writes(jsplit.FormatCSV) || format.writes(jsplit.FormatMsgpack) ||
+		format.writes(jsplit.FormatParquet) || compress || checkpoint > 0 || resume) {
+		fmt.Println("-merge-output can't be used with -stdout, -output, -files, -shards, -partition-by, " +
+			"-partition-by-date, -format csv, -format msgpack, -format parquet, -compress-output or checkpoints")
+		os.Exit(1)
+	}
+
+	if ndjson {
+		if format.format != string(jsplit.FormatJSONL) && format.format != string(jsplit.FormatNDJSON) {
+			fmt.Printf("-ndjson can't be used with -format %s\n", format.format)
+			os.Exit(1)
+		}
+
+		format.format = string(jsplit.FormatNDJSON)
+	}
+
+	outFileMode, err := parseMode("file-mode", fileMode)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	outDirMode, err := parseMode("dir-mode", dirMode)
 	if err != nil {
-		fmt.Printf("Split failed: %s", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
+
+	delimiter, err := parseCsvDelimiter(csvDelim)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	csvDialect := jsplit.CSVDialect{Delimiter: delimiter, CRLF: csvCRLF, Quote: jsplit.CSVQuote(csvQuote), Null: csvNull}
+
+	includePattern, err := parseKeyPattern("include-regex", includeRe)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	excludePattern, err := parseKeyPattern("exclude-regex", excludeRe)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	uniformFields, err := readUniformFields(uniformIn)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	sinceField, sinceTime, err := parseSince(since)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if keepUntime && sinceField == "" {
+		fmt.Println("-keep-untimed can only be used with -since")
+		os.Exit(1)
+	}
+
+	if exactKeys && required == "" {
+		fmt.Println("-exact-keys can only be used with -require-keys")
+		os.Exit(1)
+	}
+
+	level, err := parseGzipLevel(gzipLevel)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if level != 0 && !compress {
+		fmt.Println("-gzip-level can only be used with -compress-output")
+		os.Exit(1)
+	}
+
+	if (gzipCores != 0 || gzipBlock != 0) && !compress {
+		fmt.Println("-gzip-concurrency and -gzip-block-size can only be used with -compress-output")
+		os.Exit(1)
+	}
+
+	if gzipBlock != 0 && gzipCores <= 1 {
+		fmt.Println("-gzip-block-size can only be used with a -gzip-concurrency of more than 1")
+		os.Exit(1)
+	}
+
+	policy, err := existingOutputPolicy(existing, overwrite, appendOut)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	opts := jsplit.Options{
+		ReaderOptions:      readerOpts,
+		Root:               root,
+		InputStream:        jsplit.InputStream(inputStr),
+		JSON5:              json5,
+		MaxDepth:           maxDepth,
+		MaxRecordBytes:     maxRecord,
+		TolerateTruncation: tolerate,
+		IncludeKeys:        splitList(include),
+		ExcludeKeys:        splitList(exclude),
+		IncludeKeyPattern:  includePattern,
+		ExcludeKeyPattern:  excludePattern,
+		RequireKeys:        splitList(required),
+		ExactKeys:          exactKeys,
+		Limit:              limit,
+		DedupField:         dedup,
+		SortBy:             sortBy,
+		MaxSortMemory:      sortMemory,
+		Filter:             filter,
+		SinceField:         sinceField,
+		Since:              sinceTime,
+		KeepUntimed:        keepUntime,
+		Shards:             shards,
+		HashPartition:      hashField,
+		FallbackShard:      fallback,
+		PartitionBy:        partition,
+		FilenameField:      fileField,
+		PartitionByDate:    dateField,
+		DateFormat:         dateFormat,
+		MaxOpenPartitions:  maxOpen,
+		MaxFiles:           maxFiles,
+		OverflowPartition:  overflow,
+		RootListKey:        rootList,
+		MaxFileBytes:       maxBytes,
+		RecordsPerFile:     perFile,
+		Concurrency:        concurrent,
+		FileConcurrency:    fileConc,
+		FileErrors:         jsplit.FileErrors(fileErrs),
+		MaxMemory:          maxMemory,
+		Format:             jsplit.Format(format.format),
+		KeyFormats:         format.keys,
+		ParquetNestedJSON:  nestedPq,
+		CSV:                csvDialect,
+		NameTemplate:       nameTmpl,
+		NormalizeNames:     normalize,
+		NameCollisions:     jsplit.NameCollisions(collisions),
+		KeyRoutes:          routes,
+		ValueFiles:         valueFiles,
+		Flatten:            flatten,
+		FlattenSeparator:   separator,
+		FlattenArrays:      flattenArr,
+		UniformFields:      uniformFields,
+		Redact:             splitList(redact),
+		RedactPlaceholder:  redactWith,
+		NormalizeNumbers:   normNums,
+		Pretty:             pretty,
+		InferSchema:        schema,
+		BigQuerySchema:     bqSchema,
+		CompressOutput:     compress,
+		GzipLevel:          level,
+		GzipConcurrency:    gzipCores,
+		GzipBlockSize:      gzipBlock,
+		Checksums:          checksums,
+		GCSComposePartSize: partSize,
+		SkipErrors:         skipErrs,
+		StrictUTF8:         jsplit.UTF8Policy(strictUTF8),
+		DryRun:             dryRun,
+		CheckpointInterval: checkpoint,
+		FlushInterval:      flushEvery,
+		Resume:             resume,
+		ExistingOutput:     policy,
+		RequireOutputDir:   !create,
+		FileMode:           outFileMode,
+		DirMode:            outDirMode,
+		AtomicFiles:        atomicOut,
+	}
+
+	if annotate {
+		opts.IndexField = indexField
+		opts.SourceField = sourceFld
+	}
+
+	if mergeOut != "" {
+		opts.KeyField = keyField
+	}
+
+	if progress > 0 && !quiet {
+		opts.Stats = jsplit.NewProgressPrinter(os.Stderr)
+		opts.StatsInterval = progress
+	}
+
+	summary := newRunSummary(filename, fileList, outputPath, mergeOut, stdout)
+	if summaryOut != "" {
+		opts.Stats = summary.Track(opts.Stats)
+		opts.Uploaded = summary.TrackUploads()
+		opts.KeyWriteTime = summary.TrackWriteTimes()
+	}
+
+	// exit records how the split ended in the summary, if one is written, before exiting with code
+	exit := func(code int, reason string, problems *jsplit.ErrCancelContext) {
+		if summaryOut != "" {
+			summary.Reason, summary.ExitCode = reason, code
+			summary.Finish(context.Background(), err, problems)
+
+			if err := summary.WriteFile(summaryOut); err != nil {
+				slog.Error("Unable to write the summary: "+err.Error(), "error", err)
+
+				if code == 0 {
+					code = 1
+				}
+			}
+		}
+
+		os.Exit(code)
+	}
+
+	// the temporary files of the split are kept in a directory of their own, so that they can be removed however it
+	// ends
+	opts.TempDir, err = os.MkdirTemp(tmpDir, "jsplit-")
+	if err != nil {
+		slog.Error("Split failed: "+err.Error(), "error", err)
+		exit(1, "error", nil)
+	}
+
+	// interrupting the split stops it cleanly, closing the files written so far. A second interrupt exits immediately,
+	// after removing the temporary files.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+
+		again := make(chan os.Signal, 1)
+		signal.Notify(again, os.Interrupt, syscall.SIGTERM)
+		stop()
+
+		<-again
+		_ = os.RemoveAll(opts.TempDir)
+		os.Exit(130)
+	}()
+
+	// a followed file is never finished, so the first interrupt stops following it rather than the split, which then
+	// ends as it would at the end of the file
+	splitCtx := ctx
+	if follow {
+		opts.ReaderOptions = append(opts.ReaderOptions, jsplit.WithFollow(ctx.Done()))
+		splitCtx = context.Background()
+	}
+
+	// the fields of a uniform schema are found in a first pass over the input, unless they were supplied
+	if uniform && uniformIn == "" {
+		inputs := []string{filename}
+		if len(fileList) > 0 {
+			inputs = fileList
+		}
+
+		opts.UniformFields, err = jsplit.InferUniformFields(ctx, inputs, opts)
+		if err != nil {
+			_ = os.RemoveAll(opts.TempDir)
+
+			slog.Error("Unable to find the fields of a uniform schema, supply them with -uniform-schema-file: "+err.Error(),
+				"error", err)
+			exit(1, "error", nil)
+		}
+	}
+
+	problems, _ := jsplit.NewErrContextWithProblems(splitCtx, maxProblems)
+
+	switch {
+	case stdout:
+		err = streamFile(problems, filename, opts)
+	case mergeOut != "":
+		err = mergeFile(problems, filename, mergeOut, opts)
+	case len(fileList) > 0:
+		err = jsplit.SplitFiles(problems, fileList, outputPath, opts)
+	default:
+		err = jsplit.SplitFile(problems, filename, outputPath, opts)
+	}
+
+	_ = os.RemoveAll(opts.TempDir)
+
+	printProblems(problems)
+
+	if err != nil && ctx.Err() != nil {
+		msg := "Split interrupted, the files written so far have been closed: " + err.Error()
+		if checkpoint > 0 {
+			msg += ". Run again with -resume to carry on from the last checkpoint"
+		}
+
+		slog.Error(msg, "error", err)
+
+		exit(130, "interrupted", problems)
+	}
+
+	if errors.Is(err, jsplit.ErrOutputExists) {
+		slog.Error(fmt.Sprintf("Split failed: %s, use -overwrite to replace it or -append to add to it", err),
+			"error", err)
+		exit(1, "output_exists", problems)
+	}
+
+	if errors.Is(err, jsplit.ErrTooManyFiles) {
+		slog.Error(fmt.Sprintf("Split failed: %s, raise -max-files or use -overflow-partition", err), "error", err)
+		exit(1, "too_many_files", problems)
+	}
+
+	if errors.Is(err, jsplit.ErrFilesFailed) {
+		slog.Error(fmt.Sprintf("Split finished, but %s", err), "error", err)
+		exit(1, "files_failed", problems)
+	}
+
+	if errors.Is(err, jsplit.ErrEmptyInput) {
+		slog.Error("Split failed: "+err.Error(), "error", err)
+		exit(exitEmptyInput, "empty_input", problems)
+	}
+
+	if errors.Is(err, jsplit.ErrNoOutputDir) {
+		slog.Error(fmt.Sprintf("Split failed: %s, and -create=false is set", err), "error", err)
+		exit(1, "no_output_dir", problems)
+	}
+
+	if err != nil {
+		slog.Error("Split failed: "+err.Error(), "error", err)
+		exit(1, "error", problems)
+	}
+
+	if summaryOut != "" {
+		exit(0, "completed", problems)
+	}
+}
+
+// newRunSummary returns the summary of a split of filename, or of files when they are set, written to outputPath, or
+// to mergeOut or standard output when they are set
+func newRunSummary(filename string, files []string, outputPath, mergeOut string, stdout bool) *jsplit.RunSummary {
+	inputs := []string{filename}
+	if len(files) > 0 {
+		inputs = files
+	}
+
+	switch {
+	case stdout:
+		outputPath = "-"
+	case mergeOut != "":
+		outputPath = mergeOut
+	}
+
+	return jsplit.NewRunSummary(inputs, outputPath)
+}
+
+// printProblems prints a summary of the problems found during the split once it has ended, however it ended
+func printProblems(ctx *jsplit.ErrCancelContext) {
+	problems, reported := ctx.Problems()
+	if reported == 0 {
+		return
+	}
+
+	slog.Warn(fmt.Sprintf("%d problems were found during the split:", reported), "problems", reported)
+
+	for _, p := range problems {
+		slog.Warn(fmt.Sprintf("  at byte %d: %s", p.Offset, p.Err), "offset", p.Offset, "error", p.Err)
+	}
+
+	if reported > len(problems) {
+		slog.Warn(fmt.Sprintf("  and %d more", reported-len(problems)))
+	}
+}
+
+// existingOutputPolicy returns what is done with an output path which exists for the -existing-output flag, or the
+// -overwrite and -append flags it can be given by instead
+func existingOutputPolicy(policy string, overwrite, appendOut bool) (jsplit.ExistingOutput, error) {
+	switch {
+	case overwrite && appendOut:
+		return "", errors.New("-overwrite and -append can't be used together")
+	case policy != "" && (overwrite || appendOut):
+		return "", errors.New("-existing-output can't be used with -overwrite or -append")
+	case overwrite:
+		return jsplit.ExistingOutputOverwrite, nil
+	case appendOut:
+		return jsplit.ExistingOutputAppend, nil
+	case policy == "":
+		return jsplit.ExistingOutputError, nil
+	}
+
+	return jsplit.ExistingOutput(policy), nil
+}
+
+// newLogger returns the logger messages are printed with for the -log-level, -log-format, -quiet and -verbose flags.
+// Messages are written to standard output as it is when each is written, so that they follow it to standard error
+// with -stdout.
+func newLogger(level, format string, quiet, verbose bool) (*slog.Logger, error) {
+	if quiet && verbose {
+		return nil, errors.New("-quiet can't be used with -verbose")
+	}
+
+	var lvl slog.Level
+
+	switch {
+	case quiet:
+		lvl = slog.LevelError
+	case verbose:
+		lvl = slog.LevelDebug
+	case level == "":
+		lvl = defaultLogLevel()
+	default:
+		err := lvl.UnmarshalText([]byte(level))
+		if err != nil {
+			return nil, fmt.Errorf("-log-level must be error, warn, info or debug, got %s", level)
+		}
+	}
+
+	switch format {
+	case "text":
+		return slog.New(jsplit.NewMessageHandler(nil, lvl)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(stdoutWriter{}, &slog.HandlerOptions{Level: lvl})), nil
+	}
+
+	return nil, fmt.Errorf("-log-format must be text or json, got %s", format)
+}
+
+// defaultLogLevel returns slog.LevelInfo when standard output is a terminal, and slog.LevelWarn when it is redirected
+func defaultLogLevel() slog.Level {
+	fi, err := os.Stdout.Stat()
+	if err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		return slog.LevelInfo
+	}
+
+	return slog.LevelWarn
+}
+
+// defaultProgress returns jsplit.DefaultProgressInterval when standard error is a terminal, and 0, printing no
+// progress, when it is redirected
+func defaultProgress() time.Duration {
+	fi, err := os.Stderr.Stat()
+	if err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		return jsplit.DefaultProgressInterval
+	}
+
+	return 0
+}
+
+// stdoutWriter writes to os.Stdout as it is at the time of each write
+type stdoutWriter struct{}
+
+func (stdoutWriter) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// autoBufferLimit is the largest chunks -read-buffer-size auto reads the input in
+const autoBufferLimit = 16 * 1024 * 1024
+
+// parseReadBufferSize returns the option setting the size of the chunks the input is read in for a -read-buffer-size
+// value, which is a number of bytes or auto, or nil when it isn't set
+func parseReadBufferSize(value string) (jsplit.AsyncReaderOption, error) {
+	switch value {
+	case "":
+		return nil, nil
+	case "auto":
+		return jsplit.WithAutoBufferSize(autoBufferLimit), nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("invalid -read-buffer-size %q, use a number of bytes or auto", value)
+	}
+
+	return jsplit.WithReadBufferSize(size), nil
+}
+
+// parseMode returns the permissions for the value of the flag with the given name, which is an octal mode such as 0640
+// or 2750, or 0 when it isn't set
+func parseMode(name, value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(strings.TrimPrefix(value, "0o"), 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, fmt.Errorf("invalid -%s %q, use an octal mode such as 0640", name, value)
+	}
+
+	perm := os.FileMode(mode) & os.ModePerm
+
+	for bit, special := range map[uint64]os.FileMode{0o4000: os.ModeSetuid, 0o2000: os.ModeSetgid, 0o1000: os.ModeSticky} {
+		if mode&bit != 0 {
+			perm |= special
+		}
+	}
+
+	return perm, nil
+}
+
+// readUniformFields reads the fields of each list of a uniform schema from the json object in the local file at path,
+// e.g. {"users": ["id", "name"]}, returning nil when path is empty
+func readUniformFields(path string) (map[string][]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -uniform-schema-file: %w", err)
+	}
+
+	var fields map[string][]string
+
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -uniform-schema-file %s: %w", path, err)
+	}
+
+	return fields, nil
+}
+
+// parseCsvDelimiter returns the rune given as the -csv-delimiter flag, a single character or tab
+func parseCsvDelimiter(value string) (rune, error) {
+	if value == "tab" || value == `\t` {
+		return '\t', nil
+	}
+
+	delimiter, size := utf8.DecodeRuneInString(value)
+	if value == "" || size != len(value) || delimiter == utf8.RuneError {
+		return 0, fmt.Errorf("invalid -csv-delimiter %q, use a single character or tab", value)
+	}
+
+	return delimiter, nil
+}
+
+// parseKeyPattern compiles the regular expression value of the named flag matching keys, returning nil when it is empty
+func parseKeyPattern(name, value string) (*regexp.Regexp, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	pattern, err := regexp.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s %q: %w", name, value, err)
+	}
+
+	return pattern, nil
+}
+
+// parseSince returns the Options.SinceField and Options.Since for a -since value, which is a field and a timestamp
+// separated by =, e.g. updated_at=2024-05-01, or an empty field when it isn't set
+func parseSince(value string) (string, time.Time, error) {
+	if value == "" {
+		return "", time.Time{}, nil
+	}
+
+	field, cutoff, ok := strings.Cut(value, "=")
+	if !ok || field == "" {
+		return "", time.Time{}, fmt.Errorf("invalid -since %q, use field=timestamp, e.g. updated_at=2024-05-01", value)
+	}
+
+	ts, err := jsplit.ParseTimestamp(cutoff)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid -since %q: %w", value, err)
+	}
+
+	return field, ts, nil
+}
+
+// parseGzipLevel returns the Options.GzipLevel for a -gzip-level value, which is a level from 1 to 9, one of the names
+// of the gzip constants, or -1 or -2 for gzip.DefaultCompression and gzip.HuffmanOnly
+func parseGzipLevel(value string) (int, error) {
+	switch value {
+	case "default":
+		return 0, nil
+	case "best-speed":
+		return gzip.BestSpeed, nil
+	case "best-compression":
+		return gzip.BestCompression, nil
+	case "huffman-only":
+		return gzip.HuffmanOnly, nil
+	}
+
+	level, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid -gzip-level %q, use 1-9, default, best-speed, best-compression or huffman-only",
+			value)
+	}
+
+	switch level {
+	case gzip.NoCompression:
+		return 0, fmt.Errorf("-gzip-level 0 wouldn't compress the files, leave out -compress-output instead")
+	case gzip.DefaultCompression:
+		return 0, nil
+	}
+
+	return level, nil
+}
+
+// formatFlag is the value of the -format flag, which sets the format lists are written in, or when given as
+// key=format the format of the lists of one key, and can be repeated to set the formats of several keys
+type formatFlag struct {
+	format string
+	keys   map[string]jsplit.Format
+}
+
+func (ff *formatFlag) String() string {
+	if ff == nil {
+		return ""
+	}
+
+	return ff.format
+}
+
+func (ff *formatFlag) Set(value string) error {
+	key, format, ok := strings.Cut(value, "=")
+	if !ok {
+		ff.format = value
+		return nil
+	}
+
+	if key == "" {
+		return fmt.Errorf("expected key=format, got %s", value)
+	}
+
+	if ff.keys == nil {
+		ff.keys = make(map[string]jsplit.Format)
+	}
+
+	ff.keys[key] = jsplit.Format(format)
+
+	return nil
+}
+
+// writes reports whether any lists are written in the format
+func (ff *formatFlag) writes(format jsplit.Format) bool {
+	if jsplit.Format(ff.format) == format {
+		return true
+	}
+
+	for _, f := range ff.keys {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// routeFlag is the value of the -route flag, which can be repeated to route the lists of several key patterns to
+// directories, the first route a key matches applying
+type routeFlag []jsplit.KeyRoute
+
+func (rf *routeFlag) String() string {
+	if rf == nil {
+		return ""
+	}
+
+	routes := make([]string, len(*rf))
+	for i, route := range *rf {
+		routes[i] = route.Pattern + "=" + route.Dir
+	}
+
+	return strings.Join(routes, ",")
+}
+
+func (rf *routeFlag) Set(value string) error {
+	route, err := jsplit.ParseKeyRoute(value)
+	if err != nil {
+		return err
+	}
+
+	*rf = append(*rf, route)
+
+	return nil
+}
+
+// splitList splits a comma separated flag value into its elements, returning nil if it is empty
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	elems := strings.Split(value, ",")
+	for i, elem := range elems {
+		elems[i] = strings.TrimSpace(elem)
+	}
+
+	return elems
+}
+
+// peekFile prints the structure of the json file, just the number of records in each of its lists when countOnly is
+// set, or the number of distinct values of the fields of their records when opts.DistinctFields are given
+func peekFile(filename string, opts jsplit.PeekOptions, countOnly bool, readerOpts []jsplit.AsyncReaderOption) error {
+	rd, err := jsplit.AsyncReaderFromFile(filename, 1024*1024, readerOpts...)
+	if err != nil {
+		return err
+	}
+
+	defer rd.Close()
+
+	ctx := rd.Start(context.Background())
+
+	summary, err := jsplit.Peek(ctx, rd, opts)
+	if err != nil {
+		return err
+	}
+
+	if len(opts.DistinctFields) > 0 {
+		return summary.PrintDistinct(os.Stdout)
+	}
+
+	if countOnly {
+		return summary.PrintCounts(os.Stdout)
+	}
+
+	return summary.Print(os.Stdout)
+}
+
+// validateFile checks that the json file is well-formed
+func validateFile(filename string, opts jsplit.ValidateOptions, readerOpts []jsplit.AsyncReaderOption) error {
+	rd, err := jsplit.AsyncReaderFromFile(filename, 1024*1024, readerOpts...)
+	if err != nil {
+		return err
+	}
+
+	defer rd.Close()
+
+	return jsplit.Validate(rd.Start(context.Background()), rd, opts)
+}
+
+// streamFile writes the items of the single list split from the json file to standard output. Everything else printed
+// from then on, including errors, is written to standard error instead, so that only items reach the pipe.
+func streamFile(ctx context.Context, filename string, opts jsplit.Options) error {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	return splitFileTo(ctx, filename, jsplit.NewStreamSink(out), opts)
+}
+
+// mergeFile writes the items of every list of the file to the file at path, or to standard output when it is -, as one
+// stream of ndjson led by the keys of their lists
+func mergeFile(ctx context.Context, filename, path string, opts jsplit.Options) error {
+	if path == "-" {
+		out := os.Stdout
+		os.Stdout = os.Stderr
+
+		return splitFileTo(ctx, filename, jsplit.NewMergeSink(out), opts)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if opts.FileMode != 0 {
+		err = f.Chmod(opts.FileMode)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	wr := bufio.NewWriterSize(f, 1024*1024)
+
+	err = splitFileTo(ctx, filename, jsplit.NewMergeSink(wr), opts)
+	if err == nil {
+		err = wr.Flush()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// splitFileTo splits the file into the sink
+func splitFileTo(ctx context.Context, filename string, sink jsplit.OutputSink, opts jsplit.Options) error {
+	rd, err := jsplit.AsyncReaderFromFile(filename, 1024*1024, opts.ReaderOptions...)
+	if err != nil {
+		return err
+	}
+
+	defer rd.Close()
+
+	return jsplit.Split(ctx, rd, sink, opts)
 }
@@ -0,0 +1,248 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/azureblob"
+)
+
+// The environment variables Azure Blob Storage credentials are read from, as the Azure SDKs and CLI read them.
+// AzureConnectionStringEnv takes precedence over the others for az:// URIs, and AzureClientIDEnv picks the user
+// assigned managed identity DefaultAzureCredential uses.
+const (
+	AzureConnectionStringEnv = "AZURE_STORAGE_CONNECTION_STRING"
+	AzureAccountEnv          = "AZURE_STORAGE_ACCOUNT"
+	AzureKeyEnv              = "AZURE_STORAGE_KEY"
+	AzureSASTokenEnv         = "AZURE_STORAGE_SAS_TOKEN"
+	AzureClientIDEnv         = "AZURE_CLIENT_ID"
+)
+
+// AzureBlob is the location of a blob in Azure Blob Storage
+type AzureBlob struct {
+	Account   string // the storage account, empty for az:// URIs, whose account is configured separately
+	Container string
+	Name      string
+	SAS       string // the shared access signature query of an https URL, if it has one
+
+	endpoint string // the https endpoint of the account's blob service, e.g. https://account.blob.core.windows.net
+}
+
+// IsAzureBlobURI reports whether uri is an az://container/blob URI or the https URL of a blob, e.g.
+// https://account.blob.core.windows.net/container/blob
+func IsAzureBlobURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+
+	switch u.Scheme {
+	case "az":
+		return true
+	case "https":
+		return strings.Contains(u.Host, ".blob.core.")
+	}
+
+	return false
+}
+
+// ParseAzureBlobURI parses an az://container/blob URI, or the https URL of a blob, which may carry a shared access
+// signature, e.g. https://account.blob.core.windows.net/container/blob?sv=...&sig=...
+func ParseAzureBlobURI(uri string) (*AzureBlob, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var b AzureBlob
+
+	switch {
+	case u.Scheme == "az":
+		b.Container = u.Host
+		b.Name = strings.TrimLeft(u.Path, "/")
+	case IsAzureBlobURI(uri):
+		b.Account, _, _ = strings.Cut(u.Host, ".")
+		b.Container, b.Name, _ = strings.Cut(strings.TrimLeft(u.Path, "/"), "/")
+		b.SAS = u.RawQuery
+		b.endpoint = "https://" + u.Host
+	}
+
+	if b.Container == "" || b.Name == "" {
+		return nil, fmt.Errorf("%s is not an az://container/blob URI or the https URL of an Azure blob", uri)
+	}
+
+	return &b, nil
+}
+
+// AzureError is an error response from Azure Blob Storage, along with the blob it was for
+type AzureError struct {
+	URI    string
+	Status int
+	Code   string // the x-ms-error-code of the response, e.g. BlobNotFound
+}
+
+func (ae *AzureError) Error() string {
+	switch ae.Code {
+	case "ContainerNotFound":
+		return fmt.Sprintf("the container of %s does not exist", ae.URI)
+	case "BlobNotFound":
+		return fmt.Sprintf("%s does not exist", ae.URI)
+	}
+
+	if ae.Status == http.StatusForbidden {
+		return fmt.Sprintf("access denied to %s: %s", ae.URI, ae.Code)
+	}
+
+	return fmt.Sprintf("reading %s failed: %d %s", ae.URI, ae.Status, ae.Code)
+}
+
+// NewAzureRangeReader returns a reader for length bytes of the Azure blob at uri, see ParseAzureBlobURI, starting at
+// offset, along with the number of bytes it will return. A negative length reads to the end of the blob. Blobs are
+// read as they are stored, authorized with the shared access signature of the URL, or the credentials set in the
+// environment: a connection string, an account key or SAS token, or else those found by the Azure SDK's
+// DefaultAzureCredential, such as the managed identity of the host.
+func (c *Client) NewAzureRangeReader(ctx context.Context, uri string, offset, length int64) (io.ReadCloser, int64, error) {
+	b, err := ParseAzureBlobURI(uri)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bkt, err := c.azureBucket(ctx, uri, b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r, err := bkt.NewRangeReader(ctx, b.Name, offset, length, nil)
+	if err != nil {
+		return nil, 0, describeAzureError(uri, err)
+	}
+
+	// the size of the reader is that of the whole blob
+	size := r.Size() - offset
+	if length >= 0 && length < size {
+		size = length
+	}
+
+	return r, size, nil
+}
+
+// azureBucket returns the container of the blob at uri, opening it if it hasn't been opened already
+func (c *Client) azureBucket(ctx context.Context, uri string, b *AzureBlob) (*blob.Bucket, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// containers of az:// URIs are opened with the credentials of the environment, and those of https URLs with their
+	// shared access signature
+	key := "azure:" + b.endpoint + "/" + b.Container + "?" + b.SAS
+	if bkt, ok := c.buckets[key]; ok {
+		return bkt, nil
+	}
+
+	svc, err := c.azureServiceClient(uri, b)
+	if err != nil {
+		return nil, err
+	}
+
+	bkt, err := azureblob.OpenBucket(ctx, svc, b.Container, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.buckets[key] = bkt
+
+	return bkt, nil
+}
+
+// azureServiceClient returns a client of the blob service of the account of b, authorized with the shared access
+// signature of its URL, AzureConnectionStringEnv for az:// URIs, or AzureKeyEnv or AzureSASTokenEnv, and with a
+// DefaultAzureCredential otherwise
+func (c *Client) azureServiceClient(uri string, b *AzureBlob) (*azblob.ServiceClient, error) {
+	opts := &azblob.ClientOptions{Transport: c.azureHTTPClient(), PerCallPolicies: []policy.Policy{storedBytes{}}}
+
+	if b.SAS != "" {
+		return azblob.NewServiceClientWithNoCredential(b.endpoint+"/?"+b.SAS, opts)
+	}
+
+	account, endpoint := b.Account, b.endpoint
+
+	if endpoint == "" {
+		if cs := os.Getenv(AzureConnectionStringEnv); cs != "" {
+			return azblob.NewServiceClientFromConnectionString(cs, opts)
+		}
+
+		account = os.Getenv(AzureAccountEnv)
+		if account == "" {
+			return nil, fmt.Errorf("the storage account of %s isn't known, set %s or %s", uri, AzureAccountEnv,
+				AzureConnectionStringEnv)
+		}
+
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+
+	if key := os.Getenv(AzureKeyEnv); key != "" {
+		cred, err := azblob.NewSharedKeyCredential(account, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure storage account key: %w", err)
+		}
+
+		return azblob.NewServiceClientWithSharedKey(endpoint+"/", cred, opts)
+	}
+
+	if sas := os.Getenv(AzureSASTokenEnv); sas != "" {
+		return azblob.NewServiceClientWithNoCredential(endpoint+"/?"+strings.TrimPrefix(sas, "?"), opts)
+	}
+
+	cred, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions: azcore.ClientOptions{Transport: opts.Transport},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to authorize reading %s: %w", uri, err)
+	}
+
+	return azblob.NewServiceClient(endpoint+"/", cred, opts)
+}
+
+// storedBytes is a pipeline policy asking for blobs as they are stored, so that compressed blobs aren't decompressed
+// in transit
+type storedBytes struct{}
+
+func (storedBytes) Do(req *policy.Request) (*http.Response, error) {
+	req.Raw().Header.Set("Accept-Encoding", "identity")
+	return req.Next()
+}
+
+// describeAzureError returns an *AzureError for the error responses of Azure Blob Storage, and describes the
+// credentials which failed to authorize reading uri
+func describeAzureError(uri string, err error) error {
+	var se *azblob.StorageError
+	if errors.As(err, &se) {
+		return &AzureError{URI: uri, Status: se.StatusCode(), Code: string(se.ErrorCode)}
+	}
+
+	var afe *azidentity.AuthenticationFailedError
+	if errors.As(err, &afe) {
+		return fmt.Errorf("unable to authorize reading %s: %w", uri, afe)
+	}
+
+	return fmt.Errorf("reading %s failed: %w", uri, err)
+}
+
+// azureHTTPClient returns the client requests to Azure Blob Storage are sent with
+func (c *Client) azureHTTPClient() *http.Client {
+	if c.opts != nil && c.opts.AzureHTTPClient != nil {
+		return c.opts.AzureHTTPClient
+	}
+
+	return http.DefaultClient
+}
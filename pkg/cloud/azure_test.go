@@ -0,0 +1,261 @@
+package cloud
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAzureBlobs serves the blobs of the containers of an account's blob service, as Azure Blob Storage would, as
+// they are stored.
+// authorize checks the Authorization header of each request, failing it with a 403 when it returns false.
+func fakeAzureBlobs(t *testing.T, account string, blobs map[string]string,
+	authorize func(*http.Request) bool) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// emulators take the account from the path, and Azure from the host
+		path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+account), "/")
+		container, name, _ := strings.Cut(path, "/")
+
+		switch {
+		case !authorize(r):
+			w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case r.Header.Get("x-ms-version") == "" || r.Header.Get("Accept-Encoding") != "identity":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data, ok := blobs[container+"/"+name]
+		if !ok {
+			code := "BlobNotFound"
+			if _, ok := blobs[container+"/"]; !ok {
+				code = "ContainerNotFound"
+			}
+
+			w.Header().Set("x-ms-error-code", code)
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+
+		if rng := r.Header.Get("x-ms-range"); rng != "" {
+			var start, end int
+
+			n, _ := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			if n < 2 || end >= len(data) {
+				end = len(data) - 1
+			}
+
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+		}
+
+		_, _ = w.Write([]byte(data))
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// clearAzureEnv unsets the Azure credentials of the environment for the test, which the Azure SDK tells apart from
+// empty ones
+func clearAzureEnv(t *testing.T) {
+	for _, env := range []string{AzureConnectionStringEnv, AzureAccountEnv, AzureKeyEnv, AzureSASTokenEnv,
+		AzureClientIDEnv, "AZURE_TENANT_ID", "AZURE_CLIENT_SECRET", "IDENTITY_ENDPOINT", "IDENTITY_HEADER"} {
+		t.Setenv(env, "")
+		require.NoError(t, os.Unsetenv(env))
+	}
+}
+
+func TestParseAzureBlobURI(t *testing.T) {
+	b, err := ParseAzureBlobURI("az://exports/2024/01/data.json")
+	require.NoError(t, err)
+	require.Equal(t, AzureBlob{Container: "exports", Name: "2024/01/data.json"}, *b)
+
+	b, err = ParseAzureBlobURI("https://acct.blob.core.windows.net/exports/data.json.gz?sv=2021&sig=abc")
+	require.NoError(t, err)
+	require.Equal(t, "acct", b.Account)
+	require.Equal(t, "exports", b.Container)
+	require.Equal(t, "data.json.gz", b.Name)
+	require.Equal(t, "sv=2021&sig=abc", b.SAS)
+
+	require.True(t, IsAzureBlobURI("az://exports/data.json"))
+	require.True(t, IsAzureBlobURI("https://acct.blob.core.windows.net/exports/data.json"))
+	require.False(t, IsAzureBlobURI("https://example.com/exports/data.json"))
+	require.False(t, IsAzureBlobURI("gs://exports/data.json"))
+
+	for _, uri := range []string{"az://exports", "az://exports/", "https://acct.blob.core.windows.net/exports"} {
+		_, err = ParseAzureBlobURI(uri)
+		require.ErrorContains(t, err, "is not an az://container/blob URI", uri)
+	}
+}
+
+func TestNewAzureRangeReaderSharedKey(t *testing.T) {
+	const account = "devstoreaccount1"
+
+	var denied atomic.Bool
+
+	blobs := map[string]string{"exports/": "", "exports/data/a b.json": `{"a": [1, 2]}`}
+
+	// requests are signed with the account key, see
+	// https://learn.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+	srv := fakeAzureBlobs(t, account, blobs, func(r *http.Request) bool {
+		return strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey "+account+":") && !denied.Load()
+	})
+
+	clearAzureEnv(t)
+	t.Setenv(AzureConnectionStringEnv, fmt.Sprintf("DefaultEndpointsProtocol=http;AccountName=%s;AccountKey=%s;"+
+		"BlobEndpoint=%s/%s;", account, base64.StdEncoding.EncodeToString([]byte("not a real key")), srv.URL, account))
+
+	ctx := context.Background()
+	c := NewClient(nil)
+
+	r, size, err := c.NewAzureRangeReader(ctx, "az://exports/data/a b.json", 0, -1)
+	require.NoError(t, err)
+	require.Equal(t, int64(13), size)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, `{"a": [1, 2]}`, string(data))
+	require.NoError(t, r.Close())
+
+	// ranges of the blob can be read
+	r, size, err = c.NewAzureRangeReader(ctx, "az://exports/data/a b.json", 7, 4)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), size)
+
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "1, 2", string(data))
+	require.NoError(t, r.Close())
+
+	// missing blobs and containers, and requests which aren't authorized, are described
+	_, _, err = c.NewAzureRangeReader(ctx, "az://exports/missing.json", 0, -1)
+	require.EqualError(t, err, "az://exports/missing.json does not exist")
+
+	_, _, err = c.NewAzureRangeReader(ctx, "az://imports/data.json", 0, -1)
+	require.EqualError(t, err, "the container of az://imports/data.json does not exist")
+
+	var azErr *AzureError
+
+	require.ErrorAs(t, err, &azErr)
+	require.Equal(t, http.StatusNotFound, azErr.Status)
+
+	denied.Store(true)
+	_, _, err = c.NewAzureRangeReader(ctx, "az://exports/data/a b.json", 0, -1)
+	require.EqualError(t, err, "access denied to az://exports/data/a b.json: AuthenticationFailed")
+
+	// the account of an az:// URI has to be known
+	clearAzureEnv(t)
+	_, _, err = NewClient(nil).NewAzureRangeReader(ctx, "az://exports/data.json", 0, -1)
+	require.EqualError(t, err, fmt.Sprintf("the storage account of az://exports/data.json isn't known, set %s or %s",
+		AzureAccountEnv, AzureConnectionStringEnv))
+}
+
+// rewriteHost sends requests to Azure Blob Storage to the test server instead
+type rewriteHost struct {
+	srv *httptest.Server
+}
+
+func (rh rewriteHost) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Host, ".blob.core.windows.net") {
+		u, _ := url.Parse(rh.srv.URL)
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+	}
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewAzureRangeReaderSASAndManagedIdentity(t *testing.T) {
+	const account = "acct"
+
+	blobs := map[string]string{"exports/": "", "exports/data.json": `[1, 2]`}
+
+	var tokens int32
+
+	srv := fakeAzureBlobs(t, account, blobs, func(r *http.Request) bool {
+		return r.URL.Query().Get("sig") == "secret" || r.Header.Get("Authorization") == "Bearer token-1"
+	})
+
+	// managed identity tokens are served by the App Service identity endpoint
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-IDENTITY-HEADER") != "identity-secret" ||
+			r.URL.Query().Get("resource") != "https://storage.azure.com" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		n := atomic.AddInt32(&tokens, 1)
+		_, _ = fmt.Fprintf(w, `{"access_token": "token-%d", "expires_on": "%d"}`, n, 1<<40)
+	}))
+	defer identity.Close()
+
+	clearAzureEnv(t)
+
+	ctx := context.Background()
+	c := NewClient(&ReaderOptions{AzureHTTPClient: &http.Client{Transport: rewriteHost{srv}}})
+
+	read := func(uri string) (string, error) {
+		r, _, err := c.NewAzureRangeReader(ctx, uri, 0, -1)
+		if err != nil {
+			return "", err
+		}
+
+		defer r.Close()
+
+		data, err := io.ReadAll(r)
+
+		return string(data), err
+	}
+
+	// the shared access signature of the URL, or of the environment, authorizes reads
+	data, err := read("https://acct.blob.core.windows.net/exports/data.json?sv=2021-08-06&sig=secret")
+	require.NoError(t, err)
+	require.Equal(t, `[1, 2]`, data)
+
+	t.Setenv(AzureAccountEnv, account)
+	t.Setenv(AzureSASTokenEnv, "?sv=2021-08-06&sig=secret")
+
+	data, err = read("az://exports/data.json")
+	require.NoError(t, err)
+	require.Equal(t, `[1, 2]`, data)
+
+	// and without either a managed identity's token is used, which is reused until it expires. Containers are opened
+	// once by a client, with the credentials of the environment when they are first read from.
+	require.NoError(t, os.Unsetenv(AzureSASTokenEnv))
+	t.Setenv("IDENTITY_ENDPOINT", identity.URL)
+	t.Setenv("IDENTITY_HEADER", "identity-secret")
+
+	c = NewClient(&ReaderOptions{AzureHTTPClient: &http.Client{Transport: rewriteHost{srv}}})
+
+	for i := 0; i < 2; i++ {
+		data, err = read("az://exports/data.json")
+		require.NoError(t, err)
+		require.Equal(t, `[1, 2]`, data)
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&tokens))
+
+	// a managed identity which can't be reached is reported
+	t.Setenv("IDENTITY_HEADER", "wrong")
+
+	_, _, err = NewClient(&ReaderOptions{AzureHTTPClient: &http.Client{Transport: rewriteHost{srv}}}).
+		NewAzureRangeReader(ctx, "az://exports/data.json", 0, -1)
+	require.ErrorContains(t, err, "unable to authorize reading az://exports/data.json")
+	require.ErrorContains(t, err, "401 Unauthorized")
+}
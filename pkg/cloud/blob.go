@@ -2,13 +2,18 @@ package cloud
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/url"
+	"path"
+	"sort"
 	"strings"
 
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/fileblob" // required by CDK as blob driver
 	_ "gocloud.dev/blob/gcsblob"  // required by CDK as blob driver
 	_ "gocloud.dev/blob/s3blob"   // required by CDK as blob driver
+	"gocloud.dev/gcerrors"
 )
 
 func OpenBucket(ctx context.Context, uri string) (*blob.Bucket, error) {
@@ -32,7 +37,7 @@ func NewWriter(ctx context.Context, uri string) (*blob.Writer, error) {
 		k   string
 	)
 
-	_, bkt, k, err = ParseBlobURI(uri)
+	bkt, k, err = SplitBlobURI(uri)
 	if err != nil {
 		return nil, err
 	}
@@ -44,44 +49,77 @@ func NewWriter(ctx context.Context, uri string) (*blob.Writer, error) {
 
 	w, err := b.NewWriter(ctx, k, nil)
 	if err != nil {
-		return nil, err
+		return nil, DescribeError(uri, err)
 	}
 
 	return w, nil
 }
 
-func NewReader(ctx context.Context, uri string) (*blob.Reader, error) {
-	var (
-		err error
-		bkt string
-		k   string
-	)
+// NewReader returns a reader for the object at uri, opening its bucket for just this read. Use a Client to reuse the
+// bucket for several reads. opts may be nil.
+func NewReader(ctx context.Context, uri string, opts *ReaderOptions) (*blob.Reader, error) {
+	return NewRangeReader(ctx, uri, 0, -1, opts)
+}
 
-	_, bkt, k, err = ParseBlobURI(uri)
+// NewRangeReader returns a reader for length bytes of the object at uri starting at offset. A negative length reads
+// to the end of the object. Its bucket is opened for just this read, see Client.NewRangeReader. opts may be nil.
+func NewRangeReader(ctx context.Context, uri string, offset, length int64, opts *ReaderOptions) (*blob.Reader, error) {
+	return NewClient(opts).NewRangeReader(ctx, uri, offset, length)
+}
+
+func ParseBlobURI(uri string) (scheme, bucket, key string, err error) {
+	u, err := url.Parse(uri)
 	if err != nil {
-		return nil, err
+		return "", "", "", err
 	}
 
-	b, err := OpenBucket(ctx, bkt)
+	return u.Scheme, u.Host, strings.TrimLeft(u.Path, "/"), nil
+}
+
+// SplitBlobURI splits a blob URI into the URL used to open its bucket and the key of the object within the bucket.
+// Query parameters are kept on the bucket URL as that is where the CDK drivers read their options from. file:// URIs
+// are split into their directory and file name.
+func SplitBlobURI(uri string) (bucketURL, key string, err error) {
+	u, err := url.Parse(uri)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
-	r, err := b.NewReader(ctx, k, nil)
-	if err != nil {
-		return nil, err
+	if u.Scheme == "" {
+		return "", "", fmt.Errorf("%s is not a blob URI", uri)
+	}
+
+	bucket := u.Host
+	key = strings.TrimLeft(u.Path, "/")
+
+	if u.Scheme == "file" {
+		bucket, key = path.Split(u.Path)
 	}
 
-	return r, nil
+	bucketURL = u.Scheme + "://" + bucket
+	if u.RawQuery != "" {
+		bucketURL += "?" + u.RawQuery
+	}
+
+	return bucketURL, key, nil
 }
 
-func ParseBlobURI(uri string) (scheme, bucket, key string, err error) {
-	u, err := url.Parse(uri)
-	if err != nil {
-		return "", "", "", err
+// DescribeError replaces the errors returned by the CDK for missing objects, failed permission checks and reads from
+// requester pays buckets without a user project with errors naming the object being accessed.  The original error is
+// wrapped.
+func DescribeError(uri string, err error) error {
+	if isUserProjectMissing(err) {
+		return fmt.Errorf("%s is in a requester pays bucket, set a user project to bill with %s: %w", uri, GCSUserProjectEnv, err)
 	}
 
-	return u.Scheme, u.Host, strings.TrimLeft(u.Path, "/"), nil
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		return fmt.Errorf("%s does not exist: %w", uri, err)
+	case gcerrors.PermissionDenied:
+		return fmt.Errorf("access denied to %s: %w", uri, err)
+	default:
+		return err
+	}
 }
 
 func IsCloudURI(uri string) bool {
@@ -92,3 +130,103 @@ func IsCloudURI(uri string) bool {
 
 	return s != ""
 }
+
+// IsPattern reports whether uri names a set of objects rather than a single object, either with an empty key or one
+// ending in / to select every object under a prefix, or with a key containing the glob characters understood by
+// path.Match
+func IsPattern(uri string) bool {
+	_, key, err := SplitBlobURI(uri)
+	if err != nil {
+		return false
+	}
+
+	return key == "" || strings.HasSuffix(key, "/") || strings.ContainsAny(key, globChars)
+}
+
+// globChars are the characters which make a key a pattern for path.Match
+const globChars = "*?[\\"
+
+// ListObjects returns the URIs of the objects matching the pattern uri, sorted lexicographically, opening the bucket
+// for just this listing, see Client.ListObjects. opts may be nil.
+func ListObjects(ctx context.Context, uri string, opts *ReaderOptions) ([]string, error) {
+	return NewClient(opts).ListObjects(ctx, uri)
+}
+
+// ListObjects returns the URIs of the objects matching the pattern uri, sorted lexicographically. Objects are listed
+// using the part of the key before the first glob character as a prefix and then matched against the whole key with
+// path.Match, so * does not match across / separators. As ? starts the query of a URI it can't be used as a glob
+// character.
+func (c *Client) ListObjects(ctx context.Context, uri string) ([]string, error) {
+	bkt, pattern, err := SplitBlobURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// path.Match only reports malformed patterns when it gets far enough to see them
+	if _, err = path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", uri, err)
+	}
+
+	b, err := c.bucket(ctx, bkt)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := pattern
+	if i := strings.IndexAny(pattern, globChars); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	var uris []string
+
+	it := b.List(&blob.ListOptions{Prefix: prefix})
+
+	for {
+		obj, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, DescribeError(uri, err)
+		}
+
+		if obj.IsDir {
+			continue
+		}
+
+		if prefix != pattern {
+			if matched, _ := path.Match(pattern, obj.Key); !matched {
+				continue
+			}
+		}
+
+		uris = append(uris, objectURI(u, obj.Key))
+	}
+
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("no objects match %s", uri)
+	}
+
+	sort.Strings(uris)
+
+	return uris, nil
+}
+
+// objectURI returns the URI of the object with the given key in the bucket of the pattern URI u
+func objectURI(u *url.URL, key string) string {
+	obj := *u
+
+	if obj.Scheme == "file" {
+		obj.Path = path.Join(path.Dir(u.Path), key)
+	} else {
+		obj.Path = "/" + key
+	}
+
+	return obj.String()
+}
@@ -1,9 +1,14 @@
 package cloud
 
 import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gocloud.dev/gcerrors"
 )
 
 func TestIsCloudURITrue(t *testing.T) {
@@ -47,3 +52,97 @@ func TestParseBlobUriFS(t *testing.T) {
 	require.Equal(t, expectedBucket, actualBucket)
 	require.Equal(t, expectedKey, actualKey)
 }
+
+func TestSplitBlobURI(t *testing.T) {
+	tests := []struct {
+		uri               string
+		expectedBucketURL string
+		expectedKey       string
+	}{
+		{
+			uri:               "gs://bucket/path/to/file.json",
+			expectedBucketURL: "gs://bucket",
+			expectedKey:       "path/to/file.json",
+		},
+		{
+			uri:               "s3://bucket/file.json?awssdk=v2&region=us-west-2",
+			expectedBucketURL: "s3://bucket?awssdk=v2&region=us-west-2",
+			expectedKey:       "file.json",
+		},
+		{
+			uri:               "file:///path/to/file.json",
+			expectedBucketURL: "file:///path/to/",
+			expectedKey:       "file.json",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.uri, func(t *testing.T) {
+			bucketURL, key, err := SplitBlobURI(test.uri)
+			require.NoError(t, err)
+			require.Equal(t, test.expectedBucketURL, bucketURL)
+			require.Equal(t, test.expectedKey, key)
+		})
+	}
+
+	_, _, err := SplitBlobURI("/path/to/file.json")
+	require.Error(t, err)
+}
+
+func TestNewReaderMissingObject(t *testing.T) {
+	uri := "file://" + filepath.ToSlash(t.TempDir()) + "/missing.json"
+
+	_, err := NewReader(context.Background(), uri, nil)
+	require.Error(t, err)
+	require.Equal(t, gcerrors.NotFound, gcerrors.Code(err))
+	require.Contains(t, err.Error(), uri+" does not exist")
+}
+
+func TestNewRangeReader(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.json"), []byte("0123456789"), 0o644))
+
+	r, err := NewRangeReader(context.Background(), "file://"+filepath.ToSlash(dir)+"/test.json", 4, -1, nil)
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "456789", string(data))
+}
+
+func TestIsPattern(t *testing.T) {
+	require.True(t, IsPattern("gs://bucket/exports/"))
+	require.True(t, IsPattern("gs://bucket/exports/part-*.json.gz"))
+	require.True(t, IsPattern("s3://bucket/part-[0-9].json?awssdk=v2"))
+	require.True(t, IsPattern("file:///tmp/dir/"))
+	require.False(t, IsPattern("gs://bucket/exports/part-0.json.gz"))
+	require.False(t, IsPattern("s3://bucket/part.json?awssdk=v2"))
+}
+
+func TestListObjects(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	for _, name := range []string{"part-10.json", "part-02.json", "part-01.json", "other.json"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0o600))
+	}
+
+	base := "file://" + filepath.ToSlash(dir)
+
+	uris, err := ListObjects(ctx, base+"/part-*.json", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{base + "/part-01.json", base + "/part-02.json", base + "/part-10.json"}, uris)
+
+	uris, err = ListObjects(ctx, base+"/", nil)
+	require.NoError(t, err)
+	require.Len(t, uris, 4)
+	require.Equal(t, base+"/other.json", uris[0])
+
+	_, err = ListObjects(ctx, base+"/missing-*.json", nil)
+	require.ErrorContains(t, err, "no objects match")
+
+	_, err = ListObjects(ctx, base+"/part-[.json", nil)
+	require.ErrorContains(t, err, "invalid pattern")
+}
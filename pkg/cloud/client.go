@@ -0,0 +1,134 @@
+package cloud
+
+import (
+	"context"
+	"sync"
+
+	"gocloud.dev/blob"
+)
+
+// Client reads objects from cloud storage, opening each bucket once and reusing it, along with its connections, for
+// every object read from the bucket. Clients are safe for concurrent use, and the buckets they opened are closed along
+// with them, so a Client must only be closed once the readers it returned have been closed.
+type Client struct {
+	opts    *ReaderOptions
+	open    BucketOpener
+	mu      sync.Mutex
+	buckets map[string]*blob.Bucket
+}
+
+// BucketOpener opens the bucket at bucketURL, e.g. gs://bucket, for a Client
+type BucketOpener func(ctx context.Context, bucketURL string) (*blob.Bucket, error)
+
+// NewClient returns a *Client opening buckets with the credentials and user project set in opts, which may be nil
+func NewClient(opts *ReaderOptions) *Client {
+	return NewClientWithOpener(opts, opts.openBucket)
+}
+
+// NewClientWithOpener returns a *Client opening buckets with open rather than the CDK's drivers, e.g. to read objects
+// from in-memory buckets created with memblob.OpenBucket in place of Google Cloud Storage or S3 in tests. A user
+// project, set in opts, which may be nil, or with GCSUserProjectEnv, can only be billed for gs:// buckets opened with
+// gcsblob.
+func NewClientWithOpener(opts *ReaderOptions, open BucketOpener) *Client {
+	return &Client{opts: opts, open: open, buckets: make(map[string]*blob.Bucket)}
+}
+
+// bucket returns the bucket at bucketURL, opening it if it hasn't been opened already
+func (c *Client) bucket(ctx context.Context, bucketURL string) (*blob.Bucket, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if b, ok := c.buckets[bucketURL]; ok {
+		return b, nil
+	}
+
+	b, err := c.open(ctx, bucketURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c.buckets[bucketURL] = b
+
+	return b, nil
+}
+
+// NewReader returns a reader for the object at uri
+func (c *Client) NewReader(ctx context.Context, uri string) (*blob.Reader, error) {
+	return c.NewRangeReader(ctx, uri, 0, -1)
+}
+
+// NewRangeReader returns a reader for length bytes of the object at uri starting at offset. A negative length reads
+// to the end of the object.
+func (c *Client) NewRangeReader(ctx context.Context, uri string, offset, length int64) (*blob.Reader, error) {
+	return c.newRangeReader(ctx, uri, offset, length, false)
+}
+
+// NewRawRangeReader is NewRangeReader reading the bytes of the object as they are stored. Google Cloud Storage otherwise
+// decompresses objects stored with a Content-Encoding of gzip as they are read, whatever the range asked for, so that
+// offsets into what is read don't match the object's size, and the reader can't resume from them.
+func (c *Client) NewRawRangeReader(ctx context.Context, uri string, offset, length int64) (*blob.Reader, error) {
+	return c.newRangeReader(ctx, uri, offset, length, true)
+}
+
+func (c *Client) newRangeReader(ctx context.Context, uri string, offset, length int64, raw bool) (*blob.Reader, error) {
+	bkt, k, err := SplitBlobURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.bucket(ctx, bkt)
+	if err != nil {
+		return nil, err
+	}
+
+	ro, err := c.opts.readerOptions(b, bkt, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := b.NewRangeReader(ctx, k, offset, length, ro)
+	if err != nil {
+		return nil, DescribeError(uri, err)
+	}
+
+	return r, nil
+}
+
+// Attributes returns the metadata of the object at uri, such as its size, Content-Encoding and Content-Type
+func (c *Client) Attributes(ctx context.Context, uri string) (*blob.Attributes, error) {
+	bkt, k, err := SplitBlobURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.bucket(ctx, bkt)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := b.Attributes(ctx, k)
+	if err != nil {
+		return nil, DescribeError(uri, err)
+	}
+
+	return attrs, nil
+}
+
+// Close closes every bucket the Client has opened, returning the first error
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+
+	for bucketURL, b := range c.buckets {
+		err := b.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		delete(c.buckets, bucketURL)
+	}
+
+	return firstErr
+}
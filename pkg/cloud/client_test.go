@@ -0,0 +1,165 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcp"
+)
+
+func TestClientReusesBuckets(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	for _, name := range []string{"part-01.json", "part-02.json", "part-03.json"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600))
+	}
+
+	c := NewClient(nil)
+
+	var opens int32
+
+	open := c.open
+	c.open = func(ctx context.Context, bucketURL string) (*blob.Bucket, error) {
+		atomic.AddInt32(&opens, 1)
+		return open(ctx, bucketURL)
+	}
+
+	uris, err := c.ListObjects(ctx, "file://"+filepath.ToSlash(dir)+"/part-*.json")
+	require.NoError(t, err)
+	require.Len(t, uris, 3)
+
+	for _, uri := range uris {
+		r, err := c.NewReader(ctx, uri)
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Base(uri), string(data))
+		require.NoError(t, r.Close())
+	}
+
+	// the bucket is opened once for the listing and every read
+	require.Equal(t, int32(1), atomic.LoadInt32(&opens))
+
+	require.NoError(t, c.Close())
+	require.Empty(t, c.buckets)
+
+	_, err = c.NewReader(ctx, uris[0])
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&opens))
+}
+
+// fakeGCS serves the objects it holds to GET requests for https://storage.googleapis.com/[bucket]/[key]
+type fakeGCS struct {
+	objects  map[string]string
+	requests int32
+}
+
+func (f *fakeGCS) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&f.requests, 1)
+
+	obj, ok := f.objects[req.URL.Path]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewReader(nil)),
+			Header: http.Header{}, Request: req}, nil
+	}
+
+	header := http.Header{}
+	header.Set("Content-Length", strconv.Itoa(len(obj)))
+	header.Set("Content-Type", "application/json")
+
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader([]byte(obj))),
+		ContentLength: int64(len(obj)), Header: header, Request: req}, nil
+}
+
+func TestClientGCSHTTPClient(t *testing.T) {
+	fake := &fakeGCS{objects: map[string]string{"/bucket/data.json": `{"a": 1}`}}
+	c := NewClient(&ReaderOptions{GCSHTTPClient: &gcp.HTTPClient{Client: http.Client{Transport: fake}}})
+
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		r, err := c.NewReader(context.Background(), "gs://bucket/data.json")
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, `{"a": 1}`, string(data))
+		require.NoError(t, r.Close())
+	}
+
+	require.Equal(t, int32(2), atomic.LoadInt32(&fake.requests))
+	require.Len(t, c.buckets, 1)
+}
+
+func TestNewClientWithOpener(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv(GCSUserProjectEnv, "")
+
+	mem := memblob.OpenBucket(nil)
+	require.NoError(t, mem.WriteAll(ctx, "data.json", []byte(`{"a": 1}`), &blob.WriterOptions{ContentEncoding: "identity"}))
+
+	var opened []string
+
+	c := NewClientWithOpener(nil, func(ctx context.Context, bucketURL string) (*blob.Bucket, error) {
+		opened = append(opened, bucketURL)
+
+		if bucketURL != "gs://bucket" {
+			return nil, fmt.Errorf("no bucket %s", bucketURL)
+		}
+
+		return mem, nil
+	})
+
+	defer c.Close()
+
+	r, err := c.NewReader(ctx, "gs://bucket/data.json")
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, `{"a": 1}`, string(data))
+	require.NoError(t, r.Close())
+
+	// raw reads of buckets which aren't Google Cloud Storage read the objects as they are, and their metadata is read
+	r, err = c.NewRawRangeReader(ctx, "gs://bucket/data.json", 1, -1)
+	require.NoError(t, err)
+
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, `"a": 1}`, string(data))
+	require.NoError(t, r.Close())
+
+	attrs, err := c.Attributes(ctx, "gs://bucket/data.json")
+	require.NoError(t, err)
+	require.Equal(t, "identity", attrs.ContentEncoding)
+	require.Equal(t, int64(8), attrs.Size)
+
+	_, err = c.Attributes(ctx, "gs://bucket/missing.json")
+	require.ErrorContains(t, err, "gs://bucket/missing.json does not exist")
+
+	uris, err := c.ListObjects(ctx, "gs://bucket/*.json")
+	require.NoError(t, err)
+	require.Equal(t, []string{"gs://bucket/data.json"}, uris)
+
+	// missing objects are described, and the opener's errors are returned as they are
+	_, err = c.NewReader(ctx, "gs://bucket/missing.json")
+	require.ErrorContains(t, err, "gs://bucket/missing.json does not exist")
+
+	_, err = c.NewReader(ctx, "s3://other/data.json")
+	require.EqualError(t, err, "no bucket s3://other")
+
+	require.Equal(t, []string{"gs://bucket", "s3://other"}, opened)
+}
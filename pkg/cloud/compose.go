@@ -0,0 +1,264 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// maxComposeSources is the number of objects Google Cloud Storage composes into one in a single request
+const maxComposeSources = 32
+
+// composeFunc composes the objects srcs of a bucket, in order, into the object dst, which may be one of srcs
+type composeFunc func(ctx context.Context, dst string, srcs []string) error
+
+// ComposedWriter uploads an object to Google Cloud Storage as a series of parts, each an object of its own written
+// with a separate upload, which are composed into the object once the writer is closed. Each upload is then only as
+// long as a part, so that writing a large object over hours doesn't rest on a single upload, which has to start over
+// from the beginning when it fails. The parts are deleted once they have been composed, or if the upload fails or is
+// cancelled, in which case no object is left holding part of what was written.
+type ComposedWriter struct {
+	ctx      context.Context
+	b        *blob.Bucket
+	uri      string
+	key      string
+	partSize int64
+	compose  composeFunc
+	closeB   bool // whether b is closed along with the writer, having been opened for it
+
+	parts   []string     // the keys of the parts uploaded so far, including the one being written
+	part    *blob.Writer // the part being written, if any
+	written int64        // the number of bytes written to the part
+	err     error        // the first error writing a part, after which the object can't be composed
+}
+
+// NewComposedWriter returns a writer uploading the Google Cloud Storage object at uri in parts of partSize bytes,
+// which must be positive, see ComposedWriter. The parts are named after the object, e.g. <key>.part-00001, and
+// written alongside it.
+func NewComposedWriter(ctx context.Context, uri string, partSize int64) (*ComposedWriter, error) {
+	if partSize <= 0 {
+		return nil, fmt.Errorf("compose part size must be positive, got %d", partSize)
+	}
+
+	if !strings.HasPrefix(uri, "gs://") {
+		return nil, fmt.Errorf("%s is not a Google Cloud Storage object, only they can be composed", uri)
+	}
+
+	bkt, key, err := SplitBlobURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	_, name, _, err := ParseBlobURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := OpenBucket(ctx, bkt)
+	if err != nil {
+		return nil, err
+	}
+
+	var client *storage.Client
+	if !b.As(&client) {
+		_ = b.Close()
+		return nil, fmt.Errorf("%s is not a Google Cloud Storage bucket", bkt)
+	}
+
+	cw := newComposedWriter(ctx, b, uri, key, partSize, gcsCompose(client.Bucket(name)))
+	cw.closeB = true
+
+	return cw, nil
+}
+
+// newComposedWriter returns a writer uploading the object with the given key to b in parts of partSize bytes, which
+// are composed with compose
+func newComposedWriter(ctx context.Context, b *blob.Bucket, uri, key string, partSize int64,
+	compose composeFunc,
+) *ComposedWriter {
+	return &ComposedWriter{ctx: ctx, b: b, uri: uri, key: key, partSize: partSize, compose: compose}
+}
+
+// gcsCompose returns a composeFunc composing the objects of bkt with a storage.Composer
+func gcsCompose(bkt *storage.BucketHandle) composeFunc {
+	return func(ctx context.Context, dst string, srcs []string) error {
+		objs := make([]*storage.ObjectHandle, len(srcs))
+		for i, src := range srcs {
+			objs[i] = bkt.Object(src)
+		}
+
+		_, err := bkt.Object(dst).ComposerFrom(objs...).Run(ctx)
+
+		return err
+	}
+}
+
+// Write writes p to the parts of the object, starting a new part each time the one being written reaches the part
+// size
+func (cw *ComposedWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+
+	var written int
+
+	for len(p) > 0 {
+		if cw.part == nil {
+			cw.err = cw.startPart()
+			if cw.err != nil {
+				return written, cw.err
+			}
+		}
+
+		n := int64(len(p))
+		if n > cw.partSize-cw.written {
+			n = cw.partSize - cw.written
+		}
+
+		var wn int
+
+		wn, cw.err = cw.part.Write(p[:n])
+		written += wn
+		cw.written += int64(wn)
+
+		if cw.err != nil {
+			cw.err = DescribeError(cw.uri, cw.err)
+			return written, cw.err
+		}
+
+		if cw.written == cw.partSize {
+			cw.err = cw.endPart()
+			if cw.err != nil {
+				return written, cw.err
+			}
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// startPart starts the upload of the next part
+func (cw *ComposedWriter) startPart() error {
+	key := fmt.Sprintf("%s.part-%05d", cw.key, len(cw.parts)+1)
+	cw.parts = append(cw.parts, key)
+
+	w, err := cw.b.NewWriter(cw.ctx, key, nil)
+	if err != nil {
+		return DescribeError(cw.uri, err)
+	}
+
+	cw.part = w
+	cw.written = 0
+
+	return nil
+}
+
+// endPart finishes the upload of the part being written
+func (cw *ComposedWriter) endPart() error {
+	w := cw.part
+	cw.part = nil
+
+	err := w.Close()
+	if err != nil {
+		return DescribeError(cw.uri, err)
+	}
+
+	return nil
+}
+
+// Close finishes the upload of the last part and composes the parts into the object, deleting them afterwards. The
+// parts are composed maxComposeSources at a time, each request after the first appending them to the object composed
+// so far. An object which nothing was written to is uploaded empty.
+func (cw *ComposedWriter) Close() error {
+	err := cw.err
+	if err == nil && cw.part != nil {
+		err = cw.endPart()
+	} else if cw.part != nil {
+		_ = cw.part.Close()
+	}
+
+	if err == nil {
+		err = cw.composeParts()
+	}
+
+	// parts are deleted after a failure too, even once the context has been cancelled
+	deleteErr := cw.deleteParts(context.WithoutCancel(cw.ctx))
+	if err == nil && deleteErr != nil {
+		err = fmt.Errorf("%s was composed but its parts couldn't all be deleted: %w", cw.uri, deleteErr)
+	}
+
+	if cw.closeB {
+		err = errors.Join(err, cw.b.Close())
+	}
+
+	return err
+}
+
+// composeParts composes the uploaded parts into the object
+func (cw *ComposedWriter) composeParts() error {
+	if len(cw.parts) == 0 {
+		err := cw.b.WriteAll(cw.ctx, cw.key, nil, nil)
+		if err != nil {
+			return DescribeError(cw.uri, err)
+		}
+
+		return nil
+	}
+
+	for i := 0; i < len(cw.parts); {
+		var srcs []string
+		if i > 0 {
+			srcs = append(srcs, cw.key)
+		}
+
+		end := i + maxComposeSources - len(srcs)
+		if end > len(cw.parts) {
+			end = len(cw.parts)
+		}
+
+		srcs = append(srcs, cw.parts[i:end]...)
+
+		err := cw.compose(cw.ctx, cw.key, srcs)
+		if err != nil {
+			err = fmt.Errorf("unable to compose %s: %w", cw.uri, DescribeError(cw.uri, err))
+
+			// the object composed from the first parts is deleted too, even once the context has been cancelled, as
+			// it only holds part of what was written
+			if i > 0 {
+				deleteErr := cw.b.Delete(context.WithoutCancel(cw.ctx), cw.key)
+				if deleteErr != nil && gcerrors.Code(deleteErr) != gcerrors.NotFound {
+					err = errors.Join(err, fmt.Errorf("%s holds part of what was written: %w", cw.uri, deleteErr))
+				}
+			}
+
+			return err
+		}
+
+		i = end
+	}
+
+	return nil
+}
+
+// deleteParts deletes the parts which were uploaded, ignoring those which don't exist as their upload failed
+func (cw *ComposedWriter) deleteParts(ctx context.Context) error {
+	var errs []error
+
+	for _, key := range cw.parts {
+		err := cw.b.Delete(ctx, key)
+		if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			errs = append(errs, err)
+		}
+	}
+
+	cw.parts = nil
+
+	return errors.Join(errs...)
+}
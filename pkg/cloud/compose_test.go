@@ -0,0 +1,204 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+	"google.golang.org/api/option"
+)
+
+// concatCompose returns a composeFunc concatenating the objects of b, recording the sources of each call
+func concatCompose(b *blob.Bucket, calls *[][]string) composeFunc {
+	return func(ctx context.Context, dst string, srcs []string) error {
+		*calls = append(*calls, srcs)
+
+		var data []byte
+
+		for _, src := range srcs {
+			part, err := b.ReadAll(ctx, src)
+			if err != nil {
+				return err
+			}
+
+			data = append(data, part...)
+		}
+
+		return b.WriteAll(ctx, dst, data, nil)
+	}
+}
+
+// keys returns the keys of the objects in b
+func keys(t *testing.T, b *blob.Bucket) []string {
+	var keys []string
+
+	it := b.List(nil)
+
+	for {
+		obj, err := it.Next(context.Background())
+		if err == io.EOF {
+			return keys
+		}
+
+		require.NoError(t, err)
+
+		keys = append(keys, obj.Key)
+	}
+}
+
+func TestComposedWriter(t *testing.T) {
+	ctx := context.Background()
+
+	write := func(partSize int64, writes ...string) (*blob.Bucket, [][]string, error) {
+		b := memblob.OpenBucket(nil)
+
+		var calls [][]string
+
+		cw := newComposedWriter(ctx, b, "mem://bucket/out/list_00.jsonl", "out/list_00.jsonl", partSize,
+			concatCompose(b, &calls))
+
+		for _, s := range writes {
+			n, err := cw.Write([]byte(s))
+			require.NoError(t, err)
+			require.Equal(t, len(s), n)
+		}
+
+		return b, calls, cw.Close()
+	}
+
+	read := func(b *blob.Bucket) string {
+		data, err := b.ReadAll(ctx, "out/list_00.jsonl")
+		require.NoError(t, err)
+
+		return string(data)
+	}
+
+	// writes are split across parts, which are composed and then deleted
+	b, calls, err := write(4, "abc", "defghij", "k")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"out/list_00.jsonl.part-00001", "out/list_00.jsonl.part-00002",
+		"out/list_00.jsonl.part-00003"}}, calls)
+	require.Equal(t, "abcdefghijk", read(b))
+	require.Equal(t, []string{"out/list_00.jsonl"}, keys(t, b))
+
+	// more parts than can be composed at once are appended to the object composed so far
+	data := strings.Repeat("0123456789", 7)
+
+	b, calls, err = write(1, data)
+	require.NoError(t, err)
+	require.Equal(t, data, read(b))
+	require.Equal(t, []string{"out/list_00.jsonl"}, keys(t, b))
+	require.Len(t, calls, 3)
+	require.Len(t, calls[0], maxComposeSources)
+	require.Equal(t, "out/list_00.jsonl", calls[1][0])
+	require.Len(t, calls[1], maxComposeSources)
+	require.Equal(t, []string{"out/list_00.jsonl", "out/list_00.jsonl.part-00064", "out/list_00.jsonl.part-00065",
+		"out/list_00.jsonl.part-00066", "out/list_00.jsonl.part-00067", "out/list_00.jsonl.part-00068",
+		"out/list_00.jsonl.part-00069", "out/list_00.jsonl.part-00070"}, calls[2])
+
+	// an object which nothing is written to is empty
+	b, calls, err = write(4)
+	require.NoError(t, err)
+	require.Empty(t, calls)
+	require.Equal(t, "", read(b))
+
+	// the parts are deleted when they can't be composed
+	b = memblob.OpenBucket(nil)
+	composeErr := errors.New("compose failed")
+
+	cw := newComposedWriter(ctx, b, "mem://bucket/out.jsonl", "out.jsonl", 2,
+		func(context.Context, string, []string) error { return composeErr })
+
+	_, err = cw.Write([]byte("abcde"))
+	require.NoError(t, err)
+
+	err = cw.Close()
+	require.ErrorIs(t, err, composeErr)
+	require.EqualError(t, err, "unable to compose mem://bucket/out.jsonl: compose failed")
+	require.Empty(t, keys(t, b))
+
+	// cancelling the upload part way through composing the parts deletes the object composed so far along with them
+	b = memblob.OpenBucket(nil)
+	calls = nil
+	cancelCtx, cancel := context.WithCancel(ctx)
+	compose := concatCompose(b, &calls)
+
+	cw = newComposedWriter(cancelCtx, b, "mem://bucket/out.jsonl", "out.jsonl", 1,
+		func(ctx context.Context, dst string, srcs []string) error {
+			if len(calls) == 1 {
+				cancel()
+				return ctx.Err()
+			}
+
+			return compose(ctx, dst, srcs)
+		})
+
+	_, err = cw.Write([]byte(data))
+	require.NoError(t, err)
+
+	err = cw.Close()
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, calls, 1)
+	require.Empty(t, keys(t, b))
+
+	// as is cancelling it while a part is being written
+	b = memblob.OpenBucket(nil)
+	cancelCtx, cancel = context.WithCancel(ctx)
+
+	cw = newComposedWriter(cancelCtx, b, "mem://bucket/out.jsonl", "out.jsonl", 4, concatCompose(b, &calls))
+
+	_, err = cw.Write([]byte("abcdef"))
+	require.NoError(t, err)
+
+	cancel()
+
+	require.ErrorIs(t, cw.Close(), context.Canceled)
+	require.Empty(t, keys(t, b))
+
+	_, err = NewComposedWriter(ctx, "s3://bucket/out.jsonl", 1024)
+	require.EqualError(t, err, "s3://bucket/out.jsonl is not a Google Cloud Storage object, only they can be composed")
+
+	_, err = NewComposedWriter(ctx, "gs://bucket/out.jsonl", 0)
+	require.EqualError(t, err, "compose part size must be positive, got 0")
+}
+
+func TestGCSCompose(t *testing.T) {
+	var (
+		path string
+		req  struct {
+			SourceObjects []struct {
+				Name string `json:"name"`
+			} `json:"sourceObjects"`
+		}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"bucket": "bucket", "name": "out.jsonl"}`))
+	}))
+	defer srv.Close()
+
+	client, err := storage.NewClient(context.Background(), option.WithoutAuthentication(),
+		option.WithEndpoint(srv.URL))
+	require.NoError(t, err)
+
+	err = gcsCompose(client.Bucket("bucket"))(context.Background(), "out.jsonl",
+		[]string{"out.jsonl.part-00001", "out.jsonl.part-00002"})
+	require.NoError(t, err)
+	require.Equal(t, "/b/bucket/o/out.jsonl/compose", path)
+	require.Len(t, req.SourceObjects, 2)
+	require.Equal(t, "out.jsonl.part-00001", req.SourceObjects[0].Name)
+	require.Equal(t, "out.jsonl.part-00002", req.SourceObjects[1].Name)
+}
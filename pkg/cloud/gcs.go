@@ -0,0 +1,236 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/gcsblob"
+	"gocloud.dev/gcp"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+)
+
+// gcsScope is the OAuth scope requested for explicitly configured credentials, matching the scope the CDK requests
+// for application default credentials
+const gcsScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GCSUserProjectEnv is the environment variable naming the project billed for reads from requester pays Google Cloud
+// Storage buckets when ReaderOptions doesn't set one
+const GCSUserProjectEnv = "JSPLIT_GCS_USER_PROJECT"
+
+// ReaderOptions configures how objects are read from cloud storage
+type ReaderOptions struct {
+	// GCSUserProject is the project billed for reads from requester pays Google Cloud Storage buckets
+	GCSUserProject string
+
+	// GCSCredentialsFile is the path of a service account or other credentials JSON file used to authenticate with
+	// Google Cloud Storage. Application default credentials are used when neither it nor GCSTokenSource are set.
+	GCSCredentialsFile string
+
+	// GCSTokenSource supplies the tokens used to authenticate with Google Cloud Storage. It takes precedence over
+	// GCSCredentialsFile.
+	GCSTokenSource oauth2.TokenSource
+
+	// GCSHTTPClient is the authenticated client requests to Google Cloud Storage are sent with, so that its connections
+	// can be shared, or its transport replaced with a fake in tests. It takes precedence over GCSTokenSource and
+	// GCSCredentialsFile.
+	GCSHTTPClient *gcp.HTTPClient
+
+	// AzureHTTPClient is the client requests to Azure Blob Storage, and for the tokens of DefaultAzureCredential, are
+	// sent with, http.DefaultClient when it isn't set
+	AzureHTTPClient *http.Client
+}
+
+// openBucket opens the bucket at bucketURL, authenticating with the credentials set in opts for Google Cloud Storage
+// buckets
+func (opts *ReaderOptions) openBucket(ctx context.Context, bucketURL string) (*blob.Bucket, error) {
+	hasCredentials := opts != nil &&
+		(opts.GCSCredentialsFile != "" || opts.GCSTokenSource != nil || opts.GCSHTTPClient != nil)
+	if !hasCredentials || !strings.HasPrefix(bucketURL, "gs://") {
+		return OpenBucket(ctx, bucketURL)
+	}
+
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.GCSHTTPClient != nil {
+		return gcsblob.OpenBucket(ctx, opts.GCSHTTPClient, u.Host, nil)
+	}
+
+	ts, err := opts.gcsTokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gcp.NewHTTPClient(gcp.DefaultTransport(), ts)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcsblob.OpenBucket(ctx, client, u.Host, nil)
+}
+
+// gcsTokenSource returns the token source set in opts, or one for the credentials in opts.GCSCredentialsFile
+func (opts *ReaderOptions) gcsTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	if opts.GCSTokenSource != nil {
+		return opts.GCSTokenSource, nil
+	}
+
+	data, err := os.ReadFile(opts.GCSCredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read GCS credentials: %w", err)
+	}
+
+	creds, err := google.CredentialsFromJSON(ctx, data, gcsScope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GCS credentials in %s: %w", opts.GCSCredentialsFile, err)
+	}
+
+	return creds.TokenSource, nil
+}
+
+// ErrNoGCSCredentials is returned by CheckGCSCredentials when neither the ReaderOptions nor the environment configure
+// credentials for Google Cloud Storage
+var ErrNoGCSCredentials = errors.New("no Google Cloud Storage credentials were found")
+
+// CheckGCSCredentials checks that the credentials reads from Google Cloud Storage would be authenticated with can be
+// loaded and exchanged for a token, returning ErrNoGCSCredentials when none are configured. Credentials named by
+// GOOGLE_APPLICATION_CREDENTIALS which can't be loaded are an error rather than missing.
+func CheckGCSCredentials(ctx context.Context, opts *ReaderOptions) error {
+	if opts != nil && opts.GCSHTTPClient != nil {
+		return nil
+	}
+
+	var ts oauth2.TokenSource
+
+	if opts != nil && (opts.GCSTokenSource != nil || opts.GCSCredentialsFile != "") {
+		var err error
+
+		ts, err = opts.gcsTokenSource(ctx)
+		if err != nil {
+			return err
+		}
+	} else {
+		creds, err := google.FindDefaultCredentials(ctx, gcsScope)
+		if err != nil && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
+			return ErrNoGCSCredentials
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid GCS credentials: %w", err)
+		}
+
+		ts = creds.TokenSource
+	}
+
+	_, err := ts.Token()
+	if err != nil {
+		return fmt.Errorf("unable to get a GCS token: %w", err)
+	}
+
+	return nil
+}
+
+// gcsUserProject returns the user project set in opts, falling back to the one set in the environment
+func (opts *ReaderOptions) gcsUserProject() string {
+	if opts != nil && opts.GCSUserProject != "" {
+		return opts.GCSUserProject
+	}
+
+	return os.Getenv(GCSUserProjectEnv)
+}
+
+// readerOptions returns the CDK reader options for reading from b, which was opened from bucketURL. Raw reads get the
+// bytes of Google Cloud Storage objects as they are stored, see Client.NewRawRangeReader.
+func (opts *ReaderOptions) readerOptions(b *blob.Bucket, bucketURL string, raw bool) (*blob.ReaderOptions, error) {
+	project := opts.gcsUserProject()
+	if (project == "" && !raw) || !strings.HasPrefix(bucketURL, "gs://") {
+		return nil, nil
+	}
+
+	var client *storage.Client
+	if !b.As(&client) {
+		// only Google Cloud Storage transcodes objects, so raw reads of other buckets need nothing
+		if project == "" {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("%s is not a Google Cloud Storage bucket", bucketURL)
+	}
+
+	var before []func(asFunc func(interface{}) bool) error
+	if project != "" {
+		before = append(before, withUserProject(client, project))
+	}
+
+	// the user project replaces the object handle, so reads are made raw afterwards
+	if raw {
+		before = append(before, readCompressed)
+	}
+
+	return &blob.ReaderOptions{BeforeRead: func(asFunc func(interface{}) bool) error {
+		for _, f := range before {
+			if err := f(asFunc); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}}, nil
+}
+
+// withUserProject returns a BeforeRead function which bills reads to project
+func withUserProject(client *storage.Client, project string) func(asFunc func(interface{}) bool) error {
+	return func(asFunc func(interface{}) bool) error {
+		var objp **storage.ObjectHandle
+		if !asFunc(&objp) {
+			return errors.New("unable to set the user project of a Google Cloud Storage read")
+		}
+
+		obj := *objp
+		*objp = client.Bucket(obj.BucketName()).UserProject(project).Object(obj.ObjectName())
+
+		return nil
+	}
+}
+
+// readCompressed is a BeforeRead function reading objects stored with a Content-Encoding of gzip as they are stored,
+// rather than decompressed by Google Cloud Storage
+func readCompressed(asFunc func(interface{}) bool) error {
+	var objp **storage.ObjectHandle
+	if !asFunc(&objp) {
+		return errors.New("unable to read a Google Cloud Storage object as it is stored")
+	}
+
+	*objp = (*objp).ReadCompressed(true)
+
+	return nil
+}
+
+// isUserProjectMissing reports whether err was returned by Google Cloud Storage for a read from a requester pays
+// bucket without a user project
+func isUserProjectMissing(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+		return false
+	}
+
+	// the JSON API sets the message, the XML API used for reading objects only sets the body
+	for _, s := range []string{apiErr.Message, apiErr.Body} {
+		if strings.Contains(s, "UserProjectMissing") || strings.Contains(strings.ToLower(s), "requester pays") {
+			return true
+		}
+	}
+
+	return false
+}
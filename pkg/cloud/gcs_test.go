@@ -0,0 +1,167 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+func TestGCSUserProject(t *testing.T) {
+	t.Setenv(GCSUserProjectEnv, "")
+
+	var opts *ReaderOptions
+	require.Equal(t, "", opts.gcsUserProject())
+
+	t.Setenv(GCSUserProjectEnv, "env-project")
+	require.Equal(t, "env-project", opts.gcsUserProject())
+	require.Equal(t, "env-project", (&ReaderOptions{}).gcsUserProject())
+	require.Equal(t, "explicit", (&ReaderOptions{GCSUserProject: "explicit"}).gcsUserProject())
+}
+
+func TestReaderOptionsIgnoredOutsideGCS(t *testing.T) {
+	b, err := OpenBucket(context.Background(), "file://"+t.TempDir())
+	require.NoError(t, err)
+
+	ro, err := (&ReaderOptions{GCSUserProject: "project"}).readerOptions(b, "file://"+t.TempDir(), true)
+	require.NoError(t, err)
+	require.Nil(t, ro)
+}
+
+func TestWithUserProject(t *testing.T) {
+	client, err := storage.NewClient(context.Background(), option.WithoutAuthentication())
+	require.NoError(t, err)
+
+	orig := client.Bucket("bucket").Object("path/to/file.json")
+	obj := orig
+	objp := &obj
+	asFunc := func(i interface{}) bool {
+		if p, ok := i.(***storage.ObjectHandle); ok {
+			*p = objp
+			return true
+		}
+		return false
+	}
+
+	require.NoError(t, withUserProject(client, "project")(asFunc))
+	require.NotSame(t, orig, *objp)
+	require.Equal(t, "bucket", (*objp).BucketName())
+	require.Equal(t, "path/to/file.json", (*objp).ObjectName())
+
+	err = withUserProject(client, "project")(func(interface{}) bool { return false })
+	require.Error(t, err)
+}
+
+func TestReadCompressed(t *testing.T) {
+	client, err := storage.NewClient(context.Background(), option.WithoutAuthentication())
+	require.NoError(t, err)
+
+	orig := client.Bucket("bucket").Object("path/to/file.json.gz")
+	obj := orig
+	objp := &obj
+	asFunc := func(i interface{}) bool {
+		if p, ok := i.(***storage.ObjectHandle); ok {
+			*p = objp
+			return true
+		}
+		return false
+	}
+
+	require.NoError(t, readCompressed(asFunc))
+	require.NotSame(t, orig, *objp)
+	require.Equal(t, "path/to/file.json.gz", (*objp).ObjectName())
+
+	require.Error(t, readCompressed(func(interface{}) bool { return false }))
+}
+
+func TestDescribeErrorRequesterPays(t *testing.T) {
+	uri := "gs://bucket/file.json"
+
+	for _, apiErr := range []*googleapi.Error{
+		{Code: 400, Message: "Bucket is a requester pays bucket but no user project provided."},
+		{Code: 400, Body: "<Error><Code>UserProjectMissing</Code></Error>"},
+	} {
+		err := DescribeError(uri, apiErr)
+		require.ErrorIs(t, err, apiErr)
+		require.Contains(t, err.Error(), "requester pays")
+		require.Contains(t, err.Error(), GCSUserProjectEnv)
+	}
+
+	apiErr := &googleapi.Error{Code: 400, Message: "Invalid argument."}
+	require.Equal(t, error(apiErr), DescribeError(uri, apiErr))
+
+	other := errors.New("requester pays")
+	require.Equal(t, other, DescribeError(uri, other))
+}
+
+func TestOpenBucketWithCredentials(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	credsFile := filepath.Join(dir, "creds.json")
+	creds := `{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token"}`
+	require.NoError(t, os.WriteFile(credsFile, []byte(creds), 0o600))
+
+	for _, opts := range []*ReaderOptions{
+		{GCSCredentialsFile: credsFile},
+		{GCSTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})},
+	} {
+		b, err := opts.openBucket(ctx, "gs://bucket")
+		require.NoError(t, err)
+
+		var client *storage.Client
+		require.True(t, b.As(&client))
+	}
+
+	_, err := (&ReaderOptions{GCSCredentialsFile: filepath.Join(dir, "missing.json")}).openBucket(ctx, "gs://bucket")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	invalidFile := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalidFile, []byte("not json"), 0o600))
+
+	_, err = (&ReaderOptions{GCSCredentialsFile: invalidFile}).openBucket(ctx, "gs://bucket")
+	require.ErrorContains(t, err, invalidFile)
+
+	// credentials only apply to GCS
+	_, err = (&ReaderOptions{GCSCredentialsFile: invalidFile}).openBucket(ctx, "file://"+dir)
+	require.NoError(t, err)
+}
+
+// failingTokenSource fails every request for a token, as credentials which have been revoked would
+type failingTokenSource struct{}
+
+func (failingTokenSource) Token() (*oauth2.Token, error) {
+	return nil, errors.New("invalid_grant")
+}
+
+func TestCheckGCSCredentials(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	require.NoError(t, CheckGCSCredentials(ctx, &ReaderOptions{
+		GCSTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+	}))
+
+	err := CheckGCSCredentials(ctx, &ReaderOptions{GCSTokenSource: failingTokenSource{}})
+	require.EqualError(t, err, "unable to get a GCS token: invalid_grant")
+
+	err = CheckGCSCredentials(ctx, &ReaderOptions{GCSCredentialsFile: filepath.Join(dir, "missing.json")})
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// without credentials in the options or the environment there are none, unless the environment names a file
+	t.Setenv("HOME", dir)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	require.ErrorIs(t, CheckGCSCredentials(ctx, nil), ErrNoGCSCredentials)
+
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(dir, "missing.json"))
+	err = CheckGCSCredentials(ctx, &ReaderOptions{})
+	require.ErrorContains(t, err, "invalid GCS credentials")
+	require.NotErrorIs(t, err, ErrNoGCSCredentials)
+}
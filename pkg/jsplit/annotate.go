@@ -0,0 +1,101 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// DefaultIndexField is the field the index of each object in its list is added as by the CLI's -annotate
+const DefaultIndexField = "_jsplit_index"
+
+// DefaultKeyField is the field the key of each object's list is added as by the CLI's -merge-output
+const DefaultKeyField = "_key"
+
+// annotateItems returns the ListAddFunc and listErrorFunc through which the items of the list with the given key are
+// passed on to add and onErr, adding opts.KeyField, opts.IndexField and opts.SourceField to each object. Items which
+// are rejected still count towards the index, so that it is always the position of the object in the list of the
+// document. add and onErr are returned as they are when no fields are being added.
+func annotateItems(key string, add ListAddFunc, onErr listErrorFunc, opts Options) (ListAddFunc, listErrorFunc) {
+	if opts.KeyField == "" && opts.IndexField == "" && opts.SourceField == "" {
+		return add, onErr
+	}
+
+	// the names, the key and the source are quoted once, for every item. Strings can always be marshalled.
+	var keyField, indexName, source []byte
+	if opts.KeyField != "" {
+		name, _ := json.Marshal(opts.KeyField)
+		value, _ := json.Marshal(key)
+
+		keyField = append(append(name, ':'), value...)
+	}
+
+	if opts.IndexField != "" {
+		indexName, _ = json.Marshal(opts.IndexField)
+		indexName = append(indexName, ':')
+	}
+
+	if opts.SourceField != "" && opts.source != "" {
+		name, _ := json.Marshal(opts.SourceField)
+		value, _ := json.Marshal(opts.source)
+
+		source = append(append(name, ':'), value...)
+	}
+
+	var (
+		index int
+		buf   []byte
+	)
+
+	annotated := func(item []byte) error {
+		defer func() {
+			index++
+		}()
+
+		if len(item) < 2 || item[0] != OpenCB {
+			return add(item)
+		}
+
+		// the key is added after the opening brace, and the other fields before the closing brace following a comma
+		// unless the object is empty
+		buf = append(buf[:0], OpenCB)
+
+		if keyField != nil {
+			buf = append(buf, keyField...)
+			if len(item) > 2 {
+				buf = append(buf, COMMA)
+			}
+		}
+
+		buf = append(buf, item[1:len(item)-1]...)
+
+		if indexName != nil {
+			if len(buf) > 1 {
+				buf = append(buf, COMMA)
+			}
+
+			buf = append(buf, indexName...)
+			buf = strconv.AppendInt(buf, int64(index), 10)
+		}
+
+		if source != nil {
+			if len(buf) > 1 {
+				buf = append(buf, COMMA)
+			}
+
+			buf = append(buf, source...)
+		}
+
+		buf = append(buf, CloseCB)
+
+		return add(buf)
+	}
+
+	if onErr == nil {
+		return annotated, nil
+	}
+
+	return annotated, func(i int, item []byte, err error) error {
+		index++
+		return onErr(i, item, err)
+	}
+}
@@ -0,0 +1,100 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotateItems(t *testing.T) {
+	var (
+		added    []string
+		rejected []int
+	)
+
+	add := func(item []byte) error {
+		added = append(added, string(item))
+		return nil
+	}
+
+	onErr := func(index int, item []byte, err error) error {
+		rejected = append(rejected, index)
+		return nil
+	}
+
+	opts := Options{IndexField: "_i", SourceField: "_src", source: `exports/"a".json`}
+	annotatedAdd, annotatedErr := annotateItems("users", add, onErr, opts)
+
+	require.NoError(t, annotatedAdd([]byte(`{"id":1}`)))
+	require.NoError(t, annotatedErr(1, []byte(`{"id":`), errors.New("invalid")))
+	require.NoError(t, annotatedAdd([]byte(`{}`)))
+	require.NoError(t, annotatedAdd([]byte(`[1]`)))
+	require.NoError(t, annotatedAdd([]byte(`{"a":{"b":2}}`)))
+
+	// rejected items and items which aren't objects still count towards the index
+	require.Equal(t, []string{
+		`{"id":1,"_i":0,"_src":"exports/\"a\".json"}`,
+		`{"_i":2,"_src":"exports/\"a\".json"}`,
+		`[1]`,
+		`{"a":{"b":2},"_i":4,"_src":"exports/\"a\".json"}`,
+	}, added)
+	require.Equal(t, []int{1}, rejected)
+
+	// the source is left out when it isn't known, and the index when its field isn't set
+	added = nil
+
+	annotatedAdd, _ = annotateItems("users", add, nil, Options{IndexField: "_i", SourceField: "_src"})
+	require.NoError(t, annotatedAdd([]byte(`{"id":1}`)))
+
+	annotatedAdd, _ = annotateItems("users", add, nil, Options{SourceField: "_src", source: "in.json"})
+	require.NoError(t, annotatedAdd([]byte(`{"id":1}`)))
+	require.Equal(t, []string{`{"id":1,"_i":0}`, `{"id":1,"_src":"in.json"}`}, added)
+
+	// the key of the list leads the object, ahead of the other fields
+	added = nil
+
+	annotatedAdd, _ = annotateItems(`"users"`, add, nil, Options{KeyField: "_key", IndexField: "_i"})
+	require.NoError(t, annotatedAdd([]byte(`{"id":1}`)))
+	require.NoError(t, annotatedAdd([]byte(`{}`)))
+	require.NoError(t, annotatedAdd([]byte(`2`)))
+	require.Equal(t, []string{`{"_key":"\"users\"","id":1,"_i":0}`, `{"_key":"\"users\"","_i":1}`, `2`}, added)
+}
+
+func TestSplitFileAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	require.NoError(t, os.WriteFile(input,
+		[]byte(`{"users": [{"id": 1}, {"id": 2}, {"id": 1}], "n": {"a": 1}, "ids": [1, 2]}`), 0o600))
+
+	// each element is annotated with its position in the list of the document, before duplicates are dropped
+	outputDir := filepath.Join(dir, "output")
+	opts := Options{IndexField: DefaultIndexField, SourceField: "_source", DedupField: "id"}
+	require.NoError(t, SplitFile(context.Background(), input, outputDir, opts))
+
+	requireContents(t, filepath.Join(outputDir, "users_00.jsonl"),
+		`{"id":1,"_jsplit_index":0,"_source":"`+input+`"}`+"\n"+`{"id":2,"_jsplit_index":1,"_source":"`+input+`"}`)
+	requireContents(t, filepath.Join(outputDir, "ids_00.jsonl"), "1\n2")
+	requireContents(t, filepath.Join(outputDir, "root.json"), "{\n\t\"n\":{\"a\":1}\n}")
+
+	// the elements of a document which is a json array are annotated too, and a stream has no source
+	bs := NewTestByteStream([]byte(`[{"id": "a"}, {"id": "b"}]`), 4)
+	outputDir = filepath.Join(dir, "stream")
+	require.NoError(t, SplitStream(context.Background(), bs, outputDir, opts))
+	requireContents(t, filepath.Join(outputDir, "root_00.jsonl"),
+		`{"id":"a","_jsplit_index":0}`+"\n"+`{"id":"b","_jsplit_index":1}`)
+
+	bs = NewTestByteStream([]byte(`[{"id": "a"}, {"id": "b"}, {"id": "c"}]`), 4)
+	outputDir = filepath.Join(dir, "shards")
+	require.NoError(t, SplitStream(context.Background(), bs, outputDir, Options{IndexField: "i", Shards: 2}))
+	requireContents(t, filepath.Join(outputDir, "part-000_00.jsonl"), `{"id":"a","i":0}`+"\n"+`{"id":"c","i":2}`)
+
+	err := SplitFile(context.Background(), input, filepath.Join(dir, "same"), Options{IndexField: "f", SourceField: "f"})
+	require.EqualError(t, err, "index field and source field must have different names, both are f")
+
+	err = SplitFile(context.Background(), input, filepath.Join(dir, "same"), Options{KeyField: "f", IndexField: "f"})
+	require.EqualError(t, err, "key field must have a different name from the index and source fields, got f")
+}
@@ -1,94 +1,628 @@
 package jsplit
 
 import (
-	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"io"
-	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/danielchalef/jsplit/pkg/cloud"
+	"golang.org/x/time/rate"
 )
 
+// ErrReaderClosed is returned by Read once an AsyncReader has been closed
+var ErrReaderClosed = errors.New("async reader closed")
+
+// ErrReadTimeout is returned by Read when no data was received from the source within the timeout set with
+// WithReadTimeout
+var ErrReadTimeout = errors.New("read timed out")
+
+// ReadError is returned by Read when reading the source fails, saying how far into the input reading got before it
+// failed so that stuck or failing reads can be tracked down
+type ReadError struct {
+	// Offset is the offset within the input reading had reached, see AsyncReader.Offset
+	Offset int64
+	// Err is the error the source failed with
+	Err error
+}
+
+// Error returns the error the source failed with and where
+func (re *ReadError) Error() string {
+	return fmt.Sprintf("%s at byte offset %d", re.Err, re.Offset)
+}
+
+// Unwrap returns the error the source failed with
+func (re *ReadError) Unwrap() error {
+	return re.Err
+}
+
 // AsyncReader reads an io.Reader asynchronously
 type AsyncReader struct {
-	rd         io.Reader
-	readCh     chan []byte
-	bufferSize int
-	isClosed   int32
+	bytesRead   int64 // accessed atomically so kept first to guarantee 64-bit alignment
+	inFlight    int64 // bytes queued for the consumer, accessed atomically
+	peak        int64 // the most bytes which have been queued at once, accessed atomically
+	totalSize   int64
+	maxQueued   int64         // bytes queued after which reading pauses, unlimited when 0
+	space       chan struct{} // signalled when the consumer takes a chunk, waking a paused reader
+	budget      *memoryBudget // the memory of the split the queued chunks are counted against, see Options.MaxMemory
+	limiter     *rate.Limiter // paces reading to the rate set with WithMaxReadRate, unlimited when nil
+	tuner       *bufferTuner  // grows the buffers read into, see WithAutoBufferSize, or nil to keep bufferSize
+	byteRange   *byteRange    // the part of the source read, see WithByteRange, or nil to read all of it
+	rd          io.Reader
+	readCh      chan []byte // closed once reading stops, at the end of the source, on a read error or by Close
+	closers     []io.Closer
+	abort       func()
+	bufPool     sync.Pool
+	retry       RetryPolicy
+	cloudOpts   cloud.ReaderOptions
+	cloud       *cloud.Client
+	ownsCloud   bool  // whether cloud was created by the AsyncReader, and so is closed along with it
+	err         error // set before readCh is closed when reading fails
+	mu          sync.Mutex
+	cancel      CancelWithErrFunc
+	finished    chan struct{}   // closed once the reading goroutine exits
+	follow      <-chan struct{} // closed once a followed file is to stop being followed, see WithFollow
+	timeout     time.Duration
+	closed      bool
+	seekable    bool        // whether offsets in the data read are offsets in the source, so reading can resume from one
+	fill        bool        // whether each chunk is filled before it is queued, see WithFillBuffers
+	fixedSize   bool        // whether the buffer size was set with WithReadBufferSize, which turns off tuning
+	leaveOpen   bool        // whether a file passed to AsyncReaderFromFileHandle is left open, see WithLeaveFileOpen
+	compression Compression // the compression format of the source, detected from its first bytes
+	readAs      Compression // the compression format the source is decompressed as, see WithCompression
+	bufferSize  int
+	queueDepth  int
+	gcsChunk    int // the size of the chunks Google Cloud Storage objects are read in, see WithGCSReadBufferSize
+	isClosed    int32
 } // reordered to pack better
 
-// AsyncReaderFromFile creates an AsyncReader for reading from a Google Cloud Storage object
-func AsyncReaderFromFile(uri string, bufferSize int) (*AsyncReader, error) {
-	var (
-		r   io.Reader
-		err error
-	)
+// AsyncReaderFromFile creates an AsyncReader for reading from a local file, an http(s) URL, a cloud storage URI, an
+// Azure blob, a Unix domain socket named by a unix:// URI, a file on a host reached over ssh named by a user@host:/path
+// or ssh:// URI, or standard input when uri is "-". Cloud storage URIs ending in / or containing glob characters read
+// every matching object, see AsyncReaderFromCloudPrefix.
+func AsyncReaderFromFile(uri string, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if afr.follow != nil && (afr.byteRange != nil || uri == "-" || isUnixSocket(uri) || isSSHPath(uri) ||
+		strings.HasPrefix(uri, "http") || cloud.IsCloudURI(uri) || cloud.IsAzureBlobURI(uri)) {
+		return nil, fmt.Errorf("only whole local files can be followed, not %s", uri)
+	}
+
+	if afr.follow != nil {
+		return afr.followFile(uri)
+	}
+
+	if afr.byteRange != nil {
+		return afr.openByteRange(uri)
+	}
 
 	switch {
+	case uri == "-":
+		return AsyncReaderFromStdin(bufferSize, opts...)
+	case isUnixSocket(uri):
+		return AsyncReaderFromUnixSocket(strings.TrimPrefix(uri, unixSocketScheme), bufferSize, opts...)
+	case isSSHPath(uri):
+		return AsyncReaderFromSSH(uri, bufferSize, opts...)
+	case cloud.IsAzureBlobURI(uri):
+		return AsyncReaderFromAzureBlob(uri, bufferSize, opts...)
 	case strings.HasPrefix(uri, "http"):
-		r, err = HTTPReader(uri)
-		if err != nil {
-			return nil, err
-		}
+		return AsyncReaderFromURL(uri, bufferSize, opts...)
+	case cloud.IsCloudURI(uri) && cloud.IsPattern(uri):
+		return AsyncReaderFromCloudPrefix(uri, bufferSize, opts...)
 	case cloud.IsCloudURI(uri):
-		r, err = cloud.NewReader(context.TODO(), uri)
+		return asyncReaderFromCloud(uri, bufferSize, opts)
+	}
+
+	f, size, err := openLocalFile(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return afr.attachSource(f, size)
+}
+
+// openLocalFile opens a file on the local filesystem returning it along with its size
+func openLocalFile(filename string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+
+	return f, fi.Size(), nil
+}
+
+// AsyncReaderFromFileHandle creates an AsyncReader for reading a file which is already open, such as one whose
+// descriptor was passed from a parent process and opened with os.NewFile, so that it doesn't have to be opened again by
+// name. The file is read from its current offset, and compressed files are detected from their first bytes. The
+// AsyncReader takes ownership of f, closing it once reading stops, unless WithLeaveFileOpen is given in which case the
+// caller closes it once the AsyncReader has been closed. A byte range can't be read from an open file.
+func AsyncReaderFromFileHandle(f *os.File, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if afr.byteRange != nil {
+		return nil, fmt.Errorf("a byte range can't be read from an open file %s", f.Name())
+	}
+
+	var r io.ReadCloser = f
+	if afr.leaveOpen {
+		r = readCloser{Reader: f, Closer: closerFunc(func() error { return nil })}
+	}
+
+	return afr.attachSource(r, fileRemaining(f))
+}
+
+// fileRemaining returns the number of bytes of f following its current offset, or -1 if f isn't a regular file, such
+// as a pipe, whose size is known
+func fileRemaining(f *os.File) int64 {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return -1
+	}
+
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil || offset > fi.Size() {
+		return -1
+	}
+
+	return fi.Size() - offset
+}
+
+// readCloser combines a reader with the closer closing it
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// AsyncReaderFromStdin creates an AsyncReader for reading from standard input. Compressed input is detected from its
+// first bytes. Standard input is left open once reading finishes.
+func AsyncReaderFromStdin(bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	return AsyncReaderFromReader(os.Stdin, bufferSize, opts...)
+}
+
+// AsyncReaderFromS3 creates an AsyncReader for reading an object from Amazon S3 given a s3://bucket/key URI.
+// Credentials and region are taken from the standard AWS SDK v2 configuration chain.
+func AsyncReaderFromS3(uri string, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("%s is not a s3://bucket/key URI", uri)
+	}
+
+	q := u.Query()
+	if !q.Has("awssdk") {
+		q.Set("awssdk", "v2")
+		u.RawQuery = q.Encode()
+	}
+
+	return asyncReaderFromCloud(u.String(), bufferSize, opts)
+}
+
+// AsyncReaderFromAzureBlob creates an AsyncReader for reading a blob from Azure Blob Storage given an
+// az://container/blob URI, whose storage account is set in the environment, or the https URL of the blob, e.g.
+// https://account.blob.core.windows.net/container/blob. Requests are authorized with the shared access signature of the
+// URL, or the credentials in the environment, see cloud.Client.NewAzureRangeReader.
+func AsyncReaderFromAzureBlob(uri string, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	_, err := cloud.ParseAzureBlobURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return asyncReaderFromCloud(uri, bufferSize, opts)
+}
+
+// asyncReaderFromCloud creates an AsyncReader for reading a cloud storage object
+func asyncReaderFromCloud(uri string, bufferSize int, opts []AsyncReaderOption) (*AsyncReader, error) {
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	afr.useGCSBufferSize(uri)
+
+	// requests for the object are aborted along with the AsyncReader
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r, size, declared, err := afr.openCloudObject(ctx, uri)
+	if err != nil {
+		cancel()
+		afr.closeCloud()
+
+		return nil, err
+	}
+
+	_, err = afr.attachSource(r, size)
+	if err != nil {
+		cancel()
+		afr.closeCloud()
+
+		return nil, err
+	}
+
+	// a format given with WithCompression overrides the metadata as much as the first bytes
+	if afr.readAs == CompressionAuto {
+		checkCompression(uri, declared, afr.compression)
+	}
+
+	afr.closers = append(afr.closers, closerFunc(func() error {
+		cancel()
+		return nil
+	}))
+	afr.abort = cancel
+
+	return afr, nil
+}
+
+// openCloudObject opens a cloud storage object returning it along with its size and the compression format its
+// metadata declares, or "" if it doesn't declare one, see cloudObjectCompression. Reads which fail with transient errors
+// are retried from the offset already read. Requests made for the object are cancelled along with ctx.
+func (afr *AsyncReader) openCloudObject(ctx context.Context, uri string) (io.ReadCloser, int64, Compression, error) {
+	client := afr.cloudClient()
+
+	// Azure blobs are read as they are stored, and their compression is detected from their contents
+	if cloud.IsAzureBlobURI(uri) {
+		r, size, err := client.NewAzureRangeReader(ctx, uri, 0, -1)
 		if err != nil {
-			return nil, err
+			return nil, 0, "", err
 		}
-	default:
-		r, err = os.Open(uri)
-		if err != nil {
-			return nil, err
+
+		reopen := func(_ context.Context, offset int64) (io.ReadCloser, error) {
+			r, _, err := client.NewAzureRangeReader(ctx, uri, offset, -1)
+			return r, err
 		}
+
+		return newResumableReader(r, reopen, afr.retry), size, "", nil
 	}
 
-	// if gzipped, wrap in gzip reader
-	if strings.HasSuffix(uri, ".gz") {
-		gr, err := gzip.NewReader(r)
+	// the metadata isn't needed when the object is decompressed as the format given with WithCompression
+	var declared Compression
+
+	if afr.readAs == CompressionAuto {
+		var err error
+
+		declared, err = cloudObjectCompression(ctx, client, uri)
 		if err != nil {
-			return nil, err
+			return nil, 0, "", err
 		}
+	}
+
+	// declared compressed objects are read as stored, so that they aren't decompressed in transit and offsets into them
+	// can be resumed from, as are objects decompressed as a format which was given
+	newRangeReader := client.NewRangeReader
+	if declared != "" || afr.readAs != CompressionAuto {
+		newRangeReader = client.NewRawRangeReader
+	}
+
+	r, err := newRangeReader(ctx, uri, 0, -1)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	reopen := func(_ context.Context, offset int64) (io.ReadCloser, error) {
+		return newRangeReader(ctx, uri, offset, -1)
+	}
+
+	return newResumableReader(r, reopen, afr.retry), r.Size(), declared, nil
+}
+
+// useGCSBufferSize reads the source in filled chunks of the size set with WithGCSReadBufferSize, if it is set and uri
+// is a Google Cloud Storage object or prefix. Cloud storage objects are also filled when their chunks are tuned, as
+// the network reads of a download return too little to ever fill a chunk, as well as with WithFillBuffers.
+func (afr *AsyncReader) useGCSBufferSize(uri string) {
+	if afr.gcsChunk == 0 || !strings.HasPrefix(uri, "gs://") {
+		afr.fill = afr.fill || afr.tuner != nil
+		return
+	}
+
+	afr.bufferSize = afr.gcsChunk
+	afr.fill = true
+	afr.tuner = nil
+
+	// a chunk is read at once, so the pacing of reads has to allow for the larger chunks
+	if afr.limiter != nil {
+		afr.limiter.SetBurst(afr.bufferSize)
+	}
+}
+
+// fillingReader fills each buffer it reads into before returning, so that a source whose reads return a little at a
+// time is read in chunks of the buffer's size. A read returning neither data nor an error ends the fill early, so that
+// the AsyncReader can wait for data.
+type fillingReader struct {
+	io.Reader
+}
 
-		return AsyncReaderFromReader(gr, bufferSize)
+func (fr fillingReader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		nn, err := fr.Reader.Read(p[n:])
+		n += nn
+
+		if err != nil || nn == 0 {
+			return n, err
+		}
 	}
 
-	return AsyncReaderFromReader(r, bufferSize)
+	return n, nil
 }
 
-// AsyncReaderFromReader returns an AsyncReader for reading the supplied io.Reader
-func AsyncReaderFromReader(rd io.Reader, bufferSize int) (*AsyncReader, error) {
-	return &AsyncReader{
-		readCh:     make(chan []byte, 16),
-		rd:         rd,
+// cloudObjectCompression returns the compression format declared by the metadata of the object at uri, or "" if it
+// doesn't declare one or its metadata can't be read, in which case the format is detected from its first bytes.
+func cloudObjectCompression(ctx context.Context, client *cloud.Client, uri string) (Compression, error) {
+	attrs, err := client.Attributes(ctx, uri)
+	if err != nil {
+		// reading the object reports why it can't be read, if it can't
+		logger().Debug(fmt.Sprintf("Unable to read the metadata of %s, detecting its compression: %v", uri, err),
+			"uri", uri, "error", err)
+
+		return "", nil
+	}
+
+	compression, err := compressionFromMetadata(attrs.ContentEncoding, attrs.ContentType)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s: %w", uri, err)
+	}
+
+	return compression, nil
+}
+
+// checkCompression warns when the compression format detected from the first bytes of the object at uri isn't the one
+// its metadata declared. The format detected is the one the object is read with.
+func checkCompression(uri string, declared, detected Compression) {
+	if declared == "" || declared == detected {
+		return
+	}
+
+	logger().Warn(fmt.Sprintf("%s is declared %s compressed by its metadata but its contents are %s, ignoring the metadata",
+		uri, declared, describeCompression(detected)), "uri", uri, "declared", declared, "detected", detected)
+}
+
+// describeCompression describes contents compressed with the compression format
+func describeCompression(compression Compression) string {
+	if compression == CompressionNone {
+		return "not compressed"
+	}
+
+	return string(compression) + " compressed"
+}
+
+// cloudClient returns the client cloud storage objects are read with, creating one configured by the AsyncReader's
+// options if it wasn't given one. Buckets are opened once and reused for every object read from them.
+func (afr *AsyncReader) cloudClient() *cloud.Client {
+	if afr.cloud == nil {
+		afr.cloud = cloud.NewClient(&afr.cloudOpts)
+		afr.ownsCloud = true
+	}
+
+	return afr.cloud
+}
+
+// closeCloud closes the cloud storage client if the AsyncReader created it
+func (afr *AsyncReader) closeCloud() {
+	if afr.ownsCloud {
+		_ = afr.cloud.Close()
+	}
+}
+
+// attachSource sets r as the source of the AsyncReader, decompressing it if it is compressed. The AsyncReader takes
+// ownership of r. size is the number of bytes r will return, or -1 if it isn't known.
+func (afr *AsyncReader) attachSource(r io.ReadCloser, size int64) (*AsyncReader, error) {
+	rd, compression, closers, err := decompressAs(r, afr.readAs)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	// the decompressed, or transcoded, size can't be known up front
+	if compression != CompressionNone || isTranscoded(rd) {
+		size = -1
+	}
+
+	// decompressors are closed before the source they read from
+	afr.rd = rd
+	afr.closers = append(closers, r)
+	afr.totalSize = size
+	afr.compression = compression
+	afr.seekable = compression == CompressionNone && preservesOffsets(rd)
+
+	return afr, nil
+}
+
+// AsyncReaderFromReader returns an AsyncReader for reading the supplied io.Reader. Compressed data is decompressed, but
+// rd itself is not closed.
+func AsyncReaderFromReader(rd io.Reader, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	drd, _, closers, err := decompressAs(rd, afr.readAs)
+	if err != nil {
+		return nil, err
+	}
+
+	afr.rd = drd
+	afr.closers = closers
+
+	return afr, nil
+}
+
+// newAsyncReader returns an AsyncReader configured by the supplied options. The constructors then attach its source
+// along with any io.Closers it owns, which are closed in order once reading stops.
+func newAsyncReader(bufferSize int, opts []AsyncReaderOption) (*AsyncReader, error) {
+	// zero length reads would never make progress
+	if bufferSize <= 0 {
+		return nil, fmt.Errorf("bufferSize must be positive, got %d", bufferSize)
+	}
+
+	afr := &AsyncReader{
 		bufferSize: bufferSize,
-	}, nil
+		queueDepth: DefaultQueueDepth,
+		totalSize:  -1,
+		retry:      DefaultRetryPolicy,
+		readAs:     CompressionAuto,
+	}
+
+	for _, opt := range opts {
+		err := opt(afr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if afr.fixedSize {
+		afr.tuner = nil
+	}
+
+	afr.readCh = make(chan []byte, afr.queueDepth)
+	afr.space = make(chan struct{}, 1)
+
+	return afr, nil
 }
 
-// Start starts the background reading of the io.Reader
+// Start starts the background reading of the io.Reader. If ctx is already done nothing is read, the source is closed
+// and Read returns ctx's error.
 func (afr *AsyncReader) Start(ctx context.Context) context.Context {
 	errCtx, cancelFunc := NewErrContextWithCancel(ctx)
+	finished := make(chan struct{})
+
+	afr.mu.Lock()
+	defer afr.mu.Unlock()
+
+	if afr.closed {
+		cancelFunc(ErrReaderClosed)
+		return errCtx
+	}
+
+	afr.cancel = cancelFunc
+	afr.finished = finished
+
+	// a context which is already done stops the reader before anything is read, so that no read of the source is left
+	// blocked and Read returns the context's error rather than racing the first read
+	if err := ctx.Err(); err != nil {
+		afr.finish(err)
+		close(finished)
+		cancelFunc(err)
+
+		return errCtx
+	}
+
+	// sources which can block indefinitely, such as network requests, are aborted when the context is cancelled
+	if afr.abort != nil {
+		go func() {
+			select {
+			case <-errCtx.Done():
+				afr.abort()
+			case <-finished:
+			}
+		}()
+	}
 
 	go func() {
+		defer close(finished)
+
+		// reads in a row which returned neither data nor an error, and when data was last read
+		emptyReads := 0
+		lastData := time.Now()
+
+		// the size of the buffers read into, which grows as it is tuned
+		size := afr.bufferSize
+
 		for {
-			buf := make([]byte, afr.bufferSize)
-			n, err := afr.rd.Read(buf)
+			buf := afr.getBuffer(size)
+			readStart := time.Now()
+			n, err := afr.read(buf)
 
-			if err != nil && err != io.EOF {
-				cancelFunc(err)
-				return
+			if next := afr.tuner.next(size, n, time.Since(readStart)); next != size {
+				size = next
+
+				// a chunk is read at once, so the pacing of reads has to allow for the larger chunks
+				if afr.limiter != nil {
+					afr.limiter.SetBurst(size)
+				}
+			}
+
+			// a read can return data along with an error, io.EOF or otherwise, so the data is queued first
+			if n == 0 {
+				afr.ReleaseBuffer(buf)
+			} else {
+				total := atomic.AddInt64(&afr.bytesRead, int64(n))
+				if debugEnabled() {
+					logger().Debug(fmt.Sprintf("Read %d bytes, %d in total", n, total), "bytes", n, "total", total)
+				}
+
+				// if the consumer has gone away nobody is draining readCh, so stop rather than block forever
+				if !afr.waitForRate(errCtx, n) || !afr.waitForSpace(errCtx, n) {
+					afr.closeSource()
+					return
+				}
+
+				afr.queued(n)
+
+				select {
+				case afr.readCh <- buf[:n]:
+				case <-errCtx.Done():
+					afr.closeSource()
+					return
+				}
 			}
 
+			// a source with no data yet, such as a named pipe whose writer is still open, can return neither data nor
+			// an error. It is read again after a pause rather than in a loop which spins, and counts towards the read
+			// timeout as a read which blocks would.
 			if n > 0 {
-				afr.readCh <- buf[:n]
+				emptyReads = 0
+				lastData = time.Now()
+			} else if err == nil {
+				emptyReads++
+
+				if afr.timeout > 0 && time.Since(lastData) >= afr.timeout {
+					err = fmt.Errorf("%w: no data received for %s", ErrReadTimeout, afr.timeout)
+				} else if !waitForData(errCtx, emptyReads) {
+					afr.closeSource()
+					return
+				}
 			}
 
 			if err == io.EOF {
-				close(afr.readCh)
-				atomic.StoreInt32(&afr.isClosed, 1)
+				afr.finish(nil)
+				return
+			}
+
+			// a read which failed as the source was aborted by the cancellation of the context is stopped by the
+			// cancellation rather than failing, so Read returns the context's error
+			if err != nil && errCtx.Err() != nil && afr.abort != nil {
+				afr.closeSource()
+				return
+			}
+
+			// a source which keeps failing, or fails once, stops reading all the same, so that a consumer receiving
+			// from readCh rather than waiting on the context isn't left blocked
+			if err != nil {
+				// the error is handed to the consumer once it has read the chunks queued before the failure
+				err = &ReadError{Offset: afr.Offset(), Err: err}
+				afr.finish(err)
+				cancelFunc(err)
 
 				return
 			}
@@ -98,43 +632,390 @@ func (afr *AsyncReader) Start(ctx context.Context) context.Context {
 	return errCtx
 }
 
-// Read gets the next chunk which has been read from the file.
-func (afr *AsyncReader) Read(ctx context.Context) ([]byte, error) {
+// waitForRate pauses reading after n bytes have been read for as long as it takes to keep to the rate set with
+// WithMaxReadRate. It returns false if ctx is cancelled while waiting.
+func (afr *AsyncReader) waitForRate(ctx context.Context, n int) bool {
+	if afr.limiter == nil {
+		return true
+	}
+
+	// unlike WaitN, a reservation waits out its delay even when it would run past the deadline of ctx, which then stops
+	// the split with the deadline's error
+	delay := afr.limiter.ReserveN(time.Now(), n).Delay()
+	if delay == 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
 	select {
-	case buf, ok := <-afr.readCh:
-		if !ok {
-			return nil, io.EOF
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// emptyReadBackoff sets how long reading pauses after a read which returned neither data nor an error, doubling with
+// each such read in a row
+var emptyReadBackoff = RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+
+// waitForData pauses reading after the given number of reads in a row have returned neither data nor an error. It
+// returns false if ctx is cancelled while waiting.
+func waitForData(ctx context.Context, emptyReads int) bool {
+	timer := time.NewTimer(emptyReadBackoff.backoff(emptyReads + 1))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waitForSpace pauses reading until a chunk of n bytes can be queued without the bytes queued exceeding the limit set
+// with WithMaxInFlightBytes, and without the memory held by the split exceeding Options.MaxMemory. A chunk is always
+// queued when nothing else is, so a chunk larger than the limit, or output buffers taking up all of the memory, can't
+// stall reading. It returns false if ctx is cancelled while waiting.
+func (afr *AsyncReader) waitForSpace(ctx context.Context, n int) bool {
+	for afr.maxQueued > 0 || afr.budget != nil {
+		released := afr.budget.released()
+
+		inFlight := atomic.LoadInt64(&afr.inFlight)
+		if (afr.maxQueued == 0 || inFlight == 0 || inFlight+int64(n) <= afr.maxQueued) && afr.budget.allows(int64(n)) {
+			return true
 		}
 
-		return buf, nil
+		select {
+		case <-afr.space:
+		case <-released:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+// queued counts a chunk of n bytes being queued for the consumer, raising the peak if more bytes are queued than
+// before
+func (afr *AsyncReader) queued(n int) {
+	afr.budget.enqueue(int64(n))
+	inFlight := atomic.AddInt64(&afr.inFlight, int64(n))
+
+	for {
+		peak := atomic.LoadInt64(&afr.peak)
+		if inFlight <= peak || atomic.CompareAndSwapInt64(&afr.peak, peak, inFlight) {
+			return
+		}
+	}
+}
+
+// read reads the next chunk from the source. When a read timeout is set, a read which doesn't return within it is
+// abandoned and fails with ErrReadTimeout. Network requests are aborted so that the read returns, and other sources
+// are closed once the abandoned read returns, if it ever does.
+func (afr *AsyncReader) read(buf []byte) (int, error) {
+	rd := afr.rd
+	if afr.fill {
+		rd = fillingReader{rd}
+	}
+
+	if afr.timeout <= 0 {
+		return rd.Read(buf)
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		n, err := rd.Read(buf)
+		done <- result{n, err}
+	}()
+
+	timer := time.NewTimer(afr.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-timer.C:
+	}
+
+	if afr.abort != nil {
+		afr.abort()
+		<-done
+	} else {
+		// the source can't be closed while it is being read, so it is left to the abandoned read
+		closers := afr.closers
+		afr.closers = nil
+
+		go func() {
+			<-done
+			closeAll(closers)
+		}()
+	}
+
+	return 0, fmt.Errorf("%w: no data received for %s", ErrReadTimeout, afr.timeout)
+}
+
+// getBuffer returns a buffer of size bytes previously handed back with ReleaseBuffer, or a newly allocated one. Buffers
+// released before the buffer size was tuned up are too small, and are dropped.
+func (afr *AsyncReader) getBuffer(size int) []byte {
+	if bufPtr, ok := afr.bufPool.Get().(*[]byte); ok && cap(*bufPtr) >= size {
+		return (*bufPtr)[:size]
+	}
+
+	return make([]byte, size)
+}
+
+// ReleaseBuffer hands a chunk returned by Read back to the AsyncReader so that its memory can be reused for later
+// reads. The chunk must not be used after it has been released. Releasing chunks is optional, chunks which are never
+// released are garbage collected as normal.
+func (afr *AsyncReader) ReleaseBuffer(buf []byte) {
+	if cap(buf) < afr.bufferSize {
+		return
+	}
+
+	buf = buf[:cap(buf)]
+	afr.bufPool.Put(&buf)
+}
+
+// Read gets the next chunk which has been read from the file. Once every chunk has been read it returns io.EOF, or the
+// error which stopped the reading if reading failed. Chunks read before a failure are returned ahead of the error. Both
+// end the reading for good, a source which keeps failing isn't read again.
+func (afr *AsyncReader) Read(ctx context.Context) ([]byte, error) {
+	select {
+	case buf, ok := <-afr.readCh:
+		return afr.chunk(buf, ok)
 
 	case <-ctx.Done():
+		// the context returned by Start is also cancelled when reading fails, in which case readCh has already been
+		// closed and the queued chunks and the error can be received without blocking
+		if afr.IsClosed() {
+			buf, ok := <-afr.readCh
+			return afr.chunk(buf, ok)
+		}
+
 		return nil, ctx.Err()
 	}
 }
 
-// IsClosed is used for testing to verify that the reader and associated channel has been closed.
-func (afr *AsyncReader) IsClosed() bool {
-	return atomic.LoadInt32(&afr.isClosed) == 1
+// AsReader returns an io.Reader reading the chunks of the AsyncReader as a stream of bytes, so that it can be passed to
+// the standard library, e.g. to json.NewDecoder or io.Copy, which uses its WriteTo method to write each chunk as it
+// is. Reads return ctx's error once it is done, unless reading has already finished, in which case the chunks queued
+// and then io.EOF or the read error are returned as they are by Read. The AsyncReader must have been started, and
+// nothing else may Read from it. Chunks are released once they have been read.
+func (afr *AsyncReader) AsReader(ctx context.Context) io.Reader {
+	return &chunkReader{afr: afr, ctx: ctx}
 }
 
-func HTTPReader(uri string) (io.Reader, error) {
-	const HTTPTimeOut = 10 * time.Minute
+// chunkReader adapts an AsyncReader to io.Reader, keeping the bytes of the last chunk which haven't been read yet
+type chunkReader struct {
+	afr   *AsyncReader
+	ctx   context.Context
+	buf   []byte // the last chunk, released once all of it has been read
+	chunk []byte // the part of buf which hasn't been read
+}
 
-	var (
-		err error
-		r   *http.Response
-	)
+// Read reads up to len(p) bytes from the chunks, reading the next chunk once the last has been used up
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for len(cr.chunk) == 0 {
+		err := cr.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, cr.chunk)
+	cr.chunk = cr.chunk[n:]
+
+	return n, nil
+}
+
+// WriteTo writes the rest of the chunks to w until reading finishes, returning nil rather than io.EOF once every chunk
+// has been written
+func (cr *chunkReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
 
-	var httpClient = &http.Client{
-		Timeout: HTTPTimeOut,
+	for {
+		if len(cr.chunk) == 0 {
+			err := cr.next()
+			if err == io.EOF {
+				return written, nil
+			}
+
+			if err != nil {
+				return written, err
+			}
+
+			continue
+		}
+
+		n, err := w.Write(cr.chunk)
+		written += int64(n)
+		cr.chunk = cr.chunk[n:]
+
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// next releases the last chunk and reads the next one
+func (cr *chunkReader) next() error {
+	if cr.buf != nil {
+		cr.afr.ReleaseBuffer(cr.buf)
+		cr.buf = nil
+	}
+
+	// Read picks between a queued chunk and the cancellation at random, so a cancelled context is checked first
+	if cr.ctx.Err() != nil && !cr.afr.IsClosed() {
+		return cr.ctx.Err()
 	}
 
-	r, err = httpClient.Get(uri)
+	buf, err := cr.afr.Read(cr.ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return r.Body, nil
+	cr.buf, cr.chunk = buf, buf
 
+	return nil
+}
+
+// chunk returns the result of a Read which received buf from readCh. ok is false once readCh has been closed.
+func (afr *AsyncReader) chunk(buf []byte, ok bool) ([]byte, error) {
+	if ok {
+		atomic.AddInt64(&afr.inFlight, -int64(len(buf)))
+		afr.budget.dequeue(int64(len(buf)))
+
+		// wakes the reader if it paused with too many bytes queued
+		select {
+		case afr.space <- struct{}{}:
+		default:
+		}
+
+		return buf, nil
+	}
+
+	if afr.err != nil {
+		return nil, afr.err
+	}
+
+	return nil, io.EOF
+}
+
+// Close stops reading early, closing the source and releasing any chunks which haven't been read. Reads after Close
+// return ErrReaderClosed, or io.EOF or the read error if reading had already finished. Close may be called more than
+// once and concurrently with Read.
+func (afr *AsyncReader) Close() error {
+	afr.mu.Lock()
+	defer afr.mu.Unlock()
+
+	if afr.closed {
+		return nil
+	}
+
+	afr.closed = true
+
+	if afr.finished != nil {
+		afr.cancel(ErrReaderClosed)
+		<-afr.finished
+	}
+
+	// the reading goroutine closes readCh itself if it reached the end of the source or failed
+	if atomic.LoadInt32(&afr.isClosed) == 0 {
+		afr.finish(ErrReaderClosed)
+	}
+
+	for buf := range afr.readCh {
+		afr.ReleaseBuffer(buf)
+	}
+
+	return nil
+}
+
+// finish stops reading, closing the source and then readCh, after which Read returns the chunks still queued followed
+// by err, or io.EOF when err is nil. It is called once, by whichever of the reading goroutine, Start and Close stops
+// reading.
+func (afr *AsyncReader) finish(err error) {
+	afr.closeSource()
+	afr.err = err
+	atomic.StoreInt32(&afr.isClosed, 1)
+	close(afr.readCh)
+}
+
+// closeSource closes everything the AsyncReader owns. Close errors are ignored as all data has already been read. The
+// cloud storage client is closed last, once the objects read with it have been closed.
+func (afr *AsyncReader) closeSource() {
+	closeAll(afr.closers)
+	afr.closers = nil
+
+	afr.closeCloud()
+	afr.ownsCloud = false
+}
+
+// closeAll closes each of the closers in order ignoring any errors
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		_ = c.Close()
+	}
+}
+
+// closerFunc adapts a function to the io.Closer interface
+type closerFunc func() error
+
+// Close calls the function
+func (fn closerFunc) Close() error {
+	return fn()
+}
+
+// BytesRead returns the number of bytes which have been read and queued for the consumer so far
+func (afr *AsyncReader) BytesRead() int64 {
+	return atomic.LoadInt64(&afr.bytesRead)
+}
+
+// Offset returns the offset within the input reading has reached, which is the number of bytes read so far following
+// the start of the range set with WithByteRange. The offsets of compressed input are those of the decompressed data, as
+// are the offsets of a *ParseError. It can be called while reading is in progress, e.g. to log where a stuck read is.
+func (afr *AsyncReader) Offset() int64 {
+	var start int64
+	if afr.byteRange != nil {
+		start = afr.byteRange.offset
+	}
+
+	return start + atomic.LoadInt64(&afr.bytesRead)
+}
+
+// InFlightBytes returns the number of bytes which have been read and queued, but not yet returned by Read
+func (afr *AsyncReader) InFlightBytes() int64 {
+	return atomic.LoadInt64(&afr.inFlight)
+}
+
+// PeakInFlightBytes returns the most bytes which have been queued waiting for Read at once, showing how far the source
+// has got ahead of the consumer
+func (afr *AsyncReader) PeakInFlightBytes() int64 {
+	return atomic.LoadInt64(&afr.peak)
+}
+
+// TotalSize returns the number of bytes the AsyncReader will read in total, or -1 if it isn't known.  The size is known
+// for uncompressed local files, cloud storage objects, and http responses with a Content-Length.
+func (afr *AsyncReader) TotalSize() int64 {
+	return afr.totalSize
+}
+
+// IsClosed reports whether the reader and associated channel have been closed, either because reading finished or
+// because Close was called.
+func (afr *AsyncReader) IsClosed() bool {
+	return atomic.LoadInt32(&afr.isClosed) == 1
 }
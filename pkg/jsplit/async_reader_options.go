@@ -0,0 +1,233 @@
+package jsplit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+	"golang.org/x/time/rate"
+)
+
+// DefaultQueueDepth is the number of chunks an AsyncReader will read ahead of the consumer by default
+const DefaultQueueDepth = 16
+
+// AsyncReaderOption configures an AsyncReader when it is created
+type AsyncReaderOption func(afr *AsyncReader) error
+
+// WithQueueDepth sets the number of chunks which may be read ahead of the consumer. Up to queueDepth * bufferSize bytes
+// may be held in memory waiting to be consumed, so large buffer sizes call for a shallower queue.
+func WithQueueDepth(queueDepth int) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if queueDepth < 1 {
+			return fmt.Errorf("queue depth must be at least 1, got %d", queueDepth)
+		}
+
+		afr.queueDepth = queueDepth
+
+		return nil
+	}
+}
+
+// WithMaxInFlightBytes pauses reading while more than maxBytes have been read and queued without being consumed, so
+// that the memory held by a reader which is ahead of a slow consumer is bounded by bytes rather than by the number of
+// chunks, see WithQueueDepth. A chunk is always queued when the queue is empty, even if it is larger than maxBytes. A
+// limit of 0, the default, leaves the queue to be bounded by its depth alone.
+func WithMaxInFlightBytes(maxBytes int64) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if maxBytes < 0 {
+			return fmt.Errorf("max in flight bytes must not be negative, got %d", maxBytes)
+		}
+
+		afr.maxQueued = maxBytes
+
+		return nil
+	}
+}
+
+// WithMaxReadRate paces reading so that on average no more than bytesPerSecond bytes of the source are read each
+// second, to avoid saturating a shared link or being throttled by cloud storage. Up to one buffer is read at once, so
+// the pace is kept over a few chunks rather than within each one. A rate of 0, the default, reads as fast as the
+// source and the consumer allow.
+func WithMaxReadRate(bytesPerSecond int64) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if bytesPerSecond < 0 {
+			return fmt.Errorf("max read rate must not be negative, got %d", bytesPerSecond)
+		}
+
+		afr.limiter = nil
+		if bytesPerSecond > 0 {
+			afr.limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), afr.bufferSize)
+		}
+
+		return nil
+	}
+}
+
+// WithReadTimeout abandons reading, failing with ErrReadTimeout, when a read of the source doesn't return within
+// timeout, so that a stalled network source can't hang the reader indefinitely. Requests to http(s) URLs and cloud
+// storage are aborted when a read times out. A timeout of 0, the default, waits as long as each read takes.
+func WithReadTimeout(timeout time.Duration) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if timeout < 0 {
+			return fmt.Errorf("read timeout must not be negative, got %s", timeout)
+		}
+
+		afr.timeout = timeout
+
+		return nil
+	}
+}
+
+// WithByteRange reads only length bytes of a local file, http(s) URL or cloud storage object from offset onwards, or
+// everything from offset onwards when length is 0, e.g. to split part of a huge input while debugging. Files are
+// seeked to the offset and the range of URLs and objects is requested from the server, so the bytes before it aren't
+// read. As compressed data can't be decompressed from part way through, ranges can only be read from uncompressed
+// input, and the range is split as a document of its own, so it should start and end on the boundaries of values,
+// such as lines of ndjson. It can't be used with standard input, cloud storage prefixes or checkpoints.
+func WithByteRange(offset, length int64) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if offset < 0 {
+			return fmt.Errorf("byte range offset must not be negative, got %d", offset)
+		}
+
+		if length < 0 {
+			return fmt.Errorf("byte range length must not be negative, got %d", length)
+		}
+
+		afr.byteRange = &byteRange{offset: offset, length: length}
+		if length == 0 {
+			afr.byteRange.length = -1
+		}
+
+		return nil
+	}
+}
+
+// WithCompression decompresses the source as the compression format given, CompressionNone reading it as it is,
+// whatever its first bytes are, rather than the format being detected from them. Sources with misleading names, or
+// data which happens to start with the magic bytes of a format, are then read as intended, and the metadata of cloud
+// storage objects is ignored. CompressionAuto, the default, detects the format. Each object of a cloud storage prefix
+// is decompressed as the format given, and a byte range can only be read with CompressionNone or CompressionAuto.
+func WithCompression(compression Compression) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if !validCompression(compression) {
+			return fmt.Errorf("unsupported compression %s, expected auto, none, gzip, zstd, bzip2 or lz4", compression)
+		}
+
+		afr.readAs = compression
+
+		return nil
+	}
+}
+
+// WithReadBufferSize sets the size of the chunks the source is read in, in place of the buffer size the AsyncReader
+// was created with. It overrides WithAutoBufferSize, wherever the two are given.
+func WithReadBufferSize(size int) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if size <= 0 {
+			return fmt.Errorf("read buffer size must be positive, got %d", size)
+		}
+
+		afr.bufferSize = size
+		afr.fixedSize = true
+
+		if afr.limiter != nil {
+			afr.limiter.SetBurst(size)
+		}
+
+		return nil
+	}
+}
+
+// WithAutoBufferSize tunes the size of the chunks the source is read in, rather than leaving choosing one to guesswork.
+// Reading starts with chunks of the AsyncReader's buffer size, which are doubled, up to maxSize bytes, while reads
+// consistently fill them and the throughput of the reads keeps rising, as it does for high latency sources such as
+// cloud storage, whose chunks are then filled before they are queued. A source which only returns a little at a time,
+// or which is no faster read in larger chunks, keeps smaller chunks. The size is only tuned during the first few
+// seconds of reading. It is off by default, and is ignored when a size is given with WithReadBufferSize or
+// WithGCSReadBufferSize.
+func WithAutoBufferSize(maxSize int) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if maxSize <= 0 {
+			return fmt.Errorf("auto buffer size limit must be positive, got %d", maxSize)
+		}
+
+		afr.tuner = newBufferTuner(maxSize)
+
+		return nil
+	}
+}
+
+// WithFillBuffers fills each chunk up to the buffer size before it is queued, reading the source again until it is
+// full, rather than queueing whatever each read returned. Sources which return a little at a time, such as
+// decompressors and TLS connections, otherwise hand the parser many small chunks, each with its own overhead. The last
+// chunk, ending at the end of the source, is queued however short it is, as is the data read before a read fails or
+// returns nothing, as a followed file does at its end. The read timeout applies to filling each chunk. It is off by
+// default, leaving cloud storage objects to be filled when their chunks are tuned or WithGCSReadBufferSize is set.
+func WithFillBuffers() AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		afr.fill = true
+
+		return nil
+	}
+}
+
+// WithLeaveFileOpen leaves the file passed to AsyncReaderFromFileHandle open once reading stops, for the caller to
+// close once the AsyncReader has been closed, rather than the AsyncReader taking ownership of it. Other sources are
+// unaffected.
+func WithLeaveFileOpen() AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		afr.leaveOpen = true
+
+		return nil
+	}
+}
+
+// WithCloudClient reads cloud storage objects with c, so that buckets it has already opened, and their connections, are
+// reused across AsyncReaders. c isn't closed along with the AsyncReader, the caller closes it once every AsyncReader
+// using it has been closed. The GCS options are ignored, as c has its own. When it isn't set each AsyncReader reading
+// from cloud storage creates its own client, which is closed along with it.
+func WithCloudClient(c *cloud.Client) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		afr.cloud = c
+
+		return nil
+	}
+}
+
+// WithGCSUserProject sets the project billed for reads from requester pays Google Cloud Storage buckets. When it isn't
+// set the project is taken from the JSPLIT_GCS_USER_PROJECT environment variable.
+func WithGCSUserProject(project string) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		afr.cloudOpts.GCSUserProject = project
+
+		return nil
+	}
+}
+
+// WithGCSReadBufferSize sets the size of the chunks Google Cloud Storage objects are read in, in place of the buffer
+// size the AsyncReader was created with, and fills each chunk before it is queued rather than queueing whatever each
+// network read returned, which for a download is often only a few kilobytes. Fewer, larger chunks raise the
+// throughput of long sequential reads, and 8MB to 16MB, with a queue depth of 4 to 8, suits most large objects. The
+// read timeout applies to filling each chunk. A size of 0, the default, reads objects as other sources are read.
+func WithGCSReadBufferSize(size int) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if size < 0 {
+			return fmt.Errorf("GCS read buffer size must not be negative, got %d", size)
+		}
+
+		afr.gcsChunk = size
+
+		return nil
+	}
+}
+
+// WithGCSCredentialsFile sets the credentials JSON file used to authenticate reads from Google Cloud Storage in place of
+// application default credentials
+func WithGCSCredentialsFile(path string) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		afr.cloudOpts.GCSCredentialsFile = path
+
+		return nil
+	}
+}
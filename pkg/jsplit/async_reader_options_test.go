@@ -0,0 +1,311 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithQueueDepth(t *testing.T) {
+	rd, err := AsyncReaderFromReader(bytes.NewReader([]byte("test")), 8)
+	require.NoError(t, err)
+	require.Equal(t, DefaultQueueDepth, cap(rd.readCh))
+
+	rd, err = AsyncReaderFromReader(bytes.NewReader([]byte("test data")), 1, WithQueueDepth(1))
+	require.NoError(t, err)
+	require.Equal(t, 1, cap(rd.readCh))
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, "test data", string(readAll(t, ctx, rd)))
+
+	for _, queueDepth := range []int{0, -1} {
+		_, err = AsyncReaderFromReader(bytes.NewReader([]byte("test")), 8, WithQueueDepth(queueDepth))
+		require.Error(t, err)
+	}
+}
+
+func TestWithMaxInFlightBytes(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+
+	// without a limit the whole input is queued, as it fits in the queue
+	rd, err := AsyncReaderFromReader(bytes.NewReader(data), 10)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Eventually(t, func() bool { return rd.IsClosed() }, 5*time.Second, time.Millisecond)
+	require.Equal(t, int64(100), rd.InFlightBytes())
+	require.Equal(t, string(data), string(readAll(t, ctx, rd)))
+	require.Zero(t, rd.InFlightBytes())
+	require.Equal(t, int64(100), rd.PeakInFlightBytes())
+
+	// reading pauses once queueing another chunk would exceed the limit
+	rd, err = AsyncReaderFromReader(bytes.NewReader(data), 10, WithMaxInFlightBytes(25))
+	require.NoError(t, err)
+
+	// the first chunks can be short, as the input is peeked at for compression and byte order marks
+	ctx = rd.Start(context.Background())
+	require.Eventually(t, func() bool { return rd.InFlightBytes() > 15 }, 5*time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	require.LessOrEqual(t, rd.InFlightBytes(), int64(25))
+	require.False(t, rd.IsClosed())
+
+	require.Equal(t, string(data), string(readAll(t, ctx, rd)))
+	require.LessOrEqual(t, rd.PeakInFlightBytes(), int64(25))
+
+	// a chunk larger than the limit is still queued on its own
+	rd, err = AsyncReaderFromReader(bytes.NewReader(data), 10, WithMaxInFlightBytes(5))
+	require.NoError(t, err)
+
+	ctx = rd.Start(context.Background())
+	require.Equal(t, string(data), string(readAll(t, ctx, rd)))
+	require.Equal(t, int64(10), rd.PeakInFlightBytes())
+
+	// closing a paused reader stops it
+	rd, err = AsyncReaderFromReader(bytes.NewReader(data), 10, WithMaxInFlightBytes(10))
+	require.NoError(t, err)
+
+	rd.Start(context.Background())
+	require.Eventually(t, func() bool { return rd.InFlightBytes() > 0 }, 5*time.Second, time.Millisecond)
+	require.NoError(t, rd.Close())
+
+	_, err = AsyncReaderFromReader(bytes.NewReader(data), 10, WithMaxInFlightBytes(-1))
+	require.Error(t, err)
+}
+
+func TestWithReadTimeout(t *testing.T) {
+	rd, err := AsyncReaderFromReader(bytes.NewReader([]byte("test")), 8)
+	require.NoError(t, err)
+	require.Zero(t, rd.timeout)
+
+	rd, err = AsyncReaderFromReader(bytes.NewReader([]byte("test data")), 1, WithReadTimeout(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, time.Second, rd.timeout)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, "test data", string(readAll(t, ctx, rd)))
+
+	_, err = AsyncReaderFromReader(bytes.NewReader([]byte("test")), 8, WithReadTimeout(-time.Second))
+	require.Error(t, err)
+}
+
+func TestWithMaxReadRate(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 400)
+
+	rd, err := AsyncReaderFromReader(bytes.NewReader(data), 100)
+	require.NoError(t, err)
+	require.Nil(t, rd.limiter)
+
+	// the first buffer is read at once, and the other 3900 bytes at 10000 bytes a second
+	rd, err = AsyncReaderFromReader(bytes.NewReader(data), 100, WithMaxReadRate(10000))
+	require.NoError(t, err)
+
+	start := time.Now()
+	ctx := rd.Start(context.Background())
+	require.Equal(t, string(data), string(readAll(t, ctx, rd)))
+
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, 350*time.Millisecond)
+	require.Less(t, elapsed, 2*time.Second)
+
+	// closing a paced reader stops it
+	rd, err = AsyncReaderFromReader(bytes.NewReader(data), 100, WithMaxReadRate(100))
+	require.NoError(t, err)
+
+	rd.Start(context.Background())
+	require.Eventually(t, func() bool { return rd.BytesRead() > 0 }, 5*time.Second, time.Millisecond)
+	require.NoError(t, rd.Close())
+	require.Less(t, rd.BytesRead(), int64(len(data)))
+
+	_, err = AsyncReaderFromReader(bytes.NewReader(data), 100, WithMaxReadRate(-1))
+	require.EqualError(t, err, "max read rate must not be negative, got -1")
+}
+
+func TestWithGCSUserProject(t *testing.T) {
+	rd, err := AsyncReaderFromReader(bytes.NewReader([]byte("test")), 8, WithGCSUserProject("project"))
+	require.NoError(t, err)
+	require.Equal(t, "project", rd.cloudOpts.GCSUserProject)
+}
+
+func TestWithGCSCredentialsFile(t *testing.T) {
+	rd, err := AsyncReaderFromReader(bytes.NewReader([]byte("test")), 8, WithGCSCredentialsFile("creds.json"))
+	require.NoError(t, err)
+	require.Equal(t, "creds.json", rd.cloudOpts.GCSCredentialsFile)
+}
+
+func TestWithCloudClient(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"part-01.json", "part-02.json"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600))
+	}
+
+	client := cloud.NewClient(nil)
+	defer client.Close()
+
+	base := "file://" + filepath.ToSlash(dir)
+
+	rd, err := AsyncReaderFromFile(base+"/part-*.json", 4, WithCloudClient(client))
+	require.NoError(t, err)
+	require.Equal(t, "part-01.jsonpart-02.json", string(readAll(t, rd.Start(context.Background()), rd)))
+	require.NoError(t, rd.Close())
+
+	// the client belongs to the caller, so it can still be used once the reader has been closed
+	rd, err = AsyncReaderFromFile(base+"/part-02.json", 4, WithCloudClient(client))
+	require.NoError(t, err)
+	require.Equal(t, "part-02.json", string(readAll(t, rd.Start(context.Background()), rd)))
+	require.NoError(t, rd.Close())
+}
+
+func TestWithGCSReadBufferSize(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id": 1}`), 100)
+	client := fakeCloud(t, map[string][]byte{"gs://bucket/data.json": data, "s3://bucket/data.json": data})
+
+	chunks := func(uri string) []int {
+		rd, err := AsyncReaderFromFile(uri, 100, WithCloudClient(client), WithGCSReadBufferSize(256))
+		require.NoError(t, err)
+
+		defer rd.Close()
+
+		ctx := rd.Start(context.Background())
+
+		var (
+			sizes []int
+			read  []byte
+		)
+
+		for {
+			buf, err := rd.Read(ctx)
+			if err == io.EOF {
+				require.Equal(t, data, read)
+				return sizes
+			}
+
+			require.NoError(t, err)
+
+			sizes = append(sizes, len(buf))
+			read = append(read, buf...)
+		}
+	}
+
+	// Google Cloud Storage objects are read in filled chunks of the size set, while the chunks of other objects hold up
+	// to the reader's buffer size, as much as each read returned
+	require.Equal(t, []int{256, 256, 256, 132}, chunks("gs://bucket/data.json"))
+
+	sizes := chunks("s3://bucket/data.json")
+	require.Greater(t, len(sizes), 9)
+
+	for _, size := range sizes {
+		require.LessOrEqual(t, size, 100)
+	}
+
+	// each chunk is filled however little each read of the object returns
+	fr := fillingReader{iotest.OneByteReader(bytes.NewReader(data))}
+	buf := make([]byte, 256)
+
+	n, err := fr.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 256, n)
+
+	_, err = AsyncReaderFromFile("gs://bucket/data.json", 100, WithGCSReadBufferSize(-1))
+	require.EqualError(t, err, "GCS read buffer size must not be negative, got -1")
+}
+
+func TestWithFillBuffers(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id": 1}`), 30)
+
+	// chunks reads src in chunks of up to 100 bytes, returning their sizes, the data read and the error which ended
+	// reading
+	chunks := func(src io.Reader, opts ...AsyncReaderOption) ([]int, []byte, error) {
+		rd, err := AsyncReaderFromReader(src, 100, opts...)
+		require.NoError(t, err)
+
+		defer rd.Close()
+
+		ctx := rd.Start(context.Background())
+
+		var (
+			sizes []int
+			read  []byte
+		)
+
+		for {
+			buf, err := rd.Read(ctx)
+			if err != nil {
+				return sizes, read, err
+			}
+
+			sizes = append(sizes, len(buf))
+			read = append(read, buf...)
+		}
+	}
+
+	// a source returning a byte at a time is read in a chunk per byte, after the first few peeked at to detect its
+	// compression
+	sizes, read, err := chunks(iotest.OneByteReader(bytes.NewReader(data)))
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, data, read)
+	require.Greater(t, len(sizes), len(data)-5)
+
+	// unless each chunk is filled, apart from the last, which ends with the source
+	sizes, read, err = chunks(iotest.OneByteReader(bytes.NewReader(data)), WithFillBuffers())
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, data, read)
+	require.Equal(t, []int{100, 100, 70}, sizes)
+
+	// the end of the source can arrive along with the last of its data, part way through filling a chunk
+	sizes, read, err = chunks(iotest.DataErrReader(iotest.OneByteReader(bytes.NewReader(data))), WithFillBuffers())
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, data, read)
+	require.Equal(t, []int{100, 100, 70}, sizes)
+
+	// the data read before a failure is queued ahead of the error
+	failing := io.MultiReader(iotest.OneByteReader(bytes.NewReader(data[:150])), iotest.ErrReader(errors.New("boom")))
+
+	sizes, read, err = chunks(failing, WithFillBuffers())
+	require.EqualError(t, err, "boom at byte offset 150")
+	require.Equal(t, data[:150], read)
+	require.Equal(t, []int{100, 50}, sizes)
+}
+
+// BenchmarkGCSReadBufferSize reads a large Google Cloud Storage object from memory in chunks of various sizes,
+// measuring the overhead of handing each chunk to the consumer
+func BenchmarkGCSReadBufferSize(b *testing.B) {
+	data := bytes.Repeat([]byte(`{"id": 1, "name": "benchmark"}`+"\n"), 2*1024*1024)
+	client := fakeCloud(b, map[string][]byte{"gs://bucket/data.json": data})
+
+	for _, size := range []int{0, 256 * 1024, 1024 * 1024, 8 * 1024 * 1024, 16 * 1024 * 1024} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+
+			for i := 0; i < b.N; i++ {
+				rd, err := AsyncReaderFromFile("gs://bucket/data.json", 32*1024, WithCloudClient(client),
+					WithGCSReadBufferSize(size))
+				require.NoError(b, err)
+
+				ctx := rd.Start(context.Background())
+
+				for {
+					buf, err := rd.Read(ctx)
+					if err == io.EOF {
+						break
+					}
+
+					require.NoError(b, err)
+					rd.ReleaseBuffer(buf)
+				}
+
+				require.NoError(b, rd.Close())
+			}
+		})
+	}
+}
@@ -2,13 +2,28 @@ package jsplit
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/danielchalef/jsplit/pkg/cloud"
 	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
 )
 
 func TestAsyncReaderReadsAll(t *testing.T) {
@@ -109,12 +124,890 @@ func TestReadError(t *testing.T) {
 	require.NoError(t, err)
 	ctx := rd.Start(context.Background())
 
-	for i := 0; i < errAfterNReads; i++ {
-		_, err = rd.Read(ctx)
+	var read []byte
+
+	for {
+		var buf []byte
+
+		buf, err = rd.Read(ctx)
 		if err != nil {
 			break
 		}
+
+		read = append(read, buf...)
+	}
+
+	require.ErrorIs(t, err, expectedErr)
+
+	// everything read before the failure is still delivered, and the error says how far reading got
+	require.Equal(t, rd.BytesRead(), int64(len(read)))
+	require.Equal(t, rd.Offset(), int64(len(read)))
+	require.Equal(t, buffer[:len(read)], read)
+
+	var re *ReadError
+	require.ErrorAs(t, err, &re)
+	require.Equal(t, int64(len(read)), re.Offset)
+	require.EqualError(t, err, fmt.Sprintf("test error at byte offset %d", len(read)))
+
+	// later reads keep returning the error
+	_, err = rd.Read(ctx)
+	require.ErrorIs(t, err, expectedErr)
+	require.ErrorIs(t, ctx.Err(), expectedErr)
+}
+
+// chunkedReader returns its chunks one per read, returning err along with the last chunk
+type chunkedReader struct {
+	chunks []string
+	err    error
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if len(cr.chunks) == 0 {
+		return 0, cr.err
+	}
+
+	n := copy(p, cr.chunks[0])
+	if n < len(cr.chunks[0]) {
+		cr.chunks[0] = cr.chunks[0][n:]
+		return n, nil
+	}
+
+	cr.chunks = cr.chunks[1:]
+	if len(cr.chunks) == 0 {
+		return n, cr.err
+	}
+
+	return n, nil
+}
+
+func TestReadErrorWithData(t *testing.T) {
+	tests := map[string][]string{
+		"several reads":                  {`{"list": `, `[1, 2`, `, 3]`},
+		"a single read":                  {`{"list": [1, 2, 3]}`},
+		"a source shorter than the peek": {`{`, `"`},
+		"a read which fills the peek":    {`{"a"`},
+	}
+
+	for name, chunks := range tests {
+		t.Run(name, func(t *testing.T) {
+			var expected string
+			for _, chunk := range chunks {
+				expected += chunk
+			}
+
+			// data returned along with io.EOF is read
+			rd, err := AsyncReaderFromReader(&chunkedReader{chunks: append([]string(nil), chunks...), err: io.EOF}, 3)
+			require.NoError(t, err)
+			require.Equal(t, expected, string(readAll(t, rd.Start(context.Background()), rd)))
+
+			// data returned along with any other error is read ahead of the error
+			rd, err = AsyncReaderFromReader(&chunkedReader{chunks: append([]string(nil), chunks...),
+				err: io.ErrUnexpectedEOF}, 3)
+			require.NoError(t, err)
+
+			ctx := rd.Start(context.Background())
+
+			var read []byte
+
+			for {
+				var buf []byte
+
+				buf, err = rd.Read(ctx)
+				if err != nil {
+					break
+				}
+
+				read = append(read, buf...)
+			}
+
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			require.Equal(t, expected, string(read))
+			require.Equal(t, int64(len(expected)), rd.BytesRead())
+		})
+	}
+}
+
+func TestReadErrorClosesChannel(t *testing.T) {
+	// once its chunks run out the source fails every read with the same error
+	expectedErr := errors.New("permanent error")
+
+	rd, err := AsyncReaderFromReader(&chunkedReader{chunks: []string{`{"list": `, `[1, 2]}`}, err: expectedErr}, 32)
+	require.NoError(t, err)
+	rd.Start(context.Background())
+
+	// a consumer receiving from the channel rather than waiting on the context sees it closed once reading fails
+	var read []byte
+
+	timeout := time.After(5 * time.Second)
+
+	for closed := false; !closed; {
+		select {
+		case buf, ok := <-rd.readCh:
+			read = append(read, buf...)
+			closed = !ok
+		case <-timeout:
+			t.Fatal("readCh wasn't closed after a read error")
+		}
+	}
+
+	require.Equal(t, `{"list": [1, 2]}`, string(read))
+	require.True(t, rd.IsClosed())
+
+	// the error is returned without waiting on the context
+	_, err = rd.Read(context.Background())
+	require.ErrorIs(t, err, expectedErr)
+	require.NoError(t, rd.Close())
+}
+
+func TestReadErrorWithParentContext(t *testing.T) {
+	expectedErr := fmt.Errorf("read tcp: %w", syscall.ECONNRESET)
+	er := &ErroringReader{
+		Reader:         bytes.NewReader(make([]byte, 1024)),
+		err:            expectedErr,
+		errAfterNReads: 2,
+	}
+
+	rd, err := AsyncReaderFromReader(er, 32)
+	require.NoError(t, err)
+
+	// the failure is reported rather than the context error, even to callers not using the context returned by Start
+	ctx := context.Background()
+	_ = rd.Start(ctx)
+
+	for err == nil {
+		_, err = rd.Read(ctx)
+	}
+
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+	require.NotErrorIs(t, err, context.Canceled)
+}
+
+// stallingReader returns its data and then blocks until unblock is closed
+type stallingReader struct {
+	data    []byte
+	unblock chan struct{}
+}
+
+func (sr *stallingReader) Read(p []byte) (int, error) {
+	if len(sr.data) > 0 {
+		n := copy(p, sr.data)
+		sr.data = sr.data[n:]
+
+		return n, nil
+	}
+
+	<-sr.unblock
+
+	return 0, io.EOF
+}
+
+func TestAsyncReaderReadTimeout(t *testing.T) {
+	sr := &stallingReader{data: []byte("test data"), unblock: make(chan struct{})}
+	defer close(sr.unblock)
+
+	rd, err := AsyncReaderFromReader(sr, 4, WithReadTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+
+	var read []byte
+
+	for err == nil {
+		var buf []byte
+
+		buf, err = rd.Read(ctx)
+		read = append(read, buf...)
+	}
+
+	// the data read before the source stalled is delivered ahead of the timeout
+	require.Equal(t, "test data", string(read))
+	require.ErrorIs(t, err, ErrReadTimeout)
+	require.ErrorIs(t, ctx.Err(), ErrReadTimeout)
+	require.NoError(t, rd.Close())
+}
+
+// pipeReader returns neither data nor an error for its first empty reads, as a named pipe can before its writer has
+// written anything, and then returns its data. Once its data has been read it returns nothing again while it is open,
+// and io.EOF otherwise.
+type pipeReader struct {
+	empty int
+	data  []byte
+	open  bool
+	reads int
+}
+
+func (pr *pipeReader) Read(p []byte) (int, error) {
+	pr.reads++
+
+	if pr.empty > 0 {
+		pr.empty--
+		return 0, nil
+	}
+
+	if len(pr.data) == 0 {
+		if pr.open {
+			return 0, nil
+		}
+
+		return 0, io.EOF
+	}
+
+	n := copy(p, pr.data)
+	pr.data = pr.data[n:]
+
+	return n, nil
+}
+
+func TestAsyncReaderEmptyReads(t *testing.T) {
+	const data = `{"list": [1, 2, 3]}`
+
+	start := time.Now()
+	pr := &pipeReader{empty: 5, data: []byte(data)}
+
+	rd, err := AsyncReaderFromReader(pr, 8)
+	require.NoError(t, err)
+	require.Equal(t, data, string(readAll(t, rd.Start(context.Background()), rd)))
+
+	// reading pauses after each read which returns nothing, rather than spinning, and the reads returning nothing
+	// aren't mistaken for the end of the data
+	require.GreaterOrEqual(t, time.Since(start), 31*time.Millisecond)
+	require.Less(t, pr.reads, 15)
+
+	// a source which stops returning data times out as one whose reads block does
+	pr = &pipeReader{data: []byte(data), open: true}
+
+	rd, err = AsyncReaderFromReader(pr, 8, WithReadTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+
+	var read []byte
+
+	for err == nil {
+		var buf []byte
+
+		buf, err = rd.Read(ctx)
+		read = append(read, buf...)
+	}
+
+	require.Equal(t, data, string(read))
+	require.ErrorIs(t, err, ErrReadTimeout)
+	require.Less(t, pr.reads, 20)
+	require.NoError(t, rd.Close())
+}
+
+// fakeCloud returns a *cloud.Client reading the objects, by URI such as gs://bucket/data.json, from in-memory buckets
+// in place of cloud storage. Buckets without any objects fail to open.
+func fakeCloud(t testing.TB, objects map[string][]byte) *cloud.Client {
+	return fakeCloudWithOptions(t, objects, nil)
+}
+
+// fakeCloudWithOptions is fakeCloud writing the objects with the writer options in opts, if any, e.g. to set their
+// metadata
+func fakeCloudWithOptions(t testing.TB, objects map[string][]byte, opts map[string]*blob.WriterOptions) *cloud.Client {
+	ctx := context.Background()
+	t.Setenv(cloud.GCSUserProjectEnv, "")
+
+	buckets := make(map[string]*blob.Bucket)
+
+	for uri, data := range objects {
+		bucketURL, key, err := cloud.SplitBlobURI(uri)
+		require.NoError(t, err)
+
+		if buckets[bucketURL] == nil {
+			buckets[bucketURL] = memblob.OpenBucket(nil)
+		}
+
+		require.NoError(t, buckets[bucketURL].WriteAll(ctx, key, data, opts[uri]))
+	}
+
+	c := cloud.NewClientWithOpener(nil, func(ctx context.Context, bucketURL string) (*blob.Bucket, error) {
+		b, ok := buckets[bucketURL]
+		if !ok {
+			return nil, fmt.Errorf("bucket %s not found", bucketURL)
+		}
+
+		return b, nil
+	})
+
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+func readAll(t *testing.T, ctx context.Context, rd *AsyncReader) []byte {
+	var read []byte
+
+	for {
+		newlyRead, err := rd.Read(ctx)
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		read = append(read, newlyRead...)
+	}
+
+	return read
+}
+
+func TestAsyncReaderFromFileClosesSource(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	tempDir := t.TempDir()
+
+	plainFile := filepath.Join(tempDir, "plain.json")
+	require.NoError(t, os.WriteFile(plainFile, []byte(contents), 0o644))
+
+	gzFile := filepath.Join(tempDir, "compressed.json.gz")
+	gzBuf := bytes.NewBuffer(nil)
+	gzWr := gzip.NewWriter(gzBuf)
+	_, err := gzWr.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, gzWr.Close())
+	require.NoError(t, os.WriteFile(gzFile, gzBuf.Bytes(), 0o644))
+
+	for _, filename := range []string{plainFile, gzFile} {
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			rd, err := AsyncReaderFromFile(filename, 4)
+			require.NoError(t, err)
+
+			f, ok := rd.closers[len(rd.closers)-1].(*os.File)
+			require.True(t, ok)
+
+			ctx := rd.Start(context.Background())
+			require.Equal(t, contents, string(readAll(t, ctx, rd)))
+
+			// closing an already closed file fails with os.ErrClosed
+			require.ErrorIs(t, f.Close(), os.ErrClosed)
+		})
+	}
+}
+
+// endlessReader never returns io.EOF
+type endlessReader struct{}
+
+func (endlessReader) Read(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func TestAsyncReaderExitsOnCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	rd, err := AsyncReaderFromReader(endlessReader{}, 32)
+	require.NoError(t, err)
+	ctx = rd.Start(ctx)
+
+	// read a single chunk and then walk away leaving the channel full
+	_, err = rd.Read(ctx)
+	require.NoError(t, err)
+
+	cancel()
+
+	// give the background goroutine time to notice the cancellation
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+// cancelledContext returns a context which is already done with err
+func cancelledContext(t *testing.T, err error) context.Context {
+	if err == context.DeadlineExceeded {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		t.Cleanup(cancel)
+
+		return ctx
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return ctx
+}
+
+// countingReader counts its reads of its data
+type countingReader struct {
+	io.Reader
+	reads  int32
+	closed bool
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	atomic.AddInt32(&cr.reads, 1)
+	return cr.Reader.Read(p)
+}
+
+func (cr *countingReader) Close() error {
+	cr.closed = true
+	return nil
+}
+
+func TestAsyncReaderStartCancelled(t *testing.T) {
+	for _, expected := range []error{context.Canceled, context.DeadlineExceeded} {
+		before := runtime.NumGoroutine()
+
+		cr := &countingReader{Reader: strings.NewReader(`{"list": [1, 2, 3]}`)}
+
+		rd, err := AsyncReaderFromReader(cr, 8)
+		require.NoError(t, err)
+
+		rd.closers = append(rd.closers, cr)
+		peeked := atomic.LoadInt32(&cr.reads)
+
+		// the source is closed without being read beyond the bytes peeked at when the reader was created, and every
+		// read returns the context's error
+		parent := cancelledContext(t, expected)
+		ctx := rd.Start(parent)
+		require.ErrorIs(t, ctx.Err(), expected)
+
+		for _, readCtx := range []context.Context{ctx, parent, context.Background()} {
+			_, err = rd.Read(readCtx)
+			require.ErrorIs(t, err, expected)
+		}
+
+		require.Equal(t, peeked, atomic.LoadInt32(&cr.reads))
+		require.True(t, cr.closed)
+		require.True(t, rd.IsClosed())
+		require.NoError(t, rd.Close())
+
+		// nothing is left running
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		require.LessOrEqual(t, runtime.NumGoroutine(), before)
+	}
+}
+
+func TestAsyncReaderClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	rd, err := AsyncReaderFromReader(endlessReader{}, 32)
+	require.NoError(t, err)
+	ctx := rd.Start(context.Background())
+
+	_, err = rd.Read(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, rd.Close())
+	require.True(t, rd.IsClosed())
+	require.NoError(t, rd.Close())
+
+	_, err = rd.Read(ctx)
+	require.ErrorIs(t, err, ErrReaderClosed)
+	_, err = rd.Read(context.Background())
+	require.ErrorIs(t, err, ErrReaderClosed)
+
+	// the reading goroutine has exited by the time Close returns
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestAsyncReaderCloseBeforeStart(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"list": [1, 2, 3]}`), 0o644))
+
+	rd, err := AsyncReaderFromFile(filename, 4)
+	require.NoError(t, err)
+
+	f, ok := rd.closers[len(rd.closers)-1].(*os.File)
+	require.True(t, ok)
+
+	require.NoError(t, rd.Close())
+	require.ErrorIs(t, f.Close(), os.ErrClosed)
+
+	ctx := rd.Start(context.Background())
+	_, err = rd.Read(ctx)
+	require.ErrorIs(t, err, ErrReaderClosed)
+}
+
+func TestAsyncReaderCloseConcurrentWithRead(t *testing.T) {
+	rd, err := AsyncReaderFromReader(endlessReader{}, 32, WithQueueDepth(1))
+	require.NoError(t, err)
+	ctx := rd.Start(context.Background())
+
+	errs := make(chan error)
+
+	for i := 0; i < 4; i++ {
+		go func() {
+			var err error
+			for err == nil {
+				_, err = rd.Read(ctx)
+			}
+			errs <- err
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, rd.Close())
+
+	for i := 0; i < 4; i++ {
+		require.ErrorIs(t, <-errs, ErrReaderClosed)
+	}
+}
+
+func TestAsyncReaderCloseAfterEOF(t *testing.T) {
+	rd, err := AsyncReaderFromReader(bytes.NewReader([]byte("test")), 8)
+	require.NoError(t, err)
+	ctx := rd.Start(context.Background())
+
+	require.Equal(t, "test", string(readAll(t, ctx, rd)))
+	require.NoError(t, rd.Close())
+
+	_, err = rd.Read(ctx)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestAsyncReaderInvalidBufferSize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{}`), 0o644))
+
+	for _, bufferSize := range []int{0, -1} {
+		_, err := AsyncReaderFromReader(bytes.NewReader([]byte(`{}`)), bufferSize)
+		require.EqualError(t, err, fmt.Sprintf("bufferSize must be positive, got %d", bufferSize))
+
+		_, err = AsyncReaderFromFile(filename, bufferSize)
+		require.Error(t, err)
+
+		_, err = AsyncReaderFromFiles([]string{filename}, bufferSize)
+		require.Error(t, err)
+	}
+}
+
+func TestAsyncReaderFromS3InvalidURI(t *testing.T) {
+	for _, uri := range []string{"gs://bucket/file.json", "s3:///file.json", "/path/to/file.json"} {
+		_, err := AsyncReaderFromS3(uri, 1024)
+		require.Error(t, err, uri)
+	}
+}
+
+func TestAsyncReaderFromAzureBlob(t *testing.T) {
+	const doc = `{"name": "export", "users": [{"id": 1}, {"id": 2}]}`
+
+	data := gzipBytes(t, []byte(doc))
+
+	var requests int32
+
+	// an emulator of Azure Blob Storage holding one blob, read with the account key of a connection string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+
+		switch {
+		case !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey devstoreaccount1:"):
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path != "/devstoreaccount1/exports/users.json.gz":
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write(data)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv(cloud.AzureConnectionStringEnv,
+		fmt.Sprintf("AccountName=devstoreaccount1;AccountKey=a2V5;BlobEndpoint=%s/devstoreaccount1", srv.URL))
+
+	// compressed blobs are detected and decompressed as other sources are
+	rd, err := AsyncReaderFromFile("az://exports/users.json.gz", 1024)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, doc, string(readAll(t, ctx, rd)))
+	require.Equal(t, CompressionGzip, rd.compression)
+	require.NoError(t, rd.Close())
+
+	dir := filepath.Join(t.TempDir(), "users")
+	require.NoError(t, SplitFile(context.Background(), "az://exports/users.json.gz", dir, Options{}))
+	requireContents(t, filepath.Join(dir, "users_00.jsonl"), `{"id":1}`+"\n"+`{"id":2}`)
+
+	_, err = AsyncReaderFromAzureBlob("az://exports/missing.json", 1024)
+	require.EqualError(t, err, "az://exports/missing.json does not exist")
+
+	requestsMade := atomic.LoadInt32(&requests)
+
+	for _, uri := range []string{"az://exports", "gs://bucket/file.json", "https://example.com/file.json"} {
+		_, err = AsyncReaderFromAzureBlob(uri, 1024)
+		require.ErrorContains(t, err, "is not an az://container/blob URI", uri)
+	}
+
+	require.Equal(t, requestsMade, atomic.LoadInt32(&requests))
+}
+
+func TestAsyncReaderFromStdin(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	filename := filepath.Join(t.TempDir(), "stdin")
+	require.NoError(t, os.WriteFile(filename, gzipBytes(t, []byte(contents)), 0o644))
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+
+	defer f.Close()
+
+	stdin := os.Stdin
+	os.Stdin = f
+
+	defer func() {
+		os.Stdin = stdin
+	}()
+
+	rd, err := AsyncReaderFromFile("-", 8)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, contents, string(readAll(t, ctx, rd)))
+
+	// standard input is not closed
+	_, err = f.Stat()
+	require.NoError(t, err)
+}
+
+func TestAsyncReaderFromFileHandle(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	tempDir := t.TempDir()
+
+	plainFile := filepath.Join(tempDir, "plain.json")
+	require.NoError(t, os.WriteFile(plainFile, []byte(`skipped `+contents), 0o644))
+
+	gzFile := filepath.Join(tempDir, "compressed.json.gz")
+	require.NoError(t, os.WriteFile(gzFile, gzipBytes(t, []byte(contents)), 0o644))
+
+	// the file is read from its current offset, and compressed files are decompressed
+	f, err := os.Open(plainFile)
+	require.NoError(t, err)
+
+	_, err = f.Seek(int64(len("skipped ")), io.SeekStart)
+	require.NoError(t, err)
+
+	rd, err := AsyncReaderFromFileHandle(f, 4)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(contents)), rd.TotalSize())
+	require.Equal(t, contents, string(readAll(t, rd.Start(context.Background()), rd)))
+	require.NoError(t, rd.Close())
+
+	// the file is closed along with the AsyncReader
+	require.ErrorIs(t, f.Close(), os.ErrClosed)
+
+	// unless it is left open for the caller
+	f, err = os.Open(gzFile)
+	require.NoError(t, err)
+
+	defer f.Close()
+
+	rd, err = AsyncReaderFromFileHandle(f, 4, WithLeaveFileOpen())
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), rd.TotalSize())
+	require.Equal(t, contents, string(readAll(t, rd.Start(context.Background()), rd)))
+	require.NoError(t, rd.Close())
+
+	_, err = f.Stat()
+	require.NoError(t, err)
+
+	// the size of a pipe isn't known
+	pr, pw, err := os.Pipe()
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = pw.Write([]byte(contents))
+		_ = pw.Close()
+	}()
+
+	rd, err = AsyncReaderFromFileHandle(pr, 4)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), rd.TotalSize())
+	require.Equal(t, contents, string(readAll(t, rd.Start(context.Background()), rd)))
+	require.NoError(t, rd.Close())
+
+	_, err = AsyncReaderFromFileHandle(f, 4, WithByteRange(1, 2))
+	require.ErrorContains(t, err, "a byte range can't be read from an open file")
+}
+
+func TestAsyncReaderReleaseBuffer(t *testing.T) {
+	const size = 64 * 1024
+
+	buffer := make([]byte, size)
+	_, err := rand.Read(buffer)
+	require.NoError(t, err)
+
+	rd, err := AsyncReaderFromReader(bytes.NewReader(buffer), 1024, WithQueueDepth(1))
+	require.NoError(t, err)
+	ctx := rd.Start(context.Background())
+
+	read := make([]byte, 0, size)
+	for {
+		chunk, err := rd.Read(ctx)
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		read = append(read, chunk...)
+		rd.ReleaseBuffer(chunk)
 	}
 
-	require.Equal(t, err, expectedErr)
+	require.Equal(t, buffer, read)
+}
+
+func benchmarkAsyncReader(b *testing.B, release bool) {
+	const size = 16 * 1024 * 1024
+
+	buffer := make([]byte, size)
+	b.SetBytes(size)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		rd, err := AsyncReaderFromReader(bytes.NewReader(buffer), 64*1024)
+		require.NoError(b, err)
+		ctx := rd.Start(context.Background())
+
+		for {
+			chunk, err := rd.Read(ctx)
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(b, err)
+
+			if release {
+				rd.ReleaseBuffer(chunk)
+			}
+		}
+	}
+}
+
+func BenchmarkAsyncReader(b *testing.B) {
+	benchmarkAsyncReader(b, false)
+}
+
+func BenchmarkAsyncReaderReleaseBuffer(b *testing.B) {
+	benchmarkAsyncReader(b, true)
+}
+
+func TestAsyncReaderProgress(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	tempDir := t.TempDir()
+
+	plainFile := filepath.Join(tempDir, "plain.json")
+	require.NoError(t, os.WriteFile(plainFile, []byte(contents), 0o644))
+
+	gzFile := filepath.Join(tempDir, "compressed.json.gz")
+	require.NoError(t, os.WriteFile(gzFile, gzipBytes(t, []byte(contents)), 0o644))
+
+	tests := []struct {
+		filename     string
+		expectedSize int64
+	}{
+		{filename: plainFile, expectedSize: int64(len(contents))},
+		{filename: gzFile, expectedSize: -1},
+	}
+
+	for _, test := range tests {
+		t.Run(filepath.Base(test.filename), func(t *testing.T) {
+			rd, err := AsyncReaderFromFile(test.filename, 4)
+			require.NoError(t, err)
+			require.Equal(t, test.expectedSize, rd.TotalSize())
+			require.Equal(t, int64(0), rd.BytesRead())
+
+			ctx := rd.Start(context.Background())
+			readAll(t, ctx, rd)
+
+			require.Equal(t, int64(len(contents)), rd.BytesRead())
+		})
+	}
+
+	rd, err := AsyncReaderFromReader(bytes.NewReader([]byte(contents)), 4)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), rd.TotalSize())
+}
+
+func TestAsyncReaderAsReader(t *testing.T) {
+	data := make([]byte, 10_000)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	// io.Copy writes each chunk with WriteTo, and short reads are served from what is left of the last chunk
+	for _, onlyReader := range []bool{false, true} {
+		rd, err := AsyncReaderFromReader(bytes.NewReader(data), 64)
+		require.NoError(t, err)
+
+		r := rd.AsReader(rd.Start(context.Background()))
+		if onlyReader {
+			r = io.LimitReader(r, int64(len(data)))
+		}
+
+		var buf bytes.Buffer
+
+		n, err := io.CopyBuffer(&buf, r, make([]byte, 25))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), n)
+		require.Equal(t, data, buf.Bytes())
+
+		require.NoError(t, rd.Close())
+	}
+
+	// it can be decoded by encoding/json
+	rd, err := AsyncReaderFromReader(strings.NewReader(`{"a": [1, 2]} {"b": "c"}`), 4)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	dec := json.NewDecoder(rd.AsReader(rd.Start(context.Background())))
+
+	var values []map[string]interface{}
+
+	for dec.More() {
+		var value map[string]interface{}
+		require.NoError(t, dec.Decode(&value))
+
+		values = append(values, value)
+	}
+
+	require.Equal(t, []map[string]interface{}{{"a": []interface{}{1.0, 2.0}}, {"b": "c"}}, values)
+}
+
+func TestAsyncReaderAsReaderErrors(t *testing.T) {
+	// data read before a failure is copied before the error is returned
+	expectedErr := errors.New("test error")
+	er := &ErroringReader{Reader: bytes.NewReader(make([]byte, 1024)), err: expectedErr, errAfterNReads: 2}
+
+	rd, err := AsyncReaderFromReader(er, 32)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+
+	n, err := io.Copy(&buf, rd.AsReader(rd.Start(context.Background())))
+	require.ErrorIs(t, err, expectedErr)
+	require.Equal(t, rd.BytesRead(), n)
+
+	// reads fail once the context is done, even with chunks queued
+	sr := &stallingReader{data: []byte("some data"), unblock: make(chan struct{})}
+
+	rd, err = AsyncReaderFromReader(sr, 4)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	// the reader is unblocked before it is closed
+	defer close(sr.unblock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := rd.AsReader(rd.Start(ctx))
+
+	p := make([]byte, 2)
+	_, err = io.ReadFull(r, p)
+	require.NoError(t, err)
+
+	cancel()
+
+	_, err = io.ReadFull(r, make([]byte, 3))
+	require.ErrorIs(t, err, context.Canceled)
 }
@@ -0,0 +1,89 @@
+package jsplit
+
+// The types and modes of the fields of BigQuery schemas
+const (
+	bigQueryString  = "STRING"
+	bigQueryInteger = "INTEGER"
+	bigQueryFloat   = "FLOAT"
+	bigQueryBoolean = "BOOLEAN"
+	bigQueryRecord  = "RECORD"
+	bigQueryJSON    = "JSON"
+
+	bigQueryNullable = "NULLABLE"
+	bigQueryRequired = "REQUIRED"
+	bigQueryRepeated = "REPEATED"
+)
+
+// bigQueryField is a column of a BigQuery schema, or a field of a RECORD column
+type bigQueryField struct {
+	Name   string          `json:"name"`
+	Type   string          `json:"type"`
+	Mode   string          `json:"mode"`
+	Fields []bigQueryField `json:"fields,omitempty"`
+}
+
+// bigQueryFields returns the BigQuery fields describing the fields of the objects observed for the node, in the order
+// they were first seen. Fields which were in every object and never null are REQUIRED.
+func (sn *schemaNode) bigQueryFields() []bigQueryField {
+	fields := make([]bigQueryField, 0, len(sn.fields))
+
+	for _, field := range sn.fields {
+		bf := field.node.bigQueryField(field.name)
+		if bf.Mode == bigQueryNullable && field.seen == sn.objects && field.node.types&schemaNull == 0 {
+			bf.Mode = bigQueryRequired
+		}
+
+		fields = append(fields, bf)
+	}
+
+	return fields
+}
+
+// bigQueryField returns the BigQuery field with the given name describing the values observed for the node. Arrays
+// are REPEATED fields of the type of their elements, objects are RECORDs of their fields, and numbers are INTEGERs
+// unless any weren't integers. Values of conflicting types, arrays of arrays, which BigQuery can't repeat, and objects
+// without fields are JSON, and values which were only ever null or empty arrays are STRINGs.
+func (sn *schemaNode) bigQueryField(name string) bigQueryField {
+	types := sn.types &^ schemaNull
+
+	if types == schemaArray {
+		if sn.items == nil || sn.items.types&^schemaNull == 0 {
+			return bigQueryField{Name: name, Type: bigQueryString, Mode: bigQueryRepeated}
+		}
+
+		if sn.items.types&^schemaNull == schemaArray {
+			return bigQueryField{Name: name, Type: bigQueryJSON, Mode: bigQueryNullable}
+		}
+
+		bf := sn.items.bigQueryField(name)
+		bf.Mode = bigQueryRepeated
+
+		return bf
+	}
+
+	bf := bigQueryField{Name: name, Mode: bigQueryNullable}
+
+	switch types {
+	case 0, schemaString:
+		bf.Type = bigQueryString
+	case schemaNumber:
+		bf.Type = bigQueryInteger
+		if sn.floats {
+			bf.Type = bigQueryFloat
+		}
+	case schemaBoolean:
+		bf.Type = bigQueryBoolean
+	case schemaObject:
+		bf.Type = bigQueryRecord
+		bf.Fields = sn.bigQueryFields()
+
+		if len(bf.Fields) == 0 {
+			bf.Type = bigQueryJSON
+			bf.Fields = nil
+		}
+	default:
+		bf.Type = bigQueryJSON
+	}
+
+	return bf
+}
@@ -0,0 +1,105 @@
+package jsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListSchemaBigQuery(t *testing.T) {
+	ls := NewListSchema("events")
+
+	for _, item := range []string{
+		`{"id": 1, "score": 2, "name": "a", "ok": true, "tags": ["x"], "user": {"id": 7, "emails": ["a@b"]},
+			"visits": [{"at": "2024", "pages": [1, 2]}], "grid": [[1]], "any": 1, "empty": [], "none": null}`,
+		`{"id": 2, "score": 2.5, "name": null, "ok": false, "tags": [], "user": {"id": 8, "emails": []},
+			"visits": [], "grid": [], "any": "b", "empty": [], "none": null, "meta": {}}`,
+		`[1, 2]`,
+	} {
+		require.NoError(t, ls.Add([]byte(item)))
+	}
+
+	data, err := ls.MarshalBigQuery()
+	require.NoError(t, err)
+
+	// nested objects are records and arrays repeated, fields in every object which are never null are required, and
+	// items which aren't objects are left out
+	require.JSONEq(t, `[
+		{"name": "id", "type": "INTEGER", "mode": "REQUIRED"},
+		{"name": "score", "type": "FLOAT", "mode": "REQUIRED"},
+		{"name": "name", "type": "STRING", "mode": "NULLABLE"},
+		{"name": "ok", "type": "BOOLEAN", "mode": "REQUIRED"},
+		{"name": "tags", "type": "STRING", "mode": "REPEATED"},
+		{"name": "user", "type": "RECORD", "mode": "REQUIRED", "fields": [
+			{"name": "id", "type": "INTEGER", "mode": "REQUIRED"},
+			{"name": "emails", "type": "STRING", "mode": "REPEATED"}
+		]},
+		{"name": "visits", "type": "RECORD", "mode": "REPEATED", "fields": [
+			{"name": "at", "type": "STRING", "mode": "REQUIRED"},
+			{"name": "pages", "type": "INTEGER", "mode": "REPEATED"}
+		]},
+		{"name": "grid", "type": "JSON", "mode": "REQUIRED"},
+		{"name": "any", "type": "JSON", "mode": "REQUIRED"},
+		{"name": "empty", "type": "STRING", "mode": "REPEATED"},
+		{"name": "none", "type": "STRING", "mode": "NULLABLE"},
+		{"name": "meta", "type": "JSON", "mode": "NULLABLE"}
+	]`, string(data))
+
+	// integers too large for an INTEGER are FLOATs, and a list without objects has no fields
+	ls = NewListSchema("big")
+	require.NoError(t, ls.Add([]byte(`{"n": 99999999999999999999}`)))
+
+	data, err = ls.MarshalBigQuery()
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"name": "n", "type": "FLOAT", "mode": "REQUIRED"}]`, string(data))
+
+	data, err = NewListSchema("empty").MarshalBigQuery()
+	require.NoError(t, err)
+	require.JSONEq(t, `[]`, string(data))
+}
+
+func TestSplitStreamBigQuerySchema(t *testing.T) {
+	const doc = `{"key": "value", "users": [{"id": 1, "address": {"city": "x", "zips": [1, 2]}}, {"id": 2}]}`
+
+	tempDir := t.TempDir()
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), tempDir,
+		Options{BigQuerySchema: true, Format: FormatNDJSON})
+	require.NoError(t, err)
+
+	// the BigQuery schema is written alone, without a JSON Schema
+	data, err := os.ReadFile(filepath.Join(tempDir, "users.bq_schema.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"name": "id", "type": "INTEGER", "mode": "REQUIRED"},
+		{"name": "address", "type": "RECORD", "mode": "NULLABLE", "fields": [
+			{"name": "city", "type": "STRING", "mode": "REQUIRED"},
+			{"name": "zips", "type": "INTEGER", "mode": "REPEATED"}]}]`, string(data))
+
+	require.NoFileExists(t, filepath.Join(tempDir, "users.schema.json"))
+	require.NoFileExists(t, filepath.Join(tempDir, "key.bq_schema.json"))
+
+	m := readManifest(t, tempDir)
+	require.Equal(t, "users.bq_schema.json", m.Keys[0].BigQuerySchema)
+	require.Empty(t, m.Keys[0].Schema)
+
+	// or along with one, and for the shards of a document which is a json array
+	tempDir = t.TempDir()
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(`[{"a": 1.5}, {"a": 2}]`), 16), tempDir,
+		Options{BigQuerySchema: true, InferSchema: true, Shards: 2})
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(filepath.Join(tempDir, "root.bq_schema.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"name": "a", "type": "FLOAT", "mode": "REQUIRED"}]`, string(data))
+	require.FileExists(t, filepath.Join(tempDir, "root.schema.json"))
+
+	m = readManifest(t, tempDir)
+	require.Len(t, m.Keys, 2)
+
+	for _, mk := range m.Keys {
+		require.Equal(t, "root.bq_schema.json", mk.BigQuerySchema)
+		require.Equal(t, "root.schema.json", mk.Schema)
+	}
+}
@@ -0,0 +1,98 @@
+package jsplit
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// autoTuneWindow is how long after the first read the buffer size is tuned for, see WithAutoBufferSize
+	autoTuneWindow = 3 * time.Second
+
+	// autoTuneReads is the number of reads in a row which have to fill their buffer before it grows
+	autoTuneReads = 4
+
+	// autoTuneGain is how much the throughput of the reads has to rise each time the buffer grows for it to keep
+	// growing
+	autoTuneGain = 1.1
+)
+
+// bufferTuner chooses the size of the buffers an AsyncReader reads into when WithAutoBufferSize is set. The buffer
+// starts at the AsyncReader's buffer size and doubles, up to max, each time autoTuneReads reads in a row fill it, as a
+// source which fills every buffer has more to return than the buffer holds. Sources which return a little at a time
+// never fill their buffers, so they keep the size they started with. Once the buffer has grown, it only grows again
+// while the throughput of the reads keeps rising, and tuning stops for good once autoTuneWindow has passed.
+type bufferTuner struct {
+	max      int
+	deadline time.Time // when tuning stops, set by the first read
+
+	// full is the number of reads in a row which filled their buffer, and bytes and took the bytes they read and the
+	// time reading them took
+	full  int
+	bytes int64
+	took  time.Duration
+
+	rate float64 // the throughput, in bytes a second, of the reads before the buffer last grew, 0 until it grows
+	done bool
+}
+
+// newBufferTuner returns a *bufferTuner growing buffers up to max bytes
+func newBufferTuner(max int) *bufferTuner {
+	return &bufferTuner{max: max}
+}
+
+// next returns the size of the buffer to read into next, following a read of n bytes into a buffer of size bytes which
+// took as long as took. The size is returned as it is by a nil *bufferTuner.
+func (bt *bufferTuner) next(size, n int, took time.Duration) int {
+	if bt == nil || bt.done {
+		return size
+	}
+
+	now := time.Now()
+	if bt.deadline.IsZero() {
+		bt.deadline = now.Add(autoTuneWindow)
+	} else if now.After(bt.deadline) {
+		bt.finish(size)
+		return size
+	}
+
+	if n < size {
+		bt.full, bt.bytes, bt.took = 0, 0, 0
+		return size
+	}
+
+	bt.full++
+	bt.bytes += int64(n)
+	bt.took += took
+
+	if bt.full < autoTuneReads {
+		return size
+	}
+
+	// reads which take no measurable time are as fast as they can be, so only the cap stops the buffer growing
+	rate := float64(bt.bytes) / bt.took.Seconds()
+
+	if size >= bt.max || (bt.rate > 0 && rate < bt.rate*autoTuneGain) {
+		bt.finish(size)
+		return size
+	}
+
+	bt.rate = rate
+	bt.full, bt.bytes, bt.took = 0, 0, 0
+
+	size *= 2
+	if size > bt.max {
+		size = bt.max
+	}
+
+	return size
+}
+
+// finish stops tuning, keeping buffers of size bytes
+func (bt *bufferTuner) finish(size int) {
+	bt.done = true
+
+	if debugEnabled() {
+		logger().Debug(fmt.Sprintf("Reading in chunks of %d bytes", size), "buffer_size", size)
+	}
+}
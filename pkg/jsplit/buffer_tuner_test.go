@@ -0,0 +1,143 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferTuner(t *testing.T) {
+	// tune returns the size settled on by reads taking as long as took for the size, each returning as much as read
+	tune := func(max int, read func(size int) int, took func(size int) time.Duration) int {
+		bt := newBufferTuner(max)
+		size := 1024
+
+		for i := 0; i < 100 && !bt.done; i++ {
+			size = bt.next(size, read(size), took(size))
+		}
+
+		return size
+	}
+
+	fill := func(size int) int { return size }
+	latency := func(int) time.Duration { return time.Millisecond }
+
+	// reads which fill their buffer as fast whatever its size grow it up to the cap
+	require.Equal(t, 64*1024, tune(64*1024, fill, latency))
+	require.Equal(t, 3000, tune(3000, fill, latency))
+
+	// reads returning a little at a time don't grow it
+	require.Equal(t, 1024, tune(64*1024, func(int) int { return 100 }, latency))
+
+	// and reads which are no faster in larger buffers only grow it once, to measure that
+	require.Equal(t, 2048, tune(64*1024, fill, func(size int) time.Duration {
+		return time.Duration(size) * time.Microsecond
+	}))
+
+	// the size is only tuned for the first few seconds
+	bt := newBufferTuner(64 * 1024)
+	require.Equal(t, 1024, bt.next(1024, 1024, time.Millisecond))
+
+	bt.deadline = time.Now().Add(-time.Second)
+	for i := 0; i < autoTuneReads; i++ {
+		require.Equal(t, 1024, bt.next(1024, 1024, time.Millisecond))
+	}
+
+	require.True(t, bt.done)
+
+	// a nil tuner keeps the size
+	require.Equal(t, 1024, (*bufferTuner)(nil).next(1024, 1024, time.Millisecond))
+}
+
+// latencyReader fills each read from its reader after a delay, as for a high latency source
+type latencyReader struct {
+	rd    io.Reader
+	delay time.Duration
+}
+
+func (lr *latencyReader) Read(p []byte) (int, error) {
+	time.Sleep(lr.delay)
+
+	n, err := io.ReadFull(lr.rd, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// tricklingReader returns a few bytes of its reader at a time
+type tricklingReader struct {
+	rd io.Reader
+}
+
+func (tr *tricklingReader) Read(p []byte) (int, error) {
+	if len(p) > 100 {
+		p = p[:100]
+	}
+
+	return tr.rd.Read(p)
+}
+
+func TestAsyncReaderAutoBufferSize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+
+	// readChunks reads the source, returning what was read and the size of the largest buffer read into
+	readChunks := func(rd io.Reader, opts ...AsyncReaderOption) ([]byte, int) {
+		afr, err := AsyncReaderFromReader(rd, 1024, opts...)
+		require.NoError(t, err)
+
+		defer afr.Close()
+
+		ctx := afr.Start(context.Background())
+
+		var (
+			read    []byte
+			largest int
+		)
+
+		for {
+			chunk, err := afr.Read(ctx)
+			if err == io.EOF {
+				return read, largest
+			}
+
+			require.NoError(t, err)
+
+			read = append(read, chunk...)
+			if cap(chunk) > largest {
+				largest = cap(chunk)
+			}
+
+			afr.ReleaseBuffer(chunk)
+		}
+	}
+
+	// a fast source filling every read converges on the largest buffers. Its latency is long enough that delays in
+	// scheduling the reads don't hide the throughput rising as they grow.
+	read, largest := readChunks(&latencyReader{rd: bytes.NewReader(data), delay: 10 * time.Millisecond},
+		WithAutoBufferSize(64*1024))
+	require.Equal(t, data, read)
+	require.Equal(t, 64*1024, largest)
+
+	// while a slow one returning a little at a time keeps the buffers it started with
+	read, largest = readChunks(&tricklingReader{rd: bytes.NewReader(data)}, WithAutoBufferSize(64*1024))
+	require.Equal(t, data, read)
+	require.Equal(t, 1024, largest)
+
+	// a size given explicitly overrides tuning
+	read, largest = readChunks(&latencyReader{rd: bytes.NewReader(data[:128*1024]), delay: 2 * time.Millisecond},
+		WithAutoBufferSize(64*1024), WithReadBufferSize(4096))
+	require.Equal(t, data[:128*1024], read)
+	require.Equal(t, 4096, largest)
+
+	_, err := AsyncReaderFromReader(bytes.NewReader(data), 1024, WithAutoBufferSize(0))
+	require.EqualError(t, err, "auto buffer size limit must be positive, got 0")
+
+	_, err = AsyncReaderFromReader(bytes.NewReader(data), 1024, WithReadBufferSize(-1))
+	require.EqualError(t, err, "read buffer size must be positive, got -1")
+}
@@ -1,6 +1,7 @@
 package jsplit
 
 import (
+	"bytes"
 	"context"
 	"io"
 
@@ -20,6 +21,32 @@ type BufferedByteStreamIter struct {
 	ctx    context.Context
 	buffer []byte
 	pos    int
+
+	// offset and lines are the number of bytes and newlines which came before the start of the buffer, and recent holds
+	// the last of those bytes, so that parse errors can say where they happened
+	offset int64
+	lines  int64
+	recent []byte
+
+	// err is the error which stopped the stream from being read, if it failed, and ended is set once every byte of
+	// the stream has been read
+	err   error
+	ended bool
+
+	// items is the number of list items, or values of a stream of them, parsed and added so far, which tells a
+	// document which was cut short after some of its records from one with none, see tolerateTruncation
+	items int64
+
+	// pooled is the buffer taken from the pool to join the unread bytes to the next chunk of the stream, which is put
+	// back once it has been replaced, and objBuf holds the values returned by ParseObject
+	pooled []byte
+	objBuf []byte
+
+	// maxDepth is the deepest ParseObject allows objects and arrays to be nested, DefaultMaxDepth when it isn't set
+	maxDepth int
+
+	// maxRecordBytes is the largest value ParseObject and parseVal allow, with no limit when it isn't set
+	maxRecordBytes int
 }
 
 // NewBufferStreamIter returns a *BufferedByteStreamIter for iterating over the bytes of the given byte stream
@@ -33,13 +60,17 @@ func NewBufferedStreamIter(readCtx context.Context, stream ByteStream) *Buffered
 }
 
 // Next read the byte at the current position and move the current position forward.  When all bytes have been iterated
-// over a call to next will return 0
+// over, or reading the stream fails, a call to next will return 0. See Err for the error the stream failed with.
 func (itr *BufferedByteStreamIter) Next() byte {
 	if itr.pos >= len(itr.buffer) {
 		err := itr.readMore()
-		if err != nil && err != io.EOF {
-			panic(err)
-		} else if err == io.EOF {
+		if err != nil {
+			if err != io.EOF {
+				itr.err = err
+			} else {
+				itr.ended = true
+			}
+
 			return 0
 		}
 	}
@@ -53,6 +84,7 @@ func (itr *BufferedByteStreamIter) Next() byte {
 // Advance moves the current position forward n places for positive numbers, and back n places for negative numbers
 func (itr *BufferedByteStreamIter) Advance(n int) {
 	if n > 0 {
+		itr.discard(n)
 		itr.buffer = itr.buffer[n:]
 		itr.pos -= n
 	} else {
@@ -62,6 +94,7 @@ func (itr *BufferedByteStreamIter) Advance(n int) {
 
 // Skip moves the start of the buffer to the current position, and then sets the current position to 0
 func (itr *BufferedByteStreamIter) Skip() {
+	itr.discard(itr.pos)
 	itr.buffer = itr.buffer[itr.pos:]
 	itr.pos = 0
 }
@@ -70,12 +103,45 @@ func (itr *BufferedByteStreamIter) Skip() {
 // start of the buffer to be the current position, and then sets the current position to 0 and then returns
 func (itr *BufferedByteStreamIter) Value() []byte {
 	val := itr.buffer[:itr.pos]
+	itr.discard(itr.pos)
 	itr.buffer = itr.buffer[itr.pos:]
 	itr.pos = 0
 
 	return val
 }
 
+// Offset returns the number of bytes of the stream before the current position
+func (itr *BufferedByteStreamIter) Offset() int64 {
+	return itr.offset + int64(itr.pos)
+}
+
+// discard accounts for the first n bytes of the buffer before they are removed from it
+func (itr *BufferedByteStreamIter) discard(n int) {
+	if n <= 0 {
+		return
+	}
+
+	discarded := itr.buffer[:n]
+	itr.offset += int64(n)
+	itr.lines += int64(bytes.Count(discarded, newLineBytes))
+
+	if n >= snippetContext {
+		itr.recent = append(itr.recent[:0], discarded[n-snippetContext:]...)
+		return
+	}
+
+	itr.recent = append(itr.recent, discarded...)
+	if len(itr.recent) > snippetContext {
+		itr.recent = append(itr.recent[:0], itr.recent[len(itr.recent)-snippetContext:]...)
+	}
+}
+
+// Err returns the error which stopped the stream from being read, or nil if it hasn't failed. The stream ends where it
+// failed, so parsing fails where it did too, and reports this error in place of a parse error.
+func (itr *BufferedByteStreamIter) Err() error {
+	return itr.err
+}
+
 func (itr *BufferedByteStreamIter) readMore() error {
 	buf, err := itr.stream.Read(itr.ctx)
 	if err != nil {
@@ -95,7 +161,13 @@ func (itr *BufferedByteStreamIter) readMore() error {
 		copy(newBuf[oldLen:], buf)
 		itr.buffer = newBuf
 
-		p.Put(newBuf)
+		// the buffer is only put back once it is no longer used, as iterators parsing other documents at the same time
+		// share the pool
+		if itr.pooled != nil {
+			p.Put(itr.pooled)
+		}
+
+		itr.pooled = newBuf
 	}
 
 	return nil
@@ -66,3 +66,27 @@ func TestBufferedByteStreamIter(t *testing.T) {
 
 	require.Equal(t, splitWords, words)
 }
+
+func TestBufferedByteStreamIterOffset(t *testing.T) {
+	const testStr = "0123\n5678\n"
+
+	itr := NewBufferedStreamIter(context.Background(), NewTestByteStream([]byte(testStr), 3))
+	require.Equal(t, int64(0), itr.Offset())
+
+	for i := 0; i < 4; i++ {
+		itr.Next()
+	}
+
+	require.Equal(t, int64(4), itr.Offset())
+	itr.Skip()
+	require.Equal(t, int64(4), itr.Offset())
+
+	for i := 0; i < 3; i++ {
+		itr.Next()
+	}
+
+	require.Equal(t, "\n56", string(itr.Value()))
+	require.Equal(t, int64(7), itr.Offset())
+	require.Equal(t, int64(1), itr.lines)
+	require.Equal(t, "0123\n56", string(itr.recent))
+}
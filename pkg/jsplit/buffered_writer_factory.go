@@ -2,24 +2,21 @@ package jsplit
 
 import (
 	"bufio"
-	"context"
+	"compress/gzip"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
-	"strings"
+	"text/template"
 	"time"
-
-	"github.com/danielchalef/jsplit/pkg/cloud"
 )
 
 // BufferedWriteCloser wraps an io.WriteCloser in a bufio.Writer object and provides an io.WriteCloser implementation
 // for the bufio.Writer object
 type BufferedWriteCloser struct {
-	start time.Time
-	wr    io.WriteCloser
-	bufWr *bufio.Writer
-	name  string
+	start  time.Time
+	wr     io.WriteCloser
+	bufWr  *bufio.Writer
+	name   string
+	budget *memoryBudget // the memory of the split the buffer is counted against until the writer is closed
 }
 
 // NewBufferedWriteCloser returns a BufferedWriteCloser object which writes to the supplied io.WriteCloser
@@ -39,61 +36,421 @@ func (bwc *BufferedWriteCloser) Write(p []byte) (n int, err error) {
 	return bwc.bufWr.Write(p)
 }
 
-// Close makes sure the bufio.Writer object flushes, and the supplied io.WriteCloser is closed
-func (bwc *BufferedWriteCloser) Close() error {
+// Flush writes any buffered data to the io.WriteCloser
+func (bwc *BufferedWriteCloser) Flush() error {
+	return bwc.bufWr.Flush()
+}
+
+// Sync flushes the buffered data and everything the io.WriteCloser buffers below it, and commits the file to disk if it
+// is a local file
+func (bwc *BufferedWriteCloser) Sync() error {
 	err := bwc.bufWr.Flush()
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Closing %s after %f seconds\n", bwc.name, time.Since(bwc.start).Seconds())
+	return syncWriter(bwc.wr)
+}
+
+// syncWriter flushes what w buffers and commits it to disk, if w can be synced like an *os.File. Writers which can't
+// be, such as cloud storage uploads, are left alone.
+func syncWriter(w io.Writer) error {
+	if s, ok := w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
 
-	return bwc.wr.Close()
+	return nil
+}
+
+// Close makes sure the bufio.Writer object flushes, and the supplied io.WriteCloser is closed. The io.WriteCloser is
+// closed even if flushing fails so that cloud storage uploads are always finished.
+func (bwc *BufferedWriteCloser) Close() error {
+	flushErr := bwc.bufWr.Flush()
+
+	if bwc.budget != nil {
+		bwc.budget.release(int64(bwc.bufWr.Size()))
+		bwc.budget = nil
+	}
+
+	elapsed := time.Since(bwc.start).Seconds()
+	logger().Debug(fmt.Sprintf("Closing %s after %f seconds", bwc.name, elapsed), "file", bwc.name, "seconds", elapsed)
+
+	err := bwc.wr.Close()
+	if flushErr != nil {
+		return flushErr
+	}
+
+	return err
 }
 
 // BufferedWriterFactory returns an object which can be used for creating jsonl files
 type BufferedWriterFactory struct {
-	format     string
+	sink       OutputSink
+	key        string
+	dir        string // the directory within the sink the files are created in, if any, e.g. dt=2024-01-01
+	ext        string
+	tmpl       *template.Template
+	files      []*ManifestFile
 	index      int
 	bufferSize int
+	outFormat  Format
+	compress   bool
+	gzipLevel  int
+	gzipBlock  int // the size of the blocks compressed at once when gzipCores is more than 1
+	gzipCores  int
+	checksums  bool
+	budget     *memoryBudget
+	limit      *fileLimit
+	tempDir    string
+	csvDialect CSVDialect
+	nestedJSON bool
 }
 
 // NewBufferedWriterFactory returns a *BufferedWriterFactory instance which creates files in the format [key]_%02d.jsonl
-// within the supplied directory.
-func NewBufferedWriterFactory(directory, key string, bufferSize int) *BufferedWriterFactory {
-	var format string
-
-	if cloud.IsCloudURI(directory) {
-		format = strings.TrimLeft(directory, "/") + "/" + key + "_%02d.jsonl"
-	} else {
-		format = filepath.Join(directory, key+"_%02d.jsonl")
-	}
+// in the supplied sink. The extension follows opts.Format, and if opts.CompressOutput is set the files are gzip
+// compressed and named [key]_%02d.jsonl.gz. Files are named by opts.NameTemplate instead when it is set. Path
+// separators in the key are escaped, see fileKey.
+func NewBufferedWriterFactory(sink OutputSink, key string, bufferSize int, opts Options) *BufferedWriterFactory {
+	return newWriterFactory(sink, fileKey(key), bufferSize, opts)
+}
 
+// newWriterFactory returns a *BufferedWriterFactory creating files named after name, which is used as it is
+func newWriterFactory(sink OutputSink, name string, bufferSize int, opts Options) *BufferedWriterFactory {
 	return &BufferedWriterFactory{
-		format:     format,
+		sink:       sink,
+		key:        name,
+		ext:        string(opts.format()) + compressedExt(opts.CompressOutput),
+		tmpl:       opts.nameTemplate(),
 		index:      0,
 		bufferSize: bufferSize,
+		outFormat:  opts.format(),
+		compress:   opts.CompressOutput,
+		gzipLevel:  opts.gzipLevel(),
+		gzipBlock:  opts.GzipBlockSize,
+		gzipCores:  opts.GzipConcurrency,
+		checksums:  opts.Checksums,
+		budget:     opts.budget,
+		limit:      opts.files,
+		tempDir:    opts.TempDir,
+		csvDialect: opts.CSV,
+		nestedJSON: opts.ParquetNestedJSON,
 	}
 }
 
-// CreateWriter will create a new file within and return an io.WriteCloser for writing to the newly created file
+// compressedExt returns the extension appended to the names of output files
+func compressedExt(compress bool) string {
+	if compress {
+		return ".gz"
+	}
+
+	return ""
+}
+
+// CreateWriter will create a new file within the sink and return an io.WriteCloser for writing to the newly created
+// file
 func (bwf *BufferedWriterFactory) CreateWriter() (io.WriteCloser, error) {
-	filename := fmt.Sprintf(bwf.format, bwf.index)
+	name, err := bwf.fileName(bwf.index)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bwf.limit.create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &ManifestFile{Name: name}
 	bwf.index++
 
-	if cloud.IsCloudURI(filename) {
-		w, err := cloud.NewWriter(context.TODO(), filename)
+	w, err := bwf.open(file.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	bwf.files = append(bwf.files, file)
+
+	return bwf.newWriter(sinkPath(bwf.sink, file.Name), w, file)
+}
+
+// open opens the file with the given name in the sink, through which sinks streaming the items of lists are passed the
+// key the file is written for
+func (bwf *BufferedWriterFactory) open(name string) (io.WriteCloser, error) {
+	if ls, ok := bwf.sink.(interface {
+		openList(key, name string) (io.WriteCloser, error)
+	}); ok {
+		return ls.openList(bwf.key, name)
+	}
+
+	return bwf.sink.OpenKey(name)
+}
+
+// newWriter returns a buffered writer for the file, converting the jsonl written to it to the factory's format. A file
+// written under a temporary name is only renamed into place once every writer above it has closed without error.
+func (bwf *BufferedWriterFactory) newWriter(filename string, wr io.WriteCloser, file *ManifestFile) (io.WriteCloser, error) {
+	af, ok := wr.(*atomicFile)
+	if !ok {
+		return bwf.formatWriter(filename, wr, file)
+	}
+
+	af.held = true
+
+	w, err := bwf.formatWriter(filename, wr, file)
+	if err != nil {
+		_ = af.finish(err)
+		return nil, err
+	}
+
+	return &atomicWriteCloser{WriteCloser: w, file: af}, nil
+}
+
+// formatWriter returns a buffered writer for the file, converting the jsonl written to it to the factory's format
+func (bwf *BufferedWriterFactory) formatWriter(filename string, wr io.WriteCloser, file *ManifestFile) (io.WriteCloser,
+	error) {
+	wr, err := bwf.wrap(wr, file)
+	if err != nil {
+		_ = wr.Close()
+		return nil, err
+	}
+
+	if bwf.outFormat == FormatCSV {
+		csvWr, err := newCsvWriteCloser(wr, bwf.tempDir, bwf.csvDialect)
 		if err != nil {
+			_ = wr.Close()
 			return nil, err
 		}
 
-		return NewBufferedWriteCloser(filename, w, bwf.bufferSize), nil
+		wr = csvWr
+	}
+
+	if bwf.outFormat == FormatParquet {
+		parquetWr, err := newParquetWriteCloser(wr, bwf.tempDir, bwf.nestedJSON)
+		if err != nil {
+			_ = wr.Close()
+			return nil, err
+		}
+
+		wr = parquetWr
+	}
+
+	if bwf.outFormat == FormatMsgpack {
+		wr = NewMsgpackWriteCloser(wr)
+	}
+
+	bwc := NewBufferedWriteCloser(filename, wr, bwf.bufferSize)
+
+	// the buffer is held until the file is closed
+	bwf.budget.reserve(int64(bwc.bufWr.Size()))
+	bwc.budget = bwf.budget
+
+	return bwc, nil
+}
+
+// atomicWriteCloser finishes the atomic file at the bottom of the writers of a file once they have been closed, so that
+// a file whose writers fail as they are closed, such as a csv file whose rows can't be converted, is removed rather
+// than renamed into place
+type atomicWriteCloser struct {
+	io.WriteCloser
+	file *atomicFile
+}
+
+// Flush flushes the writers above the file
+func (awc *atomicWriteCloser) Flush() error {
+	if fl, ok := awc.WriteCloser.(interface{ Flush() error }); ok {
+		return fl.Flush()
+	}
+
+	return nil
+}
+
+// Sync flushes the writers above the file and commits it to disk
+func (awc *atomicWriteCloser) Sync() error {
+	return syncWriter(awc.WriteCloser)
+}
+
+// Close closes the writers, renaming the file into place if they all closed without error and removing it otherwise
+func (awc *atomicWriteCloser) Close() error {
+	return awc.file.finish(awc.WriteCloser.Close())
+}
+
+// fileName returns the name of the file with the given index
+func (bwf *BufferedWriterFactory) fileName(index int) (string, error) {
+	name := fmt.Sprintf("%s_%02d.%s", bwf.key, index, bwf.ext)
+
+	if bwf.tmpl != nil {
+		var err error
+
+		name, err = renderName(bwf.tmpl, FileNameData{Key: bwf.key, Shard: index, Ext: bwf.ext})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if bwf.dir != "" {
+		name = bwf.dir + "/" + name
+	}
+
+	return name, nil
+}
+
+// wrap returns a writer counting the bytes written to wr in file, and recording their checksum in it if the factory
+// computes checksums, compressing the data first if the factory compresses its files
+func (bwf *BufferedWriterFactory) wrap(wr io.WriteCloser, file *ManifestFile) (io.WriteCloser, error) {
+	if bwf.checksums {
+		wr = newChecksumWriteCloser(wr, &file.SHA256)
+	}
+
+	wr = &countingWriteCloser{WriteCloser: wr, n: &file.Bytes}
+
+	if !bwf.compress {
+		return wr, nil
+	}
+
+	if bwf.gzipCores > 1 {
+		pgzWr, err := NewParallelGzipWriteCloser(wr, bwf.gzipLevel, bwf.gzipBlock, bwf.gzipCores)
+		if err != nil {
+			return wr, err
+		}
+
+		return pgzWr, nil
 	}
 
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	gzWr, err := NewGzipWriteCloserLevel(wr, bwf.gzipLevel)
+	if err != nil {
+		return wr, err
+	}
+
+	return gzWr, nil
+}
+
+// resume restores the files created before a split was interrupted, so that the next file created follows them. When
+// appendLast is set the last file, which was still being written, is truncated to the size it had at the checkpoint
+// and a writer appending to it is returned.
+func (bwf *BufferedWriterFactory) resume(files []ManifestFile, appendLast bool) (io.WriteCloser, error) {
+	bwf.files = nil
+	for i := range files {
+		file := files[i]
+		bwf.files = append(bwf.files, &file)
+	}
+
+	bwf.index = len(files)
+	bwf.limit.add(len(files))
+
+	if !appendLast || len(files) == 0 {
+		return nil, nil
+	}
+
+	return bwf.appendLast()
+}
+
+// canAppend reports whether the factory's sink can append to the files it has created
+func (bwf *BufferedWriterFactory) canAppend() bool {
+	_, ok := bwf.sink.(interface {
+		appendKey(name string, size int64) (io.WriteCloser, error)
+	})
+
+	return ok
+}
+
+// appendLast returns a writer appending to the last file the factory created, after truncating it to the number of
+// bytes recorded for it
+func (bwf *BufferedWriterFactory) appendLast() (io.WriteCloser, error) {
+	as, ok := bwf.sink.(interface {
+		appendKey(name string, size int64) (io.WriteCloser, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("files can't be appended to in %s", sinkLocation(bwf.sink))
+	}
+
+	file := bwf.files[len(bwf.files)-1]
+
+	w, err := as.appendKey(file.Name, file.Bytes)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewBufferedWriteCloser(filename, f, bwf.bufferSize), nil
+	return bwf.newWriter(sinkPath(bwf.sink, file.Name), w, file)
+}
+
+// Files returns the names of the files which have been created so far, relative to the directory, along with the
+// number of bytes written to each
+func (bwf *BufferedWriterFactory) Files() []ManifestFile {
+	files := make([]ManifestFile, len(bwf.files))
+	for i, file := range bwf.files {
+		files[i] = *file
+	}
+
+	return files
+}
+
+// countingWriteCloser counts the bytes written to an io.WriteCloser
+type countingWriteCloser struct {
+	io.WriteCloser
+	n *int64
+}
+
+// Write writes p to the io.WriteCloser adding the number of bytes written to the count
+func (cwc *countingWriteCloser) Write(p []byte) (int, error) {
+	n, err := cwc.WriteCloser.Write(p)
+	*cwc.n += int64(n)
+
+	return n, err
+}
+
+// Sync syncs the io.WriteCloser
+func (cwc *countingWriteCloser) Sync() error {
+	return syncWriter(cwc.WriteCloser)
+}
+
+// GzipWriteCloser gzip compresses the data written to an io.WriteCloser
+type GzipWriteCloser struct {
+	gzWr *gzip.Writer
+	wr   io.WriteCloser
+}
+
+// NewGzipWriteCloser returns a GzipWriteCloser object which writes compressed data to the supplied io.WriteCloser
+func NewGzipWriteCloser(wr io.WriteCloser) *GzipWriteCloser {
+	return &GzipWriteCloser{
+		gzWr: gzip.NewWriter(wr),
+		wr:   wr,
+	}
+}
+
+// NewGzipWriteCloserLevel returns a GzipWriteCloser object which writes data compressed at the given level to the
+// supplied io.WriteCloser, or an error if the level isn't one accepted by gzip.NewWriterLevel
+func NewGzipWriteCloserLevel(wr io.WriteCloser, level int) (*GzipWriteCloser, error) {
+	gzWr, err := gzip.NewWriterLevel(wr, level)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GzipWriteCloser{gzWr: gzWr, wr: wr}, nil
+}
+
+// Write compresses p writing it to the io.WriteCloser
+func (gwc *GzipWriteCloser) Write(p []byte) (n int, err error) {
+	return gwc.gzWr.Write(p)
+}
+
+// Sync writes the data compressed so far to the io.WriteCloser, so that it can be decompressed up to there, and syncs
+// the io.WriteCloser
+func (gwc *GzipWriteCloser) Sync() error {
+	err := gwc.gzWr.Flush()
+	if err != nil {
+		return err
+	}
+
+	return syncWriter(gwc.wr)
+}
+
+// Close writes the gzip trailer and closes the supplied io.WriteCloser. The io.WriteCloser is closed even if writing the
+// trailer fails.
+func (gwc *GzipWriteCloser) Close() error {
+	gzErr := gwc.gzWr.Close()
+
+	err := gwc.wr.Close()
+	if gzErr != nil {
+		return gzErr
+	}
+
+	return err
 }
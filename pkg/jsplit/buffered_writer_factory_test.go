@@ -0,0 +1,66 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferedWriterFactoryCloud(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// a trailing / on the prefix doesn't produce an empty path segment
+	for _, dir := range []string{"file://" + filepath.ToSlash(tempDir), "file://" + filepath.ToSlash(tempDir) + "/"} {
+		fileFactory := NewBufferedWriterFactory(NewDirSink(context.Background(), dir), "key", 1024, Options{})
+
+		wr, err := fileFactory.CreateWriter()
+		require.NoError(t, err)
+
+		_, err = wr.Write([]byte(`{"key":"value"}`))
+		require.NoError(t, err)
+		require.NoError(t, wr.Close())
+
+		requireContents(t, filepath.Join(tempDir, "key_00.jsonl"), `{"key":"value"}`)
+	}
+}
+
+type failingWriteCloser struct {
+	closed bool
+}
+
+func (fwc *failingWriteCloser) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func (fwc *failingWriteCloser) Close() error {
+	fwc.closed = true
+	return nil
+}
+
+func TestBufferedWriteCloserClosesOnFlushError(t *testing.T) {
+	fwc := &failingWriteCloser{}
+	bwc := NewBufferedWriteCloser("test", fwc, 1024)
+
+	_, err := bwc.Write([]byte("buffered"))
+	require.NoError(t, err)
+
+	require.EqualError(t, bwc.Close(), "write failed")
+	require.True(t, fwc.closed)
+}
+
+func TestSplitStreamCloudOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	outputDir := filepath.Join(tempDir, "prefix")
+	require.NoError(t, os.Mkdir(outputDir, 0o755))
+
+	bs := NewTestByteStream([]byte(`{"key": "value", "list": [{"idx": 0}, {"idx": 1}]}`), 16)
+	err := SplitStream(context.Background(), bs, "file://"+filepath.ToSlash(outputDir)+"/", Options{})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(outputDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}")
+	requireContents(t, filepath.Join(outputDir, "root.json"), "{\n\t\"key\":\"value\"\n}")
+}
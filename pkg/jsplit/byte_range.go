@@ -0,0 +1,86 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+)
+
+// byteRange is the part of a source an AsyncReader reads, see WithByteRange. A negative length reads to the end.
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+// openByteRange attaches the byte range of uri as the source of the AsyncReader, after checking that uri isn't
+// compressed
+func (afr *AsyncReader) openByteRange(uri string) (*AsyncReader, error) {
+	if uri == "-" || isUnixSocket(uri) || isSSHPath(uri) ||
+		(cloud.IsCloudURI(uri) && cloud.IsPattern(uri)) {
+		return nil, fmt.Errorf("a byte range can't be read from %s", uri)
+	}
+
+	// requests for the input are aborted along with the AsyncReader
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := afr.checkUncompressed(ctx, uri)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r, size, err := afr.openFrom(ctx, uri, afr.byteRange.offset, afr.byteRange.length)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	afr.rd = r
+	afr.closers = []io.Closer{r, closerFunc(func() error {
+		cancel()
+		return nil
+	})}
+	afr.abort = cancel
+	afr.totalSize = size
+
+	// offsets in the data read are offsets from the start of the range, so checkpoints can't be resumed from them
+	afr.seekable = false
+
+	return afr, nil
+}
+
+// checkUncompressed returns an error if the first bytes of uri identify a compression format, or one was given with
+// WithCompression, as a range of compressed data can't be decompressed. A source read with CompressionNone isn't
+// checked, it is read as it is.
+func (afr *AsyncReader) checkUncompressed(ctx context.Context, uri string) error {
+	switch afr.readAs {
+	case CompressionNone:
+		return nil
+	case CompressionAuto:
+	default:
+		return fmt.Errorf("a byte range can't be read from %s, it is decompressed as %s", uri, afr.readAs)
+	}
+
+	r, _, err := afr.openFrom(ctx, uri, 0, maxMagicLen)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	buf := make([]byte, maxMagicLen)
+
+	n, err := peek(r, buf)
+	if err != nil {
+		return err
+	}
+
+	compression := magicCompression(buf[:n])
+	if compression != CompressionNone {
+		return fmt.Errorf("a byte range can't be read from %s, it is %s", uri, describeCompression(compression))
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package jsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithByteRange(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data.txt")
+	require.NoError(t, os.WriteFile(filename, []byte("0123456789abcdefghij"), 0o644))
+
+	read := func(uri string, offset, length int64) (string, int64) {
+		rd, err := AsyncReaderFromFile(uri, 3, WithByteRange(offset, length))
+		require.NoError(t, err)
+		defer rd.Close()
+
+		data := readAll(t, rd.Start(context.Background()), rd)
+
+		return string(data), rd.TotalSize()
+	}
+
+	// the file is seeked to the offset and read up to the length
+	data, size := read(filename, 5, 10)
+	require.Equal(t, "56789abcde", data)
+	require.Equal(t, int64(10), size)
+
+	// the offset reached counts from the start of the file rather than the range
+	rd, err := AsyncReaderFromFile(filename, 3, WithByteRange(5, 10))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), rd.Offset())
+
+	readAll(t, rd.Start(context.Background()), rd)
+	require.Equal(t, int64(15), rd.Offset())
+	require.NoError(t, rd.Close())
+
+	// a length of 0 reads the rest of the file, and ranges past the end read what there is
+	data, size = read(filename, 15, 0)
+	require.Equal(t, "fghij", data)
+	require.Equal(t, int64(5), size)
+
+	data, size = read(filename, 18, 10)
+	require.Equal(t, "ij", data)
+	require.Equal(t, int64(2), size)
+
+	data, size = read(filename, 30, 0)
+	require.Empty(t, data)
+	require.Zero(t, size)
+
+	// the range of a URL is requested from the server
+	var ranges []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "data.txt", time.Time{}, strings.NewReader("0123456789abcdefghij"))
+	}))
+	defer srv.Close()
+
+	data, _ = read(srv.URL+"/data.txt", 2, 3)
+	require.Equal(t, "234", data)
+
+	data, _ = read(srv.URL+"/data.txt", 17, 0)
+	require.Equal(t, "hij", data)
+	require.Equal(t, []string{"bytes=0-3", "bytes=2-4", "bytes=0-3", "bytes=17-"}, ranges)
+
+	// compressed input can't be read part way through
+	var buf bytes.Buffer
+
+	gw := gzip.NewWriter(&buf)
+	_, err = gw.Write([]byte(`{"a": [1, 2]}`))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	gzipped := filepath.Join(dir, "data.json.gz")
+	require.NoError(t, os.WriteFile(gzipped, buf.Bytes(), 0o644))
+
+	_, err = AsyncReaderFromFile(gzipped, 3, WithByteRange(4, 0))
+	require.EqualError(t, err, "a byte range can't be read from "+gzipped+", it is gzip compressed")
+
+	_, err = AsyncReaderFromFile("-", 3, WithByteRange(4, 0))
+	require.EqualError(t, err, "a byte range can't be read from -")
+
+	_, err = AsyncReaderFromFile(filename, 3, WithByteRange(-1, 0))
+	require.EqualError(t, err, "byte range offset must not be negative, got -1")
+
+	_, err = AsyncReaderFromFile(filename, 3, WithByteRange(0, -1))
+	require.EqualError(t, err, "byte range length must not be negative, got -1")
+}
+
+func TestSplitFileByteRange(t *testing.T) {
+	const ndjson = `{"id": 1}` + "\n" + `{"id": 2}` + "\n" + `{"id": 3}` + "\n" + `{"id": 4}` + "\n"
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data.ndjson")
+	require.NoError(t, os.WriteFile(filename, []byte(ndjson), 0o644))
+
+	// the second and third lines are split on their own
+	outputDir := filepath.Join(dir, "output")
+	require.NoError(t, SplitFile(context.Background(), filename, outputDir,
+		Options{ReaderOptions: []AsyncReaderOption{WithByteRange(10, 20)}}))
+	requireContents(t, filepath.Join(outputDir, "root_00.jsonl"), `{"id":2}`+"\n"+`{"id":3}`)
+
+	// offsets within a range aren't offsets in the file, so checkpoints can't be resumed from them
+	err := SplitFile(context.Background(), filename, filepath.Join(dir, "checkpointed"),
+		Options{ReaderOptions: []AsyncReaderOption{WithByteRange(10, 20)}, CheckpointInterval: time.Minute})
+	require.EqualError(t, err, "checkpoints can't be recorded reading "+filename+", as it can't be read from an offset")
+}
@@ -39,3 +39,8 @@ func (bs *ByteStack) Peek() byte {
 
 	return bs.chars[l-1]
 }
+
+// Len returns the number of bytes on the stack
+func (bs *ByteStack) Len() int {
+	return len(bs.chars)
+}
@@ -15,6 +15,7 @@ func TestByteStack(t *testing.T) {
 	bs.Push(byte('0'))
 	bs.Push(byte('1'))
 	bs.Push(byte('2'))
+	require.Equal(t, 3, bs.Len())
 
 	require.Equal(t, byte('2'), bs.Peek())
 	require.Equal(t, byte('2'), bs.Pop())
@@ -25,4 +26,5 @@ func TestByteStack(t *testing.T) {
 
 	require.Equal(t, byte(0), bs.Peek())
 	require.Equal(t, byte(0), bs.Pop())
+	require.Zero(t, bs.Len())
 }
@@ -0,0 +1,363 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+)
+
+// CheckpointFilename is the name of the file in the output directory recording the progress of a split when
+// Options.CheckpointInterval is set
+const CheckpointFilename = "checkpoint.json"
+
+// checkpoint records how far a split had got, so that it can be resumed from the list item where it was recorded
+type checkpoint struct {
+	// Offset is the offset in the input just after the last item which was written
+	Offset int64 `json:"offset"`
+
+	// Key is the key of the list being written, and RootList is set when it is the document, which is a json array
+	Key      string `json:"key"`
+	RootList bool   `json:"rootList,omitempty"`
+
+	// Files are the files written for the key so far. Open is set when the last of them was still being written, in
+	// which case its size is the number of bytes which had been flushed to it.
+	Files []ManifestFile `json:"files"`
+	Open  bool           `json:"open"`
+
+	// Keys are the keys which had been finished, and Root and RootRecords the part of root.json which had been parsed
+	Keys        []ManifestKey `json:"keys"`
+	Root        string        `json:"root"`
+	RootRecords int64         `json:"rootRecords"`
+}
+
+// readCheckpoint reads the checkpoint recorded in the local directory dir
+func readCheckpoint(dir string) (*checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dir, CheckpointFilename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no checkpoint to resume from in %s", dir)
+		}
+
+		return nil, err
+	}
+
+	var c checkpoint
+
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint in %s: %w", dir, err)
+	}
+
+	return &c, nil
+}
+
+// checkpointer records checkpoints of a split every interval, as the items of its lists are written
+type checkpointer struct {
+	filename string
+	interval time.Duration
+	last     time.Time
+	base     int64 // offset in the input of the start of the data parsed
+	itr      *BufferedByteStreamIter
+	keys     []ManifestKey
+	mode     os.FileMode // the permissions the checkpoint is written with, the default when it isn't set
+}
+
+// newCheckpointer returns a *checkpointer recording checkpoints in the local directory dir, or nil if
+// opts.CheckpointInterval isn't set. When resume is set the split carries on from it and keeps its finished keys.
+func newCheckpointer(dir string, opts Options, resume *checkpoint) *checkpointer {
+	if opts.CheckpointInterval <= 0 {
+		return nil
+	}
+
+	cp := &checkpointer{
+		filename: filepath.Join(dir, CheckpointFilename),
+		interval: opts.CheckpointInterval,
+		last:     time.Now(),
+		mode:     opts.FileMode,
+	}
+
+	if resume != nil {
+		cp.base = resume.Offset
+		cp.keys = resume.Keys
+	}
+
+	return cp
+}
+
+// track returns a ListAddFunc passing the items of the key's list to add, recording a checkpoint after an item has
+// been written if one is due. root and rootRecords are the part of root.json parsed before the list, or nil for a
+// document which is a json array.
+func (cp *checkpointer) track(sk *splitKey, add ListAddFunc, root []byte, rootRecords int64) ListAddFunc {
+	if cp == nil {
+		return add
+	}
+
+	return func(item []byte) error {
+		err := add(item)
+		if err != nil || time.Since(cp.last) < cp.interval {
+			return err
+		}
+
+		return cp.save(sk, root, rootRecords)
+	}
+}
+
+// finished records that the key has been finished, so it is left out of the files written by a resumed split
+func (cp *checkpointer) finished(sk *splitKey) {
+	if cp == nil {
+		return
+	}
+
+	var m Manifest
+
+	mk := m.addKey(sk.name, sk.factory, sk.wr)
+	if mk != nil {
+		cp.keys = append(cp.keys, *mk)
+	}
+}
+
+// reopened records that a key which had been finished is being written again, having appeared a second time in the
+// document, so that the checkpoint resumes it rather than leaving it out
+func (cp *checkpointer) reopened(sk *splitKey) {
+	if cp == nil {
+		return
+	}
+
+	keys := cp.keys[:0]
+	for _, mk := range cp.keys {
+		if mk.Key != sk.name {
+			keys = append(keys, mk)
+		}
+	}
+
+	cp.keys = keys
+}
+
+// save flushes the file being written for the key and records a checkpoint just after the last item written to it.
+// The checkpoint is written to a temporary file which is then renamed, so an interruption leaves the previous one.
+func (cp *checkpointer) save(sk *splitKey, root []byte, rootRecords int64) error {
+	err := sk.wr.flush()
+	if err != nil {
+		return err
+	}
+
+	c := checkpoint{
+		Offset:      cp.base + cp.itr.Offset(),
+		Key:         sk.name,
+		RootList:    root == nil,
+		Open:        sk.wr.wr != nil,
+		Keys:        cp.keys,
+		Root:        string(root),
+		RootRecords: rootRecords,
+	}
+
+	var m Manifest
+	if mk := m.addKey(sk.name, sk.factory, sk.wr); mk != nil {
+		c.Files = mk.Files
+	}
+
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := cp.filename + ".tmp"
+
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if cp.mode != 0 {
+		err = os.Chmod(tmp, cp.mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Rename(tmp, cp.filename)
+	if err != nil {
+		return err
+	}
+
+	cp.last = time.Now()
+
+	return nil
+}
+
+// remove removes the checkpoint once the split has completed
+func (cp *checkpointer) remove() error {
+	if cp == nil {
+		return nil
+	}
+
+	return removeCheckpoint(filepath.Dir(cp.filename))
+}
+
+// removeCheckpoint removes the checkpoint recorded in the local directory dir, if there is one
+func removeCheckpoint(dir string) error {
+	err := os.Remove(filepath.Join(dir, CheckpointFilename))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// resumeKey returns the *splitKey for the list which was being written when the checkpoint was recorded, restoring
+// the files written for it and appending to the last of them if it wasn't finished
+func resumeKey(sink OutputSink, c *checkpoint, opts Options) (*splitKey, error) {
+	sk := newSplitKey(sink, c.Key, fileKey(c.Key), opts)
+	sk.isList = true
+
+	wr, err := sk.factory.resume(c.Files, c.Open)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]int64, len(c.Files))
+	for i, file := range c.Files {
+		records[i] = file.Records
+	}
+
+	var writtenBytes uint64
+	if c.Open && len(c.Files) > 0 {
+		writtenBytes = uint64(c.Files[len(c.Files)-1].Bytes)
+	}
+
+	sk.wr.resume(wr, records, writtenBytes)
+
+	return sk, nil
+}
+
+// asyncReaderFromOffset creates an AsyncReader reading uri from offset onwards, for resuming a split from a
+// checkpoint. uri can be an uncompressed local file, http(s) URL or cloud storage object, which is read from the offset
+// as it is stored.
+func asyncReaderFromOffset(uri string, offset int64, bufferSize int, opts []AsyncReaderOption) (*AsyncReader, error) {
+	if uri == "-" || isUnixSocket(uri) || isSSHPath(uri) ||
+		(cloud.IsCloudURI(uri) && cloud.IsPattern(uri)) {
+		return nil, fmt.Errorf("can't resume reading %s from an offset", uri)
+	}
+
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if afr.byteRange != nil {
+		return nil, errors.New("a byte range can't be read when resuming a split")
+	}
+
+	// requests for the input are aborted along with the AsyncReader
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r, size, err := afr.openFrom(ctx, uri, offset, -1)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	afr.rd = r
+	afr.closers = []io.Closer{r, closerFunc(func() error {
+		cancel()
+		return nil
+	})}
+	afr.abort = cancel
+	afr.totalSize = size
+	afr.seekable = true
+
+	return afr, nil
+}
+
+// openFrom opens uri for reading length bytes from offset, or the rest of uri when length is negative, returning the
+// source along with the number of bytes left to read, or -1 if it isn't known
+func (afr *AsyncReader) openFrom(ctx context.Context, uri string, offset, length int64) (io.ReadCloser, int64, error) {
+	switch {
+	case cloud.IsAzureBlobURI(uri):
+		r, size, err := afr.cloudClient().NewAzureRangeReader(ctx, uri, offset, length)
+		if err != nil {
+			afr.closeCloud()
+			return nil, 0, err
+		}
+
+		return r, size, nil
+
+	case strings.HasPrefix(uri, "http"):
+		resp, err := httpGetFrom(ctx, http.DefaultClient, uri, offset, length)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return resp.Body, resp.ContentLength, nil
+
+	case cloud.IsCloudURI(uri):
+		client := afr.cloudClient()
+
+		r, err := client.NewRangeReader(ctx, uri, offset, length)
+		if err != nil {
+			afr.closeCloud()
+			return nil, 0, err
+		}
+
+		reopen := func(_ context.Context, read int64) (io.ReadCloser, error) {
+			if length < 0 {
+				return client.NewRangeReader(ctx, uri, offset+read, -1)
+			}
+
+			return client.NewRangeReader(ctx, uri, offset+read, length-read)
+		}
+
+		return newResumableReader(r, reopen, afr.retry), rangeSize(r.Size(), offset, length), nil
+	}
+
+	f, err := os.Open(uri)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fi, err := f.Stat()
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+
+	if length < 0 {
+		return f, rangeSize(fi.Size(), offset, length), nil
+	}
+
+	return limitedFile{Reader: io.LimitReader(f, length), Closer: f}, rangeSize(fi.Size(), offset, length), nil
+}
+
+// limitedFile reads the part of a file a byte range covers, closing the file when it is closed
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// rangeSize returns the number of bytes of a source of the given size which are read from offset, up to length bytes
+// of them unless length is negative
+func rangeSize(size, offset, length int64) int64 {
+	size -= offset
+	if length >= 0 && length < size {
+		size = length
+	}
+
+	if size < 0 {
+		return 0
+	}
+
+	return size
+}
@@ -0,0 +1,180 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// checkpointDoc returns a document with a list of n items under each of the keys, and a scalar between them
+func checkpointDoc(keys []string, n int) string {
+	var sb strings.Builder
+
+	sb.WriteString("{")
+
+	for k, key := range keys {
+		fmt.Fprintf(&sb, "%q: %d, %q: [", key+"_count", n, key)
+
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+
+			fmt.Fprintf(&sb, `{"key": %q, "idx": %d}`, key, i)
+		}
+
+		sb.WriteString("]")
+
+		if k < len(keys)-1 {
+			sb.WriteString(",\n")
+		}
+	}
+
+	sb.WriteString("}")
+
+	return sb.String()
+}
+
+// requireSameFiles checks that the directories contain the same files with the same contents
+func requireSameFiles(t *testing.T, expectedDir, dir string) {
+	expected, err := os.ReadDir(expectedDir)
+	require.NoError(t, err)
+
+	actual, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, len(expected), len(actual))
+
+	for _, entry := range expected {
+		data, err := os.ReadFile(filepath.Join(expectedDir, entry.Name()))
+		require.NoError(t, err)
+
+		requireContents(t, filepath.Join(dir, entry.Name()), string(data))
+	}
+}
+
+func TestSplitFileResume(t *testing.T) {
+	tests := map[string]struct {
+		doc  string
+		opts Options
+	}{
+		"object": {
+			doc:  checkpointDoc([]string{"a", "b", "c"}, 50),
+			opts: Options{MaxFileBytes: 200},
+		},
+		"ndjson": {
+			doc:  checkpointDoc([]string{"a", "b"}, 50),
+			opts: Options{Format: FormatNDJSON, MaxFileBytes: 300},
+		},
+		"root list": {
+			doc:  "[" + strings.Repeat(`{"idx": 1}, `, 100) + `{"idx": 2}]`,
+			opts: Options{MaxFileBytes: 150},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "input.json")
+			expectedDir := filepath.Join(dir, "expected")
+			outputDir := filepath.Join(dir, "output")
+
+			require.NoError(t, os.WriteFile(input, []byte(test.doc), 0o644))
+			require.NoError(t, SplitFile(context.Background(), input, expectedDir, test.opts))
+
+			// the split is interrupted part way through the second list by the input being cut short
+			require.NoError(t, os.WriteFile(input, []byte(test.doc[:len(test.doc)*2/3]), 0o644))
+
+			opts := test.opts
+			opts.CheckpointInterval = time.Nanosecond
+
+			require.Error(t, SplitFile(context.Background(), input, outputDir, opts))
+			require.FileExists(t, filepath.Join(outputDir, CheckpointFilename))
+			require.NoFileExists(t, filepath.Join(outputDir, ManifestFilename))
+
+			require.NoError(t, os.WriteFile(input, []byte(test.doc), 0o644))
+
+			opts.Resume = true
+			require.NoError(t, SplitFile(context.Background(), input, outputDir, opts))
+
+			require.NoFileExists(t, filepath.Join(outputDir, CheckpointFilename))
+			requireSameFiles(t, expectedDir, outputDir)
+		})
+	}
+}
+
+func TestSplitFileResumeWithoutInterval(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	expectedDir := filepath.Join(dir, "expected")
+	outputDir := filepath.Join(dir, "output")
+	doc := checkpointDoc([]string{"a", "b"}, 50)
+
+	require.NoError(t, os.WriteFile(input, []byte(doc), 0o644))
+	require.NoError(t, SplitFile(context.Background(), input, expectedDir, Options{}))
+
+	require.NoError(t, os.WriteFile(input, []byte(doc[:len(doc)*2/3]), 0o644))
+	require.Error(t, SplitFile(context.Background(), input, outputDir, Options{CheckpointInterval: time.Nanosecond}))
+	require.FileExists(t, filepath.Join(outputDir, CheckpointFilename))
+
+	// the checkpoint resumed from is removed once the split completes, though no more are recorded
+	require.NoError(t, os.WriteFile(input, []byte(doc), 0o644))
+	require.NoError(t, SplitFile(context.Background(), input, outputDir, Options{Resume: true}))
+
+	require.NoFileExists(t, filepath.Join(outputDir, CheckpointFilename))
+	requireSameFiles(t, expectedDir, outputDir)
+}
+
+func TestSplitFileResumeWithoutCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	require.NoError(t, os.WriteFile(input, []byte(`{"a": [1]}`), 0o644))
+
+	err := SplitFile(context.Background(), input, dir, Options{Resume: true})
+	require.EqualError(t, err, fmt.Sprintf("no checkpoint to resume from in %s", dir))
+}
+
+func TestSplitFileCheckpointUnseekableInput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json.gz")
+	require.NoError(t, os.WriteFile(input, gzipBytes(t, []byte(`{"a": [1]}`)), 0o644))
+
+	err := SplitFile(context.Background(), input, filepath.Join(dir, "out"), Options{CheckpointInterval: time.Second})
+	require.EqualError(t, err, fmt.Sprintf("checkpoints can't be recorded reading %s, as it can't be read from an offset", input))
+}
+
+func TestValidateCheckpoints(t *testing.T) {
+	tests := map[string]Options{
+		"concurrency":       {Concurrency: 2},
+		"shards":            {Shards: 2},
+		"csv output":        {Format: FormatCSV},
+		"schema inference":  {InferSchema: true},
+		"compressed output": {CompressOutput: true},
+		"skipping errors":   {SkipErrors: true},
+		"a dry run":         {DryRun: true},
+		"a limit":           {Limit: 10},
+		"checksums":         {Checksums: true},
+		"json5 input":       {JSON5: true},
+		"deduplication":     {DedupField: "id"},
+		"normalized names":  {NormalizeNames: true},
+		"partitions":        {PartitionBy: "region"},
+		"annotations":       {IndexField: DefaultIndexField},
+	}
+
+	for option, opts := range tests {
+		opts.CheckpointInterval = time.Second
+		require.EqualError(t, opts.validate(), "checkpoints can't be used with "+option)
+
+		opts.CheckpointInterval = 0
+		opts.Resume = true
+		require.EqualError(t, opts.validate(), "checkpoints can't be used with "+option)
+	}
+
+	opts := Options{CheckpointInterval: -time.Second}
+	require.EqualError(t, opts.validate(), "checkpoint interval must not be negative, got -1s")
+}
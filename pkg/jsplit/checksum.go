@@ -0,0 +1,153 @@
+package jsplit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+)
+
+// checksumWriteCloser computes the SHA-256 digest of the data written to an io.WriteCloser, storing it once the
+// io.WriteCloser is closed
+type checksumWriteCloser struct {
+	io.WriteCloser
+	hash hash.Hash
+	sum  *string
+}
+
+// newChecksumWriteCloser returns a *checksumWriteCloser writing to wr, which stores the hex encoded digest in sum
+func newChecksumWriteCloser(wr io.WriteCloser, sum *string) *checksumWriteCloser {
+	return &checksumWriteCloser{WriteCloser: wr, hash: sha256.New(), sum: sum}
+}
+
+// Write writes p to the io.WriteCloser adding the bytes written to the digest
+func (cwc *checksumWriteCloser) Write(p []byte) (int, error) {
+	n, err := cwc.WriteCloser.Write(p)
+	cwc.hash.Write(p[:n])
+
+	return n, err
+}
+
+// Sync syncs the io.WriteCloser
+func (cwc *checksumWriteCloser) Sync() error {
+	return syncWriter(cwc.WriteCloser)
+}
+
+// Close closes the io.WriteCloser and stores the digest of everything written to it
+func (cwc *checksumWriteCloser) Close() error {
+	*cwc.sum = hex.EncodeToString(cwc.hash.Sum(nil))
+
+	return cwc.WriteCloser.Close()
+}
+
+// checksum returns the hex encoded SHA-256 digest of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyChecksums checks the files listed in the manifest in dir against the SHA-256 digests recorded when they were
+// written with Options.Checksums set, returning the number of files verified. dir can be a local directory or a cloud
+// storage URI. Every file is checked, and the error lists each file which is missing or doesn't match its digest.
+func VerifyChecksums(ctx context.Context, dir string) (int, error) {
+	ds := &dirSink{ctx: ctx, dir: dir}
+
+	data, err := readOutputFile(ctx, ds.path(ManifestFilename))
+	if err != nil {
+		return 0, err
+	}
+
+	var m Manifest
+
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return 0, fmt.Errorf("invalid manifest in %s: %w", dir, err)
+	}
+
+	files := m.files()
+
+	var (
+		verified int
+		failures []string
+	)
+
+	for _, file := range files {
+		if file.SHA256 == "" {
+			continue
+		}
+
+		sum, err := fileChecksum(ctx, ds.path(file.Name))
+
+		switch {
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("%s: %s", file.Name, err))
+		case sum != file.SHA256:
+			failures = append(failures, fmt.Sprintf("%s: checksum %s doesn't match %s", file.Name, sum, file.SHA256))
+		default:
+			verified++
+		}
+	}
+
+	if len(failures) > 0 {
+		return verified, fmt.Errorf("%d files failed verification:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+
+	if verified == 0 && len(files) > 0 {
+		return 0, errors.New("the manifest has no checksums, the files weren't written with checksums")
+	}
+
+	return verified, nil
+}
+
+// openOutputFile opens a file written by a split, which can be a local file or a cloud storage object
+func openOutputFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if cloud.IsCloudURI(filename) {
+		r, err := cloud.NewReader(ctx, filename, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	}
+
+	return os.Open(filename)
+}
+
+// readOutputFile returns the contents of a file written by a split
+func readOutputFile(ctx context.Context, filename string) ([]byte, error) {
+	r, err := openOutputFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// fileChecksum returns the hex encoded SHA-256 digest of a file written by a split
+func fileChecksum(ctx context.Context, filename string) (string, error) {
+	r, err := openOutputFile(ctx, filename)
+	if err != nil {
+		return "", err
+	}
+
+	defer r.Close()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,76 @@
+package jsplit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStreamChecksums(t *testing.T) {
+	const doc = `{"key": "value", "list": [{"idx": 0}, {"idx": 1}, {"idx": 2}], "other": [{"a": 1, "b": 2}]}`
+
+	for name, opts := range map[string]Options{
+		"jsonl":      {Checksums: true, MaxFileBytes: 10},
+		"compressed": {Checksums: true, CompressOutput: true},
+		"csv":        {Checksums: true, Format: FormatCSV},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			bs := NewTestByteStream([]byte(doc), 8)
+			require.NoError(t, SplitStream(context.Background(), bs, tempDir, opts))
+
+			manifest := readManifest(t, tempDir)
+
+			files := []ManifestFile{*manifest.Root}
+			for _, mk := range manifest.Keys {
+				files = append(files, mk.Files...)
+			}
+
+			// each digest is of the file as written
+			for _, file := range files {
+				data, err := os.ReadFile(filepath.Join(tempDir, file.Name))
+				require.NoError(t, err)
+
+				sum := sha256.Sum256(data)
+				require.Equal(t, hex.EncodeToString(sum[:]), file.SHA256, file.Name)
+			}
+
+			verified, err := VerifyChecksums(context.Background(), tempDir)
+			require.NoError(t, err)
+			require.Equal(t, len(files), verified)
+		})
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	const doc = `{"key": "value", "list": [{"idx": 0}], "other": [{"idx": 1}]}`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{Checksums: true}))
+
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "list_00.jsonl"), []byte(`{"idx":9}`), 0o644))
+	require.NoError(t, os.Remove(filepath.Join(tempDir, "other_00.jsonl")))
+
+	verified, err := VerifyChecksums(context.Background(), tempDir)
+	require.Error(t, err)
+	require.Equal(t, 1, verified)
+	require.Contains(t, err.Error(), "2 files failed verification")
+	require.Contains(t, err.Error(), "list_00.jsonl: checksum")
+	require.Contains(t, err.Error(), "other_00.jsonl: open")
+
+	// a split without checksums can't be verified
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{}))
+	require.Empty(t, readManifest(t, tempDir).Root.SHA256)
+
+	_, err = VerifyChecksums(context.Background(), tempDir)
+	require.EqualError(t, err, "the manifest has no checksums, the files weren't written with checksums")
+}
@@ -0,0 +1,319 @@
+package jsplit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CSVQuote is the policy for quoting the fields of csv files
+type CSVQuote string
+
+const (
+	// CSVQuoteMinimal quotes the fields which need it, those holding the delimiter, a quote or a line break, or
+	// starting with a space, and strings which read as the CSVDialect's Null, as encoding/csv does
+	CSVQuoteMinimal CSVQuote = "minimal"
+
+	// CSVQuoteAll quotes every field but nulls, so that they can be told apart from strings
+	CSVQuoteAll CSVQuote = "all"
+
+	// CSVQuoteNone quotes nothing, escaping backslashes, delimiters and line breaks within fields with a backslash
+	// instead, as the text format of Postgres COPY reads them
+	CSVQuoteNone CSVQuote = "none"
+)
+
+// CSVDialect sets how csv files are written, to match the loaders which read them, e.g. tab delimited with \N for
+// null for Postgres COPY. The zero value writes comma delimited rows ending with \n, quoted as needed, with nulls as
+// empty fields.
+type CSVDialect struct {
+	// Delimiter separates the fields of each row, a comma when it isn't set. It can't be a quote, a backslash or a
+	// line break.
+	Delimiter rune
+
+	// CRLF ends each row with \r\n rather than \n
+	CRLF bool
+
+	// Quote is how fields are quoted, CSVQuoteMinimal when it isn't set
+	Quote CSVQuote
+
+	// Null is written for json nulls, e.g. \N, and is empty when it isn't set. It is never quoted or escaped.
+	Null string
+}
+
+// delimiter returns the rune separating the fields of each row
+func (cd CSVDialect) delimiter() rune {
+	if cd.Delimiter == 0 {
+		return ','
+	}
+
+	return cd.Delimiter
+}
+
+// validate checks the delimiter, quoting policy and null can be written and read back
+func (cd CSVDialect) validate() error {
+	delim := cd.delimiter()
+	if delim == '"' || delim == '\\' || delim == '\r' || delim == '\n' || !utf8.ValidRune(delim) ||
+		delim == utf8.RuneError {
+		return fmt.Errorf("invalid csv delimiter %q", delim)
+	}
+
+	switch cd.Quote {
+	case "", CSVQuoteMinimal, CSVQuoteAll, CSVQuoteNone:
+	default:
+		return fmt.Errorf("unsupported csv quoting %q, use minimal, all or none", cd.Quote)
+	}
+
+	if strings.ContainsRune(cd.Null, delim) || strings.ContainsAny(cd.Null, "\"\r\n") {
+		return fmt.Errorf("the csv null %q must not contain the delimiter, a quote or a line break", cd.Null)
+	}
+
+	return nil
+}
+
+// CsvWriteCloser receives jsonl formatted data, one json object per line, and writes it to an io.WriteCloser as csv.
+// The header row is the union of the keys of every object, in the order they are first seen, with cells left empty
+// for keys an object doesn't have. Strings are written unquoted, nulls as empty cells, and nested objects and arrays
+// as json. The delimiter, line endings, quoting and nulls are those of its CSVDialect.
+//
+// As the header can't be known until every object has been seen, the jsonl data is spilled to a temporary file and
+// converted once Close is called. This keeps memory use independent of the size of the output at the cost of writing
+// the data to disk twice and decoding it twice, once to find the header and once to write the rows.
+type CsvWriteCloser struct {
+	wr      io.WriteCloser
+	spill   *jsonlSpill
+	dialect CSVDialect
+}
+
+// NewCsvWriteCloser returns a CsvWriteCloser object which writes csv to the supplied io.WriteCloser
+func NewCsvWriteCloser(wr io.WriteCloser) (*CsvWriteCloser, error) {
+	return newCsvWriteCloser(wr, "", CSVDialect{})
+}
+
+// NewCsvWriteCloserDialect returns a CsvWriteCloser object which writes csv in the given dialect to the supplied
+// io.WriteCloser
+func NewCsvWriteCloserDialect(wr io.WriteCloser, dialect CSVDialect) (*CsvWriteCloser, error) {
+	err := dialect.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return newCsvWriteCloser(wr, "", dialect)
+}
+
+// newCsvWriteCloser returns a CsvWriteCloser spilling the jsonl data to a temporary file in tempDir, or in the default
+// directory for temporary files when it is empty, see Options.TempDir
+func newCsvWriteCloser(wr io.WriteCloser, tempDir string, dialect CSVDialect) (*CsvWriteCloser, error) {
+	spill, err := newJsonlSpill(tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CsvWriteCloser{wr: wr, spill: spill, dialect: dialect}, nil
+}
+
+// Write spills jsonl data to the temporary file
+func (cwc *CsvWriteCloser) Write(p []byte) (int, error) {
+	return cwc.spill.Write(p)
+}
+
+// Close converts the spilled jsonl data to csv, closes the supplied io.WriteCloser, and removes the temporary file.
+// The io.WriteCloser is closed even if the conversion fails.
+func (cwc *CsvWriteCloser) Close() error {
+	convertErr := cwc.convert()
+
+	spillErr := cwc.spill.close()
+	err := cwc.wr.Close()
+
+	switch {
+	case convertErr != nil:
+		return convertErr
+	case spillErr != nil:
+		return spillErr
+	default:
+		return err
+	}
+}
+
+// convert writes the header and then a row for every object in the spilled data
+func (cwc *CsvWriteCloser) convert() error {
+	err := cwc.spill.flush()
+	if err != nil {
+		return err
+	}
+
+	var (
+		header  []string
+		columns = make(map[string]int)
+	)
+
+	err = cwc.spill.eachObject(FormatCSV, func(fields []objectField) error {
+		for _, field := range fields {
+			if _, ok := columns[field.Key]; !ok {
+				columns[field.Key] = len(header)
+				header = append(header, field.Key)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	csvWr := &csvRowWriter{wr: bufio.NewWriterSize(cwc.wr, 64*1024), dialect: cwc.dialect}
+
+	err = csvWr.write(header, nil)
+	if err != nil {
+		return err
+	}
+
+	row := make([]string, len(header))
+	nulls := make([]bool, len(header))
+
+	err = cwc.spill.eachObject(FormatCSV, func(fields []objectField) error {
+		for i := range row {
+			row[i] = ""
+			nulls[i] = false
+		}
+
+		for _, field := range fields {
+			cell, isNull, err := csvCell(field.Value)
+			if err != nil {
+				return err
+			}
+
+			row[columns[field.Key]] = cell
+			nulls[columns[field.Key]] = isNull
+		}
+
+		return csvWr.write(row, nulls)
+	})
+	if err != nil {
+		return err
+	}
+
+	return csvWr.wr.Flush()
+}
+
+// csvCell returns the text written to a csv cell for a json value, and whether it is null
+func csvCell(val json.RawMessage) (string, bool, error) {
+	switch {
+	case len(val) == 0 || bytes.Equal(val, nullValue):
+		return "", true, nil
+	case val[0] == QM:
+		var s string
+
+		err := json.Unmarshal(val, &s)
+
+		return s, false, err
+	default:
+		return string(val), false, nil
+	}
+}
+
+// csvRowWriter writes the rows of a csv file in a CSVDialect
+type csvRowWriter struct {
+	wr      *bufio.Writer
+	dialect CSVDialect
+}
+
+// write writes a row of fields, those which are set in nulls, which can be nil, as the dialect's null
+func (crw *csvRowWriter) write(row []string, nulls []bool) error {
+	delim := crw.dialect.delimiter()
+
+	for i, field := range row {
+		if i > 0 {
+			_, _ = crw.wr.WriteRune(delim)
+		}
+
+		switch {
+		case nulls != nil && nulls[i]:
+			_, _ = crw.wr.WriteString(crw.dialect.Null)
+		case crw.dialect.Quote == CSVQuoteNone:
+			crw.writeEscaped(field, delim)
+		case crw.dialect.Quote == CSVQuoteAll || crw.needsQuotes(field, delim):
+			crw.writeQuoted(field)
+		default:
+			_, _ = crw.wr.WriteString(field)
+		}
+	}
+
+	// the writer keeps the first error it was given
+	_, err := crw.wr.WriteString(crw.lineEnding())
+
+	return err
+}
+
+// lineEnding returns the text ending each row
+func (crw *csvRowWriter) lineEnding() string {
+	if crw.dialect.CRLF {
+		return "\r\n"
+	}
+
+	return "\n"
+}
+
+// needsQuotes reports whether a field has to be quoted to be read back as it is, as encoding/csv decides, or because
+// it would otherwise read as the dialect's null
+func (crw *csvRowWriter) needsQuotes(field string, delim rune) bool {
+	switch {
+	case field == "":
+		return false
+	case field == crw.dialect.Null, field == `\.`:
+		return true
+	case strings.ContainsRune(field, delim) || strings.ContainsAny(field, "\"\r\n"):
+		return true
+	}
+
+	r, _ := utf8.DecodeRuneInString(field)
+
+	return unicode.IsSpace(r)
+}
+
+// writeQuoted writes the field within quotes, doubling the quotes within it, with the line breaks within it written
+// as the dialect's line endings, as encoding/csv does
+func (crw *csvRowWriter) writeQuoted(field string) {
+	_ = crw.wr.WriteByte('"')
+
+	for _, r := range field {
+		switch r {
+		case '"':
+			_, _ = crw.wr.WriteString(`""`)
+		case '\r':
+			if !crw.dialect.CRLF {
+				_ = crw.wr.WriteByte('\r')
+			}
+		case '\n':
+			_, _ = crw.wr.WriteString(crw.lineEnding())
+		default:
+			_, _ = crw.wr.WriteRune(r)
+		}
+	}
+
+	_ = crw.wr.WriteByte('"')
+}
+
+// writeEscaped writes the field without quotes, escaping backslashes, the delimiter and line breaks within it with a
+// backslash, as Postgres COPY's text format does
+func (crw *csvRowWriter) writeEscaped(field string, delim rune) {
+	for _, r := range field {
+		switch r {
+		case '\\':
+			_, _ = crw.wr.WriteString(`\\`)
+		case '\r':
+			_, _ = crw.wr.WriteString(`\r`)
+		case '\n':
+			_, _ = crw.wr.WriteString(`\n`)
+		case delim:
+			_ = crw.wr.WriteByte('\\')
+			_, _ = crw.wr.WriteRune(r)
+		default:
+			_, _ = crw.wr.WriteRune(r)
+		}
+	}
+}
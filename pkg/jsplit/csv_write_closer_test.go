@@ -0,0 +1,219 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCsvWriteCloser(t *testing.T) {
+	buf := NewBufWriteCloser()
+	cwc, err := NewCsvWriteCloser(buf)
+	require.NoError(t, err)
+
+	lines := `{"id":1,"name":"alex","tags":["a","b"]}
+{"name":"brian, \"b\"","id":2.50,"address":{"city":"x"}}
+{"id":3,"name":null,"active":true}`
+
+	_, err = cwc.Write([]byte(lines))
+	require.NoError(t, err)
+	require.NoError(t, cwc.Close())
+
+	expected := `id,name,tags,address,active
+1,alex,"[""a"",""b""]",,
+2.50,"brian, ""b""",,"{""city"":""x""}",
+3,,,,true
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestCsvWriteCloserDialect(t *testing.T) {
+	lines := `{"id":1,"name":"tab\tand|pipe","note":null}
+{"id":2,"name":"line\nbreak \\ \"q\"","note":"\\N"}
+{"id":3,"name":" lead","note":""}`
+
+	for name, tc := range map[string]struct {
+		dialect  CSVDialect
+		expected string
+	}{
+		// Postgres COPY's text format, in which strings can't be mistaken for nulls, as their backslashes are escaped
+		"postgres text": {CSVDialect{Delimiter: '\t', Quote: CSVQuoteNone, Null: `\N`},
+			"id\tname\tnote\n" +
+				"1\ttab\\\tand|pipe\t\\N\n" +
+				`2` + "\t" + `line\nbreak \\ "q"` + "\t" + `\\N` + "\n" +
+				"3\t lead\t\n"},
+		// quoted as needed, including strings which would read as the null
+		"minimal": {CSVDialect{Delimiter: '|', CRLF: true, Null: `\N`},
+			"id|name|note\r\n" +
+				"1|\"tab\tand|pipe\"|\\N\r\n" +
+				`2|"line` + "\r\n" + `break \ ""q"""|"\N"` + "\r\n" +
+				"3|\" lead\"|\r\n"},
+		"all": {CSVDialect{Quote: CSVQuoteAll},
+			`"id","name","note"` + "\n" +
+				`"1","tab` + "\t" + `and|pipe",` + "\n" +
+				`"2","line` + "\n" + `break \ ""q""","\N"` + "\n" +
+				`"3"," lead",""` + "\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			buf := NewBufWriteCloser()
+			cwc, err := NewCsvWriteCloserDialect(buf, tc.dialect)
+			require.NoError(t, err)
+
+			_, err = cwc.Write([]byte(lines))
+			require.NoError(t, err)
+			require.NoError(t, cwc.Close())
+			require.Equal(t, tc.expected, buf.String())
+		})
+	}
+
+	for dialect, expected := range map[CSVDialect]string{
+		{Delimiter: '"'}:                 `invalid csv delimiter '"'`,
+		{Delimiter: '\n'}:                `invalid csv delimiter '\n'`,
+		{Quote: "sometimes"}:             `unsupported csv quoting "sometimes", use minimal, all or none`,
+		{Delimiter: '\t', Null: "\t"}:    `the csv null "\t" must not contain the delimiter, a quote or a line break`,
+		{Delimiter: '|', Null: `"null"`}: `the csv null "\"null\"" must not contain the delimiter, a quote or a line break`,
+	} {
+		_, err := NewCsvWriteCloserDialect(NewBufWriteCloser(), dialect)
+		require.EqualError(t, err, expected)
+	}
+}
+
+func TestCsvWriteCloserNonObject(t *testing.T) {
+	buf := NewBufWriteCloser()
+	cwc, err := NewCsvWriteCloser(buf)
+	require.NoError(t, err)
+
+	_, err = cwc.Write([]byte("{\"id\":1}\n[1,2]"))
+	require.NoError(t, err)
+	require.ErrorContains(t, cwc.Close(), "csv output requires lists of json objects")
+}
+
+func TestSplitStreamCSV(t *testing.T) {
+	var testStr = `{"key": "value", "users": [{"id": 1, "name": "alex"}, {"id": 2, "email": "b@example.com"}]}`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{Format: FormatCSV})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "users_00.csv"), "id,name,email\n1,alex,\n2,,b@example.com\n")
+
+	// the dialect of the files is set by the options
+	dialectDir := t.TempDir()
+	bs = NewTestByteStream([]byte(`{"users": [{"id": 1, "name": "a\tb"}, {"id": 2, "name": null}]}`), 16)
+	require.NoError(t, SplitStream(context.Background(), bs, dialectDir,
+		Options{Format: FormatCSV, CSV: CSVDialect{Delimiter: '\t', Quote: CSVQuoteNone, Null: `\N`}}))
+	requireContents(t, filepath.Join(dialectDir, "users_00.csv"), "id\tname\n1\ta\\\tb\n2\t\\N\n")
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(testStr), 16), t.TempDir(),
+		Options{Format: FormatCSV, CSV: CSVDialect{Delimiter: '\r'}})
+	require.EqualError(t, err, `invalid csv delimiter '\r'`)
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"key\":\"value\"\n}")
+
+	bs = NewTestByteStream([]byte(testStr), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Format: "xml"})
+	require.Error(t, err)
+}
+
+func TestSplitStreamKeyFormats(t *testing.T) {
+	const doc = `{"users": [{"id": 1, "name": "alex"}, {"id": 2, "name": "sam"}], "events": [{"e": "a"}, {"e": "b"}],
+		"tags": ["x", "y"]}`
+
+	// each key is written in its own format, and the rest in the default
+	tempDir := t.TempDir()
+	opts := Options{Format: FormatJSONL, KeyFormats: map[string]Format{"users": FormatCSV, "events": FormatNDJSON}}
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), tempDir, opts))
+
+	requireContents(t, filepath.Join(tempDir, "users_00.csv"), "id,name\n1,alex\n2,sam\n")
+	requireContents(t, filepath.Join(tempDir, "events_00.ndjson"), `{"e":"a"}`+"\n"+`{"e":"b"}`+"\n")
+	requireContents(t, filepath.Join(tempDir, "tags_00.jsonl"), `"x"`+"\n"+`"y"`)
+
+	var files []string
+	for _, k := range readManifest(t, tempDir).Keys {
+		for _, f := range k.Files {
+			files = append(files, f.Name)
+		}
+	}
+
+	require.Equal(t, []string{"users_00.csv", "events_00.ndjson", "tags_00.jsonl"}, files)
+
+	// the elements of a document which is a json array use the format of the root list key
+	tempDir = t.TempDir()
+	opts = Options{KeyFormats: map[string]Format{DefaultRootListKey: FormatCSV}}
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(`[{"a": 1}, {"a": 2}]`), 8), tempDir,
+		opts))
+	requireContents(t, filepath.Join(tempDir, "root_00.csv"), "a\n1\n2\n")
+
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), t.TempDir(),
+		Options{KeyFormats: map[string]Format{"users": "xml"}})
+	require.EqualError(t, err, `unsupported output format "xml" for users`)
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), t.TempDir(),
+		Options{Pretty: true, KeyFormats: map[string]Format{"users": FormatCSV}})
+	require.EqualError(t, err, "pretty output can't be written as csv")
+
+	opts = Options{CheckpointInterval: time.Second, KeyFormats: map[string]Format{"users": FormatCSV}}
+	require.EqualError(t, opts.validate(), "checkpoints can't be used with csv output")
+}
+
+func TestSplitStreamCSVTempDir(t *testing.T) {
+	var items []string
+	for i := 0; i < 100; i++ {
+		items = append(items, fmt.Sprintf(`{"idx": %d}`, i))
+	}
+
+	doc := `{"list": [` + strings.Join(items, ", ") + `]}`
+
+	// the jsonl is spilled to the temp dir while the split runs, and removed once it is done
+	tempDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(doc), 16)
+	require.NoError(t, SplitStream(context.Background(), bs, outputDir, Options{Format: FormatCSV, TempDir: tempDir}))
+	require.FileExists(t, filepath.Join(outputDir, "list_00.csv"))
+	requireEmptyDir(t, tempDir)
+
+	// and when the split is cancelled part way through
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var spilled int
+
+	cbs := &cancellingByteStream{
+		ByteStream:  NewTestByteStream([]byte(doc), 16),
+		cancelAfter: len(doc) / 2 / 16,
+		cancel: func() {
+			entries, err := os.ReadDir(tempDir)
+			require.NoError(t, err)
+			spilled = len(entries)
+
+			cancel()
+		},
+	}
+
+	err := SplitStream(ctx, cbs, t.TempDir(), Options{Format: FormatCSV, TempDir: tempDir})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, spilled)
+	requireEmptyDir(t, tempDir)
+
+	// a temp dir which doesn't exist fails the split
+	bs = NewTestByteStream([]byte(doc), 16)
+	err = SplitStream(context.Background(), bs, t.TempDir(),
+		Options{Format: FormatCSV, TempDir: filepath.Join(tempDir, "missing")})
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// requireEmptyDir checks that nothing is left in dir
+func requireEmptyDir(t *testing.T, dir string) {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,83 @@
+package jsplit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDateFormat is the layout the dates of partitions are formatted with when Options.DateFormat isn't set
+const DefaultDateFormat = "2006-01-02"
+
+// UnknownDatePartition is the date of the partition the elements partitioned by Options.PartitionByDate are written to
+// when they have no timestamp, or one which can't be parsed
+const UnknownDatePartition = "unknown"
+
+// datePartitionPrefix starts the name of the directory of each date partition, Hive style, e.g. dt=2024-01-01
+const datePartitionPrefix = "dt="
+
+// timestampLayouts are the layouts timestamps given as strings are parsed with, in turn
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// epochMillisThreshold is the magnitude from which epoch timestamps are taken to be in milliseconds rather than
+// seconds. In seconds it would be more than 30,000 years away, and in milliseconds it is September 2001.
+const epochMillisThreshold = 1e12
+
+// datePartition returns the name of the directory of the partition for the element's timestamp in opts.PartitionByDate,
+// e.g. dt=2024-01-01
+func datePartition(item []byte, opts Options) string {
+	date := UnknownDatePartition
+
+	value, isString, ok := fieldValue(item, opts.PartitionByDate)
+	if ok {
+		if ts, ok := parseTimestamp(value, isString); ok {
+			date = ts.UTC().Format(opts.dateFormat())
+		}
+	}
+
+	return datePartitionPrefix + date
+}
+
+// parseTimestamp parses the json value of a timestamp field, which is either a string in one of timestampLayouts, or a
+// number of seconds or milliseconds since the Unix epoch. Strings holding a number are parsed as numbers.
+func parseTimestamp(value string, isString bool) (time.Time, bool) {
+	if isString {
+		for _, layout := range timestampLayouts {
+			ts, err := time.Parse(layout, value)
+			if err == nil {
+				return ts, true
+			}
+		}
+	}
+
+	epoch, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(epoch, 0) || math.IsNaN(epoch) {
+		return time.Time{}, false
+	}
+
+	if math.Abs(epoch) >= epochMillisThreshold {
+		return time.UnixMilli(int64(epoch)), true
+	}
+
+	sec, frac := math.Modf(epoch)
+
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
+
+// validateDateFormat returns an error if dates formatted with the layout can't name a directory of the output
+func validateDateFormat(layout string) error {
+	date := time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC).Format(layout)
+	if layout == "" || date == layout || strings.ContainsAny(date, `/\`) || strings.Contains(date, "..") {
+		return fmt.Errorf("invalid date format %q, it must format a date without path separators", layout)
+	}
+
+	return nil
+}
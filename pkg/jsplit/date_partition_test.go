@@ -0,0 +1,107 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value    string
+		isString bool
+		expected time.Time
+	}{
+		{"2024-01-01T10:00:00Z", true, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{"2024-01-01T23:30:00.5-02:00", true, time.Date(2024, 1, 2, 1, 30, 0, 5e8, time.UTC)},
+		{"2024-01-01T10:00:00", true, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{"2024-01-01 10:00:00", true, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{"2024-01-01", true, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"1704103200", false, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{"1704103200.25", false, time.Date(2024, 1, 1, 10, 0, 0, 25e7, time.UTC)},
+		{"1704103200000", false, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{"1704103200000", true, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{"0", false, time.Unix(0, 0)},
+	}
+
+	for _, test := range tests {
+		ts, ok := parseTimestamp(test.value, test.isString)
+		require.True(t, ok, test.value)
+		require.True(t, test.expected.Equal(ts), "%s: %s", test.value, ts)
+	}
+
+	for _, value := range []string{"yesterday", "01/02/2024", "", "2024-13-01"} {
+		_, ok := parseTimestamp(value, true)
+		require.False(t, ok, value)
+	}
+
+	_, ok := parseTimestamp("true", false)
+	require.False(t, ok)
+}
+
+func TestSplitStreamDatePartitions(t *testing.T) {
+	tempDir := t.TempDir()
+
+	doc := `[{"id": 1, "ts": "2024-01-01T10:00:00Z"}, {"id": 2, "ts": 1704189600}, {"id": 3, "ts": "2024-01-01T23:00:00-05:00"},
+		{"id": 4}, {"id": 5, "ts": "soon"}, {"id": 6, "ts": 1704067200000}]`
+
+	// elements are written to the directory of their date in UTC, and the manifest is keyed by the directory
+	outputDir := filepath.Join(tempDir, "daily")
+	bs := NewTestByteStream([]byte(doc), 16)
+	require.NoError(t, SplitStream(context.Background(), bs, outputDir, Options{PartitionByDate: "ts", RootListKey: "users"}))
+
+	requireContents(t, filepath.Join(outputDir, "dt=2024-01-01", "users_00.jsonl"),
+		`{"id":1,"ts":"2024-01-01T10:00:00Z"}`+"\n"+`{"id":6,"ts":1704067200000}`)
+	requireContents(t, filepath.Join(outputDir, "dt=2024-01-02", "users_00.jsonl"),
+		`{"id":2,"ts":1704189600}`+"\n"+`{"id":3,"ts":"2024-01-01T23:00:00-05:00"}`)
+	requireContents(t, filepath.Join(outputDir, "dt=unknown", "users_00.jsonl"), `{"id":4}`+"\n"+`{"id":5,"ts":"soon"}`)
+
+	m := readManifest(t, outputDir)
+	require.Len(t, m.Keys, 3)
+	require.Equal(t, "dt=2024-01-01", m.Keys[0].Key)
+	require.Equal(t, "dt=2024-01-01/users_00.jsonl", m.Keys[0].Files[0].Name)
+	require.Equal(t, int64(2), m.Keys[0].Records)
+
+	// partitions closed to make room are appended to, and checksums of the files can be verified
+	outputDir = filepath.Join(tempDir, "monthly")
+	bs = NewTestByteStream([]byte(doc), 16)
+	opts := Options{PartitionByDate: "ts", DateFormat: "2006-01", Checksums: true, CompressOutput: true}
+	require.NoError(t, SplitStream(context.Background(), bs, outputDir, opts))
+
+	requireGzipContents(t, filepath.Join(outputDir, "dt=2024-01", "root_00.jsonl.gz"),
+		`{"id":1,"ts":"2024-01-01T10:00:00Z"}`+"\n"+`{"id":2,"ts":1704189600}`+"\n"+
+			`{"id":3,"ts":"2024-01-01T23:00:00-05:00"}`+"\n"+`{"id":6,"ts":1704067200000}`)
+
+	verified, err := VerifyChecksums(context.Background(), outputDir)
+	require.NoError(t, err)
+	require.Equal(t, 2, verified)
+
+	outputDir = filepath.Join(tempDir, "reopened")
+	bs = NewTestByteStream([]byte(doc), 16)
+	require.NoError(t, SplitStream(context.Background(), bs, outputDir, Options{PartitionByDate: "ts", MaxOpenPartitions: 1}))
+	requireContents(t, filepath.Join(outputDir, "dt=2024-01-02", "root_00.jsonl"),
+		`{"id":2,"ts":1704189600}`+"\n"+`{"id":3,"ts":"2024-01-01T23:00:00-05:00"}`)
+
+	// the lists of an object aren't partitioned by date, so the split fails rather than ignoring the field
+	bs = NewTestByteStream([]byte(`{"users": `+doc+`}`), 16)
+	err = SplitStream(context.Background(), bs, filepath.Join(tempDir, "object"), Options{PartitionByDate: "ts"})
+	require.EqualError(t, err, "partition by date ts can't be used when the document is an object, only with an "+
+		"array or a stream of json values")
+	require.NoFileExists(t, filepath.Join(tempDir, "object", "users_00.jsonl"))
+
+	tests := map[string]Options{
+		"date partitions can't be used with shards or partitions": {PartitionByDate: "ts", PartitionBy: "id"},
+		`invalid date format "2006/01/02", it must format a date without path separators`: {
+			PartitionByDate: "ts", DateFormat: "2006/01/02"},
+		`invalid date format "daily", it must format a date without path separators`: {
+			PartitionByDate: "ts", DateFormat: "daily"},
+	}
+
+	for expected, opts := range tests {
+		bs = NewTestByteStream([]byte(doc), 16)
+		require.EqualError(t, SplitStream(context.Background(), bs, filepath.Join(tempDir, "invalid"), opts), expected)
+	}
+}
@@ -0,0 +1,348 @@
+package jsplit
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var (
+	// gzipMagic are the first bytes of a deflate compressed gzip stream
+	gzipMagic = []byte{0x1f, 0x8b, 0x08}
+	// zstdMagic are the first bytes of a zstd frame
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// bzip2Magic are the first bytes of a bzip2 stream. They are followed by the block size, '1' through '9'
+	bzip2Magic = []byte("BZh")
+	// lz4Magic are the first bytes of an lz4 frame, as written by the lz4 command line tool
+	lz4Magic = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+// Compression identifies the compression format of an input stream
+type Compression string
+
+const (
+	// CompressionAuto detects the compression format from the first bytes of the input, see WithCompression
+	CompressionAuto  Compression = "auto"
+	CompressionNone  Compression = "none"
+	CompressionGzip  Compression = "gzip"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+	CompressionLZ4   Compression = "lz4"
+)
+
+// maxMagicLen is the number of bytes which need to be peeked to identify any supported format
+const maxMagicLen = 4
+
+// decompress peeks at the first bytes of rd and, if they identify a supported compression format, wraps rd in a
+// decompressing reader.  The detected format is returned along with any closers, which must be closed once reading has
+// finished. The decompressed text is transcoded to UTF-8 if it starts with a UTF-16 byte order mark, see decodeText.
+func decompress(rd io.Reader) (io.Reader, Compression, []io.Closer, error) {
+	return decompressAs(rd, CompressionAuto)
+}
+
+// decompressAs decompresses rd in the same way as decompress, but as the compression format given, without peeking at
+// its first bytes, unless it is CompressionAuto or "", when the format is detected from them
+func decompressAs(rd io.Reader, compression Compression) (io.Reader, Compression, []io.Closer, error) {
+	var (
+		drd     io.Reader
+		closers []io.Closer
+		err     error
+	)
+
+	if compression == CompressionAuto || compression == "" {
+		drd, compression, closers, err = detectCompression(rd)
+	} else {
+		drd, closers, err = newDecompressor(rd, compression)
+	}
+
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	trd, err := decodeText(drd)
+	if err != nil {
+		closeAll(closers)
+		return nil, "", nil, err
+	}
+
+	return trd, compression, closers, nil
+}
+
+// detectCompression wraps rd in a decompressing reader if its first bytes identify a supported compression format
+func detectCompression(rd io.Reader) (io.Reader, Compression, []io.Closer, error) {
+	magic := make([]byte, maxMagicLen)
+
+	n, err := peek(rd, magic)
+	if err != nil && n == 0 {
+		return nil, "", nil, err
+	}
+
+	// put the peeked bytes back in front of the stream
+	magic = magic[:n]
+	rd = unread(magic, rd, err)
+
+	compression := magicCompression(magic)
+
+	drd, closers, err := newDecompressor(rd, compression)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return drd, compression, closers, nil
+}
+
+// newDecompressor wraps rd in a reader decompressing the compression format, returning rd itself for CompressionNone
+func newDecompressor(rd io.Reader, compression Compression) (io.Reader, []io.Closer, error) {
+	switch compression {
+	case CompressionGzip:
+		gr, err := newGzipReader(rd)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return gr, []io.Closer{gr}, nil
+
+	case CompressionZstd:
+		zr, err := zstd.NewReader(rd)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// closing the decoder stops its background goroutines
+		return zr, []io.Closer{closerFunc(func() error {
+			zr.Close()
+			return nil
+		})}, nil
+
+	case CompressionLZ4:
+		// the lz4 reader doesn't hold anything which needs closing
+		return lz4.NewReader(rd), nil, nil
+
+	case CompressionBzip2:
+		// compress/bzip2 only decompresses, and its reader has nothing to close
+		return bzip2.NewReader(rd), nil, nil
+
+	case CompressionNone:
+		return rd, nil, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported compression %s", compression)
+}
+
+// validCompression reports whether compression is a format decompressAs accepts
+func validCompression(compression Compression) bool {
+	switch compression {
+	case CompressionAuto, CompressionNone, CompressionGzip, CompressionZstd, CompressionBzip2, CompressionLZ4:
+		return true
+	}
+
+	return false
+}
+
+// gzipReaders holds the gzip.Readers of compressed inputs which have been read, so that splitting many small gzip
+// files resets a reader onto each file rather than allocating the buffers and decompressor of a new one
+var gzipReaders sync.Pool
+
+// pooledGzipReader decompresses a gzip stream with a gzip.Reader from gzipReaders, which it returns to the pool once
+// closed
+type pooledGzipReader struct {
+	gr *gzip.Reader // nil once closed
+}
+
+// newGzipReader returns a *pooledGzipReader decompressing rd, reading its header straight away so that a stream with
+// an invalid header fails here. Every member of a stream of concatenated gzip members, as some tools write, is read,
+// as gzip.NewReader and Reset both leave multistream mode on, and nothing must turn it off.
+func newGzipReader(rd io.Reader) (*pooledGzipReader, error) {
+	gr, ok := gzipReaders.Get().(*gzip.Reader)
+	if !ok {
+		gr, err := gzip.NewReader(rd)
+		if err != nil {
+			return nil, err
+		}
+
+		return &pooledGzipReader{gr: gr}, nil
+	}
+
+	// a reader which fails to reset onto one stream can be reset onto the next
+	err := gr.Reset(rd)
+	if err != nil {
+		gzipReaders.Put(gr)
+		return nil, err
+	}
+
+	return &pooledGzipReader{gr: gr}, nil
+}
+
+// Read reads the decompressed stream, returning ErrReaderClosed once the reader has been closed, as the gzip.Reader
+// may by then be decompressing another stream
+func (pgr *pooledGzipReader) Read(p []byte) (int, error) {
+	if pgr.gr == nil {
+		return 0, ErrReaderClosed
+	}
+
+	return pgr.gr.Read(p)
+}
+
+// Close closes the gzip.Reader, which leaves the stream it decompresses open, and returns it to the pool. Closing the
+// reader again does nothing.
+func (pgr *pooledGzipReader) Close() error {
+	if pgr.gr == nil {
+		return nil
+	}
+
+	err := pgr.gr.Close()
+	gzipReaders.Put(pgr.gr)
+	pgr.gr = nil
+
+	return err
+}
+
+// magicCompression returns the compression format identified by the first bytes of a stream
+func magicCompression(magic []byte) Compression {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(magic, lz4Magic):
+		return CompressionLZ4
+	case bytes.HasPrefix(magic, bzip2Magic) && len(magic) > len(bzip2Magic) && isBzip2BlockSize(magic[len(bzip2Magic)]):
+		return CompressionBzip2
+	}
+
+	return CompressionNone
+}
+
+// peek reads len(buf) bytes from rd, or fewer if rd ends first, to identify its format. io.ReadFull can't be used as
+// it reports a source failing with io.ErrUnexpectedEOF, or any error along with the last bytes it needs, the same as a
+// short source. Errors other than io.EOF are returned along with the number of bytes read before them. A source with
+// no data yet, which returns neither data nor an error, is read again after a pause rather than in a loop which spins.
+func peek(rd io.Reader, buf []byte) (int, error) {
+	n, emptyReads := 0, 0
+
+	for n < len(buf) {
+		nn, err := rd.Read(buf[n:])
+		n += nn
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return n, err
+		}
+
+		if nn > 0 {
+			emptyReads = 0
+			continue
+		}
+
+		emptyReads++
+		time.Sleep(emptyReadBackoff.backoff(emptyReads + 1))
+	}
+
+	return n, nil
+}
+
+// unread returns a reader returning the peeked bytes followed by the rest of rd. If peeking failed with err once it
+// had read the bytes, they are followed by err instead, so that data a source returns along with an error is still
+// read ahead of the error.
+func unread(peeked []byte, rd io.Reader, err error) io.Reader {
+	if err != nil {
+		rd = failedReader{err}
+	}
+
+	return io.MultiReader(bytes.NewReader(peeked), rd)
+}
+
+// failedReader is the rest of a source which failed with err
+type failedReader struct {
+	err error
+}
+
+// Read returns the error the source failed with
+func (fr failedReader) Read([]byte) (int, error) {
+	return 0, fr.err
+}
+
+func isBzip2BlockSize(ch byte) bool {
+	return ch >= '1' && ch <= '9'
+}
+
+// contentEncodings are the compression formats of the Content-Encodings cloud storage objects can be stored with
+var contentEncodings = map[string]Compression{
+	"":         "",
+	"identity": "",
+	"gzip":     CompressionGzip,
+	"x-gzip":   CompressionGzip,
+	"zstd":     CompressionZstd,
+}
+
+// contentTypes are the compression formats of the Content-Types of compressed cloud storage objects
+var contentTypes = map[string]Compression{
+	"application/gzip":    CompressionGzip,
+	"application/x-gzip":  CompressionGzip,
+	"application/zstd":    CompressionZstd,
+	"application/x-bzip2": CompressionBzip2,
+	"application/x-lz4":   CompressionLZ4,
+}
+
+// compressionFromMetadata returns the compression format declared by the Content-Encoding, or failing that the
+// Content-Type, of a cloud storage object, or "" if neither declares one. Content-Encodings which can't be decompressed
+// are an error.
+func compressionFromMetadata(contentEncoding, contentType string) (Compression, error) {
+	encoding := strings.ToLower(strings.TrimSpace(contentEncoding))
+
+	compression, ok := contentEncodings[encoding]
+	if !ok {
+		return "", fmt.Errorf("unsupported Content-Encoding %s, only gzip and zstd can be decompressed", contentEncoding)
+	}
+
+	if compression != "" {
+		return compression, nil
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+
+	return contentTypes[strings.ToLower(strings.TrimSpace(mediaType))], nil
+}
+
+// decompressSource wraps rc in a decompressing reader if it is compressed, or as the compression format given unless it
+// is CompressionAuto, see decompressAs. Closing the returned reader closes the
+// decompressors and then rc.
+func decompressSource(rc io.ReadCloser, compression Compression) (io.ReadCloser, error) {
+	rd, _, closers, err := decompressAs(rc, compression)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+
+	return &sourceReader{Reader: rd, closers: append(closers, rc)}, nil
+}
+
+// sourceReader reads a decompressed source, closing everything that reading it required when closed
+type sourceReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes the decompressors and the source in order returning the first error
+func (sr *sourceReader) Close() error {
+	var firstErr error
+
+	for _, c := range sr.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,441 @@
+package jsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+)
+
+func TestDecompressDetectsFormat(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	tempDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+	}{
+		{
+			name:     "gzipped without .gz suffix",
+			filename: "export.json",
+			data:     gzipBytes(t, []byte(contents)),
+		},
+		{
+			name:     "plain with .gz suffix",
+			filename: "export.json.gz",
+			data:     []byte(contents),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(tempDir, test.filename)
+			require.NoError(t, os.WriteFile(filename, test.data, 0o644))
+
+			rd, err := AsyncReaderFromFile(filename, 8)
+			require.NoError(t, err)
+
+			ctx := rd.Start(context.Background())
+			require.Equal(t, contents, string(readAll(t, ctx, rd)))
+		})
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	tempDir := t.TempDir()
+	gzipped := gzipBytes(t, []byte(contents))
+
+	write := func(name string, data []byte) string {
+		filename := filepath.Join(tempDir, name)
+		require.NoError(t, os.WriteFile(filename, data, 0o644))
+
+		return filename
+	}
+
+	read := func(filename string, opts ...AsyncReaderOption) string {
+		rd, err := AsyncReaderFromFile(filename, 8, opts...)
+		require.NoError(t, err)
+
+		defer rd.Close()
+
+		return string(readAll(t, rd.Start(context.Background()), rd))
+	}
+
+	compressed := write("export.json.gz", gzipped)
+	misnamed := write("export.txt", gzipped)
+
+	// the format is detected by default, and with auto
+	require.Equal(t, contents, read(compressed))
+	require.Equal(t, contents, read(compressed, WithCompression(CompressionAuto)))
+
+	// none reads a compressed file as it is
+	require.Equal(t, string(gzipped), read(compressed, WithCompression(CompressionNone)))
+
+	// a format decompresses the file whatever it is named
+	require.Equal(t, contents, read(misnamed, WithCompression(CompressionGzip)))
+	require.Equal(t, contents, read(write("export.gz", zstdBytes(t, []byte(contents))),
+		WithCompression(CompressionZstd)))
+
+	rd, err := AsyncReaderFromReader(bytes.NewReader(gzipped), 8, WithCompression(CompressionNone))
+	require.NoError(t, err)
+	require.Equal(t, string(gzipped), string(readAll(t, rd.Start(context.Background()), rd)))
+
+	// input which isn't in the format given can't be read
+	_, err = AsyncReaderFromFile(write("plain.json", []byte(contents)), 8, WithCompression(CompressionGzip))
+	require.ErrorIs(t, err, gzip.ErrHeader)
+
+	_, err = AsyncReaderFromFile(compressed, 8, WithCompression("brotli"))
+	require.EqualError(t, err, "unsupported compression brotli, expected auto, none, gzip, zstd, bzip2 or lz4")
+
+	// a byte range can be read from a compressed file read as it is, but not from one which is decompressed
+	require.Equal(t, string(gzipped[2:6]), read(compressed, WithCompression(CompressionNone), WithByteRange(2, 4)))
+
+	_, err = AsyncReaderFromFile(misnamed, 8, WithCompression(CompressionGzip), WithByteRange(2, 4))
+	require.EqualError(t, err, "a byte range can't be read from "+misnamed+", it is decompressed as gzip")
+}
+
+func TestWithCompressionCloudMetadata(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	var buf bytes.Buffer
+
+	SetLogger(slog.New(NewMessageHandler(&buf, slog.LevelWarn)))
+	t.Cleanup(func() {
+		SetLogger(nil)
+	})
+
+	client := fakeCloudWithOptions(t, map[string][]byte{
+		"gs://bucket/export":      gzipBytes(t, []byte(contents)),
+		"gs://bucket/plain.json":  []byte(contents),
+		"gs://bucket/brotli.json": []byte(contents),
+	}, map[string]*blob.WriterOptions{
+		"gs://bucket/export":      {ContentEncoding: "gzip", ContentType: "application/json"},
+		"gs://bucket/plain.json":  {ContentEncoding: "gzip", ContentType: "application/json"},
+		"gs://bucket/brotli.json": {ContentEncoding: "br", ContentType: "application/json"},
+	})
+
+	// the metadata is ignored, without a warning, when the format is given
+	for uri, expected := range map[string]string{
+		"gs://bucket/export":      string(gzipBytes(t, []byte(contents))),
+		"gs://bucket/plain.json":  contents,
+		"gs://bucket/brotli.json": contents,
+	} {
+		rd, err := AsyncReaderFromFile(uri, 8, WithCloudClient(client), WithCompression(CompressionNone))
+		require.NoError(t, err, uri)
+
+		require.Equal(t, expected, string(readAll(t, rd.Start(context.Background()), rd)), uri)
+		require.NoError(t, rd.Close())
+	}
+
+	require.Empty(t, buf.String())
+}
+
+func TestAsyncReaderFromReaderDecompresses(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	for name, data := range map[string][]byte{
+		"plain":   []byte(contents),
+		"gzipped": gzipBytes(t, []byte(contents)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			rd, err := AsyncReaderFromReader(bytes.NewReader(data), 8)
+			require.NoError(t, err)
+
+			ctx := rd.Start(context.Background())
+			require.Equal(t, contents, string(readAll(t, ctx, rd)))
+		})
+	}
+}
+
+func TestDecompressShortInput(t *testing.T) {
+	for _, data := range []string{"", "{", "[]"} {
+		rd, err := AsyncReaderFromReader(bytes.NewReader([]byte(data)), 8)
+		require.NoError(t, err)
+
+		ctx := rd.Start(context.Background())
+		require.Equal(t, data, string(readAll(t, ctx, rd)))
+	}
+}
+
+func TestDecompressGzipPool(t *testing.T) {
+	// badHeader starts like a gzip stream, but ends part way through the name of the file it compressed
+	badHeader := []byte{0x1f, 0x8b, 0x08, 0x08, 0, 0, 0, 0, 0, 0, 'a'}
+
+	// the readers of the files which were read are reused for those which follow, even after one fails
+	for i, data := range [][]byte{
+		gzipBytes(t, []byte(`{"file": 0}`)),
+		badHeader,
+		gzipBytes(t, []byte(`{"file": 2}`)),
+		badHeader,
+		gzipBytes(t, []byte(`{"file": 4}`)),
+		gzipBytes(t, []byte(`{"file": 5}`)),
+	} {
+		rd, compression, closers, err := decompress(bytes.NewReader(data))
+		if i%2 == 1 && i < 4 {
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, CompressionGzip, compression)
+
+		contents, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf(`{"file": %d}`, i), string(contents))
+
+		closeAll(closers)
+
+		// a reader which has been returned to the pool can't be read any more
+		_, err = closers[0].(io.Reader).Read(make([]byte, 8))
+		require.ErrorIs(t, err, ErrReaderClosed)
+		require.NoError(t, closers[0].Close())
+	}
+}
+
+func TestDecompressGzipMultistream(t *testing.T) {
+	const contents = `{"list": [1, 2, 3], "name": "members"}`
+
+	// members of a file written by tools which compress each part of it on its own, split part way through a number
+	// and a string, with an empty member between them
+	var members []byte
+	for _, part := range []string{`{"list": [1, 2`, ``, `, 3], "na`, `me": "members"}`} {
+		members = append(members, gzipBytes(t, []byte(part))...)
+	}
+
+	// every member is read, by new readers and by those reset onto the stream from the pool
+	for i := 0; i < 3; i++ {
+		rd, compression, closers, err := decompress(bytes.NewReader(members))
+		require.NoError(t, err)
+		require.Equal(t, CompressionGzip, compression)
+
+		data, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		require.Equal(t, contents, string(data))
+
+		closeAll(closers)
+	}
+
+	// and the whole of a file of concatenated members is split
+	tempDir := t.TempDir()
+	filename := filepath.Join(tempDir, "members.json.gz")
+	require.NoError(t, os.WriteFile(filename, members, 0o644))
+
+	require.NoError(t, SplitFile(context.Background(), filename, filepath.Join(tempDir, "out"), Options{}))
+	requireContents(t, filepath.Join(tempDir, "out", "list_00.jsonl"), "1\n2\n3")
+	requireContents(t, filepath.Join(tempDir, "out", "root.json"), "{\n\t"+`"name":"members"`+"\n}")
+}
+
+func BenchmarkDecompressSmallGzipFiles(b *testing.B) {
+	const files = 1000
+
+	inputs := make([][]byte, files)
+
+	for i := range inputs {
+		var buf bytes.Buffer
+
+		gw := gzip.NewWriter(&buf)
+		_, _ = fmt.Fprintf(gw, `{"file": %d, "items": [1, 2, 3]}`, i)
+		_ = gw.Close()
+
+		inputs[i] = buf.Bytes()
+	}
+
+	buf := make([]byte, 4096)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, data := range inputs {
+			rd, _, closers, err := decompress(bytes.NewReader(data))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for err == nil {
+				_, err = rd.Read(buf)
+			}
+
+			if err != io.EOF {
+				b.Fatal(err)
+			}
+
+			closeAll(closers)
+		}
+	}
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	zw, err := zstd.NewWriter(buf)
+	require.NoError(t, err)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestDecompressZstd(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	filename := filepath.Join(t.TempDir(), "export.json.zst")
+	require.NoError(t, os.WriteFile(filename, zstdBytes(t, []byte(contents)), 0o644))
+
+	before := runtime.NumGoroutine()
+
+	rd, err := AsyncReaderFromFile(filename, 8)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, contents, string(readAll(t, ctx, rd)))
+
+	// the decoder's goroutines exit once it has been closed
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+// bzip2JSON is `[{"idx": 0, "name": "alex"}, {"idx": 1, "name": "brian"}]` compressed with bzip2 -9
+var bzip2JSON = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x31, 0x1e,
+	0x30, 0x3c, 0x00, 0x00, 0x0e, 0x1b, 0x80, 0x50, 0x04, 0x60, 0x10, 0x00,
+	0x0a, 0x36, 0x27, 0x10, 0x4a, 0x20, 0x00, 0x50, 0xa6, 0x13, 0x4d, 0x01,
+	0xa6, 0x20, 0x95, 0x4f, 0xd2, 0x35, 0x19, 0x01, 0xb1, 0x47, 0x2c, 0x21,
+	0x74, 0x41, 0x72, 0x44, 0x34, 0x63, 0x34, 0xe7, 0x21, 0xdd, 0x9b, 0x73,
+	0x4c, 0x89, 0x26, 0xae, 0x40, 0x22, 0x68, 0xd5, 0x78, 0xe7, 0xe2, 0xee,
+	0x48, 0xa7, 0x0a, 0x12, 0x06, 0x23, 0xc6, 0x07, 0x80,
+}
+
+func TestDecompressBzip2(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "export.json.bz2")
+	require.NoError(t, os.WriteFile(filename, bzip2JSON, 0o644))
+
+	rd, err := AsyncReaderFromFile(filename, 8)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, `[{"idx": 0, "name": "alex"}, {"idx": 1, "name": "brian"}]`, string(readAll(t, ctx, rd)))
+}
+
+// lz4JSON is `[{"idx": 0, "name": "alex"}, {"idx": 1, "name": "brian"}]` compressed as an lz4 frame
+var lz4JSON = []byte{
+	0x04, 0x22, 0x4d, 0x18, 0x64, 0x70, 0xb9, 0x34, 0x00, 0x00, 0x00, 0xf4,
+	0x0e, 0x5b, 0x7b, 0x22, 0x69, 0x64, 0x78, 0x22, 0x3a, 0x20, 0x30, 0x2c,
+	0x20, 0x22, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0x3a, 0x20, 0x22, 0x61, 0x6c,
+	0x65, 0x78, 0x22, 0x7d, 0x2c, 0x20, 0x1c, 0x00, 0x10, 0x31, 0x1c, 0x00,
+	0x00, 0x1c, 0x00, 0xb0, 0x3a, 0x20, 0x22, 0x62, 0x72, 0x69, 0x61, 0x6e,
+	0x22, 0x7d, 0x5d, 0x00, 0x00, 0x00, 0x00, 0x7c, 0x19, 0xda, 0x38,
+}
+
+func TestDecompressLZ4(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "export.json.lz4")
+	require.NoError(t, os.WriteFile(filename, lz4JSON, 0o644))
+
+	rd, err := AsyncReaderFromFile(filename, 8)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, `[{"idx": 0, "name": "alex"}, {"idx": 1, "name": "brian"}]`, string(readAll(t, ctx, rd)))
+
+	// the decompressed stream is split like any other
+	rd, err = AsyncReaderFromFile(filename, 8)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	sink := newMemSink()
+	require.NoError(t, Split(context.Background(), rd, sink, Options{}))
+	require.Equal(t, "{\"idx\":0,\"name\":\"alex\"}\n{\"idx\":1,\"name\":\"brian\"}", sink.contents("root_00.jsonl"))
+}
+
+func TestCompressionFromMetadata(t *testing.T) {
+	tests := []struct {
+		contentEncoding string
+		contentType     string
+		expected        Compression
+	}{
+		{"gzip", "application/json", CompressionGzip},
+		{" X-GZIP ", "", CompressionGzip},
+		{"zstd", "", CompressionZstd},
+		{"", "application/zstd", CompressionZstd},
+		{"identity", "application/x-bzip2", CompressionBzip2},
+		{"", "application/gzip; charset=binary", CompressionGzip},
+		{"", "application/json", ""},
+		{"", "", ""},
+	}
+
+	for _, test := range tests {
+		compression, err := compressionFromMetadata(test.contentEncoding, test.contentType)
+		require.NoError(t, err)
+		require.Equal(t, test.expected, compression, "%s %s", test.contentEncoding, test.contentType)
+	}
+
+	_, err := compressionFromMetadata("br", "application/json")
+	require.EqualError(t, err, "unsupported Content-Encoding br, only gzip and zstd can be decompressed")
+}
+
+func TestAsyncReaderCompressionFromMetadata(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	var buf bytes.Buffer
+
+	SetLogger(slog.New(NewMessageHandler(&buf, slog.LevelWarn)))
+	t.Cleanup(func() {
+		SetLogger(nil)
+	})
+
+	client := fakeCloudWithOptions(t, map[string][]byte{
+		"gs://bucket/export":      gzipBytes(t, []byte(contents)),
+		"gs://bucket/export.zst":  zstdBytes(t, []byte(contents)),
+		"gs://bucket/plain.json":  []byte(contents),
+		"gs://bucket/brotli.json": []byte(contents),
+	}, map[string]*blob.WriterOptions{
+		"gs://bucket/export":      {ContentEncoding: "gzip", ContentType: "application/json"},
+		"gs://bucket/export.zst":  {ContentEncoding: "zstd", ContentType: "application/json"},
+		"gs://bucket/plain.json":  {ContentEncoding: "gzip", ContentType: "application/json"},
+		"gs://bucket/brotli.json": {ContentEncoding: "br", ContentType: "application/json"},
+	})
+
+	// objects declared compressed are decompressed whatever their names
+	for _, uri := range []string{"gs://bucket/export", "gs://bucket/export.zst"} {
+		rd, err := AsyncReaderFromFile(uri, 8, WithCloudClient(client))
+		require.NoError(t, err)
+
+		require.Equal(t, contents, string(readAll(t, rd.Start(context.Background()), rd)), uri)
+		require.Equal(t, int64(-1), rd.TotalSize())
+		require.NoError(t, rd.Close())
+	}
+
+	require.Empty(t, buf.String())
+
+	// the contents are read as they are when they don't match the metadata
+	rd, err := AsyncReaderFromFile("gs://bucket/plain.json", 8, WithCloudClient(client))
+	require.NoError(t, err)
+
+	require.Equal(t, contents, string(readAll(t, rd.Start(context.Background()), rd)))
+	require.NoError(t, rd.Close())
+	require.Equal(t, "Warning: gs://bucket/plain.json is declared gzip compressed by its metadata but its contents are "+
+		"not compressed, ignoring the metadata\n", buf.String())
+
+	_, err = AsyncReaderFromFile("gs://bucket/brotli.json", 8, WithCloudClient(client))
+	require.EqualError(t, err, "unable to read gs://bucket/brotli.json: unsupported Content-Encoding br, only gzip "+
+		"and zstd can be decompressed")
+}
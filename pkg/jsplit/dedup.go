@@ -0,0 +1,93 @@
+package jsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// dedupItems returns a ListAddFunc passing the items of a list to add, dropping objects whose opts.DedupField holds a
+// value which an earlier item of the list held, and counting them in dropped. Items without the field, or where it is
+// null, are always kept. add is returned as is when opts.DedupField isn't set.
+func dedupItems(add ListAddFunc, opts Options, dropped *int64) ListAddFunc {
+	if opts.DedupField == "" {
+		return add
+	}
+
+	seen := make(map[string]struct{})
+
+	return func(item []byte) error {
+		id, ok := dedupID(item, opts.DedupField)
+		if ok {
+			if _, dup := seen[id]; dup {
+				*dropped++
+				return nil
+			}
+
+			seen[id] = struct{}{}
+		}
+
+		return add(item)
+	}
+}
+
+// dedupID returns the value of the field of the item which identifies it, or false if the item isn't an object with a
+// non-null value for the field. Strings are unquoted so that differently escaped strings with the same value match, and
+// other values are compacted.
+func dedupID(item []byte, field string) (string, bool) {
+	val, isString, ok := fieldValue(item, field)
+	if !ok {
+		return "", false
+	}
+
+	// strings are kept apart from other values with the same text, e.g. "1" and 1
+	if isString {
+		return string(QM) + val, true
+	}
+
+	return val, true
+}
+
+// fieldValue returns the value of the field of the item, unquoted if it is a string, which is reported, and compacted
+// otherwise. It returns false if the item isn't an object with a non-null value for the field.
+func fieldValue(item []byte, field string) (string, bool, bool) {
+	var fields map[string]json.RawMessage
+
+	err := json.Unmarshal(item, &fields)
+	if err != nil {
+		return "", false, false
+	}
+
+	val, ok := fields[field]
+	if !ok || string(val) == "null" {
+		return "", false, false
+	}
+
+	if val[0] == QM {
+		var s string
+
+		err = json.Unmarshal(val, &s)
+		if err != nil {
+			return "", false, false
+		}
+
+		return s, true, true
+	}
+
+	var buf bytes.Buffer
+
+	err = json.Compact(&buf, val)
+	if err != nil {
+		return "", false, false
+	}
+
+	return buf.String(), false, true
+}
+
+// reportDuplicates prints the number of items of the list with the given key which were dropped as duplicates
+func reportDuplicates(key string, duplicates int64) {
+	if duplicates > 0 {
+		logger().Info(fmt.Sprintf("%d duplicate items of %s were dropped", duplicates, key), "key", key,
+			"duplicates", duplicates)
+	}
+}
@@ -0,0 +1,69 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupID(t *testing.T) {
+	tests := map[string]struct {
+		item string
+		id   string
+		ok   bool
+	}{
+		"string":         {item: `{"id": "a", "v": 1}`, id: `"a`, ok: true},
+		"escaped string": {item: `{"id": "\u0061"}`, id: `"a`, ok: true},
+		"number":         {item: `{"v": 1, "id": 12}`, id: `12`, ok: true},
+		"object":         {item: `{"id": {"a": 1,  "b": [1, 2]}}`, id: `{"a":1,"b":[1,2]}`, ok: true},
+		"null":           {item: `{"id": null}`},
+		"missing":        {item: `{"other": 1}`},
+		"nested":         {item: `{"inner": {"id": 1}}`},
+		"not an object":  {item: `[1, 2]`},
+		"scalar":         {item: `"id"`},
+		"invalid":        {item: `{"id": 1`},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			id, ok := dedupID([]byte(test.item), "id")
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.id, id)
+		})
+	}
+}
+
+func TestSplitStreamDedupField(t *testing.T) {
+	const doc = `{"users": [{"id": 1, "v": "a"}, {"id": 2}, {"id": 1, "v": "b"}, {"v": "no id"}, {"v": "no id"}, ` +
+		`{"id": null}, {"id": null}, {"id": "1"}, {"id": 1.0}], "groups": [{"id": 1}, {"id": 1}], "n": 3}`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{DedupField: "id"}))
+
+	// records without an id are kept, and each list is deduplicated on its own
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1,"v":"a"}`+"\n"+`{"id":2}`+"\n"+
+		`{"v":"no id"}`+"\n"+`{"v":"no id"}`+"\n"+`{"id":null}`+"\n"+`{"id":null}`+"\n"+`{"id":"1"}`+"\n"+`{"id":1.0}`)
+	requireContents(t, filepath.Join(tempDir, "groups_00.jsonl"), `{"id":1}`)
+
+	manifest := readManifest(t, tempDir)
+	require.Len(t, manifest.Keys, 2)
+	require.Equal(t, int64(1), manifest.Keys[0].Duplicates)
+	require.Equal(t, int64(8), manifest.Keys[0].Records)
+	require.Equal(t, int64(1), manifest.Keys[1].Duplicates)
+
+	// duplicates don't count towards the limit
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(`{"list": [{"id": 1}, {"id": 1}, {"id": 2}, {"id": 3}]}`), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{DedupField: "id", Limit: 2}))
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), `{"id":1}`+"\n"+`{"id":2}`)
+
+	// the elements of a document which is a json array are deduplicated across every shard
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(`[{"id": 1}, {"id": 1}, {"id": 2}, {"id": 2}, {"id": 3}]`), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{DedupField: "id", Shards: 2}))
+	requireContents(t, filepath.Join(tempDir, "part-000_00.jsonl"), `{"id":1}`+"\n"+`{"id":3}`)
+	requireContents(t, filepath.Join(tempDir, "part-001_00.jsonl"), `{"id":2}`)
+}
@@ -0,0 +1,249 @@
+package jsplit
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"text/tabwriter"
+)
+
+const (
+	// DefaultExactDistinct is the number of distinct values of a field which are counted exactly by
+	// PeekOptions.ApproxDistinct before the count is estimated instead
+	DefaultExactDistinct = 10000
+
+	// hllPrecision is the number of bits of a hash which select a register of a HyperLogLog sketch. Its 2^14 registers
+	// take 16KB and estimate counts with a standard error of about 0.81%.
+	hllPrecision = 14
+)
+
+// DistinctCount is the number of distinct values of a field of the objects in the lists of a key, as counted by Peek
+type DistinctCount struct {
+	// Key is the key of the lists, DefaultRootListKey for a document which is a json array
+	Key string
+
+	// Field is the field whose values were counted
+	Field string
+
+	// Count is the number of distinct values of the field, or an estimate of it when Exact isn't set
+	Count uint64
+
+	// Exact is set when Count was counted exactly, and is false when it was estimated with a HyperLogLog sketch
+	Exact bool
+
+	// StdError is the relative standard error of an estimated Count, e.g. 0.008 for 0.8%, and 0 for an exact one. The
+	// count is within one standard error of the true count about 68% of the time, and within three almost always.
+	StdError float64
+
+	// Complete is false when Peek stopped before the end of a list of the key, in which case Count only covers the
+	// items seen before it stopped
+	Complete bool
+}
+
+// distinctKey identifies the field of the objects in the lists of a key whose values are counted
+type distinctKey struct {
+	key   string
+	field string
+}
+
+// distinctCounts counts the distinct values of each of a set of fields of the items of the lists of a document
+type distinctCounts struct {
+	fields   []string
+	approx   bool
+	counters map[distinctKey]*distinctCounter
+	order    []distinctKey // the order the counters were created in, which is the order of the keys in the document
+	partial  map[string]bool
+}
+
+func newDistinctCounts(fields []string, approx bool) *distinctCounts {
+	return &distinctCounts{
+		fields:   fields,
+		approx:   approx,
+		counters: make(map[distinctKey]*distinctCounter),
+		partial:  make(map[string]bool),
+	}
+}
+
+// add counts the values of each field in the item of a list of key. Items which aren't objects, and fields which are
+// missing or null, aren't counted. Values are compared as Options.DedupField compares them.
+func (dc *distinctCounts) add(key string, item []byte) {
+	for _, field := range dc.fields {
+		dk := distinctKey{key: key, field: field}
+
+		counter, ok := dc.counters[dk]
+		if !ok {
+			counter = newDistinctCounter(dc.approx)
+			dc.counters[dk] = counter
+			dc.order = append(dc.order, dk)
+		}
+
+		id, ok := dedupID(item, field)
+		if ok {
+			counter.add(id)
+		}
+	}
+}
+
+// results returns the counts of each field of each key, in the order the keys appear in the document
+func (dc *distinctCounts) results() []DistinctCount {
+	counts := make([]DistinctCount, 0, len(dc.order))
+
+	for _, dk := range dc.order {
+		counter := dc.counters[dk]
+		count, exact := counter.count()
+
+		result := DistinctCount{Key: dk.key, Field: dk.field, Count: count, Exact: exact, Complete: !dc.partial[dk.key]}
+		if !exact {
+			result.StdError = hllStdError(hllPrecision)
+		}
+
+		counts = append(counts, result)
+	}
+
+	return counts
+}
+
+// distinctCounter counts distinct values exactly, holding each in a set, or, when approximating, until the set holds
+// more than DefaultExactDistinct values, after which they are added to a HyperLogLog sketch of a fixed size instead
+type distinctCounter struct {
+	approx bool
+	exact  map[string]struct{} // nil once the values are added to sketch
+	sketch *hyperLogLog
+}
+
+func newDistinctCounter(approx bool) *distinctCounter {
+	return &distinctCounter{approx: approx, exact: make(map[string]struct{})}
+}
+
+// add counts the value
+func (dc *distinctCounter) add(value string) {
+	if dc.sketch != nil {
+		dc.sketch.add(value)
+		return
+	}
+
+	dc.exact[value] = struct{}{}
+
+	if dc.approx && len(dc.exact) > DefaultExactDistinct {
+		dc.sketch = newHyperLogLog(hllPrecision)
+
+		for v := range dc.exact {
+			dc.sketch.add(v)
+		}
+
+		dc.exact = nil
+	}
+}
+
+// count returns the number of distinct values added, and whether it is exact rather than estimated
+func (dc *distinctCounter) count() (uint64, bool) {
+	if dc.sketch != nil {
+		return dc.sketch.count(), false
+	}
+
+	return uint64(len(dc.exact)), true
+}
+
+// hyperLogLog estimates the number of distinct values added to it in a fixed amount of memory, one byte for each of its
+// 2^precision registers. Each value is hashed, the first precision bits of the hash select a register, and the register
+// keeps the largest number of leading zeros, plus one, seen in the rest of the hashes it was selected by. Many distinct
+// values are needed to see long runs of zeros, so the registers together estimate how many there were. See Flajolet et
+// al., "HyperLogLog: the analysis of a near-optimal cardinality estimation algorithm".
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	return &hyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// add adds the value to the sketch
+func (hll *hyperLogLog) add(value string) {
+	h := hashValue(value)
+
+	idx := h >> (64 - hll.precision)
+
+	// the bit set past the end of the rest of the hash bounds the run of zeros of a hash which is zero
+	rest := h<<hll.precision | 1<<(hll.precision-1)
+	rank := uint8(bits.LeadingZeros64(rest)) + 1
+
+	if rank > hll.registers[idx] {
+		hll.registers[idx] = rank
+	}
+}
+
+// count returns the estimated number of distinct values added to the sketch. Small counts, which leave registers
+// empty, are estimated by linear counting of the empty registers, which is more accurate over that range.
+func (hll *hyperLogLog) count() uint64 {
+	m := float64(len(hll.registers))
+
+	var (
+		sum   float64
+		zeros int
+	)
+
+	for _, r := range hll.registers {
+		sum += math.Ldexp(1, -int(r))
+
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(math.Round(estimate))
+}
+
+// hllStdError returns the relative standard error of the counts estimated by a sketch with the given precision
+func hllStdError(precision uint8) float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<precision))
+}
+
+// hashValue returns a 64 bit hash of the value, FNV-1a mixed by the finalizer of MurmurHash3 so that every bit depends
+// on the whole value, as the registers and ranks of a sketch are taken from different bits. The hash doesn't change
+// from run to run, so neither do the estimates of the same input.
+func hashValue(value string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(value))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+// PrintDistinct writes a table of the number of distinct values of each field of the lists of each key to w. Estimated
+// counts are marked with a ~ and followed by their standard error, and counts which stopped part way through a list are
+// marked with a +.
+func (ps *PeekSummary) PrintDistinct(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "key\tfield\tdistinct\terror")
+
+	for _, dc := range ps.Distinct {
+		count, stdErr := fmt.Sprint(dc.Count), "exact"
+		if !dc.Exact {
+			count, stdErr = "~"+count, fmt.Sprintf("±%.2f%%", dc.StdError*100)
+		}
+
+		if !dc.Complete {
+			count += "+"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", dc.Key, dc.Field, count, stdErr)
+	}
+
+	return tw.Flush()
+}
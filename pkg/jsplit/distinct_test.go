@@ -0,0 +1,119 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	stdErr := hllStdError(hllPrecision)
+	require.InDelta(t, 0.0081, stdErr, 0.0001)
+
+	// estimates are almost always within three standard errors of the true count, across small counts, estimated by
+	// linear counting, and large ones
+	for _, n := range []int{0, 1, 100, 5000, 50000, 250000, 1000000} {
+		hll := newHyperLogLog(hllPrecision)
+
+		for i := 0; i < n; i++ {
+			hll.add(fmt.Sprintf("user-%d", i))
+
+			// values seen again don't change the estimate
+			if i%4 == 0 {
+				hll.add(fmt.Sprintf("user-%d", i/2))
+			}
+		}
+
+		estimate := float64(hll.count())
+		require.InDelta(t, float64(n), estimate, math.Max(1, 3*stdErr*float64(n)), "%d distinct values", n)
+	}
+}
+
+func TestPeekDistinct(t *testing.T) {
+	const doc = `{"users": [{"id": 1, "team": "a"}, {"id": "1", "team": "a"}, {"id": 2, "team": "b"}, {"team": null},
+		{"id": 1}, "x", {"id": {"a": 1}}, {"id": {"a":1}}], "name": "export", "events": [], "users": [{"id": 3}]}`
+
+	for _, chunk := range []int{1, 7, 1024} {
+		summary, err := Peek(context.Background(), NewTestByteStream([]byte(doc), chunk),
+			PeekOptions{DistinctFields: []string{"id", "team"}})
+		require.NoError(t, err)
+
+		// strings are counted apart from other values with the same text, objects are compacted and the lists of a key
+		// which appears twice are counted together
+		require.Equal(t, []DistinctCount{
+			{Key: "users", Field: "id", Count: 5, Exact: true, Complete: true},
+			{Key: "users", Field: "team", Count: 2, Exact: true, Complete: true},
+		}, summary.Distinct)
+
+		// the elements of lists are still counted
+		require.Equal(t, []KeySummary{
+			{Key: "users", Type: "array", Items: 8, Complete: true},
+			{Key: "name", Type: "string", Complete: true},
+			{Key: "events", Type: "array", Complete: true},
+			{Key: "users", Type: "array", Items: 1, Complete: true},
+		}, summary.Keys)
+	}
+
+	// the elements of a document which is a json array are counted as the root list
+	summary, err := Peek(context.Background(), NewTestByteStream([]byte(`[{"id": 1}, {"id": 2}]`), 4),
+		PeekOptions{DistinctFields: []string{"id"}})
+	require.NoError(t, err)
+	require.Equal(t, []DistinctCount{{Key: DefaultRootListKey, Field: "id", Count: 2, Exact: true, Complete: true}},
+		summary.Distinct)
+
+	// counts which stopped part way through a list are marked as such
+	summary, err = Peek(context.Background(), NewTestByteStream([]byte(doc), 16),
+		PeekOptions{DistinctFields: []string{"id"}, MaxBytes: 40})
+	require.NoError(t, err)
+	require.True(t, summary.Truncated)
+	require.Equal(t, []DistinctCount{{Key: "users", Field: "id", Count: 2, Exact: true}}, summary.Distinct)
+}
+
+func TestPeekApproxDistinct(t *testing.T) {
+	const users = 100000
+
+	var sb strings.Builder
+
+	sb.WriteString(`{"users": [`)
+
+	for i := 0; i < users; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		fmt.Fprintf(&sb, `{"id": "%08x", "team": %d, "score": %d}`, i*7919, i%20, i%(2*DefaultExactDistinct))
+	}
+
+	sb.WriteString(`]}`)
+
+	summary, err := Peek(context.Background(), NewTestByteStream([]byte(sb.String()), 64*1024),
+		PeekOptions{DistinctFields: []string{"id", "team", "score"}, ApproxDistinct: true})
+	require.NoError(t, err)
+	require.Len(t, summary.Distinct, 3)
+
+	// fields with many values are estimated within the error bound, and those with few are still counted exactly
+	id := summary.Distinct[0]
+	require.False(t, id.Exact)
+	require.Equal(t, hllStdError(hllPrecision), id.StdError)
+	require.InDelta(t, users, float64(id.Count), 3*id.StdError*users)
+
+	require.Equal(t, DistinctCount{Key: "users", Field: "team", Count: 20, Exact: true, Complete: true},
+		summary.Distinct[1])
+
+	score := summary.Distinct[2]
+	require.False(t, score.Exact)
+	require.InDelta(t, 2*DefaultExactDistinct, float64(score.Count), 3*score.StdError*2*DefaultExactDistinct)
+
+	var buf bytes.Buffer
+
+	require.NoError(t, summary.PrintDistinct(&buf))
+	require.Equal(t, fmt.Sprintf("key    field  distinct  error\n"+
+		"users  id     %-8s  ±0.81%%\n"+
+		"users  team   20        exact\n"+
+		"users  score  %-8s  ±0.81%%\n", fmt.Sprint("~", id.Count), fmt.Sprint("~", score.Count)), buf.String())
+}
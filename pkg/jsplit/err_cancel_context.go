@@ -15,8 +15,24 @@ type ErrCancelContext struct {
 	context.Context
 	err error
 	mu  *sync.Mutex
+
+	// the problems reported to the context, when it collects them
+	maxProblems int
+	problems    []Problem
+	reported    int
+}
+
+// Problem is a non-fatal error found during a split, such as a list item skipped because of Options.SkipErrors, along
+// with the offset in the input it was found at
+type Problem struct {
+	Offset int64
+	Err    error
 }
 
+// problemsKey is the key the ErrCancelContext returns itself for from Value, so that the problems reported to contexts
+// derived from it can be collected
+type problemsKey struct{}
+
 // Err returns the error that the context was cancelled with
 func (ec *ErrCancelContext) Err() error {
 	ec.mu.Lock()
@@ -29,6 +45,60 @@ func (ec *ErrCancelContext) Err() error {
 	return ec.Context.Err()
 }
 
+// Value returns the context itself for problemsKey, and otherwise the value of its parent
+func (ec *ErrCancelContext) Value(key interface{}) interface{} {
+	if key == (problemsKey{}) {
+		return ec
+	}
+
+	return ec.Context.Value(key)
+}
+
+// Report records a problem found at the given offset in the input. Only the first problems up to the maximum the
+// context was created with are kept, though all of them are counted. Problems aren't recorded by contexts created
+// with NewErrContextWithCancel.
+func (ec *ErrCancelContext) Report(offset int64, err error) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if ec.maxProblems == 0 {
+		return
+	}
+
+	ec.reported++
+
+	if len(ec.problems) < ec.maxProblems {
+		ec.problems = append(ec.problems, Problem{Offset: offset, Err: err})
+	}
+}
+
+// Problems returns the problems recorded in the order they were reported, along with the number which were reported,
+// which is more than were recorded once the maximum is reached. Err returns the fatal error the context was cancelled
+// with, if any.
+func (ec *ErrCancelContext) Problems() ([]Problem, int) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	return append([]Problem(nil), ec.problems...), ec.reported
+}
+
+// reportProblem reports a problem to the closest ErrCancelContext ctx is derived from which collects problems, if any
+func reportProblem(ctx context.Context, offset int64, err error) {
+	for ctx != nil {
+		ec, ok := ctx.Value(problemsKey{}).(*ErrCancelContext)
+		if !ok {
+			return
+		}
+
+		if ec.maxProblems > 0 {
+			ec.Report(offset, err)
+			return
+		}
+
+		ctx = ec.Context
+	}
+}
+
 // NewErrContextWithCancel returns a new context, and a function which can be used to cancel the context
 func NewErrContextWithCancel(parent context.Context) (context.Context, CancelWithErrFunc) {
 	newCtx, cancelFunc := context.WithCancel(parent)
@@ -47,3 +117,15 @@ func NewErrContextWithCancel(parent context.Context) (context.Context, CancelWit
 		cancelFunc()
 	}
 }
+
+// NewErrContextWithProblems returns a new context as NewErrContextWithCancel does, which also records up to
+// maxProblems of the problems found by splits using it, such as list items skipped because of Options.SkipErrors or
+// keys found more than once. Nothing is recorded when maxProblems isn't positive.
+func NewErrContextWithProblems(parent context.Context, maxProblems int) (*ErrCancelContext, CancelWithErrFunc) {
+	ctx, cancel := NewErrContextWithCancel(parent)
+
+	errCtx := ctx.(*ErrCancelContext)
+	errCtx.maxProblems = maxProblems
+
+	return errCtx, cancel
+}
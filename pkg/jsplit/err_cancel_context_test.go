@@ -0,0 +1,84 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrContextProblems(t *testing.T) {
+	ctx, cancel := NewErrContextWithProblems(context.Background(), 3)
+
+	// problems are reported through the contexts derived from it, including other ErrCancelContexts
+	derived, cancelDerived := NewErrContextWithCancel(ctx)
+	defer cancelDerived(nil)
+
+	inner, stop := context.WithCancel(derived)
+	defer stop()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			reportProblem(inner, int64(i), fmt.Errorf("problem %d", i))
+		}(i)
+	}
+
+	wg.Wait()
+
+	// only the first problems are kept, though all are counted
+	problems, reported := ctx.Problems()
+	require.Equal(t, 10, reported)
+	require.Len(t, problems, 3)
+
+	for _, p := range problems {
+		require.Equal(t, fmt.Sprintf("problem %d", p.Offset), p.Err.Error())
+	}
+
+	// the fatal error is tracked apart from the problems
+	require.NoError(t, ctx.Err())
+
+	fatal := errors.New("fatal")
+	cancel(fatal)
+	require.Equal(t, fatal, ctx.Err())
+
+	_, reported = ctx.Problems()
+	require.Equal(t, 10, reported)
+
+	// contexts which don't collect problems ignore them
+	plain, cancelPlain := NewErrContextWithCancel(context.Background())
+	defer cancelPlain(nil)
+
+	reportProblem(plain, 0, errors.New("ignored"))
+	reportProblem(context.Background(), 0, errors.New("ignored"))
+
+	problems, reported = plain.(*ErrCancelContext).Problems()
+	require.Empty(t, problems)
+	require.Zero(t, reported)
+}
+
+func TestSplitStreamProblems(t *testing.T) {
+	const doc = `{"list": [{"idx": 0}, {"idx": 1,}, {"idx": 2}], "other": [1], "list": [3]}`
+
+	ctx, _ := NewErrContextWithProblems(context.Background(), 10)
+
+	err := SplitStream(ctx, NewTestByteStream([]byte(doc), 8), t.TempDir(), Options{SkipErrors: true})
+	require.NoError(t, err)
+
+	// the skipped item and the duplicate key are both reported, along with where they were found
+	problems, reported := ctx.Problems()
+	require.Equal(t, 2, reported)
+	require.Contains(t, problems[0].Err.Error(), "item 1 of list was skipped: ")
+	require.Contains(t, problems[0].Err.Error(), "invalid list item")
+	require.Equal(t, "list appears more than once in the document, the items of its lists are written to the same files",
+		problems[1].Err.Error())
+	require.Greater(t, problems[0].Offset, int64(len(`{"list": [{"idx": 0}`)))
+	require.Greater(t, problems[1].Offset, problems[0].Offset)
+}
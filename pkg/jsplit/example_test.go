@@ -0,0 +1,189 @@
+package jsplit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/danielchalef/jsplit/pkg/jsplit"
+)
+
+// memorySink keeps the files written by a split in memory
+type memorySink struct {
+	mu    sync.Mutex
+	files map[string]*bytes.Buffer
+}
+
+func (ms *memorySink) OpenKey(name string) (io.WriteCloser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	ms.files[name] = buf
+
+	return nopWriteCloser{buf}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// ExampleSplit splits a document read from memory, keeping the files it is split into in memory rather than writing
+// them to a directory
+func ExampleSplit() {
+	doc := `{"name": "example", "users": [{"id": 1}, {"id": 2}], "groups": [{"id": "admin"}]}`
+
+	rd, err := jsplit.AsyncReaderFromReader(strings.NewReader(doc), 1024*1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rd.Close()
+
+	sink := &memorySink{files: make(map[string]*bytes.Buffer)}
+
+	err = jsplit.Split(context.Background(), rd, sink, jsplit.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	names := make([]string, 0, len(sink.files))
+	for name := range sink.files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%s:\n%s\n", name, sink.files[name])
+	}
+}
+
+// ExampleRecords routes the items of a document's lists as they are parsed, instead of writing them to files
+func ExampleRecords() {
+	doc := `{"name": "example", "users": [{"id": 1}, {"id": 2}], "groups": [{"id": "admin"}]}`
+
+	rd, err := jsplit.AsyncReaderFromReader(strings.NewReader(doc), 1024*1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rd.Close()
+
+	ctx := rd.Start(context.Background())
+	records, errs := jsplit.Records(ctx, rd, jsplit.Options{})
+
+	for r := range records {
+		fmt.Printf("%s: %s\n", r.Key, r.Value)
+	}
+
+	if err = <-errs; err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// users: {"id":1}
+	// users: {"id":2}
+	// groups: {"id":"admin"}
+}
+
+// ExampleSinkFunc captures the lists of a document in buffers, with a function creating the writer of each file
+func ExampleSinkFunc() {
+	doc := `{"name": "example", "users": [{"id": 1}, {"id": 2}], "groups": [{"id": "admin"}]}`
+
+	rd, err := jsplit.AsyncReaderFromReader(strings.NewReader(doc), 1024*1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rd.Close()
+
+	// the files and keys finished are logged to standard output unless another logger is set
+	jsplit.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer jsplit.SetLogger(nil)
+
+	var mu sync.Mutex
+	files := make(map[string]*bytes.Buffer)
+
+	sink := jsplit.SinkFunc(func(name string) (io.WriteCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		buf := bytes.NewBuffer(nil)
+		files[name] = buf
+
+		return nopWriteCloser{buf}, nil
+	})
+
+	err = jsplit.Split(context.Background(), rd, sink, jsplit.Options{Concurrency: 2})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(files["users_00.jsonl"])
+	fmt.Println(files["groups_00.jsonl"])
+
+	// Output:
+	// {"id":1}
+	// {"id":2}
+	// {"id":"admin"}
+}
+
+// ExampleRecordTransformFunc upper cases the name of each user as the users are split, and drops those which were
+// deleted
+func ExampleRecordTransformFunc() {
+	doc := `{"users": [{"id": 1, "name": "ada"}, {"id": 2, "name": "bob", "deleted": true}, {"id": 3, "name": "cy"}]}`
+
+	rd, err := jsplit.AsyncReaderFromReader(strings.NewReader(doc), 1024*1024)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rd.Close()
+
+	jsplit.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer jsplit.SetLogger(nil)
+
+	transform := func(key string, raw json.RawMessage) (json.RawMessage, bool, error) {
+		var user struct {
+			ID      int    `json:"id"`
+			Name    string `json:"name"`
+			Deleted bool   `json:"deleted"`
+		}
+
+		err := json.Unmarshal(raw, &user)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if user.Deleted {
+			return nil, false, nil
+		}
+
+		user.Name = strings.ToUpper(user.Name)
+
+		out, err := json.Marshal(map[string]interface{}{"id": user.ID, "name": user.Name})
+
+		return out, true, err
+	}
+
+	sink := &memorySink{files: make(map[string]*bytes.Buffer)}
+
+	err = jsplit.Split(context.Background(), rd, sink, jsplit.Options{RecordTransform: transform})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(sink.files["users_00.jsonl"])
+
+	// Output:
+	// {"id":1,"name":"ADA"}
+	// {"id":3,"name":"CY"}
+}
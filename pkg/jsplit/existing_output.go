@@ -0,0 +1,343 @@
+package jsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+)
+
+// ExistingOutput is what a split does with the files a previous run left in its local output directory
+type ExistingOutput string
+
+const (
+	// ExistingOutputError fails the split with ErrOutputExists when a file it would write already exists, and SplitFile
+	// and SplitFiles refuse to write into an output directory which already exists unless Options.RequireOutputDir is
+	// set
+	ExistingOutputError ExistingOutput = "error"
+	// ExistingOutputOverwrite replaces the files which already exist, and SplitFile and SplitFiles remove an output
+	// directory which already exists first, unless Options.RequireOutputDir is set
+	ExistingOutputOverwrite ExistingOutput = "overwrite"
+	// ExistingOutputAppend carries on from the split recorded in the manifest of the output directory, appending the
+	// items of each list to its last file until it is full and then numbering its files on from there, adding the new
+	// values which aren't lists to root.json, and recording the totals of both splits in the manifest. Files which
+	// aren't in the manifest are refused as they are by ExistingOutputError. It can only be used with a local output
+	// directory, and not with shards, partitions, csv output, schema inference, skipping errors or checksums, nor with
+	// checkpoints or SplitFiles. Limit and DedupField only apply to the items of each split.
+	ExistingOutputAppend ExistingOutput = "append"
+)
+
+// ErrOutputExists is returned when a file a split would write already exists, and Options.ExistingOutput doesn't allow
+// it to be replaced
+var ErrOutputExists = errors.New("output file already exists")
+
+// existingOutput returns what the split does with files which already exist
+func (opts Options) existingOutput() ExistingOutput {
+	switch {
+	case opts.ExistingOutput != "":
+		return opts.ExistingOutput
+	case opts.Overwrite:
+		return ExistingOutputOverwrite
+	}
+
+	return ExistingOutputError
+}
+
+// sinkExistingOutput returns what the split's sink does with files which already exist. Files are replaced unless a
+// policy is set, and a resumed split replaces the files the interrupted split wrote after its last checkpoint.
+func (opts Options) sinkExistingOutput() ExistingOutput {
+	if opts.Resume || opts.ExistingOutput == "" {
+		return ExistingOutputOverwrite
+	}
+
+	return opts.ExistingOutput
+}
+
+// validateExistingOutput returns an error if the policy for existing output isn't known, or can't be used with the
+// other options
+func (opts Options) validateExistingOutput() error {
+	switch opts.ExistingOutput {
+	case "", ExistingOutputError, ExistingOutputOverwrite, ExistingOutputAppend:
+	default:
+		return fmt.Errorf("unsupported existing output policy %q, it must be %s, %s or %s", opts.ExistingOutput,
+			ExistingOutputError, ExistingOutputOverwrite, ExistingOutputAppend)
+	}
+
+	if opts.Overwrite && opts.existingOutput() != ExistingOutputOverwrite {
+		return fmt.Errorf("overwrite can't be used with the %s existing output policy", opts.ExistingOutput)
+	}
+
+	if opts.existingOutput() != ExistingOutputAppend {
+		return nil
+	}
+
+	var option string
+
+	switch {
+	case opts.Shards > 0:
+		option = "shards"
+	case opts.partitioned():
+		option = "partitions"
+	case opts.writesFormat(FormatCSV):
+		option = "csv output"
+	case opts.writesFormat(FormatParquet):
+		option = "parquet output"
+	case opts.InferSchema || opts.BigQuerySchema:
+		option = "schema inference"
+	case opts.SkipErrors:
+		option = "skipping errors"
+	case opts.Checksums:
+		option = "checksums"
+	case opts.ValueFiles:
+		option = "value files"
+	case opts.AtomicFiles:
+		option = "atomic files"
+	default:
+		return nil
+	}
+
+	return fmt.Errorf("existing output can't be appended to with %s", option)
+}
+
+// fileSet is the set of files a split has created, which it can replace whatever the policy for existing output, as
+// when the schema of a key appearing more than once is rewritten
+type fileSet struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func newFileSet() *fileSet {
+	return &fileSet{names: make(map[string]bool)}
+}
+
+// add adds the file with the given name to the set
+func (set *fileSet) add(name string) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.names[name] = true
+}
+
+// has reports whether the file with the given name is in the set
+func (set *fileSet) has(name string) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	return set.names[name]
+}
+
+// localDir returns the local directory the sink writes files to, if it writes to one
+func localDir(sink OutputSink) (string, bool) {
+	switch s := sink.(type) {
+	case *dirSink:
+		return s.dir, !cloud.IsCloudURI(s.dir)
+	case dryRunSink:
+		return localDir(s.OutputSink)
+	}
+
+	return "", false
+}
+
+// readPreviousManifest returns the manifest of the split being appended to in the sink's local directory, or nil if
+// it has none, in which case the split starts afresh
+func readPreviousManifest(sink OutputSink) (*Manifest, error) {
+	dir, ok := localDir(sink)
+	if !ok {
+		return nil, fmt.Errorf("existing output can only be appended to in a local output directory, not %s",
+			sinkLocation(sink))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to append to %s, its manifest can't be read: %w", dir, err)
+	}
+
+	return &m, nil
+}
+
+// previousKey returns the files written for the key by the split being appended to, or nil if it wrote none
+func (opts Options) previousKey(name string) *ManifestKey {
+	if opts.previous == nil {
+		return nil
+	}
+
+	return opts.previous.key(name)
+}
+
+// appendTo carries on writing the files of the key written by the split being appended to, appending to its last file
+// unless it is full, see Options.fileFull, in which case the next file is numbered after it
+func (sk *splitKey) appendTo(previous *ManifestKey) error {
+	if previous == nil || len(previous.Files) == 0 {
+		return nil
+	}
+
+	last := previous.Files[len(previous.Files)-1]
+	open := !sk.opts.fileFull(uint64(last.Bytes), last.Records)
+
+	wr, err := sk.factory.resume(previous.Files, open)
+	if err != nil {
+		return err
+	}
+
+	records := make([]int64, len(previous.Files))
+	for i, file := range previous.Files {
+		records[i] = file.Records
+	}
+
+	var writtenBytes uint64
+	if open {
+		writtenBytes = uint64(last.Bytes)
+	}
+
+	sk.wr.resume(wr, records, writtenBytes)
+	sk.isList = true
+	sk.duplicates = previous.Duplicates
+	sk.filtered.dropped = previous.Filtered
+
+	return nil
+}
+
+// addPrevious adds the keys of the split being appended to which weren't written to again to the manifest, in the
+// order they were first written, ahead of the keys which are new
+func (m *Manifest) addPrevious(previous *Manifest) {
+	if previous == nil {
+		return
+	}
+
+	keys := make([]ManifestKey, 0, len(previous.Keys)+len(m.Keys))
+	written := make(map[string]bool, len(m.Keys))
+
+	for _, mk := range m.Keys {
+		written[mk.Key] = true
+	}
+
+	for _, mk := range previous.Keys {
+		if written[mk.Key] {
+			mk = *m.key(mk.Key)
+		}
+
+		keys = append(keys, mk)
+	}
+
+	for _, mk := range m.Keys {
+		if previous.key(mk.Key) == nil {
+			keys = append(keys, mk)
+		}
+	}
+
+	m.Keys = keys
+}
+
+// key returns the entry of the manifest for the key, or nil if it has none
+func (m *Manifest) key(name string) *ManifestKey {
+	for i := range m.Keys {
+		if m.Keys[i].Key == name {
+			return &m.Keys[i]
+		}
+	}
+
+	return nil
+}
+
+// appendRoot returns the values of root.json written by the split being appended to followed by rootItems, the values
+// of this split formatted as they are written. The previous file is reopened, its closing brace stripped, and the new
+// values carry on from its last value, so that its formatting is kept as it is. The file is decompressed and
+// compressed again along with the new values when output is compressed.
+func appendRoot(sink OutputSink, rootItems []byte, opts Options) ([]byte, error) {
+	if opts.previous == nil || opts.previous.Root == nil {
+		return rootItems, nil
+	}
+
+	dir, _ := localDir(sink)
+
+	prev, err := os.ReadFile(filepath.Join(dir, opts.previous.Root.Name))
+	if err != nil {
+		return nil, fmt.Errorf("unable to append to %s: %w", opts.previous.Root.Name, err)
+	}
+
+	if opts.CompressOutput {
+		gr, err := gzip.NewReader(bytes.NewReader(prev))
+		if err != nil {
+			return nil, fmt.Errorf("unable to append to %s: %w", opts.previous.Root.Name, err)
+		}
+
+		prev, err = io.ReadAll(gr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to append to %s: %w", opts.previous.Root.Name, err)
+		}
+	}
+
+	prevBody, ok := objectBody(prev)
+	if !ok {
+		return nil, fmt.Errorf("unable to append to %s, it isn't a json object", opts.previous.Root.Name)
+	}
+
+	body, _ := objectBody(rootItems)
+
+	switch {
+	case strings.TrimSpace(string(body)) == "":
+		body = prevBody
+	case strings.TrimSpace(string(prevBody)) != "":
+		body = append(append(bytes.TrimRight(prevBody, " \t\r\n"), COMMA), body...)
+	}
+
+	merged := make([]byte, 0, len(body)+4)
+	merged = append(merged, OpenCB)
+	merged = append(merged, bytes.TrimRight(body, " \t\r\n")...)
+	merged = append(merged, '\n', CloseCB)
+
+	return merged, nil
+}
+
+// objectBody returns what comes between the braces of the json object data
+func objectBody(data []byte) ([]byte, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) < 2 || data[0] != OpenCB || data[len(data)-1] != CloseCB {
+		return nil, false
+	}
+
+	return append([]byte(nil), data[1:len(data)-1]...), true
+}
+
+// replaceFile writes data to the file with the given name in the sink, replacing the file left by the split being
+// appended to
+func replaceFile(sink OutputSink, name string, data []byte, opts Options) (string, error) {
+	as, ok := sink.(interface {
+		appendKey(name string, size int64) (io.WriteCloser, error)
+	})
+	if opts.previous == nil || !ok {
+		return writeFile(sink, name, data)
+	}
+
+	w, err := as.appendKey(name, 0)
+	if os.IsNotExist(err) {
+		return writeFile(sink, name, data)
+	} else if err != nil {
+		return "", err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		_ = w.Close()
+		return "", err
+	}
+
+	return sinkPath(sink, name), w.Close()
+}
@@ -0,0 +1,151 @@
+package jsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStreamExistingOutput(t *testing.T) {
+	const doc = `{"a": 1, "list": [{"idx": 0}, {"idx": 1}]}`
+
+	split := func(dir string, opts Options) error {
+		return SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), dir, opts)
+	}
+
+	// files left by a previous split are refused
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "list_00.jsonl"), []byte(`{"idx":9}`), 0o644))
+
+	err := split(tempDir, Options{ExistingOutput: ExistingOutputError})
+	require.ErrorIs(t, err, ErrOutputExists)
+	require.ErrorContains(t, err, "list_00.jsonl")
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), `{"idx":9}`)
+
+	// or replaced
+	require.NoError(t, split(tempDir, Options{ExistingOutput: ExistingOutputOverwrite}))
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}")
+
+	// files are replaced when no policy is set, as before
+	require.NoError(t, split(tempDir, Options{}))
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}")
+
+	// files which aren't in the manifest are refused when appending
+	tempDir = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "list_00.jsonl"), []byte(`{"idx":9}`), 0o644))
+	require.ErrorIs(t, split(tempDir, Options{ExistingOutput: ExistingOutputAppend}), ErrOutputExists)
+
+	for name, test := range map[string]struct {
+		opts Options
+		err  string
+	}{
+		"unknown":   {Options{ExistingOutput: "merge"}, `unsupported existing output policy "merge", it must be error, overwrite or append`},
+		"overwrite": {Options{ExistingOutput: ExistingOutputAppend, Overwrite: true}, "overwrite can't be used with the append existing output policy"},
+		"shards":    {Options{ExistingOutput: ExistingOutputAppend, Shards: 2}, "existing output can't be appended to with shards"},
+		"csv":       {Options{ExistingOutput: ExistingOutputAppend, Format: FormatCSV}, "existing output can't be appended to with csv output"},
+		"checksums": {Options{ExistingOutput: ExistingOutputAppend, Checksums: true}, "existing output can't be appended to with checksums"},
+		"resume":    {Options{ExistingOutput: ExistingOutputAppend, CheckpointInterval: 10}, "checkpoints can't be used with appending to existing output"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.EqualError(t, split(t.TempDir(), test.opts), test.err)
+		})
+	}
+
+	// only local output directories can be appended to
+	rd, err := AsyncReaderFromReader(strings.NewReader(doc), 8)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	err = Split(context.Background(), rd, newMemSink(), Options{ExistingOutput: ExistingOutputAppend})
+	require.ErrorContains(t, err, "existing output can only be appended to in a local output directory")
+}
+
+func TestSplitStreamAppend(t *testing.T) {
+	tempDir := t.TempDir()
+
+	split := func(doc string, opts Options) {
+		opts.ExistingOutput = ExistingOutputAppend
+		bs := NewTestByteStream([]byte(doc), 8)
+		require.NoError(t, SplitStream(context.Background(), bs, tempDir, opts))
+	}
+
+	// an output directory without a manifest is split into afresh
+	split(`{"a": 1, "list": [{"idx": 0}, {"idx": 1}], "other": [{"x": 1}]}`, Options{MaxFileBytes: 30})
+
+	// the last file of a list is appended to until it is full, the files which follow are numbered after it, and the
+	// values which aren't lists are added to the root
+	split(`{"b": "two", "list": [{"idx": 2}, {"idx": 3}, {"idx": 4}], "new": [{"y": 1}]}`, Options{MaxFileBytes: 30})
+
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}\n{\"idx\":2}\n{\"idx\":3}")
+	requireContents(t, filepath.Join(tempDir, "list_01.jsonl"), `{"idx":4}`)
+	requireContents(t, filepath.Join(tempDir, "other_00.jsonl"), `{"x":1}`)
+	requireContents(t, filepath.Join(tempDir, "new_00.jsonl"), `{"y":1}`)
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"a\":1,\n\t\"b\":\"two\"\n}")
+
+	// the manifest records both splits, with the keys of the first split first
+	manifest := readManifest(t, tempDir)
+	require.Equal(t, int64(2), manifest.Root.Records)
+	require.Equal(t, fileSize(t, filepath.Join(tempDir, "root.json")), manifest.Root.Bytes)
+
+	var keys []string
+	for _, mk := range manifest.Keys {
+		keys = append(keys, mk.Key)
+	}
+
+	require.Equal(t, []string{"list", "other", "new"}, keys)
+	require.Equal(t, int64(5), manifest.Keys[0].Records)
+	require.Len(t, manifest.Keys[0].Files, 2)
+	require.Equal(t, int64(4), manifest.Keys[0].Files[0].Records)
+	require.Equal(t, fileSize(t, filepath.Join(tempDir, "list_00.jsonl")), manifest.Keys[0].Files[0].Bytes)
+
+	// a full last file isn't appended to
+	split(`{"list": [{"idx": 5}]}`, Options{MaxFileBytes: 5})
+	requireContents(t, filepath.Join(tempDir, "list_01.jsonl"), `{"idx":4}`)
+	requireContents(t, filepath.Join(tempDir, "list_02.jsonl"), `{"idx":5}`)
+	require.Equal(t, int64(6), readManifest(t, tempDir).Keys[0].Records)
+}
+
+func TestSplitStreamAppendCompressed(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for _, doc := range []string{`{"a": 1, "list": [{"idx": 0}]}`, `{"b": 2, "list": [{"idx": 1}]}`} {
+		bs := NewTestByteStream([]byte(doc), 8)
+		opts := Options{ExistingOutput: ExistingOutputAppend, CompressOutput: true}
+		require.NoError(t, SplitStream(context.Background(), bs, tempDir, opts))
+	}
+
+	requireGzipContents(t, filepath.Join(tempDir, "list_00.jsonl.gz"), "{\"idx\":0}\n{\"idx\":1}")
+	requireGzipContents(t, filepath.Join(tempDir, "root.json.gz"), "{\n\t\"a\":1,\n\t\"b\":2\n}")
+	require.Equal(t, int64(2), readManifest(t, tempDir).Keys[0].Records)
+}
+
+func TestSplitFileExistingOutput(t *testing.T) {
+	inputDir := t.TempDir()
+	outputDir := filepath.Join(t.TempDir(), "out")
+
+	first := filepath.Join(inputDir, "first.json")
+	require.NoError(t, os.WriteFile(first, []byte(`{"list": [{"idx": 0}]}`), 0o644))
+
+	second := filepath.Join(inputDir, "second.json")
+	require.NoError(t, os.WriteFile(second, []byte(`{"list": [{"idx": 1}]}`), 0o644))
+
+	require.NoError(t, SplitFile(context.Background(), first, outputDir, Options{ExistingOutput: ExistingOutputError}))
+
+	// an existing output directory is refused, replaced or appended to
+	err := SplitFile(context.Background(), second, outputDir, Options{ExistingOutput: ExistingOutputError})
+	require.ErrorIs(t, err, ErrOutputExists)
+	require.ErrorContains(t, err, outputDir)
+
+	require.NoError(t, SplitFile(context.Background(), second, outputDir, Options{ExistingOutput: ExistingOutputAppend}))
+	requireContents(t, filepath.Join(outputDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}")
+
+	require.NoError(t, SplitFile(context.Background(), first, outputDir, Options{ExistingOutput: ExistingOutputOverwrite}))
+	requireContents(t, filepath.Join(outputDir, "list_00.jsonl"), `{"idx":0}`)
+
+	err = SplitFiles(context.Background(), []string{first, second}, outputDir, Options{ExistingOutput: ExistingOutputAppend})
+	require.EqualError(t, err, "several files can't be split with appending to existing output")
+}
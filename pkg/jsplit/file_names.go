@@ -0,0 +1,109 @@
+package jsplit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NameCollisions is what is done when two keys, or two values of the field lists are partitioned by, would give their
+// files the same name, which only happens when Options.NormalizeNames is set
+type NameCollisions string
+
+const (
+	// NameCollisionsSuffix gives the files of the key found later a numeric suffix, e.g. user_profiles_2, and lists
+	// the key in the Collisions of the manifest
+	NameCollisionsSuffix NameCollisions = "suffix"
+	// NameCollisionsFail fails the split with ErrNameCollision before anything is written for the key found later
+	NameCollisionsFail NameCollisions = "fail"
+)
+
+// ErrNameCollision is returned when two keys would give their files the same name and Options.NameCollisions is
+// NameCollisionsFail
+var ErrNameCollision = errors.New("output file name collision")
+
+// fileNames chooses the names the files written for the keys of a document are given. Keys are escaped with fileKey,
+// or turned into slugs when Options.NormalizeNames is set, in which case keys which would share a slug are told apart
+// by a numeric suffix, in the order they are found, or fail the split, following Options.NameCollisions.
+type fileNames struct {
+	normalize  bool
+	fail       bool
+	slugs      map[string]string // slug given to each key
+	keys       map[string]string // key each slug was given to
+	collisions []ManifestCollision
+}
+
+// newFileNames returns a *fileNames naming the files of a split configured by opts
+func newFileNames(opts Options) *fileNames {
+	return &fileNames{
+		normalize: opts.NormalizeNames,
+		fail:      opts.NameCollisions == NameCollisionsFail,
+		slugs:     make(map[string]string),
+		keys:      make(map[string]string),
+	}
+}
+
+// name returns the name the files written for key are given. A key which appears more than once is always given the
+// same name.
+func (fn *fileNames) name(key string) (string, error) {
+	if !fn.normalize {
+		return fileKey(key), nil
+	}
+
+	if name, ok := fn.slugs[key]; ok {
+		return name, nil
+	}
+
+	base := slug(key)
+	name := base
+
+	if other, taken := fn.keys[base]; taken && fn.fail {
+		return "", fmt.Errorf("%w: %q and %q would both be written to files named %s", ErrNameCollision, other, key,
+			base)
+	}
+
+	for i := 2; ; i++ {
+		if _, taken := fn.keys[name]; !taken {
+			break
+		}
+
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+
+	if name != base {
+		fn.collisions = append(fn.collisions, ManifestCollision{Key: key, Name: name, Slug: base, With: fn.keys[base]})
+	}
+
+	fn.slugs[key] = name
+	fn.keys[name] = key
+
+	return name, nil
+}
+
+// addToManifest lists the keys which were given a suffix as their slug was taken in the manifest
+func (fn *fileNames) addToManifest(m *Manifest) {
+	m.Collisions = append(m.Collisions, fn.collisions...)
+}
+
+// slug returns a lowercase name for the key made up of ascii letters, digits and underscores. Every run of other
+// characters, such as spaces, is replaced by a single underscore, e.g. "User Profiles" becomes user_profiles. Keys
+// without any letters or digits become key.
+func slug(key string) string {
+	var sb strings.Builder
+
+	for _, r := range strings.ToLower(key) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case sb.Len() > 0 && !strings.HasSuffix(sb.String(), "_"):
+			sb.WriteByte('_')
+		}
+	}
+
+	s := strings.TrimSuffix(sb.String(), "_")
+	if s == "" {
+		return "key"
+	}
+
+	return s
+}
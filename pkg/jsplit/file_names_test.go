@@ -0,0 +1,124 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlug(t *testing.T) {
+	require.Equal(t, "user_profiles", slug("User Profiles"))
+	require.Equal(t, "a_b_c", slug("  A--b/..C!  "))
+	require.Equal(t, "caf", slug("Café"))
+	require.Equal(t, "2021_orders", slug("2021_Orders"))
+	require.Equal(t, "key", slug("日本"))
+	require.Equal(t, "key", slug(""))
+}
+
+// requireName requires the key to be given the name
+func requireName(t *testing.T, names *fileNames, key, expected string) {
+	name, err := names.name(key)
+	require.NoError(t, err)
+	require.Equal(t, expected, name)
+}
+
+func TestFileNames(t *testing.T) {
+	names := newFileNames(Options{NormalizeNames: true})
+	requireName(t, names, "User Profiles", "user_profiles")
+	requireName(t, names, "user-profiles", "user_profiles_2")
+	requireName(t, names, "USER_PROFILES", "user_profiles_3")
+	requireName(t, names, "User Profiles", "user_profiles")
+
+	// a key whose slug is already taken by a suffixed name is given the next free one
+	requireName(t, names, "User Profiles 2", "user_profiles_2_2")
+
+	var manifest Manifest
+
+	names.addToManifest(&manifest)
+	require.Equal(t, []ManifestCollision{
+		{Key: "user-profiles", Name: "user_profiles_2", Slug: "user_profiles", With: "User Profiles"},
+		{Key: "USER_PROFILES", Name: "user_profiles_3", Slug: "user_profiles", With: "User Profiles"},
+		{Key: "User Profiles 2", Name: "user_profiles_2_2", Slug: "user_profiles_2", With: "user-profiles"},
+	}, manifest.Collisions)
+
+	// a key whose slug is taken fails instead when collisions aren't allowed, while the key given it still has it
+	names = newFileNames(Options{NormalizeNames: true, NameCollisions: NameCollisionsFail})
+	requireName(t, names, "User Profiles", "user_profiles")
+
+	_, err := names.name("user-profiles")
+	require.ErrorIs(t, err, ErrNameCollision)
+	require.EqualError(t, err, `output file name collision: "User Profiles" and "user-profiles" would both be `+
+		`written to files named user_profiles`)
+	requireName(t, names, "User Profiles", "user_profiles")
+
+	// keys are only escaped when names aren't normalized
+	names = newFileNames(Options{})
+	requireName(t, names, "User Profiles", "User Profiles")
+	requireName(t, names, "a/b", "a%2Fb")
+}
+
+func TestSplitStreamNormalizeNames(t *testing.T) {
+	const doc = `{"User Profiles": [{"id": 1}], "user-profiles": [{"id": 2}], "Orders/2021": [{"id": 3}]}`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	opts := Options{NormalizeNames: true, InferSchema: true}
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, opts))
+
+	requireContents(t, filepath.Join(tempDir, "user_profiles_00.jsonl"), `{"id":1}`)
+	requireContents(t, filepath.Join(tempDir, "user_profiles_2_00.jsonl"), `{"id":2}`)
+	requireContents(t, filepath.Join(tempDir, "orders_2021_00.jsonl"), `{"id":3}`)
+	require.FileExists(t, filepath.Join(tempDir, "user_profiles.schema.json"))
+
+	// the manifest records the key each file was written for
+	manifest := readManifest(t, tempDir)
+	require.Len(t, manifest.Keys, 3)
+	require.Equal(t, "User Profiles", manifest.Keys[0].Key)
+	require.Equal(t, "user_profiles_00.jsonl", manifest.Keys[0].Files[0].Name)
+	require.Equal(t, "user_profiles.schema.json", manifest.Keys[0].Schema)
+	require.Equal(t, "user-profiles", manifest.Keys[1].Key)
+	require.Equal(t, "user_profiles_2_00.jsonl", manifest.Keys[1].Files[0].Name)
+	require.Equal(t, "Orders/2021", manifest.Keys[2].Key)
+}
+
+func TestSplitStreamPartitionNameCollisions(t *testing.T) {
+	const elements = `[{"city": "New York"}, {"city": "new-york"}, {"city": "Boston"}, {"city": "New York"}]`
+
+	// values whose slugs are the same are written to files of their own, and listed in the manifest
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(elements), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{PartitionBy: "city", NormalizeNames: true}))
+
+	requireContents(t, filepath.Join(tempDir, "new_york_00.jsonl"),
+		`{"city":"New York"}`+"\n"+`{"city":"New York"}`)
+	requireContents(t, filepath.Join(tempDir, "new_york_2_00.jsonl"), `{"city":"new-york"}`)
+	requireContents(t, filepath.Join(tempDir, "boston_00.jsonl"), `{"city":"Boston"}`)
+
+	manifest := readManifest(t, tempDir)
+	require.Equal(t, "new-york", manifest.Keys[1].Key)
+	require.Equal(t, "new_york_2_00.jsonl", manifest.Keys[1].Files[0].Name)
+	require.Equal(t, []ManifestCollision{{Key: "new-york", Name: "new_york_2", Slug: "new_york", With: "New York"}},
+		manifest.Collisions)
+
+	// or fail the split before anything is written for the later value
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(elements), 8)
+	err := SplitStream(context.Background(), bs, tempDir,
+		Options{PartitionBy: "city", NormalizeNames: true, NameCollisions: NameCollisionsFail})
+	require.ErrorIs(t, err, ErrNameCollision)
+	require.EqualError(t, err, `partitioning by city: output file name collision: "New York" and "new-york" would `+
+		`both be written to files named new_york`)
+	require.NoFileExists(t, filepath.Join(tempDir, "new_york_2_00.jsonl"))
+	require.NoFileExists(t, filepath.Join(tempDir, ManifestFilename))
+
+	// the keys of an object are checked in the same way
+	bs = NewTestByteStream([]byte(`{"User Profiles": [{"id": 1}], "user-profiles": [{"id": 2}]}`), 8)
+	err = SplitStream(context.Background(), bs, t.TempDir(), Options{NormalizeNames: true,
+		NameCollisions: NameCollisionsFail})
+	require.ErrorIs(t, err, ErrNameCollision)
+
+	err = SplitStream(context.Background(), bs, t.TempDir(), Options{NameCollisions: "rename"})
+	require.EqualError(t, err, `unsupported name collision policy "rename", it must be suffix or fail`)
+}
@@ -0,0 +1,615 @@
+package jsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// filterExpr is a parsed Options.Filter, which reports whether an item, decoded with json.Number numbers, matches
+type filterExpr interface {
+	match(item interface{}) bool
+}
+
+// filterAnd matches items which match both of its expressions
+type filterAnd struct {
+	left, right filterExpr
+}
+
+func (f filterAnd) match(item interface{}) bool {
+	return f.left.match(item) && f.right.match(item)
+}
+
+// filterOr matches items which match either of its expressions
+type filterOr struct {
+	left, right filterExpr
+}
+
+func (f filterOr) match(item interface{}) bool {
+	return f.left.match(item) || f.right.match(item)
+}
+
+// filterNot matches items which don't match its expression
+type filterNot struct {
+	expr filterExpr
+}
+
+func (f filterNot) match(item interface{}) bool {
+	return !f.expr.match(item)
+}
+
+// filterExists matches items which have the field, even when it is null
+type filterExists struct {
+	field []string
+}
+
+func (f filterExists) match(item interface{}) bool {
+	_, ok := resolveField(item, f.field)
+	return ok
+}
+
+// filterOperand is one side of a comparison, either a field of the item or a literal value
+type filterOperand struct {
+	field []string
+	value interface{}
+}
+
+// resolve returns the value of the operand for the item, which is nil for a missing field
+func (o filterOperand) resolve(item interface{}) interface{} {
+	if o.field == nil {
+		return o.value
+	}
+
+	val, _ := resolveField(item, o.field)
+
+	return val
+}
+
+// filterCompare matches items for which the comparison of its operands holds
+type filterCompare struct {
+	op          string
+	left, right filterOperand
+}
+
+func (f filterCompare) match(item interface{}) bool {
+	left, right := f.left.resolve(item), f.right.resolve(item)
+
+	switch f.op {
+	case "==":
+		return filterEqual(left, right)
+	case "!=":
+		return !filterEqual(left, right)
+	}
+
+	cmp, ok := filterOrder(left, right)
+	if !ok {
+		return false
+	}
+
+	switch f.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// resolveField returns the value of the field of the item, following the names of the fields of nested objects, or
+// false if the item doesn't have it
+func resolveField(item interface{}, field []string) (interface{}, bool) {
+	for _, name := range field {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		item, ok = obj[name]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return item, true
+}
+
+// filterEqual reports whether two values are equal. Numbers are equal when they have the same value however they are
+// written, and values of different types are never equal.
+func filterEqual(a, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+
+	if aok && bok {
+		cmp, ok := compareNumbers(an, bn)
+		return ok && cmp == 0
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// filterOrder compares two numbers or two strings, returning false for any other values, which have no order
+func filterOrder(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+
+		return compareNumbers(av, bv)
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+
+		return strings.Compare(av, bv), true
+	}
+
+	return 0, false
+}
+
+// compareNumbers compares two json numbers, exactly when both are integers, so that large ids can be matched
+func compareNumbers(a, b json.Number) (int, bool) {
+	ai, aerr := a.Int64()
+	bi, berr := b.Int64()
+
+	if aerr == nil && berr == nil {
+		switch {
+		case ai < bi:
+			return -1, true
+		case ai > bi:
+			return 1, true
+		}
+
+		return 0, true
+	}
+
+	af, aerr := a.Float64()
+	bf, berr := b.Float64()
+
+	if aerr != nil || berr != nil {
+		return 0, false
+	}
+
+	switch {
+	case af < bf:
+		return -1, true
+	case af > bf:
+		return 1, true
+	}
+
+	return 0, true
+}
+
+// filterTokenKind is the kind of a token of a filter expression
+type filterTokenKind int
+
+const (
+	filterEOF filterTokenKind = iota
+	filterIdent
+	filterString
+	filterNumber
+	filterOp
+	filterOpen
+	filterClose
+)
+
+// filterToken is a token of a filter expression, along with its offset in the expression
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	pos  int
+}
+
+// describe returns the token as it is named in errors
+func (tok filterToken) describe() string {
+	if tok.kind == filterEOF {
+		return "the end of the filter"
+	}
+
+	return strconv.Quote(tok.text)
+}
+
+// lexFilter splits the filter expression into tokens, ending with a filterEOF token
+func lexFilter(text string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	i := 0
+	for i < len(text) {
+		ch := text[i]
+		start := i
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			i++
+			continue
+		case ch == '(':
+			tokens = append(tokens, filterToken{filterOpen, "(", i})
+			i++
+		case ch == ')':
+			tokens = append(tokens, filterToken{filterClose, ")", i})
+			i++
+		case strings.HasPrefix(text[i:], "==") || strings.HasPrefix(text[i:], "!=") ||
+			strings.HasPrefix(text[i:], "<=") || strings.HasPrefix(text[i:], ">=") ||
+			strings.HasPrefix(text[i:], "&&") || strings.HasPrefix(text[i:], "||"):
+			tokens = append(tokens, filterToken{filterOp, text[i : i+2], i})
+			i += 2
+		case ch == '<' || ch == '>' || ch == '!':
+			tokens = append(tokens, filterToken{filterOp, text[i : i+1], i})
+			i++
+		case ch == '=':
+			return nil, fmt.Errorf("unexpected = at offset %d, use == to compare values", i)
+		case ch == QM:
+			end, err := stringEnd(text, i)
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, filterToken{filterString, text[i:end], i})
+			i = end
+		case ch == '-' || ch >= '0' && ch <= '9':
+			for i++; i < len(text) && strings.IndexByte("0123456789.eE+-", text[i]) >= 0; i++ {
+			}
+
+			tokens = append(tokens, filterToken{filterNumber, text[start:i], start})
+		case isFilterIdentStart(ch):
+			for i++; i < len(text) && (isFilterIdentStart(text[i]) || text[i] >= '0' && text[i] <= '9' ||
+				text[i] == '-' || text[i] == '.'); i++ {
+			}
+
+			tokens = append(tokens, filterToken{filterIdent, text[start:i], start})
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", text[i:i+1], i)
+		}
+	}
+
+	return append(tokens, filterToken{filterEOF, "", len(text)}), nil
+}
+
+// stringEnd returns the offset following the json string starting at offset start of the text
+func stringEnd(text string, start int) (int, error) {
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case QM:
+			return i + 1, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated string at offset %d", start)
+}
+
+// isFilterIdentStart reports whether ch can start the name of a field
+func isFilterIdentStart(ch byte) bool {
+	return ch == '_' || ch == '$' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+// filterParser parses a filter expression by recursive descent
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseFilter parses a filter expression, see Options.Filter
+func parseFilter(text string) (filterExpr, error) {
+	tokens, err := lexFilter(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", text, err)
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err == nil && p.peek().kind != filterEOF {
+		err = p.errorf("expected and, or or the end of the filter")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", text, err)
+	}
+
+	return expr, nil
+}
+
+// peek returns the next token without consuming it
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+// next consumes the next token
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != filterEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+// is reports whether the next token is the keyword or operator, either of which may be given
+func (p *filterParser) is(keyword, op string) bool {
+	tok := p.peek()
+	return tok.kind == filterIdent && tok.text == keyword || tok.kind == filterOp && tok.text == op
+}
+
+// errorf returns an error describing what was expected in place of the next token
+func (p *filterParser) errorf(expected string) error {
+	tok := p.peek()
+	return fmt.Errorf("%s at offset %d, found %s", expected, tok.pos, tok.describe())
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.is("or", "||") {
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = filterOr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.is("and", "&&") {
+		p.next()
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		left = filterAnd{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if p.is("not", "!") {
+		p.next()
+
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return filterNot{expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	tok := p.peek()
+
+	switch {
+	case tok.kind == filterOpen:
+		p.next()
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek().kind != filterClose {
+			return nil, p.errorf("expected )")
+		}
+
+		p.next()
+
+		return expr, nil
+	case tok.kind == filterIdent && tok.text == "exists":
+		p.next()
+
+		if p.peek().kind != filterOpen {
+			return nil, p.errorf("expected ( after exists")
+		}
+
+		p.next()
+
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek().kind != filterClose {
+			return nil, p.errorf("expected )")
+		}
+
+		p.next()
+
+		return filterExists{field}, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	if op.kind != filterOp || !strings.Contains(" == != < <= > >= ", " "+op.text+" ") {
+		return nil, p.errorf("expected a comparison")
+	}
+
+	p.next()
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterCompare{op: op.text, left: left, right: right}, nil
+}
+
+// parseOperand parses a field or a literal string, number, true, false or null
+func (p *filterParser) parseOperand() (filterOperand, error) {
+	tok := p.peek()
+
+	switch tok.kind {
+	case filterString:
+		p.next()
+
+		var s string
+
+		err := json.Unmarshal([]byte(tok.text), &s)
+		if err != nil {
+			return filterOperand{}, fmt.Errorf("invalid string %s at offset %d", tok.text, tok.pos)
+		}
+
+		return filterOperand{value: s}, nil
+	case filterNumber:
+		p.next()
+
+		if !json.Valid([]byte(tok.text)) {
+			return filterOperand{}, fmt.Errorf("invalid number %s at offset %d", tok.text, tok.pos)
+		}
+
+		return filterOperand{value: json.Number(tok.text)}, nil
+	case filterIdent:
+		switch tok.text {
+		case "true", "false":
+			p.next()
+			return filterOperand{value: tok.text == "true"}, nil
+		case "null":
+			p.next()
+			return filterOperand{}, nil
+		}
+
+		if isFilterKeyword(tok.text) {
+			break
+		}
+
+		field, err := p.parseField()
+		if err != nil {
+			return filterOperand{}, err
+		}
+
+		return filterOperand{field: field}, nil
+	}
+
+	return filterOperand{}, p.errorf("expected a field or a value")
+}
+
+// parseField parses the name of a field, with the names of nested fields separated by dots, e.g. user.address.city
+func (p *filterParser) parseField() ([]string, error) {
+	tok := p.peek()
+	if tok.kind != filterIdent || isFilterKeyword(tok.text) {
+		return nil, p.errorf("expected a field")
+	}
+
+	field := strings.Split(tok.text, ".")
+	for _, name := range field {
+		if name == "" {
+			return nil, fmt.Errorf("invalid field %s at offset %d", tok.text, tok.pos)
+		}
+	}
+
+	p.next()
+
+	return field, nil
+}
+
+// isFilterKeyword reports whether the word has a meaning of its own in a filter, and so can't be the name of a field
+func isFilterKeyword(word string) bool {
+	switch word {
+	case "and", "or", "not", "exists", "true", "false", "null":
+		return true
+	}
+
+	return false
+}
+
+// filterCounts counts the items of a list which matched Options.Filter and those which were dropped, by the filter or
+// by Options.Since
+type filterCounts struct {
+	matched     int64
+	dropped     int64
+	since       sinceCounts
+	transformed int64 // the items dropped by Options.RecordTransform
+}
+
+// total returns the number of items dropped by the filter, Options.Since and Options.RecordTransform
+func (fc filterCounts) total() int64 {
+	return fc.dropped + fc.transformed
+}
+
+// filterItems returns a ListAddFunc passing the items of a list which match opts.Filter, and are kept by opts.Since, to
+// add, and counting them in counts. Items which aren't objects have no fields. add is returned as is when neither
+// opts.Filter nor opts.SinceField is set.
+func filterItems(add ListAddFunc, opts Options, counts *filterCounts) ListAddFunc {
+	if opts.Filter == "" && opts.SinceField == "" {
+		return add
+	}
+
+	// the filter was checked when the options were validated
+	expr, _ := parseFilter(opts.Filter)
+
+	return func(item []byte) error {
+		if opts.SinceField != "" && !keepSince(item, opts, &counts.since) {
+			counts.dropped++
+			return nil
+		}
+
+		if opts.Filter == "" {
+			counts.matched++
+			return add(item)
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.UseNumber()
+
+		var val interface{}
+
+		err := dec.Decode(&val)
+		if err != nil {
+			return err
+		}
+
+		if !expr.match(val) {
+			counts.dropped++
+			return nil
+		}
+
+		counts.matched++
+
+		return add(item)
+	}
+}
+
+// reportFiltered prints the number of items of the list with the given key which matched the filter and were dropped,
+// and those dropped by Options.Since
+func reportFiltered(key string, opts Options, counts filterCounts) {
+	reportSince(key, opts, counts.since)
+
+	if counts.transformed > 0 {
+		logger().Info(fmt.Sprintf("%d items of %s were dropped by the record transform", counts.transformed, key),
+			"key", key, "dropped", counts.transformed)
+	}
+
+	if opts.Filter != "" {
+		logger().Info(fmt.Sprintf("%d items of %s matched the filter, %d were dropped", counts.matched, key,
+			counts.dropped), "key", key, "matched", counts.matched, "dropped", counts.dropped)
+	}
+}
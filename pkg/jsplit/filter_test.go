@@ -0,0 +1,149 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterMatch(t *testing.T) {
+	const item = `{"status": "active", "age": 21, "score": 2.5, "id": 9007199254740993, "admin": false, ` +
+		`"manager": null, "tags": ["a", "b"], "user": {"address": {"city": "Oslo"}}, "min_age": 18}`
+
+	tests := map[string]bool{
+		`status == "active"`:                       true,
+		`status != "active"`:                       false,
+		`"active" == status`:                       true,
+		`status=="active"`:                         true,
+		`age == 21`:                                true,
+		`age == 21.0`:                              true,
+		`age == 2.1e1`:                             true,
+		`age == "21"`:                              false,
+		`age > 20 and age < 22`:                    true,
+		`age >= 21 && age <= 21`:                   true,
+		`age > 21`:                                 false,
+		`score < 3`:                                true,
+		`score >= -1`:                              true,
+		`age >= min_age`:                           true,
+		`id == 9007199254740993`:                   true,
+		`id == 9007199254740992`:                   false,
+		`status > "aardvark"`:                      true,
+		`status < 5`:                               false,
+		`admin == false`:                           true,
+		`admin != true`:                            true,
+		`manager == null`:                          true,
+		`missing == null`:                          true,
+		`missing != "x"`:                           true,
+		`missing == "x"`:                           false,
+		`missing < 1`:                              false,
+		`exists(manager)`:                          true,
+		`exists(missing)`:                          false,
+		`exists(user.address.city)`:                true,
+		`exists(status.length)`:                    false,
+		`user.address.city == "Oslo"`:              true,
+		`user.address == null`:                     false,
+		`tags == null`:                             false,
+		`not status == "active"`:                   false,
+		`!(age < 18)`:                              true,
+		`not not exists(age)`:                      true,
+		`age < 18 or status == "active"`:           true,
+		`age < 18 || status == "inactive"`:         false,
+		`age < 18 or age > 20 and status == "x"`:   false,
+		`(age < 18 or age > 20) and status == "x"`: false,
+		`age > 20 or age < 18 and status == "x"`:   true,
+		`status == "active" and (age >= 18 or exists(guardian))`: true,
+	}
+
+	var val interface{}
+
+	dec := json.NewDecoder(strings.NewReader(item))
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&val))
+
+	for text, expected := range tests {
+		t.Run(text, func(t *testing.T) {
+			expr, err := parseFilter(text)
+			require.NoError(t, err)
+			require.Equal(t, expected, expr.match(val))
+		})
+	}
+
+	// items which aren't objects have no fields
+	expr, err := parseFilter(`exists(a) or a == 1`)
+	require.NoError(t, err)
+	require.False(t, expr.match([]interface{}{json.Number("1")}))
+	require.False(t, expr.match("a"))
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := map[string]string{
+		``:                      `expected a field or a value at offset 0, found the end of the filter`,
+		`status`:                `expected a comparison at offset 6, found the end of the filter`,
+		`age > 1 or admin`:      `expected a comparison at offset 16, found the end of the filter`,
+		`status = "active"`:     `unexpected = at offset 7, use == to compare values`,
+		`status == `:            `expected a field or a value at offset 10, found the end of the filter`,
+		`status == "active`:     `unterminated string at offset 10`,
+		`status == "a\x"`:       `invalid string "a\x" at offset 10`,
+		`age > 1.2.3`:           `invalid number 1.2.3 at offset 6`,
+		`age > 01`:              `invalid number 01 at offset 6`,
+		`age > -`:               `invalid number - at offset 6`,
+		`age >> 1`:              `expected a field or a value at offset 5, found ">"`,
+		`(age > 1`:              `expected ) at offset 8, found the end of the filter`,
+		`age > 1)`:              `expected and, or or the end of the filter at offset 7, found ")"`,
+		`age > 1 age < 2`:       `expected and, or or the end of the filter at offset 8, found "age"`,
+		`age > 1 and`:           `expected a field or a value at offset 11, found the end of the filter`,
+		`or age > 1`:            `expected a field or a value at offset 0, found "or"`,
+		`exists age`:            `expected ( after exists at offset 7, found "age"`,
+		`exists(age`:            `expected ) at offset 10, found the end of the filter`,
+		`exists("age")`:         `expected a field at offset 7, found "\"age\""`,
+		`exists(and)`:           `expected a field at offset 7, found "and"`,
+		`user..city == 1`:       `invalid field user..city at offset 0`,
+		`user. == 1`:            `invalid field user. at offset 0`,
+		`age > 1 ; drop tables`: `unexpected ";" at offset 8`,
+		`age == true == false`:  `expected and, or or the end of the filter at offset 12, found "=="`,
+		`not`:                   `expected a field or a value at offset 3, found the end of the filter`,
+	}
+
+	for text, msg := range tests {
+		t.Run(text, func(t *testing.T) {
+			_, err := parseFilter(text)
+			require.EqualError(t, err, "invalid filter "+strconv.Quote(text)+": "+msg)
+		})
+	}
+}
+
+func TestSplitStreamFilter(t *testing.T) {
+	const doc = `{"users": [{"id": 1, "active": true}, {"id": 2}, {"id": 3, "active": true}, 4], ` +
+		`"groups": [{"id": 1}], "n": 3}`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{Filter: `active == true or id == 1`}))
+
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1,"active":true}`+"\n"+`{"id":3,"active":true}`)
+	requireContents(t, filepath.Join(tempDir, "groups_00.jsonl"), `{"id":1}`)
+
+	manifest := readManifest(t, tempDir)
+	require.Len(t, manifest.Keys, 2)
+	require.Equal(t, int64(2), manifest.Keys[0].Records)
+	require.Equal(t, int64(2), manifest.Keys[0].Filtered)
+	require.Zero(t, manifest.Keys[1].Filtered)
+
+	// items which don't match don't count towards the limit, and the elements of a root list are filtered too
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(`[{"v": 1}, {"v": 5}, {"v": 2}, {"v": 6}, {"v": 7}]`), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{Filter: `v > 4`, Limit: 2}))
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), `{"v":5}`+"\n"+`{"v":6}`)
+
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(), Options{Filter: `id =`})
+	require.EqualError(t, err, `invalid filter "id =": unexpected = at offset 3, use == to compare values`)
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(),
+		Options{Filter: `id == 1`, CheckpointInterval: 10})
+	require.EqualError(t, err, "checkpoints can't be used with a filter")
+}
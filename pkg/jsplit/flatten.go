@@ -0,0 +1,161 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// DefaultFlattenSeparator joins the keys of nested objects when Options.FlattenSeparator isn't set
+const DefaultFlattenSeparator = "."
+
+// flattener rewrites json objects so that nested objects are replaced by their fields, with keys joined by a
+// separator, e.g. {"a":{"b":1}} becomes {"a.b":1}. Arrays of scalars are optionally replaced by a field per element.
+// Empty objects and arrays, and arrays holding objects or arrays, are left as json. Items whose flattened keys
+// collide, e.g. {"a":{"b":1},"a.b":2}, fail rather than being written with the same key twice.
+type flattener struct {
+	separator   string
+	indexArrays bool
+	buf         []byte
+	keys        map[string]struct{} // the keys appended for the item being flattened
+}
+
+// newFlattener returns a *flattener configured from opts
+func newFlattener(opts Options) *flattener {
+	separator := opts.FlattenSeparator
+	if separator == "" {
+		separator = DefaultFlattenSeparator
+	}
+
+	return &flattener{separator: separator, indexArrays: opts.FlattenArrays, keys: make(map[string]struct{})}
+}
+
+// flattenItems returns a ListAddFunc which flattens every item before passing it to add when opts.Flatten is set, and
+// add itself otherwise. Items are flattened one at a time as they are split so memory use stays bounded.
+func flattenItems(add ListAddFunc, opts Options) ListAddFunc {
+	if !opts.Flatten {
+		return add
+	}
+
+	f := newFlattener(opts)
+
+	return func(item []byte) error {
+		flat, err := f.flatten(item)
+		if err != nil {
+			return err
+		}
+
+		return add(flat)
+	}
+}
+
+// flatten returns the flattened item. Items which aren't objects are returned unchanged. The returned slice is reused
+// by the next call.
+func (f *flattener) flatten(item []byte) ([]byte, error) {
+	if len(item) == 0 || item[0] != OpenCB {
+		return item, nil
+	}
+
+	fields, err := decodeObject(item)
+	if err != nil {
+		return nil, err
+	}
+
+	f.buf = append(f.buf[:0], OpenCB)
+	clear(f.keys)
+
+	err = f.appendFields("", fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(f.buf, CloseCB), nil
+}
+
+// appendFields appends the flattened fields to the buffer, prefixing their keys
+func (f *flattener) appendFields(prefix string, fields []objectField) error {
+	for _, field := range fields {
+		key := prefix + field.Key
+		val := field.Value
+
+		switch {
+		case len(val) > 2 && val[0] == OpenCB:
+			nested, err := decodeObject(val)
+			if err != nil {
+				return err
+			}
+
+			err = f.appendFields(key+f.separator, nested)
+			if err != nil {
+				return err
+			}
+
+			continue
+
+		case len(val) > 2 && val[0] == OpenSB && f.indexArrays:
+			elems, ok, err := scalarElements(val)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				for i, elem := range elems {
+					err = f.appendField(key+f.separator+strconv.Itoa(i), elem)
+					if err != nil {
+						return err
+					}
+				}
+
+				continue
+			}
+		}
+
+		err := f.appendField(key, val)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendField appends a single key and value to the buffer, failing if the item already has the key
+func (f *flattener) appendField(key string, val []byte) error {
+	if _, ok := f.keys[key]; ok {
+		return fmt.Errorf("flattening the item gives it the key %q twice, set a flatten separator its keys don't "+
+			"hold", key)
+	}
+
+	f.keys[key] = struct{}{}
+
+	if len(f.buf) > 1 {
+		f.buf = append(f.buf, COMMA)
+	}
+
+	// marshalling a string can't fail
+	encodedKey, _ := json.Marshal(key)
+
+	f.buf = append(f.buf, encodedKey...)
+	f.buf = append(f.buf, COLON)
+	f.buf = append(f.buf, val...)
+
+	return nil
+}
+
+// scalarElements returns the elements of a json array, and whether they are all scalars
+func scalarElements(val []byte) ([]json.RawMessage, bool, error) {
+	var elems []json.RawMessage
+
+	err := json.Unmarshal(val, &elems)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, elem := range elems {
+		if len(elem) == 0 || elem[0] == OpenCB || elem[0] == OpenSB {
+			return nil, false, nil
+		}
+	}
+
+	return elems, len(elems) > 0, nil
+}
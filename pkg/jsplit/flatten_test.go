@@ -0,0 +1,120 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		item     string
+		expected string
+	}{
+		{
+			name:     "flat",
+			item:     `{"a":1,"b":"str"}`,
+			expected: `{"a":1,"b":"str"}`,
+		},
+		{
+			name:     "deeply nested",
+			item:     `{"a":{"b":{"c":{"d":1.50}},"e":true},"f":null}`,
+			expected: `{"a.b.c.d":1.50,"a.e":true,"f":null}`,
+		},
+		{
+			name:     "separator",
+			opts:     Options{FlattenSeparator: "__"},
+			item:     `{"a":{"b":{"c":1}}}`,
+			expected: `{"a__b__c":1}`,
+		},
+		{
+			name:     "arrays left as json",
+			item:     `{"a":{"b":[1,2],"c":[{"d":1}]}}`,
+			expected: `{"a.b":[1,2],"a.c":[{"d":1}]}`,
+		},
+		{
+			name:     "arrays of scalars indexed",
+			opts:     Options{FlattenArrays: true},
+			item:     `{"a":{"b":[1,"x",null]},"c":[{"d":1}],"e":[1,[2]],"f":[]}`,
+			expected: `{"a.b.0":1,"a.b.1":"x","a.b.2":null,"c":[{"d":1}],"e":[1,[2]],"f":[]}`,
+		},
+		{
+			name:     "empty objects",
+			item:     `{"a":{},"b":{"c":{}}}`,
+			expected: `{"a":{},"b.c":{}}`,
+		},
+		{
+			name:     "escaped keys",
+			item:     `{"a\"b":{"c\\d":1}}`,
+			expected: `{"a\"b.c\\d":1}`,
+		},
+		{
+			name:     "not an object",
+			item:     `[1,{"a":{"b":1}}]`,
+			expected: `[1,{"a":{"b":1}}]`,
+		},
+		{
+			name:     "scalar",
+			item:     `"str"`,
+			expected: `"str"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flat, err := newFlattener(test.opts).flatten([]byte(test.item))
+			require.NoError(t, err)
+			require.Equal(t, test.expected, string(flat))
+		})
+	}
+
+	_, err := newFlattener(Options{}).flatten([]byte(`{"a":{"b":}}`))
+	require.Error(t, err)
+
+	// keys which collide once flattened fail the item, rather than being written twice
+	for _, item := range []string{`{"a":{"b":{"c":1}},"a.b":{"c":2}}`, `{"a.b.c":1,"a":{"b":{"c":2}}}`} {
+		_, err = newFlattener(Options{}).flatten([]byte(item))
+		require.EqualError(t, err, `flattening the item gives it the key "a.b.c" twice, set a flatten separator its `+
+			`keys don't hold`, item)
+	}
+
+	_, err = newFlattener(Options{FlattenArrays: true}).flatten([]byte(`{"a":[1,2],"a.1":3}`))
+	require.ErrorContains(t, err, `the key "a.1" twice`)
+
+	// the same keys are fine in different items, and with a separator the keys don't hold
+	f := newFlattener(Options{})
+	for i := 0; i < 2; i++ {
+		flat, err := f.flatten([]byte(`{"a":{"b":1}}`))
+		require.NoError(t, err)
+		require.Equal(t, `{"a.b":1}`, string(flat))
+	}
+
+	flat, err := newFlattener(Options{FlattenSeparator: "/"}).flatten([]byte(`{"a":{"b":{"c":1}},"a.b":{"c":2}}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"a/b/c":1,"a.b/c":2}`, string(flat))
+}
+
+func TestSplitStreamFlatten(t *testing.T) {
+	var testStr = `{"key": {"nested": 1}, "users": [{"id": 1, "address": {"city": "x", "geo": {"lat": 1}}}, {"id": 2, "tags": ["a", "b"]}]}`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{Flatten: true, FlattenArrays: true, Format: FormatCSV})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "users_00.csv"), "id,address.city,address.geo.lat,tags.0,tags.1\n1,x,1,,\n2,,,a,b\n")
+
+	// only list items are flattened
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"key\":{\"nested\":1}\n}")
+
+	bs = NewTestByteStream([]byte(`[{"a": {"b": 1}}, {"a": {"c": 2}}]`), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Shards: 1, Flatten: true, Format: FormatNDJSON})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "part-000_00.ndjson"), "{\"a.b\":1}\n{\"a.c\":2}\n")
+}
@@ -0,0 +1,129 @@
+package jsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// WithFollow keeps reading a local file once its end has been reached, as tail -F does, so that a log which is still
+// being written is split as it grows. At the end of the file it is read again after a pause, of up to 100ms, until
+// done is closed, after which the end of the file ends the input. A file which is rotated, replaced by a new file
+// with the same name, is reopened once the old one has been read to its end, and one which is truncated is read again
+// from its start. A followed file has no end to find, so it is split as a stream of json values, as ndjson is, and
+// without decompressing it. Only local files can be followed, and not a byte range of them. A read timeout fails the
+// split once the file hasn't grown for that long.
+func WithFollow(done <-chan struct{}) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		afr.follow = done
+
+		return nil
+	}
+}
+
+// following reports whether the AsyncReader follows a file, see WithFollow
+func (afr *AsyncReader) following() bool {
+	return afr.follow != nil
+}
+
+// follows reports whether rd is read from a file which is being followed, see WithFollow
+func follows(rd ByteStream) bool {
+	fr, ok := rd.(interface{ following() bool })
+	return ok && fr.following()
+}
+
+// followFile sets the local file at path, followed until afr.follow is closed, as the source of the AsyncReader
+func (afr *AsyncReader) followFile(path string) (*AsyncReader, error) {
+	if afr.readAs != CompressionAuto && afr.readAs != CompressionNone {
+		return nil, fmt.Errorf("%s can't be followed, a followed file can't be decompressed as %s", path, afr.readAs)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fr := &followReader{path: path, f: f, done: afr.follow}
+
+	afr.rd = fr
+	afr.closers = []io.Closer{fr}
+
+	return afr, nil
+}
+
+// followReader reads a file which is still being written. At the end of the file it returns neither data nor an
+// error, so that the AsyncReader reads it again after a pause, until done is closed.
+type followReader struct {
+	path   string
+	f      *os.File
+	offset int64 // the offset in f of the next byte read
+	done   <-chan struct{}
+}
+
+// Read reads the next bytes of the file, reopening it at its end if it has been rotated
+func (fr *followReader) Read(p []byte) (int, error) {
+	n, err := fr.f.Read(p)
+	fr.offset += int64(n)
+
+	if err != io.EOF {
+		return n, err
+	}
+
+	if n > 0 {
+		return n, nil
+	}
+
+	select {
+	case <-fr.done:
+		return 0, io.EOF
+	default:
+	}
+
+	return 0, fr.reopen()
+}
+
+// reopen opens the file now at path if it isn't the one being read, as once a log has been rotated, or seeks back to
+// the start of the file if it has been truncated to less than has been read, once the end of the file being read has
+// been reached
+func (fr *followReader) reopen() error {
+	cur, err := fr.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(fr.path)
+	if err != nil {
+		// a log which has been rotated may not have been replaced yet
+		return nil
+	}
+
+	switch {
+	case !os.SameFile(fi, cur):
+		f, err := os.Open(fr.path)
+		if err != nil {
+			return nil
+		}
+
+		logger().Info(fmt.Sprintf("%s was replaced, following the new file", fr.path), "file", fr.path)
+
+		_ = fr.f.Close()
+		fr.f = f
+		fr.offset = 0
+	case cur.Size() < fr.offset:
+		logger().Warn(fmt.Sprintf("%s was truncated, following it from its start", fr.path), "file", fr.path)
+
+		_, err = fr.f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		fr.offset = 0
+	}
+
+	return nil
+}
+
+// Close closes the file being read
+func (fr *followReader) Close() error {
+	return fr.f.Close()
+}
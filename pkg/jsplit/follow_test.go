@@ -0,0 +1,138 @@
+package jsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollow(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(input, []byte("{\"id\": 1}\n{\"id\": 2}\n"), 0o644))
+
+	done := make(chan struct{})
+
+	rd, err := AsyncReaderFromFile(input, 8, WithFollow(done))
+	require.NoError(t, err)
+
+	defer rd.Close()
+
+	ctx := rd.Start(context.Background())
+	outputDir := filepath.Join(dir, "out")
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- SplitStream(ctx, rd, outputDir, Options{})
+	}()
+
+	read := int64(20)
+	waitForRead := func() {
+		require.Eventually(t, func() bool { return rd.BytesRead() == read }, 5*time.Second, time.Millisecond)
+	}
+
+	waitForRead()
+
+	// lines appended once the end of the file has been read are read as they are written, even part of one at a time
+	appendTo := func(data string) {
+		f, err := os.OpenFile(input, os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+
+		_, err = f.WriteString(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		read += int64(len(data))
+	}
+
+	appendTo("{\"id\": 3}\n{\"id\":")
+	waitForRead()
+	appendTo(" 4}\n")
+
+	// a rotated file is read to its end before the file which replaced it is read
+	appendTo("{\"id\": 5}\n")
+	require.NoError(t, os.Rename(input, input+".1"))
+	require.NoError(t, os.WriteFile(input, []byte("{\"id\": 6}\n"), 0o644))
+	read += 10
+	waitForRead()
+
+	select {
+	case err := <-errCh:
+		require.FailNow(t, "the split ended before following stopped", "%v", err)
+	default:
+	}
+
+	// once following stops the split ends at the end of the file
+	close(done)
+	require.NoError(t, <-errCh)
+
+	requireContents(t, filepath.Join(outputDir, "root_00.jsonl"),
+		"{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}\n{\"id\":5}\n{\"id\":6}")
+}
+
+func TestFollowTruncated(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(input, []byte("{\"id\": 1}\n{\"id\": 2}\n"), 0o644))
+
+	done := make(chan struct{})
+
+	rd, err := AsyncReaderFromFile(input, 8, WithFollow(done))
+	require.NoError(t, err)
+
+	defer rd.Close()
+
+	ctx := rd.Start(context.Background())
+	outputDir := filepath.Join(dir, "out")
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- SplitStream(ctx, rd, outputDir, Options{})
+	}()
+
+	require.Eventually(t, func() bool { return rd.BytesRead() == 20 }, 5*time.Second, time.Millisecond)
+
+	// a file truncated to less than has been read is read again from its start
+	require.NoError(t, os.WriteFile(input, []byte("{\"id\": 3}\n"), 0o644))
+	require.Eventually(t, func() bool { return rd.BytesRead() == 30 }, 5*time.Second, time.Millisecond)
+
+	close(done)
+	require.NoError(t, <-errCh)
+
+	requireContents(t, filepath.Join(outputDir, "root_00.jsonl"), "{\"id\":1}\n{\"id\":2}\n{\"id\":3}")
+}
+
+func TestFollowErrors(t *testing.T) {
+	done := make(chan struct{})
+
+	// only whole local files can be followed
+	for _, uri := range []string{"-", "https://example.com/app.log", "gs://bucket/app.log", "s3://bucket/app.log"} {
+		_, err := AsyncReaderFromFile(uri, 8, WithFollow(done))
+		require.EqualError(t, err, "only whole local files can be followed, not "+uri)
+	}
+
+	input := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(input, []byte("{\"id\": 1}\n"), 0o644))
+
+	_, err := AsyncReaderFromFile(input, 8, WithFollow(done), WithByteRange(0, 4))
+	require.EqualError(t, err, "only whole local files can be followed, not "+input)
+
+	_, err = AsyncReaderFromFile(input, 8, WithFollow(done), WithCompression(CompressionGzip))
+	require.EqualError(t, err, input+" can't be followed, a followed file can't be decompressed as gzip")
+
+	// a followed file is a stream of values, so it has no root to point into
+	close(done)
+
+	rd, err := AsyncReaderFromFile(input, 8, WithFollow(done))
+	require.NoError(t, err)
+
+	defer rd.Close()
+
+	err = SplitStream(rd.Start(context.Background()), rd, t.TempDir(), Options{Root: "/users"})
+	require.EqualError(t, err,
+		"a root pointer can't be used with a followed file, which is split as a stream of json values")
+}
@@ -0,0 +1,206 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AsyncReaderFromURL creates an AsyncReader for reading the body of a GET request to an http or https URL. Compressed
+// bodies, including those sent with a gzip Content-Encoding, are decompressed. The request is aborted if the context
+// passed to Start is cancelled. A download which fails part way through with a transient error, such as a dropped
+// connection, is resumed where it stopped as WithRetryPolicy allows, with a range request when the server accepts
+// them, or otherwise by requesting the whole body again and skipping what was already read. It fails instead if the
+// body has changed since it was first read, as told by its ETag or Last-Modified header.
+func AsyncReaderFromURL(uri string, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	rd, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	resp, err := httpGet(ctx, http.DefaultClient, uri)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	body := newResumableReader(resp.Body, httpResume(ctx, http.DefaultClient, uri, resp), rd.retry)
+
+	_, err = rd.attachSource(body, resp.ContentLength)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// bodies decompressed by the transport have the offsets of the decompressed data
+	rd.seekable = rd.seekable && !resp.Uncompressed
+
+	rd.closers = append(rd.closers, closerFunc(func() error {
+		cancel()
+		return nil
+	}))
+	rd.abort = cancel
+
+	return rd, nil
+}
+
+// HTTPReader returns the body of a GET request to the supplied uri. The caller is responsible for closing it.
+func HTTPReader(uri string) (io.ReadCloser, error) {
+	const HTTPTimeOut = 10 * time.Minute
+
+	var httpClient = &http.Client{
+		Timeout: HTTPTimeOut,
+	}
+
+	resp, err := httpGet(context.Background(), httpClient, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// httpGet issues a GET request returning the response. Responses with a non 2xx status are returned as errors.
+func httpGet(ctx context.Context, client *http.Client, uri string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return httpDo(client, req)
+}
+
+// httpGetFrom issues a GET request for length bytes of the body of uri from offset onwards, or for the rest of the body
+// when length is negative, as it is stored rather than decompressed. Servers which don't support range requests return
+// an error, as the whole body would have to be read again.
+func httpGetFrom(ctx context.Context, client *http.Client, uri string, offset, length int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if length < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+
+	req.Header.Set("Accept-Encoding", "identity")
+
+	r, err := httpDo(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.StatusCode != http.StatusPartialContent {
+		_ = r.Body.Close()
+		return nil, fmt.Errorf("GET %s failed: the server doesn't support range requests", uri)
+	}
+
+	return r, nil
+}
+
+// httpResume returns a reopenFunc continuing the body of resp, the response to a GET request to uri, at an offset.
+// Range requests are only made when the server accepts them and the body wasn't encoded, as the offsets read are those
+// of the decoded body.
+func httpResume(ctx context.Context, client *http.Client, uri string, resp *http.Response) reopenFunc {
+	ranges := resp.Header.Get("Accept-Ranges") == "bytes" && resp.Header.Get("Content-Encoding") == "" &&
+		!resp.Uncompressed
+	validator := httpValidator(resp)
+
+	return func(_ context.Context, offset int64) (io.ReadCloser, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if ranges {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("Accept-Encoding", "identity")
+
+			// the whole body is sent instead of the range if it has changed
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+
+		r, err := httpDo(client, req)
+		if err != nil {
+			return nil, err
+		}
+
+		if validator != "" && httpValidator(r) != validator {
+			_ = r.Body.Close()
+			return nil, fmt.Errorf("GET %s failed: the body changed after %d bytes of it were read, so the download "+
+				"can't be resumed", uri, offset)
+		}
+
+		if r.StatusCode == http.StatusPartialContent {
+			logger().Info(fmt.Sprintf("Resuming the download of %s at offset %d", uri, offset), "input", uri,
+				"offset", offset)
+
+			return r.Body, nil
+		}
+
+		logger().Warn(fmt.Sprintf("%s can't be read from offset %d with a range request, reading it again from the "+
+			"start", uri, offset), "input", uri, "offset", offset)
+
+		_, err = io.CopyN(io.Discard, r.Body, offset)
+		if err == io.EOF {
+			err = fmt.Errorf("GET %s failed: the body is shorter than the %d bytes of it already read, so the "+
+				"download can't be resumed", uri, offset)
+		}
+
+		if err != nil {
+			_ = r.Body.Close()
+			return nil, err
+		}
+
+		return r.Body, nil
+	}
+}
+
+// httpValidator returns the ETag of the response, or its Last-Modified time when it has no strong ETag, which tell
+// whether the body of another response for the same URL is the same, or "" when it has neither
+func httpValidator(resp *http.Response) string {
+	etag := resp.Header.Get("ETag")
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	return resp.Header.Get("Last-Modified")
+}
+
+// httpStatusError is the error returned for a response with a non 2xx status
+type httpStatusError struct {
+	method string
+	url    string
+	proto  string
+	status string
+	code   int
+}
+
+func (hse *httpStatusError) Error() string {
+	return fmt.Sprintf("%s %s failed: %s %s", hse.method, hse.url, hse.proto, hse.status)
+}
+
+// httpDo sends the request returning the response. Responses with a non 2xx status are returned as errors.
+func httpDo(client *http.Client, req *http.Request) (*http.Response, error) {
+	r, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		_ = r.Body.Close()
+		return nil, &httpStatusError{method: req.Method, url: req.URL.String(), proto: r.Proto, status: r.Status,
+			code: r.StatusCode}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,235 @@
+package jsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	gzWr := gzip.NewWriter(buf)
+	_, err := gzWr.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gzWr.Close())
+
+	return buf.Bytes()
+}
+
+func TestAsyncReaderFromURL(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	compressed := gzipBytes(t, []byte(contents))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/plain.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(contents))
+	})
+	mux.HandleFunc("/suffix.json.gz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(compressed)
+	})
+	mux.HandleFunc("/encoded.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(compressed)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, path := range []string{"/plain.json", "/suffix.json.gz", "/encoded.json"} {
+		t.Run(path, func(t *testing.T) {
+			rd, err := AsyncReaderFromURL(srv.URL+path, 8)
+			require.NoError(t, err)
+
+			ctx := rd.Start(context.Background())
+			require.Equal(t, contents, string(readAll(t, ctx, rd)))
+		})
+	}
+
+	_, err := AsyncReaderFromURL(srv.URL+"/missing.json", 8)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "404 Not Found")
+}
+
+func TestAsyncReaderFromURLCancel(t *testing.T) {
+	aborted := make(chan struct{})
+
+	// the server sends a little data and then hangs until the client goes away
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"list": [`))
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer srv.Close()
+
+	rd, err := AsyncReaderFromURL(srv.URL, 1024)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = rd.Start(ctx)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	// the data sent before the server hung is read, after which the cancellation is seen
+	for err == nil {
+		_, err = rd.Read(ctx)
+	}
+
+	require.ErrorIs(t, err, context.Canceled)
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		require.Fail(t, "request was not aborted")
+	}
+}
+
+func TestAsyncReaderFromURLReadTimeout(t *testing.T) {
+	aborted := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"list": [`))
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer srv.Close()
+
+	rd, err := AsyncReaderFromURL(srv.URL, 1024, WithReadTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer rd.Close()
+
+	ctx := rd.Start(context.Background())
+
+	for err == nil {
+		_, err = rd.Read(ctx)
+	}
+
+	require.ErrorIs(t, err, ErrReadTimeout)
+
+	// the request is aborted rather than left hanging
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		require.Fail(t, "request was not aborted")
+	}
+}
+
+// droppingServer serves contents, dropping the connection half way through the body of the first response. Responses
+// are served with http.ServeContent, which answers range requests, when ranges is set, and the ETag of the body is
+// changed after the first response when changed is set.
+func droppingServer(t *testing.T, contents []byte, ranges, changed bool) (*httptest.Server, *[]string) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Header.Get("Range"))
+		first := len(requests) == 1
+		mu.Unlock()
+
+		etag := `"v1"`
+		if changed && !first {
+			etag = `"v2"`
+		}
+
+		w.Header().Set("ETag", etag)
+
+		if !first && ranges {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+			return
+		}
+
+		if ranges {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+
+		if !first {
+			_, _ = w.Write(contents)
+			return
+		}
+
+		_, _ = w.Write(contents[:len(contents)/2])
+		w.(http.Flusher).Flush()
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		_ = conn.Close()
+	}))
+
+	return srv, &requests
+}
+
+func TestAsyncReaderFromURLResume(t *testing.T) {
+	contents := []byte(`{"list": [` + strings.Repeat(`"item", `, 1000) + `"last"]}`)
+	retry := WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	// a dropped download is resumed with a range request for the rest of the body
+	srv, requests := droppingServer(t, contents, true, false)
+	defer srv.Close()
+
+	rd, err := AsyncReaderFromURL(srv.URL, 64, retry)
+	require.NoError(t, err)
+
+	require.Equal(t, string(contents), string(readAll(t, rd.Start(context.Background()), rd)))
+	require.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(contents)/2)}, *requests)
+
+	// or by reading the body again from its start when the server doesn't accept range requests
+	srv, requests = droppingServer(t, contents, false, false)
+	defer srv.Close()
+
+	rd, err = AsyncReaderFromURL(srv.URL, 64, retry)
+	require.NoError(t, err)
+
+	require.Equal(t, string(contents), string(readAll(t, rd.Start(context.Background()), rd)))
+	require.Equal(t, []string{"", ""}, *requests)
+
+	// a body which changed can't be resumed
+	for _, ranges := range []bool{true, false} {
+		srv, _ = droppingServer(t, contents, ranges, true)
+		defer srv.Close()
+
+		rd, err = AsyncReaderFromURL(srv.URL, 64, retry)
+		require.NoError(t, err)
+
+		ctx := rd.Start(context.Background())
+		for err == nil {
+			_, err = rd.Read(ctx)
+		}
+
+		require.EqualError(t, err, fmt.Sprintf("GET %s failed: the body changed after %d bytes of it were read, so "+
+			"the download can't be resumed at byte offset %d", srv.URL, len(contents)/2, len(contents)/2))
+	}
+
+	// the download fails once the retries run out
+	srv, _ = droppingServer(t, contents, true, false)
+	defer srv.Close()
+
+	rd, err = AsyncReaderFromURL(srv.URL, 64, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	for err == nil {
+		_, err = rd.Read(ctx)
+	}
+
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,54 @@
+package jsplit
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// prettyIndent is the indentation used for each level of nesting when Options.Pretty is set
+const prettyIndent = "  "
+
+// indentItems returns a ListAddFunc which indents every item before passing it to add when opts.Pretty is set, and add
+// itself otherwise. Items are indented before they are written so file rollover accounts for the indented size.
+func indentItems(add ListAddFunc, opts Options) ListAddFunc {
+	if !opts.Pretty {
+		return add
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	return func(item []byte) error {
+		buf.Reset()
+
+		err := json.Indent(buf, item, "", prettyIndent)
+		if err != nil {
+			return err
+		}
+
+		return add(buf.Bytes())
+	}
+}
+
+// transformItems returns the ListAddFunc which normalizes the numbers of, redacts, flattens, makes uniform, observes
+// the schema of and indents the items of the list with the given key as set in opts before passing them to add
+func transformItems(key string, add ListAddFunc, schema *ListSchema, opts Options) ListAddFunc {
+	add = redactItems(flattenItems(uniformItems(key, observeItems(indentItems(add, opts), schema), opts), opts), opts)
+
+	return normalizeNumberItems(add, opts)
+}
+
+// indentRoot indents the non-list values written to root.json when opts.Pretty is set
+func indentRoot(rootItems []byte, opts Options) ([]byte, error) {
+	if !opts.Pretty {
+		return rootItems, nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(rootItems)))
+
+	err := json.Indent(buf, rootItems, "", prettyIndent)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
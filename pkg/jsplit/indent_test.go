@@ -0,0 +1,62 @@
+package jsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStreamPretty(t *testing.T) {
+	var testStr = `{"key": "value", "object": {"a": [1, 2]}, "list": [{"idx": 0, "tags": ["a"]}, {"idx": 1}, 2]}`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{Pretty: true, Format: FormatNDJSON})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "root.json"), `{
+  "key": "value",
+  "object": {
+    "a": [
+      1,
+      2
+    ]
+  }
+}`)
+	requireContents(t, filepath.Join(tempDir, "list_00.ndjson"), `{
+  "idx": 0,
+  "tags": [
+    "a"
+  ]
+}
+{
+  "idx": 1
+}
+2
+`)
+}
+
+func TestSplitStreamPrettyMaxFileBytes(t *testing.T) {
+	var testStr = `{"list": [{"idx": 0}, {"idx": 1}, {"idx": 2}]}`
+
+	tempDir := t.TempDir()
+
+	// indented the items are 14 bytes each rather than 9, so every file is rolled over after its second item
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{Pretty: true, MaxFileBytes: 20})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\n  \"idx\": 0\n}\n{\n  \"idx\": 1\n}")
+	requireContents(t, filepath.Join(tempDir, "list_01.jsonl"), "{\n  \"idx\": 2\n}")
+
+	_, err = os.Stat(filepath.Join(tempDir, "list_02.jsonl"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	bs = NewTestByteStream([]byte(testStr), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Pretty: true, Format: FormatCSV})
+	require.ErrorContains(t, err, "pretty")
+}
@@ -0,0 +1,183 @@
+package jsplit
+
+import (
+	"context"
+	"io"
+)
+
+// json5State is where a json5Stream is within the text it is reading
+type json5State int
+
+const (
+	json5Value json5State = iota
+	json5String
+	json5Escaped
+	json5Slash
+	json5LineComment
+	json5BlockComment
+	json5BlockCommentStar
+)
+
+// json5Stream is a ByteStream reading json which may contain // and /* */ comments, and commas trailing the last
+// element of arrays and objects, returning it as plain json. Comments are removed, leaving the newline ending a line
+// comment and a space in place of a block comment, and trailing commas are dropped. Strings are passed through as they
+// are, so comment markers within them are kept.
+type json5Stream struct {
+	stream ByteStream
+	state  json5State
+
+	// held is a comma along with the whitespace which has followed it, which is only written once the next value shows
+	// that the comma isn't trailing
+	held []byte
+
+	eof bool
+}
+
+// newJSON5Stream returns a *json5Stream stripping comments and trailing commas from the json read from stream
+func newJSON5Stream(stream ByteStream) *json5Stream {
+	return &json5Stream{stream: stream}
+}
+
+// json5Input returns the stream a split parses, which strips comments and trailing commas when opts.JSON5 is set
+func json5Input(stream ByteStream, opts Options) ByteStream {
+	if !opts.JSON5 {
+		return stream
+	}
+
+	return newJSON5Stream(stream)
+}
+
+// Read returns the next chunk of plain json, reading more of the stream when everything read so far was stripped
+func (js *json5Stream) Read(ctx context.Context) ([]byte, error) {
+	for {
+		if js.eof {
+			return nil, io.EOF
+		}
+
+		buf, err := js.stream.Read(ctx)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		out := js.strip(buf)
+
+		if err == io.EOF {
+			js.eof = true
+			out = append(out, js.flush()...)
+		}
+
+		if len(out) > 0 {
+			return out, nil
+		}
+	}
+}
+
+// flush returns whatever has been held back once the stream has ended
+func (js *json5Stream) flush() []byte {
+	out := js.held
+	js.held = nil
+
+	if js.state == json5Slash {
+		out = append(out, '/')
+	}
+
+	return out
+}
+
+// emit appends ch to out, or to the bytes held after a comma when it is whitespace which may precede a closing bracket
+func (js *json5Stream) emit(out []byte, ch byte) []byte {
+	if js.held == nil {
+		return append(out, ch)
+	}
+
+	if isWhitespace[ch] {
+		js.held = append(js.held, ch)
+		return out
+	}
+
+	// a closing bracket makes the held comma a trailing one, which is dropped
+	if ch == CloseSB || ch == CloseCB {
+		out = append(out, js.held[1:]...)
+	} else {
+		out = append(out, js.held...)
+	}
+
+	js.held = nil
+
+	return append(out, ch)
+}
+
+// strip returns buf with comments and trailing commas removed, carrying the state over to the chunk which follows
+func (js *json5Stream) strip(buf []byte) []byte {
+	out := make([]byte, 0, len(buf)+len(js.held))
+
+	for _, ch := range buf {
+		switch js.state {
+		case json5String:
+			out = append(out, ch)
+
+			switch ch {
+			case Escape:
+				js.state = json5Escaped
+			case QM:
+				js.state = json5Value
+			}
+		case json5Escaped:
+			out = append(out, ch)
+			js.state = json5String
+		case json5Slash:
+			switch ch {
+			case '/':
+				js.state = json5LineComment
+			case '*':
+				js.state = json5BlockComment
+			default:
+				// not a comment, which the parser reports
+				js.state = json5Value
+				out = js.emit(out, '/')
+				out = js.value(out, ch)
+			}
+		case json5LineComment:
+			if ch == '\n' {
+				js.state = json5Value
+				out = js.emit(out, ch)
+			}
+		case json5BlockComment:
+			if ch == '*' {
+				js.state = json5BlockCommentStar
+			}
+		case json5BlockCommentStar:
+			switch ch {
+			case '/':
+				js.state = json5Value
+				out = js.emit(out, ' ')
+			case '*':
+			default:
+				js.state = json5BlockComment
+			}
+		default:
+			out = js.value(out, ch)
+		}
+	}
+
+	return out
+}
+
+// value handles a character read outside of strings and comments
+func (js *json5Stream) value(out []byte, ch byte) []byte {
+	switch ch {
+	case '/':
+		js.state = json5Slash
+		return out
+	case COMMA:
+		// a comma following a held one is invalid, and is passed on for the parser to report
+		out = append(out, js.held...)
+		js.held = append(js.held[:0], ch)
+
+		return out
+	case QM:
+		js.state = json5String
+	}
+
+	return js.emit(out, ch)
+}
@@ -0,0 +1,105 @@
+package jsplit
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSON5Stream(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected string
+	}{
+		"plain json": {
+			input:    `{"a": [1, 2], "b": "c"}`,
+			expected: `{"a": [1, 2], "b": "c"}`,
+		},
+		"line comments": {
+			input:    "{\n// leading\n\"a\": 1, // trailing\n\"b\": 2 //last}\n}",
+			expected: "{\n\n\"a\": 1, \n\"b\": 2 \n}",
+		},
+		"block comments": {
+			input:    `{/* one */"a": /* two ** */ 1 /***/}`,
+			expected: `{ "a":   1  }`,
+		},
+		"trailing commas": {
+			input:    "{\"a\": [1, 2, ], \"b\": {\"c\": 3,\n},\n}",
+			expected: "{\"a\": [1, 2 ], \"b\": {\"c\": 3\n}\n}",
+		},
+		"trailing comma before a comment": {
+			input:    "[1, // more to come\n/* or not */]",
+			expected: "[1 \n ]",
+		},
+		"comment markers in strings": {
+			input:    `{"url": "http://example.com/*x*/", "s": "a // b, ]", "q": "\"//\", "e": "\\"}`,
+			expected: `{"url": "http://example.com/*x*/", "s": "a // b, ]", "q": "\"//\", "e": "\\"}`,
+		},
+		"commas which aren't trailing": {
+			input:    `[1,,2, "a"]`,
+			expected: `[1,,2, "a"]`,
+		},
+		"division": {
+			input:    `[1/2]`,
+			expected: `[1/2]`,
+		},
+		"slash at the end": {
+			input:    `[1]/`,
+			expected: `[1]/`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// reading a byte at a time checks the state carried between chunks
+			for _, readSize := range []int{1, 3, len(test.input)} {
+				js := newJSON5Stream(NewTestByteStream([]byte(test.input), readSize))
+
+				var read []byte
+
+				for {
+					buf, err := js.Read(context.Background())
+					if err == io.EOF {
+						break
+					}
+
+					require.NoError(t, err)
+					require.NotEmpty(t, buf)
+
+					read = append(read, buf...)
+				}
+
+				require.Equal(t, test.expected, string(read), "read size %d", readSize)
+			}
+		})
+	}
+}
+
+func TestSplitStreamJSON5(t *testing.T) {
+	const doc = `{
+	// exported by the config tool
+	"name": "settings /* not a comment */",
+	"hosts": [
+		{"host": "a.example.com", "path": "//share"}, /* primary */
+		{"host": "b.example.com", "path": "/*"},
+	],
+	"empty": [
+		// nothing yet
+	],
+}`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 5)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{JSON5: true}))
+
+	requireContents(t, filepath.Join(tempDir, "hosts_00.jsonl"),
+		"{\"host\":\"a.example.com\",\"path\":\"//share\"}\n{\"host\":\"b.example.com\",\"path\":\"/*\"}")
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"name\":\"settings /* not a comment */\"\n}")
+
+	// strict parsing stays the default
+	bs = NewTestByteStream([]byte(doc), 5)
+	require.Error(t, SplitStream(context.Background(), bs, t.TempDir(), Options{}))
+}
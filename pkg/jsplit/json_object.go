@@ -0,0 +1,92 @@
+package jsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// objectField is a single field of a json object. Value holds the field's json encoded value.
+type objectField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// decodeObject decodes a json object into its fields, keeping the order they appear in. Values are left encoded so
+// numbers keep their original representation.
+func decodeObject(item []byte) ([]objectField, error) {
+	dec := json.NewDecoder(bytes.NewReader(item))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("expected a json object, found %s", abbreviate(item))
+	}
+
+	var fields []objectField
+
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := tok.(string)
+		if !ok {
+			return nil, errors.New("expected a json object key")
+		}
+
+		var val json.RawMessage
+
+		err = dec.Decode(&val)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, objectField{Key: key, Value: val})
+	}
+
+	// consume the closing brace so truncated objects are reported
+	_, err = dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// encodeObject encodes the fields as a compact json object, in their order
+func encodeObject(fields []objectField) []byte {
+	buf := []byte{OpenCB}
+
+	for i, field := range fields {
+		if i > 0 {
+			buf = append(buf, COMMA)
+		}
+
+		// marshalling a string can't fail
+		key, _ := json.Marshal(field.Key)
+
+		buf = append(buf, key...)
+		buf = append(buf, COLON)
+		buf = append(buf, field.Value...)
+	}
+
+	return append(buf, CloseCB)
+}
+
+// abbreviate returns the start of a json item for use in error messages
+func abbreviate(item []byte) string {
+	const maxLen = 32
+
+	if len(item) <= maxLen {
+		return string(item)
+	}
+
+	return string(item[:maxLen]) + "..."
+}
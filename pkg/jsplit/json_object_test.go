@@ -0,0 +1,28 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeObject(t *testing.T) {
+	fields, err := decodeObject([]byte(`{"b":1.50,"a":{"c":[1,2]},"s":"str","n":null}`))
+	require.NoError(t, err)
+	require.Equal(t, []objectField{
+		{Key: "b", Value: json.RawMessage(`1.50`)},
+		{Key: "a", Value: json.RawMessage(`{"c":[1,2]}`)},
+		{Key: "s", Value: json.RawMessage(`"str"`)},
+		{Key: "n", Value: json.RawMessage(`null`)},
+	}, fields)
+
+	fields, err = decodeObject([]byte(`{}`))
+	require.NoError(t, err)
+	require.Empty(t, fields)
+
+	for _, invalid := range []string{`[1,2]`, `"string"`, `{"a":1`, `{"a":}`} {
+		_, err = decodeObject([]byte(invalid))
+		require.Error(t, err, invalid)
+	}
+}
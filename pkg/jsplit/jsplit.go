@@ -1,12 +1,13 @@
 package jsplit
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/danielchalef/jsplit/pkg/cloud"
@@ -68,7 +69,7 @@ func IsNext(itr *BufferedByteStreamIter, expected byte) error {
 
 	ch := itr.Next()
 	if ch != expected {
-		return fmt.Errorf("expected '%v' found '%v'", rune(ch), rune(expected))
+		return itr.errorf("expected %q found %q", rune(expected), rune(ch))
 	}
 
 	return nil
@@ -81,7 +82,7 @@ func ParseUntil(itr *BufferedByteStreamIter, findCh byte) ([]byte, error) {
 	for {
 		ch := itr.Next()
 		if ch == 0 {
-			return nil, fmt.Errorf("unexpected eof found while looking for '%v'", string(ch))
+			return nil, itr.errorf("unexpected eof found while looking for %q", rune(findCh))
 		} else if ch == findCh && prev != Escape {
 			return itr.Value(), nil
 		}
@@ -110,11 +111,15 @@ func ParseKey(itr *BufferedByteStreamIter) ([]byte, error) {
 	return key, nil
 }
 
-var parseObjBuffer = make([]byte, 128*1024)
+// ErrTooDeep is returned when objects and arrays are nested deeper within a value than Options.MaxDepth allows
+var ErrTooDeep = errors.New("json nested too deeply")
 
-// ParseObject parses a json struct or list. It is non-reentrant and not thread safe due to it's use of parseObjBuffer.
-// Could swap for a sync pool to make it so.  Also a reference to the returned data should not be stored as the data may
-// change when ParseObject is called again
+// ErrRecordTooLarge is returned when a list item or other value is larger than Options.MaxRecordBytes allows
+var ErrRecordTooLarge = errors.New("json value too large")
+
+// ParseObject parses a json struct or list. The value is held in a buffer belonging to the iterator, so documents can be
+// parsed concurrently by iterators of their own, but a reference to the returned data should not be stored as the data
+// may change when ParseObject is called again with the same iterator
 func ParseObject(itr *BufferedByteStreamIter) ([]byte, error) {
 	SkipWhitespace(itr)
 
@@ -131,18 +136,45 @@ func ParseObject(itr *BufferedByteStreamIter) ([]byte, error) {
 	case OpenSB:
 		closeCh = CloseSB
 	default:
-		return nil, fmt.Errorf("unexpected char '%v' found while looking for '{'", string(ch))
+		return nil, itr.errorf("unexpected char '%v' found while looking for '{'", string(ch))
+	}
+
+	if itr.objBuf == nil {
+		itr.objBuf = make([]byte, 0, 128*1024)
 	}
 
-	parseObjBuffer = parseObjBuffer[:1]
-	parseObjBuffer[0] = ch
+	parseObjBuffer := append(itr.objBuf[:0], ch)
+
+	// the buffer is kept as it grows, to be reused by the next call
+	defer func() {
+		itr.objBuf = parseObjBuffer[:0]
+	}()
 
 	openStack := NewByteStack()
 
+	maxDepth := itr.maxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
 	for {
+		// the rest of a value which is too large is skipped without being held, as the rest of one too deep is
+		if itr.maxRecordBytes > 0 && len(parseObjBuffer) > itr.maxRecordBytes {
+			err := itr.errorf("%w, more than %d bytes", ErrRecordTooLarge, itr.maxRecordBytes)
+
+			depth := openStack.Len() + 1
+			if lastOpen == QM {
+				depth--
+			}
+
+			skipValue(itr, depth, lastOpen == QM, lastOpen == QM && prev == Escape)
+
+			return nil, err
+		}
+
 		ch := itr.Next()
 		if ch == 0 {
-			return nil, errors.New("unexpected EOF found while parsing object")
+			return nil, itr.errorf("unexpected EOF found while parsing object")
 		}
 
 		if isWhitespace[ch] {
@@ -196,7 +228,17 @@ func ParseObject(itr *BufferedByteStreamIter) ([]byte, error) {
 			}
 
 		default:
-			return nil, fmt.Errorf("unknown opening character '%v'", string(ch))
+			return nil, itr.errorf("unknown opening character '%v'", string(ch))
+		}
+
+		// a bracket which was just pushed is nested within those below it on the stack and the value's own, as strings
+		// are only on the stack until they end. The rest of the value is skipped, so that a list whose items can be
+		// skipped carries on from the next item.
+		if lastOpen == ch && ch != QM && openStack.Len() >= maxDepth {
+			err := itr.errorf("%w, more than %d objects and arrays deep", ErrTooDeep, maxDepth)
+			skipNested(itr, openStack.Len()+1)
+
+			return nil, err
 		}
 
 		prev = ch
@@ -214,15 +256,21 @@ const (
 
 // ParseVal parses a json value
 func ParseVal(itr *BufferedByteStreamIter, addFn ListAddFunc, parentType ParentType) (bool, []byte, error) {
+	return parseVal(itr, addFn, nil, parentType)
+}
+
+// parseVal parses a json value. If it is a list at the root of the document onErr handles items which can't be parsed,
+// see parseList.
+func parseVal(itr *BufferedByteStreamIter, addFn ListAddFunc, onErr listErrorFunc, parentType ParentType) (bool, []byte, error) {
 	SkipWhitespace(itr)
 
 	ch := itr.Next()
 	switch ch {
 	case 0:
-		return false, nil, errors.New("reached EOF while parsing value")
+		return false, nil, itr.errorf("reached EOF while parsing value")
 
 	case QM:
-		val, err := ParseUntil(itr, QM)
+		val, err := parseString(itr)
 		return false, val, err
 
 	case OpenSB:
@@ -230,7 +278,7 @@ func ParseVal(itr *BufferedByteStreamIter, addFn ListAddFunc, parentType ParentT
 		itr.Skip()
 
 		if parentType == None {
-			return true, nil, ParseList(itr, addFn)
+			return true, nil, parseList(itr, addFn, onErr)
 		}
 
 		listObj, err := ParseObject(itr)
@@ -254,195 +302,1438 @@ func ParseVal(itr *BufferedByteStreamIter, addFn ListAddFunc, parentType ParentT
 			ch = itr.Next()
 			if ch == COMMA || ch == CloseSB || ch == CloseCB {
 				itr.Advance(-1)
-				return false, itr.Value(), nil
+
+				// whitespace between the value and the delimiter isn't part of the value
+				return false, bytes.TrimRight(itr.Value(), " \t\r\n"), nil
 			} else if ch == 0 {
-				return false, nil, errors.New("reached EOF while parsing value")
+				return false, nil, itr.errorf("reached EOF while parsing value")
 			}
 		}
 	}
 }
 
+// parseString parses the rest of a json string value after its opening quote. A string larger than the iterator's
+// maxRecordBytes fails with ErrRecordTooLarge once the limit is reached, after the rest of it is skipped.
+func parseString(itr *BufferedByteStreamIter) ([]byte, error) {
+	if itr.maxRecordBytes == 0 {
+		return ParseUntil(itr, QM)
+	}
+
+	var prev byte
+
+	for {
+		if itr.pos > itr.maxRecordBytes {
+			err := itr.errorf("%w, more than %d bytes", ErrRecordTooLarge, itr.maxRecordBytes)
+			skipValue(itr, 0, true, prev == Escape)
+
+			return nil, err
+		}
+
+		ch := itr.Next()
+		if ch == 0 {
+			return nil, itr.errorf("unexpected eof found while looking for %q", rune(QM))
+		} else if ch == QM && prev != Escape {
+			return itr.Value(), nil
+		}
+
+		prev = ch
+	}
+}
+
 // ParseList parses a json list calling addFn for each list item
 func ParseList(itr *BufferedByteStreamIter, addFn func(item []byte) error) error {
+	return parseList(itr, addFn, nil)
+}
+
+// listErrorFunc is called with the index of an item of a list which couldn't be parsed, or which isn't valid json, the
+// item if it could be read, and the error. Returning nil skips the item, and returning an error stops parsing.
+type listErrorFunc func(index int, item []byte, err error) error
+
+// parseList parses a json list calling addFn for each list item. When onErr is set items which aren't valid json are
+// passed to it rather than addFn, and after a list item can't be parsed onErr is called and parsing resumes with the
+// next item.
+func parseList(itr *BufferedByteStreamIter, addFn ListAddFunc, onErr listErrorFunc) error {
 	SkipWhitespace(itr)
 
 	ch := itr.Next()
 	if ch != OpenSB {
-		return fmt.Errorf("unexpected char '%v' found while looking for '['", string(ch))
+		return itr.errorf("unexpected char '%v' found while looking for '['", string(ch))
 	}
 
 	itr.Skip()
 
-	for {
+	return parseListItems(itr, addFn, onErr)
+}
+
+// resumeList continues parsing a json list part way through, from just after the item where a checkpoint was recorded
+func resumeList(itr *BufferedByteStreamIter, addFn ListAddFunc) error {
+	SkipWhitespace(itr)
+
+	ch := itr.Next()
+	switch ch {
+	case CloseSB:
+		itr.Skip()
+		return nil
+	case COMMA:
+		itr.Skip()
+		return parseListItems(itr, addFn, nil)
+	}
+
+	return itr.errorf("unexpected token %q found resuming a list. Expecting ','", rune(ch))
+}
+
+// parseListItems parses the items of a json list following its opening bracket, see parseList
+func parseListItems(itr *BufferedByteStreamIter, addFn ListAddFunc, onErr listErrorFunc) error {
+	var ch byte
+
+	for index := 0; ; index++ {
 		_, newVal, err := ParseVal(itr, nil, List)
 		if err != nil {
-			return err
+			if onErr == nil {
+				return err
+			}
+
+			closed, err := skipListItem(itr, index, err, onErr, 0)
+			if err != nil || closed {
+				return err
+			}
+
+			continue
 		}
 
 		if newVal != nil {
-			err = addFn(newVal)
-			if err != nil {
+			if onErr != nil && !json.Valid(newVal) {
+				err = onErr(index, newVal, invalidItemError(newVal))
+			} else {
+				err = addFn(newVal)
+				if err == nil {
+					itr.items++
+				}
+
+				var skippable *skippableError
+				if onErr != nil && errors.As(err, &skippable) {
+					err = onErr(index, newVal, skippable.err)
+				}
+			}
+
+			if errors.Is(err, errListLimit) {
+				return skipRestOfList(itr)
+			} else if err != nil {
 				return err
 			}
 		}
 
 		SkipWhitespace(itr)
 		ch = itr.Next()
+
+		if ch != CloseSB && ch != COMMA {
+			err = itr.errorf("unexpected token %q found. Expecting ','", rune(ch))
+			if onErr == nil {
+				return err
+			}
+
+			closed, err := skipListItem(itr, index, err, onErr, ch)
+			if err != nil || closed {
+				return err
+			}
+
+			continue
+		}
+
 		itr.Skip()
 
 		if ch == CloseSB {
 			return nil
-		} else if ch != COMMA {
-			return fmt.Errorf("unexpected token '%v' found. Expecting ','", rune(ch))
 		}
 	}
 }
 
-// SplitStream processes a json byte stream reading it and sending json lists in the root of the json document to jsonl
-// files sharded based on the size of the data written. Non-List root level objects are written to a file named root.json
-func SplitStream(ctx context.Context, rd ByteStream, dir string) error {
-	itr := NewBufferedStreamIter(ctx, rd)
+// invalidItemError returns the error decoding an item which isn't valid json
+func invalidItemError(item []byte) error {
+	var val json.RawMessage
 
-	SkipWhitespace(itr)
+	err := json.Unmarshal(item, &val)
+	if err == nil {
+		err = errors.New("invalid json")
+	}
 
-	ch := itr.Next()
-	if ch != '{' {
-		return fmt.Errorf("invalid format. only json objects are supported")
+	return fmt.Errorf("invalid list item %s: %w", abbreviate(item), err)
+}
+
+// skipListItem reports the error parsing a list item to onErr, then moves the iterator past the next comma which
+// separates list items, or the closing bracket of the list in which case closed is true. Commas and brackets within
+// strings or nested objects and arrays are skipped over, so parsing resumes at the start of the next item. last is the
+// character which couldn't be parsed if it has been read, or 0. The error is returned if the end of the input is
+// reached first.
+func skipListItem(itr *BufferedByteStreamIter, index int, parseErr error, onErr listErrorFunc, last byte) (bool, error) {
+	err := onErr(index, nil, parseErr)
+	if err != nil {
+		return false, err
 	}
 
-	itr.Skip()
+	switch skipToDelimiter(itr, last, true) {
+	case 0:
+		return false, parseErr
+	case CloseSB:
+		return true, nil
+	}
 
-	start := time.Now()
-	rootItems := make([]byte, 0, 128*1024)
-	rootItems = append(rootItems, []byte("{\n")...)
-	initialLen := len(rootItems)
+	return false, nil
+}
 
-	for {
-		key, err := ParseKey(itr)
-		if err != nil {
-			return err
-		}
+// skipRestOfList moves the iterator past the closing bracket of the list being parsed, without parsing the items
+// before it
+func skipRestOfList(itr *BufferedByteStreamIter) error {
+	if skipToDelimiter(itr, 0, false) == 0 {
+		return itr.errorf("reached EOF while skipping the rest of a list")
+	}
 
-		fileFactory := NewBufferedWriterFactory(dir, string(key[1:len(key)-1]), 256*1024)
-		wr := NewSplittingJsonlWriter(fileFactory.CreateWriter, 4*1024*1024*1024)
+	return nil
+}
 
-		_, val, err := ParseVal(itr, wr.Add, None)
-		if err != nil {
-			return err
-		}
+// skipToDelimiter moves the iterator past the closing bracket of the list being parsed, or past the next comma
+// separating its items when commas is set, returning the delimiter or 0 if the end of the input is reached first.
+// Commas and brackets within strings or nested objects and arrays are skipped over. last is the first character to
+// scan if it has already been read, or 0.
+func skipToDelimiter(itr *BufferedByteStreamIter, last byte, commas bool) byte {
+	var (
+		depth    int
+		inString bool
+		escaped  bool
+	)
 
-		err = wr.Close()
-		if err != nil {
-			return err
-		}
+	ch := last
 
-		if val != nil {
-			if len(rootItems) != initialLen {
-				rootItems = append(rootItems, []byte(",\n")...)
+	for {
+		if ch == 0 {
+			// the bytes scanned are dropped as they go, so skipping a long list doesn't buffer all of it
+			if itr.pos >= len(itr.buffer) {
+				itr.Skip()
 			}
 
-			rootItems = append(rootItems, '\t')
-			rootItems = append(rootItems, key...)
-			rootItems = append(rootItems, ':')
-			rootItems = append(rootItems, val...)
+			ch = itr.Next()
+			if ch == 0 {
+				return 0
+			}
 		}
 
-		SkipWhitespace(itr)
-
-		ch := itr.Next()
-		if ch == COMMA {
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case ch == Escape:
+				escaped = true
+			case ch == QM:
+				inString = false
+			}
+		case ch == QM:
+			inString = true
+		case ch == OpenCB || ch == OpenSB:
+			depth++
+		case (ch == CloseCB || ch == CloseSB) && depth > 0:
+			depth--
+		case depth == 0 && (ch == CloseSB || (commas && ch == COMMA)):
 			itr.Skip()
-		} else if ch == CloseCB {
-			break
+			return ch
 		}
+
+		ch = 0
 	}
+}
 
-	rootItems = append(rootItems, []byte("\n}")...)
+// skipNested moves the iterator past the brackets closing the objects and arrays it is within, which are depth deep,
+// without holding the bytes skipped. Brackets within strings are skipped over.
+func skipNested(itr *BufferedByteStreamIter, depth int) {
+	skipValue(itr, depth, false, false)
+}
 
-	var rootFile string
+// skipValue moves the iterator past the end of the value it is within, as skipNested does, when the iterator may be
+// within a string, and just after the escape of one of its characters when escaped is set. A depth of 0 skips the
+// rest of the string.
+func skipValue(itr *BufferedByteStreamIter, depth int, inString, escaped bool) {
+	for depth > 0 || inString {
+		if itr.pos >= len(itr.buffer) {
+			itr.Skip()
+		}
 
-	if cloud.IsCloudURI(dir) {
-		rootFile = strings.TrimSuffix(dir, "/") + "/root.json"
+		ch := itr.Next()
 
-		w, err := cloud.NewWriter(context.TODO(), rootFile)
-		if err != nil {
-			return err
+		switch {
+		case ch == 0:
+			return
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case ch == Escape:
+				escaped = true
+			case ch == QM:
+				inString = false
+			}
+		case ch == QM:
+			inString = true
+		case ch == OpenCB || ch == OpenSB:
+			depth++
+		case ch == CloseCB || ch == CloseSB:
+			depth--
 		}
+	}
 
-		_, err = w.Write(rootItems)
-		if err != nil {
-			return err
-		}
+	itr.Skip()
+}
 
-		err = w.Close()
-		if err != nil {
-			return err
-		}
-	} else {
-		rootFile = filepath.Join(dir, "root.json")
-		err := os.WriteFile(rootFile, rootItems, os.ModePerm)
-		if err != nil {
-			return err
-		}
+// Split reads the json document from reader, sending json lists in the root of the document to jsonl files sharded
+// based on the size of the data written, and writing the files to sink. Non-List root level objects are written to a
+// file named root.json. The elements of a document which is a json array are written as a single list, or distributed
+// across opts.Shards jsonl files when it is set, as are the values of a document which is a stream of json values,
+// such as an ndjson file. Split starts the reader, which the caller should close once it returns.
+func Split(ctx context.Context, reader *AsyncReader, sink OutputSink, opts Options) error {
+	err := opts.validate()
+	if err != nil {
+		return err
+	}
+
+	opts = opts.withMemoryBudget()
+	if reader.budget == nil {
+		reader.budget = opts.budget
 	}
 
-	fmt.Printf("%s written successfully\n", rootFile)
+	return splitStream(reader.Start(ctx), reader, sink, opts)
+}
 
-	elapsed := time.Since(start)
-	fmt.Printf("Completed in %f seconds", elapsed.Seconds())
+// SplitStream processes a json byte stream in the same way as Split, writing the files to dir, which can be a local
+// directory or a cloud storage URI. A local directory is created if it doesn't exist, unless opts.RequireOutputDir is
+// set.
+func SplitStream(ctx context.Context, rd ByteStream, dir string, opts Options) error {
+	existed := localDirExists(dir)
 
-	return nil
+	err := createOutputDir(dir, opts)
+	if err != nil {
+		return err
+	}
+
+	return removeFailedOutputDir(dir, existed, splitStream(ctx, rd, NewDirSink(ctx, dir), opts))
 }
 
-// SplitFile processes a json file reading it and sending json lists in the root of the json document to jsonl
-func Split(filename, outputPath string, overwrite bool) error {
-	var (
-		fi    os.FileInfo
-		err   error
-		rd    *AsyncReader
-		perms os.FileMode = 0o755
-	)
+// splitStream splits the json document read from rd, writing the files to sink
+func splitStream(ctx context.Context, rd ByteStream, sink OutputSink, opts Options) error {
+	return splitFrom(ctx, rd, sink, opts, nil, nil)
+}
 
-	// create output path if it doesn't exist
-	// or if it does exist, remove it if overwrite is true
-	if !cloud.IsCloudURI(outputPath) {
-		fi, err = os.Stat(outputPath)
+// splitFrom splits the json document read from rd in the same way as splitStream, recording checkpoints with cp when it
+// is set. When resume is set rd is read from the checkpoint's offset, and the split carries on from there.
+func splitFrom(ctx context.Context, rd ByteStream, sink OutputSink, opts Options, cp *checkpointer,
+	resume *checkpoint) error {
+	err := opts.validate()
+	if err != nil {
+		return err
+	}
 
-		switch {
-		// if we ecountered an error and it's not a "file does not exist" error, exit
-		case err != nil && !os.IsNotExist(err):
-			return err
-		// if the file exists and it's a directory, exit unless overwrite is true
-		case err == nil && fi.IsDir() && !overwrite:
-			return fmt.Errorf("error: %s already exists", outputPath)
-		// if the file exists and it's a directory, remove it if overwrite is true
-		case err == nil && fi.IsDir() && overwrite:
-			err = os.RemoveAll(outputPath)
-			if err != nil {
-				return err
-			}
-		}
+	if opts.Root != "" && follows(rd) {
+		return errors.New("a root pointer can't be used with a followed file, which is split as a stream of json values")
+	}
+
+	opts = opts.withMemoryBudget().withFileLimit()
+
+	// cancelling the context of the writers when splitting fails aborts any uploads to cloud storage which are still in
+	// progress, rather than leaving partial objects behind
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		err = os.MkdirAll(outputPath, perms)
+	sink = bindSink(ctx, sink, opts)
+
+	if opts.existingOutput() == ExistingOutputAppend {
+		opts.previous, err = readPreviousManifest(sink)
 		if err != nil {
 			return err
 		}
 	}
 
-	rd, err = AsyncReaderFromFile(filename, 1024*1024)
+	stats := newStatsReporter(rd, opts)
+	defer stats.close()
+
+	itr := NewBufferedStreamIter(ctx, json5Input(rd, opts))
+	itr.maxDepth = opts.maxDepth()
+	itr.maxRecordBytes = int(opts.MaxRecordBytes)
+
+	if cp != nil {
+		cp.itr = itr
+	}
+
+	start := time.Now()
+	failure := &firstError{cancel: cancel}
+
+	if resume != nil && resume.RootList {
+		return splitRootList(itr, sink, opts, stats, failure, start, cp, resume)
+	} else if resume != nil {
+		return splitObject(ctx, itr, sink, opts, stats, failure, start, cp, resume)
+	}
+
+	ch, err := seekRoot(itr, opts)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Reading %s\n", filename)
+	// a followed file has no end, so its values are split as they are written rather than as a single document
+	if follows(rd) {
+		itr.Advance(-1)
+
+		return splitValueStream(itr, sink, opts, stats, failure, start, cp)
+	}
+
+	if opts.InputStream == InputStreamValues {
+		itr.Advance(-1)
+
+		return splitValueStream(itr, sink, opts, stats, failure, start, cp)
+	}
+
+	if opts.Root == "" && ch == OpenCB && opts.InputStream != InputStreamDocuments {
+		itr.Advance(-1)
+
+		if isValueStream(itr) {
+			return splitValueStream(itr, sink, opts, stats, failure, start, cp)
+		}
+
+		itr.Next()
+	}
+
+	switch {
+	case ch == OpenSB:
+		itr.Advance(-1)
+		itr.Skip()
+
+		return splitRootList(itr, sink, opts, stats, failure, start, cp, nil)
+	case ch != OpenCB:
+		return itr.errorf("invalid format. only json objects and arrays are supported")
+	}
+
+	itr.Skip()
+
+	return splitObject(ctx, itr, sink, opts, stats, failure, start, cp, nil)
+}
+
+// splitValueStream writes the values of a document which is a stream of json values, such as an ndjson file, as if they
+// were the elements of a json array at the root of the document, so they are sharded or partitioned in the same way
+func splitValueStream(itr *BufferedByteStreamIter, sink OutputSink, opts Options, stats *statsReporter,
+	failure *firstError, start time.Time, cp *checkpointer) error {
+	// a checkpoint records the offset of a list item, and resuming carries on from the list's next comma
+	if cp != nil {
+		return errors.New("checkpoints can't be used with a stream of json values")
+	}
+
+	logger().Info("The document is a stream of json values, splitting them as the elements of a root list")
+
+	opts.valueStream = true
+
+	return splitRootList(itr, sink, opts, stats, failure, start, nil, nil)
+}
+
+// ErrEmptyInput is returned when the input is empty or holds nothing but whitespace, so there is no document to split.
+// Nothing is written to the output.
+var ErrEmptyInput = errors.New("input contained no JSON document")
+
+// emptyInputError returns the error for input which ended before the document started, naming opts.source when it is
+// set, or the error reading the input if that is why it ended
+func emptyInputError(itr *BufferedByteStreamIter, opts Options) error {
+	if itr.Err() != nil {
+		return itr.Err()
+	}
+
+	if opts.source != "" {
+		return fmt.Errorf("%w: %s", ErrEmptyInput, opts.source)
+	}
+
+	return ErrEmptyInput
+}
+
+// seekRoot moves the iterator to the value opts.Root points to, or to the start of the document when it isn't set,
+// returning the first character of the value. ErrEmptyInput is returned if there is no document.
+func seekRoot(itr *BufferedByteStreamIter, opts Options) (byte, error) {
+	SkipWhitespace(itr)
+
+	ch := itr.Next()
+	if ch == 0 {
+		return 0, emptyInputError(itr, opts)
+	}
+
+	if opts.Root == "" {
+		return ch, nil
+	}
+
+	if ch != OpenCB {
+		return 0, fmt.Errorf("root pointer %s doesn't resolve to an object, the document isn't an object", opts.Root)
+	}
+
+	itr.Skip()
+
+	// validate has already checked the pointer can be parsed
+	tokens, _ := parsePointer(opts.Root)
+
+	err := descend(itr, opts.Root, tokens)
+	if err != nil {
+		return 0, err
+	}
+
+	ch = itr.Next()
 
-	ctx := context.Background()
-	ctx = rd.Start(ctx)
+	if ch != OpenCB && ch != OpenSB {
+		return 0, fmt.Errorf("root pointer %s doesn't resolve to an object or array", opts.Root)
+	}
+
+	return ch, nil
+}
 
-	err = SplitStream(ctx, rd, outputPath)
+// splitObject writes the lists of the object being split, following its opening brace, to jsonl files and its other
+// values to root.json. When resume is set the object is instead parsed from the item of the list where the checkpoint
+// was recorded.
+func splitObject(ctx context.Context, itr *BufferedByteStreamIter, sink OutputSink, opts Options,
+	stats *statsReporter, failure *firstError, start time.Time, cp *checkpointer, resume *checkpoint) error {
+	err := opts.validateObject()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	var manifest Manifest
+
+	rootItems := make([]byte, 0, 128*1024)
+	rootItems = append(rootItems, []byte("{\n")...)
+	initialLen := len(rootItems)
+	rootRecords := int64(0)
+
+	var sem chan struct{}
+	if opts.Concurrency > 1 {
+		sem = make(chan struct{}, opts.Concurrency)
+	}
+
+	rejects := newRejectLog(sink, opts)
+	defer func() {
+		_ = rejects.close()
+	}()
+
+	var keys []*splitKey
+
+	// keys found more than once in the object carry on writing to the files of their first list
+	seen := make(map[string]*splitKey)
+	names := newFileNames(opts)
+	more := true
+
+	if resume != nil {
+		// the keys finished before the checkpoint are already complete
+		manifest.Keys = append(manifest.Keys, resume.Keys...)
+		rootItems = append(rootItems[:0], resume.Root...)
+		rootRecords = resume.RootRecords
+
+		sk, err := resumeKey(sink, resume, opts)
+		if err != nil {
+			return err
+		}
+
+		sk.kw = newKeyWriter(ctx, failure.set, sem, sk.add(opts), sk.finish, opts.budget)
+		keys = append(keys, sk)
+
+		err = resumeList(itr, sk.items(stats.count(sk.name, cp.track(sk, sk.kw.Add, rootItems, rootRecords))))
+		if err != nil {
+			return finishKeys(keys, failure, err)
+		}
+
+		err = sk.kw.close()
+		if err != nil {
+			return finishKeys(keys, failure, err)
+		}
+
+		cp.finished(sk)
+
+		more = !endOfObject(itr)
+	}
+
+	// the keys seen in the document being split, as a stream of documents may hold several
+	inDocument := make(map[string]bool)
+	check := newKeyCheck(opts)
+
+	for {
+		for more {
+			key, err := ParseKey(itr)
+			if tolerateTruncation(itr, err, opts) {
+				break
+			} else if err != nil {
+				return finishKeys(keys, failure, err)
+			}
+
+			key, err = opts.checkUTF8(key, "the key")
+			if err != nil {
+				return finishKeys(keys, failure, err)
+			}
+
+			// the key refers to the iterator's buffer which is reused while the value is parsed
+			key = append([]byte(nil), key...)
+			name := string(key[1 : len(key)-1])
+
+			err = check.add(name)
+			if err != nil {
+				return finishKeys(keys, failure, err)
+			}
+
+			// the values of keys which aren't being split are parsed past without writing anything
+			if !opts.splitsKey(name) {
+				_, _, err = parseVal(itr, discardItem, nil, None)
+				if tolerateTruncation(itr, err, opts) {
+					break
+				} else if err != nil {
+					return finishKeys(keys, failure, err)
+				}
+
+				if endOfObject(itr) {
+					break
+				}
+
+				continue
+			}
+
+			sk, dup := seen[name]
+			if dup {
+				// the keys of the documents which follow the first in a stream of them are expected to be the same
+				if inDocument[name] {
+					warnDuplicateKey(itr, name)
+				}
+
+				// the key's first list has to be finished before its files can be written to again
+				err = sk.kw.wait()
+				if err == nil {
+					err = sk.reopen()
+				}
+
+				if err != nil {
+					return finishKeys(keys, failure, err)
+				}
+
+				cp.reopened(sk)
+			} else {
+				fileName, err := names.name(name)
+				if err != nil {
+					return finishKeys(keys, failure, err)
+				}
+
+				sk = newSplitKey(sink, name, fileName, opts)
+				seen[name] = sk
+				keys = append(keys, sk)
+
+				err = sk.appendTo(opts.previousKey(name))
+				if err != nil {
+					return finishKeys(keys, failure, err)
+				}
+			}
+
+			inDocument[name] = true
+
+			sk.kw = newKeyWriter(ctx, failure.set, sem, sk.add(opts), sk.finish, opts.budget)
+
+			add, onErr := annotateItems(sk.name, sk.items(stats.count(sk.name, cp.track(sk, sk.kw.Add, rootItems, rootRecords))),
+				rejects.handler(sk.name, itr), opts)
+
+			isList, val, err := parseVal(itr, add, onErr, None)
+
+			// the items of a list cut short by the end of the input are written, and nothing follows them
+			truncated := tolerateTruncation(itr, err, opts)
+			if err != nil && !truncated {
+				return finishKeys(keys, failure, err)
+			}
+
+			// set before close, so it is seen by the goroutine writing the key when it finishes
+			sk.isList = sk.isList || isList
+
+			err = sk.kw.close()
+			if err != nil {
+				return finishKeys(keys, failure, err)
+			}
+
+			cp.finished(sk)
+
+			if truncated {
+				break
+			}
+
+			if val != nil {
+				val, err = opts.checkUTF8(val, "the value of key "+name)
+				if err != nil {
+					return finishKeys(keys, failure, err)
+				}
+
+				if opts.ValueFiles {
+					fileName, err := names.name(name)
+					if err == nil {
+						err = writeValue(sink, name, fileName, val, &manifest, opts)
+					}
+
+					if err != nil {
+						return finishKeys(keys, failure, err)
+					}
+
+					if endOfObject(itr) {
+						break
+					}
+
+					continue
+				}
+
+				if len(rootItems) != initialLen {
+					rootItems = append(rootItems, []byte(",\n")...)
+				}
+
+				rootItems = append(rootItems, '\t')
+				rootItems = append(rootItems, key...)
+				rootItems = append(rootItems, ':')
+				rootItems = append(rootItems, val...)
+				rootRecords++
+			}
+
+			if endOfObject(itr) {
+				break
+			}
+		}
+
+		err := check.end()
+		if err != nil {
+			return finishKeys(keys, failure, err)
+		}
+
+		// the lists of the documents which follow are appended to the files of the same keys
+		more, err = nextDocument(itr, opts)
+		if err != nil {
+			return finishKeys(keys, failure, err)
+		}
+
+		if !more {
+			break
+		}
+
+		inDocument = make(map[string]bool)
+	}
+
+	err = finishKeys(keys, failure, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, sk := range keys {
+		sk.addToManifest(&manifest, rejects)
+	}
+
+	names.addToManifest(&manifest)
+	manifest.addPrevious(opts.previous)
+
+	err = rejects.close()
+	if err != nil {
+		return err
+	}
+
+	manifest.Rejects = rejects.file()
+
+	if !opts.ValueFiles {
+		err = writeRoot(sink, append(rootItems, []byte("\n}")...), rootRecords, &manifest, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeManifest(sink, &manifest, opts)
+	if err != nil {
+		return err
+	}
+
+	err = cp.remove()
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start).Seconds()
+	logger().Info(fmt.Sprintf("Completed in %f seconds", elapsed), "seconds", elapsed)
+
+	return nil
+}
+
+// writeRoot writes root.json, holding the values of the object being split which aren't lists, and records it in the
+// manifest
+func writeRoot(sink OutputSink, rootItems []byte, rootRecords int64, manifest *Manifest, opts Options) error {
+	rootItems, err := indentRoot(normalizeRoot(rootItems, opts), opts)
+	if err != nil {
+		return err
+	}
+
+	rootName := "root.json" + compressedExt(opts.CompressOutput)
+
+	rootItems, err = appendRoot(sink, rootItems, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.previous != nil && opts.previous.Root != nil {
+		rootRecords += opts.previous.Root.Records
+	}
+
+	if opts.CompressOutput {
+		rootItems, err = gzipData(rootItems, opts.gzipLevel())
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.DryRun {
+		rootFile, err := replaceFile(sink, rootName, rootItems, opts)
+		if err != nil {
+			return err
+		}
+
+		logger().Info(rootFile+" written successfully", "file", rootFile)
+	}
+
+	manifest.Root = &ManifestFile{Name: rootName, Records: rootRecords, Bytes: int64(len(rootItems))}
+	if opts.Checksums {
+		manifest.Root.SHA256 = checksum(rootItems)
+	}
+
+	return nil
+}
+
+// writeValue writes a value of the object being split which isn't a list to [fileName].json when opts.ValueFiles is
+// set, and records it in the manifest
+func writeValue(sink OutputSink, key, fileName string, val []byte, manifest *Manifest, opts Options) error {
+	name := fileName + ".json" + compressedExt(opts.CompressOutput)
+	if name == ManifestFilename {
+		return fmt.Errorf("the value of key %s can't be written to %s, the name of the manifest", key, name)
+	}
+
+	val, err := indentRoot(normalizeRoot(val, opts), opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.CompressOutput {
+		val, err = gzipData(val, opts.gzipLevel())
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.DryRun {
+		valueFile, err := writeFile(sink, name, val)
+		if err != nil {
+			return err
+		}
+
+		logger().Info(valueFile+" written successfully", "file", valueFile)
+	}
+
+	file := ManifestFile{Name: name, Records: 1, Bytes: int64(len(val))}
+	if opts.Checksums {
+		file.SHA256 = checksum(val)
+	}
+
+	manifest.addValue(key, file)
+
+	return nil
+}
+
+// discardItem is the ListAddFunc for the lists of keys which aren't being split
+func discardItem([]byte) error {
+	return nil
+}
+
+// endOfObject moves past the comma following a value of the object being split, returning true if the value was the
+// last in the object
+func endOfObject(itr *BufferedByteStreamIter) bool {
+	SkipWhitespace(itr)
+
+	ch := itr.Next()
+	if ch == COMMA {
+		itr.Skip()
+	}
+
+	return ch == CloseCB
+}
+
+// nextDocument reports whether the object just split is followed by another with keys, as in a stream of json
+// documents written back to back by a producer which flushes each as it completes, leaving the iterator at its first
+// key. Empty objects are skipped over. Anything but whitespace after the last document is an error. Nothing follows
+// an object found with opts.Root, which is within the document.
+func nextDocument(itr *BufferedByteStreamIter, opts Options) (bool, error) {
+	if opts.Root != "" {
+		return false, nil
+	}
+
+	for {
+		SkipWhitespace(itr)
+
+		ch := itr.Next()
+		switch ch {
+		case 0:
+			return false, itr.Err()
+		case OpenCB:
+		default:
+			return false, itr.errorf("unexpected %q after the end of the document, only objects can follow it",
+				string([]byte{ch}))
+		}
+
+		itr.Skip()
+		SkipWhitespace(itr)
+
+		if itr.Next() != CloseCB {
+			itr.Advance(-1)
+			return true, nil
+		}
+
+		itr.Skip()
+	}
+}
+
+// splitKey is a single key in the root of the document being split
+type splitKey struct {
+	sink       OutputSink
+	name       string
+	factory    *BufferedWriterFactory
+	wr         *SplittingJsonlWriter
+	schema     *ListSchema
+	schemaFile schemaFiles
+	opts       Options
+	isList     bool
+	kw         *keyWriter
+
+	// duplicates is the number of items of the list dropped because of Options.DedupField
+	duplicates int64
+
+	// filtered counts the items of the list which matched Options.Filter and those which were dropped
+	filtered filterCounts
+
+	// sorter holds the items of the list until it ends when Options.SortBy is set
+	sorter *itemSorter
+
+	// lastOpen is set when the last file hadn't reached the size limit when the key was finished, along with the
+	// number of bytes of items written to it, so that it can be appended to if the key appears again
+	lastOpen     bool
+	writtenBytes uint64
+
+	// started is when the first item of the list being written was, and writing the time spent writing the lists of
+	// the key finished so far, see Options.KeyWriteTime
+	started time.Time
+	writing time.Duration
+}
+
+// newSplitKey returns a *splitKey writing the items of the key's list to files in sink, named after fileName, in the
+// format set for the key and in the directory it is routed to
+func newSplitKey(sink OutputSink, name, fileName string, opts Options) *splitKey {
+	opts = opts.forKey(name)
+	factory := newWriterFactory(sink, fileName, 256*1024, opts)
+	factory.dir = opts.keyDir(name)
+
+	sk := &splitKey{
+		sink:    sink,
+		name:    name,
+		factory: factory,
+		wr:      newListWriter(factory, opts),
+		opts:    opts,
+	}
+
+	sk.schema = newListSchema(name, opts)
+	if sk.schema != nil {
+		sk.schema.name = fileName
+	}
+
+	return sk
+}
+
+// items returns the ListAddFunc through which the parsed items of the list are passed to add, checking their UTF-8,
+// dropping those which don't match Options.Filter and duplicates, and stopping at Options.Limit
+func (sk *splitKey) items(add ListAddFunc) ListAddFunc {
+	add = filterItems(dedupItems(limitItems(add, sk.opts), sk.opts, &sk.duplicates), sk.opts, &sk.filtered)
+
+	return utf8Items(transformRecords(sk.name, add, sk.opts, &sk.filtered), sk.opts)
+}
+
+// add returns the ListAddFunc which transforms and writes the items of the list, once they have been sorted when
+// Options.SortBy is set
+func (sk *splitKey) add(opts Options) ListAddFunc {
+	add := transformItems(sk.name, sk.wr.Add, sk.schema, opts)
+	if opts.SortBy != "" {
+		sk.sorter = newItemSorter(add, opts)
+		add = sk.sorter.Add
+	}
+
+	return func(item []byte) error {
+		if sk.started.IsZero() {
+			sk.started = time.Now()
+		}
+
+		return add(item)
+	}
+}
+
+// finish writes the sorted items of the list, closes the last file written for the key and writes the schema of its
+// list
+func (sk *splitKey) finish() error {
+	defer sk.stopClock()
+
+	if sk.sorter != nil {
+		err := sk.sorter.flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	if sk.isList && sk.opts.format() == FormatNDJSON {
+		err := sk.wr.ensureFile()
+		if err != nil {
+			return err
+		}
+	}
+
+	sk.lastOpen = sk.wr.wr != nil
+	sk.writtenBytes = sk.wr.writtenBytes
+
+	err := sk.wr.Close()
+	if err != nil {
+		return err
+	}
+
+	// the schema is written again, describing the items of every list, if the key appears again
+	if sk.isList && sk.schema != nil {
+		sk.schemaFile, err = sk.schema.write(sk.sink)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reopen prepares the key to be written to again when it appears more than once in the document, once its previous
+// list has been finished. The items of the next list are appended to the last file where it can be, as when
+// partitions are reopened, and otherwise written to the next file. Limit and DedupField apply to each list on its own.
+func (sk *splitKey) reopen() error {
+	if !sk.lastOpen {
+		return nil
+	}
+
+	sk.lastOpen = false
+
+	// a second header would be written into the middle of a csv file, or footer into a parquet file, the digest of a
+	// file would only cover what was appended, and an atomic file has already been given its name
+	if sk.opts.format().spilled() || sk.opts.Checksums || sk.opts.AtomicFiles {
+		return nil
+	}
+
+	if !sk.factory.canAppend() {
+		return nil
+	}
+
+	wr, err := sk.factory.appendLast()
+	if err != nil {
+		return err
+	}
+
+	sk.wr.resume(wr, sk.wr.Records(), sk.writtenBytes)
+
+	return nil
+}
+
+// warnDuplicateKey warns that a key appears more than once in the object being split, reporting it as a problem
+func warnDuplicateKey(itr *BufferedByteStreamIter, name string) {
+	msg := name + " appears more than once in the document, the items of its lists are written to the same files"
+
+	logger().Warn(msg, "key", name)
+	reportProblem(itr.ctx, itr.Offset(), errors.New(msg))
+}
+
+// abandon closes the file being written for the key after the split has failed, flushing the items written to it so
+// far. The split's context has been cancelled by then, so uploads to cloud storage are aborted rather than completed.
+func (sk *splitKey) abandon() {
+	if sk.sorter != nil {
+		sk.sorter.close()
+	}
+
+	_ = sk.wr.Close()
+}
+
+// stopClock adds the time spent writing the list which has just been finished to the time spent writing the key
+func (sk *splitKey) stopClock() {
+	if !sk.started.IsZero() {
+		sk.writing += time.Since(sk.started)
+		sk.started = time.Time{}
+	}
+}
+
+// addToManifest adds the files written for the key, and the number of its items which were rejected, dropped as
+// duplicates or filtered out, to the manifest once it has been finished, reporting the time spent writing it
+func (sk *splitKey) addToManifest(m *Manifest, rejects *rejectLog) {
+	if sk.wr.Files() > 0 {
+		logger().Info(fmt.Sprintf("%s written to %d files", sk.name, sk.wr.Files()), "key", sk.name, "files", sk.wr.Files())
+	}
+
+	reportDuplicates(sk.name, sk.duplicates)
+	reportFiltered(sk.name, sk.opts, sk.filtered)
+
+	mk := m.addKey(sk.name, sk.factory, sk.wr)
+	if mk != nil {
+		sk.schemaFile.setIn(mk)
+		mk.Rejected = rejects.rejected(sk.name)
+		mk.Duplicates = sk.duplicates
+		mk.Filtered = sk.filtered.total()
+
+		if sk.opts.KeyWriteTime != nil {
+			sk.opts.KeyWriteTime(sk.name, sk.writing)
+		}
+	}
+}
+
+// finishKeys waits for every key to be written, returning the first error which stopped the split. err is an error
+// from parsing, in which case keys still being written are stopped. When the split has failed, the files of keys which
+// weren't finished are closed so that the items written before the failure are flushed.
+func finishKeys(keys []*splitKey, failure *firstError, err error) error {
+	if err != nil {
+		failure.set(err)
+
+		for _, sk := range keys {
+			sk.kw.stop()
+		}
+	}
+
+	for _, sk := range keys {
+		_ = sk.kw.wait()
+	}
+
+	err = failure.get()
+	if err != nil {
+		for _, sk := range keys {
+			sk.abandon()
+		}
+	}
+
+	return err
+}
+
+// gzipData returns the data gzip compressed at the given level
+func gzipData(data []byte, level int) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+
+	gzWr, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = gzWr.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = gzWr.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// splitRootList writes the elements of the json array at the root of the document. They are distributed across
+// opts.Shards jsonl files when it is set, partitioned by their value of opts.PartitionBy or opts.FilenameField or their
+// date in opts.PartitionByDate when one of those is set, and otherwise written in order to size bounded files named
+// after opts.RootListKey.
+func splitRootList(itr *BufferedByteStreamIter, sink OutputSink, opts Options, stats *statsReporter,
+	failure *firstError, start time.Time, cp *checkpointer, resume *checkpoint) error {
+	var (
+		manifest Manifest
+		err      error
+	)
+
+	err = newKeyCheck(opts).list()
+	if err != nil {
+		return err
+	}
+
+	// the elements are the only list, written in the format set for the root list key
+	opts = opts.forKey(opts.rootListKey())
+
+	rejects := newRejectLog(sink, opts)
+	defer func() {
+		_ = rejects.close()
+	}()
+
+	if opts.Shards > 0 {
+		err = shardRootList(itr, NewShardingJsonlWriter(sink, 256*1024, opts), sink, opts, &manifest, rejects, stats,
+			failure)
+	} else if opts.partitioned() {
+		err = shardRootList(itr, NewPartitioningJsonlWriter(sink, 256*1024, opts), sink, opts, &manifest, rejects,
+			stats, failure)
+	} else {
+		err = writeRootList(itr, sink, opts, &manifest, rejects, stats, failure, cp, resume)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = rejects.close()
+	if err != nil {
+		return err
+	}
+
+	manifest.Rejects = rejects.file()
+
+	err = writeManifest(sink, &manifest, opts)
+	if err != nil {
+		return err
+	}
+
+	err = cp.remove()
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start).Seconds()
+	logger().Info(fmt.Sprintf("Completed in %f seconds", elapsed), "seconds", elapsed)
+
+	return nil
+}
+
+// rootListWriter distributes the elements of the json array at the root of the document across several series of
+// files, as ShardingJsonlWriter and PartitioningJsonlWriter do
+type rootListWriter interface {
+	Add(item []byte) error
+	Close() error
+	abandon()
+	report()
+	addToManifest(m *Manifest, schemas schemaFiles)
+}
+
+// shardRootList distributes the elements of the json array at the root of the document across the series of files
+// written by wr, by shard or by partition
+func shardRootList(itr *BufferedByteStreamIter, wr rootListWriter, sink OutputSink, opts Options, manifest *Manifest,
+	rejects *rejectLog, stats *statsReporter, failure *firstError) error {
+	schema := newListSchema("root", opts)
+
+	var (
+		duplicates int64
+		filtered   filterCounts
+	)
+
+	add := transformItems(opts.rootListKey(), wr.Add, schema, opts)
+	add = dedupItems(limitItems(stats.count(DefaultRootListKey, add), opts), opts, &duplicates)
+	add = utf8Items(transformRecords(DefaultRootListKey, filterItems(add, opts, &filtered), opts, &filtered), opts)
+
+	add, onErr := annotateItems(DefaultRootListKey, add, rejects.handler(DefaultRootListKey, itr), opts)
+
+	err := parseRootList(itr, add, onErr, opts)
+	if err != nil {
+		failure.set(err)
+		wr.abandon()
+
+		return err
+	}
+
+	err = wr.Close()
+	if err != nil {
+		return err
+	}
+
+	wr.report()
+	reportDuplicates(DefaultRootListKey, duplicates)
+	reportFiltered(DefaultRootListKey, opts, filtered)
+
+	schemaFile, err := schema.write(sink)
+	if err != nil {
+		return err
+	}
+
+	wr.addToManifest(manifest, schemaFile)
+
+	return nil
+}
+
+// writeRootList writes the elements of the json array at the root of the document to files named after
+// opts.RootListKey, as if they were a list with that key. When resume is set the elements are parsed from the one where
+// the checkpoint was recorded.
+func writeRootList(itr *BufferedByteStreamIter, sink OutputSink, opts Options, manifest *Manifest,
+	rejects *rejectLog, stats *statsReporter, failure *firstError, cp *checkpointer, resume *checkpoint) error {
+	var (
+		sk  *splitKey
+		err error
+	)
+
+	if resume != nil {
+		sk, err = resumeKey(sink, resume, opts)
+		if err != nil {
+			return err
+		}
+	} else {
+		// the key of the root list is the only one named, so it can't collide with another
+		fileName, _ := newFileNames(opts).name(opts.rootListKey())
+		sk = newSplitKey(sink, opts.rootListKey(), fileName, opts)
+		sk.isList = true
+
+		err = sk.appendTo(opts.previousKey(sk.name))
+		if err != nil {
+			return err
+		}
+	}
+
+	add := sk.items(stats.count(sk.name, cp.track(sk, sk.add(opts), nil, 0)))
+
+	if resume != nil {
+		err = resumeList(itr, add)
+	} else {
+		add, onErr := annotateItems(sk.name, add, rejects.handler(sk.name, itr), opts)
+		err = parseRootList(itr, add, onErr, opts)
+	}
+
+	if err != nil {
+		failure.set(err)
+		sk.abandon()
+
+		return err
+	}
+
+	err = sk.finish()
+	if err != nil {
+		return err
+	}
+
+	sk.addToManifest(manifest, rejects)
+	manifest.addPrevious(opts.previous)
+
+	return nil
+}
+
+// SplitFile splits the json file in the same way as Split, writing the files to outputPath. The file can be a local
+// file, an http(s) URL or a cloud storage URI, and outputPath a local directory, which is created if it doesn't exist
+// unless opts.RequireOutputDir is set, or a cloud storage URI. If ctx is cancelled the split stops, closing the files
+// written so far. Checkpoints are recorded in outputPath when opts.CheckpointInterval is set, and opts.Resume carries
+// on from the last of them. Each .json entry of a .zip archive is split as its own document into a directory of
+// outputPath named after the entry, as is each .json member of a .tar.gz or .tgz archive.
+func SplitFile(ctx context.Context, filename, outputPath string, opts Options) error {
+	var (
+		err    error
+		rd     *AsyncReader
+		resume *checkpoint
+	)
+
+	err = opts.validate()
+	if err != nil {
+		return err
+	}
+
+	// the budget and the limit on files are shared by the entries of a zip or tar archive
+	opts = opts.withMemoryBudget().withFileLimit()
+
+	if (opts.CheckpointInterval > 0 || opts.Resume) && cloud.IsCloudURI(outputPath) {
+		return fmt.Errorf("checkpoints can only be recorded in a local output directory, not %s", outputPath)
+	}
+
+	if opts.Resume {
+		resume, err = readCheckpoint(outputPath)
+		if err != nil {
+			return err
+		}
+
+		rd, err = asyncReaderFromOffset(filename, resume.Offset, 1024*1024, opts.ReaderOptions)
+		if err != nil {
+			return err
+		}
+
+		defer rd.Close()
+
+		logger().Info(fmt.Sprintf("Resuming %s from offset %d", filename, resume.Offset), "input", filename,
+			"offset", resume.Offset)
+
+		cp := newCheckpointer(outputPath, opts, resume)
+
+		err = splitFrom(rd.Start(ctx), rd, NewDirSink(ctx, outputPath), opts, cp, resume)
+		if err != nil || cp != nil {
+			return err
+		}
+
+		// a split resumed without recording checkpoints of its own still removes the one it carried on from
+		return removeCheckpoint(outputPath)
+	}
+
+	existed := localDirExists(outputPath)
+
+	err = prepareOutputDir(outputPath, opts)
+	if err != nil {
+		return err
+	}
+
+	if isZip(filename) {
+		if opts.CheckpointInterval > 0 {
+			return fmt.Errorf("checkpoints can't be recorded reading %s, as it can't be read from an offset", filename)
+		}
+
+		if opts.existingOutput() == ExistingOutputAppend {
+			return fmt.Errorf("existing output can't be appended to splitting the zip archive %s", filename)
+		}
+
+		return splitZip(ctx, filename, outputPath, opts)
+	}
+
+	if isTarGz(filename) {
+		if opts.CheckpointInterval > 0 {
+			return fmt.Errorf("checkpoints can't be recorded reading %s, as it can't be read from an offset", filename)
+		}
+
+		if opts.existingOutput() == ExistingOutputAppend {
+			return fmt.Errorf("existing output can't be appended to splitting the tar archive %s", filename)
+		}
+
+		return splitTarGz(ctx, filename, outputPath, opts)
+	}
+
+	rd, err = AsyncReaderFromFile(filename, 1024*1024, opts.ReaderOptions...)
+	if err != nil {
+		return err
+	}
+
+	opts.source = filename
+
+	// stops reading if splitting fails part way through
+	defer rd.Close()
+
+	if opts.CheckpointInterval > 0 && !rd.seekable {
+		return fmt.Errorf("checkpoints can't be recorded reading %s, as it can't be read from an offset", filename)
+	}
+
+	logger().Info("Reading "+filename, "input", filename)
+
+	err = splitFrom(rd.Start(ctx), rd, NewDirSink(ctx, outputPath), opts, newCheckpointer(outputPath, opts, nil), nil)
+
+	return removeFailedOutputDir(outputPath, existed, err)
+}
+
+// prepareOutputDir makes sure a local output directory is ready to be written to, refusing to write to one which
+// already exists unless existing output is overwritten, in which case it is removed and created afresh, appended to,
+// or opts.RequireOutputDir is set
+func prepareOutputDir(outputPath string, opts Options) error {
+	policy := opts.existingOutput()
+
+	if !cloud.IsCloudURI(outputPath) && !opts.DryRun {
+		fi, err := os.Stat(outputPath)
+
+		switch {
+		// if we ecountered an error and it's not a "file does not exist" error, exit
+		case err != nil && !os.IsNotExist(err):
+			return err
+		// if the file exists and it's a directory, exit unless it is overwritten, appended to or required to exist
+		case err == nil && fi.IsDir() && policy == ExistingOutputError && !opts.RequireOutputDir:
+			return fmt.Errorf("%w: %s", ErrOutputExists, outputPath)
+		// if the file exists and it's a directory, remove it if it is overwritten
+		case err == nil && fi.IsDir() && policy == ExistingOutputOverwrite:
+			err = os.RemoveAll(outputPath)
+			if err != nil {
+				return err
+			}
+
+			err = mkdirAll(outputPath, opts.DirMode)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return createOutputDir(outputPath, opts)
 }
@@ -1,10 +1,20 @@
 package jsplit
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -333,7 +343,7 @@ true
 	require.NoError(t, err)
 
 	bs := NewTestByteStream([]byte(testStr), 256)
-	err = SplitStream(context.Background(), bs, tempDir)
+	err = SplitStream(context.Background(), bs, tempDir, Options{})
 	require.NoError(t, err)
 
 	requireContents(t, filepath.Join(tempDir, "root.json"), expectedRoot)
@@ -347,6 +357,320 @@ true
 	requireContents(t, filepath.Join(tempDir, "mixed_list_00.jsonl"), expectedMixedList)
 }
 
+func TestSplitStreamRootList(t *testing.T) {
+	var testStr = ` [
+	{"idx": 0, "name": "alex"},
+	{"idx": 1, "tags": ["a", "b"], "text": "contains ], and }"},
+	{"idx": 2, "nested": {"list": [{}, []]}},
+	"string",
+	4
+]`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{Shards: 2})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "part-000_00.jsonl"), `{"idx":0,"name":"alex"}
+{"idx":2,"nested":{"list":[{},[]]}}
+4`)
+	requireContents(t, filepath.Join(tempDir, "part-001_00.jsonl"), `{"idx":1,"tags":["a","b"],"text":"contains ], and }"}
+"string"`)
+
+	_, err = os.Stat(filepath.Join(tempDir, "root.json"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// the lists of an object aren't sharded, so the split fails rather than ignoring the shards
+	outputDir := filepath.Join(t.TempDir(), "object")
+	bs = NewTestByteStream([]byte(`{"users": `+testStr+`}`), 16)
+	err = SplitStream(context.Background(), bs, outputDir, Options{Shards: 2})
+	require.EqualError(t, err, "shards can't be used when the document is an object, only with an array or a stream "+
+		"of json values")
+	require.NoFileExists(t, filepath.Join(outputDir, "users_00.jsonl"))
+}
+
+func TestSplitStreamRootListWithoutShards(t *testing.T) {
+	var testStr = `[{"idx": 0, "name": "alex"}, {"idx": 1, "name": "brian"}, {"idx": 2, "name": "charles"}]`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{MaxFileBytes: 40})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), "{\"idx\":0,\"name\":\"alex\"}\n{\"idx\":1,\"name\":\"brian\"}")
+	requireContents(t, filepath.Join(tempDir, "root_01.jsonl"), `{"idx":2,"name":"charles"}`)
+
+	_, err = os.Stat(filepath.Join(tempDir, "root.json"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	m := readManifest(t, tempDir)
+	require.Len(t, m.Keys, 1)
+	require.Equal(t, "root", m.Keys[0].Key)
+	require.Equal(t, int64(3), m.Keys[0].Records)
+
+	// the name of the files is configurable
+	bs = NewTestByteStream([]byte(`[1, 2, 3]`), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{RootListKey: "items"})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "items_00.jsonl"), "1\n2\n3")
+
+	bs = NewTestByteStream([]byte(`[1, 2, 3]`), 16)
+	err = SplitStream(context.Background(), bs, t.TempDir(), Options{Shards: -1})
+	require.Error(t, err)
+}
+
+func TestSplitStreamKeyOrder(t *testing.T) {
+	items := []string{
+		`{"zebra":1,"apple":{"yak":"y","bee":[{"z":1,"a":2}]},"mango":null,"10":1.50,"2":true}`,
+		`{"b":"\u00e9\"","a":[3,2,1],"c":{}}`,
+	}
+
+	testStr := "{\"list\": [\n  " + items[0] + ",\n  " + items[1] + "\n], \"z\": 1, \"a\": 2}"
+
+	tempDir := t.TempDir()
+
+	// items are copied from the input without being decoded, so keys keep the order they were in
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), items[0]+"\n"+items[1])
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"z\":1,\n\t\"a\":2\n}")
+
+	// as they are when flattened
+	bs = NewTestByteStream([]byte(testStr), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Flatten: true})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), `{"zebra":1,"apple.yak":"y","apple.bee":[{"z":1,"a":2}],"mango":null,"10":1.50,"2":true}`+
+		"\n"+items[1])
+}
+
+func TestSplitStreamMaxFileBytes(t *testing.T) {
+	var testStr = `{"list": [{"idx": 0}, {"idx": 1}, {"idx": 2}, {"idx": 3}, {"idx": 4}], "key": "value"}`
+
+	tempDir := t.TempDir()
+
+	// each file is rolled over once the item crossing the 16 byte threshold has been written
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{MaxFileBytes: 16})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}")
+	requireContents(t, filepath.Join(tempDir, "list_01.jsonl"), "{\"idx\":2}\n{\"idx\":3}")
+	requireContents(t, filepath.Join(tempDir, "list_02.jsonl"), `{"idx":4}`)
+
+	_, err = os.Stat(filepath.Join(tempDir, "list_03.jsonl"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	bs = NewTestByteStream([]byte(testStr), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{MaxFileBytes: -1})
+	require.Error(t, err)
+}
+
+func TestSplitStreamRecordsPerFile(t *testing.T) {
+	const doc = `{"list": [{"idx": 0, "s": "a long string which crosses max file bytes"}, {"idx": 1}, {"idx": 2}, ` +
+		`{"idx": 3}, {"idx": 4}], "even": [1, 2, 3, 4], "key": "value"}`
+
+	// every file holds exactly the number of items but the last, which holds the rest, however large the items are
+	for _, format := range []Format{FormatJSONL, FormatNDJSON} {
+		tempDir := t.TempDir()
+
+		bs := NewTestByteStream([]byte(doc), 16)
+		require.NoError(t, SplitStream(context.Background(), bs, tempDir,
+			Options{RecordsPerFile: 2, MaxFileBytes: 16, Format: format}))
+
+		manifest := readManifest(t, tempDir)
+		require.Len(t, manifest.Keys, 2)
+
+		var records []int64
+		for _, file := range manifest.Keys[0].Files {
+			records = append(records, file.Records)
+		}
+
+		require.Equal(t, []int64{2, 2, 1}, records, format)
+
+		end := ""
+		if format == FormatNDJSON {
+			end = "\n"
+		}
+
+		ext := "." + string(format)
+		requireContents(t, filepath.Join(tempDir, "list_01"+ext), "{\"idx\":2}\n{\"idx\":3}"+end)
+		requireContents(t, filepath.Join(tempDir, "list_02"+ext), `{"idx":4}`+end)
+
+		// a list which fills its last file doesn't start another
+		require.Len(t, manifest.Keys[1].Files, 2)
+		requireContents(t, filepath.Join(tempDir, "even_01"+ext), "3\n4"+end)
+	}
+
+	// appending carries on filling the last file
+	tempDir := t.TempDir()
+	opts := Options{RecordsPerFile: 4}
+
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(`{"list": [1, 2, 3]}`), 8),
+		tempDir, opts))
+
+	opts.ExistingOutput = ExistingOutputAppend
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(`{"list": [4, 5]}`), 8),
+		tempDir, opts))
+
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "1\n2\n3\n4")
+	requireContents(t, filepath.Join(tempDir, "list_01.jsonl"), "5")
+
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), t.TempDir(),
+		Options{RecordsPerFile: -1})
+	require.EqualError(t, err, "records per file must not be negative, got -1")
+}
+
+func TestSplitStreamNDJSON(t *testing.T) {
+	var testStr = `{"key": "value", "list": [{"idx": 0}, {"idx": 1}], "empty": [], "other": [1, 2]}`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{Format: FormatNDJSON})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"key\":\"value\"\n}")
+	requireContents(t, filepath.Join(tempDir, "list_00.ndjson"), "{\"idx\":0}\n{\"idx\":1}\n")
+	requireContents(t, filepath.Join(tempDir, "empty_00.ndjson"), "")
+	requireContents(t, filepath.Join(tempDir, "other_00.ndjson"), "1\n2\n")
+
+	_, err = os.Stat(filepath.Join(tempDir, "key_00.ndjson"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// shards which aren't given any elements are empty files too
+	bs = NewTestByteStream([]byte(`[1]`), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Shards: 2, Format: FormatNDJSON})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "part-000_00.ndjson"), "1\n")
+	requireContents(t, filepath.Join(tempDir, "part-001_00.ndjson"), "")
+}
+
+func TestSplitStreamDuplicateKeys(t *testing.T) {
+	const doc = `{"users": [{"id": 1}, {"id": 2}], "n": 1, "users": [{"id": 3}], "groups": [], "users": [{"id": 4}]}`
+
+	// the items of every list with the key end up in the same files, in order
+	for _, concurrency := range []int{0, 2} {
+		tempDir := t.TempDir()
+
+		bs := NewTestByteStream([]byte(doc), 8)
+		require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{Concurrency: concurrency}))
+
+		requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}")
+
+		manifest := readManifest(t, tempDir)
+		require.Len(t, manifest.Keys, 1)
+		require.Equal(t, "users", manifest.Keys[0].Key)
+		require.Equal(t, int64(4), manifest.Keys[0].Records)
+		require.Len(t, manifest.Keys[0].Files, 1)
+	}
+
+	// files which were full, or can't be appended to, are followed by new ones
+	tests := map[string]struct {
+		opts  Options
+		files map[string]string
+	}{
+		"rolled over": {Options{MaxFileBytes: 16, Format: FormatNDJSON}, map[string]string{
+			"users_00.ndjson": "{\"id\":1}\n{\"id\":2}\n",
+			"users_01.ndjson": "{\"id\":3}\n{\"id\":4}\n",
+		}},
+		"csv": {Options{Format: FormatCSV}, map[string]string{
+			"users_00.csv": "id\n1\n2\n",
+			"users_01.csv": "id\n3\n",
+			"users_02.csv": "id\n4\n",
+		}},
+	}
+
+	for name, test := range tests {
+		tempDir := t.TempDir()
+
+		bs := NewTestByteStream([]byte(doc), 8)
+		require.NoError(t, SplitStream(context.Background(), bs, tempDir, test.opts), name)
+
+		for file, contents := range test.files {
+			requireContents(t, filepath.Join(tempDir, file), contents)
+		}
+
+		manifest := readManifest(t, tempDir)
+		require.Equal(t, int64(4), manifest.Keys[0].Records, name)
+		require.Len(t, manifest.Keys[0].Files, len(test.files), name)
+	}
+
+	// compressed files are appended to with another gzip member
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{CompressOutput: true}))
+	requireGzipContents(t, filepath.Join(tempDir, "users_00.jsonl.gz"), "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}")
+}
+
+func TestSplitStreamCompressOutput(t *testing.T) {
+	var testStr = `{"key": "value", "list": [{"idx": 0}, {"idx": 1}], "other": [1, 2]}`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{CompressOutput: true})
+	require.NoError(t, err)
+
+	requireGzipContents(t, filepath.Join(tempDir, "root.json.gz"), "{\n\t\"key\":\"value\"\n}")
+	requireGzipContents(t, filepath.Join(tempDir, "list_00.jsonl.gz"), "{\"idx\":0}\n{\"idx\":1}")
+	requireGzipContents(t, filepath.Join(tempDir, "other_00.jsonl.gz"), "1\n2")
+
+	// the compressed output can be split again
+	bs = NewTestByteStream([]byte(`[1, 2, 3]`), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Shards: 2, CompressOutput: true})
+	require.NoError(t, err)
+
+	requireGzipContents(t, filepath.Join(tempDir, "part-000_00.jsonl.gz"), "1\n3")
+	requireGzipContents(t, filepath.Join(tempDir, "part-001_00.jsonl.gz"), "2")
+}
+
+func TestSplitStreamGzipLevel(t *testing.T) {
+	var testStr = `{"key": "value", "list": [{"idx": 0}, {"idx": 1}, {"idx": 0}, {"idx": 1}]}`
+
+	levels := []int{0, gzip.BestSpeed, 5, gzip.BestCompression, gzip.DefaultCompression, gzip.HuffmanOnly}
+	for _, level := range levels {
+		tempDir := t.TempDir()
+
+		bs := NewTestByteStream([]byte(testStr), 16)
+		err := SplitStream(context.Background(), bs, tempDir, Options{CompressOutput: true, GzipLevel: level})
+		require.NoError(t, err)
+
+		requireGzipContents(t, filepath.Join(tempDir, "root.json.gz"), "{\n\t\"key\":\"value\"\n}")
+		requireGzipContents(t, filepath.Join(tempDir, "list_00.jsonl.gz"),
+			"{\"idx\":0}\n{\"idx\":1}\n{\"idx\":0}\n{\"idx\":1}")
+	}
+
+	for _, level := range []int{-3, 10} {
+		bs := NewTestByteStream([]byte(testStr), 16)
+		err := SplitStream(context.Background(), bs, t.TempDir(), Options{CompressOutput: true, GzipLevel: level})
+		require.EqualError(t, err, fmt.Sprintf("gzip level must be between -2 and 9, got %d", level))
+	}
+}
+
+func requireGzipContents(t *testing.T, filename string, expectedContents string) {
+	t.Run(filepath.Base(filename), func(t *testing.T) {
+		f, err := os.Open(filename)
+		require.NoError(t, err)
+
+		defer f.Close()
+
+		gzRd, err := gzip.NewReader(f)
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(gzRd)
+		require.NoError(t, err)
+		require.Equal(t, expectedContents, string(data))
+	})
+}
+
 func requireContents(t *testing.T, filename string, expectedContents string) {
 	t.Run(filepath.Base(filename), func(t *testing.T) {
 		data, err := os.ReadFile(filename)
@@ -355,3 +679,599 @@ func requireContents(t *testing.T, filename string, expectedContents string) {
 		require.Equal(t, expectedContents, string(data))
 	})
 }
+
+// cancellingByteStream cancels the split part way through the stream, as an interrupt does, after which reads fail
+// with the context's error
+type cancellingByteStream struct {
+	ByteStream
+	reads       int
+	cancelAfter int
+	cancel      context.CancelFunc
+}
+
+func (cbs *cancellingByteStream) Read(ctx context.Context) ([]byte, error) {
+	cbs.reads++
+	if cbs.reads > cbs.cancelAfter {
+		cbs.cancel()
+		<-ctx.Done()
+
+		return nil, ctx.Err()
+	}
+
+	return cbs.ByteStream.Read(ctx)
+}
+
+func TestSplitStreamCancelled(t *testing.T) {
+	var items []string
+	for i := 0; i < 100; i++ {
+		items = append(items, fmt.Sprintf(`{"idx": %d, "name": "item %d"}`, i, i))
+	}
+
+	list := "[" + strings.Join(items, ", ") + "]"
+
+	tests := map[string]struct {
+		doc   string
+		opts  Options
+		files []string
+	}{
+		"keys":        {`{"key": "value", "list": ` + list + `}`, Options{}, []string{"list_00.jsonl"}},
+		"concurrent":  {`{"first": ` + list + `, "second": ` + list + `}`, Options{Concurrency: 2}, []string{"first_00.jsonl", "second_00.jsonl"}},
+		"compressed":  {`{"list": ` + list + `}`, Options{CompressOutput: true}, []string{"list_00.jsonl.gz"}},
+		"root list":   {list, Options{}, []string{"root_00.jsonl"}},
+		"root shards": {list, Options{Shards: 2}, []string{"part-000_00.jsonl", "part-001_00.jsonl"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// stops three quarters of the way through the document, part way through the last list
+			cbs := &cancellingByteStream{
+				ByteStream:  NewTestByteStream([]byte(test.doc), 16),
+				cancelAfter: len(test.doc) * 3 / 4 / 16,
+				cancel:      cancel,
+			}
+
+			err := SplitStream(ctx, cbs, tempDir, test.opts)
+			require.ErrorIs(t, err, context.Canceled)
+
+			// the files hold whole items, up to where the split stopped
+			records := 0
+
+			for _, file := range test.files {
+				f, err := os.Open(filepath.Join(tempDir, file))
+				require.NoError(t, err)
+				defer f.Close()
+
+				var rd io.Reader = f
+				if test.opts.CompressOutput {
+					rd, err = gzip.NewReader(f)
+					require.NoError(t, err)
+				}
+
+				data, err := io.ReadAll(rd)
+				require.NoError(t, err)
+
+				lines := strings.Split(string(data), "\n")
+				for _, line := range lines {
+					require.True(t, json.Valid([]byte(line)), line)
+				}
+
+				records += len(lines)
+			}
+
+			require.Less(t, records, 100*len(test.files))
+		})
+	}
+}
+
+func TestSplitStreamIncludeExcludeKeys(t *testing.T) {
+	const doc = `{"key": "value", "skipped": "val", "list": [{"idx": 0}], ` +
+		`"other": [{"idx": 1, "nested": {"s": "]}\",["}}, [1, [2]]], "last": [{"idx": 2}]}`
+
+	tests := map[string]struct {
+		opts  Options
+		files []string
+		root  string
+	}{
+		"include": {
+			opts:  Options{IncludeKeys: []string{"list", "key"}},
+			files: []string{"list_00.jsonl"},
+			root:  "{\n\t\"key\":\"value\"\n}",
+		},
+		"exclude": {
+			opts:  Options{ExcludeKeys: []string{"other", "skipped"}},
+			files: []string{"list_00.jsonl", "last_00.jsonl"},
+			root:  "{\n\t\"key\":\"value\"\n}",
+		},
+		"include takes precedence": {
+			opts:  Options{IncludeKeys: []string{"other", "last"}, ExcludeKeys: []string{"other"}},
+			files: []string{"other_00.jsonl", "last_00.jsonl"},
+			root:  "{\n\n}",
+		},
+		"include pattern": {
+			opts:  Options{IncludeKeys: []string{"key"}, IncludeKeyPattern: regexp.MustCompile(`^l`)},
+			files: []string{"list_00.jsonl", "last_00.jsonl"},
+			root:  "{\n\t\"key\":\"value\"\n}",
+		},
+		"exclude pattern": {
+			opts:  Options{ExcludeKeys: []string{"list"}, ExcludeKeyPattern: regexp.MustCompile(`^(s|o)`)},
+			files: []string{"last_00.jsonl"},
+			root:  "{\n\t\"key\":\"value\"\n}",
+		},
+		"include pattern takes precedence": {
+			opts:  Options{IncludeKeyPattern: regexp.MustCompile(`t$`), ExcludeKeyPattern: regexp.MustCompile(`.`)},
+			files: []string{"list_00.jsonl", "last_00.jsonl"},
+			root:  "{\n\n}",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			bs := NewTestByteStream([]byte(doc), 8)
+			require.NoError(t, SplitStream(context.Background(), bs, tempDir, test.opts))
+
+			// excluded keys produce no files, and aren't in the manifest
+			expected := append([]string{"root.json", ManifestFilename}, test.files...)
+
+			entries, err := os.ReadDir(tempDir)
+			require.NoError(t, err)
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+
+			require.ElementsMatch(t, expected, names)
+			require.Len(t, readManifest(t, tempDir).Keys, len(test.files))
+			requireContents(t, filepath.Join(tempDir, "root.json"), test.root)
+		})
+	}
+
+	// keys after an excluded value are still split
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{ExcludeKeys: []string{"other"}}))
+	requireContents(t, filepath.Join(tempDir, "last_00.jsonl"), `{"idx":2}`)
+
+	// excluded values are parsed, so a document which ends inside one fails
+	bs = NewTestByteStream([]byte(`{"other": [{"idx": 1}, {"idx": [`), 8)
+	require.Error(t, SplitStream(context.Background(), bs, t.TempDir(), Options{ExcludeKeys: []string{"other"}}))
+}
+
+func TestSplitStreamLimit(t *testing.T) {
+	const doc = `{"list": [{"idx": 0}, {"idx": 1}, {"idx": 2, "s": "]}\",["}, [1, [2]], 3], "key": "value", ` +
+		`"short": [{"idx": 3}], "empty": [], "last": [4, 5, 6]}`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{Limit: 2}))
+
+	// the skipped items, with brackets in strings and nested lists, don't stop the keys which follow being split
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}")
+	requireContents(t, filepath.Join(tempDir, "short_00.jsonl"), `{"idx":3}`)
+	requireContents(t, filepath.Join(tempDir, "last_00.jsonl"), "4\n5")
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"key\":\"value\"\n}")
+
+	manifest := readManifest(t, tempDir)
+	require.Len(t, manifest.Keys, 3)
+	require.Equal(t, int64(2), manifest.Keys[0].Records)
+
+	// the elements of a document which is a json array are limited across every shard
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(`[1, 2, 3, 4, 5]`), 4)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{Limit: 3, Shards: 2}))
+	requireContents(t, filepath.Join(tempDir, "part-000_00.jsonl"), "1\n3")
+	requireContents(t, filepath.Join(tempDir, "part-001_00.jsonl"), "2")
+
+	// the rest of a list is still read, so a document which ends inside it fails
+	bs = NewTestByteStream([]byte(`{"list": [1, 2, [3`), 4)
+	require.Error(t, SplitStream(context.Background(), bs, t.TempDir(), Options{Limit: 1}))
+
+	opts := Options{Limit: -1}
+	require.EqualError(t, opts.validate(), "limit must not be negative, got -1")
+}
+
+// syntheticArrayStream generates a document holding a single list of about size bytes, without ever holding more than
+// one chunk of it in memory
+type syntheticArrayStream struct {
+	size    int64
+	written int64
+	index   int
+	done    bool
+}
+
+func (sas *syntheticArrayStream) Read(context.Context) ([]byte, error) {
+	if sas.done {
+		return nil, io.EOF
+	}
+
+	// chunks aren't reused as the iterator may hold on to them
+	chunk := make([]byte, 0, 64*1024)
+
+	if sas.written == 0 {
+		chunk = append(chunk, `{"big": [`...)
+	}
+
+	for len(chunk) < 60*1024 {
+		if sas.written >= sas.size {
+			chunk = append(chunk, `]}`...)
+			sas.done = true
+
+			break
+		}
+
+		if sas.index > 0 {
+			chunk = append(chunk, ',')
+		}
+
+		start := len(chunk)
+		chunk = append(chunk, fmt.Sprintf(`{"idx": %d, "payload": "%s"}`, sas.index, strings.Repeat("x", 200))...)
+		sas.written += int64(len(chunk) - start)
+		sas.index++
+	}
+
+	return chunk, nil
+}
+
+// discardSink counts the bytes written to it without keeping them
+type discardSink struct {
+	written int64
+}
+
+func (ds *discardSink) OpenKey(string) (io.WriteCloser, error) {
+	return &countingWriteCloser{WriteCloser: discardWriteCloser{}, n: &ds.written}, nil
+}
+
+func TestSplitStreamLargeArrayMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("splits a large document")
+	}
+
+	const size = 256 * 1024 * 1024
+
+	runtime.GC()
+
+	var peak uint64
+
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+
+	go func() {
+		defer close(sampled)
+
+		var ms runtime.MemStats
+
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > peak {
+				peak = ms.HeapAlloc
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+
+	sink := &discardSink{}
+	stream := &syntheticArrayStream{size: size}
+
+	err := splitStream(context.Background(), stream, sink, Options{})
+
+	close(stop)
+	<-sampled
+
+	require.NoError(t, err)
+	require.Greater(t, sink.written, int64(size*9/10))
+
+	// list items are streamed to their files one at a time, so the heap stays far smaller than the list
+	require.Less(t, peak, uint64(64*1024*1024), "peak heap %d bytes", peak)
+}
+
+func TestSplitEmptyInput(t *testing.T) {
+	ctx := context.Background()
+
+	for _, doc := range []string{"", "  \n\t\r\n "} {
+		// nothing is written, and the output directory isn't left behind
+		outputDir := filepath.Join(t.TempDir(), "out")
+
+		err := SplitStream(ctx, NewTestByteStream([]byte(doc), 2), outputDir, Options{})
+		require.ErrorIs(t, err, ErrEmptyInput)
+		require.EqualError(t, err, "input contained no JSON document")
+		require.NoDirExists(t, outputDir)
+
+		filename := filepath.Join(t.TempDir(), "empty.json")
+		require.NoError(t, os.WriteFile(filename, []byte(doc), 0o644))
+
+		err = SplitFile(ctx, filename, outputDir, Options{})
+		require.ErrorIs(t, err, ErrEmptyInput)
+		require.EqualError(t, err, "input contained no JSON document: "+filename)
+		require.NoDirExists(t, outputDir)
+
+		// a directory which already existed is kept
+		err = SplitFile(ctx, filename, t.TempDir(), Options{RequireOutputDir: true})
+		require.ErrorIs(t, err, ErrEmptyInput)
+
+		err = SplitStream(ctx, NewTestByteStream([]byte(doc), 2), t.TempDir(), Options{Root: "/data"})
+		require.ErrorIs(t, err, ErrEmptyInput)
+
+		_, err = Peek(ctx, NewTestByteStream([]byte(doc), 2), PeekOptions{})
+		require.ErrorIs(t, err, ErrEmptyInput)
+
+		records, errs := Records(ctx, NewTestByteStream([]byte(doc), 2), Options{})
+		for range records {
+		}
+
+		require.ErrorIs(t, <-errs, ErrEmptyInput)
+	}
+
+	// null is a document, just not one which can be split
+	err := SplitStream(ctx, NewTestByteStream([]byte(" null "), 2), t.TempDir(), Options{})
+	require.NotErrorIs(t, err, ErrEmptyInput)
+	require.ErrorContains(t, err, "invalid format. only json objects and arrays are supported")
+}
+
+func TestSplitStreamMaxDepth(t *testing.T) {
+	ctx := context.Background()
+	nested := func(depth int) string {
+		return strings.Repeat("[", depth) + strings.Repeat("]", depth)
+	}
+
+	// items nested as deep as the limit are written, counting the item itself but not the strings within it
+	tempDir := t.TempDir()
+	doc := `{"list": [` + nested(3) + `, {"a": [{"b": "[[{{"}]}], "value": {"x": [[1]]}}`
+	require.NoError(t, SplitStream(ctx, NewTestByteStream([]byte(doc), 8), tempDir, Options{MaxDepth: 3}))
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "[[[]]]\n"+`{"a":[{"b":"[[{{"}]}`)
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t"+`"value":{"x":[[1]]}`+"\n}")
+
+	// while deeper items and values fail the split with a parse error at the bracket which is too deep
+	for _, doc := range []string{`{"list": [` + nested(4) + `]}`, `{"value": {"x": [[[1]]]}}`, nested(5)} {
+		err := SplitStream(ctx, NewTestByteStream([]byte(doc), 8), t.TempDir(), Options{MaxDepth: 3})
+		require.ErrorIs(t, err, ErrTooDeep, doc)
+
+		var pe *ParseError
+		require.ErrorAs(t, err, &pe)
+		require.ErrorContains(t, err, "json nested too deeply, more than 3 objects and arrays deep")
+	}
+
+	// input nested millions deep fails cleanly at the default limit, without reading the rest of an unclosed document
+	// into memory
+	deep := `{"list": [` + strings.Repeat("[", 4*1024*1024)
+	err := SplitStream(ctx, NewTestByteStream([]byte(deep), 64*1024), t.TempDir(), Options{})
+	require.ErrorIs(t, err, ErrTooDeep)
+	require.ErrorContains(t, err, fmt.Sprintf("more than %d objects and arrays deep", DefaultMaxDepth))
+
+	// items which are too deep are skipped with SkipErrors, and the next item is parsed from where they end
+	tempDir = t.TempDir()
+	doc = `{"list": [1, ` + nested(5) + `, {"s": "]]"}, [[{"a": ["]"]}]], 2]}`
+	require.NoError(t, SplitStream(ctx, NewTestByteStream([]byte(doc), 4), tempDir,
+		Options{MaxDepth: 3, SkipErrors: true}))
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "1\n"+`{"s":"]]"}`+"\n2")
+
+	err = SplitStream(ctx, NewTestByteStream([]byte(doc), 8), t.TempDir(), Options{MaxDepth: -1})
+	require.EqualError(t, err, "max depth must not be negative, got -1")
+}
+
+func TestSplitStreamMaxRecordBytes(t *testing.T) {
+	ctx := context.Background()
+
+	// an oversized object, whose strings hold brackets and escaped quotes, an oversized string and one with whitespace
+	blob := strings.Repeat(`x]\"`, 50)
+	doc := `{"list": [{"id": 1}, {"id": 2, "blob": "` + blob + `"}, "short", "` + blob + `", [1, "` +
+		strings.Repeat(" ", 100) + `"], {"id": 3}], "value": "small"}`
+
+	// items up to the limit are written, while an oversized one fails the split with a parse error
+	err := SplitStream(ctx, NewTestByteStream([]byte(doc), 8), t.TempDir(), Options{MaxRecordBytes: 64})
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+
+	var pe *ParseError
+	require.ErrorAs(t, err, &pe)
+	require.ErrorContains(t, err, "json value too large, more than 64 bytes")
+
+	var buf bytes.Buffer
+
+	SetLogger(slog.New(NewMessageHandler(&buf, slog.LevelInfo)))
+	defer SetLogger(nil)
+
+	// with SkipErrors oversized items are warned about and rejected, and the next item is parsed from where they end
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(ctx, NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{MaxRecordBytes: 64, SkipErrors: true}))
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), `{"id":1}`+"\n"+`"short"`+"\n"+`{"id":3}`)
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t"+`"value":"small"`+"\n}")
+
+	var rejected []int
+	for _, item := range readRejects(t, tempDir) {
+		require.Equal(t, "list", item.Key)
+		require.Contains(t, item.Error, "json value too large, more than 64 bytes")
+
+		rejected = append(rejected, item.Index)
+	}
+
+	require.Equal(t, []int{1, 3, 4}, rejected)
+	require.Contains(t, buf.String(), "item 1 of list was skipped: ")
+	require.Contains(t, buf.String(), "item 3 of list was skipped: ")
+
+	// a value which isn't a list is limited too
+	err = SplitStream(ctx, NewTestByteStream([]byte(`{"value": "`+blob+`"}`), 8), t.TempDir(),
+		Options{MaxRecordBytes: 64})
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+
+	err = SplitStream(ctx, NewTestByteStream([]byte(doc), 8), t.TempDir(), Options{MaxRecordBytes: -1})
+	require.EqualError(t, err, "max record bytes must not be negative, got -1")
+}
+
+func TestSplitStreamLargeNumbers(t *testing.T) {
+	// integers beyond the precision of a float64 or the range of an int64, and numbers written with exponents
+	const doc = `{"id": 18446744073709551615, "users": [
+		{"id": 9007199254740993, "score": 1.10e2, "user": {"id": 9007199254740995}},
+		{"id": 9007199254740992, "score": 123456789012345678901234567890, "user": {"id": -9223372036854775808}}]}`
+
+	const first = `"id":9007199254740993,"score":1.10e2`
+	const second = `"id":9007199254740992,"score":123456789012345678901234567890`
+
+	// numbers are written with their original digits however the items are transformed
+	for name, tc := range map[string]struct {
+		opts     Options
+		filename string
+		expected string
+	}{
+		"passthrough": {Options{}, "users_00.jsonl", `{` + first + `,"user":{"id":9007199254740995}}` + "\n" +
+			`{` + second + `,"user":{"id":-9223372036854775808}}`},
+		"flattened": {Options{Flatten: true}, "users_00.jsonl", `{` + first + `,"user.id":9007199254740995}` + "\n" +
+			`{` + second + `,"user.id":-9223372036854775808}`},
+		"redacted": {Options{Redact: []string{"user"}}, "users_00.jsonl", `{` + first + `}` + "\n" + `{` + second + `}`},
+		"sorted": {Options{SortBy: "id", Redact: []string{"user"}}, "users_00.jsonl",
+			`{` + second + `}` + "\n" + `{` + first + `}`},
+		"filtered": {Options{Filter: "id == 9007199254740993", Redact: []string{"user"}}, "users_00.jsonl",
+			`{` + first + `}`},
+		"csv": {Options{Format: FormatCSV, Flatten: true}, "users_00.csv", "id,score,user.id\n" +
+			"9007199254740993,1.10e2,9007199254740995\n9007199254740992,123456789012345678901234567890," +
+			"-9223372036854775808\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), tempDir, tc.opts))
+			requireContents(t, filepath.Join(tempDir, tc.filename), tc.expected)
+			requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"id\":18446744073709551615\n}")
+		})
+	}
+}
+
+func TestSplitStreamConcatenatedDocuments(t *testing.T) {
+	const doc = `{"name": "first", "users": [{"id": 1}, {"id": 2}], "events": [{"type": "a"}]}
+{
+	"users": [{"id": 3}],
+	"count": 2,
+	"groups": [{"g": 1}],
+	"events": [{"type": "b"}]
+}  {}
+{"users": [{"id": 4}], "users": [{"id": 5}]}
+`
+
+	for _, chunk := range []int{1, 16, 4096} {
+		ctx, _ := NewErrContextWithProblems(context.Background(), 10)
+		tempDir := t.TempDir()
+		require.NoError(t, SplitStream(ctx, NewTestByteStream([]byte(doc), chunk), tempDir, Options{}))
+
+		// the lists of every document are appended to the files of their keys, in order, and the other values of every
+		// document are written to one root.json
+		requireContents(t, filepath.Join(tempDir, "users_00.jsonl"),
+			"{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}\n{\"id\":5}")
+		requireContents(t, filepath.Join(tempDir, "events_00.jsonl"), "{\"type\":\"a\"}\n{\"type\":\"b\"}")
+		requireContents(t, filepath.Join(tempDir, "groups_00.jsonl"), `{"g":1}`)
+		requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"name\":\"first\",\n\t\"count\":2\n}")
+
+		m := readManifest(t, tempDir)
+		require.Len(t, m.Keys, 3)
+		require.Equal(t, int64(5), m.Keys[0].Records)
+
+		// only a key repeated within a document is reported
+		problems, reported := ctx.Problems()
+		require.Equal(t, 1, reported)
+		require.Contains(t, problems[0].Err.Error(), "users appears more than once in the document")
+	}
+
+	// only objects can follow a document
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(`{"a": [{"b": 1}]} [1]`), 8), t.TempDir(),
+		Options{})
+	require.ErrorContains(t, err, `unexpected "[" after the end of the document, only objects can follow it`)
+
+	// nothing after the object found with a root pointer is split
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(),
+		NewTestByteStream([]byte(`{"data": {"a": [1]}, "other": {"a": [2]}}`), 8), tempDir, Options{Root: "/data"}))
+	requireContents(t, filepath.Join(tempDir, "a_00.jsonl"), `1`)
+}
+
+func TestSplitStreamValueFiles(t *testing.T) {
+	const doc = `{"users": [{"id": 1}, {"id": 2}], "config": {"retries": 3, "hosts": ["a", "b"]}, "count": 2,
+		"name": "export", "enabled": true, "missing": null, "empty": [], "count": 3}`
+
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), tempDir,
+		Options{ValueFiles: true, Checksums: true}))
+
+	// lists are split as before, while every other value is written to a file of its own as it would be in root.json,
+	// the last value of a key which appears twice replacing the first
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1}`+"\n"+`{"id":2}`)
+	requireContents(t, filepath.Join(tempDir, "config.json"), `{"retries":3,"hosts":["a","b"]}`)
+	requireContents(t, filepath.Join(tempDir, "count.json"), `3`)
+	requireContents(t, filepath.Join(tempDir, "name.json"), `"export"`)
+	requireContents(t, filepath.Join(tempDir, "enabled.json"), `true`)
+	requireContents(t, filepath.Join(tempDir, "missing.json"), `null`)
+	require.NoFileExists(t, filepath.Join(tempDir, "root.json"))
+	require.NoFileExists(t, filepath.Join(tempDir, "empty.json"))
+
+	m := readManifest(t, tempDir)
+	require.Nil(t, m.Root)
+
+	var values []string
+	for _, mv := range m.Values {
+		values = append(values, mv.Key)
+		require.Equal(t, int64(1), mv.Records)
+		require.Equal(t, fileSize(t, filepath.Join(tempDir, mv.Files[0].Name)), mv.Bytes)
+	}
+
+	require.Equal(t, []string{"config", "count", "name", "enabled", "missing"}, values)
+
+	verified, err := VerifyChecksums(context.Background(), tempDir)
+	require.NoError(t, err)
+	require.Equal(t, 6, verified)
+
+	var buf strings.Builder
+
+	require.NoError(t, Reassemble(context.Background(), tempDir, &buf))
+	require.JSONEq(t, `{"users": [{"id": 1}, {"id": 2}], "config": {"retries": 3, "hosts": ["a", "b"]}, "count": 3,
+		"name": "export", "enabled": true, "missing": null}`, buf.String())
+
+	// values are indented and compressed as root.json would be
+	tempDir = t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), tempDir,
+		Options{ValueFiles: true, Pretty: true, CompressOutput: true}))
+
+	f, err := os.Open(filepath.Join(tempDir, "config.json.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	gzRd, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(gzRd)
+	require.NoError(t, err)
+	require.Equal(t, "{\n  \"retries\": 3,\n  \"hosts\": [\n    \"a\",\n    \"b\"\n  ]\n}", string(data))
+
+	// a value can't overwrite the manifest, and value files can't be appended to or split from several files
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(`{"manifest": {"a": 1}}`), 16), t.TempDir(),
+		Options{ValueFiles: true})
+	require.EqualError(t, err, "the value of key manifest can't be written to manifest.json, the name of the manifest")
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), t.TempDir(),
+		Options{ValueFiles: true, ExistingOutput: ExistingOutputAppend})
+	require.EqualError(t, err, "existing output can't be appended to with value files")
+
+	err = SplitFiles(context.Background(), []string{"a.json", "b.json"}, t.TempDir(), Options{ValueFiles: true})
+	require.EqualError(t, err, "several files can't be split with value files")
+}
+
+func BenchmarkSplitStreamLargeArray(b *testing.B) {
+	const size = 64 * 1024 * 1024
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		err := splitStream(context.Background(), &syntheticArrayStream{size: size}, &discardSink{}, Options{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
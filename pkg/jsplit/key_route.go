@@ -0,0 +1,56 @@
+package jsplit
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// KeyRoute routes the lists of the keys matching Pattern, a glob as matched by path.Match such as dim_*, to Dir, a
+// directory directly within the output such as dims. Dirs can't be nested, as the directories of partitions can't.
+type KeyRoute struct {
+	Pattern string
+	Dir     string
+}
+
+// ParseKeyRoute parses a route given as pattern=dir, e.g. dim_*=dims
+func ParseKeyRoute(value string) (KeyRoute, error) {
+	pattern, dir, ok := strings.Cut(value, "=")
+	if !ok || pattern == "" || dir == "" {
+		return KeyRoute{}, fmt.Errorf("expected pattern=dir, got %s", value)
+	}
+
+	route := KeyRoute{Pattern: pattern, Dir: strings.TrimSuffix(dir, "/")}
+
+	return route, validateKeyRoutes([]KeyRoute{route})
+}
+
+// validateKeyRoutes checks the patterns of the routes can be matched and their directories are plain directory names
+func validateKeyRoutes(routes []KeyRoute) error {
+	for _, route := range routes {
+		_, err := path.Match(route.Pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid route pattern %q: %w", route.Pattern, err)
+		}
+
+		if checkFileName(route.Dir) != nil {
+			return fmt.Errorf("the directory %q of the route for %s must be a directory name, such as dims", route.Dir,
+				route.Pattern)
+		}
+	}
+
+	return nil
+}
+
+// keyDir returns the directory within the output the lists of the key are routed to by opts.KeyRoutes, or an empty
+// string when they are written to its root
+func (opts Options) keyDir(key string) string {
+	for _, route := range opts.KeyRoutes {
+		// validate has already checked the pattern can be matched
+		if ok, _ := path.Match(route.Pattern, key); ok {
+			return route.Dir
+		}
+	}
+
+	return ""
+}
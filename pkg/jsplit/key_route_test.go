@@ -0,0 +1,63 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyRoute(t *testing.T) {
+	route, err := ParseKeyRoute("dim_*=dims/")
+	require.NoError(t, err)
+	require.Equal(t, KeyRoute{Pattern: "dim_*", Dir: "dims"}, route)
+
+	_, err = ParseKeyRoute("dims")
+	require.EqualError(t, err, "expected pattern=dir, got dims")
+
+	_, err = ParseKeyRoute("dim_[=dims")
+	require.EqualError(t, err, `invalid route pattern "dim_[": syntax error in pattern`)
+
+	for _, dir := range []string{"..", "../dims", "/dims", "tables/dims", `tables\dims`, "."} {
+		_, err = ParseKeyRoute("dim_*=" + dir)
+		require.EqualError(t, err, "the directory "+strconv.Quote(dir)+" of the route for dim_* must be a directory "+
+			"name, such as dims")
+	}
+}
+
+func TestSplitStreamKeyRoutes(t *testing.T) {
+	const doc = `{"dim_users": [{"id": 1}], "fact_orders": [{"id": 2}, {"id": 3}], "fact_dim": [4], ` +
+		`"events": [5], "name": "export"}`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{KeyRoutes: []KeyRoute{
+		{Pattern: "dim_*", Dir: "dims"},
+		{Pattern: "fact_*", Dir: "facts"},
+		{Pattern: "*dim*", Dir: "other"},
+	}}))
+
+	// each key follows the first route it matches, and keys matching none stay in the root
+	requireContents(t, filepath.Join(tempDir, "dims", "dim_users_00.jsonl"), `{"id":1}`)
+	requireContents(t, filepath.Join(tempDir, "facts", "fact_orders_00.jsonl"), `{"id":2}`+"\n"+`{"id":3}`)
+	requireContents(t, filepath.Join(tempDir, "facts", "fact_dim_00.jsonl"), "4")
+	requireContents(t, filepath.Join(tempDir, "events_00.jsonl"), "5")
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"name\":\"export\"\n}")
+	require.NoDirExists(t, filepath.Join(tempDir, "other"))
+
+	var names []string
+	for _, key := range readManifest(t, tempDir).Keys {
+		for _, file := range key.Files {
+			names = append(names, file.Name)
+		}
+	}
+
+	require.Equal(t, []string{"dims/dim_users_00.jsonl", "facts/fact_orders_00.jsonl",
+		"facts/fact_dim_00.jsonl", "events_00.jsonl"}, names)
+
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(),
+		Options{KeyRoutes: []KeyRoute{{Pattern: "dim_*", Dir: "../dims"}}})
+	require.EqualError(t, err, `the directory "../dims" of the route for dim_* must be a directory name, such as dims`)
+}
@@ -0,0 +1,172 @@
+package jsplit
+
+import (
+	"context"
+	"sync"
+)
+
+// keyWriterQueueLen is the number of items which can be queued for a key being written concurrently before parsing
+// waits for them to be written
+const keyWriterQueueLen = 1024
+
+// keyWriter adds the items of a single key's list to a ListAddFunc and then finishes writing the key. When it is given
+// a semaphore the items are added by a goroutine, started once the first item is added, so that parsing can move on
+// to the following keys while they are written. Items are added in the order they were received. Without a semaphore
+// items are added as they are received and the key is finished by close.
+type keyWriter struct {
+	ctx    context.Context
+	cancel CancelWithErrFunc
+	sem    chan struct{}
+	add    ListAddFunc
+	finish func() error
+	budget *memoryBudget // the memory of the split the queued items are counted against
+	items  chan []byte
+	done   chan struct{}
+	err    error
+	closed bool
+}
+
+// newKeyWriter returns a *keyWriter passing items to add, and calling finish once every item has been added. If an
+// item can't be written, or the key can't be finished, cancel is called with the error so that the split stops. Items
+// which are queued are counted against budget until they have been added.
+func newKeyWriter(ctx context.Context, cancel CancelWithErrFunc, sem chan struct{}, add ListAddFunc, finish func() error,
+	budget *memoryBudget) *keyWriter {
+	return &keyWriter{ctx: ctx, cancel: cancel, sem: sem, add: add, finish: finish, budget: budget}
+}
+
+// Add queues the item to be written. Items are copied as the parser reuses the memory holding them.
+func (kw *keyWriter) Add(item []byte) error {
+	if kw.sem == nil {
+		return kw.add(item)
+	}
+
+	if kw.items == nil {
+		err := kw.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	kw.budget.enqueue(int64(len(item)))
+
+	select {
+	case kw.items <- append([]byte(nil), item...):
+		return nil
+	case <-kw.done:
+		kw.budget.dequeue(int64(len(item)))
+		return kw.err
+	case <-kw.ctx.Done():
+		kw.budget.dequeue(int64(len(item)))
+		return kw.ctx.Err()
+	}
+}
+
+// start waits until fewer keys than the semaphore allows are being written, and then starts writing this one
+func (kw *keyWriter) start() error {
+	select {
+	case kw.sem <- struct{}{}:
+	case <-kw.ctx.Done():
+		return kw.ctx.Err()
+	}
+
+	kw.items = make(chan []byte, keyWriterQueueLen)
+	kw.done = make(chan struct{})
+
+	go kw.run()
+
+	return nil
+}
+
+// run adds the queued items and then finishes the key
+func (kw *keyWriter) run() {
+	defer func() {
+		<-kw.sem
+		close(kw.done)
+	}()
+
+	for item := range kw.items {
+		err := kw.add(item)
+		kw.budget.dequeue(int64(len(item)))
+
+		if err != nil {
+			kw.fail(err)
+			return
+		}
+	}
+
+	err := kw.finish()
+	if err != nil {
+		kw.fail(err)
+	}
+}
+
+// fail records the error and stops the split
+func (kw *keyWriter) fail(err error) {
+	kw.err = err
+	kw.cancel(err)
+}
+
+// close is called once every item has been added. The key is finished immediately unless its items are being written
+// by a goroutine, in which case wait must be called to find out if writing the key succeeded.
+func (kw *keyWriter) close() error {
+	if kw.closed {
+		return nil
+	}
+
+	kw.closed = true
+
+	if kw.items == nil {
+		return kw.finish()
+	}
+
+	close(kw.items)
+
+	return nil
+}
+
+// stop stops writing the key without finishing it, after the split has failed. wait must still be called to wait for
+// the items already queued.
+func (kw *keyWriter) stop() {
+	if kw.items != nil && !kw.closed {
+		kw.closed = true
+		close(kw.items)
+	}
+}
+
+// wait waits until the key has been finished, returning any error writing it
+func (kw *keyWriter) wait() error {
+	if kw.done == nil {
+		return nil
+	}
+
+	<-kw.done
+
+	return kw.err
+}
+
+// firstError records the first error which stops a split, cancelling the split's context so that keys still being
+// written stop too. Later errors, which are usually caused by the cancellation, are ignored.
+type firstError struct {
+	mu     sync.Mutex
+	err    error
+	cancel context.CancelFunc
+}
+
+// set records err if no other error has been, and cancels the split
+func (fe *firstError) set(err error) {
+	fe.mu.Lock()
+	if fe.err == nil {
+		fe.err = err
+	}
+	fe.mu.Unlock()
+
+	fe.cancel()
+}
+
+// get returns the first error recorded
+func (fe *firstError) get() error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	return fe.err
+}
@@ -0,0 +1,193 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failure := &firstError{cancel: cancel}
+	sem := make(chan struct{}, 2)
+
+	var (
+		active    int32
+		maxActive int32
+		mu        sync.Mutex
+		written   = make(map[int][]string)
+		writers   []*keyWriter
+		finished  = make([]bool, 5)
+	)
+
+	for i := 0; i < 5; i++ {
+		i := i
+		add := func(item []byte) error {
+			mu.Lock()
+			written[i] = append(written[i], string(item))
+			mu.Unlock()
+
+			return nil
+		}
+
+		finish := func() error {
+			n := atomic.AddInt32(&active, 1)
+			for {
+				max := atomic.LoadInt32(&maxActive)
+				if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+					break
+				}
+			}
+
+			atomic.AddInt32(&active, -1)
+			finished[i] = true
+
+			return nil
+		}
+
+		kw := newKeyWriter(ctx, failure.set, sem, add, finish, nil)
+		writers = append(writers, kw)
+
+		// the item buffer is reused, as it is by the parser
+		item := make([]byte, 1)
+		for j := 0; j < 100; j++ {
+			item[0] = byte('a' + j%26)
+			require.NoError(t, kw.Add(item))
+		}
+
+		require.NoError(t, kw.close())
+	}
+
+	for _, kw := range writers {
+		require.NoError(t, kw.wait())
+	}
+
+	require.NoError(t, failure.get())
+	require.LessOrEqual(t, maxActive, int32(2))
+
+	for i := 0; i < 5; i++ {
+		require.True(t, finished[i])
+		require.Len(t, written[i], 100)
+
+		for j, item := range written[i] {
+			require.Equal(t, string(rune('a'+j%26)), item)
+		}
+	}
+}
+
+func TestKeyWriterError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failure := &firstError{cancel: cancel}
+	writeErr := errors.New("write failed")
+
+	kw := newKeyWriter(ctx, failure.set, make(chan struct{}, 1), func(item []byte) error {
+		return writeErr
+	}, func() error {
+		return nil
+	}, nil)
+
+	var err error
+	for i := 0; i < 10*keyWriterQueueLen && err == nil; i++ {
+		err = kw.Add([]byte("1"))
+	}
+
+	require.Error(t, err)
+	require.ErrorIs(t, failure.get(), writeErr)
+	require.ErrorIs(t, kw.wait(), writeErr)
+	require.Error(t, ctx.Err())
+
+	// later errors are ignored
+	failure.set(errors.New("context canceled"))
+	require.ErrorIs(t, failure.get(), writeErr)
+}
+
+func TestKeyWriterSerial(t *testing.T) {
+	var items []string
+
+	finished := false
+	kw := newKeyWriter(context.Background(), nil, nil, func(item []byte) error {
+		items = append(items, string(item))
+		return nil
+	}, func() error {
+		finished = true
+		return nil
+	}, nil)
+
+	require.NoError(t, kw.Add([]byte("1")))
+	require.NoError(t, kw.Add([]byte("2")))
+	require.Equal(t, []string{"1", "2"}, items)
+
+	require.NoError(t, kw.close())
+	require.True(t, finished)
+	require.NoError(t, kw.wait())
+}
+
+func TestSplitStreamConcurrency(t *testing.T) {
+	var sb strings.Builder
+
+	sb.WriteString(`{"key": "value"`)
+
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&sb, `, "list%d": [`, i)
+		for j := 0; j < 200; j++ {
+			if j > 0 {
+				sb.WriteString(", ")
+			}
+
+			fmt.Fprintf(&sb, `{"list": %d, "idx": %d}`, i, j)
+		}
+		sb.WriteString("]")
+	}
+
+	sb.WriteString("}")
+
+	serialDir := t.TempDir()
+	bs := NewTestByteStream([]byte(sb.String()), 64)
+	require.NoError(t, SplitStream(context.Background(), bs, serialDir, Options{MaxFileBytes: 1024, InferSchema: true}))
+
+	concurrentDir := t.TempDir()
+	bs = NewTestByteStream([]byte(sb.String()), 64)
+	require.NoError(t, SplitStream(context.Background(), bs, concurrentDir, Options{MaxFileBytes: 1024, InferSchema: true,
+		Concurrency: 3}))
+
+	// the output is the same however many lists are written at once, and the manifest lists keys in document order
+	require.Equal(t, readManifest(t, serialDir), readManifest(t, concurrentDir))
+
+	entries, err := os.ReadDir(serialDir)
+	require.NoError(t, err)
+	require.Greater(t, len(entries), 20)
+
+	for _, entry := range entries {
+		expected, err := os.ReadFile(filepath.Join(serialDir, entry.Name()))
+		require.NoError(t, err)
+
+		requireContents(t, filepath.Join(concurrentDir, entry.Name()), string(expected))
+	}
+
+	bs = NewTestByteStream([]byte(sb.String()), 64)
+	err = SplitStream(context.Background(), bs, t.TempDir(), Options{Concurrency: -1})
+	require.ErrorContains(t, err, "concurrency")
+}
+
+func TestSplitStreamConcurrencyWriteError(t *testing.T) {
+	var testStr = `{"a": [1, 2], "b": [3, 4], "c": [5]}`
+
+	// files can't be created in a directory which doesn't exist, which SplitStream would have created
+	dir := filepath.Join(t.TempDir(), "missing")
+
+	bs := NewTestByteStream([]byte(testStr), 4)
+	err := splitStream(context.Background(), bs, NewDirSink(context.Background(), dir), Options{Concurrency: 2})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,31 @@
+package jsplit
+
+import "errors"
+
+// errListLimit is returned by the ListAddFunc of a list once Options.Limit items have been added, so that the rest of
+// the list is skipped
+var errListLimit = errors.New("list limit reached")
+
+// limitItems returns a ListAddFunc passing the first opts.Limit items of a list to add, and returning errListLimit
+// once the last of them has been added. add is returned as is when opts.Limit isn't set.
+func limitItems(add ListAddFunc, opts Options) ListAddFunc {
+	if opts.Limit <= 0 {
+		return add
+	}
+
+	added := 0
+
+	return func(item []byte) error {
+		err := add(item)
+		if err != nil {
+			return err
+		}
+
+		added++
+		if added >= opts.Limit {
+			return errListLimit
+		}
+
+		return nil
+	}
+}
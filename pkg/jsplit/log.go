@@ -0,0 +1,98 @@
+package jsplit
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// currentLogger is the logger set with SetLogger, or the default one
+var currentLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger the messages printed while splitting are written to. Files and keys being finished are
+// logged at slog.LevelInfo, problems with the input which don't stop the split at slog.LevelWarn, and the chunks read
+// from the input and retried reads at slog.LevelDebug. Messages are formatted for reading, with the values they
+// mention also added as attributes, e.g. key and files, for handlers such as slog.JSONHandler. By default messages of
+// level info and above are written to standard output by a handler from NewMessageHandler. A nil logger restores the
+// default.
+func SetLogger(l *slog.Logger) {
+	currentLogger.Store(l)
+}
+
+// logger returns the logger messages are written to
+func logger() *slog.Logger {
+	if l := currentLogger.Load(); l != nil {
+		return l
+	}
+
+	return defaultLogger
+}
+
+// debugEnabled reports whether debug messages are logged, so that those logged for every chunk read are only formatted
+// when they are
+func debugEnabled() bool {
+	return logger().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// defaultLogger writes messages to standard output as jsplit always has
+var defaultLogger = slog.New(NewMessageHandler(nil, slog.LevelInfo))
+
+// messageHandler writes the message of each record on a line of its own, see NewMessageHandler
+type messageHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+}
+
+// NewMessageHandler returns a slog.Handler writing just the message of each record of at least the given level to w,
+// each on a line of its own, with warnings prefixed by "Warning: " and errors by "Error: ". Attributes are left out.
+// When w is nil messages are written to os.Stdout as it is when each one is written, so that they follow it if it is
+// redirected.
+func NewMessageHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return &messageHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+// Enabled reports whether records of the level are written
+func (mh *messageHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= mh.level.Level()
+}
+
+// Handle writes the record's message
+func (mh *messageHandler) Handle(_ context.Context, r slog.Record) error {
+	line := make([]byte, 0, len(r.Message)+10)
+
+	switch {
+	case r.Level >= slog.LevelError:
+		line = append(line, "Error: "...)
+	case r.Level >= slog.LevelWarn:
+		line = append(line, "Warning: "...)
+	}
+
+	line = append(line, r.Message...)
+	line = append(line, '\n')
+
+	w := mh.w
+	if w == nil {
+		w = os.Stdout
+	}
+
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	_, err := w.Write(line)
+
+	return err
+}
+
+// WithAttrs returns the handler, as attributes are left out
+func (mh *messageHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return mh
+}
+
+// WithGroup returns the handler, as attributes are left out
+func (mh *messageHandler) WithGroup(string) slog.Handler {
+	return mh
+}
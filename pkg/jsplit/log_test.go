@@ -0,0 +1,79 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := slog.New(NewMessageHandler(&buf, slog.LevelInfo)).With("ignored", 1)
+	l.Debug("left out")
+	l.Info("users written to 2 files", "key", "users")
+	l.Warn("a problem")
+	l.Error("a failure")
+
+	require.Equal(t, "users written to 2 files\nWarning: a problem\nError: a failure\n", buf.String())
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() {
+		SetLogger(nil)
+	})
+
+	input := []byte(`{"users": [{"id": 1}, {"id": 2}], "users": [{"id": 3}]}`)
+	rd, err := AsyncReaderFromReader(bytes.NewReader(input), 8)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, Split(ctx, rd, NewDirSink(ctx, t.TempDir()), Options{}))
+
+	var (
+		read     float64
+		messages = make(map[string]map[string]interface{})
+	)
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+
+		msg := record["msg"].(string)
+		if strings.HasPrefix(msg, "Read ") {
+			require.Equal(t, "DEBUG", record["level"])
+			read += record["bytes"].(float64)
+
+			continue
+		}
+
+		messages[msg] = record
+	}
+
+	// each chunk read is logged at debug level, and the values of messages are added as attributes
+	require.Equal(t, float64(len(input)), read)
+
+	written := messages["users written to 1 files"]
+	require.NotNil(t, written)
+	require.Equal(t, "INFO", written["level"])
+	require.Equal(t, "users", written["key"])
+	require.Equal(t, float64(1), written["files"])
+
+	duplicate := messages["users appears more than once in the document, the items of its lists are written to the "+
+		"same files"]
+	require.NotNil(t, duplicate)
+	require.Equal(t, "WARN", duplicate["level"])
+
+	// the default logger is restored with nil
+	SetLogger(nil)
+	require.Equal(t, defaultLogger, logger())
+	require.False(t, debugEnabled())
+}
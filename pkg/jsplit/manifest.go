@@ -0,0 +1,200 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// ManifestFilename is the name of the file describing the output of a split. It is written once every other output file
+// has been closed, so its presence signals that the split completed.
+const ManifestFilename = "manifest.json"
+
+// Manifest describes the files written when splitting a json document
+type Manifest struct {
+	// Root is the file the non-list values in the root of the document were written to. Records is the number of values
+	Root *ManifestFile `json:"root,omitempty"`
+
+	// Values lists the file each value in the root of the document which isn't a list was written to when
+	// Options.ValueFiles is set, in place of Root, in the order they were found
+	Values []ManifestKey `json:"values,omitempty"`
+
+	// Keys lists the files written for each list in the root of the document, or for each shard of a document which is
+	// a json array, in the order they were written
+	Keys []ManifestKey `json:"keys"`
+
+	// Rejects is the name of the file list items which couldn't be parsed were logged to when Options.SkipErrors is set
+	Rejects string `json:"rejects,omitempty"`
+
+	// Failed lists the files SplitFiles couldn't split when Options.FileErrors is FileErrorsBestEffort, in the order
+	// they were given
+	Failed []ManifestFailure `json:"failed,omitempty"`
+
+	// Collisions lists the keys, or the values of the field lists are partitioned by, whose slug had already been given
+	// to another key when Options.NormalizeNames is set, in the order they were found
+	Collisions []ManifestCollision `json:"collisions,omitempty"`
+}
+
+// ManifestCollision describes a key whose files were given Name, the Slug of the key with a numeric suffix, as the
+// files of the key With had already been given the slug
+type ManifestCollision struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	With string `json:"with"`
+}
+
+// ManifestFailure describes an input which couldn't be split, and the error which stopped it
+type ManifestFailure struct {
+	Input string `json:"input"`
+	Error string `json:"error"`
+}
+
+// ManifestKey describes the files written for a single key
+type ManifestKey struct {
+	Key     string         `json:"key"`
+	Files   []ManifestFile `json:"files"`
+	Records int64          `json:"records"`
+	Bytes   int64          `json:"bytes"`
+
+	// Schema is the name of the file describing the items of the list when Options.InferSchema is set
+	Schema string `json:"schema,omitempty"`
+
+	// BigQuerySchema is the name of the file holding the BigQuery schema of the list when Options.BigQuerySchema is
+	// set
+	BigQuerySchema string `json:"bq_schema,omitempty"`
+
+	// Rejected is the number of items of the list which were skipped because they couldn't be parsed
+	Rejected int64 `json:"rejected,omitempty"`
+
+	// Duplicates is the number of items of the list which were dropped because of Options.DedupField
+	Duplicates int64 `json:"duplicates,omitempty"`
+
+	// Filtered is the number of items of the list which were dropped because they didn't match Options.Filter, or
+	// weren't kept by Options.Since
+	Filtered int64 `json:"filtered,omitempty"`
+}
+
+// ManifestFile describes a single output file. Name is relative to the output directory, and Bytes is the size of the
+// file as written, after any compression. SHA256 is the hex encoded SHA-256 digest of the file as written when
+// Options.Checksums is set.
+type ManifestFile struct {
+	Name    string `json:"name"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+// addKey adds the files written for key by wr using files created by fileFactory. Keys without any files, those which
+// weren't lists or were empty lists, are left out and nil is returned.
+func (m *Manifest) addKey(key string, fileFactory *BufferedWriterFactory, wr *SplittingJsonlWriter) *ManifestKey {
+	files := fileFactory.Files()
+	if len(files) == 0 {
+		return nil
+	}
+
+	mk := ManifestKey{Key: key, Files: files}
+	for i, records := range wr.Records() {
+		mk.Files[i].Records = records
+		mk.Records += records
+		mk.Bytes += mk.Files[i].Bytes
+	}
+
+	m.Keys = append(m.Keys, mk)
+
+	return &m.Keys[len(m.Keys)-1]
+}
+
+// addValue records the file a value which isn't a list was written to, replacing the file of an earlier value of the
+// same key
+func (m *Manifest) addValue(key string, file ManifestFile) {
+	mv := ManifestKey{Key: key, Files: []ManifestFile{file}, Records: file.Records, Bytes: file.Bytes}
+
+	for i := range m.Values {
+		if m.Values[i].Key == key {
+			m.Values[i] = mv
+			return
+		}
+	}
+
+	m.Values = append(m.Values, mv)
+}
+
+// files returns every file listed in the manifest, root.json and the files of values first, followed by the files of
+// each key
+func (m *Manifest) files() []ManifestFile {
+	var files []ManifestFile
+
+	if m.Root != nil {
+		files = append(files, *m.Root)
+	}
+
+	for _, mv := range m.Values {
+		files = append(files, mv.Files...)
+	}
+
+	for _, mk := range m.Keys {
+		files = append(files, mk.Files...)
+	}
+
+	return files
+}
+
+// write writes the manifest to the sink, replacing the manifest of the split being appended to
+func (m *Manifest) write(sink OutputSink, opts Options) (string, error) {
+	if m.Keys == nil {
+		m.Keys = []ManifestKey{}
+	}
+
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return "", err
+	}
+
+	return replaceFile(sink, ManifestFilename, data, opts)
+}
+
+// writeManifest writes the manifest to the sink, or reports the files it lists when opts.DryRun is set
+func writeManifest(sink OutputSink, m *Manifest, opts Options) error {
+	if opts.DryRun {
+		return m.report(os.Stdout, sinkLocation(sink))
+	}
+
+	_, err := m.write(sink, opts)
+
+	return err
+}
+
+// report writes a table of the files listed in the manifest, and the totals for all of them, to w
+func (m *Manifest) report(w io.Writer, dir string) error {
+	files := m.files()
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(w, "\nDry run, no files were written. Splitting into %s would write:\n", dir)
+	fmt.Fprintln(tw, "file\trecords\tbytes")
+
+	var records, bytes int64
+
+	for _, file := range files {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", file.Name, file.Records, file.Bytes)
+
+		records += file.Records
+		bytes += file.Bytes
+	}
+
+	fmt.Fprintf(tw, "total (%d files)\t%d\t%d\n", len(files), records, bytes)
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, mf := range m.Failed {
+		fmt.Fprintf(w, "%s couldn't be split: %s\n", mf.Input, mf.Error)
+	}
+
+	return nil
+}
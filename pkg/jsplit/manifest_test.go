@@ -0,0 +1,144 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readManifest(t *testing.T, dir string) Manifest {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFilename))
+	require.NoError(t, err)
+
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+
+	return manifest
+}
+
+func fileSize(t *testing.T, filename string) int64 {
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+
+	return fi.Size()
+}
+
+func TestSplitStreamManifest(t *testing.T) {
+	var testStr = `{
+	"key": "value",
+	"list": [{"idx": 0}, {"idx": 1}, {"idx": 2}],
+	"empty": [],
+	"other": [1, 2],
+	"number": 17
+}`
+
+	for _, compress := range []bool{false, true} {
+		tempDir := t.TempDir()
+
+		bs := NewTestByteStream([]byte(testStr), 16)
+		err := SplitStream(context.Background(), bs, tempDir, Options{MaxFileBytes: 16, CompressOutput: compress})
+		require.NoError(t, err)
+
+		ext := compressedExt(compress)
+		listFiles := []ManifestFile{
+			{Name: "list_00.jsonl" + ext, Records: 2},
+			{Name: "list_01.jsonl" + ext, Records: 1},
+		}
+		otherFiles := []ManifestFile{{Name: "other_00.jsonl" + ext, Records: 2}}
+		root := &ManifestFile{Name: "root.json" + ext, Records: 2}
+
+		for _, files := range [][]ManifestFile{listFiles, otherFiles, {*root}} {
+			for i := range files {
+				files[i].Bytes = fileSize(t, filepath.Join(tempDir, files[i].Name))
+			}
+		}
+
+		root.Bytes = fileSize(t, filepath.Join(tempDir, root.Name))
+
+		expected := Manifest{
+			Root: root,
+			Keys: []ManifestKey{
+				{Key: "list", Files: listFiles, Records: 3, Bytes: listFiles[0].Bytes + listFiles[1].Bytes},
+				{Key: "other", Files: otherFiles, Records: 2, Bytes: otherFiles[0].Bytes},
+			},
+		}
+
+		require.Equal(t, expected, readManifest(t, tempDir))
+	}
+}
+
+func TestSplitStreamRootListManifest(t *testing.T) {
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(`[1, 2, 3]`), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{Shards: 2})
+	require.NoError(t, err)
+
+	expected := Manifest{
+		Keys: []ManifestKey{
+			{Key: "part-000", Files: []ManifestFile{{Name: "part-000_00.jsonl", Records: 2, Bytes: 3}}, Records: 2, Bytes: 3},
+			{Key: "part-001", Files: []ManifestFile{{Name: "part-001_00.jsonl", Records: 1, Bytes: 1}}, Records: 1, Bytes: 1},
+		},
+	}
+
+	require.Equal(t, expected, readManifest(t, tempDir))
+}
+
+func TestManifestReport(t *testing.T) {
+	m := Manifest{
+		Root: &ManifestFile{Name: "root.json", Records: 1, Bytes: 20},
+		Keys: []ManifestKey{
+			{Key: "list", Files: []ManifestFile{{Name: "list_00.jsonl", Records: 2, Bytes: 100}, {Name: "list_01.jsonl", Records: 1, Bytes: 50}}},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, m.report(buf, "out"))
+
+	expected := `
+Dry run, no files were written. Splitting into out would write:
+file             records  bytes
+root.json        1        20
+list_00.jsonl    2        100
+list_01.jsonl    1        50
+total (3 files)  4        170
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestSplitStreamDryRun(t *testing.T) {
+	var testStr = `{"key": "value", "list": [{"idx": 0}, {"idx": 1}, {"idx": 2}], "other": [{"a": 1}, {"a": 2}]}`
+
+	// the output directory is never created
+	dir := filepath.Join(t.TempDir(), "out")
+
+	for _, opts := range []Options{
+		{DryRun: true, MaxFileBytes: 16},
+		{DryRun: true, CompressOutput: true, InferSchema: true},
+		{DryRun: true, Format: FormatCSV, Concurrency: 2},
+	} {
+		bs := NewTestByteStream([]byte(testStr), 16)
+		err := SplitStream(context.Background(), bs, dir, opts)
+		require.NoError(t, err)
+
+		_, err = os.Stat(dir)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	bs := NewTestByteStream([]byte(`[1, 2, 3]`), 16)
+	err := SplitStream(context.Background(), bs, dir, Options{DryRun: true, Shards: 2})
+	require.NoError(t, err)
+
+	// documents which can't be parsed fail as they would if they were being split
+	bs = NewTestByteStream([]byte(`{"key": "value", "list": [{"idx": 0}, {"idx": 1]}`), 16)
+	err = SplitStream(context.Background(), bs, dir, Options{DryRun: true})
+	require.Error(t, err)
+
+	_, err = os.Stat(dir)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,68 @@
+package jsplit
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrTooManyFiles is returned when writing an item would create more output files than Options.MaxFiles allows
+var ErrTooManyFiles = errors.New("too many output files")
+
+// fileLimit counts the files of lists, shards and partitions created by a split, refusing to create more than
+// Options.MaxFiles. A nil *fileLimit counts nothing and allows every file.
+type fileLimit struct {
+	created int64 // accessed atomically so kept first to guarantee 64-bit alignment
+	limit   int64
+}
+
+// newFileLimit returns a *fileLimit allowing limit files to be created, or nil if limit is 0
+func newFileLimit(limit int) *fileLimit {
+	if limit == 0 {
+		return nil
+	}
+
+	return &fileLimit{limit: int64(limit)}
+}
+
+// create counts the file with the given name being created, or returns ErrTooManyFiles if it would be one too many
+func (fl *fileLimit) create(name string) error {
+	if fl == nil {
+		return nil
+	}
+
+	if atomic.AddInt64(&fl.created, 1) > fl.limit {
+		atomic.AddInt64(&fl.created, -1)
+
+		return fmt.Errorf("%w: creating %s would exceed the limit of %d files", ErrTooManyFiles, name, fl.limit)
+	}
+
+	return nil
+}
+
+// add counts n files created by an earlier split which is being carried on
+func (fl *fileLimit) add(n int) {
+	if fl == nil {
+		return
+	}
+
+	atomic.AddInt64(&fl.created, int64(n))
+}
+
+// allows reports whether n more files can be created
+func (fl *fileLimit) allows(n int) bool {
+	return fl == nil || atomic.LoadInt64(&fl.created)+int64(n) <= fl.limit
+}
+
+// withFileLimit returns the options with the limit enforcing opts.MaxFiles. The options are returned as they are when
+// MaxFiles isn't set or they already have a limit, so that the files split by SplitFiles or the entries of a zip
+// archive share one.
+func (opts Options) withFileLimit() Options {
+	if opts.MaxFiles == 0 || opts.files != nil {
+		return opts
+	}
+
+	opts.files = newFileLimit(opts.MaxFiles)
+
+	return opts
+}
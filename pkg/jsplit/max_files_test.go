@@ -0,0 +1,123 @@
+package jsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLimit(t *testing.T) {
+	fl := newFileLimit(2)
+	require.True(t, fl.allows(2))
+
+	require.NoError(t, fl.create("a_00.jsonl"))
+	require.NoError(t, fl.create("a_01.jsonl"))
+	require.False(t, fl.allows(1))
+
+	err := fl.create("b_00.jsonl")
+	require.ErrorIs(t, err, ErrTooManyFiles)
+	require.EqualError(t, err, "too many output files: creating b_00.jsonl would exceed the limit of 2 files")
+
+	// files from an earlier split count towards the limit
+	fl = newFileLimit(3)
+	fl.add(3)
+	require.ErrorIs(t, fl.create("c_03.jsonl"), ErrTooManyFiles)
+
+	// a nil limit counts nothing and allows everything
+	var nilLimit *fileLimit
+	nilLimit.add(10)
+	require.NoError(t, nilLimit.create("a_00.jsonl"))
+	require.True(t, nilLimit.allows(1<<30))
+
+	require.Nil(t, newFileLimit(0))
+
+	opts := Options{MaxFiles: 5}.withFileLimit()
+	require.NotNil(t, opts.files)
+	require.Equal(t, opts.files, opts.withFileLimit().files)
+}
+
+func TestSplitStreamMaxFiles(t *testing.T) {
+	const doc = `{"a": [{"v": 1}, {"v": 2}, {"v": 3}], "b": [{"v": 4}], "c": [{"v": 5}]}`
+
+	split := func(doc string, opts Options) (string, error) {
+		tempDir := t.TempDir()
+		return tempDir, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir, opts)
+	}
+
+	tempDir, err := split(doc, Options{MaxFiles: 3})
+	require.NoError(t, err)
+	require.Len(t, readManifest(t, tempDir).Keys, 3)
+
+	// the file which would be one too many isn't created
+	tempDir, err = split(doc, Options{MaxFiles: 2})
+	require.ErrorIs(t, err, ErrTooManyFiles)
+	require.ErrorContains(t, err, "creating c_00.jsonl would exceed the limit of 2 files")
+	require.NoFileExists(t, filepath.Join(tempDir, "c_00.jsonl"))
+
+	// files rolled over count too
+	_, err = split(doc, Options{MaxFiles: 3, MaxFileBytes: 5})
+	require.ErrorContains(t, err, "creating b_00.jsonl would exceed the limit of 3 files")
+
+	// the field and value of a partition which reaches the limit are named
+	const elements = `[{"k": "x"}, {"k": "y"}, {"k": "x"}, {"k": "z"}, {"k": "w"}]`
+
+	_, err = split(elements, Options{PartitionBy: "k", MaxFiles: 2})
+	require.ErrorIs(t, err, ErrTooManyFiles)
+	require.EqualError(t, err, "partitioning by k, the value z: too many output files: creating z_00.jsonl would "+
+		"exceed the limit of 2 files")
+
+	_, err = split(`[{"ts": "2024-01-01"}, {"ts": "2024-01-02"}]`, Options{PartitionByDate: "ts", MaxFiles: 1})
+	require.EqualError(t, err, "partitioning by ts, the value dt=2024-01-02: too many output files: creating "+
+		"dt=2024-01-02/root_00.jsonl would exceed the limit of 1 files")
+
+	_, err = split(doc, Options{MaxFiles: -1})
+	require.EqualError(t, err, "max files must not be negative, got -1")
+}
+
+func TestSplitStreamOverflowPartition(t *testing.T) {
+	const elements = `[{"k": "x"}, {"k": "y"}, {"k": "x"}, {"k": "z"}, {"k": "w"}, {"k": "y"}]`
+
+	// new values are written to the overflow partition once starting their own would leave no room for it
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(elements), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir,
+		Options{PartitionBy: "k", MaxFiles: 3, OverflowPartition: "_other"}))
+
+	requireContents(t, filepath.Join(tempDir, "x_00.jsonl"), `{"k":"x"}`+"\n"+`{"k":"x"}`)
+	requireContents(t, filepath.Join(tempDir, "y_00.jsonl"), `{"k":"y"}`+"\n"+`{"k":"y"}`)
+	requireContents(t, filepath.Join(tempDir, "_other_00.jsonl"), `{"k":"z"}`+"\n"+`{"k":"w"}`)
+	require.NoFileExists(t, filepath.Join(tempDir, "z_00.jsonl"))
+
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 4, "the files and the manifest")
+
+	// and the dates of date partitions to its directory
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(`[{"ts": "2024-01-01"}, {"ts": "2024-01-02"}, {"ts": "2024-01-03"}]`), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir,
+		Options{PartitionByDate: "ts", MaxFiles: 2, OverflowPartition: "other"}))
+
+	requireContents(t, filepath.Join(tempDir, "dt=2024-01-01", "root_00.jsonl"), `{"ts":"2024-01-01"}`)
+	requireContents(t, filepath.Join(tempDir, "dt=other", "root_00.jsonl"),
+		`{"ts":"2024-01-02"}`+"\n"+`{"ts":"2024-01-03"}`)
+
+	// the partitions still can't need more files than the limit as they fill up
+	bs = NewTestByteStream([]byte(elements), 8)
+	err = SplitStream(context.Background(), bs, t.TempDir(),
+		Options{PartitionBy: "k", MaxFiles: 3, OverflowPartition: "_other", MaxFileBytes: 5})
+	require.ErrorIs(t, err, ErrTooManyFiles)
+
+	for _, opts := range []Options{{OverflowPartition: "_other", PartitionBy: "k"}, {OverflowPartition: "_other",
+		MaxFiles: 3}} {
+		err = SplitStream(context.Background(), NewTestByteStream([]byte(elements), 8), t.TempDir(), opts)
+		require.EqualError(t, err, "an overflow partition can only be used with max files and partitions")
+	}
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(elements), 8), t.TempDir(),
+		Options{PartitionBy: "k", MaxFiles: 1, OverflowPartition: "_other"})
+	require.EqualError(t, err, "max files must leave room for a partition besides the overflow partition, got 1")
+}
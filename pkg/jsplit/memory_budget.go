@@ -0,0 +1,158 @@
+package jsplit
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// memoryBudget counts the bytes held in memory by a split, see Options.MaxMemory: the chunks its AsyncReaders have
+// queued to be parsed and the items queued for keys written concurrently, which are freed as parsing and writing catch
+// up, and the buffers of the files open for writing, which are held until the files are closed. Only reading can be
+// held back, so readers pause while the bytes counted are over the limit, until nothing queued is left to free. A nil
+// *memoryBudget counts nothing.
+type memoryBudget struct {
+	used   int64 // accessed atomically so kept first to guarantee 64-bit alignment
+	queued int64 // the part of used which is queued, accessed atomically
+	peak   int64 // the most bytes which have been counted at once, accessed atomically
+	limit  int64
+	mu     sync.Mutex
+	freed  chan struct{} // closed when bytes are released, waking every paused reader, created by released
+}
+
+// newMemoryBudget returns a *memoryBudget over which reading pauses once limit bytes are held, or nil if limit is 0
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit == 0 {
+		return nil
+	}
+
+	return &memoryBudget{limit: limit}
+}
+
+// reserve counts a buffer of n bytes being held until it is released
+func (mb *memoryBudget) reserve(n int64) {
+	if mb == nil {
+		return
+	}
+
+	mb.add(n)
+}
+
+// release counts a buffer of n bytes which was reserved being freed
+func (mb *memoryBudget) release(n int64) {
+	if mb == nil {
+		return
+	}
+
+	mb.add(-n)
+	mb.wake()
+}
+
+// enqueue counts n bytes being queued, to be freed with dequeue once they have been parsed or written
+func (mb *memoryBudget) enqueue(n int64) {
+	if mb == nil {
+		return
+	}
+
+	atomic.AddInt64(&mb.queued, n)
+	mb.add(n)
+}
+
+// dequeue counts n bytes which were queued being freed
+func (mb *memoryBudget) dequeue(n int64) {
+	if mb == nil {
+		return
+	}
+
+	atomic.AddInt64(&mb.queued, -n)
+	mb.add(-n)
+	mb.wake()
+}
+
+// add adds n to the bytes held, raising the peak if more are held than before
+func (mb *memoryBudget) add(n int64) {
+	used := atomic.AddInt64(&mb.used, n)
+
+	for {
+		peak := atomic.LoadInt64(&mb.peak)
+		if used <= peak || atomic.CompareAndSwapInt64(&mb.peak, peak, used) {
+			return
+		}
+	}
+}
+
+// wake wakes the readers waiting for bytes to be freed
+func (mb *memoryBudget) wake() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.freed != nil {
+		close(mb.freed)
+		mb.freed = nil
+	}
+}
+
+// allows reports whether n more bytes can be read, either because they fit within the limit or because nothing queued
+// is left to be freed, in which case only reading more can free the buffers which are held
+func (mb *memoryBudget) allows(n int64) bool {
+	return mb == nil || atomic.LoadInt64(&mb.used)+n <= mb.limit || atomic.LoadInt64(&mb.queued) == 0
+}
+
+// released returns a channel closed the next time bytes are freed, which is nil, and so never ready, for a nil budget.
+// It should be called before checking allows, so that bytes freed in between aren't missed.
+func (mb *memoryBudget) released() <-chan struct{} {
+	if mb == nil {
+		return nil
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.freed == nil {
+		mb.freed = make(chan struct{})
+	}
+
+	return mb.freed
+}
+
+// MemoryBytes returns the number of bytes currently held
+func (mb *memoryBudget) MemoryBytes() int64 {
+	if mb == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&mb.used)
+}
+
+// PeakMemoryBytes returns the most bytes which have been held at once
+func (mb *memoryBudget) PeakMemoryBytes() int64 {
+	if mb == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&mb.peak)
+}
+
+// withMemoryBudget returns the options with the budget enforcing opts.MaxMemory, which AsyncReaders created with
+// opts.ReaderOptions are also bound by. The options are returned as they are when MaxMemory isn't set or they already
+// have a budget, so that the files split by SplitFiles or the entries of a zip archive share one.
+func (opts Options) withMemoryBudget() Options {
+	if opts.MaxMemory == 0 || opts.budget != nil {
+		return opts
+	}
+
+	opts.budget = newMemoryBudget(opts.MaxMemory)
+
+	readerOpts := make([]AsyncReaderOption, 0, len(opts.ReaderOptions)+1)
+	readerOpts = append(readerOpts, opts.ReaderOptions...)
+	opts.ReaderOptions = append(readerOpts, withMemoryBudget(opts.budget))
+
+	return opts
+}
+
+// withMemoryBudget bounds the bytes the reader queues by the budget, along with everything else counted against it
+func withMemoryBudget(budget *memoryBudget) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		afr.budget = budget
+		return nil
+	}
+}
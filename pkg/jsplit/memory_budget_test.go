@@ -0,0 +1,142 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	mb := newMemoryBudget(100)
+
+	mb.reserve(60)
+	require.True(t, mb.allows(40))
+	require.True(t, mb.allows(50), "nothing queued is left to free the buffer")
+
+	mb.enqueue(30)
+	require.False(t, mb.allows(20))
+	require.True(t, mb.allows(10))
+	require.Equal(t, int64(90), mb.MemoryBytes())
+
+	// readers waiting for bytes to be freed are all woken
+	released := mb.released()
+	mb.dequeue(30)
+
+	select {
+	case <-released:
+	default:
+		require.Fail(t, "released wasn't closed")
+	}
+
+	mb.release(60)
+	require.Zero(t, mb.MemoryBytes())
+	require.Equal(t, int64(90), mb.PeakMemoryBytes())
+
+	// a nil budget counts nothing and allows everything
+	var nilBudget *memoryBudget
+	nilBudget.reserve(10)
+	nilBudget.enqueue(10)
+	require.True(t, nilBudget.allows(1<<40))
+	require.Nil(t, nilBudget.released())
+	require.Zero(t, nilBudget.PeakMemoryBytes())
+
+	require.Nil(t, newMemoryBudget(0))
+
+	// the options get a budget, bounding their readers, once
+	opts := Options{MaxMemory: 100, ReaderOptions: []AsyncReaderOption{WithQueueDepth(2)}}
+	budgeted := opts.withMemoryBudget()
+	require.NotNil(t, budgeted.budget)
+	require.Len(t, budgeted.ReaderOptions, 2)
+	require.Len(t, opts.ReaderOptions, 1)
+	require.Equal(t, budgeted.budget, budgeted.withMemoryBudget().budget)
+}
+
+// slowSink is an OutputSink whose files take a while to write, so that a split holding items for the files in memory
+// gets ahead
+type slowSink struct {
+	*memSink
+	delay time.Duration
+}
+
+func (ss *slowSink) OpenKey(name string) (io.WriteCloser, error) {
+	w, err := ss.memSink.OpenKey(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &slowWriter{WriteCloser: w, delay: ss.delay}, nil
+}
+
+type slowWriter struct {
+	io.WriteCloser
+	delay time.Duration
+}
+
+func (sw *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(sw.delay)
+	return sw.WriteCloser.Write(p)
+}
+
+func TestSplitMaxMemory(t *testing.T) {
+	var items []string
+	for i := 0; i < 3000; i++ {
+		items = append(items, fmt.Sprintf(`{"item":%d,"value":"abcdef"}`, i))
+	}
+
+	doc := `{"items": [` + strings.Join(items, ",") + `]}`
+
+	split := func(maxMemory int64) Stats {
+		rd, err := AsyncReaderFromReader(strings.NewReader(doc), 64)
+		require.NoError(t, err)
+		defer rd.Close()
+
+		var last Stats
+
+		sink := &slowSink{memSink: newMemSink(), delay: 2 * time.Millisecond}
+		opts := Options{
+			Concurrency:   2,
+			MaxFileBytes:  2000,
+			MaxMemory:     maxMemory,
+			StatsInterval: time.Hour,
+			Stats:         func(stats Stats) { last = stats },
+		}
+
+		require.NoError(t, Split(context.Background(), rd, sink, opts))
+
+		var written []string
+		for i := 0; ; i++ {
+			contents := sink.contents(fmt.Sprintf("items_%02d.jsonl", i))
+			if contents == "<missing>" {
+				break
+			}
+
+			written = append(written, strings.Split(contents, "\n")...)
+		}
+
+		require.Equal(t, items, written)
+
+		return last
+	}
+
+	// everything held is freed by the end of the split, but the items queued for the slow sink were counted
+	limit := int64(256*1024 + 2048)
+
+	unbounded := split(1 << 40)
+	require.Zero(t, unbounded.MemoryBytes)
+	require.Greater(t, unbounded.PeakMemoryBytes, limit+1024)
+
+	// with a limit, reading waits for the queued items to be written. Only items parsed from a chunk which was read
+	// within the limit can take it over.
+	bounded := split(limit)
+	require.Zero(t, bounded.MemoryBytes)
+	require.Greater(t, bounded.PeakMemoryBytes, int64(256*1024))
+	require.LessOrEqual(t, bounded.PeakMemoryBytes, limit+1024)
+
+	require.EqualError(t, Split(context.Background(), nil, newMemSink(), Options{MaxMemory: -1}),
+		"max memory must not be negative, got -1")
+}
@@ -0,0 +1,190 @@
+package jsplit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackWriteCloser receives jsonl formatted data, one json value per line, and writes each value to an
+// io.WriteCloser as a MessagePack record. Each record is framed by its length, as a 4 byte big-endian unsigned
+// integer, followed by that many bytes of MessagePack, so that the records of a file can be read one at a time without
+// decoding them, and a file which was cut short can be told apart from one which is complete.
+//
+// Objects are encoded as maps and arrays as arrays, keeping their order, strings as str, and numbers as the smallest
+// int or uint which holds them when they are integers, and as float64 otherwise, including integers too large for a
+// uint64. Values are encoded with github.com/vmihailenco/msgpack.
+type MsgpackWriteCloser struct {
+	wr   io.WriteCloser
+	line []byte // the part of the line being written which hasn't been encoded yet
+	buf  *bytes.Buffer
+	enc  *msgpack.Encoder // encodes the record being written to buf, which is reused for each record
+}
+
+// NewMsgpackWriteCloser returns a MsgpackWriteCloser which writes MessagePack records to the supplied io.WriteCloser
+func NewMsgpackWriteCloser(wr io.WriteCloser) *MsgpackWriteCloser {
+	buf := &bytes.Buffer{}
+	return &MsgpackWriteCloser{wr: wr, buf: buf, enc: msgpack.NewEncoder(buf)}
+}
+
+// Write encodes every complete line of p, and holds on to the rest until the line is completed by a later Write or
+// by Close. Blank lines are skipped.
+func (mwc *MsgpackWriteCloser) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			mwc.line = append(mwc.line, p...)
+			break
+		}
+
+		line := p[:i]
+		if len(mwc.line) > 0 {
+			mwc.line = append(mwc.line, line...)
+			line = mwc.line
+		}
+
+		err := mwc.writeRecord(line)
+		if err != nil {
+			return 0, err
+		}
+
+		mwc.line = mwc.line[:0]
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+// writeRecord writes the json value on a line as a length prefixed MessagePack record
+func (mwc *MsgpackWriteCloser) writeRecord(line []byte) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+
+	// room for the length, which is filled in once the record has been encoded
+	mwc.buf.Reset()
+	mwc.buf.Write([]byte{0, 0, 0, 0})
+
+	err := encodeMsgpack(mwc.enc, line)
+	if err != nil {
+		return fmt.Errorf("unable to encode %s as msgpack: %w", abbreviate(line), err)
+	}
+
+	buf := mwc.buf.Bytes()
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+
+	_, err = mwc.wr.Write(buf)
+
+	return err
+}
+
+// Sync syncs the supplied io.WriteCloser to disk if it can be, see BufferedWriteCloser.Sync. The records of the lines
+// written so far have already been written to it.
+func (mwc *MsgpackWriteCloser) Sync() error {
+	return syncWriter(mwc.wr)
+}
+
+// Close encodes the last line if it didn't end in a newline, and closes the supplied io.WriteCloser, which is closed
+// even if the line can't be encoded
+func (mwc *MsgpackWriteCloser) Close() error {
+	err := mwc.writeRecord(mwc.line)
+	mwc.line = nil
+
+	closeErr := mwc.wr.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// encodeMsgpack encodes the json value val with enc, objects with their keys in the order they appear in
+func encodeMsgpack(enc *msgpack.Encoder, val json.RawMessage) error {
+	val = bytes.TrimSpace(val)
+	if len(val) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	switch val[0] {
+	case OpenCB:
+		fields, err := decodeObject(val)
+		if err != nil {
+			return err
+		}
+
+		err = enc.EncodeMapLen(len(fields))
+
+		for _, field := range fields {
+			if err == nil {
+				err = enc.EncodeString(field.Key)
+			}
+
+			if err == nil {
+				err = encodeMsgpack(enc, field.Value)
+			}
+		}
+
+		return err
+	case OpenSB:
+		var elems []json.RawMessage
+
+		err := json.Unmarshal(val, &elems)
+		if err == nil {
+			err = enc.EncodeArrayLen(len(elems))
+		}
+
+		for _, elem := range elems {
+			if err == nil {
+				err = encodeMsgpack(enc, elem)
+			}
+		}
+
+		return err
+	case QM:
+		var s string
+
+		err := json.Unmarshal(val, &s)
+		if err != nil {
+			return err
+		}
+
+		return enc.EncodeString(s)
+	}
+
+	switch {
+	case string(val) == "null":
+		return enc.EncodeNil()
+	case string(val) == "true" || string(val) == "false":
+		return enc.EncodeBool(val[0] == 't')
+	case !json.Valid(val):
+		return fmt.Errorf("invalid json value %s", abbreviate(val))
+	}
+
+	return encodeMsgpackNumber(enc, string(val))
+}
+
+// encodeMsgpackNumber encodes the smallest MessagePack int or uint holding the number if it is an integer, and the
+// float64 nearest to it otherwise
+func encodeMsgpackNumber(enc *msgpack.Encoder, num string) error {
+	if i, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return enc.EncodeInt(i)
+	}
+
+	if u, err := strconv.ParseUint(num, 10, 64); err == nil {
+		return enc.EncodeUint(u)
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return err
+	}
+
+	return enc.EncodeFloat64(f)
+}
@@ -0,0 +1,193 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// readMsgpackRecords decodes the length prefixed MessagePack records of data back to compact json, one per record,
+// with github.com/vmihailenco/msgpack
+func readMsgpackRecords(t *testing.T, data []byte) []string {
+	var records []string
+
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), 4, "truncated record length")
+
+		n := int(binary.BigEndian.Uint32(data))
+		require.GreaterOrEqual(t, len(data)-4, n, "truncated record")
+
+		var sb strings.Builder
+
+		rd := bytes.NewReader(data[4 : 4+n])
+		decodeMsgpack(t, msgpack.NewDecoder(rd), &sb)
+		require.Zero(t, rd.Len(), "record holds more than one value")
+
+		records = append(records, sb.String())
+		data = data[4+n:]
+	}
+
+	return records
+}
+
+// decodeMsgpack writes the next MessagePack value of dec to sb as json, keeping the order of the keys of maps
+func decodeMsgpack(t *testing.T, dec *msgpack.Decoder, sb *strings.Builder) {
+	code, err := dec.PeekCode()
+	require.NoError(t, err)
+
+	switch {
+	case msgpcode.IsFixedMap(code) || code == msgpcode.Map16 || code == msgpcode.Map32:
+		n, err := dec.DecodeMapLen()
+		require.NoError(t, err)
+
+		sb.WriteString("{")
+
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+
+			decodeMsgpack(t, dec, sb)
+			sb.WriteString(":")
+			decodeMsgpack(t, dec, sb)
+		}
+
+		sb.WriteString("}")
+	case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+		n, err := dec.DecodeArrayLen()
+		require.NoError(t, err)
+
+		sb.WriteString("[")
+
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+
+			decodeMsgpack(t, dec, sb)
+		}
+
+		sb.WriteString("]")
+	default:
+		v, err := dec.DecodeInterface()
+		require.NoError(t, err)
+
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+
+		sb.Write(data)
+	}
+}
+
+func TestMsgpackWriteCloser(t *testing.T) {
+	buf := NewBufWriteCloser()
+	mwc := NewMsgpackWriteCloser(buf)
+
+	// lines can be split across writes, and the last doesn't need a newline
+	_, err := mwc.Write([]byte("{\"id\":1,\"name\":\"al"))
+	require.NoError(t, err)
+	_, err = mwc.Write([]byte("ex\"}\n\n[1,-2,null,true,false]\n\"x\""))
+	require.NoError(t, err)
+	require.NoError(t, mwc.Close())
+
+	require.Equal(t, []string{`{"id":1,"name":"alex"}`, `[1,-2,null,true,false]`, `"x"`},
+		readMsgpackRecords(t, buf.Bytes()))
+
+	// each record is framed by its length
+	require.Equal(t, []byte{0, 0, 0, 15, 0x82, 0xa2, 'i', 'd', 1, 0xa4, 'n', 'a', 'm', 'e', 0xa4, 'a', 'l', 'e', 'x'},
+		buf.Bytes()[:19])
+
+	// lines which aren't json aren't written
+	mwc = NewMsgpackWriteCloser(NewBufWriteCloser())
+	_, err = mwc.Write([]byte("{\"id\":1\n"))
+	require.ErrorContains(t, err, "unable to encode {\"id\":1 as msgpack")
+
+	_, err = NewMsgpackWriteCloser(NewBufWriteCloser()).Write([]byte("{\"n\":NaN}\n"))
+	require.ErrorContains(t, err, "unable to encode {\"n\":NaN} as msgpack")
+}
+
+func TestSplitStreamMsgpack(t *testing.T) {
+	var users []string
+	for i := 0; i < 40; i++ {
+		users = append(users, fmt.Sprintf(`{"id":%d,"name":"user %d","score":%d.5,"tags":["a",{"b":[1,-%d]}]}`,
+			i, i, i*1000, (i+1)*100000))
+	}
+
+	// values which need the wider encodings of ints, uints, floats, strings, arrays and maps
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf(`"k%02d":%d`, i, i))
+	}
+
+	users = append(users,
+		`{"big":9007199254740993,"max":18446744073709551615,"min":-9223372036854775808,"huge":1e+300}`,
+		`{"neg":[-1,-32,-33,-128,-129,-32768,-32769,-2147483648,-2147483649]}`,
+		`{"pos":[127,128,255,256,65535,65536,4294967295,4294967296]}`,
+		`{"long":"`+strings.Repeat("x", 40)+`","longer":"`+strings.Repeat("y", 300)+`","empty":""}`,
+		`{"list":[`+strings.TrimSuffix(strings.Repeat("0,", 20), ",")+`],"nested":{"a":{"b":{"c":[]}}}}`,
+		`{`+strings.Join(keys, ",")+`}`,
+		`null`, `"text"`, `[]`, `{}`)
+
+	doc := `{"name": "export", "users": [` + strings.Join(users, ", ") + `]}`
+
+	for name, opts := range map[string]Options{
+		"single": {Format: FormatMsgpack},
+		"rolled": {Format: FormatMsgpack, MaxFileBytes: 512},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 64), tempDir, opts))
+
+			// decoding the records of every file gives back the original items, in order
+			m := readManifest(t, tempDir)
+			if opts.MaxFileBytes > 0 {
+				require.Greater(t, len(m.Keys[0].Files), 1)
+			}
+
+			var records []string
+
+			for _, file := range m.Keys[0].Files {
+				require.True(t, strings.HasSuffix(file.Name, ".msgpack"), file.Name)
+
+				data, err := os.ReadFile(filepath.Join(tempDir, file.Name))
+				require.NoError(t, err)
+
+				records = append(records, readMsgpackRecords(t, data)...)
+			}
+
+			require.Equal(t, users, records)
+			requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"name\":\"export\"\n}")
+		})
+	}
+}
+
+func TestSplitStreamMsgpackOptions(t *testing.T) {
+	const doc = `{"users": [{"id": 1}], "events": [{"id": 2}]}`
+
+	tempDir := t.TempDir()
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{Format: FormatMsgpack, Pretty: true})
+	require.EqualError(t, err, "pretty output can't be written as msgpack")
+
+	// the lists of one key can be written as msgpack
+	tempDir = filepath.Join(t.TempDir(), "x")
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{KeyFormats: map[string]Format{"events": FormatMsgpack}}))
+
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1}`)
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "events_00.msgpack"))
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"id":2}`}, readMsgpackRecords(t, data))
+	require.True(t, bytes.HasPrefix(data, []byte{0, 0, 0, 5}))
+}
@@ -0,0 +1,160 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+)
+
+// AsyncReaderFromFiles creates an AsyncReader which reads each of the files in order as one continuous stream. Files
+// can be local files, http(s) URLs or cloud storage URIs, and each is decompressed independently. A file is only
+// opened once the one before it has been read and closed. The total size of the files isn't known.
+func AsyncReaderFromFiles(filenames []string, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("no files to read")
+	}
+
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return afr.attachFiles(filenames), nil
+}
+
+// AsyncReaderFromCloudPrefix creates an AsyncReader which reads every cloud storage object matching uri as one
+// continuous stream, in lexicographic order of their keys. uri either ends in / to read every object under a prefix,
+// e.g. gs://bucket/exports/, or has a key containing a glob pattern, e.g. gs://bucket/exports/part-*.json.gz.
+func AsyncReaderFromCloudPrefix(uri string, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	afr.useGCSBufferSize(uri)
+
+	// the bucket opened to list the objects is reused to read them
+	uris, err := afr.cloudClient().ListObjects(context.TODO(), uri)
+	if err != nil {
+		afr.closeCloud()
+		return nil, err
+	}
+
+	return afr.attachFiles(uris), nil
+}
+
+// attachFiles sets the files as the source of the AsyncReader, to be read one after another
+func (afr *AsyncReader) attachFiles(filenames []string) *AsyncReader {
+	// requests for http sources are aborted along with the AsyncReader
+	ctx, cancel := context.WithCancel(context.Background())
+
+	open := func(uri string) (io.ReadCloser, error) {
+		var (
+			r   io.ReadCloser
+			err error
+		)
+
+		switch {
+		case isUnixSocket(uri):
+			r, err = dialUnixSocket(ctx, strings.TrimPrefix(uri, unixSocketScheme))
+		case isSSHPath(uri):
+			r, _, err = openSSHPath(ctx, uri)
+		case strings.HasPrefix(uri, "http") && !cloud.IsAzureBlobURI(uri):
+			var resp *http.Response
+			resp, err = httpGet(ctx, http.DefaultClient, uri)
+			if resp != nil {
+				r = newResumableReader(resp.Body, httpResume(ctx, http.DefaultClient, uri, resp), afr.retry)
+			}
+		case cloud.IsCloudURI(uri):
+			r, _, _, err = afr.openCloudObject(ctx, uri)
+		default:
+			r, _, err = openLocalFile(uri)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return decompressSource(r, afr.readAs)
+	}
+
+	mr := &multiReader{names: filenames, open: open}
+
+	afr.rd = mr
+	afr.closers = []io.Closer{mr, closerFunc(func() error {
+		cancel()
+		return nil
+	})}
+	afr.abort = cancel
+
+	return afr
+}
+
+// multiReader reads a sequence of sources one after another, opening each when the previous one has been read and
+// closed. Errors name the source they came from.
+type multiReader struct {
+	names []string
+	open  func(name string) (io.ReadCloser, error)
+	cur   io.ReadCloser
+	idx   int // index of the next source to open
+}
+
+// Read reads from the current source, moving on to the next when it is exhausted
+func (mr *multiReader) Read(p []byte) (int, error) {
+	for {
+		if mr.cur == nil {
+			if mr.idx == len(mr.names) {
+				return 0, io.EOF
+			}
+
+			r, err := mr.open(mr.names[mr.idx])
+			if err != nil {
+				return 0, fmt.Errorf("unable to open %s: %w", mr.names[mr.idx], err)
+			}
+
+			mr.cur = r
+			mr.idx++
+		}
+
+		name := mr.names[mr.idx-1]
+
+		n, err := mr.cur.Read(p)
+		if err == io.EOF {
+			err = mr.cur.Close()
+			mr.cur = nil
+
+			if err != nil {
+				return n, fmt.Errorf("unable to close %s: %w", name, err)
+			}
+
+			if n == 0 {
+				continue
+			}
+
+			return n, nil
+		}
+
+		if err != nil {
+			return n, fmt.Errorf("unable to read %s: %w", name, err)
+		}
+
+		return n, nil
+	}
+}
+
+// Close closes the current source if there is one
+func (mr *multiReader) Close() error {
+	if mr.cur == nil {
+		return nil
+	}
+
+	err := mr.cur.Close()
+	mr.cur = nil
+
+	return err
+}
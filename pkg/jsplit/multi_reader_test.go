@@ -0,0 +1,122 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncReaderFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	parts := []string{`{"list": [1, `, `2, 3`, `], "key": "value"}`}
+
+	var filenames []string
+	for i, part := range parts {
+		data := []byte(part)
+		name := filepath.Join(dir, fmt.Sprintf("part-%04d.json", i))
+
+		// mix compressed and uncompressed parts, each is decompressed independently
+		if i%2 == 0 {
+			data = gzipBytes(t, data)
+			name += ".gz"
+		}
+
+		require.NoError(t, os.WriteFile(name, data, 0o600))
+		filenames = append(filenames, name)
+	}
+
+	rd, err := AsyncReaderFromFiles(filenames, 4)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, parts[0]+parts[1]+parts[2], string(readAll(t, ctx, rd)))
+	require.True(t, rd.IsClosed())
+
+	_, err = AsyncReaderFromFiles(nil, 4)
+	require.Error(t, err)
+}
+
+func TestAsyncReaderFromFilesMissing(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "present.json")
+	missing := filepath.Join(dir, "missing.json")
+	require.NoError(t, os.WriteFile(present, []byte(`{"list": [`), 0o600))
+
+	rd, err := AsyncReaderFromFiles([]string{present, missing}, 1024)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+
+	for err == nil {
+		_, err = rd.Read(ctx)
+	}
+
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Contains(t, err.Error(), missing)
+}
+
+type trackedSource struct {
+	io.Reader
+	closed bool
+}
+
+func (ts *trackedSource) Close() error {
+	ts.closed = true
+	return nil
+}
+
+func TestMultiReader(t *testing.T) {
+	sources := map[string]*trackedSource{}
+	var opened []string
+
+	mr := &multiReader{
+		names: []string{"a", "empty", "b", "bad"},
+		open: func(name string) (io.ReadCloser, error) {
+			// each source is closed before the next one is opened
+			for _, prev := range opened {
+				require.True(t, sources[prev].closed, prev)
+			}
+
+			if name == "bad" {
+				return nil, errors.New("failed")
+			}
+
+			opened = append(opened, name)
+			sources[name] = &trackedSource{Reader: bytes.NewReader([]byte(map[string]string{"a": "aaa", "b": "bb"}[name]))}
+
+			return sources[name], nil
+		},
+	}
+
+	data, err := io.ReadAll(mr)
+	require.Equal(t, "aaabb", string(data))
+	require.EqualError(t, err, "unable to open bad: failed")
+	require.Equal(t, []string{"a", "empty", "b"}, opened)
+	require.NoError(t, mr.Close())
+}
+
+func TestAsyncReaderFromCloudPrefix(t *testing.T) {
+	dir := t.TempDir()
+	parts := map[string]string{"part-2.json.gz": `2, 3]}`, "part-1.json.gz": `{"list": [1, `, "other.json": `{}`}
+
+	for name, part := range parts {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), gzipBytes(t, []byte(part)), 0o600))
+	}
+
+	// parts are read in key order rather than the order they were written
+	rd, err := AsyncReaderFromFile("file://"+filepath.ToSlash(dir)+"/part-*.json.gz", 4)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, `{"list": [1, 2, 3]}`, string(readAll(t, ctx, rd)))
+
+	_, err = AsyncReaderFromCloudPrefix("file://"+filepath.ToSlash(dir)+"/missing-*.json", 4)
+	require.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package jsplit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// FileNameData holds the values available to Options.NameTemplate when naming an output file
+type FileNameData struct {
+	// Key is the key of the list the file holds items of, with path separators percent encoded, or the name of the
+	// shard, e.g. part-000
+	Key string
+
+	// Shard is the index of the file among those written for the key, starting at 0
+	Shard int
+
+	// Ext is the extension of the file without a leading dot, e.g. jsonl, ndjson or csv, ending in .gz when the output
+	// is compressed
+	Ext string
+}
+
+// parseNameTemplate parses an output file name template. It is rendered for a couple of files to check that it can be,
+// and that it names each file of every key differently.
+func parseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+
+	names := make(map[string]bool)
+
+	for _, data := range []FileNameData{{"key", 0, "jsonl"}, {"key", 1, "jsonl"}, {"other", 0, "jsonl"}} {
+		name, err := renderName(tmpl, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name template: %w", err)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("invalid name template %q: files must be named after both {{.Key}} and {{.Shard}}", text)
+		}
+
+		names[name] = true
+	}
+
+	return tmpl, nil
+}
+
+// renderName renders the name of an output file, rejecting names which would place it outside the output directory
+func renderName(tmpl *template.Template, data FileNameData) (string, error) {
+	buf := bytes.NewBuffer(nil)
+
+	err := tmpl.Execute(buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	name := buf.String()
+
+	switch {
+	case name == "":
+		return "", errors.New("file name is empty")
+	case strings.ContainsAny(name, `/\`) || strings.Contains(name, ".."):
+		return "", fmt.Errorf("file name %q must not contain a path separator or ..", name)
+	}
+
+	return name, nil
+}
@@ -0,0 +1,66 @@
+package jsplit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNameTemplate(t *testing.T) {
+	tmpl, err := parseNameTemplate(`raw_{{.Key}}_{{printf "%04d" .Shard}}.{{.Ext}}`)
+	require.NoError(t, err)
+
+	name, err := renderName(tmpl, FileNameData{Key: "list", Shard: 3, Ext: "ndjson"})
+	require.NoError(t, err)
+	require.Equal(t, "raw_list_0003.ndjson", name)
+
+	for _, text := range []string{
+		`{{.Key`,           // doesn't parse
+		`{{.Missing}}`,     // unknown field
+		`{{.Key}}.jsonl`,   // every file of a key has the same name
+		`{{.Shard}}.jsonl`, // keys share names
+		`../{{.Key}}_{{.Shard}}`,
+		`{{.Key}}/{{.Shard}}`,
+		`{{.Key}}\{{.Shard}}`,
+		`{{if false}}{{.Key}}{{.Shard}}{{end}}`, // empty
+	} {
+		_, err = parseNameTemplate(text)
+		require.Error(t, err, text)
+	}
+}
+
+func TestRenderNameRejectsTraversal(t *testing.T) {
+	tmpl, err := parseNameTemplate(`{{.Key}}_{{.Shard}}`)
+	require.NoError(t, err)
+
+	for _, key := range []string{"..", "../etc", "a/b", `a\b`} {
+		_, err = renderName(tmpl, FileNameData{Key: key})
+		require.Error(t, err, key)
+	}
+}
+
+func TestSplitStreamNameTemplate(t *testing.T) {
+	bs := NewTestByteStream([]byte(`{"key": "value", "list": [{"idx": 0}, {"idx": 1}]}`), 16)
+	sink := newMemSink()
+
+	opts := Options{NameTemplate: `raw_{{.Key}}_{{printf "%04d" .Shard}}.{{.Ext}}`, MaxFileBytes: 1, CompressOutput: true}
+	require.NoError(t, splitStream(context.Background(), bs, sink, opts))
+
+	require.Contains(t, sink.files, "raw_list_0000.jsonl.gz")
+	require.Contains(t, sink.files, "raw_list_0001.jsonl.gz")
+	require.Contains(t, sink.files, "root.json.gz")
+
+	bs = NewTestByteStream([]byte(`[{"idx": 0}, {"idx": 1}]`), 16)
+	sink = newMemSink()
+
+	opts = Options{NameTemplate: `{{.Key}}-{{.Shard}}.{{.Ext}}`, Shards: 2}
+	require.NoError(t, splitStream(context.Background(), bs, sink, opts))
+	require.Equal(t, `{"idx":0}`, sink.contents("part-000-0.jsonl"))
+	require.Equal(t, `{"idx":1}`, sink.contents("part-001-0.jsonl"))
+
+	// a key which renders to a name outside the output directory fails the split
+	bs = NewTestByteStream([]byte(`{"..": [{"idx": 0}]}`), 16)
+	opts = Options{NameTemplate: `{{.Key}}{{.Shard}}`}
+	require.Error(t, splitStream(context.Background(), bs, newMemSink(), opts))
+}
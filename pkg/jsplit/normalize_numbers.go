@@ -0,0 +1,191 @@
+package jsplit
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// maxPlainZeros is the most trailing zeros an integer is written out in full with, as ECMAScript writes up to 1e21,
+// beyond which it is written with an exponent, e.g. 1e22
+const maxPlainZeros = 21
+
+// normalizeNumberItems returns a ListAddFunc which writes the numbers of every item in their canonical form, see
+// canonicalNumber, before passing it to add when opts.NormalizeNumbers is set, and add itself otherwise
+func normalizeNumberItems(add ListAddFunc, opts Options) ListAddFunc {
+	if !opts.NormalizeNumbers {
+		return add
+	}
+
+	return func(item []byte) error {
+		return add(normalizeNumbers(item))
+	}
+}
+
+// normalizeRoot writes the numbers of the values written to root.json in their canonical form when
+// opts.NormalizeNumbers is set
+func normalizeRoot(rootItems []byte, opts Options) []byte {
+	if !opts.NormalizeNumbers {
+		return rootItems
+	}
+
+	return normalizeNumbers(rootItems)
+}
+
+// normalizeNumbers returns the json value val with each of its numbers, outside of strings, replaced by its canonical
+// form. val itself is returned when all of them already are.
+func normalizeNumbers(val []byte) []byte {
+	var (
+		out  []byte
+		last int // the end of the part of val copied to out
+	)
+
+	for i := 0; i < len(val); {
+		switch c := val[i]; {
+		case c == '"':
+			i = quotedEnd(val, i)
+		case c == '-' || isDigit(c):
+			end := i + 1
+			for end < len(val) && isNumberByte(val[end]) {
+				end++
+			}
+
+			num := canonicalNumber(val[i:end])
+			if !bytes.Equal(num, val[i:end]) {
+				out = append(append(out, val[last:i]...), num...)
+				last = end
+			}
+
+			i = end
+		default:
+			i++
+		}
+	}
+
+	if out == nil {
+		return val
+	}
+
+	return append(out, val[last:]...)
+}
+
+// quotedEnd returns the index just past the end of the json string starting at val[start]
+func quotedEnd(val []byte, start int) int {
+	for i := start + 1; i < len(val); i++ {
+		switch val[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return len(val)
+}
+
+// isNumberByte reports whether c can be part of a json number
+func isNumberByte(c byte) bool {
+	return isDigit(c) || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-'
+}
+
+// canonicalNumber returns the canonical form of the json number num, which has the same value written with as few
+// characters as it can be. Its digits are kept as they are, rather than being parsed as a float64, so no precision is
+// lost. Integers are written without a decimal point or exponent, e.g. 1.0, 1e0 and 100e-2 are all written as 1,
+// unless they would need more than 21 trailing zeros, e.g. 1e22, and other numbers with the fewest digits after the
+// decimal point, e.g. 2.50 as 2.5, or with an exponent when they are smaller than 1e-6, as ECMAScript writes them,
+// e.g. 1e-7. -0 is written as 0. Anything which isn't a number is returned as it is.
+func canonicalNumber(num []byte) []byte {
+	rest := num
+
+	neg := len(rest) > 0 && rest[0] == '-'
+	if neg {
+		rest = rest[1:]
+	}
+
+	intEnd := 0
+	for intEnd < len(rest) && isDigit(rest[intEnd]) {
+		intEnd++
+	}
+
+	digits := append([]byte(nil), rest[:intEnd]...)
+	rest = rest[intEnd:]
+
+	if intEnd == 0 {
+		return num
+	}
+
+	var exp int
+
+	if len(rest) > 0 && rest[0] == '.' {
+		fracEnd := 1
+		for fracEnd < len(rest) && isDigit(rest[fracEnd]) {
+			fracEnd++
+		}
+
+		if fracEnd == 1 {
+			return num
+		}
+
+		digits = append(digits, rest[1:fracEnd]...)
+		exp = -(fracEnd - 1)
+		rest = rest[fracEnd:]
+	}
+
+	if len(rest) > 0 && (rest[0] == 'e' || rest[0] == 'E') {
+		e, err := strconv.Atoi(string(rest[1:]))
+
+		// exponents too large to add up are left as they are, as are those which aren't numbers
+		if err != nil || e > 1<<30 || e < -1<<30 {
+			return num
+		}
+
+		exp += e
+		rest = nil
+	}
+
+	if len(rest) > 0 {
+		return num
+	}
+
+	// the value is digits * 10^exp, with no leading or trailing zeros
+	digits = bytes.TrimLeft(digits, "0")
+	for len(digits) > 0 && digits[len(digits)-1] == '0' {
+		digits = digits[:len(digits)-1]
+		exp++
+	}
+
+	if len(digits) == 0 {
+		return []byte("0")
+	}
+
+	var out []byte
+	if neg {
+		out = append(out, '-')
+	}
+
+	// point is where the decimal point falls among the digits, so the value is 0.digits * 10^point
+	k := len(digits)
+	point := k + exp
+
+	switch {
+	case exp >= 0 && exp <= maxPlainZeros:
+		out = append(out, digits...)
+		out = append(out, bytes.Repeat([]byte{'0'}, exp)...)
+	case exp < 0 && point > 0:
+		out = append(out, digits[:point]...)
+		out = append(append(out, '.'), digits[point:]...)
+	case exp < 0 && point > -6 && point <= 0:
+		out = append(out, "0."...)
+		out = append(out, bytes.Repeat([]byte{'0'}, -point)...)
+		out = append(out, digits...)
+	default:
+		out = append(out, digits[0])
+		if k > 1 {
+			out = append(append(out, '.'), digits[1:]...)
+		}
+
+		out = append(out, 'e')
+		out = strconv.AppendInt(out, int64(point-1), 10)
+	}
+
+	return out
+}
@@ -0,0 +1,85 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalNumber(t *testing.T) {
+	for num, expected := range map[string]string{
+		// integers
+		"0":      "0",
+		"1":      "1",
+		"-42":    "-42",
+		"1.0":    "1",
+		"1e0":    "1",
+		"1E+0":   "1",
+		"10":     "10",
+		"1e2":    "100",
+		"1.5e1":  "15",
+		"100e-2": "1",
+		"-0":     "0",
+		"-0.0":   "0",
+		"0e10":   "0",
+		// floats
+		"2.50":     "2.5",
+		"0.1":      "0.1",
+		"-0.5":     "-0.5",
+		"1.25e-1":  "0.125",
+		"12.5e-3":  "0.0125",
+		"0.000001": "0.000001",
+		"1e-6":     "0.000001",
+		"1e-7":     "1e-7",
+		"1.5E-10":  "1.5e-10",
+		"-2.50e-8": "-2.5e-8",
+		// exponents and very large integers, whose digits are kept rather than rounded to a float64
+		"1e21":                             "1000000000000000000000",
+		"1e22":                             "1e22",
+		"1.5e22":                           "15000000000000000000000",
+		"1.5e23":                           "1.5e23",
+		"12345678901234567890123456789.5":  "12345678901234567890123456789.5",
+		"123456789012345678901234567890":   "123456789012345678901234567890",
+		"12345678901234567890123456789e1":  "123456789012345678901234567890",
+		"9007199254740993":                 "9007199254740993",
+		"9007199254740993.000":             "9007199254740993",
+		"3.141592653589793238462643383279": "3.141592653589793238462643383279",
+		"1000000000000000000000000000000":  "1e30",
+		"1e400":                            "1e400",
+		"-1.0e400":                         "-1e400",
+		"1e99999999999999999999":           "1e99999999999999999999",
+	} {
+		require.Equal(t, expected, string(canonicalNumber([]byte(num))), num)
+
+		// the canonical form is stable
+		require.Equal(t, expected, string(canonicalNumber([]byte(expected))), expected)
+	}
+}
+
+func TestNormalizeNumbers(t *testing.T) {
+	val := []byte(`{"a": 1.0, "b": [1e0, -2.50, "1.0", "say \"2.0\""], "c": {"d": 1E3}, "e": true, "f": null}`)
+	require.Equal(t, `{"a": 1, "b": [1, -2.5, "1.0", "say \"2.0\""], "c": {"d": 1000}, "e": true, "f": null}`,
+		string(normalizeNumbers(val)))
+
+	// values already in their canonical form are returned as they are
+	val = []byte(`{"a":1,"b":[0.5,"x"]}`)
+	require.Equal(t, &val[0], &normalizeNumbers(val)[0])
+}
+
+func TestSplitStreamNormalizeNumbers(t *testing.T) {
+	const doc = `{"count": 3.0, "users": [{"id": 1.0, "score": 2.50}, {"id": 2e0, "score": 1E-7}], "ids": [1, 1.0, 1e0]}`
+
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{NormalizeNumbers: true}))
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1,"score":2.5}`+"\n"+`{"id":2,"score":1e-7}`)
+	requireContents(t, filepath.Join(tempDir, "ids_00.jsonl"), "1\n1\n1")
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"count\":3\n}")
+
+	// numbers are written as they were read without it
+	tempDir = t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir, Options{}))
+	requireContents(t, filepath.Join(tempDir, "ids_00.jsonl"), "1\n1.0\n1e0")
+}
@@ -0,0 +1,1081 @@
+package jsplit
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+// DefaultRootListKey names the files the elements of a document which is a json array are written to when neither
+// Options.Shards nor Options.RootListKey are set
+const DefaultRootListKey = "root"
+
+// DefaultMaxOpenPartitions is the number of partitions whose files are kept open at once when
+// Options.MaxOpenPartitions isn't set
+const DefaultMaxOpenPartitions = 64
+
+// MissingPartition names the files the elements partitioned by Options.PartitionBy are written to when they don't
+// have a value for the field
+const MissingPartition = "_missing"
+
+// DefaultMaxFileBytes is the number of bytes written to a jsonl file before the following items are written to a new
+// file when Options.MaxFileBytes isn't set
+const DefaultMaxFileBytes = 4 * 1024 * 1024 * 1024
+
+// DefaultMaxDepth is the deepest objects and arrays can be nested within a list item or other value when
+// Options.MaxDepth isn't set, far deeper than real documents are nested
+const DefaultMaxDepth = 10000
+
+// Format identifies the format list items are written to output files in
+type Format string
+
+const (
+	// FormatJSONL writes each item as a line of json
+	FormatJSONL Format = "jsonl"
+	// FormatNDJSON writes each item as a line of json terminated by a newline, including the last, into files with an
+	// .ndjson extension. An empty list is written to an empty file rather than producing no files.
+	FormatNDJSON Format = "ndjson"
+	// FormatCSV writes lists of flat json objects as csv with a header row, see CsvWriteCloser
+	FormatCSV Format = "csv"
+	// FormatMsgpack writes each item as a MessagePack record prefixed by its length, see MsgpackWriteCloser
+	FormatMsgpack Format = "msgpack"
+	// FormatParquet writes lists of flat json objects as Parquet files with a column for each key, see
+	// ParquetWriteCloser
+	FormatParquet Format = "parquet"
+)
+
+// spilled reports whether files in the format are spilled while they are written and only converted once they are
+// complete, such as csv files, whose header holds the keys of every object, so that they can't be appended to
+func (f Format) spilled() bool {
+	return f == FormatCSV || f == FormatParquet
+}
+
+// Options configures how Split, SplitStream and SplitFile split a json document
+type Options struct {
+	// ReaderOptions configure the AsyncReader used by SplitFile to read its input
+	ReaderOptions []AsyncReaderOption
+
+	// Root is a JSON pointer, e.g. /data/results, to the object within the document which is split instead of the
+	// document itself. Everything outside of it is ignored. Pointers can only refer to values nested in objects, and
+	// the value must be an object or an array.
+	Root string
+
+	// InputStream says whether input holding more than one json value is a stream of values, such as an ndjson file,
+	// split as the elements of a root list, or documents written back to back, split by their keys. InputStreamAuto,
+	// which tells them apart from the first value, is used when it isn't set.
+	InputStream InputStream
+
+	// Shards is the number of jsonl files the elements of a json array at the root of the document are distributed
+	// across, round-robin. When it isn't set the elements are written in order to files named after RootListKey. It
+	// fails the split when the document is an object.
+	Shards int
+
+	// HashPartition is a field of the elements distributed across Shards, which are written to the shard picked by a
+	// stable hash of its value instead of round-robin, so that elements with the same value are always in the same
+	// shard, e.g. across runs. Strings are hashed by their unquoted value and other values by their json. Elements
+	// which aren't objects, or where the field is missing or null, are written to FallbackShard. Like Shards, it fails
+	// the split when the document is an object.
+	HashPartition string
+
+	// FallbackShard is the shard elements without the HashPartition field are written to, from 0 to Shards-1
+	FallbackShard int
+
+	// PartitionBy is a field of the elements of a json array at the root of the document, which are partitioned by
+	// its value into files named after the value instead of being written in order, e.g. with a PartitionBy of region
+	// {"region": "eu"} is written to eu_00.jsonl. Strings are written to the partition named after their unquoted
+	// value and other values after their json. Elements which aren't objects, or where the field is missing, null or
+	// an empty string, are written to MissingPartition. Values are escaped for use in file names as keys are, see
+	// NormalizeNames. It can't be used with Shards, and fails the split when the document is an object.
+	PartitionBy string
+
+	// FilenameField is a field of the elements of a json array at the root of the document naming the files each is
+	// written to, which is removed from the elements as they are written, for exports whose records already carry
+	// their destination, e.g. with a FilenameField of _table {"_table": "orders", "id": 1} is written to
+	// orders_00.jsonl as {"id":1}. Values name files as those of PartitionBy do, and elements without a value for the
+	// field are written as they are to MissingPartition. It can't be used with Shards, PartitionBy or PartitionByDate,
+	// and fails the split when the document is an object.
+	FilenameField string
+
+	// PartitionByDate is a field holding a timestamp of the elements of a json array at the root of the document, which
+	// are partitioned by its date into directories named Hive style, e.g. dt=2024-01-01/root_00.jsonl, with the files
+	// in each named as they would be without partitions. Timestamps can be RFC 3339 strings, with or without a time
+	// zone or time of day, or numbers of seconds or milliseconds since the Unix epoch, and are converted to UTC. Elements
+	// whose timestamp is missing or can't be parsed are written to dt=UnknownDatePartition. It can't be used with
+	// Shards or PartitionBy, and fails the split when the document is an object.
+	PartitionByDate string
+
+	// DateFormat is the Go time layout the dates of PartitionByDate are formatted with, e.g. 2006-01 for monthly
+	// partitions. DefaultDateFormat is used when it isn't set.
+	DateFormat string
+
+	// MaxOpenPartitions is the number of partitions whose files are kept open at once when PartitionBy, PartitionByDate
+	// or FilenameField is set. Once it is reached, starting another partition closes the file of the partition written to
+	// longest ago, which is reopened for appending when the partition has another element. Files can only be appended to
+	// in local output directories, and not when they are written as csv or their checksums are recorded, so the limit
+	// should be above the number of partitions in those cases. DefaultMaxOpenPartitions is used when it isn't set.
+	MaxOpenPartitions int
+
+	// MaxFiles is the most files of lists, shards and partitions a split creates, as a safety valve against a field
+	// with far more values than expected creating a file for each. The split fails with ErrTooManyFiles, naming the
+	// file and for partitions the value, once another file would be one too many, unless OverflowPartition is set.
+	// root.json, the manifest and the other files describing the split aren't counted. The limit is shared by the
+	// files split by SplitFiles and by the entries of a zip or tar archive. Unlimited when it isn't set.
+	MaxFiles int
+
+	// OverflowPartition is the partition the elements with the values of PartitionBy, or the dates of
+	// PartitionByDate, which are seen once MaxFiles is close to being reached are written to, instead of failing the
+	// split. A new partition is only started while it leaves room for the first file of the overflow partition, and
+	// elements are only written to the partitions already started and the overflow partition after that. Elements with
+	// the overflow partition's own value are written to it too. The split still fails if the partitions' files fill up
+	// and need more files than MaxFiles allows.
+	OverflowPartition string
+
+	// RootListKey is used in place of a key to name the files the elements of a document which is a json array are
+	// written to when Shards isn't set, e.g. [RootListKey]_00.jsonl. DefaultRootListKey is used when it isn't set.
+	RootListKey string
+
+	// MaxFileBytes is the number of bytes written to a jsonl file before the following items are written to a new file,
+	// so files are only ever split between items. The file being written when the threshold is reached is finished
+	// with the item which crossed it. DefaultMaxFileBytes is used when it isn't set.
+	MaxFileBytes int64
+
+	// RecordsPerFile is the number of items written to each file of a list before the following items are written to
+	// a new file, so that every file holds exactly that many items but the last, which holds the rest, for loaders
+	// with a limit on the rows of each request. Files are only split by their number of items when it is set, and
+	// MaxFileBytes is ignored.
+	RecordsPerFile int
+
+	// Concurrency is the number of lists which are written concurrently. Items are still parsed one at a time, but
+	// while they are being written the lists which follow can be parsed and written by other goroutines, so documents
+	// with many large lists are split faster. The items of a list are always written in order. Lists are written one
+	// at a time when it isn't set. The files of a list are closed once it has been written, so no more than
+	// Concurrency files of lists are open at once however many keys the document has.
+	Concurrency int
+
+	// FileConcurrency is the number of input files SplitFiles reads and parses at once. Each file is parsed by its own
+	// goroutine, so splitting many files is faster on machines with several cores. Files are split one after another
+	// when it isn't set.
+	FileConcurrency int
+
+	// FileErrors is what SplitFiles does when one of its files can't be split, such as one which isn't valid json.
+	// FileErrorsFailFast, stopping every file, is used when it isn't set.
+	FileErrors FileErrors
+
+	// MaxMemory bounds the bytes a split holds in memory: the chunks its AsyncReaders have read but which haven't yet
+	// been parsed, the items queued for lists written concurrently, and the buffers of the files open for writing.
+	// Reading pauses while more than MaxMemory bytes are held, so that memory use levels off when writing is slower
+	// than reading, e.g. with slow uploads. A chunk is still read when none are queued, so the limit should leave room
+	// for a chunk on top of the buffers of the files open at once. It applies to the readers SplitFile and SplitFiles
+	// create, or the one passed to Split. The memory held is reported in Stats. Unlimited when it isn't set.
+	MaxMemory int64
+
+	// IncludeKeys lists the keys of the object being split which are written, leaving out every other key. The values
+	// of keys which aren't written are still parsed past, but no files are created for them and they are left out of
+	// root.json. Every key is written when neither it nor ExcludeKeys are set.
+	IncludeKeys []string
+
+	// ExcludeKeys lists keys of the object being split which aren't written, see IncludeKeys. It is ignored when
+	// IncludeKeys is set.
+	ExcludeKeys []string
+
+	// IncludeKeyPattern also writes the keys it matches, along with those in IncludeKeys, for documents whose keys are
+	// named as they are generated, e.g. ^log_. Only keys in either are written when it is set.
+	IncludeKeyPattern *regexp.Regexp
+
+	// ExcludeKeyPattern leaves out the keys it matches along with those in ExcludeKeys. Like ExcludeKeys, it is
+	// ignored when IncludeKeys or IncludeKeyPattern is set.
+	ExcludeKeyPattern *regexp.Regexp
+
+	// RequireKeys lists keys the object being split must have, failing the split with ErrKeysMismatch once the end of
+	// the object is reached without finding one of them, so that a change to the documents upstream is caught. Keys
+	// left out by IncludeKeys or ExcludeKeys still count as found. Each document of a stream of them, and each file
+	// split by SplitFiles, must have every key, and a document which is a list has none. The files already written
+	// for other keys are removed with a local output directory the split created, and are otherwise left without a
+	// manifest. It can't be used with checkpoints. No keys are required when it isn't set.
+	RequireKeys []string
+
+	// ExactKeys also fails the split with ErrKeysMismatch as soon as the object has a key which isn't one of
+	// RequireKeys, before anything is written for it. It can only be used with RequireKeys.
+	ExactKeys bool
+
+	// Limit is the number of items of each list which are written, for taking a quick sample of a large document. The
+	// rest of each list is skipped over without being parsed, though it is still read. The items of a document which
+	// is a json array are limited as a single list, across every shard. Every item is written when it isn't set.
+	Limit int
+
+	// DedupField is a field of the objects in each list which identifies them. Only the first object of a list with a
+	// value for the field is written, and later ones with the same value are dropped, counted in the manifest. Objects
+	// without the field, or where it is null, are always written. Every distinct value seen in a list is held in memory
+	// until the list ends, so the memory used grows with the number of distinct values. The elements of a document which
+	// is a json array are deduplicated as a single list, across every shard, and the number dropped is only printed.
+	DedupField string
+
+	// SortBy is a field of the objects in each list which they are sorted by before they are written, for consumers
+	// which need sorted input. Numbers sort before strings, which sort before other values, followed by items without
+	// the field or where it is null, and items with the same value keep their order in the list. Every item of a list
+	// has to be read before the first can be written, so items are held in memory until they add up to MaxSortMemory,
+	// and are then sorted and spilled to a temporary file in TempDir. Once the list ends the files are merged, so
+	// sorting a list costs about as much disk as the list, in place of memory, and the items are written once the whole
+	// list has been read. Each list of a key which appears more than once is sorted on its own. It can't be used with
+	// Shards.
+	SortBy string
+
+	// MaxSortMemory is the number of bytes of items held in memory for each list being sorted by SortBy, before they
+	// are spilled to a temporary file. Keys written concurrently each hold up to this much. DefaultMaxSortMemory is
+	// used when it isn't set.
+	MaxSortMemory int64
+
+	// Filter is an expression which the items of each list must match to be written, e.g.
+	// status == "active" and (age >= 18 or exists(guardian)). Fields are named as they are, or with dots between the
+	// names of nested objects, e.g. user.address.city, and compared with ==, !=, <, <=, > and >= to strings, numbers,
+	// true, false, null or other fields. A missing field is null for ==, and != matches it. Only two numbers or two
+	// strings can be ordered, so < and the like never match other values. exists(field) matches a field which is
+	// there, even when it is null. Conditions are combined with and, or and not, or &&, || and !, and grouped with
+	// parentheses. Items which aren't objects have no fields. The items dropped are counted in the manifest. Every item
+	// is written when it isn't set. It can't be used with checkpoints.
+	Filter string
+
+	// RecordTransform is called with each item of every list before it is written, to enrich it, rename its fields or
+	// drop it, making the split a streaming ETL step for programs embedding it, see RecordTransformFunc. Items are
+	// passed as the raw bytes they were parsed from, after annotations are added but before Filter, Since and DedupField
+	// see them, and those which are dropped are counted in the manifest along with those Filter drops. An error fails
+	// the split, or when SkipErrors is set skips the item, logging it to the rejects file. It is called from the
+	// goroutine parsing the input, one item at a time, though SplitFiles calls it for different keys at once when it
+	// splits several files concurrently. It can't be used with checkpoints.
+	RecordTransform RecordTransformFunc
+
+	// SinceField is a timestamp field of the objects in each list, parsed as the field of PartitionByDate is, and only
+	// the items whose timestamp is at or after Since are written, e.g. to extract the records added to a full dump
+	// since the last run. Items without the field, where it is null or where it can't be parsed are dropped, unless
+	// KeepUntimed is set. The items dropped are counted in the manifest along with those dropped by Filter, which the
+	// items which are kept must match too. It can't be used with checkpoints.
+	SinceField string
+
+	// Since is the cutoff of SinceField, see ParseTimestamp
+	Since time.Time
+
+	// KeepUntimed writes the items without a timestamp in SinceField, or with one which can't be parsed, rather than
+	// dropping them
+	KeepUntimed bool
+
+	// IndexField adds a field with this name to every object written to the files of a list, holding the index of the
+	// object in the list of the document, counting from 0, e.g. {"id":7} becomes {"id":7,"_jsplit_index":0}. Items
+	// which aren't objects are written as they are. The field is added to the end of the object, so the name should
+	// be one the objects don't already have. Nothing is added when it isn't set. It can't be used with checkpoints.
+	IndexField string
+
+	// KeyField adds a field with this name to every object written to the files of a list, holding the key of the
+	// list, e.g. {"id":7} in the list of users becomes {"_key":"users","id":7}. Unlike IndexField and SourceField it
+	// is added to the start of the object, so that the objects of every list merged with NewMergeSink lead with their
+	// keys. Items which aren't objects are written as they are. Nothing is added when it isn't set. It can't be used with
+	// checkpoints.
+	KeyField string
+
+	// SourceField adds a field with this name to every object written to the files of a list, in the same way as
+	// IndexField, holding the name of the input it was read from. Only SplitFile and SplitFiles know the name of their
+	// inputs, and for the entries of a zip or tar archive it is the archive's name followed by the entry's, so the field
+	// isn't added by Split and SplitStream.
+	SourceField string
+
+	// JSON5 accepts documents containing // and /* */ comments, and commas trailing the last element of arrays and
+	// objects, which are stripped before the document is parsed. Comment markers within strings are left alone. The
+	// offsets of parse errors are those of the stripped document. It can't be used with checkpoints.
+	JSON5 bool
+
+	// MaxDepth is the deepest objects and arrays can be nested within each list item or other value parsed, counting
+	// the value itself, DefaultMaxDepth when it isn't set. A value nested deeper fails the split with ErrTooDeep, or is
+	// skipped with SkipErrors, so that untrusted input can't exhaust the memory of the split, or the stack of Filter
+	// and Flatten, by nesting millions of arrays.
+	MaxDepth int
+
+	// MaxRecordBytes is the largest each list item or other value parsed can be, with no limit when it isn't set. A
+	// larger value fails the split with ErrRecordTooLarge, or with SkipErrors is skipped, warning about its key and
+	// index, which are logged to the rejects file, so that a single pathological record such as a giant embedded blob
+	// can't exhaust the memory of the split. Parsing of the value stops once it passes the limit, and the rest of it
+	// is skipped without being held.
+	MaxRecordBytes int64
+
+	// TolerateTruncation carries on when the input ends part way through the document after at least one complete
+	// record, such as one whose producer was killed mid-write, missing its last record or closing bracket. The records
+	// before the end are written and the split succeeds, after logging a warning with the byte the input ended at.
+	TolerateTruncation bool
+
+	// Format is the format list items are written in, FormatJSONL when it isn't set. root.json is always json.
+	Format Format
+
+	// KeyFormats sets the formats the lists of particular keys are written in in place of Format, e.g. FormatCSV for
+	// users and FormatNDJSON for events, so that one split can write each list in the format its consumers read. The
+	// elements of a document which is a json array are written in the format set for its root list key.
+	KeyFormats map[string]Format
+
+	// ParquetNestedJSON writes the nested objects and arrays of the items written as FormatParquet to columns of json
+	// strings, annotated as JSON, rather than failing the file, as only flat objects are written as columns of their
+	// own. A field holding nested values and values of other kinds still fails the file.
+	ParquetNestedJSON bool
+
+	// CSV is the dialect FormatCSV files are written in, such as tab delimited with \N for null to be loaded by
+	// Postgres COPY, comma delimited and quoted as needed when it isn't set
+	CSV CSVDialect
+
+	// NameTemplate is a text/template naming the files lists are written to in place of [key]_%02d.[ext], rendered
+	// with a FileNameData, e.g. raw_{{.Key}}_{{printf "%04d" .Shard}}.{{.Ext}}. Names must not contain a path
+	// separator or .., and every file needs a different name, so the template must use both .Key and .Shard.
+	NameTemplate string
+
+	// NormalizeNames names the files written for each key after a slug of the key, lowercase with every run of
+	// characters other than ascii letters and digits replaced by an underscore, e.g. "User Profiles" is written to
+	// user_profiles_00.jsonl. Keys which would share a slug are given a suffix in the order they are found, e.g.
+	// user_profiles_2, and the manifest records the key each file was written for. Keys are only escaped, see fileKey,
+	// when it isn't set.
+	NormalizeNames bool
+
+	// NameCollisions is what is done when the slugs of two keys, or of two values of PartitionBy or FilenameField, are
+	// the same with NormalizeNames set. NameCollisionsSuffix, suffixing the later key and listing it in the manifest,
+	// is used when it isn't set.
+	NameCollisions NameCollisions
+
+	// KeyRoutes writes the files of the lists of the keys matching a route's pattern to the route's directory within
+	// the output, e.g. the lists of dim_* keys to dims and those of fact_* keys to facts, so that an export of many
+	// tables is organised by kind. Each key follows the first route it matches, and the lists of keys matching none
+	// are written to the root of the output. The manifest names the files with their directories, e.g.
+	// dims/dim_users_00.jsonl.
+	KeyRoutes []KeyRoute
+
+	// ValueFiles writes each value of the object being split which isn't a list, such as an object, string or number,
+	// to a file of its own named after its key, [key].json, holding the value as root.json would, rather than
+	// collecting them all in root.json, which isn't written. The files are listed in Manifest.Values. When a key holds
+	// a value more than once the last is written, as a json parser would keep. A key whose file would be named
+	// manifest.json fails the split. It can't be used with checkpoints, several files or appending to existing output.
+	ValueFiles bool
+
+	// Flatten replaces the nested objects of list items which are objects with their fields, joining the keys with
+	// FlattenSeparator, e.g. {"a":{"b":1}} is written as {"a.b":1}. Items whose flattened keys collide fail the split.
+	Flatten bool
+
+	// FlattenSeparator joins the keys of nested objects when Flatten is set, DefaultFlattenSeparator when it isn't set
+	FlattenSeparator string
+
+	// FlattenArrays replaces arrays of scalars with a field per element when Flatten is set, e.g. {"a":[1,2]} is
+	// written as {"a.0":1,"a.1":2}. Arrays are left as json when it isn't set.
+	FlattenArrays bool
+
+	// UniformFields lists the fields of the objects of each list, by key, so that every object is written with all of
+	// them, in that order, those it is missing as null, for loaders of columnar formats such as csv which need every
+	// row to have the same columns. Fields which aren't listed follow the listed ones. Fields are added after the
+	// objects are flattened and redacted. InferUniformFields collects them in a first pass over the input, or they can
+	// be given as they are known. The items of lists without fields are written as they are.
+	UniformFields map[string][]string
+
+	// Redact removes the named fields from the list items which are objects before they are written, e.g. for
+	// personal data such as ssn, or replaces their values with RedactPlaceholder when it is set. Fields of nested
+	// objects are named with dots between the names, e.g. user.email, and the fields of the objects in arrays are
+	// named as those of the array's field would be, so orders.card redacts the card of every order. Fields which
+	// items don't have are ignored. Items are redacted before they are flattened, and before they are partitioned, but
+	// Filter, DedupField and SortBy see them as they were read. The values which aren't lists aren't redacted.
+	Redact []string
+
+	// RedactPlaceholder is the string the values of the fields named by Redact are replaced with, e.g. [REDACTED].
+	// The fields are removed when it isn't set.
+	RedactPlaceholder string
+
+	// NormalizeNumbers writes every number of the list items, and of root.json, in one canonical form, so that 1.0, 1
+	// and 1e0 are all written as 1 and output split from differently written input can be diffed or deduplicated by
+	// its digest. Integers are written without a decimal point or exponent, unless they would need more than 21
+	// trailing zeros, e.g. 1e22, and other numbers with the fewest digits after the decimal point, with an exponent
+	// when they are smaller than 1e-6, e.g. 1e-7. The digits are never rounded, so numbers keep the precision they
+	// were written with. Filter, DedupField and SortBy see them as they were read.
+	NormalizeNumbers bool
+
+	// Pretty indents items, and root.json, with two spaces per level of nesting. Indented items span several lines, so
+	// jsonl and ndjson files written with it can't be read a line at a time. It can't be used with FormatCSV,
+	// FormatMsgpack or FormatParquet.
+	Pretty bool
+
+	// InferSchema writes a JSON Schema describing the items of each list, inferred as they are split, to
+	// [key].schema.json. The items of a document which is a json array are described by [RootListKey].schema.json, or
+	// by root.schema.json when they are sharded.
+	InferSchema bool
+
+	// BigQuerySchema writes a BigQuery schema describing the fields of the objects of each list, inferred as they are
+	// split, to [key].bq_schema.json alongside the schemas of InferSchema, so that the files of a list written as
+	// FormatNDJSON can be loaded with bq load straight away. Numbers are INTEGER or FLOAT, strings STRING, booleans
+	// BOOLEAN, objects RECORDs of their fields and arrays REPEATED fields of their elements, while fields whose values
+	// conflict are JSON.
+	BigQuerySchema bool
+
+	// CompressOutput gzip compresses every output file, appending .gz to their names
+	CompressOutput bool
+
+	// GzipLevel is the level CompressOutput compresses files at, from gzip.BestSpeed to gzip.BestCompression, or
+	// gzip.HuffmanOnly. gzip.DefaultCompression is used when it isn't set, so gzip.NoCompression, which would only
+	// wrap the files in gzip framing, can't be chosen.
+	GzipLevel int
+
+	// GzipConcurrency compresses each file CompressOutput writes in blocks of GzipBlockSize bytes on this many
+	// goroutines at once, see ParallelGzipWriteCloser, for when compressing on one goroutine holds a split back on a
+	// machine with cores to spare. The files are still standard gzip files, a little larger than they would be
+	// otherwise, and each holds up to this many blocks in memory as it is written. Files are compressed on one
+	// goroutine with compress/gzip when it isn't set or is 1. root.json is always compressed on one goroutine.
+	GzipConcurrency int
+
+	// GzipBlockSize is the size of the blocks GzipConcurrency compresses at once, DefaultGzipBlockSize when it isn't
+	// set, and must be more than 16KB. Larger blocks compress files a little smaller, while smaller blocks spread small
+	// files across more cores.
+	GzipBlockSize int
+
+	// Checksums records the SHA-256 digest of every file listed in the manifest, computed as the file is written, see
+	// VerifyChecksums
+	Checksums bool
+
+	// SkipErrors skips list items which aren't valid json, or can't be parsed, rather than failing. Each is logged to
+	// RejectsFilename in the output directory with its key, index and error, and parsing resumes with the next item.
+	// An error following an item is logged with the index of that item.
+	SkipErrors bool
+
+	// StrictUTF8 checks that the strings of the input are valid UTF-8, which items are otherwise copied without
+	// checking, so that invalid bytes can't reach output which downstream tools reject. UTF8Error fails the split at
+	// the first list item, value or key holding invalid UTF-8, and UTF8Replace replaces the invalid bytes with U+FFFD.
+	// Each item is checked once it has been parsed, so valid input only costs a scan of each item.
+	StrictUTF8 UTF8Policy
+
+	// DryRun parses the whole document, counting the items of each list and the bytes which would be written to each
+	// file, without writing any files. The files which would have been written are reported once the document has
+	// been parsed, in place of writing the manifest.
+	DryRun bool
+
+	// Stats is called every StatsInterval with the progress of the split, for exporting throughput metrics, and once
+	// more with the totals when the split finishes. It is called from its own goroutine, one call at a time.
+	Stats func(Stats)
+
+	// StatsInterval is how often Stats is called, DefaultStatsInterval when it isn't set
+	StatsInterval time.Duration
+
+	// CheckpointInterval is how often SplitFile records its progress in CheckpointFilename in the output directory, so
+	// that a split which is interrupted can be resumed with Resume. Checkpoints are recorded between list items, and
+	// only when the input is an uncompressed file, URL or cloud storage object which can be read from an offset, and
+	// the output a local directory. They can't be used with Concurrency, Shards, FormatCSV, FormatMsgpack, FormatParquet,
+	// InferSchema, BigQuerySchema, CompressOutput, SkipErrors, DryRun, Limit, Checksums, JSON5, DedupField, SortBy,
+	// Filter, SinceField, NormalizeNames, ValueFiles, PartitionBy, PartitionByDate, KeyField, IndexField or
+	// SourceField. The checkpoint is removed once the split completes.
+	CheckpointInterval time.Duration
+
+	// Resume continues the split recorded in the checkpoint in the output directory, reading the input from the
+	// offset of the last checkpoint and appending to the file which was being written. It must be used with the same
+	// input and options as the split which was interrupted.
+	Resume bool
+
+	// FlushInterval is how often the files being written to a local directory are flushed and synced to disk with
+	// fsync, so that the items written to them survive a crash of the split or the machine, at some cost to
+	// throughput. Each file is synced when an item is written to it once the interval has passed since it was last
+	// synced, and every file is synced as it is closed, so a file which is rolled over or closed to make room for
+	// other partitions isn't synced just before. Partitions which haven't been written to for a while are synced along
+	// with the others, other than the one which will be closed next to make room. Compressed files are flushed so that
+	// what has been synced can be decompressed, while csv files are only written, and so synced, as they are closed.
+	// The last item written to a msgpack file is only encoded, and so synced, once the next item is written or the
+	// file is closed.
+	// Files are only synced to disk when the operating system chooses to when it isn't set.
+	FlushInterval time.Duration
+
+	// ExistingOutput is what a split does with the files left in a local output directory by a previous run. When it
+	// isn't set SplitFile and SplitFiles refuse to write into an output directory which already exists, as for
+	// ExistingOutputError, unless Overwrite or RequireOutputDir are set, but the files of a directory which is written
+	// into are replaced. Objects in cloud storage are always overwritten.
+	ExistingOutput ExistingOutput
+
+	// Overwrite allows SplitFile to remove a local output directory which already exists. It is the same as an
+	// ExistingOutput of ExistingOutputOverwrite.
+	Overwrite bool
+
+	// RequireOutputDir makes SplitFile and SplitStream fail straight away with ErrNoOutputDir when a local output
+	// directory doesn't exist, rather than creating it along with any missing parents. SplitFile then writes into the
+	// directory which exists, instead of refusing to unless Overwrite is set, e.g. for a directory a volume is mounted
+	// on, though files left in it are still only replaced as ExistingOutput allows.
+	RequireOutputDir bool
+
+	// FileMode is the permissions of the files written to a local output directory, e.g. 0o640 so that they aren't
+	// world readable, which they are given when they are created regardless of the umask. Only permission bits can be
+	// set. Files are created with 0o666, less the umask, when it isn't set.
+	FileMode os.FileMode
+
+	// DirMode is the permissions of the local output directory, and of the directories of date partitions and archive
+	// entries within it, e.g. 0o750, which they are given when they are created regardless of the umask. os.ModeSetgid
+	// can be set too so that the files created within a directory take its group rather than the primary group of the
+	// user. Directories which already exist are left as they are, and are created with 0o755, less the umask, when it
+	// isn't set.
+	DirMode os.FileMode
+
+	// AtomicFiles writes each file to a local output directory under a temporary name, its name prefixed with . and
+	// suffixed with .tmp, e.g. .users_00.jsonl.tmp, renaming it once it has been written in full and closed, so that
+	// tools polling the directory never see a file part way through being written. The temporary files of a split
+	// which fails or is cancelled are removed rather than renamed, and one which crashes leaves only temporary files
+	// behind. Objects in cloud storage only appear once their upload completes anyway, see GCSComposePartSize. It can't
+	// be used with checkpoints or appending to existing output, which carry on writing files an earlier split left,
+	// and the lists of a key appearing more than once in the document are written to new files rather than appended
+	// to its last one.
+	AtomicFiles bool
+
+	// TempDir is the directory temporary files are written to, such as the jsonl spilled while csv or parquet output is
+	// written and zip archives downloaded from http(s) URLs or cloud storage, in place of the default directory for
+	// temporary files, see os.TempDir. It must already exist. Temporary files are removed once they are no longer needed,
+	// whether the split succeeds, fails or is cancelled.
+	TempDir string
+
+	// GCSComposePartSize uploads each file written to Google Cloud Storage as parts of this many bytes, each an object
+	// of its own, which are composed into the file with storage.Composer once it has been written and then deleted, see
+	// cloud.ComposedWriter. Each upload then only lasts as long as a part, so a failure hours into writing a large
+	// file doesn't restart its whole upload. Files are uploaded in one go when it isn't set, and to other storage always.
+	GCSComposePartSize int64
+
+	// Uploaded is called with the URI of each object written to cloud storage once its upload has completed, root.json
+	// and the manifest included, so that the objects which were written in full are known even when the split is
+	// interrupted before its manifest is written. Uploads still in progress when the split is cancelled or fails are
+	// aborted, leaving no object behind. It can be called from several goroutines at once.
+	Uploaded func(uri string)
+
+	// KeyWriteTime is called with the wall-clock time spent writing the list of each key once every list has been
+	// written, from its first item being written to its last file being closed, added up over the lists of a key found
+	// more than once. The lists of several keys are written at once with Concurrency, so their times can add up to
+	// more than the split took. It isn't called for the shards and partitions of a document which is a json array.
+	KeyWriteTime func(key string, elapsed time.Duration)
+
+	// valueStream is set when the document is a stream of json values, such as an ndjson file, whose values are split
+	// as the elements of a json array at the root of the document would be, see isValueStream
+	valueStream bool
+
+	// source is the name of the input being split, which SplitFile and SplitFiles set for SourceField
+	source string
+
+	// budget counts the memory held by the split when MaxMemory is set, see withMemoryBudget
+	budget *memoryBudget
+
+	// files counts the files created by the split when MaxFiles is set, see withFileLimit
+	files *fileLimit
+
+	// previous is the manifest of the split being appended to when ExistingOutput is ExistingOutputAppend, see
+	// readPreviousManifest
+	previous *Manifest
+}
+
+// validate returns an error if the options can't be used for splitting
+func (opts Options) validate() error {
+	if opts.Shards < 0 {
+		return fmt.Errorf("shards must not be negative, got %d", opts.Shards)
+	}
+
+	if opts.HashPartition != "" && opts.Shards == 0 {
+		return fmt.Errorf("hash partitions need shards")
+	}
+
+	if opts.FallbackShard < 0 || (opts.Shards > 0 && opts.FallbackShard >= opts.Shards) {
+		return fmt.Errorf("fallback shard must be from 0 to %d, got %d", max(opts.Shards-1, 0), opts.FallbackShard)
+	}
+
+	if opts.PartitionBy != "" && opts.Shards > 0 {
+		return fmt.Errorf("partitions can't be used with shards")
+	}
+
+	if opts.PartitionByDate != "" && (opts.Shards > 0 || opts.PartitionBy != "") {
+		return fmt.Errorf("date partitions can't be used with shards or partitions")
+	}
+
+	if opts.FilenameField != "" && (opts.Shards > 0 || opts.PartitionBy != "" || opts.PartitionByDate != "") {
+		return fmt.Errorf("a filename field can't be used with shards or partitions")
+	}
+
+	if opts.DateFormat != "" {
+		err := validateDateFormat(opts.DateFormat)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.MaxOpenPartitions < 0 {
+		return fmt.Errorf("max open partitions must not be negative, got %d", opts.MaxOpenPartitions)
+	}
+
+	if opts.MaxFiles < 0 {
+		return fmt.Errorf("max files must not be negative, got %d", opts.MaxFiles)
+	}
+
+	if opts.OverflowPartition != "" && (opts.MaxFiles == 0 || !opts.partitioned()) {
+		return fmt.Errorf("an overflow partition can only be used with max files and partitions")
+	}
+
+	if opts.OverflowPartition != "" && opts.MaxFiles < 2 {
+		return fmt.Errorf("max files must leave room for a partition besides the overflow partition, got %d",
+			opts.MaxFiles)
+	}
+
+	if opts.GzipLevel < gzip.HuffmanOnly || opts.GzipLevel > gzip.BestCompression {
+		return fmt.Errorf("gzip level must be between %d and %d, got %d", gzip.HuffmanOnly, gzip.BestCompression,
+			opts.GzipLevel)
+	}
+
+	if opts.GzipConcurrency < 0 {
+		return fmt.Errorf("gzip concurrency must not be negative, got %d", opts.GzipConcurrency)
+	}
+
+	if opts.GzipBlockSize < 0 {
+		return fmt.Errorf("gzip block size must not be negative, got %d", opts.GzipBlockSize)
+	}
+
+	if opts.GzipBlockSize > 0 && opts.GzipBlockSize <= minGzipBlockSize {
+		return fmt.Errorf("gzip block size must be more than %d bytes, got %d", minGzipBlockSize, opts.GzipBlockSize)
+	}
+
+	if opts.IndexField != "" && opts.IndexField == opts.SourceField {
+		return fmt.Errorf("index field and source field must have different names, both are %s", opts.IndexField)
+	}
+
+	if opts.KeyField != "" && (opts.KeyField == opts.IndexField || opts.KeyField == opts.SourceField) {
+		return fmt.Errorf("key field must have a different name from the index and source fields, got %s",
+			opts.KeyField)
+	}
+
+	if opts.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative, got %d", opts.Concurrency)
+	}
+
+	if opts.MaxMemory < 0 {
+		return fmt.Errorf("max memory must not be negative, got %d", opts.MaxMemory)
+	}
+
+	if opts.FileConcurrency < 0 {
+		return fmt.Errorf("file concurrency must not be negative, got %d", opts.FileConcurrency)
+	}
+
+	switch opts.NameCollisions {
+	case "", NameCollisionsSuffix, NameCollisionsFail:
+	default:
+		return fmt.Errorf("unsupported name collision policy %q, it must be %s or %s", opts.NameCollisions,
+			NameCollisionsSuffix, NameCollisionsFail)
+	}
+
+	switch opts.FileErrors {
+	case "", FileErrorsFailFast, FileErrorsBestEffort:
+	default:
+		return fmt.Errorf("unsupported file error policy %q, it must be %s or %s", opts.FileErrors,
+			FileErrorsFailFast, FileErrorsBestEffort)
+	}
+
+	if opts.GCSComposePartSize < 0 {
+		return fmt.Errorf("GCS compose part size must not be negative, got %d", opts.GCSComposePartSize)
+	}
+
+	if opts.MaxFileBytes < 0 {
+		return fmt.Errorf("max file bytes must not be negative, got %d", opts.MaxFileBytes)
+	}
+
+	if opts.RecordsPerFile < 0 {
+		return fmt.Errorf("records per file must not be negative, got %d", opts.RecordsPerFile)
+	}
+
+	if opts.MaxDepth < 0 {
+		return fmt.Errorf("max depth must not be negative, got %d", opts.MaxDepth)
+	}
+
+	if opts.MaxRecordBytes < 0 {
+		return fmt.Errorf("max record bytes must not be negative, got %d", opts.MaxRecordBytes)
+	}
+
+	if opts.MaxSortMemory < 0 {
+		return fmt.Errorf("max sort memory must not be negative, got %d", opts.MaxSortMemory)
+	}
+
+	if opts.SortBy != "" && opts.Shards > 0 {
+		return errors.New("sorted output can't be written to shards")
+	}
+
+	if opts.Limit < 0 {
+		return fmt.Errorf("limit must not be negative, got %d", opts.Limit)
+	}
+
+	if opts.ExactKeys && len(opts.RequireKeys) == 0 {
+		return errors.New("exact keys can only be used with required keys")
+	}
+
+	if opts.FlushInterval < 0 {
+		return fmt.Errorf("flush interval must not be negative, got %s", opts.FlushInterval)
+	}
+
+	if opts.StatsInterval < 0 {
+		return fmt.Errorf("stats interval must not be negative, got %s", opts.StatsInterval)
+	}
+
+	if opts.CheckpointInterval < 0 {
+		return fmt.Errorf("checkpoint interval must not be negative, got %s", opts.CheckpointInterval)
+	}
+
+	if opts.CheckpointInterval > 0 || opts.Resume {
+		err := opts.validateCheckpoints()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := opts.validateInputStream()
+	if err != nil {
+		return err
+	}
+
+	err = opts.validateExistingOutput()
+	if err != nil {
+		return err
+	}
+
+	err = opts.validateStrictUTF8()
+	if err != nil {
+		return err
+	}
+
+	_, err = parsePointer(opts.Root)
+	if err != nil {
+		return err
+	}
+
+	if len(opts.Redact) > 0 {
+		_, err = parseRedact(opts.Redact)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Filter != "" {
+		_, err = parseFilter(opts.Filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.SinceField == "" && (!opts.Since.IsZero() || opts.KeepUntimed) {
+		return errors.New("a since cutoff needs a since field to compare with")
+	}
+
+	if opts.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid file mode %v, only permission bits can be set", opts.FileMode)
+	}
+
+	if opts.DirMode&^(os.ModePerm|os.ModeSetgid|os.ModeSticky) != 0 {
+		return fmt.Errorf("invalid directory mode %v, only permission, setgid and sticky bits can be set", opts.DirMode)
+	}
+
+	if opts.NameTemplate != "" {
+		_, err = parseNameTemplate(opts.NameTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validateKeyRoutes(opts.KeyRoutes)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Format {
+	case "", FormatJSONL, FormatNDJSON, FormatCSV, FormatMsgpack, FormatParquet:
+	default:
+		return fmt.Errorf("unsupported output format %q", opts.Format)
+	}
+
+	for key, format := range opts.KeyFormats {
+		switch format {
+		case FormatJSONL, FormatNDJSON, FormatCSV, FormatMsgpack, FormatParquet:
+		default:
+			return fmt.Errorf("unsupported output format %q for %s", format, key)
+		}
+	}
+
+	err = opts.CSV.validate()
+	if err != nil {
+		return err
+	}
+
+	for _, format := range []Format{FormatCSV, FormatMsgpack, FormatParquet} {
+		if opts.Pretty && opts.writesFormat(format) {
+			return fmt.Errorf("pretty output can't be written as %s", format)
+		}
+	}
+
+	return nil
+}
+
+// validateCheckpoints returns an error if the options write files which can't be resumed part way through
+func (opts Options) validateCheckpoints() error {
+	var option string
+
+	switch {
+	case opts.Concurrency > 1:
+		option = "concurrency"
+	case opts.Shards > 0:
+		option = "shards"
+	case opts.writesFormat(FormatCSV):
+		option = "csv output"
+	case opts.writesFormat(FormatMsgpack):
+		option = "msgpack output"
+	case opts.writesFormat(FormatParquet):
+		option = "parquet output"
+	case opts.InferSchema || opts.BigQuerySchema:
+		option = "schema inference"
+	case opts.CompressOutput:
+		option = "compressed output"
+	case opts.SkipErrors:
+		option = "skipping errors"
+	case opts.DryRun:
+		option = "a dry run"
+	case opts.Limit > 0:
+		option = "a limit"
+	case opts.Checksums:
+		option = "checksums"
+	case opts.JSON5:
+		option = "json5 input"
+	case opts.DedupField != "":
+		option = "deduplication"
+	case opts.SortBy != "":
+		option = "sorting"
+	case opts.Filter != "":
+		option = "a filter"
+	case opts.SinceField != "":
+		option = "a since filter"
+	case opts.RecordTransform != nil:
+		option = "a record transform"
+	case opts.NormalizeNames:
+		option = "normalized names"
+	case opts.ValueFiles:
+		option = "value files"
+	case opts.partitioned():
+		option = "partitions"
+	case opts.KeyField != "" || opts.IndexField != "" || opts.SourceField != "":
+		option = "annotations"
+	case opts.existingOutput() == ExistingOutputAppend:
+		option = "appending to existing output"
+	case opts.AtomicFiles:
+		option = "atomic files"
+	case len(opts.RequireKeys) > 0:
+		option = "required keys"
+	default:
+		return nil
+	}
+
+	return fmt.Errorf("checkpoints can't be used with %s", option)
+}
+
+// dateFormat returns the layout the dates of partitions are formatted with
+func (opts Options) dateFormat() string {
+	if opts.DateFormat == "" {
+		return DefaultDateFormat
+	}
+
+	return opts.DateFormat
+}
+
+// partitioned reports whether the elements of a json array at the root of the document are written to partitions, by
+// PartitionBy, PartitionByDate or FilenameField
+func (opts Options) partitioned() bool {
+	return opts.PartitionBy != "" || opts.PartitionByDate != "" || opts.FilenameField != ""
+}
+
+// maxOpenPartitions returns the number of partitions whose files are kept open at once
+func (opts Options) maxOpenPartitions() int {
+	if opts.MaxOpenPartitions == 0 {
+		return DefaultMaxOpenPartitions
+	}
+
+	return opts.MaxOpenPartitions
+}
+
+// validateObject returns an error if the options shard or partition the elements of a json array at the root of the
+// document, which can't be used when the document being split is an object
+func (opts Options) validateObject() error {
+	var option string
+
+	switch {
+	case opts.PartitionBy != "":
+		option = "partition by " + opts.PartitionBy
+	case opts.PartitionByDate != "":
+		option = "partition by date " + opts.PartitionByDate
+	case opts.FilenameField != "":
+		option = "filename field " + opts.FilenameField
+	case opts.HashPartition != "":
+		option = "hash partition " + opts.HashPartition
+	case opts.Shards > 0:
+		option = "shards"
+	default:
+		return nil
+	}
+
+	return fmt.Errorf("%s can't be used when the document is an object, only with an array or a stream of json values",
+		option)
+}
+
+// validateFiles returns an error if the options can't be used for splitting several files with SplitFiles
+func (opts Options) validateFiles() error {
+	var option string
+
+	switch {
+	case opts.CheckpointInterval > 0 || opts.Resume:
+		option = "checkpoints"
+	case opts.Concurrency > 1:
+		option = "concurrency, use file concurrency instead"
+	case opts.Shards > 0:
+		option = "shards"
+	case opts.partitioned():
+		option = "partitions"
+	case opts.existingOutput() == ExistingOutputAppend:
+		option = "appending to existing output"
+	case opts.ValueFiles:
+		option = "value files"
+	case opts.InputStream == InputStreamValues:
+		option = "a stream of json values"
+	default:
+		return nil
+	}
+
+	return fmt.Errorf("several files can't be split with %s", option)
+}
+
+// fileConcurrency returns the number of files SplitFiles splits at once
+func (opts Options) fileConcurrency() int {
+	if opts.FileConcurrency == 0 {
+		return 1
+	}
+
+	return opts.FileConcurrency
+}
+
+// maxSortMemory returns the number of bytes of items held in memory for each list being sorted
+func (opts Options) maxSortMemory() int64 {
+	if opts.MaxSortMemory == 0 {
+		return DefaultMaxSortMemory
+	}
+
+	return opts.MaxSortMemory
+}
+
+// maxDepth returns the deepest objects and arrays can be nested within a value
+func (opts Options) maxDepth() int {
+	if opts.MaxDepth == 0 {
+		return DefaultMaxDepth
+	}
+
+	return opts.MaxDepth
+}
+
+// gzipLevel returns the level compressed files are written at
+func (opts Options) gzipLevel() int {
+	if opts.GzipLevel == 0 {
+		return gzip.DefaultCompression
+	}
+
+	return opts.GzipLevel
+}
+
+// format returns the format list items are written in
+func (opts Options) format() Format {
+	if opts.Format == "" {
+		return FormatJSONL
+	}
+
+	return opts.Format
+}
+
+// forKey returns the options the list of the key is written with, in the format set for it by KeyFormats
+func (opts Options) forKey(key string) Options {
+	if format, ok := opts.KeyFormats[key]; ok {
+		opts.Format = format
+	}
+
+	return opts
+}
+
+// writesFormat reports whether any list is written in the format, by default or for its key
+func (opts Options) writesFormat(format Format) bool {
+	if opts.format() == format {
+		return true
+	}
+
+	for _, f := range opts.KeyFormats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statsInterval returns how often the progress of the split is reported
+func (opts Options) statsInterval() time.Duration {
+	if opts.StatsInterval == 0 {
+		return DefaultStatsInterval
+	}
+
+	return opts.StatsInterval
+}
+
+// splitsKey reports whether the key of the object being split is written
+func (opts Options) splitsKey(key string) bool {
+	if len(opts.IncludeKeys) > 0 || opts.IncludeKeyPattern != nil {
+		return containsKey(opts.IncludeKeys, key) || matchesKey(opts.IncludeKeyPattern, key)
+	}
+
+	return !containsKey(opts.ExcludeKeys, key) && !matchesKey(opts.ExcludeKeyPattern, key)
+}
+
+// containsKey reports whether keys contains key
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesKey reports whether pattern, which may be nil, matches key
+func matchesKey(pattern *regexp.Regexp, key string) bool {
+	return pattern != nil && pattern.MatchString(key)
+}
+
+// nameTemplate returns the template naming output files, or nil if they are given the default names
+func (opts Options) nameTemplate() *template.Template {
+	if opts.NameTemplate == "" {
+		return nil
+	}
+
+	// validate has already checked the template can be parsed
+	tmpl, _ := parseNameTemplate(opts.NameTemplate)
+
+	return tmpl
+}
+
+// rootListKey returns the key naming the files the elements of a document which is a json array are written to
+func (opts Options) rootListKey() string {
+	if opts.RootListKey == "" {
+		return DefaultRootListKey
+	}
+
+	return opts.RootListKey
+}
+
+// splitSize returns the number of bytes after which jsonl files are split
+func (opts Options) splitSize() uint64 {
+	if opts.MaxFileBytes == 0 {
+		return DefaultMaxFileBytes
+	}
+
+	return uint64(opts.MaxFileBytes)
+}
+
+// fileFull reports whether a file of a list holding the given number of bytes and items is full, so that the items
+// which follow are written to the next file
+func (opts Options) fileFull(bytes uint64, records int64) bool {
+	if opts.RecordsPerFile > 0 {
+		return records >= int64(opts.RecordsPerFile)
+	}
+
+	return bytes >= opts.splitSize()
+}
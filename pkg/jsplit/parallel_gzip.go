@@ -0,0 +1,76 @@
+package jsplit
+
+import (
+	"io"
+
+	"github.com/klauspost/pgzip"
+)
+
+// DefaultGzipBlockSize is the size of the blocks ParallelGzipWriteCloser compresses when Options.GzipBlockSize isn't
+// set
+const DefaultGzipBlockSize = 1 << 20
+
+// minGzipBlockSize is the size blocks must be larger than, the end of each block being the dictionary of the next
+const minGzipBlockSize = 16 << 10
+
+// ParallelGzipWriteCloser gzip compresses the data written to an io.WriteCloser like GzipWriteCloser, but in blocks
+// compressed on up to concurrency goroutines at once with klauspost/pgzip. The blocks join into the single deflate
+// stream of a standard gzip file, which any gzip reader can decompress, a little larger than GzipWriteCloser would
+// write. Up to concurrency blocks and their compressed data are held in memory at once.
+type ParallelGzipWriteCloser struct {
+	gzWr *pgzip.Writer
+	wr   io.WriteCloser
+}
+
+// NewParallelGzipWriteCloser returns a ParallelGzipWriteCloser writing data compressed at the given level to the
+// supplied io.WriteCloser, in blocks of blockSize bytes, DefaultGzipBlockSize when it isn't positive, compressed on up
+// to concurrency goroutines at once. It returns an error if the level isn't one accepted by gzip.NewWriterLevel, or if
+// the blocks aren't larger than 16KB.
+func NewParallelGzipWriteCloser(wr io.WriteCloser, level, blockSize, concurrency int) (*ParallelGzipWriteCloser,
+	error) {
+	gzWr, err := pgzip.NewWriterLevel(wr, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if blockSize <= 0 {
+		blockSize = DefaultGzipBlockSize
+	}
+
+	err = gzWr.SetConcurrency(blockSize, max(concurrency, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParallelGzipWriteCloser{gzWr: gzWr, wr: wr}, nil
+}
+
+// Write adds p to the blocks being compressed. An error compressing or writing an earlier block may be returned by a
+// later call, and is always returned by Sync and Close.
+func (pgw *ParallelGzipWriteCloser) Write(p []byte) (int, error) {
+	return pgw.gzWr.Write(p)
+}
+
+// Sync writes the data compressed so far to the io.WriteCloser, so that it can be decompressed up to there, and syncs
+// the io.WriteCloser
+func (pgw *ParallelGzipWriteCloser) Sync() error {
+	err := pgw.gzWr.Flush()
+	if err != nil {
+		return err
+	}
+
+	return syncWriter(pgw.wr)
+}
+
+// Close compresses the rest of the data, writes the gzip trailer and closes the supplied io.WriteCloser. The
+// io.WriteCloser is closed even if compressing or writing fails.
+func (pgw *ParallelGzipWriteCloser) Close() error {
+	gzErr := pgw.gzWr.Close()
+
+	err := pgw.wr.Close()
+	if gzErr != nil {
+		return gzErr
+	}
+
+	return err
+}
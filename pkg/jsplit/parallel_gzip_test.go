@@ -0,0 +1,227 @@
+package jsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testJsonl returns n lines of jsonl, compressible as output is
+func testJsonl(n int) []byte {
+	var buf bytes.Buffer
+
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `{"id":%d,"name":"user %d","email":"user%d@example.com","score":%d}`+"\n", i, i*7, i%97,
+			i*i%1013)
+	}
+
+	return buf.Bytes()
+}
+
+// readGzipMember decompresses data, requiring it to be a single gzip member as compress/gzip writes
+func readGzipMember(t *testing.T, data []byte) []byte {
+	gzRd, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	gzRd.Multistream(false)
+
+	decompressed, err := io.ReadAll(gzRd)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, gzRd.Reset(bytes.NewReader(nil)), io.EOF)
+
+	return decompressed
+}
+
+func TestParallelGzipWriteCloser(t *testing.T) {
+	input := testJsonl(5000)
+
+	tests := map[string]struct {
+		level, blockSize, concurrency int
+	}{
+		"default":          {gzip.DefaultCompression, 0, 4},
+		"blocks":           {gzip.DefaultCompression, 32 << 10, 4},
+		"smallest blocks":  {gzip.DefaultCompression, 16<<10 + 1, 3},
+		"best speed":       {gzip.BestSpeed, 50 << 10, 8},
+		"best compression": {gzip.BestCompression, 50 << 10, 2},
+		"huffman only":     {gzip.HuffmanOnly, 50 << 10, 2},
+		"one goroutine":    {gzip.DefaultCompression, 50 << 10, 1},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			buf := NewBufWriteCloser()
+			pgw, err := NewParallelGzipWriteCloser(buf, test.level, test.blockSize, test.concurrency)
+			require.NoError(t, err)
+
+			// written in pieces which don't line up with the blocks
+			for rest := input; len(rest) > 0; {
+				n := min(len(rest), 7777)
+				_, err = pgw.Write(rest[:n])
+				require.NoError(t, err)
+
+				rest = rest[n:]
+			}
+
+			require.NoError(t, pgw.Close())
+			require.Equal(t, input, readGzipMember(t, buf.Bytes()))
+			require.Less(t, len(buf.Bytes()), len(input))
+		})
+	}
+}
+
+func TestParallelGzipWriteCloserStandardGzip(t *testing.T) {
+	gzipPath, err := exec.LookPath("gzip")
+	if err != nil {
+		t.Skip("gzip isn't installed")
+	}
+
+	input := testJsonl(2000)
+
+	buf := NewBufWriteCloser()
+	pgw, err := NewParallelGzipWriteCloser(buf, gzip.DefaultCompression, 20<<10, 4)
+	require.NoError(t, err)
+
+	_, err = pgw.Write(input)
+	require.NoError(t, err)
+	require.NoError(t, pgw.Close())
+
+	// the gzip tool checks the crc and length of the trailer as it decompresses the file
+	cmd := exec.Command(gzipPath, "-dc")
+	cmd.Stdin = bytes.NewReader(buf.Bytes())
+
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	require.Equal(t, input, out)
+}
+
+func TestParallelGzipWriteCloserSync(t *testing.T) {
+	buf := NewBufWriteCloser()
+	pgw, err := NewParallelGzipWriteCloser(buf, gzip.DefaultCompression, 20<<10, 2)
+	require.NoError(t, err)
+
+	_, err = pgw.Write([]byte("first\n"))
+	require.NoError(t, err)
+	require.NoError(t, pgw.Sync())
+
+	// what was written before the sync can be decompressed before the file is closed
+	gzRd, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	synced := make([]byte, 6)
+	_, err = io.ReadFull(gzRd, synced)
+	require.NoError(t, err)
+	require.Equal(t, "first\n", string(synced))
+
+	_, err = pgw.Write([]byte(strings.Repeat("second\n", 10000)))
+	require.NoError(t, err)
+	require.NoError(t, pgw.Sync())
+	require.NoError(t, pgw.Close())
+	require.Equal(t, "first\n"+strings.Repeat("second\n", 10000), string(readGzipMember(t, buf.Bytes())))
+
+	// a file without any data is an empty gzip file
+	buf = NewBufWriteCloser()
+	pgw, err = NewParallelGzipWriteCloser(buf, gzip.DefaultCompression, 0, 2)
+	require.NoError(t, err)
+	require.NoError(t, pgw.Close())
+	require.Empty(t, readGzipMember(t, buf.Bytes()))
+
+	_, err = NewParallelGzipWriteCloser(buf, 10, 0, 2)
+	require.EqualError(t, err, "gzip: invalid compression level: 10")
+
+	_, err = NewParallelGzipWriteCloser(buf, gzip.DefaultCompression, 16<<10, 2)
+	require.EqualError(t, err, "gzip: block size cannot be less than or equal to 16384")
+}
+
+func TestParallelGzipWriteCloserWriteError(t *testing.T) {
+	wr := &failingWriteCloser{}
+
+	pgw, err := NewParallelGzipWriteCloser(wr, gzip.DefaultCompression, 20<<10, 2)
+	require.NoError(t, err)
+
+	// the error writing the header is returned straight away, and by every write after it
+	input := testJsonl(2000)
+	_, err = pgw.Write(input)
+	require.EqualError(t, err, "write failed")
+
+	_, err = pgw.Write(input)
+	require.EqualError(t, err, "write failed")
+	require.EqualError(t, pgw.Close(), "write failed")
+	require.True(t, wr.closed)
+}
+
+func TestSplitStreamGzipConcurrency(t *testing.T) {
+	var items []string
+	for i := 0; i < 5000; i++ {
+		items = append(items, fmt.Sprintf(`{"id":%d}`, i))
+	}
+
+	doc := `{"key": "value", "users": [` + strings.Join(items, ",") + `]}`
+
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 64), tempDir,
+		Options{CompressOutput: true, GzipConcurrency: 4, GzipBlockSize: 20 << 10}))
+	requireGzipContents(t, filepath.Join(tempDir, "users_00.jsonl.gz"), strings.Join(items, "\n"))
+	requireGzipContents(t, filepath.Join(tempDir, "root.json.gz"), "{\n\t\"key\":\"value\"\n}")
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "users_00.jsonl.gz"))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), readManifest(t, tempDir).Keys[0].Files[0].Bytes)
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 64), t.TempDir(),
+		Options{CompressOutput: true, GzipConcurrency: -1})
+	require.EqualError(t, err, "gzip concurrency must not be negative, got -1")
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 64), t.TempDir(),
+		Options{CompressOutput: true, GzipConcurrency: 4, GzipBlockSize: 256})
+	require.EqualError(t, err, "gzip block size must be more than 16384 bytes, got 256")
+}
+
+// benchmarkGzip measures the throughput of compressing jsonl with the writer returned by newWriter
+func benchmarkGzip(b *testing.B, newWriter func(wr io.WriteCloser) (io.WriteCloser, error)) {
+	input := testJsonl(200000)
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		wr, err := newWriter(discardWriteCloser{})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		_, err = wr.Write(input)
+		if err == nil {
+			err = wr.Close()
+		}
+
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGzipWriteCloser(b *testing.B) {
+	benchmarkGzip(b, func(wr io.WriteCloser) (io.WriteCloser, error) {
+		return NewGzipWriteCloserLevel(wr, gzip.DefaultCompression)
+	})
+}
+
+func BenchmarkParallelGzipWriteCloser(b *testing.B) {
+	for _, concurrency := range []int{2, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency %d", concurrency), func(b *testing.B) {
+			benchmarkGzip(b, func(wr io.WriteCloser) (io.WriteCloser, error) {
+				return NewParallelGzipWriteCloser(wr, gzip.DefaultCompression, DefaultGzipBlockSize, concurrency)
+			})
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package jsplit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRowGroupBytes is about the number of bytes of encoded values a ParquetWriteCloser holds before writing them
+// as a row group
+const parquetRowGroupBytes = 64 * 1024 * 1024
+
+// parquetKind is a set of the kinds of json values found in a column
+type parquetKind uint8
+
+const (
+	parquetKindNull parquetKind = 1 << iota
+	parquetKindBool
+	parquetKindInt
+	parquetKindDouble
+	parquetKindString
+	parquetKindNested
+)
+
+// ParquetWriteCloser receives jsonl formatted data, one json object per line, and writes it to an io.WriteCloser as a
+// Parquet file. Each key of the objects is an optional column, in the order the keys are first seen, holding nulls
+// for the objects without the key. The type of each column is inferred from its values: booleans, integers which fit
+// in an int64, other numbers as doubles and strings as UTF-8 byte arrays. A column whose values aren't all of one kind
+// fails the file, as do nested objects and arrays unless they are written as json strings, see
+// Options.ParquetNestedJSON, and files of objects without any keys. The file is written uncompressed with parquet-go.
+//
+// As the schema can't be known until every object has been seen, the jsonl data is spilled to a temporary file and
+// converted once Close is called, as CsvWriteCloser does. The rows are then written in row groups of about 64MB of
+// values, so that only one row group is held in memory at a time.
+type ParquetWriteCloser struct {
+	wr            io.WriteCloser
+	spill         *jsonlSpill
+	nestedJSON    bool
+	rowGroupBytes int
+}
+
+// NewParquetWriteCloser returns a ParquetWriteCloser which writes a Parquet file to the supplied io.WriteCloser,
+// writing nested objects and arrays as json strings if nestedJSON is set
+func NewParquetWriteCloser(wr io.WriteCloser, nestedJSON bool) (*ParquetWriteCloser, error) {
+	return newParquetWriteCloser(wr, "", nestedJSON)
+}
+
+// newParquetWriteCloser returns a ParquetWriteCloser spilling the jsonl data to a temporary file in tempDir, or in the
+// default directory for temporary files when it is empty, see Options.TempDir
+func newParquetWriteCloser(wr io.WriteCloser, tempDir string, nestedJSON bool) (*ParquetWriteCloser, error) {
+	spill, err := newJsonlSpill(tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParquetWriteCloser{wr: wr, spill: spill, nestedJSON: nestedJSON, rowGroupBytes: parquetRowGroupBytes},
+		nil
+}
+
+// Write spills jsonl data to the temporary file
+func (pwc *ParquetWriteCloser) Write(p []byte) (int, error) {
+	return pwc.spill.Write(p)
+}
+
+// Close converts the spilled jsonl data to Parquet, closes the supplied io.WriteCloser, and removes the temporary
+// file. The io.WriteCloser is closed even if the conversion fails.
+func (pwc *ParquetWriteCloser) Close() error {
+	convertErr := pwc.convert()
+
+	spillErr := pwc.spill.close()
+	err := pwc.wr.Close()
+
+	switch {
+	case convertErr != nil:
+		return convertErr
+	case spillErr != nil:
+		return spillErr
+	default:
+		return err
+	}
+}
+
+// parquetColumn is a column of the file being written, holding the kinds of json values it was found to have
+type parquetColumn struct {
+	name  string
+	kinds parquetKind
+	node  parquet.Node // the optional leaf the column's values are written as, once its type is resolved
+}
+
+// parquetSchema is the root of the schema of the file, which lists its columns in the order their keys were first
+// seen, where parquet.Group orders them by name
+type parquetSchema struct {
+	parquet.Group
+	fields []parquet.Field
+}
+
+// Fields returns the columns in the order their keys were first seen
+func (ps *parquetSchema) Fields() []parquet.Field {
+	return ps.fields
+}
+
+// convert infers the columns from the spilled data, and then writes its rows in row groups
+func (pwc *ParquetWriteCloser) convert() error {
+	err := pwc.spill.flush()
+	if err != nil {
+		return err
+	}
+
+	var (
+		columns []*parquetColumn
+		index   = make(map[string]int)
+	)
+
+	err = pwc.spill.eachObject(FormatParquet, func(fields []objectField) error {
+		for _, field := range fields {
+			i, ok := index[field.Key]
+			if !ok {
+				i = len(columns)
+				index[field.Key] = i
+				columns = append(columns, &parquetColumn{name: field.Key})
+			}
+
+			columns[i].kinds |= parquetKindOf(field.Value)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// parquet-go can't write a file without columns
+	if len(columns) == 0 {
+		return errors.New("parquet output needs objects with at least one field, to write as a column")
+	}
+
+	schema := &parquetSchema{Group: parquet.Group{}}
+
+	for _, col := range columns {
+		err = col.resolveType(pwc.nestedJSON)
+		if err != nil {
+			return err
+		}
+
+		// a group of a single column is how parquet-go names a field
+		field := parquet.Group{col.name: parquet.Optional(col.node)}
+		schema.Group[col.name] = field[col.name]
+		schema.fields = append(schema.fields, field.Fields()[0])
+	}
+
+	bw := bufio.NewWriterSize(pwc.wr, 256*1024)
+	pw := parquet.NewWriter(bw, parquet.NewSchema("schema", schema), parquet.Compression(&parquet.Uncompressed))
+
+	vals := make([]json.RawMessage, len(columns))
+	rows := []parquet.Row{make(parquet.Row, len(columns))}
+	pending := 0
+
+	err = pwc.spill.eachObject(FormatParquet, func(fields []objectField) error {
+		for i := range vals {
+			vals[i] = nil
+		}
+
+		for _, field := range fields {
+			vals[index[field.Key]] = field.Value
+		}
+
+		for i, col := range columns {
+			v, err := col.value(vals[i])
+			if err != nil {
+				return err
+			}
+
+			// the definition level of an optional column is 1 when it has a value and 0 when it is null
+			definition := 1
+			if v.IsNull() {
+				definition = 0
+			}
+
+			rows[0][i] = v.Level(0, definition, i)
+
+			pending += len(v.Bytes()) + 1
+		}
+
+		_, err := pw.WriteRows(rows)
+		if err != nil {
+			return err
+		}
+
+		if pending >= pwc.rowGroupBytes {
+			pending = 0
+			return pw.Flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = pw.Close()
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// parquetKindOf returns the kind of a json value
+func parquetKindOf(val json.RawMessage) parquetKind {
+	switch {
+	case len(val) == 0 || bytes.Equal(val, nullValue):
+		return parquetKindNull
+	case val[0] == 't' || val[0] == 'f':
+		return parquetKindBool
+	case val[0] == QM:
+		return parquetKindString
+	case val[0] == OpenCB || val[0] == OpenSB:
+		return parquetKindNested
+	}
+
+	if bytes.ContainsAny(val, ".eE") {
+		return parquetKindDouble
+	}
+
+	_, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil {
+		return parquetKindDouble
+	}
+
+	return parquetKindInt
+}
+
+// resolveType sets the type of the column from the kinds of its values, failing if they don't share one
+func (col *parquetColumn) resolveType(nestedJSON bool) error {
+	switch kinds := col.kinds &^ parquetKindNull; kinds {
+	case 0, parquetKindString:
+		col.node = parquet.String()
+	case parquetKindBool:
+		col.node = parquet.Leaf(parquet.BooleanType)
+	case parquetKindInt:
+		col.node = parquet.Int(64)
+	case parquetKindDouble, parquetKindInt | parquetKindDouble:
+		col.node = parquet.Leaf(parquet.DoubleType)
+	case parquetKindNested:
+		if !nestedJSON {
+			return fmt.Errorf("parquet output can't write the nested objects and arrays of %s, flatten the items "+
+				"or write nested values as json", col.name)
+		}
+
+		col.node = parquet.JSON()
+	default:
+		return fmt.Errorf("parquet output needs the values of each field to be of one type, %s holds %s", col.name,
+			kinds)
+	}
+
+	return nil
+}
+
+// String lists the kinds, e.g. strings and numbers
+func (kinds parquetKind) String() string {
+	var names []string
+
+	for _, kind := range []struct {
+		kind parquetKind
+		name string
+	}{
+		{parquetKindBool, "booleans"},
+		{parquetKindInt | parquetKindDouble, "numbers"},
+		{parquetKindString, "strings"},
+		{parquetKindNested, "objects or arrays"},
+	} {
+		if kinds&kind.kind != 0 {
+			names = append(names, kind.name)
+		}
+	}
+
+	return strings.Join(names, " and ")
+}
+
+// value returns the Parquet value of a json value of the column, which is null when it is nil
+func (col *parquetColumn) value(val json.RawMessage) (parquet.Value, error) {
+	if parquetKindOf(val) == parquetKindNull {
+		return parquet.NullValue(), nil
+	}
+
+	switch col.node.Type().Kind() {
+	case parquet.Boolean:
+		return parquet.BooleanValue(val[0] == 't'), nil
+	case parquet.Int64:
+		// the kind of the value has already been checked
+		i, _ := strconv.ParseInt(string(val), 10, 64)
+		return parquet.Int64Value(i), nil
+	case parquet.Double:
+		f, err := strconv.ParseFloat(string(val), 64)
+		if err != nil {
+			return parquet.Value{}, fmt.Errorf("unable to write %s of %s as a double: %w", val, col.name, err)
+		}
+
+		return parquet.DoubleValue(f), nil
+	}
+
+	if col.kinds&parquetKindNested == 0 {
+		var s string
+
+		err := json.Unmarshal(val, &s)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+
+		val = []byte(s)
+	}
+
+	return parquet.ByteArrayValue(val), nil
+}
@@ -0,0 +1,215 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+)
+
+// parquetTestColumn is a column of a Parquet file which was read back, along with its type, e.g. INT(64,true)
+type parquetTestColumn struct {
+	name string
+	typ  string
+}
+
+// readParquet reads back the columns and rows of a Parquet file with parquet-go, along with the number of rows in
+// each row group
+func readParquet(t *testing.T, data []byte) ([]parquetTestColumn, []map[string]any, []int64) {
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	fields := f.Schema().Fields()
+	columns := make([]parquetTestColumn, 0, len(fields))
+
+	for _, field := range fields {
+		require.True(t, field.Optional(), "every column is optional")
+		columns = append(columns, parquetTestColumn{name: field.Name(), typ: field.Type().String()})
+	}
+
+	var groups []int64
+
+	for _, group := range f.RowGroups() {
+		groups = append(groups, group.NumRows())
+	}
+
+	pr := parquet.NewReader(bytes.NewReader(data))
+	defer pr.Close()
+
+	var rows []map[string]any
+
+	buf := make([]parquet.Row, 8)
+
+	for {
+		n, err := pr.ReadRows(buf)
+
+		for _, row := range buf[:n] {
+			values := make(map[string]any, len(columns))
+
+			for _, v := range row {
+				name := columns[v.Column()].name
+
+				switch {
+				case v.IsNull():
+					values[name] = nil
+				case v.Kind() == parquet.Boolean:
+					values[name] = v.Boolean()
+				case v.Kind() == parquet.Int64:
+					values[name] = v.Int64()
+				case v.Kind() == parquet.Double:
+					values[name] = v.Double()
+				default:
+					values[name] = string(v.ByteArray())
+				}
+			}
+
+			rows = append(rows, values)
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, f.NumRows(), int64(len(rows)))
+
+	return columns, rows, groups
+}
+
+func writeTestParquet(t *testing.T, lines string, nestedJSON bool, rowGroupBytes int) ([]byte, error) {
+	buf := NewBufWriteCloser()
+	pwc, err := NewParquetWriteCloser(buf, nestedJSON)
+	require.NoError(t, err)
+
+	if rowGroupBytes > 0 {
+		pwc.rowGroupBytes = rowGroupBytes
+	}
+
+	_, err = pwc.Write([]byte(lines))
+	require.NoError(t, err)
+
+	err = pwc.Close()
+
+	return buf.Bytes(), err
+}
+
+func TestParquetWriteCloser(t *testing.T) {
+	lines := `{"id":1,"name":"alex","score":2,"active":true,"note":null}
+{"name":"brian, \"b\"","id":2,"score":2.5,"active":null}
+{"id":-3,"score":1e3,"active":false,"name":"ünïcode"}`
+
+	data, err := writeTestParquet(t, lines, false, 0)
+	require.NoError(t, err)
+
+	// the type of each column is inferred from its values, and strings and columns of nulls are utf8
+	columns, rows, groups := readParquet(t, data)
+	require.Equal(t, []parquetTestColumn{
+		{"id", "INT(64,true)"},
+		{"name", "STRING"},
+		{"score", "DOUBLE"},
+		{"active", "BOOLEAN"},
+		{"note", "STRING"},
+	}, columns)
+	require.Equal(t, []int64{3}, groups)
+
+	require.Equal(t, []map[string]any{
+		{"id": int64(1), "name": "alex", "score": 2.0, "active": true, "note": nil},
+		{"id": int64(2), "name": `brian, "b"`, "score": 2.5, "active": nil, "note": nil},
+		{"id": int64(-3), "name": "ünïcode", "score": 1000.0, "active": false, "note": nil},
+	}, rows)
+}
+
+func TestParquetWriteCloserRowGroups(t *testing.T) {
+	var (
+		lines    strings.Builder
+		expected []map[string]any
+	)
+
+	for i := 0; i < 20; i++ {
+		row := map[string]any{"i": int64(i), "even": i%2 == 0, "s": nil}
+		fmt.Fprintf(&lines, `{"i":%d,"even":%t`, i, i%2 == 0)
+
+		if i%3 == 0 {
+			row["s"] = strings.Repeat("x", i)
+			fmt.Fprintf(&lines, `,"s":"%s"`, row["s"])
+		}
+
+		lines.WriteString("}\n")
+		expected = append(expected, row)
+	}
+
+	// rows are written in row groups once their values pass the size
+	data, err := writeTestParquet(t, lines.String(), false, 100)
+	require.NoError(t, err)
+
+	_, rows, groups := readParquet(t, data)
+	require.Equal(t, expected, rows)
+	require.Greater(t, len(groups), 1)
+
+	// a file needs at least one column
+	for _, lines := range []string{"", "{}\n{}"} {
+		_, err = writeTestParquet(t, lines, false, 0)
+		require.EqualError(t, err, "parquet output needs objects with at least one field, to write as a column")
+	}
+}
+
+func TestParquetWriteCloserNested(t *testing.T) {
+	lines := `{"id":1,"address":{"city":"x"},"tags":["a","b"]}
+{"id":2,"address":null,"tags":[]}`
+
+	_, err := writeTestParquet(t, lines, false, 0)
+	require.EqualError(t, err, "parquet output can't write the nested objects and arrays of address, flatten the "+
+		"items or write nested values as json")
+
+	// nested values can be written as json strings instead
+	data, err := writeTestParquet(t, lines, true, 0)
+	require.NoError(t, err)
+
+	columns, rows, _ := readParquet(t, data)
+	require.Equal(t, parquetTestColumn{"address", "JSON"}, columns[1])
+	require.Equal(t, []map[string]any{
+		{"id": int64(1), "address": `{"city":"x"}`, "tags": `["a","b"]`},
+		{"id": int64(2), "address": nil, "tags": `[]`},
+	}, rows)
+
+	// values of different kinds can't share a column
+	_, err = writeTestParquet(t, `{"id":1}`+"\n"+`{"id":"2"}`, true, 0)
+	require.EqualError(t, err, "parquet output needs the values of each field to be of one type, id holds numbers "+
+		"and strings")
+
+	_, err = writeTestParquet(t, `{"id":1}`+"\n"+`[1,2]`, true, 0)
+	require.ErrorContains(t, err, "parquet output requires lists of json objects")
+}
+
+func TestSplitStreamParquet(t *testing.T) {
+	const doc = `{"key": "value", "users": [{"id": 1, "name": "alex"}, {"id": 2, "email": "b@example.com"}]}`
+
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), tempDir,
+		Options{Format: FormatParquet}))
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "users_00.parquet"))
+	require.NoError(t, err)
+
+	_, rows, _ := readParquet(t, data)
+	require.Equal(t, []map[string]any{
+		{"id": int64(1), "name": "alex", "email": nil},
+		{"id": int64(2), "name": nil, "email": "b@example.com"},
+	}, rows)
+
+	manifest := readManifest(t, tempDir)
+	require.Equal(t, int64(len(data)), manifest.Keys[0].Files[0].Bytes)
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), t.TempDir(),
+		Options{Format: FormatParquet, Pretty: true})
+	require.EqualError(t, err, "pretty output can't be written as parquet")
+}
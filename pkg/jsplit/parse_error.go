@@ -0,0 +1,75 @@
+package jsplit
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// snippetContext is the number of bytes either side of the position of a parse error included in its snippet
+const snippetContext = 32
+
+// ParseError is returned when the document being split isn't valid json. It describes where in the input parsing
+// failed, so that problems can be found in documents too large to open in an editor.
+type ParseError struct {
+	// Offset is the number of bytes of the input, after decompression, before the byte where parsing failed
+	Offset int64
+	// Line is the line of the input where parsing failed, counting from 1
+	Line int64
+	// Snippet is the input surrounding the byte where parsing failed
+	Snippet string
+	// Err describes why parsing failed
+	Err error
+}
+
+// Error returns a description of the error including where it happened
+func (pe *ParseError) Error() string {
+	return fmt.Sprintf("invalid json at byte %d (line %d) near %q: %s", pe.Offset, pe.Line, pe.Snippet, pe.Err)
+}
+
+// Unwrap returns the error describing why parsing failed
+func (pe *ParseError) Unwrap() error {
+	return pe.Err
+}
+
+// errorf returns a *ParseError for the byte most recently read from the iterator
+func (itr *BufferedByteStreamIter) errorf(format string, args ...interface{}) error {
+	// the input ended early because reading it failed, which is what went wrong
+	if itr.err != nil {
+		return itr.err
+	}
+
+	// the byte which couldn't be parsed has usually just been read
+	idx := itr.pos - 1
+	if idx < 0 {
+		idx = 0
+	}
+
+	if idx > len(itr.buffer) {
+		idx = len(itr.buffer)
+	}
+
+	// read ahead so the snippet includes the input following the error. Parsing has already failed, so read errors
+	// only mean the snippet is shorter.
+	for len(itr.buffer) < idx+snippetContext {
+		if itr.readMore() != nil {
+			break
+		}
+	}
+
+	before := append(append([]byte(nil), itr.recent...), itr.buffer[:idx]...)
+	if len(before) > snippetContext {
+		before = before[len(before)-snippetContext:]
+	}
+
+	end := idx + snippetContext
+	if end > len(itr.buffer) {
+		end = len(itr.buffer)
+	}
+
+	return &ParseError{
+		Offset:  itr.offset + int64(idx),
+		Line:    itr.lines + int64(bytes.Count(itr.buffer[:idx], newLineBytes)) + 1,
+		Snippet: string(before) + string(itr.buffer[idx:end]),
+		Err:     fmt.Errorf(format, args...),
+	}
+}
@@ -0,0 +1,85 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		bad  string // the input at the byte where parsing should fail
+		line int64
+	}{
+		{
+			name: "missing comma",
+			doc:  "{\n  \"key\": \"value\",\n  \"list\": [\n    {\"idx\": 0},\n    {\"idx\": 1} {\"idx\": 2}\n  ]\n}",
+			bad:  "{\"idx\": 2}",
+			line: 5,
+		},
+		{
+			name: "bad key",
+			doc:  "{\"a\": 1,\n\"b\": 2,\n c: 3}",
+			bad:  "c: 3}",
+			line: 3,
+		},
+		{
+			name: "not json",
+			doc:  "hello",
+			bad:  "hello",
+			line: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// reading the document a few bytes at a time checks the position is tracked across chunks
+			for _, chunkSize := range []int{1, 3, 16, 1024} {
+				bs := NewTestByteStream([]byte(test.doc), chunkSize)
+				err := SplitStream(context.Background(), bs, t.TempDir(), Options{})
+
+				var pe *ParseError
+				require.True(t, errors.As(err, &pe), "chunk size %d: %v", chunkSize, err)
+				offset := strings.Index(test.doc, test.bad)
+				require.Equal(t, int64(offset), pe.Offset, "chunk size %d", chunkSize)
+				require.Equal(t, test.line, pe.Line, "chunk size %d", chunkSize)
+				require.Equal(t, snippet(test.doc, offset), pe.Snippet, "chunk size %d", chunkSize)
+				require.Contains(t, err.Error(), "invalid json at byte")
+			}
+		})
+	}
+}
+
+func TestParseErrorSnippetLength(t *testing.T) {
+	doc := `{"list": [` + strings.Repeat(`"0123456789",`, 20) + `"unterminated]}`
+
+	bs := NewTestByteStream([]byte(doc), 7)
+	err := SplitStream(context.Background(), bs, t.TempDir(), Options{})
+
+	var pe *ParseError
+	require.True(t, errors.As(err, &pe))
+	require.LessOrEqual(t, len(pe.Snippet), 2*snippetContext)
+	require.True(t, strings.HasSuffix(doc, pe.Snippet))
+	// errors at the end of the input are reported at the last byte
+	require.Equal(t, int64(len(doc)-1), pe.Offset)
+}
+
+// snippet returns the expected snippet of doc for an error at offset
+func snippet(doc string, offset int) string {
+	start := offset - snippetContext
+	if start < 0 {
+		start = 0
+	}
+
+	end := offset + snippetContext
+	if end > len(doc) {
+		end = len(doc)
+	}
+
+	return doc[start:end]
+}
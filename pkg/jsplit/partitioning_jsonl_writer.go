@@ -0,0 +1,325 @@
+package jsplit
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PartitioningJsonlWriter receives json objects one at a time and writes each to a SplittingJsonlWriter for the value
+// of a field of the object, or the date of a timestamp field, creating one the first time each value is seen. Only a
+// limited number of partitions have a file open at once, the partition written to longest ago being closed to make
+// room for the next, and reopened for appending if it is written to again.
+type PartitioningJsonlWriter struct {
+	sink       OutputSink
+	opts       Options
+	bufferSize int
+	names      *fileNames
+	partitions map[string]*partition
+	order      []*partition // partitions in the order they were first written to
+	open       *list.List   // partitions which may have a file open, the most recently written first
+	maxOpen    int
+	overflow   string    // the value of Options.OverflowPartition's partition, empty when it isn't set
+	synced     time.Time // when the files of the open partitions were last checked for syncing, see syncIdle
+}
+
+// partition is the writer for the elements with one value of the field
+type partition struct {
+	value   string
+	factory *BufferedWriterFactory
+	wr      *SplittingJsonlWriter
+	elem    *list.Element // the partition's element of open, nil once its file has been closed to make room
+
+	// closed is set when the file being written was closed to make room for other partitions, along with the number
+	// of bytes of items which had been written to it
+	closed       bool
+	writtenBytes uint64
+}
+
+// NewPartitioningJsonlWriter returns a *PartitioningJsonlWriter writing the elements with each value of
+// opts.PartitionBy, or of opts.FilenameField without the field, to jsonl files named [value]_%02d.jsonl in the supplied
+// sink, in the format and with the rollover and compression set in opts. When opts.PartitionByDate is set instead the
+// elements of each date are written to files named after opts.RootListKey in the directory of the date, e.g.
+// dt=2024-01-01/root_00.jsonl. At most opts.MaxOpenPartitions files are open at once.
+func NewPartitioningJsonlWriter(sink OutputSink, bufferSize int, opts Options) *PartitioningJsonlWriter {
+	return &PartitioningJsonlWriter{
+		sink:       sink,
+		opts:       opts,
+		bufferSize: bufferSize,
+		names:      newFileNames(opts),
+		partitions: make(map[string]*partition),
+		open:       list.New(),
+		maxOpen:    opts.maxOpenPartitions(),
+		overflow:   overflowPartition(opts),
+	}
+}
+
+// overflowPartition returns the value of the partition the elements are written to once Options.MaxFiles is close to
+// being reached, or an empty string if Options.OverflowPartition isn't set
+func overflowPartition(opts Options) string {
+	switch {
+	case opts.OverflowPartition == "":
+		return ""
+	case opts.PartitionByDate != "":
+		return datePartitionPrefix + opts.OverflowPartition
+	}
+
+	return opts.OverflowPartition
+}
+
+// Add writes the item to the partition for its value of the field
+func (pwr *PartitioningJsonlWriter) Add(item []byte) error {
+	var value string
+
+	switch {
+	case pwr.opts.PartitionByDate != "":
+		value = datePartition(item, pwr.opts)
+	case pwr.opts.FilenameField != "":
+		value, item = filenameValue(item, pwr.opts.FilenameField)
+	default:
+		var ok bool
+
+		value, _, ok = fieldValue(item, pwr.opts.PartitionBy)
+		if !ok || value == "" {
+			value = MissingPartition
+		}
+	}
+
+	p, err := pwr.partition(pwr.route(value))
+	if err == nil {
+		err = p.wr.Add(item)
+	}
+
+	if err == nil && pwr.opts.FlushInterval > 0 {
+		err = pwr.syncIdle(time.Now())
+	}
+
+	if errors.Is(err, ErrTooManyFiles) {
+		return fmt.Errorf("partitioning by %s, the value %s: %w", pwr.field(), value, err)
+	}
+
+	return err
+}
+
+// filenameValue returns the value of the field of the item, naming the partition it is written to, along with the item
+// without the field. Items without a value for the field are written to MissingPartition as they are.
+func filenameValue(item []byte, field string) (string, []byte) {
+	value, _, ok := fieldValue(item, field)
+	if !ok || value == "" {
+		return MissingPartition, item
+	}
+
+	fields, err := decodeObject(item)
+	if err != nil {
+		return MissingPartition, item
+	}
+
+	kept := fields[:0]
+
+	for _, f := range fields {
+		if f.Key != field {
+			kept = append(kept, f)
+		}
+	}
+
+	return value, encodeObject(kept)
+}
+
+// field returns the field the elements are partitioned by
+func (pwr *PartitioningJsonlWriter) field() string {
+	switch {
+	case pwr.opts.PartitionByDate != "":
+		return pwr.opts.PartitionByDate
+	case pwr.opts.FilenameField != "":
+		return pwr.opts.FilenameField
+	}
+
+	return pwr.opts.PartitionBy
+}
+
+// route returns the partition the element with the value is written to, which is the overflow partition for a new
+// value once starting its partition would leave no room for the overflow partition's first file
+func (pwr *PartitioningJsonlWriter) route(value string) string {
+	if pwr.overflow == "" || value == pwr.overflow {
+		return value
+	}
+
+	if _, ok := pwr.partitions[value]; ok {
+		return value
+	}
+
+	needed := 1
+	if _, ok := pwr.partitions[pwr.overflow]; !ok {
+		needed = 2
+	}
+
+	if pwr.opts.files.allows(needed) {
+		return value
+	}
+
+	if _, ok := pwr.partitions[pwr.overflow]; !ok {
+		logger().Warn(fmt.Sprintf("partitioning by %s would write more than %d files, the elements with %s and the "+
+			"other values which follow are written to the partition %s", pwr.field(), pwr.opts.MaxFiles, value,
+			pwr.overflow), "field", pwr.field(), "value", value, "partition", pwr.overflow)
+	}
+
+	return pwr.overflow
+}
+
+// partition returns the partition for the value, creating it if it is the first element with the value, and making
+// sure it can write to its file
+func (pwr *PartitioningJsonlWriter) partition(value string) (*partition, error) {
+	p, ok := pwr.partitions[value]
+	if !ok {
+		name := value
+		if pwr.opts.PartitionByDate != "" {
+			name = pwr.opts.rootListKey()
+		}
+
+		fileName, err := pwr.names.name(name)
+		if err != nil {
+			return nil, fmt.Errorf("partitioning by %s: %w", pwr.field(), err)
+		}
+
+		factory := newWriterFactory(pwr.sink, fileName, pwr.bufferSize, pwr.opts)
+		if pwr.opts.PartitionByDate != "" {
+			factory.dir = value
+		}
+
+		p = &partition{value: value, factory: factory, wr: newListWriter(factory, pwr.opts)}
+
+		pwr.partitions[value] = p
+		pwr.order = append(pwr.order, p)
+	}
+
+	if p.elem != nil {
+		pwr.open.MoveToFront(p.elem)
+		return p, nil
+	}
+
+	if pwr.open.Len() >= pwr.maxOpen {
+		err := pwr.closeOldest()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.closed {
+		err := pwr.reopen(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.elem = pwr.open.PushFront(p)
+
+	return p, nil
+}
+
+// syncIdle syncs the files of the open partitions which haven't been synced for Options.FlushInterval, at now, as they
+// may not be written to again for a while. The open partitions are checked at most once every interval. When as many
+// files are open as can be, the partition written to longest ago is left alone, as its file is the next closed to make
+// room, which syncs it anyway.
+func (pwr *PartitioningJsonlWriter) syncIdle(now time.Time) error {
+	if now.Sub(pwr.synced) < pwr.opts.FlushInterval {
+		return nil
+	}
+
+	pwr.synced = now
+
+	for elem := pwr.open.Front(); elem != nil; elem = elem.Next() {
+		if elem == pwr.open.Back() && pwr.open.Len() >= pwr.maxOpen {
+			break
+		}
+
+		err := elem.Value.(*partition).wr.syncIfDue(now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closeOldest closes the file of the partition written to longest ago, to make room for another
+func (pwr *PartitioningJsonlWriter) closeOldest() error {
+	p := pwr.open.Remove(pwr.open.Back()).(*partition)
+	p.elem = nil
+
+	// a partition whose last file reached the size limit starts a new file with its next element anyway
+	if p.wr.wr == nil {
+		return nil
+	}
+
+	p.closed = true
+	p.writtenBytes = p.wr.writtenBytes
+
+	return p.wr.Close()
+}
+
+// reopen reopens the file the partition was writing when it was closed to make room, to append its next elements
+func (pwr *PartitioningJsonlWriter) reopen(p *partition) error {
+	// a second header would be written into the middle of a csv file, or footer into a parquet file, the digest of a
+	// file would only cover what was appended, and an atomic file has already been given its name
+	if pwr.opts.format().spilled() || pwr.opts.Checksums || pwr.opts.AtomicFiles {
+		return fmt.Errorf("partition %s can't be reopened for appending with csv or parquet output, checksums or "+
+			"atomic files, more than %d partitions need to be open at once", p.value, pwr.maxOpen)
+	}
+
+	wr, err := p.factory.appendLast()
+	if err != nil {
+		return err
+	}
+
+	p.wr.resume(wr, p.wr.Records(), p.writtenBytes)
+	p.closed = false
+
+	return nil
+}
+
+// abandon closes the file being written for each partition after the split has failed, flushing the items written so
+// far
+func (pwr *PartitioningJsonlWriter) abandon() {
+	for _, p := range pwr.order {
+		_ = p.wr.Close()
+	}
+}
+
+// Close closes the files of all the partitions making sure all the data has been flushed
+func (pwr *PartitioningJsonlWriter) Close() error {
+	for _, p := range pwr.order {
+		err := p.wr.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// report prints the number of elements written to each partition
+func (pwr *PartitioningJsonlWriter) report() {
+	for _, p := range pwr.order {
+		var records int64
+		for _, n := range p.wr.Records() {
+			records += n
+		}
+
+		logger().Info(fmt.Sprintf("partition %s: %d records written to %d files", p.value, records, p.wr.Files()),
+			"partition", p.value, "records", records, "files", p.wr.Files())
+	}
+}
+
+// addToManifest adds the files written for each partition to the manifest, keyed by the partition's value, along with
+// the names of the schemas describing their items if there are any
+func (pwr *PartitioningJsonlWriter) addToManifest(m *Manifest, schemas schemaFiles) {
+	for _, p := range pwr.order {
+		mk := m.addKey(p.value, p.factory, p.wr)
+		if mk != nil {
+			schemas.setIn(mk)
+		}
+	}
+
+	pwr.names.addToManifest(m)
+}
@@ -0,0 +1,208 @@
+package jsplit
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitioningJsonlWriter(t *testing.T) {
+	items := []string{`{"region":"eu","n":1}`, `{"region":"us","n":2}`, `{"region":"ap","n":3}`, `{"region":"eu","n":4}`,
+		`{"n":5}`, `[6]`, `{"region":null,"n":7}`, `{"region":"","n":8}`, `{"region":"us","n":9}`,
+		`{"region":"sa/east","n":10}`, `{"region":1,"n":11}`, `{"region":"eu","n":12}`}
+
+	for _, maxOpen := range []int{0, 1, 2} {
+		tempDir := t.TempDir()
+
+		// partitions closed to make room are reopened for appending, so each is written to a single file
+		opts := Options{PartitionBy: "region", MaxOpenPartitions: maxOpen}
+		wr := NewPartitioningJsonlWriter(NewDirSink(context.Background(), tempDir), 1024, opts)
+
+		for _, item := range items {
+			require.NoError(t, wr.Add([]byte(item)))
+		}
+
+		require.NoError(t, wr.Close())
+
+		requireContents(t, filepath.Join(tempDir, "eu_00.jsonl"),
+			`{"region":"eu","n":1}`+"\n"+`{"region":"eu","n":4}`+"\n"+`{"region":"eu","n":12}`)
+		requireContents(t, filepath.Join(tempDir, "us_00.jsonl"), `{"region":"us","n":2}`+"\n"+`{"region":"us","n":9}`)
+		requireContents(t, filepath.Join(tempDir, "ap_00.jsonl"), `{"region":"ap","n":3}`)
+		requireContents(t, filepath.Join(tempDir, "_missing_00.jsonl"),
+			`{"n":5}`+"\n"+`[6]`+"\n"+`{"region":null,"n":7}`+"\n"+`{"region":"","n":8}`)
+		requireContents(t, filepath.Join(tempDir, "sa%2Feast_00.jsonl"), `{"region":"sa/east","n":10}`)
+		requireContents(t, filepath.Join(tempDir, "1_00.jsonl"), `{"region":1,"n":11}`)
+
+		var m Manifest
+		wr.addToManifest(&m, schemaFiles{})
+		require.Len(t, m.Keys, 6)
+		require.Equal(t, "eu", m.Keys[0].Key)
+		require.Equal(t, int64(3), m.Keys[0].Records)
+		require.Len(t, m.Keys[0].Files, 1)
+		require.Equal(t, fileSize(t, filepath.Join(tempDir, "eu_00.jsonl")), m.Keys[0].Bytes)
+		require.Equal(t, "sa/east", m.Keys[4].Key)
+	}
+}
+
+func TestPartitioningJsonlWriterReopening(t *testing.T) {
+	items := []string{`{"k":"a","v":1}`, `{"k":"b","v":2}`, `{"k":"a","v":3}`, `{"k":"b","v":4}`, `{"k":"a","v":5}`}
+
+	// a reopened partition carries on rolling over into new files
+	tempDir := t.TempDir()
+	opts := Options{PartitionBy: "k", MaxOpenPartitions: 1, MaxFileBytes: 30, Format: FormatNDJSON}
+	wr := NewPartitioningJsonlWriter(NewDirSink(context.Background(), tempDir), 1024, opts)
+
+	for _, item := range items {
+		require.NoError(t, wr.Add([]byte(item)))
+	}
+
+	require.NoError(t, wr.Close())
+	requireContents(t, filepath.Join(tempDir, "a_00.ndjson"), `{"k":"a","v":1}`+"\n"+`{"k":"a","v":3}`+"\n")
+	requireContents(t, filepath.Join(tempDir, "a_01.ndjson"), `{"k":"a","v":5}`+"\n")
+	requireContents(t, filepath.Join(tempDir, "b_00.ndjson"), `{"k":"b","v":2}`+"\n"+`{"k":"b","v":4}`+"\n")
+
+	// compressed files are appended to with another gzip member
+	tempDir = t.TempDir()
+	opts = Options{PartitionBy: "k", MaxOpenPartitions: 1, CompressOutput: true}
+	wr = NewPartitioningJsonlWriter(NewDirSink(context.Background(), tempDir), 1024, opts)
+
+	for _, item := range items {
+		require.NoError(t, wr.Add([]byte(item)))
+	}
+
+	require.NoError(t, wr.Close())
+	requireGzipContents(t, filepath.Join(tempDir, "a_00.jsonl.gz"),
+		`{"k":"a","v":1}`+"\n"+`{"k":"a","v":3}`+"\n"+`{"k":"a","v":5}`)
+
+	// files which can't be appended to fail once a partition has to be reopened
+	tests := map[string]struct {
+		sink OutputSink
+		opts Options
+	}{
+		"memory":    {newMemSink(), Options{}},
+		"csv":       {NewDirSink(context.Background(), t.TempDir()), Options{Format: FormatCSV}},
+		"checksums": {NewDirSink(context.Background(), t.TempDir()), Options{Checksums: true}},
+		"atomic":    {NewDirSink(context.Background(), t.TempDir()), Options{AtomicFiles: true}},
+	}
+
+	for name, test := range tests {
+		test.opts.PartitionBy = "k"
+		test.opts.MaxOpenPartitions = 1
+
+		wr = NewPartitioningJsonlWriter(test.sink, 1024, test.opts)
+		require.NoError(t, wr.Add([]byte(items[0])), name)
+		require.NoError(t, wr.Add([]byte(items[1])), name)
+		require.Error(t, wr.Add([]byte(items[2])), name)
+		wr.abandon()
+	}
+}
+
+func TestSplitStreamPartitionBy(t *testing.T) {
+	const doc = `{"data": {"results": [{"region": "eu", "id": 1}, {"region": "us", "id": 2}, {"region": "eu", "id": 3}]}}`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	opts := Options{Root: "/data/results", PartitionBy: "region", InferSchema: true, Limit: 2}
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, opts))
+
+	requireContents(t, filepath.Join(tempDir, "eu_00.jsonl"), `{"region":"eu","id":1}`)
+	requireContents(t, filepath.Join(tempDir, "us_00.jsonl"), `{"region":"us","id":2}`)
+
+	manifest := readManifest(t, tempDir)
+	require.Len(t, manifest.Keys, 2)
+	require.Equal(t, "eu", manifest.Keys[0].Key)
+	require.Equal(t, int64(1), manifest.Keys[0].Records)
+	require.Equal(t, "root.schema.json", manifest.Keys[0].Schema)
+	require.Equal(t, "us", manifest.Keys[1].Key)
+
+	// a dry run can reopen partitions
+	bs = NewTestByteStream([]byte(doc), 8)
+	opts = Options{Root: "/data/results", PartitionBy: "region", MaxOpenPartitions: 1, DryRun: true}
+	require.NoError(t, splitStream(context.Background(), bs, newMemSink(), opts))
+
+	// the lists of an object aren't partitioned, so the split fails rather than ignoring the field
+	outputDir := filepath.Join(t.TempDir(), "object")
+	bs = NewTestByteStream([]byte(doc), 8)
+	err := SplitStream(context.Background(), bs, outputDir, Options{PartitionBy: "region"})
+	require.EqualError(t, err, "partition by region can't be used when the document is an object, only with an array "+
+		"or a stream of json values")
+	require.NoFileExists(t, filepath.Join(outputDir, "data_00.jsonl"))
+
+	err = SplitStream(context.Background(), bs, tempDir, Options{PartitionBy: "region", Shards: 2})
+	require.EqualError(t, err, "partitions can't be used with shards")
+
+	err = SplitStream(context.Background(), bs, tempDir, Options{PartitionBy: "region", MaxOpenPartitions: -1})
+	require.EqualError(t, err, "max open partitions must not be negative, got -1")
+}
+
+func TestSplitStreamFilenameField(t *testing.T) {
+	const doc = `[{"_table": "orders", "id": 1, "total": 2.50}, {"id": 2, "_table": "users"}, {"_table": "orders", ` +
+		`"id": 3}, {"id": 4}, {"_table": null, "id": 5}, {"_table": "", "id": 6}, {"_table": "a/b", "id": 7}, 8]`
+
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{FilenameField: "_table"}))
+
+	// the field names the file each record is written to and is removed from it, values which aren't file names are
+	// escaped, and records without a value for it are written as they are to the missing partition
+	requireContents(t, filepath.Join(tempDir, "orders_00.jsonl"), `{"id":1,"total":2.50}`+"\n"+`{"id":3}`)
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":2}`)
+	requireContents(t, filepath.Join(tempDir, "a%2Fb_00.jsonl"), `{"id":7}`)
+	requireContents(t, filepath.Join(tempDir, MissingPartition+"_00.jsonl"),
+		`{"id":4}`+"\n"+`{"_table":null,"id":5}`+"\n"+`{"_table":"","id":6}`+"\n"+`8`)
+
+	manifest := readManifest(t, tempDir)
+	require.Len(t, manifest.Keys, 4)
+	require.Equal(t, "orders", manifest.Keys[0].Key)
+	require.Equal(t, int64(2), manifest.Keys[0].Records)
+
+	// the lists of an object aren't routed by the field, so the split fails rather than ignoring it
+	bs = NewTestByteStream([]byte(`{"records": `+doc+`}`), 8)
+	err := SplitStream(context.Background(), bs, filepath.Join(t.TempDir(), "object"), Options{FilenameField: "_table"})
+	require.EqualError(t, err, "filename field _table can't be used when the document is an object, only with an "+
+		"array or a stream of json values")
+
+	err = SplitStream(context.Background(), bs, tempDir, Options{FilenameField: "_table", PartitionBy: "region"})
+	require.EqualError(t, err, "a filename field can't be used with shards or partitions")
+}
+
+func TestPartitioningJsonlWriterSync(t *testing.T) {
+	files := make(map[string]*syncingWriteCloser)
+	sink := SinkFunc(func(name string) (io.WriteCloser, error) {
+		swc := &syncingWriteCloser{BufWriteCloser: NewBufWriteCloser()}
+		files[name] = swc
+		return swc, nil
+	})
+
+	items := []string{`{"k":"a"}`, `{"k":"b"}`, `{"k":"c"}`}
+
+	for _, maxOpen := range []int{3, 4} {
+		clear(files)
+
+		opts := Options{PartitionBy: "k", MaxOpenPartitions: maxOpen, FlushInterval: time.Hour}
+		wr := NewPartitioningJsonlWriter(sink, 1024, opts)
+
+		for _, item := range items {
+			require.NoError(t, wr.Add([]byte(item)))
+		}
+
+		// partitions which haven't been written to for the interval are synced, other than the next to be closed to
+		// make room once as many files are open as can be
+		now := time.Now().Add(2 * time.Hour)
+		require.NoError(t, wr.syncIdle(now))
+		require.Equal(t, 1, files["c_00.jsonl"].syncs)
+		require.Equal(t, 1, files["b_00.jsonl"].syncs)
+		require.Equal(t, map[int]int{3: 0, 4: 1}[maxOpen], files["a_00.jsonl"].syncs)
+
+		// and the open partitions are only checked once every interval
+		require.NoError(t, wr.syncIdle(now.Add(time.Minute)))
+		require.Equal(t, 1, files["c_00.jsonl"].syncs)
+
+		require.NoError(t, wr.Close())
+		require.Equal(t, 2, files["c_00.jsonl"].syncs)
+	}
+}
@@ -0,0 +1,435 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// PeekOptions configures how Peek reads a document
+type PeekOptions struct {
+	// Root is a JSON pointer to the object within the document which is described instead of the document itself, as
+	// for Options.Root
+	Root string
+
+	// MaxBytes is the number of bytes of the document read before Peek stops, describing the keys it has seen so far.
+	// The whole document is read when it isn't set.
+	MaxBytes int64
+
+	// DistinctFields are fields of the objects in the lists of the document whose distinct values are counted, reported
+	// in PeekSummary.Distinct. The values are compared as Options.DedupField compares them, and items which aren't
+	// objects, or where a field is missing or null, aren't counted. Counting parses the items of the lists rather than
+	// scanning over them, so it is slower than peeking alone, and every distinct value is held in memory unless
+	// ApproxDistinct is set.
+	DistinctFields []string
+
+	// ApproxDistinct estimates the number of distinct values of a field with a HyperLogLog sketch, taking 16KB for each
+	// field of each key, once more than DefaultExactDistinct values have been seen, rather than holding every value.
+	// Fields with fewer values are still counted exactly. Estimates have a standard error of about 0.81%.
+	ApproxDistinct bool
+}
+
+// KeySummary describes the value of a key in the root of a document
+type KeySummary struct {
+	// Key is the key. It is empty for a document which is a json array.
+	Key string
+
+	// Type is the type of the value, object, array, string, number, boolean or null
+	Type string
+
+	// Items is the number of elements of an array
+	Items int64
+
+	// Complete is false when Peek stopped part way through the value, in which case Items is the number of elements
+	// seen before it stopped
+	Complete bool
+}
+
+// PeekSummary describes the structure of a document, as read by Peek
+type PeekSummary struct {
+	// Keys describes the value of each key in the root of the document, in order
+	Keys []KeySummary
+
+	// Truncated is set when Peek stopped after PeekOptions.MaxBytes, in which case keys which came later in the
+	// document are left out
+	Truncated bool
+
+	// Distinct is the number of distinct values of each of PeekOptions.DistinctFields in the lists of each key, in the
+	// order the keys appear in the document. The lists of a key which appears more than once are counted together.
+	Distinct []DistinctCount
+}
+
+// Peek describes the keys in the root of the json document read from rd, and the values they hold, without writing any
+// files. Values are scanned over rather than parsed, counting the elements of arrays, so a document can be summarised
+// much faster than it can be split. It stops early once opts.MaxBytes have been read. The distinct values of the
+// fields of list items are counted when opts.DistinctFields are given.
+func Peek(ctx context.Context, rd ByteStream, opts PeekOptions) (*PeekSummary, error) {
+	tokens, err := parsePointer(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	itr := NewBufferedStreamIter(ctx, rd)
+	pk := &peeker{itr: itr, maxBytes: opts.MaxBytes, key: DefaultRootListKey}
+
+	if len(opts.DistinctFields) > 0 {
+		pk.distinct = newDistinctCounts(opts.DistinctFields, opts.ApproxDistinct)
+	}
+
+	summary, err := pk.peek(opts, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if pk.distinct != nil {
+		summary.Distinct = pk.distinct.results()
+	}
+
+	return summary, nil
+}
+
+// peek describes the document, see Peek
+func (pk *peeker) peek(opts PeekOptions, tokens []string) (*PeekSummary, error) {
+	itr := pk.itr
+
+	SkipWhitespace(itr)
+
+	ch := itr.Next()
+	if ch == 0 {
+		return nil, emptyInputError(itr, Options{})
+	}
+
+	if opts.Root != "" {
+		if ch != OpenCB {
+			return nil, fmt.Errorf("root pointer %s doesn't resolve to an object, the document isn't an object", opts.Root)
+		}
+
+		itr.Skip()
+
+		err := descend(itr, opts.Root, tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		ch = itr.Next()
+	}
+
+	summary := &PeekSummary{}
+
+	switch ch {
+	case OpenSB:
+		ks, err := pk.value(ch)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.Keys = append(summary.Keys, ks)
+		summary.Truncated = !ks.Complete
+
+		return summary, nil
+	case OpenCB:
+		itr.Skip()
+	default:
+		return nil, itr.errorf("invalid format. only json objects and arrays are supported")
+	}
+
+	// an empty object has no keys
+	SkipWhitespace(itr)
+
+	if itr.Next() == CloseCB {
+		return summary, nil
+	}
+
+	itr.Advance(-1)
+
+	for {
+		if pk.exhausted() {
+			summary.Truncated = true
+			return summary, nil
+		}
+
+		rawKey, err := ParseKey(itr)
+		if err != nil {
+			return nil, err
+		}
+
+		var key string
+
+		err = json.Unmarshal(rawKey, &key)
+		if err != nil {
+			return nil, err
+		}
+
+		SkipWhitespace(itr)
+
+		pk.key = key
+
+		ks, err := pk.value(itr.Next())
+		if err != nil {
+			return nil, err
+		}
+
+		ks.Key = key
+		summary.Keys = append(summary.Keys, ks)
+
+		if !ks.Complete {
+			summary.Truncated = true
+			return summary, nil
+		}
+
+		SkipWhitespace(itr)
+
+		ch = itr.Next()
+		itr.Skip()
+
+		switch ch {
+		case COMMA:
+		case CloseCB:
+			return summary, nil
+		default:
+			return nil, itr.errorf("unexpected token %q found. Expecting ','", rune(ch))
+		}
+	}
+}
+
+// peeker scans over the values of a document, stopping once it has read its maximum number of bytes
+type peeker struct {
+	itr      *BufferedByteStreamIter
+	maxBytes int64
+	distinct *distinctCounts // counts the distinct values of fields of the items of lists, or nil when none are
+	key      string          // the key of the value being scanned, whose list items are counted under it
+}
+
+// errPeekStopped stops parsing a list once the peeker has read as much of the document as it is allowed to
+var errPeekStopped = errors.New("peek stopped")
+
+// exhausted reports whether the peeker has read as much of the document as it is allowed to
+func (pk *peeker) exhausted() bool {
+	return pk.maxBytes > 0 && pk.itr.Offset() >= pk.maxBytes
+}
+
+// value scans over the value whose first character, ch, has just been read, returning its summary
+func (pk *peeker) value(ch byte) (KeySummary, error) {
+	switch ch {
+	case 0:
+		return KeySummary{}, pk.itr.errorf("reached EOF while parsing value")
+	case OpenCB:
+		return pk.composite("object")
+	case OpenSB:
+		if pk.distinct != nil {
+			return pk.countDistinct()
+		}
+
+		return pk.composite("array")
+	case QM:
+		_, err := ParseUntil(pk.itr, QM)
+		return KeySummary{Type: "string", Complete: true}, err
+	}
+
+	var typ string
+
+	switch ch {
+	case 't', 'f':
+		typ = "boolean"
+	case 'n':
+		typ = "null"
+	default:
+		typ = "number"
+	}
+
+	// scalars run until the delimiter which follows them
+	for {
+		ch = pk.itr.Next()
+		switch ch {
+		case 0:
+			return KeySummary{}, pk.itr.errorf("reached EOF while parsing value")
+		case COMMA, CloseCB, CloseSB:
+			pk.itr.Advance(-1)
+			pk.itr.Skip()
+
+			return KeySummary{Type: typ, Complete: true}, nil
+		}
+	}
+}
+
+// countDistinct parses the items of the array whose opening bracket has just been read, counting them along with the
+// distinct values of their fields
+func (pk *peeker) countDistinct() (KeySummary, error) {
+	ks := KeySummary{Type: "array"}
+
+	pk.itr.Skip()
+
+	err := parseListItems(pk.itr, func(item []byte) error {
+		ks.Items++
+		pk.distinct.add(pk.key, item)
+
+		if pk.exhausted() {
+			return errPeekStopped
+		}
+
+		return nil
+	}, nil)
+
+	if errors.Is(err, errPeekStopped) {
+		pk.distinct.partial[pk.key] = true
+		return ks, nil
+	}
+
+	if err != nil {
+		return KeySummary{}, err
+	}
+
+	ks.Complete = true
+
+	return ks, nil
+}
+
+// composite scans over the object or array whose opening character has just been read, counting the elements of an
+// array. Strings are skipped over so that brackets and commas within them aren't counted.
+func (pk *peeker) composite(typ string) (KeySummary, error) {
+	ks := KeySummary{Type: typ}
+
+	var (
+		depth    = 1
+		inString bool
+		escaped  bool
+		empty    = true
+	)
+
+	for {
+		// the bytes scanned are dropped as they go, so describing a long value doesn't buffer all of it
+		if pk.itr.pos >= len(pk.itr.buffer) {
+			pk.itr.Skip()
+
+			if pk.exhausted() {
+				if !empty {
+					ks.Items++
+				}
+
+				return ks, nil
+			}
+		}
+
+		ch := pk.itr.Next()
+
+		switch {
+		case ch == 0:
+			return KeySummary{}, pk.itr.errorf("reached EOF while parsing %s", typ)
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case ch == Escape:
+				escaped = true
+			case ch == QM:
+				inString = false
+			}
+		case isWhitespace[ch]:
+			continue
+		case ch == QM:
+			inString = true
+		case ch == OpenCB || ch == OpenSB:
+			depth++
+		case ch == CloseCB || ch == CloseSB:
+			depth--
+			if depth == 0 {
+				pk.itr.Skip()
+
+				if typ == "array" && !empty {
+					ks.Items++
+				}
+
+				ks.Complete = true
+
+				return ks, nil
+			}
+		case ch == COMMA && depth == 1 && typ == "array":
+			ks.Items++
+		}
+
+		empty = false
+	}
+}
+
+// Print writes a table of the keys and the types of their values to w, with the number of elements of arrays
+func (ps *PeekSummary) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "key\ttype\titems")
+
+	for _, ks := range ps.Keys {
+		items := ""
+
+		switch {
+		case ks.Type != "array":
+		case ks.Complete:
+			items = fmt.Sprint(ks.Items)
+		default:
+			items = fmt.Sprintf("%d+ (streaming)", ks.Items)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", ks.Key, ks.Type, items)
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	if ps.Truncated {
+		_, err = fmt.Fprintln(w, "Stopped before the end of the document, later keys aren't shown")
+	}
+
+	return err
+}
+
+// PrintCounts writes a table of the number of elements of each array to w, which are the records a split would write
+// for each key, followed by their total. The elements of a document which is a json array are counted as the list
+// named DefaultRootListKey, and the arrays of a key which appears more than once are counted together, as a split
+// writes them to the same files. Keys holding other values aren't listed.
+func (ps *PeekSummary) PrintCounts(w io.Writer) error {
+	var (
+		keys     []string
+		counts   = make(map[string]int64)
+		complete = true
+		total    int64
+	)
+
+	for _, ks := range ps.Keys {
+		if ks.Type != "array" {
+			continue
+		}
+
+		key := ks.Key
+		if key == "" {
+			key = DefaultRootListKey
+		}
+
+		if _, ok := counts[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		counts[key] += ks.Items
+		total += ks.Items
+		complete = complete && ks.Complete
+	}
+
+	// a count which stopped part way through an array is only a lower bound
+	suffix := ""
+	if !complete || ps.Truncated {
+		suffix = "+"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "key\trecords")
+
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%d\n", key, counts[key])
+	}
+
+	fmt.Fprintf(tw, "total\t%d%s\n", total, suffix)
+
+	return tw.Flush()
+}
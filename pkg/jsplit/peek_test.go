@@ -0,0 +1,113 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeek(t *testing.T) {
+	const doc = `{"str": "a ] b", "num": -1.5e3, "yes": true, "no": false, "nil": null, ` +
+		`"obj": {"a": [1, 2], "b": "}"}, "list": [{"idx": 0, "s": "],["}, [1, 2], 3], "empty": [], "a\"b": [[]]}`
+
+	for _, chunk := range []int{1, 7, 1024} {
+		summary, err := Peek(context.Background(), NewTestByteStream([]byte(doc), chunk), PeekOptions{})
+		require.NoError(t, err)
+
+		require.Equal(t, &PeekSummary{Keys: []KeySummary{
+			{Key: "str", Type: "string", Complete: true},
+			{Key: "num", Type: "number", Complete: true},
+			{Key: "yes", Type: "boolean", Complete: true},
+			{Key: "no", Type: "boolean", Complete: true},
+			{Key: "nil", Type: "null", Complete: true},
+			{Key: "obj", Type: "object", Complete: true},
+			{Key: "list", Type: "array", Items: 3, Complete: true},
+			{Key: "empty", Type: "array", Complete: true},
+			{Key: `a"b`, Type: "array", Items: 1, Complete: true},
+		}}, summary)
+	}
+}
+
+func TestPeekRootList(t *testing.T) {
+	summary, err := Peek(context.Background(), NewTestByteStream([]byte(` [1, {"a": 2}, "3"] `), 4), PeekOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []KeySummary{{Type: "array", Items: 3, Complete: true}}, summary.Keys)
+	require.False(t, summary.Truncated)
+
+	summary, err = Peek(context.Background(), NewTestByteStream([]byte(`{}`), 4), PeekOptions{})
+	require.NoError(t, err)
+	require.Empty(t, summary.Keys)
+}
+
+func TestPeekRoot(t *testing.T) {
+	const doc = `{"meta": {"n": 2}, "data": {"results": [1, 2], "next": null}}`
+
+	summary, err := Peek(context.Background(), NewTestByteStream([]byte(doc), 4), PeekOptions{Root: "/data"})
+	require.NoError(t, err)
+	require.Equal(t, []KeySummary{
+		{Key: "results", Type: "array", Items: 2, Complete: true},
+		{Key: "next", Type: "null", Complete: true},
+	}, summary.Keys)
+}
+
+func TestPeekMaxBytes(t *testing.T) {
+	doc := `{"key": "value", "list": [` + strings.Repeat(`{"idx": 1}, `, 1000) + `{"idx": 2}], "last": 1}`
+
+	summary, err := Peek(context.Background(), NewTestByteStream([]byte(doc), 64), PeekOptions{MaxBytes: 1024})
+	require.NoError(t, err)
+	require.True(t, summary.Truncated)
+	require.Len(t, summary.Keys, 2)
+	require.Equal(t, KeySummary{Key: "key", Type: "string", Complete: true}, summary.Keys[0])
+
+	list := summary.Keys[1]
+	require.Equal(t, "list", list.Key)
+	require.False(t, list.Complete)
+	require.Greater(t, list.Items, int64(50))
+	require.Less(t, list.Items, int64(1000))
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, summary.Print(buf))
+	require.Contains(t, buf.String(), "+ (streaming)")
+	require.Contains(t, buf.String(), "Stopped before the end of the document")
+}
+
+func TestPeekInvalid(t *testing.T) {
+	for _, doc := range []string{`{"list": [1, 2`, `"str"`, `{"a" 1}`} {
+		_, err := Peek(context.Background(), NewTestByteStream([]byte(doc), 4), PeekOptions{})
+		require.Error(t, err, doc)
+	}
+}
+
+func TestPeekCounts(t *testing.T) {
+	const doc = `{"users": [{"id": 1, "tags": ["a", "b"]}, {"id": 2, "s": "],["}, {"id": 3}], "meta": {"n": [1]}, ` +
+		`"groups": [[1, 2], {"a": []}], "empty": [], "users": [{"id": 4}], "note": "x"}`
+
+	summary, err := Peek(context.Background(), NewTestByteStream([]byte(doc), 7), PeekOptions{})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, summary.PrintCounts(&buf))
+	require.Equal(t, "key     records\nusers   4\ngroups  2\nempty   0\ntotal   6\n", buf.String())
+
+	// the counts are the records of a split, which writes nothing for an empty array
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 7), tempDir, Options{}))
+
+	records := make(map[string]int64)
+	for _, mk := range readManifest(t, tempDir).Keys {
+		records[mk.Key] = mk.Records
+	}
+
+	require.Equal(t, map[string]int64{"users": 4, "groups": 2}, records)
+
+	// as are those of a document which is a json array
+	summary, err = Peek(context.Background(), NewTestByteStream([]byte(`[1, {"a": [2, 3]}, "4"]`), 4), PeekOptions{})
+	require.NoError(t, err)
+
+	buf.Reset()
+	require.NoError(t, summary.PrintCounts(&buf))
+	require.Equal(t, "key    records\nroot   3\ntotal  3\n", buf.String())
+}
@@ -0,0 +1,80 @@
+package jsplit
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultProgressInterval is how often the jsplit command prints the progress of a split to a terminal unless told
+// otherwise
+const DefaultProgressInterval = 5 * time.Second
+
+// NewProgressPrinter returns a function for Options.Stats printing a line to w with the progress of the split each time
+// it is called: the bytes read, of how many when the size of the input is known, the list items parsed, the rate
+// bytes were read at since the previous line, and, when the size is known, an estimate of the time left at that rate.
+// When the size isn't known, as for standard input or compressed files, the time since the split started is printed
+// instead of the estimate. The memory held by the split follows when Options.MaxMemory is set. The function keeps the
+// previous stats to work out the rate, so it must not be shared by splits.
+func NewProgressPrinter(w io.Writer) func(Stats) {
+	var prev Stats
+
+	return func(stats Stats) {
+		fmt.Fprintln(w, progressLine(stats, prev))
+		prev = stats
+	}
+}
+
+// progressLine describes the progress of the split in stats, reading at the rate since prev was taken
+func progressLine(stats, prev Stats) string {
+	line := readProgressLine(stats, prev)
+
+	if stats.PeakMemoryBytes > 0 {
+		line += fmt.Sprintf(", %s in memory", formatBytes(float64(stats.MemoryBytes)))
+	}
+
+	return line
+}
+
+// readProgressLine describes how much of the input has been read and split, and how fast
+func readProgressLine(stats, prev Stats) string {
+	var rate float64
+
+	if interval := stats.Elapsed - prev.Elapsed; interval > 0 {
+		rate = float64(stats.BytesRead-prev.BytesRead) / interval.Seconds()
+	}
+
+	if stats.TotalBytes <= 0 {
+		return fmt.Sprintf("Read %s in %s, %d records, %s/s", formatBytes(float64(stats.BytesRead)),
+			stats.Elapsed.Round(time.Second), stats.Records, formatBytes(rate))
+	}
+
+	eta := "unknown"
+
+	remaining := stats.TotalBytes - stats.BytesRead
+	if remaining <= 0 {
+		eta = "0s"
+	} else if rate > 0 {
+		eta = time.Duration(float64(remaining) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("Read %s of %s (%.1f%%), %d records, %s/s, ETA %s", formatBytes(float64(stats.BytesRead)),
+		formatBytes(float64(stats.TotalBytes)), 100*float64(stats.BytesRead)/float64(stats.TotalBytes), stats.Records,
+		formatBytes(rate), eta)
+}
+
+// formatBytes returns a number of bytes in the largest decimal unit it has at least one of, e.g. 1.5 MB
+func formatBytes(n float64) string {
+	const units = "kMGTPE"
+
+	if n < 1000 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+
+	i := 0
+	for n /= 1000; n >= 1000 && i < len(units)-1; i++ {
+		n /= 1000
+	}
+
+	return fmt.Sprintf("%.1f %cB", n, units[i])
+}
@@ -0,0 +1,66 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatBytes(t *testing.T) {
+	require.Equal(t, "0 B", formatBytes(0))
+	require.Equal(t, "999 B", formatBytes(999))
+	require.Equal(t, "1.0 kB", formatBytes(1000))
+	require.Equal(t, "1.5 MB", formatBytes(1.5e6))
+	require.Equal(t, "2.3 GB", formatBytes(2.25e9+1e6))
+	require.Equal(t, "4000.0 EB", formatBytes(4e21))
+}
+
+func TestProgressLine(t *testing.T) {
+	prev := Stats{BytesRead: 1e6, TotalBytes: 10e6, Records: 10, Elapsed: 2 * time.Second}
+	stats := Stats{BytesRead: 3e6, TotalBytes: 10e6, Records: 30, Elapsed: 4 * time.Second}
+
+	// the rate is worked out since the previous line, and the estimate from the bytes left at that rate
+	require.Equal(t, "Read 3.0 MB of 10.0 MB (30.0%), 30 records, 1.0 MB/s, ETA 7s", progressLine(stats, prev))
+
+	stats.BytesRead = prev.BytesRead
+	require.Equal(t, "Read 1.0 MB of 10.0 MB (10.0%), 30 records, 0 B/s, ETA unknown", progressLine(stats, prev))
+
+	stats.BytesRead = stats.TotalBytes
+	require.Contains(t, progressLine(stats, prev), "(100.0%), 30 records, 4.5 MB/s, ETA 0s")
+
+	// without a total, the time elapsed is printed instead of an estimate
+	stats = Stats{BytesRead: 2500, Records: 5, Elapsed: 90 * time.Second}
+	require.Equal(t, "Read 2.5 kB in 1m30s, 5 records, 28 B/s", progressLine(stats, Stats{}))
+
+	// the memory held is added when it is being counted
+	stats.MemoryBytes, stats.PeakMemoryBytes = 300000, 500000
+	require.Equal(t, "Read 2.5 kB in 1m30s, 5 records, 28 B/s, 300.0 kB in memory", progressLine(stats, Stats{}))
+}
+
+func TestNewProgressPrinter(t *testing.T) {
+	doc := []byte(`{"list": [{"idx": 0}, {"idx": 1}, {"idx": 2}]}`)
+
+	input := filepath.Join(t.TempDir(), "input.json")
+	require.NoError(t, os.WriteFile(input, doc, 0o644))
+
+	rd, err := AsyncReaderFromFile(input, 16)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	var buf bytes.Buffer
+
+	opts := Options{Stats: NewProgressPrinter(&buf), StatsInterval: time.Hour}
+	require.NoError(t, Split(context.Background(), rd, newMemSink(), opts))
+
+	// the size of a local file is known, so the last line has the total
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	require.True(t, strings.HasPrefix(lines[0], "Read 46 B of 46 B (100.0%), 3 records, "), lines[0])
+	require.True(t, strings.HasSuffix(lines[0], ", ETA 0s"), lines[0])
+}
@@ -0,0 +1,179 @@
+package jsplit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Reassemble writes the json document split into dir back to w as a single json object, reading the files listed in
+// its manifest. The values of root.json, or of the files written for Options.ValueFiles, are written first, followed
+// by a field for each key of the manifest holding the items of all of its files in order, so the keys of the original
+// document may be in a different order. Items are streamed from the files one at a time, so only the largest of them,
+// and root.json or the largest value, are ever held in memory. Lists which were empty, and so have no files, are left
+// out. Shards and partitions are written as the keys they were split into, and files written as csv or msgpack can't
+// be reassembled.
+func Reassemble(ctx context.Context, dir string, w io.Writer) error {
+	ds := &dirSink{ctx: ctx, dir: dir}
+
+	data, err := readOutputFile(ctx, ds.path(ManifestFilename))
+	if err != nil {
+		return err
+	}
+
+	var m Manifest
+
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return fmt.Errorf("invalid manifest in %s: %w", dir, err)
+	}
+
+	wr := bufio.NewWriterSize(w, 256*1024)
+	_ = wr.WriteByte(OpenCB)
+
+	fields := 0
+
+	if m.Root != nil {
+		data, err = readReassembled(ctx, ds.path(m.Root.Name))
+		if err != nil {
+			return err
+		}
+
+		root, err := decodeObject(data)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", m.Root.Name, err)
+		}
+
+		for _, field := range root {
+			writeFieldName(wr, field.Key, fields)
+			_, _ = wr.Write(field.Value)
+			fields++
+		}
+	}
+
+	for _, mv := range m.Values {
+		data, err = readReassembled(ctx, ds.path(mv.Files[0].Name))
+		if err != nil {
+			return err
+		}
+
+		if !json.Valid(data) {
+			return fmt.Errorf("invalid %s", mv.Files[0].Name)
+		}
+
+		writeFieldName(wr, mv.Key, fields)
+		_, _ = wr.Write(bytes.TrimSpace(data))
+		fields++
+	}
+
+	for _, mk := range m.Keys {
+		writeFieldName(wr, mk.Key, fields)
+		_ = wr.WriteByte(OpenSB)
+		fields++
+
+		items := 0
+
+		for _, file := range mk.Files {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			items, err = reassembleFile(ctx, wr, ds.path(file.Name), items)
+			if err != nil {
+				return fmt.Errorf("unable to reassemble %s: %w", file.Name, err)
+			}
+		}
+
+		_ = wr.WriteByte(CloseSB)
+	}
+
+	_ = wr.WriteByte(CloseCB)
+
+	return wr.Flush()
+}
+
+// writeFieldName writes the name of the field of an object, preceded by a comma unless it is the first
+func writeFieldName(wr *bufio.Writer, name string, fields int) {
+	if fields > 0 {
+		_ = wr.WriteByte(COMMA)
+	}
+
+	// marshalling a string can't fail
+	key, _ := json.Marshal(name)
+
+	_, _ = wr.Write(key)
+	_ = wr.WriteByte(COLON)
+}
+
+// reassembleFile writes each of the items of a jsonl or ndjson file, decompressing it if it is compressed, to wr as the
+// elements of an array which already holds items elements, returning the number it holds afterwards
+func reassembleFile(ctx context.Context, wr *bufio.Writer, filename string, items int) (int, error) {
+	switch path.Ext(strings.TrimSuffix(filename, compressedExt(true))) {
+	case "." + string(FormatCSV), "." + string(FormatMsgpack), "." + string(FormatParquet):
+		return items, errors.New("only jsonl and ndjson files can be reassembled")
+	}
+
+	rd, closers, err := openReassembled(ctx, filename)
+	if err != nil {
+		return items, err
+	}
+
+	defer closeAll(closers)
+
+	dec := json.NewDecoder(rd)
+
+	for {
+		var item json.RawMessage
+
+		err = dec.Decode(&item)
+		if errors.Is(err, io.EOF) {
+			return items, nil
+		}
+
+		if err != nil {
+			return items, err
+		}
+
+		if items > 0 {
+			_ = wr.WriteByte(COMMA)
+		}
+
+		_, _ = wr.Write(item)
+		items++
+	}
+}
+
+// openReassembled opens a file written by a split, decompressing it if it is compressed. The closers must be closed
+// once it has been read.
+func openReassembled(ctx context.Context, filename string) (io.Reader, []io.Closer, error) {
+	r, err := openOutputFile(ctx, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rd, _, closers, err := decompress(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, nil, err
+	}
+
+	return rd, append(closers, r), nil
+}
+
+// readReassembled returns the contents of a file written by a split, decompressed if it is compressed
+func readReassembled(ctx context.Context, filename string) ([]byte, error) {
+	rd, closers, err := openReassembled(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeAll(closers)
+
+	return io.ReadAll(rd)
+}
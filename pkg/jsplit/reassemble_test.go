@@ -0,0 +1,79 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReassemble(t *testing.T) {
+	var users []string
+	for i := 0; i < 50; i++ {
+		users = append(users, fmt.Sprintf(`{"id": %d, "name": "user %d", "tags": ["a", {"b": [1, 2.50]}]}`, i, i))
+	}
+
+	doc := `{"name": "export", "users": [` + strings.Join(users, ", ") + `], "count": 50,
+		"meta": {"big": 9007199254740993, "nested": {"list": [1]}}, "groups": [[1, 2], "x", null]}`
+
+	// splitting then reassembling gives back the same document, from keys which rolled over to several files too
+	for name, opts := range map[string]Options{
+		"jsonl":      {},
+		"rolled":     {MaxFileBytes: 256},
+		"ndjson":     {Format: FormatNDJSON, MaxFileBytes: 256},
+		"compressed": {CompressOutput: true, MaxFileBytes: 256},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 64), tempDir, opts))
+
+			if opts.MaxFileBytes > 0 {
+				require.Greater(t, len(readManifest(t, tempDir).Keys[0].Files), 1)
+			}
+
+			var buf bytes.Buffer
+
+			require.NoError(t, Reassemble(context.Background(), tempDir, &buf))
+			require.JSONEq(t, doc, buf.String())
+			require.Contains(t, buf.String(), `9007199254740993`)
+		})
+	}
+
+	// a document without values other than lists has no root.json
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(`{"a": [1, 2]}`), 8), tempDir,
+		Options{}))
+
+	var buf bytes.Buffer
+
+	require.NoError(t, Reassemble(context.Background(), tempDir, &buf))
+	require.Equal(t, `{"a":[1,2]}`, buf.String())
+}
+
+func TestReassembleErrors(t *testing.T) {
+	const doc = `{"users": [{"id": 1}, {"id": 2}]}`
+
+	var buf bytes.Buffer
+
+	// output without a manifest, or with files missing or in csv, can't be reassembled
+	require.Error(t, Reassemble(context.Background(), t.TempDir(), &buf))
+
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{Format: FormatCSV}))
+	require.EqualError(t, Reassemble(context.Background(), tempDir, &buf),
+		"unable to reassemble users_00.csv: only jsonl and ndjson files can be reassembled")
+
+	tempDir = t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir, Options{}))
+	require.NoError(t, os.Remove(filepath.Join(tempDir, "users_00.jsonl")))
+	require.ErrorContains(t, Reassemble(context.Background(), tempDir, &buf), "unable to reassemble users_00.jsonl")
+
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, ManifestFilename), []byte("{"), 0o644))
+	require.ErrorContains(t, Reassemble(context.Background(), tempDir, &buf), "invalid manifest in "+tempDir)
+}
@@ -0,0 +1,49 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RecordTransformFunc changes each item of a list before it is written, see Options.RecordTransform. It is called with
+// the key of the item's list, DefaultRootListKey for the elements of a document which is a json array, and the item's
+// json, and returns the json written in its place, which must be valid, and whether the item is kept at all. raw is
+// only valid until it returns, so it must be copied to be kept, though it can be returned as it is.
+type RecordTransformFunc func(key string, raw json.RawMessage) (json.RawMessage, bool, error)
+
+// skippableError is an error with a single list item which skips the item, logging it to the rejects file, rather than
+// failing the split when Options.SkipErrors is set, see parseListItems
+type skippableError struct {
+	err error
+}
+
+func (se *skippableError) Error() string {
+	return se.err.Error()
+}
+
+func (se *skippableError) Unwrap() error {
+	return se.err
+}
+
+// transformRecords returns a ListAddFunc passing each item of the list with the given key through
+// opts.RecordTransform before passing it to add, counting the items it drops in counts, or add itself when it isn't
+// set
+func transformRecords(key string, add ListAddFunc, opts Options, counts *filterCounts) ListAddFunc {
+	if opts.RecordTransform == nil {
+		return add
+	}
+
+	return func(item []byte) error {
+		transformed, keep, err := opts.RecordTransform(key, item)
+		if err != nil {
+			return &skippableError{err: fmt.Errorf("record transform: %w", err)}
+		}
+
+		if !keep {
+			counts.transformed++
+			return nil
+		}
+
+		return add(transformed)
+	}
+}
@@ -0,0 +1,105 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// upperNames upper cases the name of each user, drops those without one and fails on those with a name which isn't
+// a string
+func upperNames(key string, raw json.RawMessage) (json.RawMessage, bool, error) {
+	if key != "users" {
+		return raw, true, nil
+	}
+
+	var user map[string]interface{}
+
+	err := json.Unmarshal(raw, &user)
+	if err != nil {
+		return nil, false, err
+	}
+
+	name, ok := user["name"]
+	if !ok {
+		return nil, false, nil
+	}
+
+	s, ok := name.(string)
+	if !ok {
+		return nil, false, errors.New("name isn't a string")
+	}
+
+	user["name"] = strings.ToUpper(s)
+
+	out, err := json.Marshal(user)
+
+	return out, true, err
+}
+
+func TestSplitStreamRecordTransform(t *testing.T) {
+	const doc = `{"users": [{"id": 1, "name": "ada"}, {"id": 2}, {"id": 3, "name": "cy"}], "teams": [{"name": "a"}]}`
+
+	var buf bytes.Buffer
+
+	SetLogger(slog.New(NewMessageHandler(&buf, slog.LevelInfo)))
+	defer SetLogger(nil)
+
+	// items are changed before they are filtered, and those dropped are counted as filtered
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir,
+		Options{RecordTransform: upperNames, Filter: `name != "CY"`}))
+
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1,"name":"ADA"}`)
+	requireContents(t, filepath.Join(tempDir, "teams_00.jsonl"), `{"name":"a"}`)
+
+	manifest := readManifest(t, tempDir)
+	require.Equal(t, int64(2), manifest.Keys[0].Filtered)
+	require.Contains(t, buf.String(), "1 items of users were dropped by the record transform")
+
+	// the elements of a document which is a json array are passed with the root list key
+	var keys []string
+
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(`[1, 2]`), 4)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir,
+		Options{RecordTransform: func(key string, raw json.RawMessage) (json.RawMessage, bool, error) {
+			keys = append(keys, key)
+			return append([]byte("1"), raw...), true, nil
+		}}))
+
+	requireContents(t, filepath.Join(tempDir, DefaultRootListKey+"_00.jsonl"), "11\n12")
+	require.Equal(t, []string{DefaultRootListKey, DefaultRootListKey}, keys)
+
+	// a transform which fails fails the split, unless errors are skipped
+	const bad = `{"users": [{"id": 1, "name": "ada"}, {"id": 2, "name": 2}, {"id": 3, "name": "cy"}]}`
+
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(bad), 8), t.TempDir(),
+		Options{RecordTransform: upperNames})
+	require.ErrorContains(t, err, "record transform: name isn't a string")
+
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(bad), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir,
+		Options{RecordTransform: upperNames, SkipErrors: true}))
+
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1,"name":"ADA"}`+"\n"+`{"id":3,"name":"CY"}`)
+
+	rejects := readRejects(t, tempDir)
+	require.Len(t, rejects, 1)
+	require.Equal(t, 1, rejects[0].Index)
+	require.Equal(t, `{"id":2,"name":2}`, rejects[0].Item)
+	require.Equal(t, "record transform: name isn't a string", rejects[0].Error)
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(),
+		Options{RecordTransform: upperNames, CheckpointInterval: 10})
+	require.EqualError(t, err, "checkpoints can't be used with a record transform")
+}
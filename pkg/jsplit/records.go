@@ -0,0 +1,141 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Record is an item of a list in the root of a document, as streamed by Records
+type Record struct {
+	// Key is the key of the item's list, unquoted but not unescaped as for the names of files. The elements of a document
+	// which is a json array are given Options.RootListKey, or DefaultRootListKey.
+	Key string
+
+	// Value is the item's json, which belongs to the Record
+	Value json.RawMessage
+}
+
+// Records streams the items of the lists in the root of the json document read from rd, tagged with the keys of their
+// lists, instead of writing them to files, so that they can be routed however the caller likes. The options which
+// choose and shape the items apply as they do to a split: Root, IncludeKeys and ExcludeKeys and their patterns,
+// RootListKey, Limit, DedupField, Filter, IndexField, JSON5, Flatten and UniformFields, with RequireKeys and ExactKeys
+// checking the keys of the object. Values which aren't lists are parsed past, and the options which only concern the
+// files written are ignored.
+//
+// The records channel is unbuffered, so the document is parsed as fast as the records are received, and parsing waits
+// while none are. Callers must keep receiving until the channel is closed, or cancel ctx to stop early. Once it is
+// closed the error channel receives the error which stopped parsing, ctx.Err() when ctx was cancelled, or nil once the
+// whole document has been streamed. rd may be an AsyncReader, started with ctx.
+func Records(ctx context.Context, rd ByteStream, opts Options) (<-chan Record, <-chan error) {
+	records := make(chan Record)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+
+		err := streamRecords(ctx, rd, opts, func(r Record) error {
+			select {
+			case records <- r:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+
+		close(records)
+		errs <- err
+	}()
+
+	return records, errs
+}
+
+// streamRecords parses the json document read from rd, passing the items of its lists to send
+func streamRecords(ctx context.Context, rd ByteStream, opts Options, send func(Record) error) error {
+	err := opts.validate()
+	if err != nil {
+		return err
+	}
+
+	itr := NewBufferedStreamIter(ctx, json5Input(rd, opts))
+	itr.maxDepth = opts.maxDepth()
+	itr.maxRecordBytes = int(opts.MaxRecordBytes)
+
+	ch, err := seekRoot(itr, opts)
+	if err != nil {
+		return err
+	}
+
+	check := newKeyCheck(opts)
+
+	switch ch {
+	case OpenSB:
+		err = check.list()
+		if err != nil {
+			return err
+		}
+
+		itr.Advance(-1)
+		itr.Skip()
+
+		return parseList(itr, recordItems(opts.rootListKey(), send, opts), nil)
+	case OpenCB:
+		itr.Skip()
+	default:
+		return itr.errorf("invalid format. only json objects and arrays are supported")
+	}
+
+	// an empty object has no lists
+	SkipWhitespace(itr)
+
+	if itr.Next() == CloseCB {
+		return check.end()
+	}
+
+	itr.Advance(-1)
+
+	for {
+		key, err := ParseKey(itr)
+		if err != nil {
+			return err
+		}
+
+		name := string(key[1 : len(key)-1])
+
+		err = check.add(name)
+		if err != nil {
+			return err
+		}
+
+		add := discardItem
+		if opts.splitsKey(name) {
+			add = recordItems(name, send, opts)
+		}
+
+		_, _, err = parseVal(itr, add, nil, None)
+		if err != nil {
+			return err
+		}
+
+		if endOfObject(itr) {
+			return check.end()
+		}
+	}
+}
+
+// recordItems returns the ListAddFunc passing the items of a list to send as Records of the key, dropping those which
+// don't match Options.Filter and duplicates, stopping at Options.Limit and adding the index of each item as a split does
+func recordItems(key string, send func(Record) error, opts Options) ListAddFunc {
+	var (
+		duplicates int64
+		filtered   filterCounts
+	)
+
+	add := redactItems(flattenItems(uniformItems(key, func(item []byte) error {
+		return send(Record{Key: key, Value: append(json.RawMessage(nil), item...)})
+	}, opts), opts), opts)
+
+	add = filterItems(dedupItems(limitItems(add, opts), opts, &duplicates), opts, &filtered)
+	add, _ = annotateItems(key, utf8Items(transformRecords(key, add, opts, &filtered), opts), nil, opts)
+
+	return add
+}
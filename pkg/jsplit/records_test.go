@@ -0,0 +1,89 @@
+package jsplit
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// collectRecords receives every record streamed by Records, returning them along with the error which stopped it
+func collectRecords(t *testing.T, doc string, opts Options) ([]string, error) {
+	records, errs := Records(context.Background(), NewTestByteStream([]byte(doc), 8), opts)
+
+	var received []string
+	for r := range records {
+		received = append(received, r.Key+" "+string(r.Value))
+	}
+
+	select {
+	case err := <-errs:
+		return received, err
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no error was sent once the records channel was closed")
+		return nil, nil
+	}
+}
+
+func TestRecords(t *testing.T) {
+	doc := `{"users": [{"id": 1}, {"id": 2}], "count": 2, "groups": [[1, 2], {"name": "admins"}], "users": [{"id": 3}]}`
+
+	// items are tagged with the key of their list, in the order of the document
+	received, err := collectRecords(t, doc, Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{`users {"id":1}`, `users {"id":2}`, `groups [1,2]`, `groups {"name":"admins"}`,
+		`users {"id":3}`}, received)
+
+	// the options choosing and shaping the items apply
+	received, err = collectRecords(t, doc, Options{IncludeKeys: []string{"users"}, Limit: 1, IndexField: "i"})
+	require.NoError(t, err)
+	require.Equal(t, []string{`users {"id":1,"i":0}`, `users {"id":3,"i":0}`}, received)
+
+	received, err = collectRecords(t, `{"data": {"items": [{"a": {"b": 1}}]}}`, Options{Root: "/data", Flatten: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{`items {"a.b":1}`}, received)
+
+	received, err = collectRecords(t, `[{"id": 1}, {"id": 1}, {"id": 2}]`, Options{RootListKey: "events",
+		DedupField: "id"})
+	require.NoError(t, err)
+	require.Equal(t, []string{`events {"id":1}`, `events {"id":2}`}, received)
+
+	received, err = collectRecords(t, `{}`, Options{})
+	require.NoError(t, err)
+	require.Empty(t, received)
+
+	// the records parsed before an error are received first
+	received, err = collectRecords(t, `{"users": [{"id": 1}, {"id": 2} {"id": 3}]}`, Options{})
+	require.ErrorContains(t, err, `invalid json at byte 32 (line 1)`)
+	require.Equal(t, []string{`users {"id":1}`, `users {"id":2}`}, received)
+
+	_, err = collectRecords(t, doc, Options{Limit: -1})
+	require.Error(t, err)
+}
+
+func TestRecordsBackpressure(t *testing.T) {
+	items := make([]string, 100)
+	for i := range items {
+		items[i] = `{"id": 1}`
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	records, errs := Records(ctx, NewTestByteStream([]byte(`[`+strings.Join(items, ",")+`]`), 8), Options{})
+
+	// parsing waits for records to be received, and stops once ctx is cancelled
+	<-records
+	cancel()
+
+	for range records {
+	}
+
+	require.ErrorIs(t, <-errs, context.Canceled)
+
+	// the error channel is closed after its one error
+	_, ok := <-errs
+	require.False(t, ok)
+}
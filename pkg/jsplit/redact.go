@@ -0,0 +1,178 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// redactNode is a field named by Options.Redact, or the parent of one, holding the fields of its value which are
+// redacted
+type redactNode struct {
+	redact bool // the field itself is redacted, along with everything within it
+	fields map[string]*redactNode
+}
+
+// parseRedact returns the tree of the fields named by the dotted paths, e.g. user.email, or an error if a path has an
+// empty name in it
+func parseRedact(paths []string) (*redactNode, error) {
+	root := &redactNode{}
+
+	for _, path := range paths {
+		node := root
+
+		for _, name := range strings.Split(path, ".") {
+			if name == "" {
+				return nil, fmt.Errorf("invalid redacted field %q, the names of nested fields are joined by dots", path)
+			}
+
+			if node.fields == nil {
+				node.fields = make(map[string]*redactNode)
+			}
+
+			child, ok := node.fields[name]
+			if !ok {
+				child = &redactNode{}
+				node.fields[name] = child
+			}
+
+			node = child
+		}
+
+		node.redact = true
+	}
+
+	return root, nil
+}
+
+// redacter removes the fields named by Options.Redact from objects, or replaces their values with
+// Options.RedactPlaceholder
+type redacter struct {
+	root        *redactNode
+	placeholder []byte // the json encoded placeholder, nil when fields are removed
+}
+
+// redactItems returns a ListAddFunc which redacts the fields of every item named by opts.Redact before passing it to
+// add, and add itself when no fields are redacted. Items are redacted one at a time as they are split.
+func redactItems(add ListAddFunc, opts Options) ListAddFunc {
+	if len(opts.Redact) == 0 {
+		return add
+	}
+
+	// the paths were checked when the options were validated
+	root, _ := parseRedact(opts.Redact)
+	r := &redacter{root: root}
+
+	if opts.RedactPlaceholder != "" {
+		// marshalling a string can't fail
+		r.placeholder, _ = json.Marshal(opts.RedactPlaceholder)
+	}
+
+	return func(item []byte) error {
+		redacted, _, err := r.redact(item, r.root)
+		if err != nil {
+			return err
+		}
+
+		return add(redacted)
+	}
+}
+
+// redact returns the value with the fields named by the node redacted, and whether any were. The fields of objects in
+// arrays are redacted as those of the array's field would be, so that users.email redacts the email of every object in
+// the array of users. Values without any of the fields, including those which aren't objects or arrays, are returned
+// as they are.
+func (r *redacter) redact(val []byte, node *redactNode) ([]byte, bool, error) {
+	if len(val) < 2 {
+		return val, false, nil
+	}
+
+	switch val[0] {
+	case OpenCB:
+		return r.redactObject(val, node)
+	case OpenSB:
+		return r.redactArray(val, node)
+	}
+
+	return val, false, nil
+}
+
+// redactObject returns the object with the fields named by the node redacted, and whether any were
+func (r *redacter) redactObject(val []byte, node *redactNode) ([]byte, bool, error) {
+	fields, err := decodeObject(val)
+	if err != nil {
+		return nil, false, err
+	}
+
+	redacted := false
+	kept := fields[:0]
+
+	for _, field := range fields {
+		child := node.fields[field.Key]
+
+		switch {
+		case child == nil:
+		case child.redact && r.placeholder == nil:
+			redacted = true
+			continue
+		case child.redact:
+			field.Value = r.placeholder
+			redacted = true
+		default:
+			value, changed, err := r.redact(field.Value, child)
+			if err != nil {
+				return nil, false, err
+			}
+
+			field.Value = value
+			redacted = redacted || changed
+		}
+
+		kept = append(kept, field)
+	}
+
+	if !redacted {
+		return val, false, nil
+	}
+
+	return encodeObject(kept), true, nil
+}
+
+// redactArray returns the array with the fields named by the node redacted from each of its elements, and whether any
+// were
+func (r *redacter) redactArray(val []byte, node *redactNode) ([]byte, bool, error) {
+	var elems []json.RawMessage
+
+	err := json.Unmarshal(val, &elems)
+	if err != nil {
+		return nil, false, err
+	}
+
+	redacted := false
+
+	for i, elem := range elems {
+		value, changed, err := r.redact(elem, node)
+		if err != nil {
+			return nil, false, err
+		}
+
+		elems[i] = value
+		redacted = redacted || changed
+	}
+
+	if !redacted {
+		return val, false, nil
+	}
+
+	buf := []byte{OpenSB}
+
+	for i, elem := range elems {
+		if i > 0 {
+			buf = append(buf, COMMA)
+		}
+
+		buf = append(buf, elem...)
+	}
+
+	return append(buf, CloseSB), true, nil
+}
@@ -0,0 +1,76 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactItems(t *testing.T) {
+	redacted := func(opts Options, item string) string {
+		var out string
+
+		add := redactItems(func(item []byte) error {
+			out = string(item)
+			return nil
+		}, opts)
+
+		require.NoError(t, add([]byte(item)))
+
+		return out
+	}
+
+	opts := Options{Redact: []string{"ssn", "user.email", "orders.card.number", "missing", "user.address.zip"}}
+
+	// top level and nested fields are removed, including those of the objects in arrays and arrays of arrays
+	require.Equal(t, `{"id":1,"user":{"name":"a","address":{"city":"x"}}}`,
+		redacted(opts, `{"id":1,"ssn":"123","user":{"name":"a","email":"a@b","address":{"city":"x","zip":"1"}}}`))
+	require.Equal(t, `{"orders":[{"card":{"exp":"12/30"}},{"card":{}},[{"card":{"cvv":1}}],2]}`,
+		redacted(opts, `{"orders":[{"card":{"number":"4111","exp":"12/30"}},{"card":{"number":"5500"}},`+
+			`[{"card":{"number":"1","cvv":1}}],2]}`))
+
+	// fields which items don't have, or which aren't objects where the path expects them, are left alone
+	for _, item := range []string{
+		`{"id": 1, "name": "a"}`,
+		`{"user": "a", "orders": {"card": 1}}`,
+		`{"user": null, "orders": [1, "card"]}`,
+		`[1, 2]`,
+		`"ssn"`,
+		`{}`,
+	} {
+		require.Equal(t, item, redacted(opts, item))
+	}
+
+	// or the values are replaced with a placeholder
+	opts.RedactPlaceholder = "[REDACTED]"
+	require.Equal(t, `{"id":1,"ssn":"[REDACTED]","user":{"email":"[REDACTED]","name":"a"}}`,
+		redacted(opts, `{"id":1,"ssn":"123","user":{"email":{"primary":"a@b"},"name":"a"}}`))
+	require.Equal(t, `[{"orders":[{"card":{"number":"[REDACTED]"}}]}]`,
+		redacted(opts, `[{"orders":[{"card":{"number":"4111"}}]}]`))
+
+	// a field is redacted whole when a path also names a field within it
+	opts = Options{Redact: []string{"user.email", "user"}}
+	require.Equal(t, `{"id":1}`, redacted(opts, `{"id":1,"user":{"email":"a@b"}}`))
+
+	for _, paths := range [][]string{{""}, {"user..email"}, {"ssn", "user."}} {
+		require.ErrorContains(t, Options{Redact: paths}.validate(), "invalid redacted field")
+	}
+}
+
+func TestSplitStreamRedact(t *testing.T) {
+	const doc = `{"ssn": "kept", "users": [{"id": 1, "ssn": "123", "contact": {"email": "a@b", "phone": "1"}},
+		{"id": 2, "contact": {"phone": "2"}}], "orders": [{"items": [{"sku": "x", "card": "4111"}]}]}`
+
+	// items are redacted as they are split, before they are flattened, while the values which aren't lists are left
+	tempDir := t.TempDir()
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{Redact: []string{"ssn", "contact.email", "items.card"}, Flatten: true})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"),
+		`{"id":1,"contact.phone":"1"}`+"\n"+`{"id":2,"contact.phone":"2"}`)
+	requireContents(t, filepath.Join(tempDir, "orders_00.jsonl"), `{"items":[{"sku":"x"}]}`)
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"ssn\":\"kept\"\n}")
+}
@@ -0,0 +1,132 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RejectsFilename is the name of the file list items skipped because of Options.SkipErrors are logged to
+const RejectsFilename = "rejects.jsonl"
+
+// rejectedItem is a line of the rejects file
+type rejectedItem struct {
+	Key   string `json:"key"`
+	Index int    `json:"index"`
+	Error string `json:"error"`
+	Item  string `json:"item,omitempty"`
+}
+
+// rejectLog logs the list items which were skipped because they couldn't be parsed to the rejects file in the sink. The
+// file is only created once an item has been rejected.
+type rejectLog struct {
+	sink   OutputSink
+	wr     io.WriteCloser
+	total  int64
+	counts map[string]int64
+}
+
+// newRejectLog returns a *rejectLog writing to sink if opts.SkipErrors is set, and nil otherwise
+func newRejectLog(sink OutputSink, opts Options) *rejectLog {
+	if !opts.SkipErrors {
+		return nil
+	}
+
+	return &rejectLog{sink: sink, counts: make(map[string]int64)}
+}
+
+// handler returns the listErrorFunc logging the items of the list with the given key which are rejected, or nil if
+// errors aren't being skipped. Rejected items are also reported as problems at the offset itr has reached, and those
+// larger than Options.MaxRecordBytes are warned about.
+func (rl *rejectLog) handler(key string, itr *BufferedByteStreamIter) listErrorFunc {
+	if rl == nil {
+		return nil
+	}
+
+	return func(index int, item []byte, err error) error {
+		reportProblem(itr.ctx, itr.Offset(), fmt.Errorf("item %d of %s was skipped: %w", index, key, err))
+
+		// oversized items are warned about as they are found, as they point to a problem with the input
+		if errors.Is(err, ErrRecordTooLarge) {
+			logger().Warn(fmt.Sprintf("item %d of %s was skipped: %s", index, key, err), "key", key, "index", index,
+				"offset", itr.Offset())
+		}
+		return rl.reject(key, index, item, err)
+	}
+}
+
+// reject logs a list item which couldn't be parsed
+func (rl *rejectLog) reject(key string, index int, item []byte, err error) error {
+	if rl.wr == nil {
+		wr, err := rl.create()
+		if err != nil {
+			return err
+		}
+
+		rl.wr = wr
+	}
+
+	data, err := json.Marshal(rejectedItem{Key: key, Index: index, Error: err.Error(), Item: string(item)})
+	if err != nil {
+		return err
+	}
+
+	_, err = rl.wr.Write(append(data, LF))
+	if err != nil {
+		return err
+	}
+
+	rl.total++
+	rl.counts[key]++
+
+	return nil
+}
+
+// create creates the rejects file
+func (rl *rejectLog) create() (io.WriteCloser, error) {
+	w, err := rl.sink.OpenKey(RejectsFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBufferedWriteCloser(rl.filename(), w, 64*1024), nil
+}
+
+// filename returns the full path of the rejects file
+func (rl *rejectLog) filename() string {
+	return sinkPath(rl.sink, RejectsFilename)
+}
+
+// rejected returns the number of items of the list with the given key which were rejected
+func (rl *rejectLog) rejected(key string) int64 {
+	if rl == nil {
+		return 0
+	}
+
+	return rl.counts[key]
+}
+
+// file returns the name of the rejects file if any items were rejected
+func (rl *rejectLog) file() string {
+	if rl == nil || rl.total == 0 {
+		return ""
+	}
+
+	return RejectsFilename
+}
+
+// close closes the rejects file reporting how many items were rejected. It can be called more than once.
+func (rl *rejectLog) close() error {
+	if rl == nil || rl.wr == nil {
+		return nil
+	}
+
+	wr := rl.wr
+	rl.wr = nil
+
+	logger().Warn(fmt.Sprintf("%d list items couldn't be parsed and were skipped, see %s", rl.total, rl.filename()),
+		"rejected", rl.total, "file", rl.filename())
+
+	return wr.Close()
+}
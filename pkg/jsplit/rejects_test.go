@@ -0,0 +1,107 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readRejects(t *testing.T, dir string) []rejectedItem {
+	data, err := os.ReadFile(filepath.Join(dir, RejectsFilename))
+	require.NoError(t, err)
+
+	var items []rejectedItem
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var item rejectedItem
+		require.NoError(t, json.Unmarshal([]byte(line), &item))
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func TestSplitStreamSkipErrors(t *testing.T) {
+	var testStr = `{"key": "value", "list": [
+		{"idx": 0},
+		{"idx": 1,},
+		{"idx": 2} {"junk": "with, commas ] and \" quotes", "nested": [1, {"a": 2}]},
+		{"idx": 3},
+		tru e,
+		{"idx": 5}
+	], "other": [1, 2]}`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{SkipErrors: true})
+	require.NoError(t, err)
+
+	// parsing resumes with the item after the one which couldn't be parsed
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":2}\n{\"idx\":3}\n{\"idx\":5}")
+	requireContents(t, filepath.Join(tempDir, "other_00.jsonl"), "1\n2")
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"key\":\"value\"\n}")
+
+	rejects := readRejects(t, tempDir)
+	require.Len(t, rejects, 3)
+
+	require.Equal(t, "list", rejects[0].Key)
+	require.Equal(t, 1, rejects[0].Index)
+	require.Equal(t, `{"idx":1,}`, rejects[0].Item)
+	require.Contains(t, rejects[0].Error, "invalid list item")
+
+	require.Equal(t, 2, rejects[1].Index)
+	require.Empty(t, rejects[1].Item)
+	require.Contains(t, rejects[1].Error, "Expecting ','")
+
+	require.Equal(t, 4, rejects[2].Index)
+	require.Equal(t, "tru e", rejects[2].Item)
+
+	m := readManifest(t, tempDir)
+	require.Equal(t, RejectsFilename, m.Rejects)
+	require.Equal(t, int64(3), m.Keys[0].Rejected)
+	require.Equal(t, int64(4), m.Keys[0].Records)
+	require.Zero(t, m.Keys[1].Rejected)
+}
+
+func TestSplitStreamSkipErrorsRootList(t *testing.T) {
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(`[1, {"a": }, 3, 4 5, 6]`), 4)
+	err := SplitStream(context.Background(), bs, tempDir, Options{SkipErrors: true})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), "1\n3\n6")
+
+	rejects := readRejects(t, tempDir)
+	require.Len(t, rejects, 2)
+	require.Equal(t, 1, rejects[0].Index)
+	require.Equal(t, "root", rejects[0].Key)
+	require.Equal(t, 3, rejects[1].Index)
+
+	require.Equal(t, RejectsFilename, readManifest(t, tempDir).Rejects)
+}
+
+func TestSplitStreamSkipErrorsValid(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// no rejects file is written when every item is valid
+	bs := NewTestByteStream([]byte(`{"list": [1, 2]}`), 4)
+	err := SplitStream(context.Background(), bs, tempDir, Options{SkipErrors: true})
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(tempDir, RejectsFilename))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Empty(t, readManifest(t, tempDir).Rejects)
+
+	// documents which end part way through a list can't be recovered from
+	bs = NewTestByteStream([]byte(`{"list": [1, 2 3`), 4)
+	err = SplitStream(context.Background(), bs, t.TempDir(), Options{SkipErrors: true})
+	require.Error(t, err)
+}
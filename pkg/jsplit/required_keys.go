@@ -0,0 +1,81 @@
+package jsplit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrKeysMismatch is returned when the object being split is missing one of Options.RequireKeys, or with
+// Options.ExactKeys has a key which isn't one of them
+var ErrKeysMismatch = errors.New("the document's keys don't match the required keys")
+
+// keyCheck checks the keys of the objects being split against Options.RequireKeys as they are found. A nil *keyCheck
+// checks nothing.
+type keyCheck struct {
+	required []string
+	exact    bool
+	found    map[string]bool // the required keys found in the object being checked
+}
+
+// newKeyCheck returns a *keyCheck for opts.RequireKeys, or nil if no keys are required
+func newKeyCheck(opts Options) *keyCheck {
+	if len(opts.RequireKeys) == 0 {
+		return nil
+	}
+
+	return &keyCheck{required: opts.RequireKeys, exact: opts.ExactKeys, found: make(map[string]bool)}
+}
+
+// add records a key of the object, returning an error straight away if it isn't a required key and opts.ExactKeys is
+// set, before anything is written for it
+func (kc *keyCheck) add(key string) error {
+	if kc == nil {
+		return nil
+	}
+
+	if !containsKey(kc.required, key) {
+		if kc.exact {
+			return fmt.Errorf("%w: unexpected key %q", ErrKeysMismatch, key)
+		}
+
+		return nil
+	}
+
+	kc.found[key] = true
+
+	return nil
+}
+
+// end returns an error listing the required keys which weren't found in the object, once all of its keys have been
+// added, and starts checking the next object of a stream of them afresh
+func (kc *keyCheck) end() error {
+	if kc == nil {
+		return nil
+	}
+
+	var missing []string
+
+	for _, key := range kc.required {
+		if !kc.found[key] {
+			missing = append(missing, fmt.Sprintf("%q", key))
+		}
+	}
+
+	kc.found = make(map[string]bool)
+
+	if missing != nil {
+		return fmt.Errorf("%w: missing %s", ErrKeysMismatch, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// list returns the error for a document whose root is a list, which has none of the required keys
+func (kc *keyCheck) list() error {
+	if kc == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: the document is a list, which has no keys", ErrKeysMismatch)
+}
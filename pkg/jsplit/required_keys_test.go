@@ -0,0 +1,112 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitRequireKeys(t *testing.T) {
+	doc := `{"users": [{"id": 1}, {"id": 2}], "count": 2, "groups": ["admin"]}`
+
+	// keys left out of the split still count as found
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{RequireKeys: []string{"users", "count", "groups"}, ExcludeKeys: []string{"groups"}}))
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), "{\"id\":1}\n{\"id\":2}")
+	require.NoFileExists(t, filepath.Join(tempDir, "groups_00.jsonl"))
+
+	// every key which is missing is named once the end of the object is reached
+	outputDir := filepath.Join(t.TempDir(), "output")
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), outputDir,
+		Options{RequireKeys: []string{"accounts", "users", "total"}})
+	require.ErrorIs(t, err, ErrKeysMismatch)
+	require.EqualError(t, err, `the document's keys don't match the required keys: missing "accounts", "total"`)
+
+	// the directory created for the split is removed with the files written before the end of the object
+	require.NoDirExists(t, outputDir)
+
+	// while a directory which already existed keeps them, without a manifest
+	tempDir = t.TempDir()
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{RequireKeys: []string{"accounts"}})
+	require.ErrorIs(t, err, ErrKeysMismatch)
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), "{\"id\":1}\n{\"id\":2}")
+	require.NoFileExists(t, filepath.Join(tempDir, "manifest.json"))
+
+	// keys which aren't required are allowed unless the keys must be exact
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(),
+		Options{RequireKeys: []string{"users"}}))
+
+	tempDir = t.TempDir()
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+		Options{RequireKeys: []string{"users", "groups"}, ExactKeys: true})
+	require.ErrorIs(t, err, ErrKeysMismatch)
+	require.EqualError(t, err, `the document's keys don't match the required keys: unexpected key "count"`)
+
+	// the split stops at the unexpected key, before the lists after it are written
+	require.NoFileExists(t, filepath.Join(tempDir, "groups_00.jsonl"))
+	require.NoFileExists(t, filepath.Join(tempDir, "root.json"))
+
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(),
+		Options{RequireKeys: []string{"groups", "users", "count"}, ExactKeys: true}))
+
+	// each document of a stream of them must have every key
+	stream := `{"users": [{"id": 1}], "count": 1}` + "\n" + `{"count": 2}`
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(stream), 8), t.TempDir(),
+		Options{RequireKeys: []string{"users", "count"}})
+	require.EqualError(t, err, `the document's keys don't match the required keys: missing "users"`)
+
+	// a document which is a list has no keys
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(`[{"id": 1}]`), 8), t.TempDir(),
+		Options{RequireKeys: []string{"users"}})
+	require.ErrorIs(t, err, ErrKeysMismatch)
+	require.ErrorContains(t, err, "the document is a list, which has no keys")
+}
+
+func TestSplitFilesRequireKeys(t *testing.T) {
+	dir := t.TempDir()
+	filenames := writeInputs(t, dir,
+		`{"name": "first", "users": [{"id": 1}]}`,
+		`{"name": "second"}`,
+	)
+
+	// each file must have every key
+	err := SplitFiles(context.Background(), filenames, filepath.Join(dir, "output"),
+		Options{RequireKeys: []string{"users"}})
+	require.ErrorIs(t, err, ErrKeysMismatch)
+	require.ErrorContains(t, err, `missing "users"`)
+	require.NoDirExists(t, filepath.Join(dir, "output"))
+
+	require.NoError(t, SplitFiles(context.Background(), filenames, filepath.Join(dir, "names"),
+		Options{RequireKeys: []string{"name"}}))
+
+	err = SplitFiles(context.Background(), filenames, filepath.Join(dir, "exact"),
+		Options{RequireKeys: []string{"name"}, ExactKeys: true})
+	require.ErrorIs(t, err, ErrKeysMismatch)
+	require.ErrorContains(t, err, `unexpected key "users"`)
+}
+
+func TestRecordsRequireKeys(t *testing.T) {
+	received, err := collectRecords(t, `{"users": [{"id": 1}], "count": 1}`, Options{RequireKeys: []string{"groups"}})
+	require.EqualError(t, err, `the document's keys don't match the required keys: missing "groups"`)
+	require.Equal(t, []string{`users {"id":1}`}, received)
+
+	_, err = collectRecords(t, `{}`, Options{RequireKeys: []string{"groups"}})
+	require.ErrorIs(t, err, ErrKeysMismatch)
+
+	_, err = collectRecords(t, `{"users": [{"id": 1}], "count": 1}`,
+		Options{RequireKeys: []string{"users"}, ExactKeys: true})
+	require.EqualError(t, err, `the document's keys don't match the required keys: unexpected key "count"`)
+}
+
+func TestRequireKeysValidate(t *testing.T) {
+	opts := Options{ExactKeys: true}
+	require.EqualError(t, opts.validate(), "exact keys can only be used with required keys")
+
+	opts = Options{RequireKeys: []string{"users"}, CheckpointInterval: time.Minute}
+	require.EqualError(t, opts.validate(), "checkpoints can't be used with required keys")
+}
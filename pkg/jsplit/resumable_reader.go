@@ -0,0 +1,164 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"gocloud.dev/gcerrors"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy controls how reads from network sources which fail with transient errors are retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a failing read is attempted before the error is returned. 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the time waited before the first retry. It doubles with each further attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used unless WithRetryPolicy is supplied
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+}
+
+// WithRetryPolicy sets how reads from cloud storage objects and http(s) URLs which fail with transient errors, such as
+// a dropped connection, are retried
+func WithRetryPolicy(policy RetryPolicy) AsyncReaderOption {
+	return func(afr *AsyncReader) error {
+		if policy.MaxAttempts < 1 {
+			return fmt.Errorf("max attempts must be at least 1, got %d", policy.MaxAttempts)
+		}
+
+		afr.retry = policy
+
+		return nil
+	}
+}
+
+// backoff returns how long to wait before making the given attempt
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	d := rp.InitialBackoff
+	for i := 2; i < attempt && d < rp.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+
+	return d
+}
+
+// reopenFunc opens a source so that reading resumes at offset
+type reopenFunc func(ctx context.Context, offset int64) (io.ReadCloser, error)
+
+// resumableReader reads from a source which, when it fails with a transient error, is reopened at the offset already
+// read so that the bytes returned continue seamlessly.
+type resumableReader struct {
+	rc     io.ReadCloser
+	reopen reopenFunc
+	policy RetryPolicy
+	offset int64
+}
+
+func newResumableReader(rc io.ReadCloser, reopen reopenFunc, policy RetryPolicy) *resumableReader {
+	return &resumableReader{
+		rc:     rc,
+		reopen: reopen,
+		policy: policy,
+	}
+}
+
+// Read reads from the source reopening it if a previous read failed
+func (rr *resumableReader) Read(p []byte) (int, error) {
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			time.Sleep(rr.policy.backoff(attempt))
+		}
+
+		if rr.rc == nil {
+			rc, err := rr.reopen(context.TODO(), rr.offset)
+			if err != nil {
+				if !isRetryable(err) || attempt >= rr.policy.MaxAttempts {
+					return 0, err
+				}
+
+				rr.logRetry(attempt, err)
+
+				continue
+			}
+
+			rr.rc = rc
+		}
+
+		n, err := rr.rc.Read(p)
+		rr.offset += int64(n)
+
+		if err == nil || err == io.EOF || !isRetryable(err) || attempt >= rr.policy.MaxAttempts {
+			return n, err
+		}
+
+		_ = rr.rc.Close()
+		rr.rc = nil
+
+		rr.logRetry(attempt, err)
+
+		// hand back what was read, the source is reopened on the next call
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// logRetry logs that the source is reopened after the attempt failed with err
+func (rr *resumableReader) logRetry(attempt int, err error) {
+	logger().Debug(fmt.Sprintf("Retrying read at offset %d after attempt %d of %d failed: %s", rr.offset, attempt,
+		rr.policy.MaxAttempts, err), "offset", rr.offset, "attempt", attempt, "max_attempts", rr.policy.MaxAttempts,
+		"error", err)
+}
+
+// Close closes the current source
+func (rr *resumableReader) Close() error {
+	if rr.rc == nil {
+		return nil
+	}
+
+	err := rr.rc.Close()
+	rr.rc = nil
+
+	return err
+}
+
+// isRetryable returns true for errors which are likely to succeed if the read is tried again
+func isRetryable(err error) bool {
+	switch gcerrors.Code(err) {
+	case gcerrors.ResourceExhausted, gcerrors.Internal, gcerrors.DeadlineExceeded:
+		return true
+	}
+
+	// e.g. a 503 from Google Cloud Storage
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && (apiErr.Code >= 500 || apiErr.Code == 429) {
+		return true
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && (statusErr.code >= 500 || statusErr.code == 429) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
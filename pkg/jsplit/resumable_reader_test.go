@@ -0,0 +1,182 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingSource returns failAfter bytes from offset before failing with err
+type failingSource struct {
+	*bytes.Reader
+	err       error
+	failAfter int
+}
+
+func (fs *failingSource) Read(p []byte) (int, error) {
+	if fs.failAfter <= 0 {
+		return 0, fs.err
+	}
+
+	if len(p) > fs.failAfter {
+		p = p[:fs.failAfter]
+	}
+
+	n, err := fs.Reader.Read(p)
+	fs.failAfter -= n
+
+	return n, err
+}
+
+func (fs *failingSource) Close() error {
+	return nil
+}
+
+// flakyOpener opens sources which fail after failAfter bytes the first failures times they are opened
+func flakyOpener(data []byte, failures int, failAfter int, err error) (reopenFunc, *[]int64) {
+	var offsets []int64
+
+	return func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+		offsets = append(offsets, offset)
+
+		rd := bytes.NewReader(data[offset:])
+		if len(offsets) > failures {
+			return io.NopCloser(rd), nil
+		}
+
+		return &failingSource{Reader: rd, err: err, failAfter: failAfter}, nil
+	}, &offsets
+}
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     4 * time.Millisecond,
+}
+
+func TestResumableReader(t *testing.T) {
+	data := make([]byte, 4096)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	reopen, offsets := flakyOpener(data, 3, 1000, io.ErrUnexpectedEOF)
+
+	rc, err := reopen(context.Background(), 0)
+	require.NoError(t, err)
+
+	rr := newResumableReader(rc, reopen, testRetryPolicy)
+	read, err := io.ReadAll(rr)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+	require.Equal(t, []int64{0, 1000, 2000, 3000}, *offsets)
+}
+
+func TestResumableReaderErrors(t *testing.T) {
+	data := make([]byte, 256)
+
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+	}{
+		{
+			name:     "not retryable",
+			failures: 1,
+			err:      errors.New("test error"),
+		},
+		{
+			name:     "too many attempts",
+			failures: testRetryPolicy.MaxAttempts,
+			err:      io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reopen, _ := flakyOpener(data, test.failures, 0, test.err)
+
+			rc, err := reopen(context.Background(), 0)
+			require.NoError(t, err)
+
+			rr := newResumableReader(rc, reopen, testRetryPolicy)
+			_, err = io.ReadAll(rr)
+			require.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	require.Equal(t, time.Second, policy.backoff(2))
+	require.Equal(t, 2*time.Second, policy.backoff(3))
+	require.Equal(t, 4*time.Second, policy.backoff(4))
+	require.Equal(t, 5*time.Second, policy.backoff(5))
+	require.Equal(t, 5*time.Second, policy.backoff(9))
+
+	_, err := AsyncReaderFromReader(bytes.NewReader([]byte("{}")), 8, WithRetryPolicy(RetryPolicy{}))
+	require.Error(t, err)
+}
+
+func TestAsyncReaderFromCloud(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	filename := filepath.Join(t.TempDir(), "test.json.gz")
+	require.NoError(t, os.WriteFile(filename, gzipBytes(t, []byte(contents)), 0o644))
+
+	rd, err := AsyncReaderFromFile("file://"+filepath.ToSlash(filename), 8)
+	require.NoError(t, err)
+
+	ctx := rd.Start(context.Background())
+	require.Equal(t, contents, string(readAll(t, ctx, rd)))
+}
+
+func TestAsyncReaderFromFakeGCS(t *testing.T) {
+	const contents = `{"list": [1, 2, 3]}`
+
+	client := fakeCloud(t, map[string][]byte{
+		"gs://bucket/data.json":     []byte(contents),
+		"gs://bucket/data.json.gz":  gzipBytes(t, []byte(contents)),
+		"gs://bucket/parts/01.json": []byte(`{"part": 1}`),
+		"gs://bucket/parts/02.json": []byte(`{"part": 2}`),
+	})
+
+	for _, uri := range []string{"gs://bucket/data.json", "gs://bucket/data.json.gz"} {
+		rd, err := AsyncReaderFromFile(uri, 8, WithCloudClient(client))
+		require.NoError(t, err)
+
+		require.Equal(t, contents, string(readAll(t, rd.Start(context.Background()), rd)), uri)
+		require.NoError(t, rd.Close())
+	}
+
+	// the size of a compressed object isn't known once it is decompressed
+	rd, err := AsyncReaderFromFile("gs://bucket/data.json", 8, WithCloudClient(client))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(contents)), rd.TotalSize())
+	require.NoError(t, rd.Close())
+
+	rd, err = AsyncReaderFromFile("gs://bucket/data.json.gz", 8, WithCloudClient(client))
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), rd.TotalSize())
+	require.NoError(t, rd.Close())
+
+	rd, err = AsyncReaderFromFile("gs://bucket/parts/", 8, WithCloudClient(client))
+	require.NoError(t, err)
+	require.Equal(t, `{"part": 1}{"part": 2}`, string(readAll(t, rd.Start(context.Background()), rd)))
+	require.NoError(t, rd.Close())
+
+	// errors opening buckets and objects are returned by AsyncReaderFromFile
+	_, err = AsyncReaderFromFile("gs://bucket/missing.json", 8, WithCloudClient(client))
+	require.ErrorContains(t, err, "gs://bucket/missing.json does not exist")
+
+	_, err = AsyncReaderFromFile("gs://other/data.json", 8, WithCloudClient(client))
+	require.EqualError(t, err, "bucket gs://other not found")
+}
@@ -0,0 +1,103 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// parsePointer returns the reference tokens of a JSON pointer (RFC 6901), e.g. /data/results has the tokens data and
+// results. The empty pointer refers to the whole document and has no tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid root pointer %q, it must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, tok := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// descend moves the iterator, which has just read the opening brace of the document, to the value the pointer refers
+// to. The next call to itr.Next returns the first character of the value. Every value before it is skipped over, and
+// the values which follow it aren't read at all. Each token is the key of an object nested in the one before it, so
+// pointers can't refer to values within arrays.
+func descend(itr *BufferedByteStreamIter, pointer string, tokens []string) error {
+	for i, tok := range tokens {
+		if i > 0 {
+			SkipWhitespace(itr)
+
+			ch := itr.Next()
+			if ch != OpenCB {
+				return fmt.Errorf("root pointer %s doesn't resolve to an object, /%s isn't an object", pointer,
+					strings.Join(tokens[:i], "/"))
+			}
+
+			itr.Skip()
+		}
+
+		err := findKey(itr, tok)
+		if err != nil {
+			return fmt.Errorf("root pointer %s doesn't resolve to an object: %w", pointer, err)
+		}
+	}
+
+	SkipWhitespace(itr)
+
+	return nil
+}
+
+// findKey moves the iterator past the key of the object being read, skipping over the values of the keys before it
+func findKey(itr *BufferedByteStreamIter, key string) error {
+	for {
+		SkipWhitespace(itr)
+
+		if itr.Next() == CloseCB {
+			return fmt.Errorf("key %q not found", key)
+		}
+
+		itr.Advance(-1)
+
+		rawKey, err := ParseKey(itr)
+		if err != nil {
+			return err
+		}
+
+		var name string
+
+		err = json.Unmarshal(rawKey, &name)
+		if err != nil {
+			return err
+		}
+
+		if name == key {
+			return nil
+		}
+
+		// values are skipped as if they were list items so that nested lists aren't split
+		_, _, err = ParseVal(itr, nil, List)
+		if err != nil {
+			return err
+		}
+
+		SkipWhitespace(itr)
+
+		ch := itr.Next()
+
+		switch ch {
+		case COMMA:
+			itr.Skip()
+		case CloseCB:
+			return fmt.Errorf("key %q not found", key)
+		default:
+			return itr.errorf("unexpected token %q found. Expecting ','", rune(ch))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package jsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePointer(t *testing.T) {
+	tokens, err := parsePointer("")
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+
+	tokens, err = parsePointer("/data/results")
+	require.NoError(t, err)
+	require.Equal(t, []string{"data", "results"}, tokens)
+
+	tokens, err = parsePointer("/a~1b/c~0d/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/b", "c~d", ""}, tokens)
+
+	_, err = parsePointer("data")
+	require.Error(t, err)
+}
+
+func TestSplitStreamRoot(t *testing.T) {
+	var testStr = `{
+		"meta": {"results": {"ignored": [1, 2]}, "list": ["x"]},
+		"other": [{"a": "}"}, 2],
+		"data": {
+			"count": 2,
+			"results": {"key": "value", "list": [{"idx": 0}, {"idx": 1}]},
+			"after": [3]
+		},
+		"trailing": [4]
+	}`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{Root: "/data/results"})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"key\":\"value\"\n}")
+	requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}")
+
+	// nothing outside of the subtree is split
+	for _, name := range []string{"ignored_00.jsonl", "other_00.jsonl", "after_00.jsonl", "trailing_00.jsonl"} {
+		_, err = os.Stat(filepath.Join(tempDir, name))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	// an array is split as a single list, or into shards
+	bs = NewTestByteStream([]byte(`{"data": {"items": [1, 2, 3]}}`), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Root: "/data/items"})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), "1\n2\n3")
+
+	bs = NewTestByteStream([]byte(`{"data": {"items": [1, 2, 3]}}`), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Root: "/data/items", Shards: 2})
+	require.NoError(t, err)
+
+	requireContents(t, filepath.Join(tempDir, "part-000_00.jsonl"), "1\n3")
+	requireContents(t, filepath.Join(tempDir, "part-001_00.jsonl"), "2")
+}
+
+func TestSplitStreamRootErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		root   string
+		errMsg string
+	}{
+		{"missing key", `{"data": {"other": {}}}`, "/data/results", `key "results" not found`},
+		{"scalar", `{"data": {"results": 1}}`, "/data/results", "root pointer /data/results doesn't resolve to an object or array"},
+		{"intermediate scalar", `{"data": "str"}`, "/data/results", "/data isn't an object"},
+		{"document array", `[1]`, "/data", "the document isn't an object"},
+		{"invalid pointer", `{"data": {}}`, "data", "must start with /"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := NewTestByteStream([]byte(test.doc), 16)
+			err := SplitStream(context.Background(), bs, t.TempDir(), Options{Root: test.root})
+			require.ErrorContains(t, err, test.errMsg)
+		})
+	}
+}
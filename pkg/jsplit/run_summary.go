@@ -0,0 +1,223 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// RunSummary describes how a run splitting files went, for schedulers which act on its outcome: what was read, where
+// it was written, how long it took and why it ended. It is written as json by WriteFile whether the run succeeded or
+// failed. Unlike the Manifest, which describes the data written, it only holds operational metadata.
+type RunSummary struct {
+	// Succeeded is set when the run finished without error
+	Succeeded bool `json:"succeeded"`
+
+	// Reason is why the run ended, such as completed, interrupted or error, and ExitCode the status the process exited
+	// with. Both are set by the caller, as only it knows how it exits.
+	Reason   string `json:"reason"`
+	ExitCode int    `json:"exit_code"`
+
+	// Error is the error the run failed with
+	Error string `json:"error,omitempty"`
+
+	// Problems are the non-fatal problems found during the run, up to the number the ErrCancelContext kept, and
+	// ProblemCount the number which were reported
+	Problems     []RunProblem `json:"problems,omitempty"`
+	ProblemCount int          `json:"problem_count,omitempty"`
+
+	// Inputs are the files, URLs or cloud storage objects read, and Output the directory, cloud storage prefix or file
+	// the output was written to, - for standard output
+	Inputs []string `json:"inputs"`
+	Output string   `json:"output"`
+
+	// Files are the full paths of the files listed in the manifest written to the output directory, when one was
+	Files []string `json:"files,omitempty"`
+
+	// Uploaded are the URIs of the objects written to cloud storage whose uploads completed, in the order they did,
+	// which are known even when the run was interrupted before its manifest was written, see TrackUploads
+	Uploaded []string `json:"uploaded,omitempty"`
+
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+
+	// Duration is the number of seconds the run took
+	Duration float64 `json:"duration_seconds"`
+
+	// BytesRead is the number of bytes of input read, of the TotalBytes it held when its size was known, see Stats
+	BytesRead  int64 `json:"bytes_read"`
+	TotalBytes int64 `json:"total_bytes,omitempty"`
+
+	// Records is the number of list items parsed, and Keys the number parsed from each list, by key, see Stats
+	Records int64            `json:"records"`
+	Keys    map[string]int64 `json:"keys"`
+
+	// KeyStats describes what was written for each key listed in the manifest, for finding the keys which dominate the
+	// output. Like Files it is only known when the output is a directory with a manifest written by the run.
+	KeyStats map[string]RunKeyStats `json:"key_stats,omitempty"`
+
+	writeTimes map[string]time.Duration // the time spent writing each key, see TrackWriteTimes
+
+	mu sync.Mutex
+}
+
+// RunKeyStats is what was written for a key. Bytes is the size of its files as written, after any compression, and
+// AvgRecordBytes the number of those bytes per record. WriteSeconds is the wall-clock time spent writing the key when
+// it was recorded with TrackWriteTimes, see Options.KeyWriteTime.
+type RunKeyStats struct {
+	Bytes          int64   `json:"bytes"`
+	Files          int     `json:"files"`
+	Records        int64   `json:"records"`
+	AvgRecordBytes float64 `json:"avg_record_bytes"`
+	WriteSeconds   float64 `json:"write_seconds,omitempty"`
+}
+
+// RunProblem is a Problem as it is recorded in a RunSummary
+type RunProblem struct {
+	Offset int64  `json:"offset"`
+	Error  string `json:"error"`
+}
+
+// NewRunSummary returns the summary of a run starting now, reading inputs and writing to output
+func NewRunSummary(inputs []string, output string) *RunSummary {
+	return &RunSummary{Inputs: inputs, Output: output, Started: time.Now(), Keys: map[string]int64{}}
+}
+
+// Track returns a function for Options.Stats recording the progress of the split in the summary, which passes the
+// stats on to next when it isn't nil
+func (rs *RunSummary) Track(next func(Stats)) func(Stats) {
+	return func(stats Stats) {
+		rs.mu.Lock()
+		rs.BytesRead, rs.TotalBytes, rs.Records = stats.BytesRead, stats.TotalBytes, stats.Records
+
+		for key, records := range stats.Keys {
+			rs.Keys[key] = records
+		}
+		rs.mu.Unlock()
+
+		if next != nil {
+			next(stats)
+		}
+	}
+}
+
+// TrackUploads returns a function for Options.Uploaded recording each object uploaded in the summary
+func (rs *RunSummary) TrackUploads() func(uri string) {
+	return func(uri string) {
+		rs.mu.Lock()
+		rs.Uploaded = append(rs.Uploaded, uri)
+		rs.mu.Unlock()
+	}
+}
+
+// TrackWriteTimes returns a function for Options.KeyWriteTime recording the time spent writing each key in the
+// summary's KeyStats
+func (rs *RunSummary) TrackWriteTimes() func(key string, elapsed time.Duration) {
+	return func(key string, elapsed time.Duration) {
+		rs.mu.Lock()
+		if rs.writeTimes == nil {
+			rs.writeTimes = make(map[string]time.Duration)
+		}
+
+		rs.writeTimes[key] = elapsed
+		rs.mu.Unlock()
+	}
+}
+
+// Finish records that the run ended with err, along with the problems collected by ctx, which can be nil. When the
+// output is a directory with a manifest written by the run, the files it lists and what was written for each key are
+// recorded too.
+func (rs *RunSummary) Finish(ctx context.Context, err error, problems *ErrCancelContext) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.Finished = time.Now()
+	rs.Duration = rs.Finished.Sub(rs.Started).Seconds()
+	rs.Succeeded = err == nil
+
+	if err != nil {
+		rs.Error = err.Error()
+	}
+
+	if problems != nil {
+		found, reported := problems.Problems()
+		for _, p := range found {
+			rs.Problems = append(rs.Problems, RunProblem{Offset: p.Offset, Error: p.Err.Error()})
+		}
+
+		rs.ProblemCount = reported
+	}
+
+	// the manifest left by an earlier run may be there when this one failed
+	if err != nil && !errors.Is(err, ErrFilesFailed) {
+		return
+	}
+
+	ds := &dirSink{ctx: ctx, dir: rs.Output}
+
+	m := readRunManifest(ctx, ds)
+	if m == nil {
+		return
+	}
+
+	rs.Files = []string{ds.path(ManifestFilename)}
+	for _, f := range m.files() {
+		rs.Files = append(rs.Files, ds.path(f.Name))
+	}
+
+	rs.KeyStats = make(map[string]RunKeyStats, len(m.Keys))
+	for _, mk := range m.Keys {
+		ks := RunKeyStats{Bytes: mk.Bytes, Files: len(mk.Files), Records: mk.Records,
+			WriteSeconds: rs.writeTimes[mk.Key].Seconds()}
+		if mk.Records > 0 {
+			ks.AvgRecordBytes = float64(mk.Bytes) / float64(mk.Records)
+		}
+
+		rs.KeyStats[mk.Key] = ks
+	}
+}
+
+// readRunManifest returns the manifest in the directory written to by the sink, or nil if it has none
+func readRunManifest(ctx context.Context, ds *dirSink) *Manifest {
+	if ds.dir == "" || ds.dir == "-" {
+		return nil
+	}
+
+	data, err := readOutputFile(ctx, ds.path(ManifestFilename))
+	if err != nil {
+		return nil
+	}
+
+	var m Manifest
+
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil
+	}
+
+	return &m
+}
+
+// WriteFile writes the summary as json to the local file at path, replacing it in one go so that a scheduler never
+// reads part of it
+func (rs *RunSummary) WriteFile(path string) error {
+	rs.mu.Lock()
+	data, err := json.MarshalIndent(rs, "", "\t")
+	rs.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	err = os.WriteFile(tmp, append(data, '\n'), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
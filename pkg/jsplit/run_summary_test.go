@@ -0,0 +1,138 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSummary(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	require.NoError(t, os.WriteFile(input, []byte(`{"users": [1, 2, 3], "groups": [4], "name": "x"}`), 0o644))
+
+	// a run which succeeds records what it read and the files it wrote
+	outputDir := filepath.Join(dir, "out")
+	summary := NewRunSummary([]string{input}, outputDir)
+
+	var calls int
+
+	err := SplitFile(context.Background(), input, outputDir, Options{Stats: summary.Track(func(Stats) { calls++ })})
+	require.NoError(t, err)
+	require.Positive(t, calls)
+
+	summary.Reason, summary.ExitCode = "completed", 0
+	summary.Finish(context.Background(), err, nil)
+
+	path := filepath.Join(dir, "summary.json")
+	require.NoError(t, summary.WriteFile(path))
+
+	var written map[string]interface{}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &written))
+
+	require.Equal(t, true, written["succeeded"])
+	require.Equal(t, "completed", written["reason"])
+	require.NotContains(t, written, "error")
+	require.Equal(t, []interface{}{input}, written["inputs"])
+	require.Equal(t, outputDir, written["output"])
+	require.ElementsMatch(t, []interface{}{filepath.Join(outputDir, ManifestFilename),
+		filepath.Join(outputDir, "root.json"), filepath.Join(outputDir, "users_00.jsonl"),
+		filepath.Join(outputDir, "groups_00.jsonl")}, written["files"])
+	require.Equal(t, float64(48), written["bytes_read"])
+	require.Equal(t, float64(4), written["records"])
+	require.Equal(t, map[string]interface{}{"users": float64(3), "groups": float64(1)}, written["keys"])
+	require.GreaterOrEqual(t, written["duration_seconds"], float64(0))
+	require.False(t, summary.Finished.Before(summary.Started))
+}
+
+func TestRunSummaryFailed(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	require.NoError(t, os.WriteFile(input, []byte(`{"users": [1, 2, {`), 0o644))
+
+	// a run which fails records its error and the problems found, but not the files of a manifest it didn't write
+	outputDir := filepath.Join(dir, "out")
+	summary := NewRunSummary([]string{input}, outputDir)
+
+	ctx, _ := NewErrContextWithProblems(context.Background(), 1)
+	ctx.Report(3, errors.New("first problem"))
+	ctx.Report(5, errors.New("second problem"))
+
+	err := SplitFile(ctx, input, outputDir, Options{Stats: summary.Track(nil)})
+	require.Error(t, err)
+
+	summary.Reason, summary.ExitCode = "error", 1
+	summary.Finish(ctx, err, ctx)
+
+	path := filepath.Join(dir, "summary.json")
+	require.NoError(t, summary.WriteFile(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var written RunSummary
+
+	require.NoError(t, json.Unmarshal(data, &written))
+	require.False(t, written.Succeeded)
+	require.Equal(t, "error", written.Reason)
+	require.Equal(t, 1, written.ExitCode)
+	require.Contains(t, written.Error, "invalid json")
+	require.Equal(t, []RunProblem{{Offset: 3, Error: "first problem"}}, written.Problems)
+	require.Equal(t, 2, written.ProblemCount)
+	require.Empty(t, written.Files)
+	require.Equal(t, int64(2), written.Keys["users"])
+}
+
+func TestRunSummaryKeyStats(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	require.NoError(t, os.WriteFile(input, []byte(`{"users": [{"id": 1}, {"id": 2}, {"id": 3}], "groups": [4, 5], `+
+		`"empty": [], "name": "x"}`), 0o644))
+
+	// each key's bytes, files, records and the time spent writing it are recorded, with its lists written at once
+	outputDir := filepath.Join(dir, "out")
+	summary := NewRunSummary([]string{input}, outputDir)
+
+	err := SplitFile(context.Background(), input, outputDir, Options{Concurrency: 2, MaxFileBytes: 16,
+		Stats: summary.Track(nil), KeyWriteTime: summary.TrackWriteTimes()})
+	require.NoError(t, err)
+
+	summary.Finish(context.Background(), err, nil)
+	require.Len(t, summary.KeyStats, 2)
+
+	users := summary.KeyStats["users"]
+	require.Equal(t, 2, users.Files)
+	require.Equal(t, int64(3), users.Records)
+	require.Equal(t, int64(len(`{"id":1}`+"\n"+`{"id":2}`)+len(`{"id":3}`)), users.Bytes, "the two files")
+	require.InDelta(t, float64(users.Bytes)/3, users.AvgRecordBytes, 1e-9)
+	require.Positive(t, users.WriteSeconds)
+	require.Less(t, users.WriteSeconds, summary.Duration)
+
+	groups := summary.KeyStats["groups"]
+	require.Equal(t, RunKeyStats{Bytes: 3, Files: 1, Records: 2, AvgRecordBytes: 1.5,
+		WriteSeconds: groups.WriteSeconds}, groups)
+	require.Positive(t, groups.WriteSeconds)
+
+	// they are written with the summary
+	path := filepath.Join(dir, "summary.json")
+	require.NoError(t, summary.WriteFile(path))
+
+	var written struct {
+		KeyStats map[string]map[string]interface{} `json:"key_stats"`
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &written))
+	require.Equal(t, float64(3), written.KeyStats["users"]["records"])
+	require.Equal(t, float64(1.5), written.KeyStats["groups"]["avg_record_bytes"])
+	require.Contains(t, written.KeyStats["users"], "write_seconds")
+}
@@ -0,0 +1,372 @@
+package jsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// schemaDialect is the JSON Schema version of the schemas written for lists
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaType is a bit set of the json types observed for a value
+type schemaType uint8
+
+const (
+	schemaObject schemaType = 1 << iota
+	schemaArray
+	schemaString
+	schemaNumber
+	schemaBoolean
+	schemaNull
+)
+
+// schemaTypeNames are the JSON Schema names of each type, in the order they are listed in a union
+var schemaTypeNames = []struct {
+	typ  schemaType
+	name string
+}{
+	{schemaObject, "object"},
+	{schemaArray, "array"},
+	{schemaString, "string"},
+	{schemaNumber, "number"},
+	{schemaBoolean, "boolean"},
+	{schemaNull, "null"},
+}
+
+// typeOf returns the type of a json encoded value
+func typeOf(val []byte) schemaType {
+	if len(val) == 0 {
+		return 0
+	}
+
+	switch val[0] {
+	case OpenCB:
+		return schemaObject
+	case OpenSB:
+		return schemaArray
+	case QM:
+		return schemaString
+	case 't', 'f':
+		return schemaBoolean
+	case 'n':
+		return schemaNull
+	default:
+		return schemaNumber
+	}
+}
+
+// schemaNode accumulates the types observed for a value. The fields of objects and the elements of arrays are
+// described by child nodes, so the size of a schema depends on the shape of the data and not on the number of items.
+type schemaNode struct {
+	types   schemaType
+	floats  bool  // whether any of the numbers observed weren't integers, which BigQuery schemas tell apart
+	objects int64 // number of objects observed, used to tell which fields are required
+	fields  []*schemaField
+	index   map[string]int
+	items   *schemaNode
+}
+
+// schemaField describes a field of the objects observed for a node
+type schemaField struct {
+	name string
+	seen int64 // number of objects the field was in
+	node *schemaNode
+}
+
+// observe adds the type of a json encoded value, and those of its fields or elements, to the node
+func (sn *schemaNode) observe(val []byte) error {
+	typ := typeOf(val)
+	sn.types |= typ
+
+	switch typ {
+	case schemaNumber:
+		if !sn.floats {
+			_, err := strconv.ParseInt(string(val), 10, 64)
+			sn.floats = err != nil
+		}
+
+	case schemaObject:
+		fields, err := decodeObject(val)
+		if err != nil {
+			return err
+		}
+
+		sn.objects++
+
+		for _, field := range fields {
+			err = sn.field(field.Key).observeIn(field.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+	case schemaArray:
+		var elems []json.RawMessage
+
+		err := json.Unmarshal(val, &elems)
+		if err != nil {
+			return err
+		}
+
+		if sn.items == nil {
+			sn.items = &schemaNode{}
+		}
+
+		for _, elem := range elems {
+			err = sn.items.observe(elem)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// field returns the field with the given name, adding it if it hasn't been seen before
+func (sn *schemaNode) field(name string) *schemaField {
+	if i, ok := sn.index[name]; ok {
+		return sn.fields[i]
+	}
+
+	if sn.index == nil {
+		sn.index = make(map[string]int)
+	}
+
+	sn.index[name] = len(sn.fields)
+	field := &schemaField{name: name, node: &schemaNode{}}
+	sn.fields = append(sn.fields, field)
+
+	return field
+}
+
+// observeIn observes the value of the field in an object
+func (sf *schemaField) observeIn(val []byte) error {
+	sf.seen++
+	return sf.node.observe(val)
+}
+
+// MarshalJSON encodes the node as a JSON Schema. A single observed type is written as a string and conflicting types
+// as a union. Fields are listed in the order they were first seen and are required if they were in every object.
+func (sn *schemaNode) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(OpenCB)
+
+	var names []string
+	for _, tn := range schemaTypeNames {
+		if sn.types&tn.typ != 0 {
+			names = append(names, tn.name)
+		}
+	}
+
+	sep := func() {
+		if buf.Len() > 1 {
+			buf.WriteByte(COMMA)
+		}
+	}
+
+	write := func(key string, val interface{}) error {
+		sep()
+
+		data, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+
+		buf.WriteString(`"` + key + `":`)
+		buf.Write(data)
+
+		return nil
+	}
+
+	var err error
+
+	switch len(names) {
+	case 0:
+	case 1:
+		err = write("type", names[0])
+	default:
+		err = write("type", names)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sn.types&schemaObject != 0 {
+		sep()
+		buf.WriteString(`"properties":{`)
+
+		var required []string
+
+		for i, field := range sn.fields {
+			if i > 0 {
+				buf.WriteByte(COMMA)
+			}
+
+			key, err := json.Marshal(field.name)
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := field.node.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+
+			buf.Write(key)
+			buf.WriteByte(COLON)
+			buf.Write(data)
+
+			if field.seen == sn.objects {
+				required = append(required, field.name)
+			}
+		}
+
+		buf.WriteByte(CloseCB)
+
+		if len(required) > 0 {
+			err = write("required", required)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if sn.items != nil {
+		err = write("items", sn.items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteByte(CloseCB)
+
+	return buf.Bytes(), nil
+}
+
+// ListSchema infers a JSON Schema for the items of a list as they are split, and a BigQuery schema when
+// Options.BigQuerySchema is set
+type ListSchema struct {
+	key   string
+	name  string // name the schema's files are given, before their extensions
+	items schemaNode
+
+	// jsonSchema and bigQuery are set for each of the schemas written for the list
+	jsonSchema bool
+	bigQuery   bool
+}
+
+// NewListSchema returns a *ListSchema for the list with the given key
+func NewListSchema(key string) *ListSchema {
+	return &ListSchema{key: key, name: fileKey(key), jsonSchema: true}
+}
+
+// newListSchema returns a *ListSchema writing the schemas set by opts for the list with the given key, or nil if no
+// schemas are written
+func newListSchema(key string, opts Options) *ListSchema {
+	if !opts.InferSchema && !opts.BigQuerySchema {
+		return nil
+	}
+
+	return &ListSchema{key: key, name: fileKey(key), jsonSchema: opts.InferSchema, bigQuery: opts.BigQuerySchema}
+}
+
+// Add observes the types of an item
+func (ls *ListSchema) Add(item []byte) error {
+	return ls.items.observe(item)
+}
+
+// MarshalJSON encodes a JSON Schema describing the list as an array of the observed items
+func (ls *ListSchema) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Schema string      `json:"$schema"`
+		Title  string      `json:"title"`
+		Type   string      `json:"type"`
+		Items  *schemaNode `json:"items"`
+	}{schemaDialect, ls.key, "array", &ls.items})
+}
+
+// MarshalBigQuery encodes a BigQuery schema describing the fields of the objects observed in the list, as loaded by
+// bq load --schema. Items which aren't objects are left out, as only objects can be loaded as rows.
+func (ls *ListSchema) MarshalBigQuery() ([]byte, error) {
+	return json.MarshalIndent(ls.items.bigQueryFields(), "", "\t")
+}
+
+// schemaFiles names the files describing the items of a list, which are empty for the schemas which aren't written
+type schemaFiles struct {
+	jsonSchema string
+	bigQuery   string
+}
+
+// setIn records the files in the manifest entry of a key
+func (sf schemaFiles) setIn(mk *ManifestKey) {
+	mk.Schema = sf.jsonSchema
+	mk.BigQuerySchema = sf.bigQuery
+}
+
+// write writes the schemas to [key].schema.json and [key].bq_schema.json in the sink, returning the names of the files.
+// A nil *ListSchema writes nothing.
+func (ls *ListSchema) write(sink OutputSink) (schemaFiles, error) {
+	var files schemaFiles
+
+	if ls == nil {
+		return files, nil
+	}
+
+	if ls.jsonSchema {
+		data, err := json.MarshalIndent(ls, "", "\t")
+		if err != nil {
+			return files, err
+		}
+
+		files.jsonSchema, err = ls.writeFile(sink, ".schema.json", data)
+		if err != nil {
+			return files, err
+		}
+	}
+
+	if ls.bigQuery {
+		data, err := ls.MarshalBigQuery()
+		if err != nil {
+			return files, err
+		}
+
+		files.bigQuery, err = ls.writeFile(sink, ".bq_schema.json", data)
+		if err != nil {
+			return files, err
+		}
+	}
+
+	return files, nil
+}
+
+// writeFile writes data to the schema file with the given extension, returning its name
+func (ls *ListSchema) writeFile(sink OutputSink, ext string, data []byte) (string, error) {
+	name := ls.name + ext
+
+	_, err := writeFile(sink, name, data)
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// observeItems returns a ListAddFunc which adds every item to schema before passing it to add, or add itself if schema
+// is nil
+func observeItems(add ListAddFunc, schema *ListSchema) ListAddFunc {
+	if schema == nil {
+		return add
+	}
+
+	return func(item []byte) error {
+		err := schema.Add(item)
+		if err != nil {
+			return err
+		}
+
+		return add(item)
+	}
+}
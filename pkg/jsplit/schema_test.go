@@ -0,0 +1,119 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListSchema(t *testing.T) {
+	ls := NewListSchema("users")
+
+	items := []string{
+		`{"id":1,"name":"alex","address":{"city":"x"},"tags":["a"]}`,
+		`{"id":2,"name":null,"address":{"city":"y","zip":"123"},"tags":[]}`,
+		`{"id":"3","active":true,"tags":[1,{"k":"v"}]}`,
+	}
+
+	for _, item := range items {
+		require.NoError(t, ls.Add([]byte(item)))
+	}
+
+	data, err := json.Marshal(ls)
+	require.NoError(t, err)
+
+	expected := `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "users",
+		"type": "array",
+		"items": {
+			"type": "object",
+			"properties": {
+				"id": {"type": ["string", "number"]},
+				"name": {"type": ["string", "null"]},
+				"address": {
+					"type": "object",
+					"properties": {
+						"city": {"type": "string"},
+						"zip": {"type": "string"}
+					},
+					"required": ["city"]
+				},
+				"tags": {
+					"type": "array",
+					"items": {
+						"type": ["object", "string", "number"],
+						"properties": {"k": {"type": "string"}},
+						"required": ["k"]
+					}
+				},
+				"active": {"type": "boolean"}
+			},
+			"required": ["id", "tags"]
+		}
+	}`
+	require.JSONEq(t, expected, string(data))
+
+	// mixed item types are a union, and lists without items describe any item
+	ls = NewListSchema("mixed")
+	for _, item := range []string{`1`, `"str"`, `null`, `[false]`} {
+		require.NoError(t, ls.Add([]byte(item)))
+	}
+
+	data, err = json.Marshal(ls)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "mixed", "type": "array",
+		"items": {"type": ["array", "string", "number", "null"], "items": {"type": "boolean"}}}`, string(data))
+
+	data, err = json.Marshal(NewListSchema("empty"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "empty", "type": "array",
+		"items": {}}`, string(data))
+
+	require.Error(t, NewListSchema("invalid").Add([]byte(`{"a":}`)))
+}
+
+func TestSplitStreamInferSchema(t *testing.T) {
+	var testStr = `{"key": "value", "users": [{"id": 1, "address": {"city": "x"}}, {"id": 2, "address": null}], "empty": []}`
+
+	tempDir := t.TempDir()
+
+	bs := NewTestByteStream([]byte(testStr), 16)
+	err := SplitStream(context.Background(), bs, tempDir, Options{InferSchema: true, Flatten: true})
+	require.NoError(t, err)
+
+	// the schema describes the items as written, after flattening
+	data, err := os.ReadFile(filepath.Join(tempDir, "users.schema.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "users", "type": "array",
+		"items": {"type": "object", "properties": {"id": {"type": "number"}, "address.city": {"type": "string"},
+		"address": {"type": "null"}}, "required": ["id"]}}`, string(data))
+
+	_, err = os.Stat(filepath.Join(tempDir, "empty.schema.json"))
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(tempDir, "key.schema.json"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	m := readManifest(t, tempDir)
+	require.Len(t, m.Keys, 1)
+	require.Equal(t, "users.schema.json", m.Keys[0].Schema)
+
+	bs = NewTestByteStream([]byte(`[1, "2", 3]`), 16)
+	err = SplitStream(context.Background(), bs, tempDir, Options{Shards: 2, InferSchema: true})
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(filepath.Join(tempDir, "root.schema.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "root", "type": "array",
+		"items": {"type": ["string", "number"]}}`, string(data))
+
+	m = readManifest(t, tempDir)
+	require.Len(t, m.Keys, 2)
+	require.Equal(t, "root.schema.json", m.Keys[0].Schema)
+	require.Equal(t, "root.schema.json", m.Keys[1].Schema)
+}
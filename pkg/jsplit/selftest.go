@@ -0,0 +1,315 @@
+package jsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// SelfTestStatus is the outcome of checking one of the backends input is read from
+type SelfTestStatus string
+
+const (
+	SelfTestOK      SelfTestStatus = "ok"
+	SelfTestFailed  SelfTestStatus = "failed"
+	SelfTestSkipped SelfTestStatus = "skipped"
+)
+
+// selfTestTimeout bounds each check, so that a backend which hangs is reported rather than stalling the others
+const selfTestTimeout = 30 * time.Second
+
+// selfTestFixture is the document each backend reads
+var selfTestFixture = []byte(`{"name": "jsplit self-test", "items": [{"id": 1, "ok": true}, {"id": 2, "tags": ["a", "b"]}]}`)
+
+// bzip2Fixture is selfTestFixture compressed with bzip2 -9, as there is no bzip2 compressor in the standard library
+var bzip2Fixture = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xe9, 0x17, 0x09, 0xa9, 0x00, 0x00,
+	0x2c, 0x9b, 0x80, 0x50, 0x06, 0x30, 0x10, 0x00, 0x0a, 0x37, 0xbf, 0xde, 0x0a, 0x20, 0x00, 0x54,
+	0x35, 0x4d, 0xa9, 0x93, 0xca, 0x64, 0x69, 0xa1, 0x90, 0x7a, 0x68, 0x25, 0x53, 0xf5, 0x13, 0x41,
+	0xa3, 0xd1, 0x00, 0x34, 0x68, 0xe2, 0xe4, 0x20, 0xa2, 0x74, 0x5e, 0xcc, 0x50, 0xde, 0x72, 0x8f,
+	0xc2, 0x12, 0x33, 0x25, 0x3a, 0xed, 0x02, 0x05, 0x88, 0x4f, 0x4d, 0xe3, 0xb9, 0x3e, 0x9c, 0x03,
+	0x7f, 0xd9, 0x82, 0x70, 0x5a, 0xde, 0x04, 0xf0, 0x88, 0xb1, 0x9d, 0xcf, 0x73, 0x42, 0x5d, 0x1b,
+	0xc2, 0xe9, 0x18, 0x60, 0x5d, 0xc9, 0x14, 0xe1, 0x42, 0x43, 0xa4, 0x5c, 0x26, 0xa4,
+}
+
+// SelfTestOptions configures the checks made by SelfTest
+type SelfTestOptions struct {
+	// Input is a document, e.g. a small object in a bucket, which is also read and validated, checking the backend
+	// and credentials a split of it would use end to end. It isn't read when empty.
+	Input string
+
+	// TempDir is the directory the fixtures read from local files are written to, os.TempDir() when it isn't set
+	TempDir string
+}
+
+// SelfTestResult is the outcome of the check of one backend
+type SelfTestResult struct {
+	// Backend names what was checked, e.g. file, gzip, http or gcs
+	Backend string
+
+	// Status is whether the backend worked, failed or was skipped
+	Status SelfTestStatus
+
+	// Detail is why the backend failed or was skipped
+	Detail string
+
+	// Elapsed is how long the check took
+	Elapsed time.Duration
+}
+
+// SelfTestReport holds the results of SelfTest, one for each backend in the order they were checked
+type SelfTestReport struct {
+	Results []SelfTestResult
+}
+
+// selfTestCheck checks a backend, returning an *errSelfTestSkipped when it can't be checked in this environment
+type selfTestCheck struct {
+	backend string
+	run     func(ctx context.Context) error
+}
+
+// errSelfTestSkipped wraps the reason a backend was skipped
+type errSelfTestSkipped struct {
+	reason string
+}
+
+func (e *errSelfTestSkipped) Error() string {
+	return e.reason
+}
+
+// SelfTest reads a small built-in document through each of the backends input can be read from, local files,
+// each supported compression format and http, checking what is read is the document, and checks the credentials reads
+// from Google Cloud Storage would use, which is skipped when there are none. The readerOpts are those a split would
+// read with, so that missing or misconfigured credentials are found before a long job. Every check is made, and
+// the report says which worked, failed and were skipped.
+func SelfTest(ctx context.Context, opts SelfTestOptions, readerOpts ...AsyncReaderOption) *SelfTestReport {
+	local := func(name string, compress func(w io.Writer) (io.WriteCloser, error)) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			data, err := compressFixture(compress)
+			if err != nil {
+				return err
+			}
+
+			return selfTestLocal(ctx, opts.TempDir, name, data, readerOpts)
+		}
+	}
+
+	checks := []selfTestCheck{
+		{"file", local("selftest.json", nil)},
+		{"gzip", local("selftest.json.gz", func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		})},
+		{"zstd", local("selftest.json.zst", func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		})},
+		{"bzip2", func(ctx context.Context) error {
+			return selfTestLocal(ctx, opts.TempDir, "selftest.json.bz2", bzip2Fixture, readerOpts)
+		}},
+		{"lz4", local("selftest.json.lz4", func(w io.Writer) (io.WriteCloser, error) {
+			return lz4.NewWriter(w), nil
+		})},
+		{"http", func(ctx context.Context) error {
+			return selfTestHTTP(ctx, readerOpts)
+		}},
+		{"gcs", func(ctx context.Context) error {
+			return selfTestGCS(ctx, readerOpts)
+		}},
+	}
+
+	if opts.Input != "" {
+		checks = append(checks, selfTestCheck{"input " + opts.Input, func(ctx context.Context) error {
+			return selfTestInput(ctx, opts.Input, readerOpts)
+		}})
+	}
+
+	report := &SelfTestReport{}
+
+	for _, check := range checks {
+		report.Results = append(report.Results, runSelfTestCheck(ctx, check))
+	}
+
+	return report
+}
+
+// runSelfTestCheck runs a check within selfTestTimeout and records its outcome
+func runSelfTestCheck(ctx context.Context, check selfTestCheck) SelfTestResult {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.run(ctx)
+	result := SelfTestResult{Backend: check.backend, Status: SelfTestOK, Elapsed: time.Since(start)}
+
+	var skipped *errSelfTestSkipped
+
+	switch {
+	case errors.As(err, &skipped):
+		result.Status = SelfTestSkipped
+		result.Detail = skipped.reason
+	case err != nil:
+		result.Status = SelfTestFailed
+		result.Detail = err.Error()
+	}
+
+	return result
+}
+
+// compressFixture returns the fixture compressed by the writer compress returns, or the fixture itself when compress is
+// nil
+func compressFixture(compress func(w io.Writer) (io.WriteCloser, error)) ([]byte, error) {
+	if compress == nil {
+		return selfTestFixture, nil
+	}
+
+	var buf bytes.Buffer
+
+	wr, err := compress(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = wr.Write(selfTestFixture)
+	if err == nil {
+		err = wr.Close()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress the fixture: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// selfTestLocal writes data to a file named name in a new directory of tempDir and reads it back
+func selfTestLocal(ctx context.Context, tempDir, name string, data []byte, readerOpts []AsyncReaderOption) error {
+	dir, err := os.MkdirTemp(tempDir, "jsplit-selftest-")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, name)
+
+	err = os.WriteFile(filename, data, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return selfTestRead(ctx, filename, readerOpts)
+}
+
+// selfTestHTTP serves the fixture from a listener on the loopback interface and reads it back
+func selfTestHTTP(ctx context.Context, readerOpts []AsyncReaderOption) error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "selftest.json", time.Time{}, bytes.NewReader(selfTestFixture))
+		}),
+		ReadHeaderTimeout: selfTestTimeout,
+	}
+
+	go func() {
+		_ = srv.Serve(l)
+	}()
+
+	defer srv.Close()
+
+	return selfTestRead(ctx, "http://"+l.Addr().String()+"/selftest.json", readerOpts)
+}
+
+// selfTestGCS checks the credentials reads from Google Cloud Storage would use, skipping the check when there are none
+func selfTestGCS(ctx context.Context, readerOpts []AsyncReaderOption) error {
+	afr, err := newAsyncReader(1, readerOpts)
+	if err != nil {
+		return err
+	}
+
+	err = cloud.CheckGCSCredentials(ctx, &afr.cloudOpts)
+	if errors.Is(err, cloud.ErrNoGCSCredentials) {
+		return &errSelfTestSkipped{reason: err.Error()}
+	}
+
+	return err
+}
+
+// selfTestInput validates the document at uri
+func selfTestInput(ctx context.Context, uri string, readerOpts []AsyncReaderOption) error {
+	rd, err := AsyncReaderFromFile(uri, 1024*1024, readerOpts...)
+	if err != nil {
+		return err
+	}
+
+	defer rd.Close()
+
+	return Validate(rd.Start(ctx), rd, ValidateOptions{})
+}
+
+// selfTestRead reads uri, checking that it holds the fixture. The compression format of the fixture is always detected,
+// whatever was given with WithCompression, as each check uses a format of its own.
+func selfTestRead(ctx context.Context, uri string, readerOpts []AsyncReaderOption) error {
+	readerOpts = append(readerOpts[:len(readerOpts):len(readerOpts)], WithCompression(CompressionAuto))
+
+	rd, err := AsyncReaderFromFile(uri, 16, readerOpts...)
+	if err != nil {
+		return err
+	}
+
+	defer rd.Close()
+
+	ctx = rd.Start(ctx)
+
+	data, err := io.ReadAll(rd.AsReader(ctx))
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(data, selfTestFixture) {
+		return fmt.Errorf("read %s, expected %s", abbreviate(data), abbreviate(selfTestFixture))
+	}
+
+	return nil
+}
+
+// Failed reports whether any backend failed. Skipped backends aren't failures.
+func (sr *SelfTestReport) Failed() bool {
+	for _, result := range sr.Results {
+		if result.Status == SelfTestFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Print writes a table of the backends, whether each worked and why those which didn't failed or were skipped to w
+func (sr *SelfTestReport) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "backend\tstatus\ttime\tdetail")
+
+	for _, result := range sr.Results {
+		detail := strings.ReplaceAll(result.Detail, "\n", " ")
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Backend, result.Status, result.Elapsed.Round(time.Millisecond),
+			detail)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,73 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTest(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+
+	tempDir := t.TempDir()
+	report := SelfTest(context.Background(), SelfTestOptions{TempDir: tempDir})
+
+	var backends []string
+
+	// every local backend works, and GCS is skipped without credentials rather than failed
+	for _, result := range report.Results {
+		backends = append(backends, result.Backend)
+
+		if result.Backend == "gcs" {
+			require.Equal(t, SelfTestSkipped, result.Status)
+			require.Equal(t, "no Google Cloud Storage credentials were found", result.Detail)
+
+			continue
+		}
+
+		require.Equal(t, SelfTestOK, result.Status, result.Backend+": "+result.Detail)
+	}
+
+	require.Equal(t, []string{"file", "gzip", "zstd", "bzip2", "lz4", "http", "gcs"}, backends)
+	require.False(t, report.Failed())
+	requireEmptyDir(t, tempDir)
+
+	var buf bytes.Buffer
+
+	require.NoError(t, report.Print(&buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 8)
+	require.Regexp(t, `^backend +status +time +detail$`, lines[0])
+	require.Regexp(t, `^gzip +ok +\S+ *$`, lines[2])
+	require.Regexp(t, `^gcs +skipped +\S+ +no Google Cloud Storage credentials were found$`, lines[7])
+}
+
+func TestSelfTestFailures(t *testing.T) {
+	dir := t.TempDir()
+
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`{"a": [1,}`), 0o644))
+
+	// credentials which can't be loaded, and an input which isn't json, fail
+	report := SelfTest(context.Background(), SelfTestOptions{TempDir: dir, Input: invalid},
+		WithGCSCredentialsFile(filepath.Join(dir, "missing.json")))
+	require.True(t, report.Failed())
+
+	results := map[string]SelfTestResult{}
+	for _, result := range report.Results {
+		results[result.Backend] = result
+	}
+
+	require.Equal(t, SelfTestOK, results["file"].Status)
+	require.Equal(t, SelfTestFailed, results["gcs"].Status)
+	require.Contains(t, results["gcs"].Detail, "unable to read GCS credentials")
+	require.Equal(t, SelfTestFailed, results["input "+invalid].Status)
+	require.Contains(t, results["input "+invalid].Detail, "expecting a value")
+}
@@ -0,0 +1,128 @@
+package jsplit
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// ShardingJsonlWriter receives json objects one at a time and distributes them across a fixed number of
+// SplittingJsonlWriters, one per shard, either round-robin or by a hash of one of their fields
+type ShardingJsonlWriter struct {
+	shards    []*SplittingJsonlWriter
+	factories []*BufferedWriterFactory
+	next      int
+
+	// hashField is the field whose value picks the shard of each item, and fallback the shard of the items without it
+	hashField string
+	fallback  int
+
+	// emptyFiles is set when shards which aren't given any items are written to an empty file
+	emptyFiles bool
+}
+
+// NewShardingJsonlWriter returns a *ShardingJsonlWriter writing opts.Shards shards.  Shard i is written to jsonl files
+// named part-[i]_%02d.jsonl in the supplied sink, in the format and with the rollover and compression set in opts.
+func NewShardingJsonlWriter(sink OutputSink, bufferSize int, opts Options) *ShardingJsonlWriter {
+	numShards := opts.Shards
+	shards := make([]*SplittingJsonlWriter, numShards)
+	factories := make([]*BufferedWriterFactory, numShards)
+
+	for i := range shards {
+		factories[i] = NewBufferedWriterFactory(sink, shardName(i), bufferSize, opts)
+		shards[i] = newListWriter(factories[i], opts)
+	}
+
+	return &ShardingJsonlWriter{shards: shards, factories: factories, hashField: opts.HashPartition,
+		fallback: opts.FallbackShard, emptyFiles: opts.format() == FormatNDJSON}
+}
+
+// Add writes the item to the shard picked by the hash of its field when sharding by one, and to the next shard
+// otherwise
+func (swr *ShardingJsonlWriter) Add(item []byte) error {
+	if swr.hashField != "" {
+		return swr.shards[swr.hashShard(item)].Add(item)
+	}
+
+	err := swr.shards[swr.next].Add(item)
+	if err != nil {
+		return err
+	}
+
+	swr.next = (swr.next + 1) % len(swr.shards)
+
+	return nil
+}
+
+// hashShard returns the shard of the item, picked by the FNV-1a hash of the value of its hash field, unquoted if it is
+// a string, so that items with the same value are always written to the same shard. Items which aren't objects, or
+// where the field is missing or null, are written to the fallback shard.
+func (swr *ShardingJsonlWriter) hashShard(item []byte) int {
+	val, _, ok := fieldValue(item, swr.hashField)
+	if !ok {
+		return swr.fallback
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(val))
+
+	return int(h.Sum32() % uint32(len(swr.shards)))
+}
+
+// abandon closes the file being written for each shard after the split has failed, flushing the items written so far
+func (swr *ShardingJsonlWriter) abandon() {
+	for _, shard := range swr.shards {
+		_ = shard.Close()
+	}
+}
+
+// Files returns the number of files which have been created for each shard so far
+func (swr *ShardingJsonlWriter) Files() []int {
+	files := make([]int, len(swr.shards))
+	for i, shard := range swr.shards {
+		files[i] = shard.Files()
+	}
+
+	return files
+}
+
+// report prints the number of files written for each shard
+func (swr *ShardingJsonlWriter) report() {
+	for i, files := range swr.Files() {
+		logger().Info(fmt.Sprintf("%s written to %d files", shardName(i), files), "shard", shardName(i), "files", files)
+	}
+}
+
+// addToManifest adds the files written for each shard to the manifest, along with the names of the schemas describing
+// their items if there are any
+func (swr *ShardingJsonlWriter) addToManifest(m *Manifest, schemas schemaFiles) {
+	for i, shard := range swr.shards {
+		mk := m.addKey(shardName(i), swr.factories[i], shard)
+		if mk != nil {
+			schemas.setIn(mk)
+		}
+	}
+}
+
+// shardName returns the name of the i'th shard, used as the key of its files
+func shardName(i int) string {
+	return fmt.Sprintf("part-%03d", i)
+}
+
+// Close closes all the shards making sure all the data has been flushed
+func (swr *ShardingJsonlWriter) Close() error {
+	for _, shard := range swr.shards {
+		if swr.emptyFiles {
+			err := shard.ensureFile()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := shard.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardingJsonlWriter(t *testing.T) {
+	const numShards = 3
+
+	tempDir := t.TempDir()
+	wr := NewShardingJsonlWriter(NewDirSink(context.Background(), tempDir), 1024, Options{Shards: numShards})
+
+	expected := make([][]string, numShards)
+
+	for i := 0; i < 10; i++ {
+		item := fmt.Sprintf(`{"idx":%d}`, i)
+		require.NoError(t, wr.Add([]byte(item)))
+
+		expected[i%numShards] = append(expected[i%numShards], item)
+	}
+
+	require.NoError(t, wr.Close())
+
+	for i := 0; i < numShards; i++ {
+		requireContents(t, filepath.Join(tempDir, fmt.Sprintf("part-%03d_00.jsonl", i)), strings.Join(expected[i], "\n"))
+	}
+}
+
+func TestShardingJsonlWriterHashPartition(t *testing.T) {
+	const numShards = 4
+
+	shardsOf := func(opts Options, items []string) [][]string {
+		tempDir := t.TempDir()
+		wr := NewShardingJsonlWriter(NewDirSink(context.Background(), tempDir), 1024, opts)
+
+		for _, item := range items {
+			require.NoError(t, wr.Add([]byte(item)))
+		}
+
+		require.NoError(t, wr.Close())
+
+		shards := make([][]string, numShards)
+		for i := range shards {
+			data, err := os.ReadFile(filepath.Join(tempDir, fmt.Sprintf("part-%03d_00.jsonl", i)))
+			if err == nil {
+				shards[i] = strings.Split(strings.TrimSpace(string(data)), "\n")
+			}
+		}
+
+		return shards
+	}
+
+	var items []string
+	for i := 0; i < 1000; i++ {
+		items = append(items, fmt.Sprintf(`{"user":"u%d","n":%d}`, i%200, i))
+	}
+
+	opts := Options{Shards: numShards, HashPartition: "user"}
+	shards := shardsOf(opts, items)
+
+	// every item with the same value is in the same shard, and the values are spread roughly evenly
+	seen := make(map[string]int)
+
+	for i, shard := range shards {
+		require.InDelta(t, len(items)/numShards, len(shard), float64(len(items)/numShards)/4)
+
+		for _, item := range shard {
+			user, _, ok := fieldValue([]byte(item), "user")
+			require.True(t, ok)
+
+			prev, ok := seen[user]
+			require.True(t, !ok || prev == i, user)
+			seen[user] = i
+		}
+	}
+
+	// the same items are always written to the same shards
+	require.Equal(t, shards, shardsOf(opts, items))
+
+	// and items without a value for the field are written to the fallback shard
+	opts.FallbackShard = 2
+	shards = shardsOf(opts, []string{`{"n":1}`, `{"user":null}`, `[1]`, `"u1"`})
+	require.Equal(t, []string{`{"n":1}`, `{"user":null}`, `[1]`, `"u1"`}, shards[2])
+
+	// the lists of an object aren't sharded by the field, so the split fails rather than ignoring it
+	doc := `{"users": [` + strings.Join(items[:10], ",") + `]}`
+	outputDir := filepath.Join(t.TempDir(), "object")
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 16), outputDir,
+		Options{Shards: numShards, HashPartition: "user"})
+	require.EqualError(t, err, "hash partition user can't be used when the document is an object, only with an array "+
+		"or a stream of json values")
+	require.NoFileExists(t, filepath.Join(outputDir, "users_00.jsonl"))
+
+	require.ErrorContains(t, Options{HashPartition: "user"}.validate(), "hash partitions need shards")
+	require.ErrorContains(t, Options{Shards: 2, FallbackShard: 2}.validate(), "fallback shard must be from 0 to 1")
+	require.ErrorContains(t, Options{FallbackShard: -1}.validate(), "fallback shard must be from 0 to 0")
+}
@@ -0,0 +1,67 @@
+package jsplit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseTimestamp parses a timestamp as the values of Options.PartitionByDate and Options.SinceField are parsed, e.g.
+// 2024-05-01, 2024-05-01T12:30:00Z or 2024-05-01 12:30:00, or a number of seconds or milliseconds since the Unix
+// epoch. Timestamps without a time zone are in UTC.
+func ParseTimestamp(value string) (time.Time, error) {
+	ts, ok := parseTimestamp(strings.TrimSpace(value), true)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q, expected a date such as 2024-05-01, a time such as "+
+			"2024-05-01T12:30:00Z or a number of seconds or milliseconds since the epoch", value)
+	}
+
+	return ts, nil
+}
+
+// sinceCounts counts the items of a list which were dropped by Options.Since, and those which had no timestamp
+type sinceCounts struct {
+	before  int64
+	untimed int64
+}
+
+// keepSince reports whether the item is kept by Options.Since, as its Options.SinceField holds a timestamp at or after
+// it, or it has no timestamp which can be parsed and Options.KeepUntimed is set, counting those which aren't in counts
+func keepSince(item []byte, opts Options, counts *sinceCounts) bool {
+	value, isString, ok := fieldValue(item, opts.SinceField)
+	if ok {
+		var ts time.Time
+
+		ts, ok = parseTimestamp(value, isString)
+		if ok && ts.Before(opts.Since) {
+			counts.before++
+			return false
+		}
+	}
+
+	if !ok {
+		counts.untimed++
+		return opts.KeepUntimed
+	}
+
+	return true
+}
+
+// reportSince prints the number of items of the list with the given key which were before Options.Since, and which
+// had no timestamp
+func reportSince(key string, opts Options, counts sinceCounts) {
+	if opts.SinceField == "" {
+		return
+	}
+
+	untimed := "dropped"
+	if opts.KeepUntimed {
+		untimed = "kept"
+	}
+
+	since := opts.Since.UTC().Format(time.RFC3339)
+
+	logger().Info(fmt.Sprintf("%d items of %s were before %s and were dropped, %d without a timestamp in %s were %s",
+		counts.before, key, since, counts.untimed, opts.SinceField, untimed), "key", key, "since", since,
+		"before", counts.before, "untimed", counts.untimed)
+}
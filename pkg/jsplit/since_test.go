@@ -0,0 +1,77 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSinceTimestamp(t *testing.T) {
+	tests := map[string]time.Time{
+		"2024-05-01":                time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC),
+		" 2024-05-01T12:30:00Z ":    time.Date(2024, time.May, 1, 12, 30, 0, 0, time.UTC),
+		"2024-05-01T12:30:00+02:00": time.Date(2024, time.May, 1, 10, 30, 0, 0, time.UTC),
+		"1714566600":                time.Date(2024, time.May, 1, 12, 30, 0, 0, time.UTC),
+		"1714566600000":             time.Date(2024, time.May, 1, 12, 30, 0, 0, time.UTC),
+	}
+
+	for value, expected := range tests {
+		ts, err := ParseTimestamp(value)
+		require.NoError(t, err, value)
+		require.True(t, expected.Equal(ts), "%s parsed as %s", value, ts)
+	}
+
+	_, err := ParseTimestamp("yesterday")
+	require.EqualError(t, err, `invalid timestamp "yesterday", expected a date such as 2024-05-01, a time such as `+
+		`2024-05-01T12:30:00Z or a number of seconds or milliseconds since the epoch`)
+}
+
+func TestSplitStreamSince(t *testing.T) {
+	const doc = `{"users": [{"id": 1, "updated_at": "2024-04-30T23:59:59Z"}, {"id": 2, "updated_at": "2024-05-01"}, ` +
+		`{"id": 3, "updated_at": 1714608000}, {"id": 4}, {"id": 5, "updated_at": "soon"}, ` +
+		`{"id": 6, "updated_at": "2024-06-01", "active": true}], "n": 3}`
+
+	since, err := ParseTimestamp("2024-05-01")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+
+	SetLogger(slog.New(NewMessageHandler(&buf, slog.LevelInfo)))
+	defer SetLogger(nil)
+
+	// items at or after the cutoff are kept, whether their timestamps are strings or numbers
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{SinceField: "updated_at", Since: since}))
+
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":2,"updated_at":"2024-05-01"}`+"\n"+
+		`{"id":3,"updated_at":1714608000}`+"\n"+`{"id":6,"updated_at":"2024-06-01","active":true}`)
+
+	manifest := readManifest(t, tempDir)
+	require.Equal(t, int64(3), manifest.Keys[0].Records)
+	require.Equal(t, int64(3), manifest.Keys[0].Filtered)
+	require.Contains(t, buf.String(), "1 items of users were before 2024-05-01T00:00:00Z and were dropped, "+
+		"2 without a timestamp in updated_at were dropped")
+
+	// items without a timestamp can be kept, and the items kept must match the filter too
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir,
+		Options{SinceField: "updated_at", Since: since, KeepUntimed: true, Filter: `id != 3`}))
+
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":2,"updated_at":"2024-05-01"}`+"\n"+
+		`{"id":4}`+"\n"+`{"id":5,"updated_at":"soon"}`+"\n"+`{"id":6,"updated_at":"2024-06-01","active":true}`)
+	require.Equal(t, int64(2), readManifest(t, tempDir).Keys[0].Filtered)
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(), Options{Since: since})
+	require.EqualError(t, err, "a since cutoff needs a since field to compare with")
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(),
+		Options{SinceField: "updated_at", Since: since, CheckpointInterval: 10})
+	require.EqualError(t, err, "checkpoints can't be used with a since filter")
+}
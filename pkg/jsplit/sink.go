@@ -0,0 +1,579 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+)
+
+// OutputSink is the destination of the files written by a split. Each file is opened, written and closed before Split
+// returns, and when Options.Concurrency is set the files of different keys are written concurrently, so OpenKey must
+// be safe to call from multiple goroutines.
+type OutputSink interface {
+	// OpenKey creates the file with the given name, such as list_00.jsonl, root.json or manifest.json, returning a
+	// writer for its contents. The file is complete once the writer has been closed without error. The files of date
+	// partitions are named after the directory of their partition, e.g. dt=2024-01-01/root_00.jsonl.
+	OpenKey(name string) (io.WriteCloser, error)
+}
+
+// SinkFunc is an OutputSink calling a function to create each file, so that the output of a split can be routed
+// anywhere, such as to in-memory buffers in tests, without writing a type. As with OpenKey the function may be called
+// from multiple goroutines.
+type SinkFunc func(name string) (io.WriteCloser, error)
+
+// OpenKey calls f(name)
+func (f SinkFunc) OpenKey(name string) (io.WriteCloser, error) {
+	return f(name)
+}
+
+// NewDirSink returns an OutputSink creating files in dir, which must already exist. dir can be a cloud storage URI
+// such as gs://bucket/prefix in which case each file is uploaded as an object under the prefix. Uploads which haven't
+// been closed are aborted if ctx is cancelled, or if the split writing to the sink fails.
+func NewDirSink(ctx context.Context, dir string) OutputSink {
+	return &dirSink{ctx: ctx, dir: dir}
+}
+
+// dirSink is an OutputSink writing files to a local directory or cloud storage prefix
+type dirSink struct {
+	ctx      context.Context
+	dir      string
+	existing ExistingOutput   // what is done with files left in a local directory, which are replaced when it isn't set
+	created  *fileSet         // the files created in a local directory, which can always be replaced
+	partSize int64            // the size of the parts files are uploaded to Google Cloud Storage in, if they are
+	fileMode os.FileMode      // the permissions of the local files created, the default when it isn't set
+	dirMode  os.FileMode      // the permissions of the local directories of partitions created, as for fileMode
+	atomic   bool             // whether local files are written under a temporary name and renamed once closed
+	uploaded func(uri string) // called with each object whose upload completed, see Options.Uploaded
+}
+
+// OpenKey creates the file in the directory, refusing names which would place it anywhere else. The directory of a
+// partition is created along with its first file.
+func (ds *dirSink) OpenKey(name string) (io.WriteCloser, error) {
+	err := checkFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := ds.path(name)
+
+	if ds.partSize > 0 && strings.HasPrefix(filename, "gs://") {
+		w, err := cloud.NewComposedWriter(ds.ctx, filename, ds.partSize)
+		if err != nil {
+			return nil, err
+		}
+
+		return ds.upload(filename, w), nil
+	}
+
+	if cloud.IsCloudURI(filename) {
+		w, err := cloud.NewWriter(ds.ctx, filename)
+		if err != nil {
+			return nil, err
+		}
+
+		return ds.upload(filename, w), nil
+	}
+
+	filename, err = ds.localPath(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	exclusive := ds.existing != "" && ds.existing != ExistingOutputOverwrite && !ds.created.has(name)
+
+	if exclusive {
+		flags |= os.O_EXCL
+	}
+
+	created := filename
+	if ds.atomic {
+		created = atomicTempPath(filename)
+
+		if _, err := os.Lstat(filename); exclusive && err == nil {
+			return nil, fmt.Errorf("%w: %s", ErrOutputExists, filename)
+		}
+	}
+
+	f, err := os.OpenFile(created, flags, os.ModePerm)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%w: %s", ErrOutputExists, created)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if ds.fileMode != 0 {
+		err = f.Chmod(ds.fileMode)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+
+	if ds.created != nil {
+		ds.created.add(name)
+	}
+
+	if ds.atomic {
+		return &atomicFile{File: f, ctx: ds.ctx, filename: filename}, nil
+	}
+
+	return f, nil
+}
+
+// atomicTempPath returns the temporary name a file is written under before being renamed to filename, which is
+// hidden in its directory so that tools polling it pass over the file, e.g. .users_00.jsonl.tmp
+func atomicTempPath(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+}
+
+// atomicFile is a local file written under a temporary name, which is renamed to its name once it has been closed
+// without error, see Options.AtomicFiles. A file closed once the split's context has been cancelled, as it is when
+// the split fails, is removed instead, so that no part of it is left under its name. When the file is held, closing
+// it leaves it under its temporary name for the writers above it to finish, see atomicWriteCloser.
+type atomicFile struct {
+	*os.File
+	ctx      context.Context
+	filename string
+	held     bool
+}
+
+// Close closes the file, renaming it to its name or removing it unless it is held
+func (af *atomicFile) Close() error {
+	err := af.File.Close()
+	if af.held {
+		return err
+	}
+
+	return af.finish(err)
+}
+
+// finish renames the closed file to its name, or removes it if err, the error closing it, is set or the split's
+// context has been cancelled, returning err or the error renaming it
+func (af *atomicFile) finish(err error) error {
+	if err == nil {
+		err = af.ctx.Err()
+	}
+
+	if err == nil {
+		err = os.Rename(af.File.Name(), af.filename)
+	}
+
+	if err != nil {
+		_ = os.Remove(af.File.Name())
+	}
+
+	return err
+}
+
+// upload returns w, the writer uploading the object at uri, reporting the object to ds.uploaded once its upload has
+// completed when it is set. Uploads which are closed once ds.ctx has been cancelled are aborted by w rather than
+// finished, so an interrupted split leaves no object holding part of a file.
+func (ds *dirSink) upload(uri string, w io.WriteCloser) io.WriteCloser {
+	if ds.uploaded == nil {
+		return w
+	}
+
+	return &uploadWriter{WriteCloser: w, uri: uri, uploaded: ds.uploaded}
+}
+
+// uploadWriter reports the object it uploads once the upload has completed
+type uploadWriter struct {
+	io.WriteCloser
+	uri      string
+	uploaded func(uri string)
+}
+
+// Close finishes the upload, reporting the object if it was uploaded
+func (uw *uploadWriter) Close() error {
+	err := uw.WriteCloser.Close()
+	if err == nil {
+		uw.uploaded(uw.uri)
+	}
+
+	return err
+}
+
+// appendKey opens the local file with the given name for appending, after truncating it to size bytes, so a split
+// which was interrupted can carry on writing it from its last checkpoint
+func (ds *dirSink) appendKey(name string, size int64) (io.WriteCloser, error) {
+	if cloud.IsCloudURI(ds.dir) {
+		return nil, fmt.Errorf("files can't be appended to in %s", ds.dir)
+	}
+
+	err := checkFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	filename, err := ds.localPath(name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Truncate(size)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// localPath returns the path of the file with the given name in the local directory, which is either directly within
+// it or within the directory of a partition, creating the partition's directory first if create is set
+func (ds *dirSink) localPath(name string, create bool) (string, error) {
+	dir, file, ok := strings.Cut(name, "/")
+	if !ok {
+		return containedPath(ds.dir, name)
+	}
+
+	dir, err := containedPath(ds.dir, dir)
+	if err != nil {
+		return "", err
+	}
+
+	if create {
+		err = mkdirAll(dir, ds.dirMode)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return containedPath(dir, file)
+}
+
+// path returns the full path of the file with the given name in the directory
+func (ds *dirSink) path(name string) string {
+	if cloud.IsCloudURI(ds.dir) {
+		return strings.TrimSuffix(ds.dir, "/") + "/" + name
+	}
+
+	return filepath.Join(ds.dir, name)
+}
+
+// ErrNoOutputDir is returned when a local output directory doesn't exist and Options.RequireOutputDir is set
+var ErrNoOutputDir = errors.New("output directory does not exist")
+
+// createOutputDir makes sure that the local directory dir exists before a split writes anything to it, creating it
+// along with any missing parents unless opts.RequireOutputDir is set, so that a split can't fail part way through for
+// want of it. Cloud storage prefixes don't need creating, and nothing is created for a dry run.
+func createOutputDir(dir string, opts Options) error {
+	if cloud.IsCloudURI(dir) || opts.DryRun {
+		return nil
+	}
+
+	fi, err := os.Stat(dir)
+
+	switch {
+	case err == nil && !fi.IsDir():
+		return fmt.Errorf("output path %s is not a directory", dir)
+	case err == nil:
+		return nil
+	case !os.IsNotExist(err):
+		return err
+	case opts.RequireOutputDir:
+		return fmt.Errorf("%w: %s", ErrNoOutputDir, dir)
+	}
+
+	return mkdirAll(dir, opts.DirMode)
+}
+
+// mkdirAll creates the local directory dir along with any missing parents, as os.MkdirAll does, giving dir the
+// permissions of mode regardless of the umask if it is created and mode is set
+func mkdirAll(dir string, mode os.FileMode) error {
+	_, err := os.Stat(dir)
+	if err == nil || mode == 0 {
+		return os.MkdirAll(dir, 0o755)
+	}
+
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(dir, mode)
+}
+
+// mkdir creates the local directory dir, as os.Mkdir does, giving it the permissions of mode regardless of the umask
+// when mode is set
+func mkdir(dir string, mode os.FileMode) error {
+	err := os.Mkdir(dir, 0o755)
+	if err != nil || mode == 0 {
+		return err
+	}
+
+	return os.Chmod(dir, mode)
+}
+
+// localDirExists reports whether dir is a local directory which exists
+func localDirExists(dir string) bool {
+	if cloud.IsCloudURI(dir) {
+		return false
+	}
+
+	fi, err := os.Stat(dir)
+
+	return err == nil && fi.IsDir()
+}
+
+// removeFailedOutputDir removes the local output directory dir after a split which failed with err, when it was created
+// for the split, and either the input held no document or its keys didn't match Options.RequireKeys. Empty input
+// leaves nothing behind, and neither do the files written for other keys before a mismatch was found, which have no
+// manifest. err is returned.
+func removeFailedOutputDir(dir string, existed bool, err error) error {
+	if existed || cloud.IsCloudURI(dir) {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, ErrEmptyInput):
+		// only removes the directory if nothing was written to it
+		_ = os.Remove(dir)
+	case errors.Is(err, ErrKeysMismatch):
+		_ = os.RemoveAll(dir)
+	}
+
+	return err
+}
+
+// fileKeyEscaper percent encodes the characters of a key which can't be used in a file name
+var fileKeyEscaper = strings.NewReplacer("%", "%25", "/", "%2F", `\`, "%5C")
+
+// fileKey escapes a key of the document for use in the names of the files written for it. Path separators, and the %
+// used to escape them, are percent encoded, so that a key can't name a file outside of the output directory and
+// different keys are always given different names.
+func fileKey(key string) string {
+	return fileKeyEscaper.Replace(key)
+}
+
+// checkFileName returns an error if name isn't a plain file name, so that it can't refer to a file outside of the
+// directory it is joined to
+func checkFileName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid output file name %q", name)
+	}
+
+	return nil
+}
+
+// checkFilePath returns an error if name isn't a plain file name, or one within the directory of a partition such as
+// dt=2024-01-01/root_00.jsonl
+func checkFilePath(name string) error {
+	dir, file, ok := strings.Cut(name, "/")
+	if ok && (checkFileName(dir) != nil || checkFileName(file) != nil) {
+		return fmt.Errorf("invalid output file name %q", name)
+	}
+
+	if !ok {
+		return checkFileName(name)
+	}
+
+	return nil
+}
+
+// containedPath returns the path of the file with the given name in the local directory dir, or an error if the
+// cleaned path doesn't resolve to a file directly within it
+func containedPath(dir, name string) (string, error) {
+	dir = filepath.Clean(dir)
+	filename := filepath.Clean(filepath.Join(dir, name))
+
+	// Join drops a relative dir of ., leaving just the name
+	prefix := ""
+	if dir != "." {
+		prefix = strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+	}
+
+	rel := strings.TrimPrefix(filename, prefix)
+	if !strings.HasPrefix(filename, prefix) || rel == "" || rel == ".." || strings.ContainsRune(rel, filepath.Separator) {
+		return "", fmt.Errorf("output file %q would be written outside of %s", name, dir)
+	}
+
+	return filename, nil
+}
+
+// String returns the directory
+func (ds *dirSink) String() string {
+	return ds.dir
+}
+
+// withContext returns a copy of the sink whose uploads are aborted when ctx is cancelled
+func (ds *dirSink) withContext(ctx context.Context) OutputSink {
+	cp := *ds
+	cp.ctx = ctx
+
+	return &cp
+}
+
+// withExistingOutput returns a copy of the sink doing what policy says with the files left in a local directory
+func (ds *dirSink) withExistingOutput(policy ExistingOutput) OutputSink {
+	cp := *ds
+	cp.existing, cp.created = policy, newFileSet()
+
+	return &cp
+}
+
+// withComposePartSize returns a copy of the sink uploading files to Google Cloud Storage in parts of size bytes
+func (ds *dirSink) withComposePartSize(size int64) OutputSink {
+	cp := *ds
+	cp.partSize = size
+
+	return &cp
+}
+
+// withModes returns a copy of the sink giving the local files and directories it creates the permissions of fileMode
+// and dirMode, when they are set
+func (ds *dirSink) withModes(fileMode, dirMode os.FileMode) OutputSink {
+	cp := *ds
+	cp.fileMode, cp.dirMode = fileMode, dirMode
+
+	return &cp
+}
+
+// withAtomicFiles returns a copy of the sink writing local files under a temporary name, renaming each once closed
+func (ds *dirSink) withAtomicFiles() OutputSink {
+	cp := *ds
+	cp.atomic = true
+
+	return &cp
+}
+
+// withUploaded returns a copy of the sink calling uploaded with each object whose upload completed
+func (ds *dirSink) withUploaded(uploaded func(uri string)) OutputSink {
+	cp := *ds
+	cp.uploaded = uploaded
+
+	return &cp
+}
+
+// dryRunSink counts and discards the files which would be written to the wrapped sink
+type dryRunSink struct {
+	OutputSink
+}
+
+// OpenKey returns a writer discarding the file's contents
+func (drs dryRunSink) OpenKey(string) (io.WriteCloser, error) {
+	return discardWriteCloser{}, nil
+}
+
+// appendKey returns a writer discarding what would be appended to the file
+func (drs dryRunSink) appendKey(string, int64) (io.WriteCloser, error) {
+	return discardWriteCloser{}, nil
+}
+
+// path returns the full path the file would be written to
+func (drs dryRunSink) path(name string) string {
+	return sinkPath(drs.OutputSink, name)
+}
+
+// String describes the wrapped sink
+func (drs dryRunSink) String() string {
+	return sinkLocation(drs.OutputSink)
+}
+
+// discardWriteCloser discards the data written to it, standing in for files during a dry run
+type discardWriteCloser struct{}
+
+// Write discards p
+func (discardWriteCloser) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Close does nothing
+func (discardWriteCloser) Close() error {
+	return nil
+}
+
+// bindSink returns the sink a split writes to, whose uploads are aborted when ctx is cancelled, which only replaces
+// files left in a local directory as opts.ExistingOutput allows, which uploads files to Google Cloud Storage in parts
+// when opts.GCSComposePartSize is set, which creates local files and directories with opts.FileMode and opts.DirMode,
+// which renames local files into place once written when opts.AtomicFiles is set, which reports the objects it uploads
+// to opts.Uploaded, and which discards everything written to it when opts.DryRun is set
+func bindSink(ctx context.Context, sink OutputSink, opts Options) OutputSink {
+	if bs, ok := sink.(interface {
+		withContext(ctx context.Context) OutputSink
+	}); ok {
+		sink = bs.withContext(ctx)
+	}
+
+	if es, ok := sink.(interface {
+		withExistingOutput(policy ExistingOutput) OutputSink
+	}); ok {
+		sink = es.withExistingOutput(opts.sinkExistingOutput())
+	}
+
+	if cs, ok := sink.(interface {
+		withComposePartSize(size int64) OutputSink
+	}); ok && opts.GCSComposePartSize > 0 {
+		sink = cs.withComposePartSize(opts.GCSComposePartSize)
+	}
+
+	if ms, ok := sink.(interface {
+		withModes(fileMode, dirMode os.FileMode) OutputSink
+	}); ok && (opts.FileMode != 0 || opts.DirMode != 0) {
+		sink = ms.withModes(opts.FileMode, opts.DirMode)
+	}
+
+	if as, ok := sink.(interface{ withAtomicFiles() OutputSink }); ok && opts.AtomicFiles {
+		sink = as.withAtomicFiles()
+	}
+
+	if us, ok := sink.(interface {
+		withUploaded(uploaded func(uri string)) OutputSink
+	}); ok && opts.Uploaded != nil {
+		sink = us.withUploaded(opts.Uploaded)
+	}
+
+	if opts.DryRun {
+		return dryRunSink{sink}
+	}
+
+	return sink
+}
+
+// sinkPath returns the full path of the file with the given name in the sink for messages, which is just the name for
+// sinks which don't write to a directory
+func sinkPath(sink OutputSink, name string) string {
+	if ps, ok := sink.(interface{ path(name string) string }); ok {
+		return ps.path(name)
+	}
+
+	return name
+}
+
+// sinkLocation describes where the sink writes files for messages
+func sinkLocation(sink OutputSink) string {
+	if s, ok := sink.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return "the output sink"
+}
+
+// writeFile writes data to the file with the given name in the sink, returning the file's full path
+func writeFile(sink OutputSink, name string, data []byte) (string, error) {
+	w, err := sink.OpenKey(name)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		_ = w.Close()
+		return "", err
+	}
+
+	return sinkPath(sink, name), w.Close()
+}
@@ -0,0 +1,540 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memSink struct {
+	mu    sync.Mutex
+	files map[string]*BufWriteCloser
+}
+
+func newMemSink() *memSink {
+	return &memSink{files: make(map[string]*BufWriteCloser)}
+}
+
+func (ms *memSink) OpenKey(name string) (io.WriteCloser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	buf := NewBufWriteCloser()
+	ms.files[name] = buf
+
+	return buf, nil
+}
+
+func (ms *memSink) contents(name string) string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	buf, ok := ms.files[name]
+	if !ok {
+		return "<missing>"
+	}
+
+	return buf.String()
+}
+
+func TestSplitToSink(t *testing.T) {
+	doc := `{"key": "value", "list": [{"idx": 0}, {"idx": 1}], "other": [{"idx": 2}]}`
+
+	rd, err := AsyncReaderFromReader(strings.NewReader(doc), 16)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	sink := newMemSink()
+	require.NoError(t, Split(context.Background(), rd, sink, Options{Concurrency: 2}))
+
+	require.Len(t, sink.files, 4)
+	require.Equal(t, "{\"idx\":0}\n{\"idx\":1}", sink.contents("list_00.jsonl"))
+	require.Equal(t, `{"idx":2}`, sink.contents("other_00.jsonl"))
+	require.Equal(t, "{\n\t\"key\":\"value\"\n}", sink.contents("root.json"))
+
+	var m Manifest
+	require.NoError(t, json.Unmarshal([]byte(sink.contents(ManifestFilename)), &m))
+	require.Len(t, m.Keys, 2)
+	require.Equal(t, "root.json", m.Root.Name)
+}
+
+func TestSplitToSinkDryRun(t *testing.T) {
+	rd, err := AsyncReaderFromReader(strings.NewReader(`{"key": "value", "list": [{"idx": 0}]}`), 16)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	sink := newMemSink()
+	require.NoError(t, Split(context.Background(), rd, sink, Options{DryRun: true, InferSchema: true}))
+	require.Empty(t, sink.files)
+}
+
+func TestSinkPath(t *testing.T) {
+	sink := NewDirSink(context.Background(), "gs://bucket/prefix/")
+	require.Equal(t, "gs://bucket/prefix/list_00.jsonl", sinkPath(sink, "list_00.jsonl"))
+
+	dryRun := bindSink(context.Background(), sink, Options{DryRun: true})
+	require.Equal(t, "gs://bucket/prefix/list_00.jsonl", sinkPath(dryRun, "list_00.jsonl"))
+	require.Equal(t, "gs://bucket/prefix/", sinkLocation(dryRunSink{sink}))
+
+	require.Equal(t, "list_00.jsonl", sinkPath(newMemSink(), "list_00.jsonl"))
+	require.Equal(t, "the output sink", sinkLocation(newMemSink()))
+}
+
+func TestSinkComposePartSize(t *testing.T) {
+	ctx := context.Background()
+	sink := NewDirSink(ctx, "gs://bucket/prefix/")
+
+	// files are only uploaded in parts when a part size is set, which is kept by the copies made of the sink
+	require.Zero(t, bindSink(ctx, sink, Options{}).(*dirSink).partSize)
+	require.Equal(t, int64(1024), bindSink(ctx, sink, Options{GCSComposePartSize: 1024}).(*dirSink).partSize)
+
+	bound := bindSink(ctx, sink, Options{GCSComposePartSize: 1024, ExistingOutput: ExistingOutputOverwrite})
+	require.Equal(t, int64(1024), bound.(*dirSink).withContext(ctx).(*dirSink).partSize)
+
+	err := SplitStream(ctx, NewTestByteStream([]byte(`{"a": [1]}`), 4), t.TempDir(), Options{GCSComposePartSize: -1})
+	require.EqualError(t, err, "GCS compose part size must not be negative, got -1")
+}
+
+func TestSplitUploadsCancelled(t *testing.T) {
+	dir := t.TempDir()
+
+	var sb strings.Builder
+
+	sb.WriteString(`{"list": [`)
+
+	for i := 0; i < 20000; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		fmt.Fprintf(&sb, `{"idx": %d}`, i)
+	}
+
+	sb.WriteString(`]}`)
+
+	// the split is interrupted while it uploads its third file
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	summary := NewRunSummary(nil, "file://"+filepath.ToSlash(dir))
+	track := summary.TrackUploads()
+
+	var uploads int
+
+	opts := Options{MaxFileBytes: 10000, Uploaded: func(uri string) {
+		track(uri)
+
+		uploads++
+		if uploads == 2 {
+			cancel()
+		}
+	}}
+
+	rd, err := AsyncReaderFromReader(strings.NewReader(sb.String()), 1024)
+	require.NoError(t, err)
+
+	err = Split(ctx, rd, NewDirSink(ctx, summary.Output), opts)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// the objects uploaded are recorded, and the upload in progress was aborted rather than leaving part of a file
+	require.Equal(t, []string{summary.Output + "/list_00.jsonl", summary.Output + "/list_01.jsonl"}, summary.Uploaded)
+
+	var objects []string
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".attrs") {
+			objects = append(objects, entry.Name())
+		}
+	}
+
+	require.Equal(t, []string{"list_00.jsonl", "list_01.jsonl"}, objects)
+}
+
+func TestFileKey(t *testing.T) {
+	require.Equal(t, "list", fileKey("list"))
+	require.Equal(t, "..%2F..%2Fetc%2Fpasswd", fileKey("../../etc/passwd"))
+	require.Equal(t, "..%5C..%5Cwindows", fileKey(`..\..\windows`))
+
+	// escaping the escape character keeps names distinct
+	require.NotEqual(t, fileKey("a/b"), fileKey("a%2Fb"))
+}
+
+func TestContainedPath(t *testing.T) {
+	dir := filepath.Join("out", "dir")
+
+	filename, err := containedPath(dir, "list_00.jsonl")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "list_00.jsonl"), filename)
+
+	filename, err = containedPath(".", "list_00.jsonl")
+	require.NoError(t, err)
+	require.Equal(t, "list_00.jsonl", filename)
+
+	filename, err = containedPath(string(filepath.Separator), "list_00.jsonl")
+	require.NoError(t, err)
+	require.Equal(t, string(filepath.Separator)+"list_00.jsonl", filename)
+
+	for _, name := range []string{"", ".", "..", "../list_00.jsonl", "sub/list_00.jsonl", "a/../../list_00.jsonl"} {
+		_, err = containedPath(dir, name)
+		require.Error(t, err, name)
+	}
+}
+
+func TestDirSinkRejectsNamesOutsideDirectory(t *testing.T) {
+	tempDir := t.TempDir()
+	sink := NewDirSink(context.Background(), filepath.Join(tempDir, "out"))
+
+	for _, name := range []string{"..", "../escaped.jsonl", "sub/../../escaped.jsonl", `..\escaped.jsonl`, ""} {
+		_, err := sink.OpenKey(name)
+		require.Error(t, err, name)
+	}
+
+	_, err := os.Stat(filepath.Join(tempDir, "escaped.jsonl"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDirSinkPartitionDirectories(t *testing.T) {
+	outputDir := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, os.Mkdir(outputDir, 0o755))
+
+	// the directory of a partition is created with its first file, but nothing can be nested any deeper
+	sink := NewDirSink(context.Background(), outputDir)
+
+	w, err := sink.OpenKey("dt=2024-01-01/root_00.jsonl")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("{}"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	requireContents(t, filepath.Join(outputDir, "dt=2024-01-01", "root_00.jsonl"), "{}")
+
+	for _, name := range []string{"a/b/c.jsonl", "../root_00.jsonl", "dt=x/..", "/root_00.jsonl", "dt=x/"} {
+		_, err = sink.OpenKey(name)
+		require.Error(t, err, name)
+	}
+}
+
+func TestSplitStreamAdversarialKeys(t *testing.T) {
+	tempDir := t.TempDir()
+	outputDir := filepath.Join(tempDir, "out")
+	require.NoError(t, os.Mkdir(outputDir, 0o755))
+
+	keys := []string{"../../escaped", "/etc/passwd", `..\\..\\escaped`, "..", ".", "a/../../escaped", `..\/escaped`, "%2F"}
+
+	doc := "{"
+	for i, key := range keys {
+		if i > 0 {
+			doc += ", "
+		}
+
+		doc += `"` + key + `": [{"idx": 0}]`
+	}
+	doc += "}"
+
+	bs := NewTestByteStream([]byte(doc), 16)
+	require.NoError(t, SplitStream(context.Background(), bs, outputDir, Options{InferSchema: true}))
+
+	// nothing is written outside of the output directory, and everything in it is a file
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	entries, err = os.ReadDir(outputDir)
+	require.NoError(t, err)
+
+	for _, entry := range entries {
+		require.False(t, entry.IsDir(), entry.Name())
+	}
+
+	// every key gets its own list file and schema, plus root.json and the manifest
+	require.Len(t, entries, 2*len(keys)+2)
+
+	// the manifest records the keys as they were in the document
+	m := readManifest(t, outputDir)
+	require.Len(t, m.Keys, len(keys))
+
+	for i, key := range keys {
+		require.Equal(t, key, m.Keys[i].Key)
+		require.Equal(t, fileKey(key)+"_00.jsonl", m.Keys[i].Files[0].Name)
+		requireContents(t, filepath.Join(outputDir, m.Keys[i].Files[0].Name), `{"idx":0}`)
+	}
+}
+
+func TestCreateOutputDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+
+	// missing parents are created too, while directories which exist are left as they are
+	require.NoError(t, createOutputDir(dir, Options{}))
+	require.DirExists(t, dir)
+	require.NoError(t, createOutputDir(dir, Options{RequireOutputDir: true}))
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	err := createOutputDir(missing, Options{RequireOutputDir: true})
+	require.ErrorIs(t, err, ErrNoOutputDir)
+	require.EqualError(t, err, "output directory does not exist: "+missing)
+	require.NoDirExists(t, missing)
+
+	require.NoError(t, createOutputDir(missing, Options{DryRun: true}))
+	require.NoDirExists(t, missing)
+	require.NoError(t, createOutputDir("gs://bucket/prefix", Options{RequireOutputDir: true}))
+
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	require.EqualError(t, createOutputDir(file, Options{}), fmt.Sprintf("output path %s is not a directory", file))
+}
+
+func TestSplitOutputDir(t *testing.T) {
+	const doc = `{"list": [1, 2]}`
+
+	// the directory is created before the split starts
+	dir := filepath.Join(t.TempDir(), "nested", "output")
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 4), dir, Options{}))
+	requireContents(t, filepath.Join(dir, "list_00.jsonl"), "1\n2")
+
+	// or is required to exist, before anything is read
+	input := filepath.Join(t.TempDir(), "input.json")
+	require.NoError(t, os.WriteFile(input, []byte(doc), 0o644))
+
+	dir = filepath.Join(t.TempDir(), "missing")
+	err := SplitFile(context.Background(), input, dir, Options{RequireOutputDir: true})
+	require.ErrorIs(t, err, ErrNoOutputDir)
+	require.NoDirExists(t, dir)
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 4), dir, Options{RequireOutputDir: true})
+	require.ErrorIs(t, err, ErrNoOutputDir)
+
+	// a directory which is required to exist is written into
+	dir = t.TempDir()
+	require.NoError(t, SplitFile(context.Background(), input, dir, Options{RequireOutputDir: true}))
+	requireContents(t, filepath.Join(dir, "list_00.jsonl"), "1\n2")
+
+	err = SplitFile(context.Background(), input, dir, Options{})
+	require.ErrorIs(t, err, ErrOutputExists)
+	require.EqualError(t, err, "output file already exists: "+dir)
+}
+
+func TestSplitOutputModes(t *testing.T) {
+	const doc = `[{"ts": "2024-01-01"}, {"ts": "2024-01-02"}]`
+
+	// modes are given regardless of the umask, to the output directory, the directories of partitions, the files and
+	// the manifest
+	dir := filepath.Join(t.TempDir(), "output")
+	opts := Options{PartitionByDate: "ts", FileMode: 0o640, DirMode: 0o750 | os.ModeSetgid}
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 4), dir, opts))
+
+	requireMode := func(path string, mode os.FileMode) {
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, mode, fi.Mode()&(os.ModePerm|os.ModeSetgid), path)
+	}
+
+	requireMode(dir, 0o750|os.ModeSetgid)
+	requireMode(filepath.Join(dir, "dt=2024-01-01"), 0o750|os.ModeSetgid)
+	requireMode(filepath.Join(dir, "dt=2024-01-01", "root_00.jsonl"), 0o640)
+	requireMode(filepath.Join(dir, "dt=2024-01-02", "root_00.jsonl"), 0o640)
+	requireMode(filepath.Join(dir, ManifestFilename), 0o640)
+
+	// directories which exist are left as they are
+	dir = t.TempDir()
+	require.NoError(t, os.Chmod(dir, 0o700))
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 4), dir, opts))
+	requireMode(dir, 0o700)
+
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 4), t.TempDir(),
+		Options{FileMode: 0o640 | os.ModeSetuid})
+	require.EqualError(t, err, "invalid file mode urw-r-----, only permission bits can be set")
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 4), t.TempDir(),
+		Options{DirMode: 0o750 | os.ModeSetuid})
+	require.EqualError(t, err, "invalid directory mode urwxr-x---, only permission, setgid and sticky bits can be set")
+}
+
+// listOutput returns the names of the files within dir, including those in the directories of partitions
+func listOutput(t *testing.T, dir string) []string {
+	var names []string
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			names = append(names, filepath.ToSlash(rel))
+		}
+
+		return err
+	})
+	require.NoError(t, err)
+
+	return names
+}
+
+func TestSplitAtomicFiles(t *testing.T) {
+	const doc = `{"key": "value", "users": [{"id": 1}, {"id": 2}, {"id": 3}], "dates": [{"ts": "2024-01-01"}]}`
+
+	// the files are renamed into place once written, leaving no temporary files behind
+	dir := t.TempDir()
+	opts := Options{AtomicFiles: true, RecordsPerFile: 2, FileMode: 0o640}
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 4), dir, opts))
+	require.ElementsMatch(t, []string{"dates_00.jsonl", "manifest.json", "root.json", "users_00.jsonl",
+		"users_01.jsonl"}, listOutput(t, dir))
+	requireContents(t, filepath.Join(dir, "users_01.jsonl"), `{"id":3}`)
+
+	fi, err := os.Stat(filepath.Join(dir, "users_00.jsonl"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+
+	// as are the files of partitions
+	dir = t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(`[{"ts": "2024-01-01"}]`), 4), dir,
+		Options{AtomicFiles: true, PartitionByDate: "ts"}))
+	require.ElementsMatch(t, []string{"dt=2024-01-01/root_00.jsonl", "manifest.json"}, listOutput(t, dir))
+
+	// a file which is still being written when the split crashes is only there under its temporary name
+	dir = t.TempDir()
+	sink := bindSink(context.Background(), NewDirSink(context.Background(), dir), Options{AtomicFiles: true})
+	w, err := sink.OpenKey("users_00.jsonl")
+	require.NoError(t, err)
+	_, err = w.Write([]byte(`{"id": 1}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{".users_00.jsonl.tmp"}, listOutput(t, dir))
+	require.NoError(t, w.Close())
+	require.Equal(t, []string{"users_00.jsonl"}, listOutput(t, dir))
+
+	// the files being written when a split fails part way through are removed, while those finished before it are
+	// kept whole
+	var items []string
+	for i := 0; i < 100; i++ {
+		items = append(items, fmt.Sprintf(`{"idx":%d}`, i))
+	}
+
+	list := `{"first": [` + strings.Join(items[:10], ", ") + `], "second": [` + strings.Join(items, ", ") + `]}`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir = t.TempDir()
+	cbs := &cancellingByteStream{
+		ByteStream:  NewTestByteStream([]byte(list), 16),
+		cancelAfter: len(list) * 3 / 4 / 16,
+		cancel:      cancel,
+	}
+
+	err = SplitStream(ctx, cbs, dir, Options{AtomicFiles: true})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []string{"first_00.jsonl"}, listOutput(t, dir))
+	requireContents(t, filepath.Join(dir, "first_00.jsonl"), strings.Join(items[:10], "\n"))
+
+	// a file whose writer fails as it is closed is removed rather than renamed into place
+	dir = t.TempDir()
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(`{"users": [{"id": 1}], "b": [1, 2]}`), 4), dir,
+		Options{AtomicFiles: true, Format: FormatCSV})
+	require.ErrorContains(t, err, "csv output requires lists of json objects")
+	require.Equal(t, []string{"users_00.csv"}, listOutput(t, dir))
+
+	dir = t.TempDir()
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(`{"users": [{"id": 1}, {"id": "x"}]}`), 4), dir,
+		Options{AtomicFiles: true, Format: FormatParquet})
+	require.ErrorContains(t, err, "parquet output needs the values of each field to be of one type")
+	require.Empty(t, listOutput(t, dir))
+
+	// files which exist are still refused when they can't be replaced
+	dir = t.TempDir()
+	sink = bindSink(context.Background(), NewDirSink(context.Background(), dir),
+		Options{AtomicFiles: true, ExistingOutput: ExistingOutputError})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "users_00.jsonl"), nil, 0o644))
+	_, err = sink.OpenKey("users_00.jsonl")
+	require.ErrorIs(t, err, ErrOutputExists)
+
+	err = SplitStream(context.Background(), NewTestByteStream([]byte(doc), 4), t.TempDir(),
+		Options{AtomicFiles: true, CheckpointInterval: time.Second})
+	require.EqualError(t, err, "checkpoints can't be used with atomic files")
+}
+
+// openCountingSink is a memSink counting the files which are open at once
+type openCountingSink struct {
+	*memSink
+	open    int64
+	maxOpen int64
+}
+
+func (ocs *openCountingSink) OpenKey(name string) (io.WriteCloser, error) {
+	wr, err := ocs.memSink.OpenKey(name)
+	if err != nil {
+		return nil, err
+	}
+
+	open := atomic.AddInt64(&ocs.open, 1)
+	for {
+		maxOpen := atomic.LoadInt64(&ocs.maxOpen)
+		if open <= maxOpen || atomic.CompareAndSwapInt64(&ocs.maxOpen, maxOpen, open) {
+			break
+		}
+	}
+
+	return &countedWriteCloser{WriteCloser: wr, open: &ocs.open}, nil
+}
+
+type countedWriteCloser struct {
+	io.WriteCloser
+	open *int64
+}
+
+func (cwc *countedWriteCloser) Close() error {
+	atomic.AddInt64(cwc.open, -1)
+	return cwc.WriteCloser.Close()
+}
+
+func TestSplitManyKeys(t *testing.T) {
+	const keys = 5000
+
+	// every key has a list, and the first hundred have a second list later in the document
+	var sb strings.Builder
+
+	sb.WriteString("{")
+
+	for i := 0; i < keys+100; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		fmt.Fprintf(&sb, `"key%d": [{"n": %d}, {"n": %d}]`, i%keys, i, i+1)
+	}
+
+	sb.WriteString("}")
+
+	for _, concurrency := range []int{1, 4} {
+		rd, err := AsyncReaderFromReader(strings.NewReader(sb.String()), 4096)
+		require.NoError(t, err)
+
+		// the files of a key are closed once its list has been written, so the files open at once are bounded by the
+		// lists written concurrently rather than by the number of keys
+		sink := &openCountingSink{memSink: newMemSink()}
+		require.NoError(t, Split(context.Background(), rd, sink, Options{Concurrency: concurrency}))
+		require.NoError(t, rd.Close())
+
+		require.LessOrEqual(t, sink.maxOpen, int64(concurrency))
+		require.Zero(t, sink.open)
+
+		// the sink can't append, so the second list of a key is written to a file of its own
+		require.Equal(t, `{"n":0}`+"\n"+`{"n":1}`, sink.contents("key0_00.jsonl"))
+		require.Equal(t, fmt.Sprintf(`{"n":%d}`+"\n"+`{"n":%d}`, keys, keys+1), sink.contents("key0_01.jsonl"))
+		require.Equal(t, fmt.Sprintf(`{"n":%d}`+"\n"+`{"n":%d}`, keys-1, keys), sink.contents(fmt.Sprintf(
+			"key%d_00.jsonl", keys-1)))
+
+		var manifest Manifest
+		require.NoError(t, json.Unmarshal([]byte(sink.contents(ManifestFilename)), &manifest))
+		require.Len(t, manifest.Keys, keys)
+		require.Equal(t, int64(4), manifest.Keys[0].Records)
+		require.Equal(t, int64(2), manifest.Keys[keys-1].Records)
+	}
+}
@@ -0,0 +1,372 @@
+package jsplit
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// DefaultMaxSortMemory is the number of bytes of items held in memory while the items of a list are sorted, before
+// they are spilled to a temporary file, when Options.MaxSortMemory isn't set
+const DefaultMaxSortMemory = 256 * 1024 * 1024
+
+// sortKind orders the values of the field items are sorted by which are of different types
+type sortKind byte
+
+const (
+	sortNumber sortKind = iota
+	sortString
+	sortOther   // booleans, objects and arrays, ordered by their compacted json
+	sortMissing // items which aren't objects with a non-null value for the field
+)
+
+// sortKey is the value of the field an item is sorted by
+type sortKey struct {
+	kind    sortKind
+	num     float64
+	integer bool   // whether the number is an integer which fits in an int64, compared exactly as ints
+	int     int64  // so that large ids which float64 can't tell apart are still ordered
+	text    string // the unquoted string, or the compacted json of other values
+}
+
+// newSortKey returns the key of the item, which is an object, for sorting by field
+func newSortKey(item []byte, field string) sortKey {
+	val, isString, ok := fieldValue(item, field)
+
+	switch {
+	case !ok:
+		return sortKey{kind: sortMissing}
+	case isString:
+		return sortKey{kind: sortString, text: val}
+	}
+
+	key, err := numberSortKey(val)
+	if err != nil {
+		return sortKey{kind: sortOther, text: val}
+	}
+
+	return key
+}
+
+// numberSortKey returns the key of a field whose value is the number written as text
+func numberSortKey(text string) (sortKey, error) {
+	num, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return sortKey{}, err
+	}
+
+	key := sortKey{kind: sortNumber, num: num, text: text}
+	key.int, err = strconv.ParseInt(text, 10, 64)
+	key.integer = err == nil
+
+	return key, nil
+}
+
+// less reports whether the key sorts before other
+func (k sortKey) less(other sortKey) bool {
+	if k.kind != other.kind {
+		return k.kind < other.kind
+	}
+
+	if k.kind == sortNumber {
+		if k.integer && other.integer {
+			return k.int < other.int
+		}
+
+		return k.num < other.num
+	}
+
+	return k.text < other.text
+}
+
+// sortedItem is an item of a list being sorted along with its key
+type sortedItem struct {
+	key  sortKey
+	item []byte
+}
+
+// itemSorter sorts the items of a list by a field before passing them to a ListAddFunc. Items are held in memory until
+// they add up to the sort memory, when they are sorted and spilled to a temporary file as a run, and once the list
+// ends the runs and the items still in memory are merged. Items with the same value for the field keep the order of
+// the list.
+type itemSorter struct {
+	add     ListAddFunc
+	field   string
+	maxMem  int64
+	tempDir string
+
+	items []sortedItem
+	mem   int64
+	runs  []*os.File
+}
+
+// newItemSorter returns an *itemSorter passing the items of a list, sorted by opts.SortBy, to add
+func newItemSorter(add ListAddFunc, opts Options) *itemSorter {
+	return &itemSorter{add: add, field: opts.SortBy, maxMem: opts.maxSortMemory(), tempDir: opts.TempDir}
+}
+
+// Add holds the item until the list ends, spilling the items held to a run once they reach the sort memory. Items are
+// copied as the parser reuses the memory holding them.
+func (s *itemSorter) Add(item []byte) error {
+	s.items = append(s.items, sortedItem{key: newSortKey(item, s.field), item: append([]byte(nil), item...)})
+	s.mem += int64(len(item))
+
+	if s.mem < s.maxMem {
+		return nil
+	}
+
+	return s.spill()
+}
+
+// spill sorts the items held in memory and writes them to a temporary file as a run
+func (s *itemSorter) spill() error {
+	f, err := os.CreateTemp(s.tempDir, "jsplit-sort-")
+	if err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, f)
+
+	sortItems(s.items)
+
+	wr := bufio.NewWriter(f)
+	for _, si := range s.items {
+		writeSortedItem(wr, si)
+	}
+
+	err = wr.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	s.items = s.items[:0]
+	s.mem = 0
+
+	return nil
+}
+
+// sortItems sorts the items by their keys, keeping the order of items with the same key
+func sortItems(items []sortedItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].key.less(items[j].key)
+	})
+}
+
+// writeSortedItem writes the item to a run as its kind, the length prefixed text of its key and the length prefixed
+// item. Bytes are written to a bufio.Writer, whose error is returned by Flush.
+func writeSortedItem(wr *bufio.Writer, si sortedItem) {
+	var lenBuf [binary.MaxVarintLen64]byte
+
+	_ = wr.WriteByte(byte(si.key.kind))
+
+	n := binary.PutUvarint(lenBuf[:], uint64(len(si.key.text)))
+	_, _ = wr.Write(lenBuf[:n])
+	_, _ = wr.WriteString(si.key.text)
+
+	n = binary.PutUvarint(lenBuf[:], uint64(len(si.item)))
+	_, _ = wr.Write(lenBuf[:n])
+	_, _ = wr.Write(si.item)
+}
+
+// readSortedItem reads the next item of a run written by writeSortedItem, returning io.EOF at the end of the run
+func readSortedItem(rd *bufio.Reader) (sortedItem, error) {
+	kind, err := rd.ReadByte()
+	if err != nil {
+		return sortedItem{}, err
+	}
+
+	text, err := readSortedBytes(rd)
+	if err != nil {
+		return sortedItem{}, err
+	}
+
+	item, err := readSortedBytes(rd)
+	if err != nil {
+		return sortedItem{}, err
+	}
+
+	key := sortKey{kind: sortKind(kind), text: string(text)}
+	if key.kind == sortNumber {
+		key, err = numberSortKey(key.text)
+		if err != nil {
+			return sortedItem{}, err
+		}
+	}
+
+	return sortedItem{key: key, item: item}, nil
+}
+
+// readSortedBytes reads length prefixed bytes from a run, which ends part way through them if they can't be read
+func readSortedBytes(rd *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(rd)
+	if err == nil {
+		b := make([]byte, n)
+		_, err = io.ReadFull(rd, b)
+
+		if err == nil {
+			return b, nil
+		}
+	}
+
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return nil, fmt.Errorf("unable to read sorted items: %w", err)
+}
+
+// flush passes the items of the list to add in order once it has ended, merging the runs spilled to temporary files
+// with the items still in memory, and then removes the runs
+func (s *itemSorter) flush() error {
+	defer s.close()
+
+	sortItems(s.items)
+
+	if len(s.runs) == 0 {
+		for _, si := range s.items {
+			err := s.add(si.item)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return s.merge()
+}
+
+// merge merges the runs and the items in memory, which follow the runs in the list, passing the items to add
+func (s *itemSorter) merge() error {
+	var h mergeHeap
+
+	for i, f := range s.runs {
+		src := &mergeSource{index: i, rd: bufio.NewReader(f)}
+
+		err := src.next()
+		if err != nil {
+			return err
+		}
+
+		if !src.done {
+			h = append(h, src)
+		}
+	}
+
+	mem := &mergeSource{index: len(s.runs), items: s.items}
+	if mem.next() == nil && !mem.done {
+		h = append(h, mem)
+	}
+
+	heap.Init(&h)
+
+	for len(h) > 0 {
+		src := h[0]
+
+		err := s.add(src.head.item)
+		if err != nil {
+			return err
+		}
+
+		err = src.next()
+		if err != nil {
+			return err
+		}
+
+		if src.done {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+
+	return nil
+}
+
+// close removes the runs spilled to temporary files and drops the items held in memory, once the list has been
+// written or the split has failed
+func (s *itemSorter) close() {
+	for _, f := range s.runs {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+
+	s.runs = nil
+	s.items = nil
+	s.mem = 0
+}
+
+// mergeSource is a run, or the items held in memory, being merged
+type mergeSource struct {
+	index int // the position of the source within the list, breaking ties between items with the same key
+	rd    *bufio.Reader
+	items []sortedItem
+	head  sortedItem
+	done  bool
+}
+
+// next moves to the next item of the source, setting done at its end
+func (ms *mergeSource) next() error {
+	if ms.rd == nil {
+		if len(ms.items) == 0 {
+			ms.done = true
+			return nil
+		}
+
+		ms.head, ms.items = ms.items[0], ms.items[1:]
+
+		return nil
+	}
+
+	si, err := readSortedItem(ms.rd)
+	if err == io.EOF {
+		ms.done = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	ms.head = si
+
+	return nil
+}
+
+// mergeHeap is a heap of the sources being merged, ordered by their next items
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].head.key.less(h[j].head.key) {
+		return true
+	}
+
+	if h[j].head.key.less(h[i].head.key) {
+		return false
+	}
+
+	return h[i].index < h[j].index
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return src
+}
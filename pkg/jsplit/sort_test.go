@@ -0,0 +1,149 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortKey(t *testing.T) {
+	items := []string{
+		`{"k": "b"}`,
+		`{"k": null}`,
+		`{"k": 10}`,
+		`{"k": true}`,
+		`{"k": "a"}`,
+		`{"k": 9.5}`,
+		`{"other": 1}`,
+		`{"k": -1e3}`,
+		`[1]`,
+		`{"k": [1, 2]}`,
+		`{"k": 9007199254740993}`,
+		`{"k": 9007199254740992}`,
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return newSortKey([]byte(items[i]), "k").less(newSortKey([]byte(items[j]), "k"))
+	})
+
+	// numbers sort numerically, integers exactly even beyond the precision of a float64, then strings, other values and
+	// items without a value, which keep their order
+	require.Equal(t, []string{
+		`{"k": -1e3}`,
+		`{"k": 9.5}`,
+		`{"k": 10}`,
+		`{"k": 9007199254740992}`,
+		`{"k": 9007199254740993}`,
+		`{"k": "a"}`,
+		`{"k": "b"}`,
+		`{"k": [1, 2]}`,
+		`{"k": true}`,
+		`{"k": null}`,
+		`{"other": 1}`,
+		`[1]`,
+	}, items)
+}
+
+func TestItemSorter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	var items, expected []string
+
+	for i := 0; i < 500; i++ {
+		items = append(items, fmt.Sprintf(`{"k": %d, "i": %d}`, rnd.Intn(50), i))
+	}
+
+	expected = append(expected, items...)
+	sort.SliceStable(expected, func(i, j int) bool {
+		return newSortKey([]byte(expected[i]), "k").less(newSortKey([]byte(expected[j]), "k"))
+	})
+
+	sortAll := func(maxMem int64) ([]string, string) {
+		tempDir := t.TempDir()
+
+		var sorted []string
+
+		s := newItemSorter(func(item []byte) error {
+			sorted = append(sorted, string(item))
+			return nil
+		}, Options{SortBy: "k", MaxSortMemory: maxMem, TempDir: tempDir})
+
+		for _, item := range items {
+			require.NoError(t, s.Add([]byte(item)))
+		}
+
+		require.NoError(t, s.flush())
+
+		return sorted, tempDir
+	}
+
+	// the items are sorted in memory, or spilled to runs which are merged and then removed, either way keeping the
+	// order of items with the same key
+	sorted, tempDir := sortAll(0)
+	require.Equal(t, expected, sorted)
+	requireEmptyDir(t, tempDir)
+
+	for _, maxMem := range []int64{1, 100, 1000, 4000} {
+		sorted, tempDir = sortAll(maxMem)
+		require.Equal(t, expected, sorted, maxMem)
+		requireEmptyDir(t, tempDir)
+	}
+
+	// runs are removed when the items can't be written
+	tempDir = t.TempDir()
+	addErr := errors.New("add failed")
+
+	s := newItemSorter(func([]byte) error { return addErr }, Options{SortBy: "k", MaxSortMemory: 10, TempDir: tempDir})
+	for _, item := range items[:10] {
+		require.NoError(t, s.Add([]byte(item)))
+	}
+
+	require.ErrorIs(t, s.flush(), addErr)
+	requireEmptyDir(t, tempDir)
+}
+
+func TestSplitStreamSortBy(t *testing.T) {
+	const doc = `{"users": [{"id": 3, "name": "c"}, {"id": 1, "name": "a"}, {"name": "x"}, {"id": 2, "name": "b"}],
+		"groups": [{"name": "z"}, {"name": "y"}], "count": 4}`
+
+	split := func(opts Options) (string, error) {
+		tempDir := t.TempDir()
+		return tempDir, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir, opts)
+	}
+
+	for _, opts := range []Options{
+		{SortBy: "id"},
+		{SortBy: "id", Concurrency: 2},
+		{SortBy: "id", MaxSortMemory: 16, TempDir: t.TempDir()},
+	} {
+		tempDir, err := split(opts)
+		require.NoError(t, err)
+		requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), strings.Join([]string{
+			`{"id":1,"name":"a"}`, `{"id":2,"name":"b"}`, `{"id":3,"name":"c"}`, `{"name":"x"}`}, "\n"))
+		requireContents(t, filepath.Join(tempDir, "groups_00.jsonl"), `{"name":"z"}`+"\n"+`{"name":"y"}`)
+		require.Equal(t, int64(4), readManifest(t, tempDir).Keys[0].Records)
+
+		if opts.TempDir != "" {
+			requireEmptyDir(t, opts.TempDir)
+		}
+	}
+
+	// the elements of a document which is a json array are sorted too
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(`[{"n": "b"}, {"n": "a"}]`), 8),
+		tempDir, Options{SortBy: "n"}))
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), `{"n":"a"}`+"\n"+`{"n":"b"}`)
+
+	_, err := split(Options{SortBy: "id", Shards: 2})
+	require.EqualError(t, err, "sorted output can't be written to shards")
+
+	_, err = split(Options{SortBy: "id", MaxSortMemory: -1})
+	require.EqualError(t, err, "max sort memory must not be negative, got -1")
+}
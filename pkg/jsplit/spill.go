@@ -0,0 +1,80 @@
+package jsplit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// jsonlSpill holds the jsonl data written for a file in a format which can't be written until every object has been
+// seen, such as csv, whose header is the union of their keys, in a temporary file which is read back once the file
+// is complete
+type jsonlSpill struct {
+	file  *os.File
+	bufWr *bufio.Writer
+}
+
+// newJsonlSpill returns a *jsonlSpill spilling to a temporary file in tempDir, or in the default directory for temporary
+// files when it is empty, see Options.TempDir
+func newJsonlSpill(tempDir string) (*jsonlSpill, error) {
+	file, err := os.CreateTemp(tempDir, "jsplit-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonlSpill{file: file, bufWr: bufio.NewWriterSize(file, 256*1024)}, nil
+}
+
+// Write spills jsonl data to the temporary file
+func (js *jsonlSpill) Write(p []byte) (int, error) {
+	return js.bufWr.Write(p)
+}
+
+// flush writes the buffered data to the temporary file, so that it can be read back
+func (js *jsonlSpill) flush() error {
+	return js.bufWr.Flush()
+}
+
+// eachObject calls fn with the fields of each object in the spilled data, failing if any line isn't an object, as
+// files in format can only be written for lists of objects
+func (js *jsonlSpill) eachObject(format Format, fn func(fields []objectField) error) error {
+	_, err := js.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	rd := bufio.NewReaderSize(js.file, 256*1024)
+
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			fields, decodeErr := decodeObject(line)
+			if decodeErr != nil {
+				return fmt.Errorf("%s output requires lists of json objects: %w", format, decodeErr)
+			}
+
+			cbErr := fn(fields)
+			if cbErr != nil {
+				return cbErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// close closes and removes the temporary file
+func (js *jsonlSpill) close() error {
+	defer os.Remove(js.file.Name())
+
+	return js.file.Close()
+}
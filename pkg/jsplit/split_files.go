@@ -0,0 +1,588 @@
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileErrors is what SplitFiles does when one of its files can't be split
+type FileErrors string
+
+const (
+	// FileErrorsFailFast stops splitting every file as soon as one fails, returning its error without writing the
+	// manifest
+	FileErrorsFailFast FileErrors = "fail-fast"
+	// FileErrorsBestEffort carries on splitting the other files when one fails, and lists the files which failed and
+	// their errors in the manifest before returning ErrFilesFailed. The items of a file which were written before it
+	// failed are kept, but none of the values of the file which aren't lists are written to root.json. Errors writing
+	// the output, and the split being cancelled, still stop every file.
+	FileErrorsBestEffort FileErrors = "best-effort"
+)
+
+// ErrFilesFailed is returned by SplitFiles once the other files have been split when some couldn't be, and
+// Options.FileErrors is FileErrorsBestEffort
+var ErrFilesFailed = errors.New("files couldn't be split")
+
+// SplitFiles splits each of the json files as a document of its own, writing the files to outputPath in the same way
+// as SplitFile. The items of lists with the same key in different files are appended to the same series of files, and
+// the values which aren't lists are all written to one root.json, in the order of the files. The elements of files
+// which are json arrays are written as a list with the key opts.RootListKey.
+//
+// Up to opts.FileConcurrency files are read and parsed at once. The items of each file are written in the order they
+// appear in it, but those of files parsed at the same time are interleaved as they are parsed, so only when the files
+// are split one after another do the items of a key follow the order of the files. The number of items written for
+// each key, and the keys listed in the manifest, don't depend on the order the files are parsed in.
+//
+// A file which can't be split stops the others unless opts.FileErrors is FileErrorsBestEffort.
+func SplitFiles(ctx context.Context, filenames []string, outputPath string, opts Options) error {
+	if len(filenames) == 0 {
+		return errors.New("no files to split")
+	}
+
+	err := opts.validate()
+	if err != nil {
+		return err
+	}
+
+	err = opts.validateFiles()
+	if err != nil {
+		return err
+	}
+
+	// the files are read and written within one budget, and count towards one limit on files
+	opts = opts.withMemoryBudget().withFileLimit()
+
+	existed := localDirExists(outputPath)
+
+	err = prepareOutputDir(outputPath, opts)
+	if err != nil {
+		return err
+	}
+
+	// cancelling the context when a file can't be split stops the others, and aborts any uploads still in progress
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	failure := &firstError{cancel: cancel}
+	sink := bindSink(ctx, NewDirSink(ctx, outputPath), opts)
+
+	ms := newMultiSplit(sink, opts)
+	defer func() {
+		_ = ms.rejects.close()
+	}()
+
+	ms.stats = newStatsReporter(&ms.read, opts)
+	defer ms.stats.close()
+
+	roots := make([]rootValues, len(filenames))
+	sem := make(chan struct{}, opts.fileConcurrency())
+
+	var wg sync.WaitGroup
+
+	for i, filename := range filenames {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+
+		go func(i int, filename string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			root, err := ms.splitFile(ctx, i, filename)
+			switch {
+			case err == nil:
+				roots[i] = root
+			case !ms.skipFile(ctx, i, filename, err):
+				failure.set(fmt.Errorf("%s: %w", filename, err))
+			}
+		}(i, filename)
+	}
+
+	wg.Wait()
+
+	// the split is stopped when the context it was given is cancelled before every file has been started
+	if failure.get() == nil && ctx.Err() != nil {
+		failure.set(ctx.Err())
+	}
+
+	manifest, err := ms.finish(failure.get())
+	if err != nil {
+		return removeFailedOutputDir(outputPath, existed, err)
+	}
+
+	err = ms.rejects.close()
+	if err != nil {
+		return err
+	}
+
+	manifest.Rejects = ms.rejects.file()
+	manifest.Failed = ms.failures()
+
+	rootItems := []byte("{\n")
+	rootRecords := int64(0)
+
+	for _, root := range roots {
+		if root.records == 0 {
+			continue
+		}
+
+		if rootRecords > 0 {
+			rootItems = append(rootItems, []byte(",\n")...)
+		}
+
+		rootItems = append(rootItems, root.items...)
+		rootRecords += root.records
+	}
+
+	err = writeRoot(sink, append(rootItems, []byte("\n}")...), rootRecords, manifest, opts)
+	if err != nil {
+		return err
+	}
+
+	err = writeManifest(sink, manifest, opts)
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start).Seconds()
+	logger().Info(fmt.Sprintf("Completed %d files in %f seconds", len(filenames), elapsed), "files", len(filenames),
+		"seconds", elapsed)
+
+	if len(manifest.Failed) > 0 {
+		errs := make([]string, len(manifest.Failed))
+		for i, mf := range manifest.Failed {
+			errs[i] = mf.Input + ": " + mf.Error
+		}
+
+		return fmt.Errorf("%d of %d %w: %s", len(manifest.Failed), len(filenames), ErrFilesFailed,
+			strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// outputError is an error writing the output of SplitFiles, rather than reading one of its files, which stops every
+// file whatever Options.FileErrors is
+type outputError struct {
+	err error
+}
+
+func (oe *outputError) Error() string {
+	return oe.err.Error()
+}
+
+func (oe *outputError) Unwrap() error {
+	return oe.err
+}
+
+// failedFile is a file SplitFiles couldn't split, with its index in the files
+type failedFile struct {
+	index int
+	ManifestFailure
+}
+
+// rootValues are the values of a file which aren't lists, to be written to root.json
+type rootValues struct {
+	items   []byte
+	records int64
+}
+
+// add adds the value of the key, which is quoted
+func (rv *rootValues) add(key, val []byte) {
+	if rv.records > 0 {
+		rv.items = append(rv.items, []byte(",\n")...)
+	}
+
+	rv.items = append(rv.items, '\t')
+	rv.items = append(rv.items, key...)
+	rv.items = append(rv.items, ':')
+	rv.items = append(rv.items, val...)
+	rv.records++
+}
+
+// multiSplit holds what is shared by the splits of the files passed to SplitFiles, the writers of each key above all
+type multiSplit struct {
+	sink    OutputSink
+	opts    Options
+	stats   *statsReporter
+	read    readProgress
+	mu      sync.Mutex // guards names, keys, rejects and failed
+	names   *fileNames
+	keys    map[string]*sharedKey
+	rejects *rejectLog
+	failed  []failedFile
+}
+
+// newMultiSplit returns a *multiSplit writing the keys of the files to sink
+func newMultiSplit(sink OutputSink, opts Options) *multiSplit {
+	return &multiSplit{
+		sink:    sink,
+		opts:    opts,
+		names:   newFileNames(opts),
+		keys:    make(map[string]*sharedKey),
+		rejects: newRejectLog(sink, opts),
+	}
+}
+
+// sharedKey is a key of the documents being split, whose items can be added by the splits of several files at once.
+// Items are added one at a time, so a file's items stay in order, and deduplicated and limited across every file.
+type sharedKey struct {
+	mu   sync.Mutex
+	sk   *splitKey
+	add  ListAddFunc
+	full bool // set once Options.Limit items have been added
+
+	// file and pos are the index of the first file the key was found in and its position in that file, which order
+	// the keys in the manifest however the files are scheduled
+	file, pos int
+}
+
+// Add adds the item to the key's files
+func (k *sharedKey) Add(item []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// the other files with the key stop parsing its list as soon as they try to add to it
+	if k.full {
+		return errListLimit
+	}
+
+	err := k.add(item)
+	if errors.Is(err, errListLimit) {
+		k.full = true
+		return err
+	}
+
+	// an item the record transform failed for only fails the file it is in
+	var skippable *skippableError
+	if err != nil && !errors.As(err, &skippable) {
+		return &outputError{err: err}
+	}
+
+	return err
+}
+
+// setList records that the key's value is a list in at least one of the files
+func (k *sharedKey) setList() {
+	k.mu.Lock()
+	k.sk.isList = true
+	k.mu.Unlock()
+}
+
+// key returns the shared writer for the key, creating it the first time the key is found. file and pos are the index of
+// the file being split and the position of the key within it.
+func (ms *multiSplit) key(name string, file, pos int) (*sharedKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	k, ok := ms.keys[name]
+	if !ok {
+		fileName, err := ms.names.name(name)
+		if err != nil {
+			return nil, err
+		}
+
+		sk := newSplitKey(ms.sink, name, fileName, ms.opts)
+		k = &sharedKey{sk: sk, add: sk.items(sk.add(ms.opts)), file: file, pos: pos}
+		ms.keys[name] = k
+
+		return k, nil
+	}
+
+	if file < k.file || (file == k.file && pos < k.pos) {
+		k.file, k.pos = file, pos
+	}
+
+	return k, nil
+}
+
+// rejectHandler returns the listErrorFunc logging the items of the key's list in the file which are rejected, naming
+// the file in their error, or nil if errors aren't being skipped
+func (ms *multiSplit) rejectHandler(itr *BufferedByteStreamIter, filename, name string) listErrorFunc {
+	handler := ms.rejects.handler(name, itr)
+	if handler == nil {
+		return nil
+	}
+
+	return func(index int, item []byte, err error) error {
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+
+		err = handler(index, item, fmt.Errorf("%s: %w", filename, err))
+		if err != nil {
+			return &outputError{err: err}
+		}
+
+		return nil
+	}
+}
+
+// skipFile records the error which stopped the file with the given index being split and returns true, if
+// Options.FileErrors lets the other files carry on without it
+func (ms *multiSplit) skipFile(ctx context.Context, index int, filename string, err error) bool {
+	var oe *outputError
+	if ms.opts.FileErrors != FileErrorsBestEffort || errors.As(err, &oe) || ctx.Err() != nil {
+		return false
+	}
+
+	logger().Warn(fmt.Sprintf("Couldn't split %s, carrying on with the other files: %s", filename, err),
+		"input", filename, "error", err)
+
+	ms.mu.Lock()
+	ms.failed = append(ms.failed, failedFile{index: index, ManifestFailure: ManifestFailure{Input: filename,
+		Error: err.Error()}})
+	ms.mu.Unlock()
+
+	return true
+}
+
+// failures returns the files which couldn't be split, in the order they were given
+func (ms *multiSplit) failures() []ManifestFailure {
+	sort.Slice(ms.failed, func(i, j int) bool {
+		return ms.failed[i].index < ms.failed[j].index
+	})
+
+	var failures []ManifestFailure
+	for _, ff := range ms.failed {
+		failures = append(failures, ff.ManifestFailure)
+	}
+
+	return failures
+}
+
+// splitFile splits the file with the given index, adding the items of its lists to the shared keys and returning the
+// values which aren't lists
+func (ms *multiSplit) splitFile(ctx context.Context, index int, filename string) (rootValues, error) {
+	if isZip(filename) {
+		return rootValues{}, errors.New("zip archives can't be split along with other files")
+	}
+
+	if isTarGz(filename) {
+		return rootValues{}, errors.New("tar archives can't be split along with other files")
+	}
+
+	rd, err := AsyncReaderFromFile(filename, 1024*1024, ms.opts.ReaderOptions...)
+	if err != nil {
+		return rootValues{}, err
+	}
+
+	// stops reading if splitting fails part way through
+	defer rd.Close()
+
+	logger().Info("Reading "+filename, "input", filename)
+
+	ms.read.add(rd)
+
+	opts := ms.opts
+	opts.source = filename
+
+	itr := NewBufferedStreamIter(rd.Start(ctx), json5Input(rd, opts))
+	itr.maxDepth = opts.maxDepth()
+	itr.maxRecordBytes = int(opts.MaxRecordBytes)
+
+	ch, err := seekRoot(itr, opts)
+	if err != nil {
+		return rootValues{}, err
+	}
+
+	switch ch {
+	case OpenSB:
+		err = newKeyCheck(opts).list()
+		if err != nil {
+			return rootValues{}, err
+		}
+
+		itr.Advance(-1)
+		itr.Skip()
+
+		name := opts.rootListKey()
+
+		k, err := ms.key(name, index, 0)
+		if err != nil {
+			return rootValues{}, err
+		}
+
+		k.setList()
+
+		add, onErr := annotateItems(name, ms.stats.count(name, k.Add), ms.rejectHandler(itr, filename, name), opts)
+
+		err = parseList(itr, add, onErr)
+		if tolerateTruncation(itr, err, opts) {
+			err = nil
+		}
+
+		return rootValues{}, err
+	case OpenCB:
+		itr.Skip()
+
+		return ms.splitObject(itr, index, filename, opts)
+	}
+
+	return rootValues{}, itr.errorf("invalid format. only json objects and arrays are supported")
+}
+
+// splitObject adds the lists of the object being split, following its opening brace, to the shared keys, returning
+// its other values
+func (ms *multiSplit) splitObject(itr *BufferedByteStreamIter, index int, filename string,
+	opts Options) (rootValues, error) {
+	var root rootValues
+
+	seen := make(map[string]bool)
+	check := newKeyCheck(opts)
+
+	for pos := 0; ; pos++ {
+		key, err := ParseKey(itr)
+		if tolerateTruncation(itr, err, opts) {
+			return root, check.end()
+		} else if err != nil {
+			return root, err
+		}
+
+		// the key refers to the iterator's buffer which is reused while the value is parsed
+		key = append([]byte(nil), key...)
+		name := string(key[1 : len(key)-1])
+
+		err = check.add(name)
+		if err != nil {
+			return root, err
+		}
+
+		// the values of keys which aren't being split are parsed past without writing anything
+		if !opts.splitsKey(name) {
+			_, _, err = parseVal(itr, discardItem, nil, None)
+			if tolerateTruncation(itr, err, opts) {
+				return root, check.end()
+			} else if err != nil {
+				return root, err
+			}
+
+			if endOfObject(itr) {
+				return root, check.end()
+			}
+
+			continue
+		}
+
+		// a key found more than once in the file is appended to like one found in several files
+		if seen[name] {
+			warnDuplicateKey(itr, name)
+		}
+
+		seen[name] = true
+
+		k, err := ms.key(name, index, pos)
+		if err != nil {
+			return root, err
+		}
+
+		add, onErr := annotateItems(name, ms.stats.count(name, k.Add), ms.rejectHandler(itr, filename, name), opts)
+
+		isList, val, err := parseVal(itr, add, onErr, None)
+		if err != nil && !tolerateTruncation(itr, err, opts) {
+			return root, err
+		}
+
+		if isList {
+			k.setList()
+		}
+
+		if err != nil {
+			return root, check.end()
+		}
+
+		if val != nil {
+			root.add(key, val)
+		}
+
+		if endOfObject(itr) {
+			return root, check.end()
+		}
+	}
+}
+
+// finish finishes writing every key once all the files have been split, returning the manifest listing their files,
+// or closes the files of every key if err, the error which stopped the split, is set
+func (ms *multiSplit) finish(err error) (*Manifest, error) {
+	keys := make([]*sharedKey, 0, len(ms.keys))
+	for _, k := range ms.keys {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].file != keys[j].file {
+			return keys[i].file < keys[j].file
+		}
+
+		return keys[i].pos < keys[j].pos
+	})
+
+	if err != nil {
+		for _, k := range keys {
+			k.sk.abandon()
+		}
+
+		return nil, err
+	}
+
+	var manifest Manifest
+
+	for _, k := range keys {
+		err = k.sk.finish()
+		if err != nil {
+			for _, k := range keys {
+				k.sk.abandon()
+			}
+
+			return nil, err
+		}
+	}
+
+	for _, k := range keys {
+		k.sk.addToManifest(&manifest, ms.rejects)
+	}
+
+	ms.names.addToManifest(&manifest)
+
+	return &manifest, nil
+}
+
+// readProgress adds up the bytes read from each of the files being split, for reporting stats
+type readProgress struct {
+	mu      sync.Mutex
+	readers []*AsyncReader
+}
+
+// add adds a reader which has been opened
+func (rp *readProgress) add(rd *AsyncReader) {
+	rp.mu.Lock()
+	rp.readers = append(rp.readers, rd)
+	rp.mu.Unlock()
+}
+
+// BytesRead returns the number of bytes read from every file so far
+func (rp *readProgress) BytesRead() int64 {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	var n int64
+	for _, rd := range rp.readers {
+		n += rd.BytesRead()
+	}
+
+	return n
+}
@@ -0,0 +1,236 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeInputs writes each of the documents to its own file in dir, returning their names in order
+func writeInputs(t *testing.T, dir string, docs ...string) []string {
+	var filenames []string
+
+	for i, doc := range docs {
+		name := filepath.Join(dir, fmt.Sprintf("part-%04d.json", i))
+		require.NoError(t, os.WriteFile(name, []byte(doc), 0o600))
+		filenames = append(filenames, name)
+	}
+
+	return filenames
+}
+
+func TestSplitFiles(t *testing.T) {
+	dir := t.TempDir()
+	filenames := writeInputs(t, dir,
+		`{"name": "first", "users": [{"id": 1}, {"id": 2}]}`,
+		`{"groups": ["admin"], "users": [{"id": 3}], "count": 1}`,
+		`[{"id": "a"}, {"id": "b"}]`,
+		`{"users": [{"id": 4}, {"id": 5}]}`,
+	)
+
+	// files split one after another are written in order
+	outputDir := filepath.Join(dir, "output")
+	require.NoError(t, SplitFiles(context.Background(), filenames, outputDir, Options{}))
+
+	requireContents(t, filepath.Join(outputDir, "users_00.jsonl"),
+		"{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}\n{\"id\":5}")
+	requireContents(t, filepath.Join(outputDir, "groups_00.jsonl"), `"admin"`)
+	requireContents(t, filepath.Join(outputDir, "root_00.jsonl"), "{\"id\":\"a\"}\n{\"id\":\"b\"}")
+	requireContents(t, filepath.Join(outputDir, "root.json"), "{\n\t\"name\":\"first\",\n\t\"count\":1\n}")
+
+	manifest := readManifest(t, outputDir)
+	require.Equal(t, int64(2), manifest.Root.Records)
+	require.Len(t, manifest.Keys, 3)
+	require.Equal(t, "users", manifest.Keys[0].Key)
+	require.Equal(t, int64(5), manifest.Keys[0].Records)
+	require.Equal(t, "groups", manifest.Keys[1].Key)
+	require.Equal(t, "root", manifest.Keys[2].Key)
+
+	_, err := os.Stat(filepath.Join(outputDir, "part-0000"))
+	require.True(t, os.IsNotExist(err))
+
+	require.EqualError(t, SplitFiles(context.Background(), nil, outputDir, Options{}), "no files to split")
+}
+
+func TestSplitFilesConcurrently(t *testing.T) {
+	dir := t.TempDir()
+
+	var (
+		docs     []string
+		expected []string
+	)
+
+	for i := 0; i < 16; i++ {
+		var items []string
+		for j := 0; j < 100; j++ {
+			item := fmt.Sprintf(`{"file":%d,"item":%d}`, i, j)
+			items = append(items, item)
+			expected = append(expected, item)
+		}
+
+		docs = append(docs, fmt.Sprintf(`{"items": [%s], "file%d": %d}`, strings.Join(items, ", "), i, i))
+	}
+
+	filenames := writeInputs(t, dir, docs...)
+
+	for _, concurrency := range []int{2, 4, 16} {
+		outputDir := filepath.Join(dir, fmt.Sprintf("output-%d", concurrency))
+		opts := Options{FileConcurrency: concurrency, MaxFileBytes: 4096}
+		require.NoError(t, SplitFiles(context.Background(), filenames, outputDir, opts))
+
+		// every item is written once, whichever order the files are parsed in, and the items of each file stay in order
+		manifest := readManifest(t, outputDir)
+		require.Len(t, manifest.Keys, 1)
+		require.Equal(t, int64(len(expected)), manifest.Keys[0].Records)
+		require.Equal(t, int64(16), manifest.Root.Records)
+
+		var items []string
+		for _, file := range manifest.Keys[0].Files {
+			data, err := os.ReadFile(filepath.Join(outputDir, file.Name))
+			require.NoError(t, err)
+
+			lines := strings.Split(string(data), "\n")
+			require.Equal(t, file.Records, int64(len(lines)))
+			items = append(items, lines...)
+		}
+
+		last := make(map[int]int)
+		for _, item := range items {
+			var file, index int
+			_, err := fmt.Sscanf(item, `{"file":%d,"item":%d}`, &file, &index)
+			require.NoError(t, err)
+
+			if prev, ok := last[file]; ok {
+				require.Equal(t, prev+1, index)
+			}
+
+			last[file] = index
+		}
+
+		sort.Strings(items)
+		sorted := append([]string(nil), expected...)
+		sort.Strings(sorted)
+		require.Equal(t, sorted, items)
+
+		// the values which aren't lists are written in the order of the files
+		data, err := os.ReadFile(filepath.Join(outputDir, "root.json"))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(data), "{\n\t\"file0\":0,\n\t\"file1\":1,\n"))
+	}
+}
+
+func TestSplitFilesSharedOptions(t *testing.T) {
+	dir := t.TempDir()
+	filenames := writeInputs(t, dir,
+		`{"users": [{"id": 1}, {"id": 2}, {"id": 1}]}`,
+		`{"users": [{"id": 2}, {"id": 3}, {"id": 4}, {"id": 5}]}`,
+	)
+
+	// duplicates are dropped, and the limit applied, across every file
+	outputDir := filepath.Join(dir, "dedup")
+	require.NoError(t, SplitFiles(context.Background(), filenames, outputDir, Options{DedupField: "id", FileConcurrency: 2}))
+
+	manifest := readManifest(t, outputDir)
+	require.Len(t, manifest.Keys, 1)
+	require.Equal(t, int64(5), manifest.Keys[0].Records)
+	require.Equal(t, int64(2), manifest.Keys[0].Duplicates)
+
+	outputDir = filepath.Join(dir, "limit")
+	require.NoError(t, SplitFiles(context.Background(), filenames, outputDir, Options{Limit: 4, FileConcurrency: 2}))
+
+	manifest = readManifest(t, outputDir)
+	require.Equal(t, int64(4), manifest.Keys[0].Records)
+}
+
+func TestSplitFilesErrors(t *testing.T) {
+	dir := t.TempDir()
+	filenames := writeInputs(t, dir, `{"users": [{"id": 1}]}`, `{"users": [{"id": 2}, `)
+
+	// errors name the file which couldn't be split
+	err := SplitFiles(context.Background(), filenames, filepath.Join(dir, "bad"), Options{FileConcurrency: 2})
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), filenames[1]+": "), err.Error())
+
+	missing := filepath.Join(dir, "missing.json")
+	err = SplitFiles(context.Background(), []string{filenames[0], missing}, filepath.Join(dir, "missing"), Options{})
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	tests := map[string]Options{
+		"several files can't be split with shards":                                    {Shards: 2},
+		"several files can't be split with partitions":                                {PartitionBy: "id"},
+		"several files can't be split with checkpoints":                               {CheckpointInterval: 1},
+		"several files can't be split with concurrency, use file concurrency instead": {Concurrency: 2},
+		"file concurrency must not be negative, got -1":                               {FileConcurrency: -1},
+		`unsupported file error policy "skip", it must be fail-fast or best-effort`:   {FileErrors: "skip"},
+	}
+
+	for expected, opts := range tests {
+		err = SplitFiles(context.Background(), filenames, filepath.Join(dir, "invalid"), opts)
+		require.EqualError(t, err, expected)
+	}
+}
+
+func TestSplitFilesFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	filenames := writeInputs(t, dir,
+		`{"name": "first", "users": [{"id": 1}, {"id": 2}]}`,
+		`{"count": 2, "users": [{"id": 3}, {"id": 4},`,
+		`{"users": [{"id": 5}], "last": true}`,
+	)
+
+	for _, opts := range []Options{{}, {FileErrors: FileErrorsFailFast}} {
+		// failing fast stops the split at the bad file, without writing the manifest
+		outputDir := filepath.Join(dir, "fail-fast")
+		opts.Overwrite = true
+
+		err := SplitFiles(context.Background(), filenames, outputDir, opts)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrFilesFailed)
+		require.True(t, strings.HasPrefix(err.Error(), filenames[1]+": "), err.Error())
+		require.NoFileExists(t, filepath.Join(outputDir, ManifestFilename))
+	}
+
+	for _, concurrency := range []int{0, 3} {
+		// while the best effort carries on with the other files, and lists the bad one in the manifest
+		outputDir := filepath.Join(dir, fmt.Sprintf("best-effort-%d", concurrency))
+
+		err := SplitFiles(context.Background(), filenames, outputDir,
+			Options{FileErrors: FileErrorsBestEffort, FileConcurrency: concurrency})
+		require.ErrorIs(t, err, ErrFilesFailed)
+		require.True(t, strings.HasPrefix(err.Error(), "1 of 3 files couldn't be split: "+filenames[1]+": "),
+			err.Error())
+
+		// the items of the bad file parsed before it failed are kept, but not the values of its root
+		manifest := readManifest(t, outputDir)
+		require.Len(t, manifest.Failed, 1)
+		require.Equal(t, filenames[1], manifest.Failed[0].Input)
+		require.NotEmpty(t, manifest.Failed[0].Error)
+		require.Equal(t, int64(5), manifest.Keys[0].Records)
+		requireContents(t, filepath.Join(outputDir, "root.json"), "{\n\t\"name\":\"first\",\n\t\"last\":true\n}")
+
+		if concurrency == 0 {
+			requireContents(t, filepath.Join(outputDir, "users_00.jsonl"),
+				"{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}\n{\"id\":5}")
+		}
+	}
+
+	// files which fail to open are skipped the same way
+	outputDir := filepath.Join(dir, "missing")
+	err := SplitFiles(context.Background(), []string{filenames[0], filepath.Join(dir, "missing.json")}, outputDir,
+		Options{FileErrors: FileErrorsBestEffort})
+	require.ErrorIs(t, err, ErrFilesFailed)
+	require.Len(t, readManifest(t, outputDir).Failed, 1)
+
+	// but errors writing the output still stop every file
+	err = SplitFiles(context.Background(), filenames, filepath.Join(dir, "too-many"),
+		Options{FileErrors: FileErrorsBestEffort, MaxFiles: 1, MaxFileBytes: 10})
+	require.ErrorIs(t, err, ErrTooManyFiles)
+	require.NotErrorIs(t, err, ErrFilesFailed)
+	require.NoFileExists(t, filepath.Join(dir, "too-many", ManifestFilename))
+}
@@ -3,6 +3,7 @@ package jsplit
 import (
 	"errors"
 	"io"
+	"time"
 )
 
 var newLineBytes = []byte{byte('\n')}
@@ -16,8 +17,17 @@ type SplittingJsonlWriter struct {
 	wr           io.WriteCloser
 	createWriter CreateWriterFn
 	splitSize    uint64
+	splitRecords int // the number of items after which files are split instead of splitSize, see Options.RecordsPerFile
 	writtenBytes uint64
 	writtenItems int
+	records      []int64 // number of items written to each file
+	terminate    bool    // whether every item, including the last, is followed by a newline
+	flushItems   bool    // whether each item is flushed to the file as soon as it has been written
+
+	// syncInterval is how often the file being written is synced to disk, see Options.FlushInterval, and synced when
+	// it was last synced or created
+	syncInterval time.Duration
+	synced       time.Time
 } // repacked by gopium
 
 // NewSplittingJsonlWriter returns a *SplittingJsonlWriter which creates streams using the supplied function.  These streams
@@ -31,6 +41,22 @@ func NewSplittingJsonlWriter(createWriter CreateWriterFn, splitSize uint64) *Spl
 	}
 }
 
+// newListWriter returns a *SplittingJsonlWriter creating files with the factory, splitting them and terminating lines as
+// set in opts. Items streamed by the factory's sink are always terminated, and flushed as they are written.
+func newListWriter(factory *BufferedWriterFactory, opts Options) *SplittingJsonlWriter {
+	wr := NewSplittingJsonlWriter(factory.CreateWriter, opts.splitSize())
+	wr.splitRecords = opts.RecordsPerFile
+	wr.terminate = opts.format() == FormatNDJSON || streams(factory.sink)
+	wr.flushItems = streams(factory.sink)
+
+	// streams such as stdout can't be synced
+	if !streams(factory.sink) {
+		wr.syncInterval = opts.FlushInterval
+	}
+
+	return wr
+}
+
 // Add adds a new json list to be written to the current stream
 func (sjwr *SplittingJsonlWriter) Add(item []byte) error {
 	if sjwr.wr == nil {
@@ -40,12 +66,10 @@ func (sjwr *SplittingJsonlWriter) Add(item []byte) error {
 		}
 	}
 
-	if sjwr.writtenItems != 0 {
-		n, err := sjwr.wr.Write(newLineBytes)
+	if sjwr.writtenItems != 0 && !sjwr.terminate {
+		err := sjwr.writeNewLine()
 		if err != nil {
 			return err
-		} else if n != 1 {
-			return errors.New("failed to write newline")
 		}
 	}
 
@@ -61,35 +85,144 @@ func (sjwr *SplittingJsonlWriter) Add(item []byte) error {
 		written += n
 	}
 
-	sjwr.writtenItems++
-	sjwr.writtenBytes += uint64(len(item))
+	if sjwr.terminate {
+		err := sjwr.writeNewLine()
+		if err != nil {
+			return err
+		}
+	}
 
-	if sjwr.writtenBytes >= sjwr.splitSize {
-		err := sjwr.newWriter()
+	if sjwr.flushItems {
+		err := sjwr.flush()
 		if err != nil {
 			return err
 		}
 	}
 
+	sjwr.writtenItems++
+	sjwr.writtenBytes += uint64(len(item))
+	sjwr.records[len(sjwr.records)-1]++
+
+	// the next file is only created once there is another item to write to it, and a file which is full is synced as
+	// it is closed
+	if sjwr.full() {
+		return sjwr.Close()
+	}
+
+	if sjwr.syncInterval > 0 {
+		return sjwr.syncIfDue(time.Now())
+	}
+
+	return nil
+}
+
+// full reports whether the file being written has reached the number of items, or bytes, after which files are split
+func (sjwr *SplittingJsonlWriter) full() bool {
+	if sjwr.splitRecords > 0 {
+		return sjwr.writtenItems >= sjwr.splitRecords
+	}
+
+	return sjwr.writtenBytes >= sjwr.splitSize
+}
+
+// syncIfDue syncs the file being written if the interval has passed since it was last synced at now
+func (sjwr *SplittingJsonlWriter) syncIfDue(now time.Time) error {
+	if sjwr.wr == nil || sjwr.syncInterval <= 0 || now.Sub(sjwr.synced) < sjwr.syncInterval {
+		return nil
+	}
+
+	return sjwr.Sync()
+}
+
+// Sync flushes the items written to the current file and commits them to disk, when the file is a local file. It does
+// nothing when no file is open.
+func (sjwr *SplittingJsonlWriter) Sync() error {
+	if sjwr.wr == nil {
+		return nil
+	}
+
+	sjwr.synced = time.Now()
+
+	return syncWriter(sjwr.wr)
+}
+
+func (sjwr *SplittingJsonlWriter) writeNewLine() error {
+	n, err := sjwr.wr.Write(newLineBytes)
+	if err != nil {
+		return err
+	} else if n != 1 {
+		return errors.New("failed to write newline")
+	}
+
 	return nil
 }
 
-// Close closes the last stream making sure all the data has been flushed
+// ensureFile creates an empty file if no items have been added, so that empty lists are still written to a file
+func (sjwr *SplittingJsonlWriter) ensureFile() error {
+	if len(sjwr.records) != 0 {
+		return nil
+	}
+
+	return sjwr.newWriter()
+}
+
+// Files returns the number of files which have been created so far
+func (sjwr *SplittingJsonlWriter) Files() int {
+	return len(sjwr.records)
+}
+
+// Records returns the number of items which have been written to each of the files created so far
+func (sjwr *SplittingJsonlWriter) Records() []int64 {
+	return sjwr.records
+}
+
+// Close closes the last stream making sure all the data has been flushed, and synced to disk when files are synced
+// periodically. The stream is only closed once, even if closing it fails.
 func (sjwr *SplittingJsonlWriter) Close() error {
 	if sjwr.wr != nil {
-		err := sjwr.wr.Close()
-		if err != nil {
-			return err
+		var syncErr error
+		if sjwr.syncInterval > 0 {
+			syncErr = sjwr.Sync()
 		}
 
+		wr := sjwr.wr
 		sjwr.wr = nil
 		sjwr.writtenBytes = 0
 		sjwr.writtenItems = 0
+
+		err := wr.Close()
+		if syncErr != nil {
+			return syncErr
+		}
+
+		return err
 	}
 
 	return nil
 }
 
+// flush writes the items buffered by the current stream to its file
+func (sjwr *SplittingJsonlWriter) flush() error {
+	if fl, ok := sjwr.wr.(interface{ Flush() error }); ok {
+		return fl.Flush()
+	}
+
+	return nil
+}
+
+// resume restores the number of items written to each file before a split was interrupted. wr appends to the last file
+// when it was still being written, and is nil otherwise, in which case the next item is written to a new file.
+func (sjwr *SplittingJsonlWriter) resume(wr io.WriteCloser, records []int64, writtenBytes uint64) {
+	sjwr.records = append([]int64(nil), records...)
+	sjwr.wr = wr
+	sjwr.synced = time.Now()
+
+	if wr != nil {
+		sjwr.writtenItems = int(records[len(records)-1])
+		sjwr.writtenBytes = writtenBytes
+	}
+}
+
 func (sjwr *SplittingJsonlWriter) newWriter() error {
 	if sjwr.wr != nil {
 		err := sjwr.Close()
@@ -104,6 +237,8 @@ func (sjwr *SplittingJsonlWriter) newWriter() error {
 	}
 
 	sjwr.wr = newWr
+	sjwr.synced = time.Now()
+	sjwr.records = append(sjwr.records, 0)
 	sjwr.writtenItems = 0
 	sjwr.writtenBytes = 0
 
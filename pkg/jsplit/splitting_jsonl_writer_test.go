@@ -2,9 +2,12 @@ package jsplit
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -59,3 +62,143 @@ func TestSplittingJSONLWriter(t *testing.T) {
 		require.Equal(t, expectedVal, string(bs))
 	}
 }
+
+func TestSplittingJSONLWriterFiles(t *testing.T) {
+	var buffers []*BufWriteCloser
+
+	createWriter := func() (io.WriteCloser, error) {
+		buf := NewBufWriteCloser()
+		buffers = append(buffers, buf)
+		return buf, nil
+	}
+
+	wr := NewSplittingJsonlWriter(createWriter, 8)
+	require.Equal(t, 0, wr.Files())
+
+	// every item fills a file, but no empty file is left after the last one
+	for _, item := range []string{`"12345678"`, `"abcdefgh"`} {
+		require.NoError(t, wr.Add([]byte(item)))
+	}
+
+	require.NoError(t, wr.Close())
+	require.Equal(t, 2, wr.Files())
+	require.Len(t, buffers, 2)
+	require.Equal(t, `"12345678"`, buffers[0].String())
+	require.Equal(t, `"abcdefgh"`, buffers[1].String())
+}
+
+func TestSplittingJSONLWriterTerminate(t *testing.T) {
+	var buffers []*BufWriteCloser
+
+	createWriter := func() (io.WriteCloser, error) {
+		buf := NewBufWriteCloser()
+		buffers = append(buffers, buf)
+		return buf, nil
+	}
+
+	wr := NewSplittingJsonlWriter(createWriter, 16)
+	wr.terminate = true
+
+	for _, item := range []string{`{"idx":0}`, `{"idx":1}`, `{"idx":2}`} {
+		require.NoError(t, wr.Add([]byte(item)))
+	}
+
+	require.NoError(t, wr.Close())
+	require.Len(t, buffers, 2)
+	require.Equal(t, "{\"idx\":0}\n{\"idx\":1}\n", buffers[0].String())
+	require.Equal(t, "{\"idx\":2}\n", buffers[1].String())
+
+	// files are only created for empty lists when asked for
+	require.NoError(t, wr.ensureFile())
+	require.Len(t, buffers, 2)
+
+	buffers = nil
+	wr = NewSplittingJsonlWriter(createWriter, 16)
+	require.NoError(t, wr.ensureFile())
+	require.NoError(t, wr.Close())
+	require.Len(t, buffers, 1)
+	require.Equal(t, []int64{0}, wr.Records())
+	require.Empty(t, buffers[0].String())
+}
+
+// syncingWriteCloser is a BufWriteCloser counting the times it is synced
+type syncingWriteCloser struct {
+	*BufWriteCloser
+	syncs int
+}
+
+func (swc *syncingWriteCloser) Sync() error {
+	swc.syncs++
+	return nil
+}
+
+func TestSplittingJSONLWriterSync(t *testing.T) {
+	var files []*syncingWriteCloser
+
+	createWriter := func() (io.WriteCloser, error) {
+		swc := &syncingWriteCloser{BufWriteCloser: NewBufWriteCloser()}
+		files = append(files, swc)
+		return swc, nil
+	}
+
+	// files are synced once the interval has passed, but not just before being rolled over, when they are synced as
+	// they are closed
+	wr := NewSplittingJsonlWriter(createWriter, 16)
+	wr.syncInterval = time.Nanosecond
+
+	for _, item := range []string{`{"idx":0}`, `{"idx":1}`, `{"idx":2}`} {
+		time.Sleep(time.Millisecond)
+		require.NoError(t, wr.Add([]byte(item)))
+	}
+
+	require.Len(t, files, 2)
+	require.Equal(t, 2, files[0].syncs)
+	require.Equal(t, 1, files[1].syncs)
+
+	require.NoError(t, wr.Close())
+	require.Equal(t, 2, files[1].syncs)
+
+	// until the interval has passed they are only synced as they are closed
+	files = nil
+	wr = NewSplittingJsonlWriter(createWriter, 1024)
+	wr.syncInterval = time.Hour
+
+	for _, item := range []string{`{"idx":0}`, `{"idx":1}`} {
+		require.NoError(t, wr.Add([]byte(item)))
+	}
+
+	require.Zero(t, files[0].syncs)
+	require.NoError(t, wr.syncIfDue(time.Now().Add(2*time.Hour)))
+	require.Equal(t, 1, files[0].syncs)
+	require.NoError(t, wr.Close())
+	require.Equal(t, 2, files[0].syncs)
+
+	// and never without an interval
+	files = nil
+	wr = NewSplittingJsonlWriter(createWriter, 1024)
+	require.NoError(t, wr.Add([]byte(`{"idx":0}`)))
+	require.NoError(t, wr.Close())
+	require.Zero(t, files[0].syncs)
+}
+
+func TestSplitStreamFlushInterval(t *testing.T) {
+	const doc = `{"list": [{"idx": 0}, {"idx": 1}, {"idx": 2}], "other": [1, 2]}`
+
+	// syncing compressed files part way through leaves them readable
+	for _, compress := range []bool{false, true} {
+		tempDir := t.TempDir()
+		err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir,
+			Options{FlushInterval: time.Nanosecond, CompressOutput: compress})
+		require.NoError(t, err)
+
+		if compress {
+			requireGzipContents(t, filepath.Join(tempDir, "list_00.jsonl.gz"), "{\"idx\":0}\n{\"idx\":1}\n{\"idx\":2}")
+		} else {
+			requireContents(t, filepath.Join(tempDir, "list_00.jsonl"), "{\"idx\":0}\n{\"idx\":1}\n{\"idx\":2}")
+		}
+	}
+
+	err := SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), t.TempDir(),
+		Options{FlushInterval: -time.Second})
+	require.EqualError(t, err, "flush interval must not be negative, got -1s")
+}
@@ -0,0 +1,146 @@
+//go:build ssh
+
+package jsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// openSSHFile connects to the host of the target and opens its file over an SFTP session, returning it along with its
+// size. Closing the file closes the session and the connection, as does cancelling ctx while connecting.
+func openSSHFile(ctx context.Context, target sshTarget) (io.ReadCloser, int64, error) {
+	var knownHosts []string
+
+	for _, name := range target.knownHostFiles {
+		if _, err := os.Stat(name); err == nil {
+			knownHosts = append(knownHosts, name)
+		}
+	}
+
+	if knownHosts == nil {
+		return nil, 0, fmt.Errorf("no known_hosts file to check the key of %s against, looked for %s", target.host,
+			strings.Join(target.knownHostFiles, ", "))
+	}
+
+	hostKeys, err := knownhosts.New(knownHosts...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	signers, closeAgent := sshSigners(target)
+	defer closeAgent()
+
+	cfg := &ssh.ClientConfig{
+		User:            target.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(signers)},
+		HostKeyCallback: hostKeys,
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", target.addr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to connect to %s: %w", target.host, err)
+	}
+
+	// the handshake isn't given ctx, so the connection is closed if it is cancelled part way through
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	sc, chans, reqs, err := ssh.NewClientConn(conn, target.addr, cfg)
+	if err != nil {
+		_ = conn.Close()
+
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, 0, fmt.Errorf("%w: %s as %s, with the keys of the ssh agent or %s: %v", ErrSSHAuth,
+				target.host, target.user, strings.Join(target.identityFiles, ", "), err)
+		}
+
+		return nil, 0, fmt.Errorf("unable to connect to %s: %w", target.host, err)
+	}
+
+	client := ssh.NewClient(sc, chans, reqs)
+
+	session, err := sftp.NewClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, 0, fmt.Errorf("unable to start an sftp session on %s: %w", target.host, err)
+	}
+
+	f, err := session.Open(target.path)
+	if err == nil {
+		var fi os.FileInfo
+
+		fi, err = f.Stat()
+		if err == nil {
+			return &sshFile{Reader: f, closers: []io.Closer{f, session, client}}, fi.Size(), nil
+		}
+
+		_ = f.Close()
+	}
+
+	_ = session.Close()
+	_ = client.Close()
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("%s doesn't exist on %s: %w", target.path, target.host, err)
+	}
+
+	return nil, 0, fmt.Errorf("unable to open %s on %s: %w", target.path, target.host, err)
+}
+
+// sshSigners returns a function listing the keys to authenticate with, those of the ssh agent followed by those of the
+// identity files of the target which exist and aren't protected by a passphrase, along with a function closing the
+// connection to the agent. A single public key method is offered, as the client only tries the first of them.
+func sshSigners(target sshTarget) (func() ([]ssh.Signer, error), func()) {
+	var (
+		keys      agent.ExtendedAgent
+		closeConn = func() {}
+	)
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			keys = agent.NewClient(conn)
+			closeConn = func() { _ = conn.Close() }
+		}
+	}
+
+	signers := func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+
+		if keys != nil {
+			s, err := keys.Signers()
+			if err == nil {
+				signers = append(signers, s...)
+			}
+		}
+
+		for _, name := range target.identityFiles {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				continue
+			}
+
+			s, err := ssh.ParsePrivateKey(data)
+			if err == nil {
+				signers = append(signers, s)
+			}
+		}
+
+		return signers, nil
+	}
+
+	return signers, closeConn
+}
@@ -0,0 +1,15 @@
+//go:build !ssh
+
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// openSSHFile fails in builds without the ssh tag, which leave out the ssh libraries
+func openSSHFile(_ context.Context, target sshTarget) (io.ReadCloser, int64, error) {
+	return nil, 0, fmt.Errorf("reading %s on %s over ssh needs jsplit to be built with -tags ssh", target.path,
+		target.host)
+}
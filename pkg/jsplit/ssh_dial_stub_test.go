@@ -0,0 +1,14 @@
+//go:build !ssh
+
+package jsplit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncReaderFromSSHWithoutTag(t *testing.T) {
+	_, err := AsyncReaderFromFile("alex@bastion:/data/x.json", 1024)
+	require.EqualError(t, err, "reading /data/x.json on bastion over ssh needs jsplit to be built with -tags ssh")
+}
@@ -0,0 +1,295 @@
+package jsplit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// sshScheme is the prefix of the URIs read by AsyncReaderFromFile over ssh, e.g. ssh://alex@bastion:2222/data/x.json,
+// along with the user@host:/path form of scp
+const sshScheme = "ssh://"
+
+// scpPath matches the user@host: start of the remote paths of scp, which needs the user so that local file names
+// containing a colon aren't taken for hosts
+var scpPath = regexp.MustCompile(`^[^/@:]+@[^/:]+:`)
+
+// ErrSSHAuth is returned when none of the keys of the ssh agent or the identity files are accepted by the host
+var ErrSSHAuth = errors.New("ssh authentication failed")
+
+// isSSHPath reports whether uri names a file on a host reached over ssh
+func isSSHPath(uri string) bool {
+	return strings.HasPrefix(uri, sshScheme) || scpPath.MatchString(uri)
+}
+
+// sshTarget is where a file read over ssh is, with the entries of ~/.ssh/config for the host applied, see
+// resolveSSHTarget
+type sshTarget struct {
+	user string
+	host string // the name the host was given, which the entries of ~/.ssh/config and known_hosts are looked up by
+	addr string // the host:port connected to
+	path string
+
+	identityFiles  []string
+	knownHostFiles []string
+}
+
+// parseSSHPath splits a user@host:/path or ssh://user@host:port/path URI into its parts, leaving the port empty when
+// it isn't given. The path of the scp form is relative to the user's home directory unless it starts with /.
+func parseSSHPath(uri string) (userName, host, port, path string, err error) {
+	if strings.HasPrefix(uri, sshScheme) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return "", "", "", "", err
+		}
+
+		if u.Hostname() == "" || u.Path == "" || u.Path == "/" {
+			return "", "", "", "", fmt.Errorf("%s is not a ssh://user@host/path URI", uri)
+		}
+
+		return u.User.Username(), u.Hostname(), u.Port(), u.Path, nil
+	}
+
+	prefix := scpPath.FindString(uri)
+	if prefix == "" || len(prefix) == len(uri) {
+		return "", "", "", "", fmt.Errorf("%s is not a user@host:/path remote path", uri)
+	}
+
+	userName, host, _ = strings.Cut(strings.TrimSuffix(prefix, ":"), "@")
+
+	return userName, host, "", uri[len(prefix):], nil
+}
+
+// resolveSSHTarget returns where the file named by uri is, taking the user, host name, port and identity files not
+// given in uri from the entries for the host in the ssh config file at configPath, as ssh does, and defaulting to the
+// current user on port 22. The config file doesn't have to exist.
+func resolveSSHTarget(uri string, configPath string) (sshTarget, error) {
+	userName, host, port, path, err := parseSSHPath(uri)
+	if err != nil {
+		return sshTarget{}, err
+	}
+
+	cfg, err := readSSHConfig(configPath, host)
+	if err != nil {
+		return sshTarget{}, err
+	}
+
+	hostName := host
+	if cfg["hostname"] != nil {
+		hostName = cfg["hostname"][0]
+	}
+
+	if port == "" && cfg["port"] != nil {
+		port = cfg["port"][0]
+	}
+
+	if port == "" {
+		port = "22"
+	}
+
+	if userName == "" && cfg["user"] != nil {
+		userName = cfg["user"][0]
+	}
+
+	if userName == "" {
+		current, err := user.Current()
+		if err != nil {
+			return sshTarget{}, fmt.Errorf("no user to connect to %s as: %w", host, err)
+		}
+
+		userName = current.Username
+	}
+
+	target := sshTarget{user: userName, host: host, addr: hostName + ":" + port, path: path}
+	if strings.Contains(hostName, ":") {
+		target.addr = "[" + hostName + "]:" + port
+	}
+
+	home, _ := os.UserHomeDir()
+
+	target.identityFiles = expandHome(cfg["identityfile"], home)
+	if target.identityFiles == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			target.identityFiles = append(target.identityFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	target.knownHostFiles = expandHome(cfg["userknownhostsfile"], home)
+	if target.knownHostFiles == nil {
+		target.knownHostFiles = []string{filepath.Join(home, ".ssh", "known_hosts")}
+	}
+
+	return target, nil
+}
+
+// expandHome replaces the ~ starting any of the paths with the home directory
+func expandHome(paths []string, home string) []string {
+	var expanded []string
+
+	for _, p := range paths {
+		if p == "~" || strings.HasPrefix(p, "~/") {
+			p = filepath.Join(home, p[1:])
+		}
+
+		expanded = append(expanded, p)
+	}
+
+	return expanded
+}
+
+// readSSHConfig returns the values of the entries of the ssh config file at path applying to host, keyed by their
+// lower case keywords. As with ssh the first value of a keyword wins, apart from IdentityFile and UserKnownHostsFile
+// whose values all apply. Host blocks are matched with their patterns, including negated ones, while Match blocks,
+// which need more than the host name to evaluate, are skipped. A missing file has no entries.
+func readSSHConfig(path string, host string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string][]string)
+	applies := true // entries before the first Host line apply to every host
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// a keyword is separated from its value by spaces or an =
+		keyword, value := line, ""
+		if i := strings.IndexAny(line, " \t="); i >= 0 {
+			keyword, value = line[:i], strings.TrimLeft(line[i:], " \t=")
+		}
+
+		keyword = strings.ToLower(keyword)
+		value = strings.Trim(value, `"`)
+
+		switch keyword {
+		case "host":
+			applies = sshHostMatches(strings.Fields(value), host)
+			continue
+		case "match":
+			applies = false
+			continue
+		}
+
+		if !applies {
+			continue
+		}
+
+		if keyword == "identityfile" || keyword == "userknownhostsfile" || values[keyword] == nil {
+			values[keyword] = append(values[keyword], value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// sshHostMatches reports whether host matches any of the patterns of a Host line without matching any negated one
+func sshHostMatches(patterns []string, host string) bool {
+	var matched bool
+
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+
+		ok, _ := filepath.Match(strings.TrimPrefix(pattern, "!"), host)
+		if ok && negated {
+			return false
+		}
+
+		matched = matched || ok && !negated
+	}
+
+	return matched
+}
+
+// AsyncReaderFromSSH creates an AsyncReader for reading a file on a host reached over ssh, given a user@host:/path
+// remote path, as scp takes, or a ssh://user@host:port/path URI. The file is opened straight away over an SFTP session,
+// authenticating with the keys of the ssh agent and the identity files, and checking the host's key against
+// known_hosts, with the user, host name, port and files set for the host in ~/.ssh/config applied. Compressed files
+// are detected from their first bytes. Reading over ssh needs jsplit to be built with -tags ssh, so that the ssh
+// libraries are only compiled in when they are wanted. A remote file is read once, so it can't be read from an offset
+// or followed.
+func AsyncReaderFromSSH(uri string, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if afr.byteRange != nil || afr.follow != nil {
+		return nil, fmt.Errorf("the remote file %s can only be read once, from its start", uri)
+	}
+
+	r, size, err := openSSHPath(context.Background(), uri)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = afr.attachSource(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	afr.seekable = false
+	afr.abort = func() {
+		_ = r.Close()
+	}
+
+	return afr, nil
+}
+
+// openSSHPath opens the file on a host reached over ssh named by uri, returning it along with its size, with the
+// entries of ~/.ssh/config for the host applied
+func openSSHPath(ctx context.Context, uri string) (io.ReadCloser, int64, error) {
+	home, _ := os.UserHomeDir()
+
+	target, err := resolveSSHTarget(uri, filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return openSSHFile(ctx, target)
+}
+
+// sshFile is a file opened over an SFTP session, which closes the session and the connection it was opened over
+// along with the file. It is closed once, whether reading was aborted or finished.
+type sshFile struct {
+	io.Reader
+	closers []io.Closer
+	once    sync.Once
+	err     error
+}
+
+func (sf *sshFile) Close() error {
+	sf.once.Do(func() {
+		var errs []error
+
+		for _, c := range sf.closers {
+			errs = append(errs, c.Close())
+		}
+
+		sf.err = errors.Join(errs...)
+	})
+
+	return sf.err
+}
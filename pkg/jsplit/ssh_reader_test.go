@@ -0,0 +1,102 @@
+package jsplit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSSHPath(t *testing.T) {
+	for uri, expected := range map[string]bool{
+		"alex@bastion:/data/x.json":           true,
+		"alex@bastion:x.json":                 true,
+		"ssh://bastion:2222/data/x.json":      true,
+		"bastion:/data/x.json":                false,
+		"data/alex@bastion:x.json":            false,
+		"C:/data/x.json":                      false,
+		"https://alex@example.com:8080/x":     false,
+		"s3://bucket/alex@bastion:x.json":     false,
+		"unix:///run/producer.sock":           false,
+		"/data/alex@bastion:/x.json":          false,
+		"alex@bastion.example.com:/x.json.gz": true,
+	} {
+		require.Equal(t, expected, isSSHPath(uri), uri)
+	}
+}
+
+func TestParseSSHPath(t *testing.T) {
+	userName, host, port, path, err := parseSSHPath("alex@bastion:/data/x.json")
+	require.NoError(t, err)
+	require.Equal(t, []string{"alex", "bastion", "", "/data/x.json"}, []string{userName, host, port, path})
+
+	userName, host, port, path, err = parseSSHPath("ssh://alex@bastion:2222/data/x.json")
+	require.NoError(t, err)
+	require.Equal(t, []string{"alex", "bastion", "2222", "/data/x.json"}, []string{userName, host, port, path})
+
+	_, _, _, _, err = parseSSHPath("alex@bastion:")
+	require.EqualError(t, err, "alex@bastion: is not a user@host:/path remote path")
+
+	_, _, _, _, err = parseSSHPath("ssh://bastion/")
+	require.EqualError(t, err, "ssh://bastion/ is not a ssh://user@host/path URI")
+}
+
+func TestResolveSSHTarget(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	config := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(config, []byte(`# the bastion
+Host bastion *.internal !skip.internal
+    HostName 10.0.0.5
+    User = ops
+    Port 2222
+    IdentityFile ~/.ssh/bastion_key
+    IdentityFile "/keys/shared key"
+
+Match host bastion
+    User matched
+
+Host *
+    User everyone
+    Port 22
+    IdentityFile ~/.ssh/id_default
+`), 0o644))
+
+	// the values of the first matching entries win, apart from the identity files which all apply
+	target, err := resolveSSHTarget("ssh://bastion/data/x.json", config)
+	require.NoError(t, err)
+	require.Equal(t, sshTarget{user: "ops", host: "bastion", addr: "10.0.0.5:2222", path: "/data/x.json",
+		identityFiles: []string{filepath.Join(home, ".ssh/bastion_key"), "/keys/shared key",
+			filepath.Join(home, ".ssh/id_default")},
+		knownHostFiles: []string{filepath.Join(home, ".ssh/known_hosts")}}, target)
+
+	// the user and port of the path override those of the config
+	target, err = resolveSSHTarget("ssh://alex@db.internal:2200/x.json", config)
+	require.NoError(t, err)
+	require.Equal(t, "alex", target.user)
+	require.Equal(t, "10.0.0.5:2200", target.addr)
+
+	// negated patterns exclude a host from an entry
+	target, err = resolveSSHTarget("ssh://skip.internal/x.json", config)
+	require.NoError(t, err)
+	require.Equal(t, "everyone", target.user)
+	require.Equal(t, "skip.internal:22", target.addr)
+	require.Equal(t, []string{filepath.Join(home, ".ssh/id_default")}, target.identityFiles)
+
+	// without a config file the defaults are used
+	target, err = resolveSSHTarget("alex@other:x.json", filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	require.Equal(t, "other:22", target.addr)
+	require.Equal(t, "x.json", target.path)
+	require.Len(t, target.identityFiles, 3)
+}
+
+func TestAsyncReaderFromSSHReadOnce(t *testing.T) {
+	_, err := AsyncReaderFromFile("alex@bastion:/data/x.json", 1024, WithByteRange(10, 20))
+	require.EqualError(t, err, "a byte range can't be read from alex@bastion:/data/x.json")
+
+	_, err = AsyncReaderFromSSH("alex@bastion:/data/x.json", 1024, WithByteRange(10, 20))
+	require.EqualError(t, err, "the remote file alex@bastion:/data/x.json can only be read once, from its start")
+}
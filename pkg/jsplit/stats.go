@@ -0,0 +1,195 @@
+package jsplit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStatsInterval is how often Options.Stats is called when Options.StatsInterval isn't set
+const DefaultStatsInterval = time.Second
+
+// Stats is a snapshot of the progress of a split, passed to Options.Stats
+type Stats struct {
+	// BytesRead is the number of bytes of input read so far. It is only known when the input is read by an
+	// AsyncReader, and is 0 otherwise.
+	BytesRead int64
+
+	// TotalBytes is the number of bytes the input holds, when it is read by an AsyncReader which knows its size, see
+	// AsyncReader.TotalSize, and 0 otherwise
+	TotalBytes int64
+
+	// InFlightBytes is the number of bytes which had been read by the AsyncReader but not yet parsed, and
+	// PeakInFlightBytes the most there have been at once. A peak close to the limit set with WithMaxInFlightBytes, or
+	// to the queue's depth in chunks, shows that splitting can't keep up with reading, as when uploads are slow. They
+	// are 0 when the input isn't read by an AsyncReader.
+	InFlightBytes     int64
+	PeakInFlightBytes int64
+
+	// MemoryBytes is the number of bytes held in memory by the split, counted as described by Options.MaxMemory, and
+	// PeakMemoryBytes the most there have been at once. They are 0 when Options.MaxMemory isn't set.
+	MemoryBytes     int64
+	PeakMemoryBytes int64
+
+	// Records is the number of list items parsed so far, across every list
+	Records int64
+
+	// Keys is the number of items parsed so far from each list, by key. Lists appear once their first item has been
+	// parsed, so empty lists and keys which aren't lists are left out. The items of a document which is a json array
+	// are counted under the key naming its files, or DefaultRootListKey when they are sharded.
+	Keys map[string]int64
+
+	// Elapsed is the time since the split started
+	Elapsed time.Duration
+}
+
+// keyCount counts the items parsed from one list
+type keyCount struct {
+	records int64 // accessed atomically so kept first to guarantee 64-bit alignment
+	key     string
+}
+
+// statsReporter counts the items parsed during a split, calling Options.Stats with the counts every
+// Options.StatsInterval from its own goroutine. The counters are updated atomically as items are parsed, so reporting
+// never holds up parsing.
+type statsReporter struct {
+	records  int64 // accessed atomically so kept first to guarantee 64-bit alignment
+	source   interface{ BytesRead() int64 }
+	total    int64
+	queue    queueCounter
+	memory   *memoryBudget
+	callback func(Stats)
+	interval time.Duration
+	start    time.Time
+	mu       sync.Mutex
+	keys     []*keyCount
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// queueCounter counts the bytes an AsyncReader has queued waiting to be parsed
+type queueCounter interface {
+	InFlightBytes() int64
+	PeakInFlightBytes() int64
+}
+
+// newStatsReporter returns a *statsReporter reporting the progress of reading rd if opts.Stats is set, and nil
+// otherwise. rd is usually the ByteStream being split, and only needs the methods reporting how much of it has been
+// read. Reporting starts straight away.
+func newStatsReporter(rd interface{}, opts Options) *statsReporter {
+	if opts.Stats == nil {
+		return nil
+	}
+
+	sr := &statsReporter{
+		memory:   opts.budget,
+		callback: opts.Stats,
+		interval: opts.statsInterval(),
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if source, ok := rd.(interface{ BytesRead() int64 }); ok {
+		sr.source = source
+	}
+
+	if sized, ok := rd.(interface{ TotalSize() int64 }); ok && sized.TotalSize() > 0 {
+		sr.total = sized.TotalSize()
+	}
+
+	if queue, ok := rd.(queueCounter); ok {
+		sr.queue = queue
+	}
+
+	go sr.run()
+
+	return sr
+}
+
+// run calls the callback every interval until the reporter is closed
+func (sr *statsReporter) run() {
+	defer close(sr.done)
+
+	ticker := time.NewTicker(sr.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sr.callback(sr.snapshot())
+		case <-sr.stop:
+			return
+		}
+	}
+}
+
+// count returns a ListAddFunc counting the items of the list with the given key before passing them to add, or add
+// itself if stats aren't being reported
+func (sr *statsReporter) count(key string, add ListAddFunc) ListAddFunc {
+	if sr == nil {
+		return add
+	}
+
+	var kc *keyCount
+
+	return func(item []byte) error {
+		// values which aren't lists never call add, so keys are only registered once they have an item
+		if kc == nil {
+			kc = &keyCount{key: key}
+
+			sr.mu.Lock()
+			sr.keys = append(sr.keys, kc)
+			sr.mu.Unlock()
+		}
+
+		atomic.AddInt64(&kc.records, 1)
+		atomic.AddInt64(&sr.records, 1)
+
+		return add(item)
+	}
+}
+
+// snapshot returns the current counts
+func (sr *statsReporter) snapshot() Stats {
+	stats := Stats{
+		Records:    atomic.LoadInt64(&sr.records),
+		TotalBytes: sr.total,
+		Keys:       make(map[string]int64),
+		Elapsed:    time.Since(sr.start),
+	}
+
+	if sr.source != nil {
+		stats.BytesRead = sr.source.BytesRead()
+	}
+
+	if sr.queue != nil {
+		stats.InFlightBytes = sr.queue.InFlightBytes()
+		stats.PeakInFlightBytes = sr.queue.PeakInFlightBytes()
+	}
+
+	stats.MemoryBytes = sr.memory.MemoryBytes()
+	stats.PeakMemoryBytes = sr.memory.PeakMemoryBytes()
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	// a key which appears more than once in the document is counted as a single list
+	for _, kc := range sr.keys {
+		stats.Keys[kc.key] += atomic.LoadInt64(&kc.records)
+	}
+
+	return stats
+}
+
+// close stops reporting, calling the callback a final time with the totals once any call in progress has returned
+func (sr *statsReporter) close() {
+	if sr == nil {
+		return
+	}
+
+	close(sr.stop)
+	<-sr.done
+
+	sr.callback(sr.snapshot())
+}
@@ -0,0 +1,112 @@
+package jsplit
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStats(t *testing.T) {
+	doc := `{"key": "value", "list": [{"idx": 0}, {"idx": 1}, {"idx": 2}], "other": [{"idx": 3}], "empty": []}`
+
+	rd, err := AsyncReaderFromReader(strings.NewReader(doc), 16)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	var (
+		mu    sync.Mutex
+		calls []Stats
+	)
+
+	opts := Options{
+		StatsInterval: time.Millisecond,
+		Stats: func(stats Stats) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			calls = append(calls, stats)
+		},
+	}
+
+	require.NoError(t, Split(context.Background(), rd, newMemSink(), opts))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// the last call has the totals
+	require.NotEmpty(t, calls)
+	last := calls[len(calls)-1]
+	require.Equal(t, int64(len(doc)), last.BytesRead)
+	require.Zero(t, last.TotalBytes, "the size of a reader isn't known")
+	require.Zero(t, last.InFlightBytes)
+	require.Greater(t, last.PeakInFlightBytes, int64(0))
+	require.LessOrEqual(t, last.PeakInFlightBytes, int64(len(doc)))
+	require.Equal(t, int64(4), last.Records)
+	require.Equal(t, map[string]int64{"list": 3, "other": 1}, last.Keys)
+
+	for i := 1; i < len(calls); i++ {
+		require.GreaterOrEqual(t, calls[i].Records, calls[i-1].Records)
+		require.GreaterOrEqual(t, calls[i].BytesRead, calls[i-1].BytesRead)
+	}
+}
+
+func TestSplitStatsRootList(t *testing.T) {
+	for _, shards := range []int{0, 2} {
+		var last Stats
+
+		opts := Options{Shards: shards, Stats: func(stats Stats) { last = stats }}
+
+		bs := NewTestByteStream([]byte(`[{"idx": 0}, {"idx": 1}, {"idx": 2}]`), 8)
+		require.NoError(t, splitStream(context.Background(), bs, newMemSink(), opts))
+
+		// the byte stream doesn't report how much has been read
+		require.Zero(t, last.BytesRead)
+		require.Zero(t, last.PeakInFlightBytes)
+		require.Equal(t, int64(3), last.Records)
+		require.Equal(t, map[string]int64{DefaultRootListKey: 3}, last.Keys)
+	}
+}
+
+func TestStatsReporter(t *testing.T) {
+	require.Nil(t, newStatsReporter(nil, Options{}))
+
+	// a nil reporter passes items straight through
+	var sr *statsReporter
+	add := func(item []byte) error { return nil }
+	require.NotNil(t, sr.count("list", add))
+	sr.close()
+
+	calls := make(chan Stats, 16)
+	sr = newStatsReporter(nil, Options{StatsInterval: time.Millisecond, Stats: func(stats Stats) {
+		select {
+		case calls <- stats:
+		default:
+		}
+	}})
+
+	// the same key appearing twice in a document is reported as one list
+	first := sr.count("list", add)
+	second := sr.count("list", add)
+	require.NoError(t, first([]byte(`1`)))
+	require.NoError(t, second([]byte(`2`)))
+
+	stats := <-calls
+	require.LessOrEqual(t, stats.Records, int64(2))
+
+	sr.close()
+
+	for len(calls) > 0 {
+		stats = <-calls
+	}
+
+	require.Equal(t, int64(2), stats.Records)
+	require.Equal(t, map[string]int64{"list": 2}, stats.Keys)
+}
+
+func TestValidateStatsInterval(t *testing.T) {
+	require.Error(t, Options{StatsInterval: -time.Second}.validate())
+}
@@ -0,0 +1,124 @@
+package jsplit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// streamSink is an OutputSink writing the items of a single list, or of every list when merging them, to a stream
+// rather than to files
+type streamSink struct {
+	mu    sync.Mutex
+	w     io.Writer
+	key   string
+	merge bool
+}
+
+// NewStreamSink returns an OutputSink writing the items of a list to w one after another, as newline terminated json,
+// for piping the items of one key of a document into another program. Each item is written to w as soon as it has been
+// parsed, and w is never closed. Only one list can be streamed, so a split of an object should select its key with
+// Options.IncludeKeys, and fails if a second list is found. Every other file, root.json and the manifest included, is
+// discarded. The messages Split prints as it goes are written to os.Stdout, so w shouldn't be os.Stdout unless
+// os.Stdout is redirected first.
+func NewStreamSink(w io.Writer) OutputSink {
+	return &streamSink{w: w}
+}
+
+// NewMergeSink returns an OutputSink writing the items of every list to w, as NewStreamSink does for a single list, so
+// that the lists of a document are merged into one stream. Options.KeyField adds the key of its list to each object,
+// and Options.IncludeKeys and Options.ExcludeKeys select the lists merged. Items are written to w whole, one at a time,
+// so the items of lists written at once are interleaved but never mixed. Every other file, root.json and the manifest
+// included, is discarded.
+func NewMergeSink(w io.Writer) OutputSink {
+	return &streamSink{w: w, merge: true}
+}
+
+// OpenKey discards the files which aren't written for lists
+func (ss *streamSink) OpenKey(string) (io.WriteCloser, error) {
+	return discardWriteCloser{}, nil
+}
+
+// openList returns a writer passing the items of the file with the given name, written for key, to the stream. Each
+// list can be written to a series of files, but a second list fails unless the lists are being merged.
+func (ss *streamSink) openList(key, _ string) (io.WriteCloser, error) {
+	if ss.merge {
+		return &mergeWriteCloser{ss: ss}, nil
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.key == "" {
+		ss.key = key
+	} else if key != ss.key {
+		return nil, fmt.Errorf("only one list can be streamed, found %s after %s", key, ss.key)
+	}
+
+	return streamWriteCloser{ss.w}, nil
+}
+
+// String describes the stream
+func (ss *streamSink) String() string {
+	return "the output stream"
+}
+
+// streamWriteCloser writes to the stream, which is left open when it is closed
+type streamWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing, leaving the stream open for the files which follow
+func (streamWriteCloser) Close() error {
+	return nil
+}
+
+// mergeWriteCloser writes the items of a list to a merged stream. The bytes written are held until they end with the
+// newline terminating an item, so that the items of lists written at once don't get mixed up.
+type mergeWriteCloser struct {
+	ss  *streamSink
+	buf []byte
+}
+
+// Write writes the items which have been written whole to the stream
+func (mw *mergeWriteCloser) Write(p []byte) (int, error) {
+	mw.buf = append(mw.buf, p...)
+	if !bytes.HasSuffix(mw.buf, []byte{LF}) {
+		return len(p), nil
+	}
+
+	err := mw.flush()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// flush writes the bytes held to the stream
+func (mw *mergeWriteCloser) flush() error {
+	if len(mw.buf) == 0 {
+		return nil
+	}
+
+	mw.ss.mu.Lock()
+	defer mw.ss.mu.Unlock()
+
+	_, err := mw.ss.w.Write(mw.buf)
+	mw.buf = mw.buf[:0]
+
+	return err
+}
+
+// Close writes anything still held to the stream, which is left open for the files which follow
+func (mw *mergeWriteCloser) Close() error {
+	return mw.flush()
+}
+
+// streams reports whether the sink writes the items of lists to a stream, in which case each item is terminated by a
+// newline and written as soon as it has been parsed
+func streams(sink OutputSink) bool {
+	_, ok := sink.(*streamSink)
+	return ok
+}
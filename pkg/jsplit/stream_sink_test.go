@@ -0,0 +1,168 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStreamToStreamSink(t *testing.T) {
+	const doc = `{"name": "example", "users": [{"id": 1}, {"id": 2}, {"id": 3}], "groups": [{"id": "admin"}]}`
+
+	// only the selected list reaches the stream, terminated by newlines even where it is split into several files
+	var buf bytes.Buffer
+
+	bs := NewTestByteStream([]byte(doc), 8)
+	opts := Options{IncludeKeys: []string{"users"}, MaxFileBytes: 10}
+	require.NoError(t, splitStream(context.Background(), bs, NewStreamSink(&buf), opts))
+	require.Equal(t, "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n", buf.String())
+
+	// the elements of a document which is a json array are streamed
+	buf.Reset()
+
+	bs = NewTestByteStream([]byte(`[1, [2], {"a": 3}]`), 4)
+	require.NoError(t, splitStream(context.Background(), bs, NewStreamSink(&buf), Options{Pretty: true}))
+	require.Equal(t, "1\n[\n  2\n]\n{\n  \"a\": 3\n}\n", buf.String())
+
+	// a second list can't be streamed after the first
+	buf.Reset()
+
+	bs = NewTestByteStream([]byte(doc), 8)
+	err := splitStream(context.Background(), bs, NewStreamSink(&buf), Options{})
+	require.EqualError(t, err, "only one list can be streamed, found groups after users")
+}
+
+func TestSplitStreamToMergeSink(t *testing.T) {
+	const doc = `{"name": "example", "users": [{"id": 1}, {"id": 2}], "groups": [{"id": "admin"}, {}, "ops"],
+		"tags": ["a"]}`
+
+	// the items of every list reach the stream, led by their keys
+	var buf bytes.Buffer
+
+	bs := NewTestByteStream([]byte(doc), 8)
+	opts := Options{KeyField: DefaultKeyField, ExcludeKeys: []string{"tags"}, MaxFileBytes: 10}
+	require.NoError(t, splitStream(context.Background(), bs, NewMergeSink(&buf), opts))
+	require.Equal(t, `{"_key":"users","id":1}`+"\n"+`{"_key":"users","id":2}`+"\n"+
+		`{"_key":"groups","id":"admin"}`+"\n"+`{"_key":"groups"}`+"\n"+`"ops"`+"\n", buf.String())
+
+	// lists written at once are interleaved, but each item is written whole
+	var items []string
+
+	for i := 0; i < 200; i++ {
+		items = append(items, fmt.Sprintf(`{"id": %d, "pad": "%s"}`, i, strings.Repeat("x", i)))
+	}
+
+	buf.Reset()
+
+	bs = NewTestByteStream([]byte(`{"a": [`+strings.Join(items, ", ")+`], "b": [`+strings.Join(items, ", ")+`]}`), 64)
+	opts = Options{KeyField: "k", Concurrency: 2, IncludeKeys: []string{"a", "b"}}
+	require.NoError(t, splitStream(context.Background(), bs, NewMergeSink(&buf), opts))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 400)
+
+	next := map[string]int{"a": 0, "b": 0}
+
+	for _, line := range lines {
+		var item struct {
+			K  string `json:"k"`
+			ID int    `json:"id"`
+		}
+
+		require.NoError(t, json.Unmarshal([]byte(line), &item), line)
+		require.Equal(t, next[item.K], item.ID)
+
+		next[item.K]++
+	}
+
+	require.Equal(t, map[string]int{"a": 200, "b": 200}, next)
+}
+
+// gatedStream returns the first part of a document, and only the rest once it has been released
+type gatedStream struct {
+	first, rest []byte
+	release     chan struct{}
+	read        int
+}
+
+func (gs *gatedStream) Read(ctx context.Context) ([]byte, error) {
+	gs.read++
+
+	switch gs.read {
+	case 1:
+		return gs.first, nil
+	case 2:
+		select {
+		case <-gs.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		return gs.rest, nil
+	}
+
+	return nil, io.EOF
+}
+
+// notifyingWriter records what has been written to it, signalling each write
+type notifyingWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	written chan struct{}
+}
+
+func (nw *notifyingWriter) Write(p []byte) (int, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	nw.buf.Write(p)
+
+	select {
+	case nw.written <- struct{}{}:
+	default:
+	}
+
+	return len(p), nil
+}
+
+func (nw *notifyingWriter) String() string {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	return nw.buf.String()
+}
+
+func TestStreamSinkFlushesItems(t *testing.T) {
+	stream := &gatedStream{
+		first:   []byte(`{"users": [{"id": 1}, `),
+		rest:    []byte(`{"id": 2}]}`),
+		release: make(chan struct{}),
+	}
+	w := &notifyingWriter{written: make(chan struct{}, 1)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- splitStream(context.Background(), stream, NewStreamSink(w), Options{})
+	}()
+
+	// the first item reaches the stream while the rest of the document is still to come
+	select {
+	case <-w.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first item wasn't written before the rest of the document was read")
+	}
+
+	require.Equal(t, "{\"id\":1}\n", w.String())
+
+	close(stream.release)
+	require.NoError(t, <-done)
+	require.Equal(t, "{\"id\":1}\n{\"id\":2}\n", w.String())
+}
@@ -0,0 +1,87 @@
+package jsplit
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// isTarGz reports whether uri names a gzip compressed tar archive, from its .tar.gz or .tgz extension
+func isTarGz(uri string) bool {
+	name := strings.ToLower(uriPath(uri))
+
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// tarMemberName returns the name of a member of a tar archive to split, without any leading ./ or /, or false if the
+// member isn't a .json file. Directories, links and the ._ files macOS adds alongside the files it archives are
+// skipped.
+func tarMemberName(hdr *tar.Header) (string, bool) {
+	if hdr.Typeflag != tar.TypeReg {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(path.Clean(hdr.Name), "/")
+	if !strings.EqualFold(path.Ext(name), ".json") || strings.HasPrefix(path.Base(name), "._") {
+		return "", false
+	}
+
+	return name, true
+}
+
+// splitTarGz splits each .json member of the gzip compressed tar archive filename as its own document, in the order
+// they are stored, into a directory of outputPath named after it, in the same way as the entries of a zip archive.
+// Tar archives are read as a stream, so archives at http(s) URLs and in cloud storage aren't downloaded first. Errors
+// name the member which was being split.
+func splitTarGz(ctx context.Context, filename, outputPath string, opts Options) error {
+	rd, err := AsyncReaderFromFile(filename, 1024*1024, opts.ReaderOptions...)
+	if err != nil {
+		return err
+	}
+
+	// stops reading if splitting fails part way through
+	defer rd.Close()
+
+	logger().Info("Reading "+filename, "input", filename)
+
+	tr := tar.NewReader(rd.AsReader(rd.Start(ctx)))
+	parent := &dirSink{ctx: ctx, dir: outputPath}
+	members := 0
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", filename, err)
+		}
+
+		name, ok := tarMemberName(hdr)
+		if !ok {
+			continue
+		}
+
+		members++
+		opts.source = filename + "/" + name
+
+		dir, err := entryDir(name)
+		if err == nil {
+			err = splitEntry(ctx, tr, name, parent.path(dir), opts)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", filename, name, err)
+		}
+	}
+
+	if members == 0 {
+		return fmt.Errorf("no .json members in %s", filename)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package jsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tarGzBytes returns a gzip compressed tar archive holding the members, in order. Names ending in / are directories.
+func tarGzBytes(t *testing.T, members ...[2]string) []byte {
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+
+	for _, member := range members {
+		hdr := &tar.Header{Name: member[0], Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(member[1]))}
+		if strings.HasSuffix(member[0], "/") {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		}
+
+		require.NoError(t, tw.WriteHeader(hdr))
+
+		_, err := tw.Write([]byte(member[1]))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+
+	return gzipBytes(t, buf.Bytes())
+}
+
+func TestIsTarGz(t *testing.T) {
+	require.True(t, isTarGz("export.tar.gz"))
+	require.True(t, isTarGz("/exports/EXPORT.TGZ"))
+	require.True(t, isTarGz("gs://bucket/export.tgz"))
+	require.True(t, isTarGz("https://example.com/export.tar.gz?token=abc"))
+	require.False(t, isTarGz("export.json.gz"))
+	require.False(t, isTarGz("export.tar"))
+	require.False(t, isTarGz("https://example.com/data.json?name=a.tgz"))
+}
+
+func TestSplitFileTarGz(t *testing.T) {
+	data := tarGzBytes(t,
+		[2]string{"./", ""},
+		[2]string{"./users.json", `{"name": "users", "users": [{"id": 1}, {"id": 2}]}`},
+		[2]string{"./tables/", ""},
+		[2]string{"./tables/groups.JSON", `[{"id": "admin"}]`},
+		[2]string{"./tables/._groups.json", "\x00\x05\x16\x07"},
+		[2]string{"./README.txt", "not json"},
+	)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "export.tar.gz")
+	require.NoError(t, os.WriteFile(input, data, 0o644))
+
+	// each member is split into its own directory, and the directories and members which aren't json are skipped
+	outputDir := filepath.Join(dir, "output")
+	require.NoError(t, SplitFile(context.Background(), input, outputDir, Options{SourceField: "_source"}))
+
+	requireContents(t, filepath.Join(outputDir, "users", "users_00.jsonl"),
+		fmt.Sprintf(`{"id":1,"_source":"%[1]s/users.json"}`+"\n"+`{"id":2,"_source":"%[1]s/users.json"}`, input))
+	requireContents(t, filepath.Join(outputDir, "users", "root.json"), "{\n\t\"name\":\"users\"\n}")
+	requireContents(t, filepath.Join(outputDir, "tables%2Fgroups", "root_00.jsonl"),
+		fmt.Sprintf(`{"id":"admin","_source":"%s/tables/groups.JSON"}`, input))
+	require.FileExists(t, filepath.Join(outputDir, "tables%2Fgroups", ManifestFilename))
+
+	entries, err := os.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// archives served over http are streamed without being downloaded first
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	outputDir = filepath.Join(dir, "http")
+	tempDir := t.TempDir()
+	require.NoError(t, SplitFile(context.Background(), srv.URL+"/export.tgz", outputDir, Options{TempDir: tempDir}))
+	requireContents(t, filepath.Join(outputDir, "users", "users_00.jsonl"), "{\"id\":1}\n{\"id\":2}")
+	requireEmptyDir(t, tempDir)
+}
+
+func TestSplitFileTarGzErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	// errors name the member being split
+	input := filepath.Join(dir, "bad.tar.gz")
+	data := tarGzBytes(t, [2]string{"good.json", `{"a": [1]}`}, [2]string{"bad.json", `{"a": [1, `})
+	require.NoError(t, os.WriteFile(input, data, 0o644))
+
+	err := SplitFile(context.Background(), input, filepath.Join(dir, "bad"), Options{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), input+"/bad.json: ")
+	require.FileExists(t, filepath.Join(dir, "bad", "good", ManifestFilename))
+
+	// members whose names leave no directory name, or one referring to the output or its parent, are refused
+	for name, memberDir := range map[string]string{"...json": "..", ".json": ""} {
+		input = filepath.Join(dir, "dots.tar.gz")
+		data = tarGzBytes(t, [2]string{"good.json", `{"a": [1]}`}, [2]string{name, `{"b": [1]}`})
+		require.NoError(t, os.WriteFile(input, data, 0o644))
+
+		outputDir := filepath.Join(dir, "dots", "output")
+		err = SplitFile(context.Background(), input, outputDir, Options{})
+		require.EqualError(t, err, fmt.Sprintf("%s/%s: the entry can't be split into a directory named %q", input, name,
+			memberDir))
+		require.NoFileExists(t, filepath.Join(dir, "dots", "b_00.jsonl"))
+		require.NoFileExists(t, filepath.Join(outputDir, "b_00.jsonl"))
+		require.NoError(t, os.RemoveAll(filepath.Join(dir, "dots")))
+	}
+
+	input = filepath.Join(dir, "empty.tgz")
+	require.NoError(t, os.WriteFile(input, tarGzBytes(t, [2]string{"notes.txt", "{}"}), 0o644))
+
+	err = SplitFile(context.Background(), input, filepath.Join(dir, "empty"), Options{})
+	require.EqualError(t, err, fmt.Sprintf("no .json members in %s", input))
+
+	// archives which aren't tar archives or are cut short fail
+	input = filepath.Join(dir, "invalid.tar.gz")
+	require.NoError(t, os.WriteFile(input, gzipBytes(t, []byte(`{"a": [1]}`)), 0o644))
+	require.Error(t, SplitFile(context.Background(), input, filepath.Join(dir, "invalid"), Options{}))
+
+	input = filepath.Join(dir, "truncated.tar.gz")
+	require.NoError(t, os.WriteFile(input, data[:len(data)/2], 0o644))
+	require.Error(t, SplitFile(context.Background(), input, filepath.Join(dir, "truncated"), Options{}))
+
+	// and tar archives can't be split along with other files
+	err = SplitFiles(context.Background(), []string{filepath.Join(dir, "bad.tar.gz")}, filepath.Join(dir, "files"),
+		Options{})
+	require.ErrorContains(t, err, "tar archives can't be split along with other files")
+}
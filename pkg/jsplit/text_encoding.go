@@ -0,0 +1,81 @@
+package jsplit
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var (
+	// utf8BOM is the byte order mark some tools write at the start of UTF-8 text
+	utf8BOM = []byte{0xef, 0xbb, 0xbf}
+	// utf16LEBOM and utf16BEBOM are the byte order marks starting little and big endian UTF-16 text
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// decodeText peeks at the first bytes of rd for a byte order mark. A UTF-8 byte order mark is stripped, and UTF-16
+// text is transcoded to UTF-8 so that it can be parsed. Text without a byte order mark is assumed to be UTF-8 and is
+// returned unchanged.
+func decodeText(rd io.Reader) (io.Reader, error) {
+	bom := make([]byte, len(utf8BOM))
+
+	n, err := peek(rd, bom)
+	if err != nil && n == 0 {
+		return nil, err
+	}
+
+	bom = bom[:n]
+	if err != nil {
+		// the source failed straight after the bytes peeked, which are read ahead of the error
+		return unread(bom, rd, err), nil
+	}
+
+	switch {
+	case bytes.HasPrefix(bom, utf8BOM):
+		return bomStrippedReader{rd}, nil
+	case bytes.HasPrefix(bom, utf16LEBOM):
+		return utf16Reader(bom[len(utf16LEBOM):], rd, unicode.LittleEndian), nil
+	case bytes.HasPrefix(bom, utf16BEBOM):
+		return utf16Reader(bom[len(utf16BEBOM):], rd, unicode.BigEndian), nil
+	}
+
+	// put the peeked bytes back in front of the stream
+	return unread(bom, rd, nil), nil
+}
+
+// utf16Reader returns a reader transcoding the UTF-16 text following its byte order mark to UTF-8. peeked holds the
+// bytes already read after the byte order mark.
+func utf16Reader(peeked []byte, rd io.Reader, endianness unicode.Endianness) io.Reader {
+	decoder := unicode.UTF16(endianness, unicode.IgnoreBOM).NewDecoder()
+
+	return transcodingReader{transform.NewReader(io.MultiReader(bytes.NewReader(peeked), rd), decoder)}
+}
+
+// transcodingReader reads text transcoded to UTF-8, which is a different size to the text it was transcoded from
+type transcodingReader struct {
+	io.Reader
+}
+
+// isTranscoded reports whether rd returns text transcoded from another encoding
+func isTranscoded(rd io.Reader) bool {
+	_, ok := rd.(transcodingReader)
+	return ok
+}
+
+// bomStrippedReader reads UTF-8 text following a byte order mark, so its offsets are behind those of the source
+type bomStrippedReader struct {
+	io.Reader
+}
+
+// preservesOffsets reports whether the offsets of the text read from rd are the offsets of the source it decodes
+func preservesOffsets(rd io.Reader) bool {
+	switch rd.(type) {
+	case transcodingReader, bomStrippedReader:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,87 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const textDoc = `{"name": "café 🍰", "list": [{"word": "naïve"}, {"word": "日本"}]}`
+
+func utf16Bytes(t *testing.T, text string, endianness unicode.Endianness) []byte {
+	data, err := unicode.UTF16(endianness, unicode.UseBOM).NewEncoder().Bytes([]byte(text))
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := map[string][]byte{
+		"utf-8":          []byte(textDoc),
+		"utf-8 with bom": append(append([]byte(nil), utf8BOM...), textDoc...),
+		"utf-16le":       utf16Bytes(t, textDoc, unicode.LittleEndian),
+		"utf-16be":       utf16Bytes(t, textDoc, unicode.BigEndian),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			rd, err := AsyncReaderFromReader(bytes.NewReader(data), 8)
+			require.NoError(t, err)
+
+			ctx := rd.Start(context.Background())
+			require.Equal(t, textDoc, string(readAll(t, ctx, rd)))
+		})
+	}
+}
+
+func TestDecodeTextShortInput(t *testing.T) {
+	for _, data := range [][]byte{{}, {0xef}, {0xef, 0xbb}, utf8BOM, utf16LEBOM, {0xff}} {
+		rd, err := AsyncReaderFromReader(bytes.NewReader(data), 8)
+		require.NoError(t, err)
+
+		ctx := rd.Start(context.Background())
+		read := readAll(t, ctx, rd)
+
+		switch {
+		case bytes.Equal(data, utf8BOM), bytes.Equal(data, utf16LEBOM):
+			require.Empty(t, read)
+		default:
+			require.Equal(t, string(data), string(read))
+		}
+	}
+}
+
+func TestSplitUTF16File(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// compressed files are transcoded once they have been decompressed
+	filename := filepath.Join(tempDir, "export.json.gz")
+	require.NoError(t, os.WriteFile(filename, gzipBytes(t, utf16Bytes(t, textDoc, unicode.LittleEndian)), 0o644))
+
+	rd, err := AsyncReaderFromFile(filename, 8)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	// the size of the transcoded text isn't known
+	require.Equal(t, int64(-1), rd.TotalSize())
+
+	sink := newMemSink()
+	require.NoError(t, Split(context.Background(), rd, sink, Options{}))
+	require.Equal(t, "{\"word\":\"naïve\"}\n{\"word\":\"日本\"}", sink.contents("list_00.jsonl"))
+	require.Equal(t, "{\n\t\"name\":\"café 🍰\"\n}", sink.contents("root.json"))
+
+	// uncompressed UTF-16 files are a different size once transcoded too
+	filename = filepath.Join(tempDir, "export.json")
+	require.NoError(t, os.WriteFile(filename, utf16Bytes(t, textDoc, unicode.BigEndian), 0o644))
+
+	rd, err = AsyncReaderFromFile(filename, 8)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	require.Equal(t, int64(-1), rd.TotalSize())
+}
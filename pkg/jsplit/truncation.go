@@ -0,0 +1,28 @@
+package jsplit
+
+import (
+	"errors"
+	"fmt"
+)
+
+// tolerateTruncation reports whether parsing failed with err because the input ended part way through the document,
+// after at least one complete record, and Options.TolerateTruncation is set, in which case the split carries on as if
+// the document ended after the last complete record. The records parsed before it are written, and a warning is logged
+// with the byte the input ended at. Errors reading the input, and inputs which end before any record, still fail.
+func tolerateTruncation(itr *BufferedByteStreamIter, err error, opts Options) bool {
+	var pe *ParseError
+	if !opts.TolerateTruncation || !errors.As(err, &pe) || !itr.ended || itr.err != nil || itr.items == 0 {
+		return false
+	}
+
+	input := "the input"
+	if opts.source != "" {
+		input = opts.source
+	}
+
+	end := itr.offset + int64(len(itr.buffer))
+	logger().Warn(fmt.Sprintf("%s ended part way through the document at byte %d, the %d complete records before it "+
+		"were written: %s", input, end, itr.items, pe.Err), "offset", end, "records", itr.items)
+
+	return true
+}
@@ -0,0 +1,78 @@
+package jsplit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingByteStream reads the stream, and then fails with err where it would have ended
+type failingByteStream struct {
+	*TestByteStream
+	err error
+}
+
+func (fbs *failingByteStream) Read(ctx context.Context) ([]byte, error) {
+	data, err := fbs.TestByteStream.Read(ctx)
+	if err == io.EOF {
+		err = fbs.err
+	}
+
+	return data, err
+}
+
+func TestSplitStreamTolerateTruncation(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+
+	SetLogger(slog.New(NewMessageHandler(&buf, slog.LevelInfo)))
+	defer SetLogger(nil)
+
+	// an array missing its last record and closing bracket fails the split
+	const truncated = `[{"id": 1}, {"id": 2}, {"id": 3, "na`
+
+	err := SplitStream(ctx, NewTestByteStream([]byte(truncated), 8), t.TempDir(), Options{})
+	var pe *ParseError
+	require.ErrorAs(t, err, &pe)
+
+	// unless truncation is tolerated, writing the complete records before it
+	tempDir := t.TempDir()
+	require.NoError(t, SplitStream(ctx, NewTestByteStream([]byte(truncated), 8), tempDir,
+		Options{TolerateTruncation: true}))
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), `{"id":1}`+"\n"+`{"id":2}`)
+	require.Equal(t, int64(2), readManifest(t, tempDir).Keys[0].Records)
+	require.Contains(t, buf.String(), "the input ended part way through the document at byte 36, the 2 complete "+
+		"records before it were written")
+
+	// or missing just its closing bracket
+	tempDir = t.TempDir()
+	require.NoError(t, SplitStream(ctx, NewTestByteStream([]byte(`[{"id": 1}, {"id": 2}`), 8), tempDir,
+		Options{TolerateTruncation: true, Shards: 2}))
+	requireContents(t, filepath.Join(tempDir, "part-000_00.jsonl"), `{"id":1}`)
+	requireContents(t, filepath.Join(tempDir, "part-001_00.jsonl"), `{"id":2}`)
+
+	// the lists of an object which is cut short are written up to its last complete record
+	tempDir = t.TempDir()
+	require.NoError(t, SplitStream(ctx, NewTestByteStream([]byte(`{"a": [1, 2], "b": [{"x": 1}, {"x"`), 8), tempDir,
+		Options{TolerateTruncation: true}))
+	requireContents(t, filepath.Join(tempDir, "a_00.jsonl"), "1\n2")
+	requireContents(t, filepath.Join(tempDir, "b_00.jsonl"), `{"x":1}`)
+	require.Len(t, readManifest(t, tempDir).Keys, 2)
+
+	// input which ends before any complete record still fails
+	err = SplitStream(ctx, NewTestByteStream([]byte(`[{"id": 1`), 8), t.TempDir(), Options{TolerateTruncation: true})
+	require.ErrorAs(t, err, &pe)
+
+	// as does input which couldn't be read to its end
+	readErr := errors.New("connection reset")
+	err = SplitStream(ctx, &failingByteStream{NewTestByteStream([]byte(`[{"id": 1}, {"id": 2}`), 8), readErr}, t.TempDir(),
+		Options{TolerateTruncation: true})
+	require.ErrorIs(t, err, readErr)
+}
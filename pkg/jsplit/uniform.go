@@ -0,0 +1,119 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+)
+
+// nullValue is the json written for the fields Options.UniformFields adds to the objects missing them
+var nullValue = []byte("null")
+
+// InferUniformFields reads the json documents in filenames, as SplitFile and SplitFiles would split them, and returns
+// the fields of the objects of each list, by key, in the order they were first seen, for Options.UniformFields. It is
+// the first of the two passes a uniform schema takes, so each input is read, and parsed, twice in all: run it once
+// and keep the fields to split the same kind of input again without it. Standard input and zip and tar archives can't
+// be read twice, their fields have to be supplied instead. The options are applied as they are by Records, so the
+// fields are those of the objects as they are written, e.g. flattened.
+func InferUniformFields(ctx context.Context, filenames []string, opts Options) (map[string][]string, error) {
+	opts.UniformFields = nil
+
+	fields := make(map[string][]string)
+	seen := make(map[string]map[string]bool)
+
+	for _, filename := range filenames {
+		if filename == "-" || isZip(filename) || isTarGz(filename) {
+			return nil, fmt.Errorf("the fields of %s can't be inferred, as it can't be read twice", filename)
+		}
+
+		rd, err := AsyncReaderFromFile(filename, 1024*1024, opts.ReaderOptions...)
+		if err != nil {
+			return nil, err
+		}
+
+		records, errs := Records(rd.Start(ctx), rd, opts)
+
+		for r := range records {
+			if len(r.Value) == 0 || r.Value[0] != OpenCB {
+				continue
+			}
+
+			objFields, err := decodeObject(r.Value)
+			if err != nil {
+				continue
+			}
+
+			keySeen := seen[r.Key]
+			if keySeen == nil {
+				keySeen = make(map[string]bool)
+				seen[r.Key] = keySeen
+			}
+
+			for _, field := range objFields {
+				if !keySeen[field.Key] {
+					keySeen[field.Key] = true
+					fields[r.Key] = append(fields[r.Key], field.Key)
+				}
+			}
+		}
+
+		err = <-errs
+		_ = rd.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fields, nil
+}
+
+// uniformItems returns a ListAddFunc which writes the fields of the objects of the list with the given key in the
+// order of opts.UniformFields, adding those they are missing as null, before passing them to add, or add itself when
+// the key has no fields. Fields which aren't listed follow the listed ones, and items which aren't objects are passed
+// on unchanged.
+func uniformItems(key string, add ListAddFunc, opts Options) ListAddFunc {
+	fields := opts.UniformFields[key]
+	if len(fields) == 0 {
+		return add
+	}
+
+	listed := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		listed[field] = true
+	}
+
+	return func(item []byte) error {
+		if len(item) == 0 || item[0] != OpenCB {
+			return add(item)
+		}
+
+		objFields, err := decodeObject(item)
+		if err != nil {
+			return err
+		}
+
+		values := make(map[string][]byte, len(objFields))
+		for _, field := range objFields {
+			values[field.Key] = field.Value
+		}
+
+		uniform := make([]objectField, 0, len(fields)+len(objFields))
+
+		for _, field := range fields {
+			value, ok := values[field]
+			if !ok {
+				value = nullValue
+			}
+
+			uniform = append(uniform, objectField{Key: field, Value: value})
+		}
+
+		for _, field := range objFields {
+			if !listed[field.Key] {
+				uniform = append(uniform, field)
+			}
+		}
+
+		return add(encodeObject(uniform))
+	}
+}
@@ -0,0 +1,67 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStreamUniformFields(t *testing.T) {
+	const doc = `{"users": [{"id": 1, "name": "a"}, {"email": "b@x", "id": 2}, {"id": 3, "tags": ["x"]}, 4], ` +
+		`"groups": [{"g": 1}, {"h": 2}]}`
+
+	// ragged objects are written with every field, in the order listed, and those which aren't listed after them
+	tempDir := t.TempDir()
+	bs := NewTestByteStream([]byte(doc), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir,
+		Options{UniformFields: map[string][]string{"users": {"id", "name", "email"}}}))
+
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1,"name":"a","email":null}`+"\n"+
+		`{"id":2,"name":null,"email":"b@x"}`+"\n"+`{"id":3,"name":null,"email":null,"tags":["x"]}`+"\n"+"4")
+	requireContents(t, filepath.Join(tempDir, "groups_00.jsonl"), `{"g":1}`+"\n"+`{"h":2}`)
+
+	// csv output of uniform objects has the same columns in every file
+	tempDir = t.TempDir()
+	bs = NewTestByteStream([]byte(`{"users": [{"id": 1}, {"name": "b"}, {"id": 3, "name": "c"}]}`), 8)
+	require.NoError(t, SplitStream(context.Background(), bs, tempDir, Options{Format: FormatCSV, MaxFileBytes: 1,
+		UniformFields: map[string][]string{"users": {"id", "name"}}}))
+
+	for i, row := range []string{"1,", ",b", "3,c"} {
+		requireContents(t, filepath.Join(tempDir, fmt.Sprintf("users_%02d.csv", i)), "id,name\n"+row+"\n")
+	}
+}
+
+func TestInferUniformFields(t *testing.T) {
+	dir := t.TempDir()
+
+	first := filepath.Join(dir, "first.json")
+	require.NoError(t, os.WriteFile(first, []byte(`{"users": [{"id": 1, "a": {"b": 2}}, {"name": "x", "id": 2}, 3], `+
+		`"skipped": [{"s": 1}], "n": 1}`), 0o644))
+
+	second := filepath.Join(dir, "second.json")
+	require.NoError(t, os.WriteFile(second, []byte(`{"users": [{"email": "e", "id": 3}], "groups": [{"g": 1}]}`),
+		0o644))
+
+	// fields are collected from every input in the order they were first seen, as the objects are written
+	fields, err := InferUniformFields(context.Background(), []string{first, second},
+		Options{Flatten: true, ExcludeKeys: []string{"skipped"}})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"users": {"id", "a.b", "name", "email"}, "groups": {"g"}}, fields)
+
+	// so that the split writes every field
+	tempDir := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, SplitFile(context.Background(), first, tempDir,
+		Options{Flatten: true, UniformFields: fields}))
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1,"a.b":2,"name":null,"email":null}`+"\n"+
+		`{"id":2,"a.b":null,"name":"x","email":null}`+"\n"+"3")
+
+	_, err = InferUniformFields(context.Background(), []string{"-"}, Options{})
+	require.EqualError(t, err, "the fields of - can't be inferred, as it can't be read twice")
+
+	_, err = InferUniformFields(context.Background(), []string{filepath.Join(dir, "missing.json")}, Options{})
+	require.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package jsplit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// unixSocketScheme is the prefix of the URIs read by AsyncReaderFromFile from a Unix domain socket, e.g.
+// unix:///run/producer.sock
+const unixSocketScheme = "unix://"
+
+// isUnixSocket reports whether uri names a Unix domain socket
+func isUnixSocket(uri string) bool {
+	return strings.HasPrefix(uri, unixSocketScheme)
+}
+
+// AsyncReaderFromUnixSocket creates an AsyncReader for reading a document streamed by a local producer listening on
+// the Unix domain socket at path, which it connects to straight away. Compressed documents are detected from their
+// first bytes. The producer closing the connection ends the document, as the end of a file would. The connection is
+// closed when the context the AsyncReader was started with is cancelled, so that a read waiting on a producer which
+// has stopped sending returns. A socket can only be read once, so it can't be read from an offset or followed.
+func AsyncReaderFromUnixSocket(path string, bufferSize int, opts ...AsyncReaderOption) (*AsyncReader, error) {
+	afr, err := newAsyncReader(bufferSize, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if afr.byteRange != nil || afr.follow != nil {
+		return nil, fmt.Errorf("the socket %s can only be read once, from its start", path)
+	}
+
+	conn, err := dialUnixSocket(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = afr.attachSource(conn, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	// the offsets of the stream can't be read from again, so checkpoints can't be resumed from them
+	afr.seekable = false
+	afr.abort = func() {
+		_ = conn.Close()
+	}
+
+	return afr, nil
+}
+
+// dialUnixSocket connects to the Unix domain socket at path, giving up if ctx is cancelled first
+func dialUnixSocket(ctx context.Context, path string) (net.Conn, error) {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the socket %s: %w", path, err)
+	}
+
+	return conn, nil
+}
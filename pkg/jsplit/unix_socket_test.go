@@ -0,0 +1,108 @@
+package jsplit
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serveUnixSocket listens on a socket in a temporary directory, serving each connection with serve, and returns the
+// socket's path
+func serveUnixSocket(t *testing.T, serve func(conn net.Conn)) string {
+	path := filepath.Join(t.TempDir(), "producer.sock")
+
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go serve(conn)
+		}
+	}()
+
+	return path
+}
+
+func TestAsyncReaderFromUnixSocket(t *testing.T) {
+	const contents = `{"list": [1, 2, 3], "name": "producer"}`
+
+	// the producer closing the connection ends the document, which is decompressed as a file would be
+	path := serveUnixSocket(t, func(conn net.Conn) {
+		defer conn.Close()
+
+		_, _ = conn.Write(gzipBytes(t, []byte(contents)))
+	})
+
+	rd, err := AsyncReaderFromUnixSocket(path, 8)
+	require.NoError(t, err)
+	require.Equal(t, CompressionGzip, rd.compression)
+	require.Equal(t, contents, string(readAll(t, rd.Start(context.Background()), rd)))
+	require.NoError(t, rd.Close())
+
+	// unix:// URIs name sockets, so that they can be split like any other input
+	tempDir := t.TempDir()
+	require.NoError(t, SplitFile(context.Background(), "unix://"+path, filepath.Join(tempDir, "out"), Options{}))
+	requireContents(t, filepath.Join(tempDir, "out", "list_00.jsonl"), "1\n2\n3")
+
+	_, err = AsyncReaderFromUnixSocket(filepath.Join(tempDir, "missing.sock"), 8)
+	require.ErrorContains(t, err, "unable to connect to the socket "+filepath.Join(tempDir, "missing.sock"))
+
+	_, err = AsyncReaderFromFile("unix://"+path, 8, WithByteRange(0, 4))
+	require.EqualError(t, err, "a byte range can't be read from unix://"+path)
+}
+
+func TestAsyncReaderFromUnixSocketCancelled(t *testing.T) {
+	// a producer which stops sending without closing the connection
+	path := serveUnixSocket(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte(`{"list": [1, `))
+	})
+
+	rd, err := AsyncReaderFromUnixSocket(path, 64)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	readCtx := rd.Start(ctx)
+
+	var read []byte
+
+	for len(read) < len(`{"list": [1, `) {
+		buf, err := rd.Read(readCtx)
+		require.NoError(t, err)
+
+		read = append(read, buf...)
+	}
+
+	require.Equal(t, `{"list": [1, `, string(read))
+
+	// cancelling the context closes the connection, so the read waiting on the producer returns
+	cancel()
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := rd.Read(readCtx)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the read wasn't stopped by cancelling the context")
+	}
+
+	require.NoError(t, rd.Close())
+}
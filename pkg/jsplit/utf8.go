@@ -0,0 +1,80 @@
+package jsplit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// UTF8Policy is what a split does with input which isn't valid UTF-8, see Options.StrictUTF8
+type UTF8Policy string
+
+const (
+	// UTF8Error fails the split with ErrInvalidUTF8 at the first list item, value or key which isn't valid UTF-8
+	UTF8Error UTF8Policy = "error"
+	// UTF8Replace replaces each run of bytes which isn't valid UTF-8 with the replacement character U+FFFD, as
+	// encoding/json does when it decodes strings
+	UTF8Replace UTF8Policy = "replace"
+)
+
+// ErrInvalidUTF8 is returned when the input holds bytes which aren't valid UTF-8 and Options.StrictUTF8 is UTF8Error
+var ErrInvalidUTF8 = errors.New("invalid UTF-8")
+
+// replacementChar is the encoding of U+FFFD which invalid UTF-8 is replaced with
+var replacementChar = []byte(string(utf8.RuneError))
+
+// validateStrictUTF8 returns an error if opts.StrictUTF8 isn't a policy
+func (opts Options) validateStrictUTF8() error {
+	switch opts.StrictUTF8 {
+	case "", UTF8Error, UTF8Replace:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported UTF-8 policy %q, it must be %s or %s", opts.StrictUTF8, UTF8Error, UTF8Replace)
+}
+
+// checkUTF8 returns b as it is when it is valid UTF-8 or opts.StrictUTF8 isn't set. Otherwise invalid UTF-8 is either
+// an error naming what b is, such as a list item, or replaced, in a copy of b.
+func (opts Options) checkUTF8(b []byte, what string) ([]byte, error) {
+	if opts.StrictUTF8 == "" || utf8.Valid(b) {
+		return b, nil
+	}
+
+	if opts.StrictUTF8 == UTF8Replace {
+		return bytes.ToValidUTF8(b, replacementChar), nil
+	}
+
+	return nil, fmt.Errorf("%w at byte %d of %s %q", ErrInvalidUTF8, invalidUTF8Index(b), what, abbreviate(b))
+}
+
+// invalidUTF8Index returns the index of the first byte of b which isn't part of a valid UTF-8 sequence
+func invalidUTF8Index(b []byte) int {
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+
+		i += size
+	}
+
+	return -1
+}
+
+// utf8Items returns a ListAddFunc passing the items of a list to add once they have been checked for invalid UTF-8
+// following opts.StrictUTF8. add is returned as is when it isn't set.
+func utf8Items(add ListAddFunc, opts Options) ListAddFunc {
+	if opts.StrictUTF8 == "" {
+		return add
+	}
+
+	return func(item []byte) error {
+		item, err := opts.checkUTF8(item, "the list item")
+		if err != nil {
+			return err
+		}
+
+		return add(item)
+	}
+}
@@ -0,0 +1,64 @@
+package jsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckUTF8(t *testing.T) {
+	require.Equal(t, -1, invalidUTF8Index([]byte(`"héllo"`)))
+	require.Equal(t, 2, invalidUTF8Index([]byte("\"h\xc3\"")))
+	require.Equal(t, 0, invalidUTF8Index([]byte("\xff")))
+
+	// input isn't checked unless a policy is set
+	invalid := []byte("\"a\xffb\"")
+
+	b, err := Options{}.checkUTF8(invalid, "the list item")
+	require.NoError(t, err)
+	require.Equal(t, invalid, b)
+
+	b, err = Options{StrictUTF8: UTF8Replace}.checkUTF8(invalid, "the list item")
+	require.NoError(t, err)
+	require.Equal(t, "\"a�b\"", string(b))
+	require.Equal(t, "\"a\xffb\"", string(invalid))
+
+	_, err = Options{StrictUTF8: UTF8Error}.checkUTF8(invalid, "the list item")
+	require.ErrorIs(t, err, ErrInvalidUTF8)
+	require.EqualError(t, err, `invalid UTF-8 at byte 2 of the list item "\"a\xffb\""`)
+
+	b, err = Options{StrictUTF8: UTF8Error}.checkUTF8([]byte(`"ok ✓"`), "the list item")
+	require.NoError(t, err)
+	require.Equal(t, `"ok ✓"`, string(b))
+}
+
+func TestSplitStreamStrictUTF8(t *testing.T) {
+	const doc = "{\"items\": [{\"name\": \"a\xffb\"}, {\"name\": \"c\"}], \"title\": \"t\xc3\", \"k\xfe\": [1]}"
+
+	split := func(opts Options) (string, error) {
+		tempDir := t.TempDir()
+		return tempDir, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 7), tempDir, opts)
+	}
+
+	// invalid bytes are written as they are by default
+	tempDir, err := split(Options{})
+	require.NoError(t, err)
+	requireContents(t, filepath.Join(tempDir, "items_00.jsonl"), "{\"name\":\"a\xffb\"}\n{\"name\":\"c\"}")
+
+	// replaced with U+FFFD, in list items, values and keys
+	tempDir, err = split(Options{StrictUTF8: UTF8Replace})
+	require.NoError(t, err)
+	requireContents(t, filepath.Join(tempDir, "items_00.jsonl"), "{\"name\":\"a�b\"}\n{\"name\":\"c\"}")
+	requireContents(t, filepath.Join(tempDir, "k�_00.jsonl"), "1")
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"title\":\"t�\"\n}")
+
+	// or fail the split at the first of them
+	_, err = split(Options{StrictUTF8: UTF8Error})
+	require.ErrorIs(t, err, ErrInvalidUTF8)
+	require.ErrorContains(t, err, "invalid UTF-8 at byte 10 of the list item")
+
+	_, err = split(Options{StrictUTF8: "ignore"})
+	require.EqualError(t, err, `unsupported UTF-8 policy "ignore", it must be error or replace`)
+}
@@ -0,0 +1,312 @@
+package jsplit
+
+import (
+	"context"
+)
+
+// ValidateOptions configures how Validate reads a document
+type ValidateOptions struct {
+	// JSON5 accepts documents containing comments and trailing commas, which are stripped before the document is
+	// validated, as for Options.JSON5. The offsets of errors are then those of the stripped document.
+	JSON5 bool
+}
+
+// Validate checks that the document read from rd is a single well-formed json value, returning a *ParseError
+// describing where the first problem is if it isn't, or the error which stopped rd from being read. Unlike a split,
+// which only finds the bounds of the values it copies, every string, number and literal is checked, along with the
+// structure of the objects and arrays holding them. Only the bytes being checked are held, so a document of any size
+// can be validated in a little memory, however deeply it is nested or however long its values are.
+func Validate(ctx context.Context, rd ByteStream, opts ValidateOptions) error {
+	v := &validator{itr: NewBufferedStreamIter(ctx, json5Input(rd, Options{JSON5: opts.JSON5}))}
+	return v.document()
+}
+
+// validator checks the json read from its iterator byte by byte, keeping the objects and arrays it is within on a
+// stack rather than recursing, so that deeply nested documents can't exhaust the goroutine's stack
+type validator struct {
+	itr   *BufferedByteStreamIter
+	stack []byte
+}
+
+// next returns the next byte, and false at the end of the document. The bytes read are dropped once the buffer has been
+// read, so that long values aren't held in memory.
+func (v *validator) next() (byte, bool) {
+	if v.itr.pos >= len(v.itr.buffer) {
+		v.itr.Skip()
+	}
+
+	ch := v.itr.Next()
+	if ch != 0 {
+		return ch, true
+	}
+
+	// Next also returns 0 at the end of the stream, which leaves the position where it was
+	return 0, v.itr.pos > 0 && v.itr.buffer[v.itr.pos-1] == 0
+}
+
+// nextToken returns the next byte which isn't whitespace, and false at the end of the document
+func (v *validator) nextToken() (byte, bool) {
+	for {
+		ch, ok := v.next()
+		if !ok || !isWhitespace[ch] {
+			return ch, ok
+		}
+	}
+}
+
+// unexpected returns the error for the byte just read, or for the end of the document, where something else was
+// expected
+func (v *validator) unexpected(ch byte, ok bool, expected string) error {
+	if !ok {
+		return v.itr.errorf("unexpected end of the document, expecting %s", expected)
+	}
+
+	return v.itr.errorf("unexpected %q, expecting %s", string([]byte{ch}), expected)
+}
+
+// document checks the value which makes up the document and that nothing but whitespace follows it
+func (v *validator) document() error {
+	ch, ok := v.nextToken()
+	if !ok {
+		return v.unexpected(ch, ok, "a value")
+	}
+
+	for {
+		// the value starting with ch, after which every container the value completes is closed in turn, until the
+		// next value is reached
+		next, closed, err := v.value(ch)
+		if err != nil {
+			return err
+		}
+
+		for closed {
+			if len(v.stack) == 0 {
+				ch, ok = v.nextToken()
+				if ok {
+					return v.itr.errorf("unexpected %q after the end of the document", string([]byte{ch}))
+				}
+
+				return v.itr.Err()
+			}
+
+			next, closed, err = v.afterValue()
+			if err != nil {
+				return err
+			}
+		}
+
+		ch = next
+	}
+}
+
+// value checks the value starting with ch, returning true once it is complete. An object or array which isn't empty is
+// pushed onto the stack instead, and the first byte of the first value within it is returned.
+func (v *validator) value(ch byte) (byte, bool, error) {
+	switch {
+	case ch == OpenCB:
+		next, ok := v.nextToken()
+		if ok && next == CloseCB {
+			return 0, true, nil
+		}
+
+		v.stack = append(v.stack, OpenCB)
+
+		next, err := v.key(next, ok)
+
+		return next, false, err
+	case ch == OpenSB:
+		next, ok := v.nextToken()
+		if ok && next == CloseSB {
+			return 0, true, nil
+		}
+
+		if !ok {
+			return 0, false, v.unexpected(next, ok, "a value or ']'")
+		}
+
+		v.stack = append(v.stack, OpenSB)
+
+		return next, false, nil
+	case ch == QM:
+		return 0, true, v.string()
+	case ch == 't':
+		return 0, true, v.literal("true")
+	case ch == 'f':
+		return 0, true, v.literal("false")
+	case ch == 'n':
+		return 0, true, v.literal("null")
+	case ch == '-' || isDigit(ch):
+		return 0, true, v.number(ch)
+	}
+
+	return 0, false, v.unexpected(ch, true, "a value")
+}
+
+// afterValue reads what follows a value within the container on top of the stack. A comma is followed by the next
+// value, whose first byte is returned, after the key before it in an object. A closing bracket pops the container,
+// which is a value which is complete, reported by returning true.
+func (v *validator) afterValue() (byte, bool, error) {
+	top := v.stack[len(v.stack)-1]
+
+	ch, ok := v.nextToken()
+
+	switch {
+	case ok && ch == COMMA && top == OpenCB:
+		next, ok := v.nextToken()
+		next, err := v.key(next, ok)
+
+		return next, false, err
+	case ok && ch == COMMA:
+		next, ok := v.nextToken()
+		if !ok {
+			return 0, false, v.unexpected(next, ok, "a value")
+		}
+
+		return next, false, nil
+	case ok && (ch == CloseCB && top == OpenCB || ch == CloseSB && top == OpenSB):
+		v.stack = v.stack[:len(v.stack)-1]
+		return 0, true, nil
+	case top == OpenCB:
+		return 0, false, v.unexpected(ch, ok, "',' or '}'")
+	}
+
+	return 0, false, v.unexpected(ch, ok, "',' or ']'")
+}
+
+// key checks the key of an object starting with ch and the colon which follows it, returning the first byte of the
+// value
+func (v *validator) key(ch byte, ok bool) (byte, error) {
+	if !ok || ch != QM {
+		return 0, v.unexpected(ch, ok, "a string key")
+	}
+
+	err := v.string()
+	if err != nil {
+		return 0, err
+	}
+
+	ch, ok = v.nextToken()
+	if !ok || ch != COLON {
+		return 0, v.unexpected(ch, ok, "':' after the key")
+	}
+
+	ch, ok = v.nextToken()
+	if !ok {
+		return 0, v.unexpected(ch, ok, "a value")
+	}
+
+	return ch, nil
+}
+
+// string checks the rest of a string whose opening quote has been read
+func (v *validator) string() error {
+	for {
+		ch, ok := v.next()
+
+		switch {
+		case !ok:
+			return v.unexpected(ch, ok, "the end of the string")
+		case ch == QM:
+			return nil
+		case ch < 0x20:
+			return v.itr.errorf("control character %q in a string, it must be escaped", string([]byte{ch}))
+		case ch != Escape:
+			continue
+		}
+
+		ch, ok = v.next()
+		if !ok {
+			return v.unexpected(ch, ok, "an escape sequence")
+		}
+
+		switch ch {
+		case QM, Escape, '/', 'b', 'f', 'n', 'r', 't':
+		case 'u':
+			for i := 0; i < 4; i++ {
+				ch, ok = v.next()
+				if !ok || !isHexDigit(ch) {
+					return v.unexpected(ch, ok, "a hex digit of a \\u escape")
+				}
+			}
+		default:
+			return v.itr.errorf("invalid escape sequence \\%s in a string", string([]byte{ch}))
+		}
+	}
+}
+
+// literal checks the rest of true, false or null once its first letter has been read
+func (v *validator) literal(word string) error {
+	for i := 1; i < len(word); i++ {
+		ch, ok := v.next()
+		if !ok || ch != word[i] {
+			return v.unexpected(ch, ok, word)
+		}
+	}
+
+	return nil
+}
+
+// number checks a number whose first byte, ch, has been read, leaving the byte which follows it to be read next
+func (v *validator) number(ch byte) error {
+	var ok bool
+
+	if ch == '-' {
+		ch, ok = v.next()
+		if !ok || !isDigit(ch) {
+			return v.unexpected(ch, ok, "a digit")
+		}
+	}
+
+	// a leading zero can't be followed by more digits
+	if ch == '0' {
+		ch, ok = v.next()
+	} else {
+		ch, ok = v.digits()
+	}
+
+	if ok && ch == '.' {
+		ch, ok = v.next()
+		if !ok || !isDigit(ch) {
+			return v.unexpected(ch, ok, "a digit after the decimal point")
+		}
+
+		ch, ok = v.digits()
+	}
+
+	if ok && (ch == 'e' || ch == 'E') {
+		ch, ok = v.next()
+		if ok && (ch == '+' || ch == '-') {
+			ch, ok = v.next()
+		}
+
+		if !ok || !isDigit(ch) {
+			return v.unexpected(ch, ok, "a digit of the exponent")
+		}
+
+		ch, ok = v.digits()
+	}
+
+	if ok {
+		v.itr.Advance(-1)
+	}
+
+	return nil
+}
+
+// digits reads past a run of digits, returning the byte which follows them
+func (v *validator) digits() (byte, bool) {
+	for {
+		ch, ok := v.next()
+		if !ok || !isDigit(ch) {
+			return ch, ok
+		}
+	}
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F'
+}
@@ -0,0 +1,148 @@
+package jsplit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func validate(doc string, readSize int) error {
+	return Validate(context.Background(), NewTestByteStream([]byte(doc), readSize), ValidateOptions{})
+}
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		`{}`, `[]`, `"s"`, `0`, `-0.5e+10`, `true`, `null`, ` {"a": [1, 2.5, -3e2, "x", true, false, null, {}, []]} `,
+		`{"a":{"b":{"c":[[[]]]}}, "d": "\"\\\/\b\f\n\r\té😀"}`, "[\n\t1 ,\r\n2\n]", `"é"`, `1E5`, `0e0`,
+	}
+
+	invalid := map[string]string{
+		``:              `unexpected end of the document, expecting a value`,
+		`   `:           `unexpected end of the document, expecting a value`,
+		`{`:             `unexpected end of the document, expecting a string key`,
+		`{"a"}`:         `unexpected "}", expecting ':' after the key`,
+		`{"a":}`:        `unexpected "}", expecting a value`,
+		`{"a":1,}`:      `unexpected "}", expecting a string key`,
+		`{a:1}`:         `unexpected "a", expecting a string key`,
+		`{"a":1 "b":2}`: `unexpected "\"", expecting ',' or '}'`,
+		`[1,]`:          `unexpected "]", expecting a value`,
+		`[1 2]`:         `unexpected "2", expecting ',' or ']'`,
+		`[1}`:           `unexpected "}", expecting ',' or ']'`,
+		`{"a":1]`:       `unexpected "]", expecting ',' or '}'`,
+		`[`:             `unexpected end of the document, expecting a value or ']'`,
+		`[[1]`:          `unexpected end of the document, expecting ',' or ']'`,
+		`{} {}`:         `unexpected "{" after the end of the document`,
+		`1 2`:           `unexpected "2" after the end of the document`,
+		`tru`:           `unexpected end of the document, expecting true`,
+		`[nul]`:         `unexpected "]", expecting null`,
+		`flase`:         `unexpected "l", expecting false`,
+		`01`:            `unexpected "1" after the end of the document`,
+		`-`:             `unexpected end of the document, expecting a digit`,
+		`-a`:            `unexpected "a", expecting a digit`,
+		`1.`:            `unexpected end of the document, expecting a digit after the decimal point`,
+		`1.e5`:          `unexpected "e", expecting a digit after the decimal point`,
+		`1e`:            `unexpected end of the document, expecting a digit of the exponent`,
+		`1e+`:           `unexpected end of the document, expecting a digit of the exponent`,
+		`+1`:            `unexpected "+", expecting a value`,
+		`.5`:            `unexpected ".", expecting a value`,
+		`"abc`:          `unexpected end of the document, expecting the end of the string`,
+		`"a\qb"`:        `invalid escape sequence \q in a string`,
+		`"a\u12G4"`:     `unexpected "G", expecting a hex digit of a \u escape`,
+		"\"a\tb\"":      `control character "\t" in a string, it must be escaped`,
+		"[1,\x00]":      `unexpected "\x00", expecting a value`,
+		`'a'`:           `unexpected "'", expecting a value`,
+		`NaN`:           `unexpected "N", expecting a value`,
+	}
+
+	for _, readSize := range []int{1, 3, 1024} {
+		for _, doc := range valid {
+			require.NoError(t, validate(doc, readSize), doc)
+		}
+
+		for doc, msg := range invalid {
+			err := validate(doc, readSize)
+
+			var pe *ParseError
+			require.ErrorAs(t, err, &pe, doc)
+			require.EqualError(t, pe.Err, msg, doc)
+		}
+	}
+
+	// the error says where the problem is
+	err := validate("{\n  \"a\": [1, 2],\n  \"b\": [3 4]\n}", 4)
+	require.EqualError(t, err, `invalid json at byte 27 (line 3) near "{\n  \"a\": [1, 2],\n  \"b\": [3 4]\n}": `+
+		`unexpected "4", expecting ',' or ']'`)
+
+	// comments and trailing commas are stripped first
+	doc := `{"a": 1, /* c */ "b": [2,],} // end`
+	require.Error(t, validate(doc, 4))
+	require.NoError(t, Validate(context.Background(), NewTestByteStream([]byte(doc), 4), ValidateOptions{JSON5: true}))
+}
+
+func TestValidateMatchesEncodingJSON(t *testing.T) {
+	seeds := []string{
+		`{"a": [1, -2.5e3, "x\"yA"], "b": {"c": null, "d": [true, false]}, "e": ""}`,
+		`[0, 10, 1.25, -0, 3E-2, [], {}, [[{"k": "v"}]]]`,
+	}
+
+	replacements := []byte{' ', ',', ':', '"', '\\', '[', ']', '{', '}', '0', '1', '-', '.', 'e', '+', 'a', 't', 'u', '\n'}
+
+	// every truncation and every replacement of a byte of the seeds is judged the same way as by encoding/json
+	for _, seed := range seeds {
+		var docs []string
+
+		for i := 0; i <= len(seed); i++ {
+			docs = append(docs, seed[:i])
+		}
+
+		for i := range seed {
+			for _, ch := range replacements {
+				docs = append(docs, seed[:i]+string(ch)+seed[i+1:])
+			}
+
+			docs = append(docs, seed[:i]+seed[i+1:])
+		}
+
+		for _, doc := range docs {
+			err := validate(doc, 5)
+			require.Equal(t, json.Valid([]byte(doc)), err == nil, "%s: %v", doc, err)
+		}
+	}
+}
+
+func TestValidateDeepAndLong(t *testing.T) {
+	// nesting is tracked without recursion, and long strings aren't held while they are checked
+	depth := 1000000
+	require.NoError(t, validate(strings.Repeat("[", depth)+strings.Repeat("]", depth), 4096))
+
+	rd := NewTestByteStream([]byte(`["`+strings.Repeat("a", 1<<20)+`"]`), 4096)
+
+	v := &validator{itr: NewBufferedStreamIter(context.Background(), rd)}
+	require.NoError(t, v.document())
+	require.Less(t, cap(v.itr.buffer), 3*4096)
+
+	// read errors are returned as they are
+	readErr := errors.New("read failed")
+	err := Validate(context.Background(), &failingStream{err: readErr}, ValidateOptions{})
+	require.ErrorIs(t, err, readErr)
+}
+
+// failingStream is a ByteStream which returns a chunk of json and then fails
+type failingStream struct {
+	err  error
+	read bool
+}
+
+func (fs *failingStream) Read(context.Context) ([]byte, error) {
+	if fs.read {
+		return nil, fs.err
+	}
+
+	fs.read = true
+
+	return []byte(`{"a": [1, 2`), nil
+}
@@ -0,0 +1,196 @@
+package jsplit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// InputStream says how input holding more than one json value is read
+type InputStream string
+
+const (
+	// InputStreamAuto reads the input as a stream of json values when its first value is an object on a line of its
+	// own, with no list among its values, which is followed by another value, as ndjson records are, see isValueStream.
+	// Anything else is read as one or more documents.
+	InputStreamAuto InputStream = "auto"
+	// InputStreamValues reads the input as a stream of json values separated by whitespace, such as an ndjson file,
+	// whose values are split as the elements of a json array at the root of the document would be. It can't be used
+	// with a root pointer.
+	InputStreamValues InputStream = "values"
+	// InputStreamDocuments reads the input as one document, or several written back to back, whose lists are split by
+	// their keys, with the lists of the documents after the first appended to the files of the same keys
+	InputStreamDocuments InputStream = "documents"
+)
+
+// validateInputStream checks opts.InputStream is one of the ways input can be read
+func (opts Options) validateInputStream() error {
+	switch opts.InputStream {
+	case "", InputStreamAuto, InputStreamDocuments:
+		return nil
+	case InputStreamValues:
+		if opts.Root != "" {
+			return errors.New("a root pointer can't be used with a stream of json values")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported input stream %q, it must be %s, %s or %s", opts.InputStream, InputStreamAuto,
+		InputStreamValues, InputStreamDocuments)
+}
+
+// valueStreamLookahead is how far into a document starting with an object splitFrom looks for the end of that object,
+// to tell a stream of json values, such as an ndjson file, from a single document
+const valueStreamLookahead = 64 * 1024
+
+// isValueStream reports whether the object the iterator is at is followed by another value, making the document a
+// stream of json values separated by whitespace, as ndjson is. So that a document which is split as it is read isn't
+// held up, or held in memory, while its first value is scanned, the document is taken to be a single one as soon as
+// the object is found to span lines, to hold a list among its values, even an empty one or one of scalars, as the
+// documents split by their keys do, or to go on for longer than valueStreamLookahead. Streams whose values hold lists,
+// such as ndjson records with a list of tags, are read with InputStreamValues. The iterator is left where it was, with
+// the bytes scanned still buffered.
+func isValueStream(itr *BufferedByteStreamIter) bool {
+	start := itr.pos
+	defer func() {
+		itr.pos = start
+	}()
+
+	var (
+		depth    int
+		inString bool
+		escaped  bool
+	)
+
+	for scanned := 0; depth > 0 || scanned == 0; scanned++ {
+		ch := itr.Next()
+
+		switch {
+		case ch == 0 || scanned == valueStreamLookahead:
+			return false
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case ch == Escape:
+				escaped = true
+			case ch == QM:
+				inString = false
+			}
+
+			continue
+		case ch == LF:
+			return false
+		case isWhitespace[ch]:
+			continue
+		case ch == QM:
+			inString = true
+		case ch == OpenSB && depth == 1:
+			return false
+		case ch == OpenCB || ch == OpenSB:
+			depth++
+		case ch == CloseCB || ch == CloseSB:
+			depth--
+		}
+	}
+
+	// SkipWhitespace would drop the bytes scanned, so the whitespace is skipped here instead
+	for {
+		ch := itr.Next()
+		if !isWhitespace[ch] {
+			return ch != 0
+		}
+	}
+}
+
+// parseValues parses a stream of json values separated by whitespace, calling addFn with each of them as if they were
+// the items of a list. Objects and arrays may span several lines, and blank lines are skipped. When onErr is set values
+// which aren't valid json are passed to it rather than addFn, as parseList does, but a value which can't be parsed
+// at all still stops parsing, as the end of the value can't be found.
+func parseValues(itr *BufferedByteStreamIter, addFn ListAddFunc, onErr listErrorFunc) error {
+	for index := 0; ; index++ {
+		SkipWhitespace(itr)
+
+		ch := itr.Next()
+		if ch == 0 {
+			return itr.Err()
+		}
+
+		itr.Advance(-1)
+
+		var (
+			val []byte
+			err error
+		)
+
+		if ch == OpenCB || ch == OpenSB {
+			val, err = ParseObject(itr)
+		} else {
+			val = scalarValue(itr)
+		}
+
+		if err != nil {
+			if onErr != nil {
+				onErrErr := onErr(index, nil, err)
+				if onErrErr != nil {
+					return onErrErr
+				}
+			}
+
+			return err
+		}
+
+		if onErr != nil && !json.Valid(val) {
+			err = onErr(index, val, invalidItemError(val))
+		} else {
+			err = addFn(val)
+			if err == nil {
+				itr.items++
+			}
+		}
+
+		// the rest of the stream is left unread, as there is no end of a list to find
+		if errors.Is(err, errListLimit) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		itr.Skip()
+	}
+}
+
+// scalarValue reads a value of a stream of json values which isn't an object or array, up to the whitespace which
+// follows it or the end of the stream
+func scalarValue(itr *BufferedByteStreamIter) []byte {
+	for {
+		ch := itr.Next()
+		if ch == 0 {
+			return itr.Value()
+		}
+
+		if isWhitespace[ch] {
+			itr.Advance(-1)
+			return itr.Value()
+		}
+	}
+}
+
+// parseRootList parses the elements of the json array at the root of the document, see parseList, or the values of a
+// stream of json values when the document is one, see parseValues
+func parseRootList(itr *BufferedByteStreamIter, addFn ListAddFunc, onErr listErrorFunc, opts Options) error {
+	var err error
+
+	if opts.valueStream {
+		err = parseValues(itr, addFn, onErr)
+	} else {
+		err = parseList(itr, addFn, onErr)
+	}
+
+	if tolerateTruncation(itr, err, opts) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,157 @@
+package jsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsValueStream(t *testing.T) {
+	tests := map[string]bool{
+		`{"a": 1}`:                         false,
+		`{"a": 1}` + "\n":                  false,
+		`{"a": 1}` + "\n" + `{"a": 2}`:     true,
+		`{"a": "}"}{"a": 2}`:               true,
+		`{"a": "\"}\n"} 1`:                 true,
+		"{\"a\": 1}\r\n\r\n{\"a\": 2}\r\n": true,
+		`{"a": {"b": [{"c": 1}]}} {}`:      true,
+		`{"a": 1`:                          false,
+
+		// documents which are split by their lists
+		`{"a": [{"b": 1}, {"b": 2}]}   `:                  false,
+		`{"a": [{"b": 1}]}` + "\n" + `{"a": 2}`:           false,
+		`{"a": [ [1]]}` + "\n" + `{"a": 2}`:               false,
+		"{\n  \"a\": 1\n}\n{\"a\": 2}":                    false,
+		`{"users": []}` + "\n" + `{"users": [{"id": 1}]}`: false,
+		`{"ids": [1, 2]}` + "\n" + `{"ids": [3]}`:         false,
+		`{"tags": ["a", "b"]}` + "\n[3]":                  false,
+	}
+
+	for doc, expected := range tests {
+		for _, readSize := range []int{1, 4, 1024} {
+			itr := NewBufferedStreamIter(context.Background(), NewTestByteStream([]byte(doc), readSize))
+			require.Equal(t, expected, isValueStream(itr), doc)
+
+			// the iterator is left at the start of the document
+			require.Equal(t, byte(doc[0]), itr.Next(), doc)
+		}
+	}
+
+	// as is a first value which takes longer than the lookahead to end
+	doc := `{"a": "` + strings.Repeat("x", valueStreamLookahead) + `"}` + "\n" + `{"a": 2}`
+	itr := NewBufferedStreamIter(context.Background(), NewTestByteStream([]byte(doc), 4096))
+	require.False(t, isValueStream(itr))
+}
+
+func TestSplitStreamValueStream(t *testing.T) {
+	const ndjson = `{"id": 1, "type": "user"}` + "\n" + `{"id": 2, "type": "group", "name": "a b"}` + "\n" +
+		`{"id": 3, "type": "user"}` + "\n"
+
+	split := func(doc string, opts Options) (string, error) {
+		tempDir := t.TempDir()
+		return tempDir, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir, opts)
+	}
+
+	// the values are written to the files of the root list
+	tempDir, err := split(ndjson, Options{})
+	require.NoError(t, err)
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), `{"id":1,"type":"user"}`+"\n"+
+		`{"id":2,"type":"group","name":"a b"}`+"\n"+`{"id":3,"type":"user"}`)
+
+	manifest := readManifest(t, tempDir)
+	require.Len(t, manifest.Keys, 1)
+	require.Equal(t, int64(3), manifest.Keys[0].Records)
+
+	// or partitioned by a field into files named after its values
+	tempDir, err = split(ndjson, Options{PartitionBy: "type"})
+	require.NoError(t, err)
+	requireContents(t, filepath.Join(tempDir, "user_00.jsonl"), `{"id":1,"type":"user"}`+"\n"+`{"id":3,"type":"user"}`)
+	requireContents(t, filepath.Join(tempDir, "group_00.jsonl"), `{"id":2,"type":"group","name":"a b"}`)
+
+	// blank lines, carriage returns, values after the first spanning several lines, scalars and a missing final
+	// newline are all read
+	const ragged = "\n  {\"id\": 1, \"tags\": [\"a\", \"b\"]}\r\n\r\n\n[1,\n 2]   {\"id\":\n 2}\n\t\"s\"\n42\nnull"
+
+	tempDir, err = split(ragged, Options{RootListKey: "items", InputStream: InputStreamValues})
+	require.NoError(t, err)
+	requireContents(t, filepath.Join(tempDir, "items_00.jsonl"), `{"id":1,"tags":["a","b"]}`+"\n"+`[1,2]`+"\n"+
+		`{"id":2}`+"\n"+`"s"`+"\n"+`42`+"\n"+`null`)
+
+	// values which aren't valid json are rejected when errors are skipped, and fail the split otherwise
+	const invalid = `{"id": 1}` + "\n" + `{"id": tru}` + "\n" + `nul` + "\n" + `{"id": 2}`
+
+	tempDir, err = split(invalid, Options{SkipErrors: true})
+	require.NoError(t, err)
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), `{"id":1}`+"\n"+`{"id":2}`)
+	require.Equal(t, int64(2), readManifest(t, tempDir).Keys[0].Rejected)
+
+	_, err = split(invalid, Options{Limit: 1})
+	require.NoError(t, err)
+
+	// a value which is cut short can't be skipped past
+	_, err = split(`{"id": 1}`+"\n"+`{"id": 2`, Options{SkipErrors: true})
+	require.ErrorContains(t, err, "unexpected EOF found while parsing object")
+
+	// a single object is still split by its keys, and a root pointer always points into a single document
+	tempDir, err = split(`{"users": [{"id": 1}]}`+"\n", Options{})
+	require.NoError(t, err)
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1}`)
+
+	_, err = split(ndjson, Options{Root: "/id"})
+	require.EqualError(t, err, "root pointer /id doesn't resolve to an object or array")
+
+	_, err = split(ndjson, Options{Root: "/id", InputStream: InputStreamValues})
+	require.EqualError(t, err, "a root pointer can't be used with a stream of json values")
+
+	_, err = split(ndjson, Options{InputStream: "lines"})
+	require.EqualError(t, err, `unsupported input stream "lines", it must be auto, values or documents`)
+
+	filename := filepath.Join(t.TempDir(), "input.ndjson")
+	require.NoError(t, os.WriteFile(filename, []byte(ndjson), 0o644))
+
+	err = SplitFile(context.Background(), filename, filepath.Join(t.TempDir(), "out"),
+		Options{CheckpointInterval: 10})
+	require.EqualError(t, err, "checkpoints can't be used with a stream of json values")
+}
+
+func TestSplitStreamInputStream(t *testing.T) {
+	split := func(doc string, opts Options) string {
+		tempDir := t.TempDir()
+		require.NoError(t, SplitStream(context.Background(), NewTestByteStream([]byte(doc), 8), tempDir, opts))
+
+		return tempDir
+	}
+
+	// documents written back to back are split by their keys whatever the lists of the first hold
+	for _, first := range []string{`{"users": []}`, `{"users": [], "ids": [1, 2]}`, `{"ids": [1, 2], "users": []}`} {
+		tempDir := split(first+"\n"+`{"users": [{"id": 1}]}`+"\n"+`{"users": [{"id": 2}]}`, Options{})
+		requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":1}`+"\n"+`{"id":2}`)
+		require.NoFileExists(t, filepath.Join(tempDir, "root_00.jsonl"), first)
+	}
+
+	// records holding lists are read as a stream of values when the input is said to be one
+	const records = `{"id": 1, "tags": ["a"]}` + "\n" + `{"id": 2, "tags": []}` + "\n"
+
+	tempDir := split(records, Options{InputStream: InputStreamValues})
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), `{"id":1,"tags":["a"]}`+"\n"+`{"id":2,"tags":[]}`)
+
+	tempDir = split(`[1, 2]`+"\n"+`[3]`, Options{InputStream: InputStreamValues})
+	requireContents(t, filepath.Join(tempDir, "root_00.jsonl"), "[1,2]\n[3]")
+
+	// and records without lists as documents when the input is said to be those
+	tempDir = split(`{"id": 1, "users": [{"id": 2}]}`+"\n"+`{"users": [{"id": 3}]}`,
+		Options{InputStream: InputStreamDocuments})
+	requireContents(t, filepath.Join(tempDir, "users_00.jsonl"), `{"id":2}`+"\n"+`{"id":3}`)
+
+	tempDir = split(`{"id": 1}`+"\n"+`{"name": "a"}`, Options{InputStream: InputStreamDocuments})
+	require.NoFileExists(t, filepath.Join(tempDir, "root_00.jsonl"))
+	requireContents(t, filepath.Join(tempDir, "root.json"), "{\n\t\"id\":1,\n\t\"name\":\"a\"\n}")
+
+	err := SplitFiles(context.Background(), writeInputs(t, t.TempDir(), records), t.TempDir(),
+		Options{InputStream: InputStreamValues})
+	require.EqualError(t, err, "several files can't be split with a stream of json values")
+}
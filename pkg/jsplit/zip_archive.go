@@ -0,0 +1,217 @@
+package jsplit
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/danielchalef/jsplit/pkg/cloud"
+)
+
+// isZip reports whether uri names a zip archive, from its extension
+func isZip(uri string) bool {
+	return strings.HasSuffix(strings.ToLower(uriPath(uri)), ".zip")
+}
+
+// uriPath returns the path of uri without any query, or uri itself when it is a local file name
+func uriPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme != "" {
+		return u.Path
+	}
+
+	return uri
+}
+
+// zipArchive is an open zip archive, holding the .json entries which are split
+type zipArchive struct {
+	entries []*zip.File
+	closer  io.Closer
+}
+
+// openZip opens the zip archive at uri, keeping its .json entries in the order they are stored. As zip archives are
+// read from their end, archives at http(s) URLs, in cloud storage and on hosts reached over ssh are downloaded to a
+// temporary file first, which is removed when the archive is closed. The temporary file is created in tempDir, or in
+// the default directory for temporary files when it is empty. Local archives are read in place.
+func openZip(uri, tempDir string, opts []AsyncReaderOption) (*zipArchive, error) {
+	var (
+		zr     *zip.Reader
+		closer io.Closer
+		err    error
+	)
+
+	if strings.HasPrefix(uri, "http") || cloud.IsCloudURI(uri) || isSSHPath(uri) {
+		zr, closer, err = downloadZip(uri, tempDir, opts)
+	} else {
+		var rc *zip.ReadCloser
+
+		rc, err = zip.OpenReader(uri)
+		if rc != nil {
+			zr, closer = &rc.Reader, rc
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	za := &zipArchive{closer: closer}
+
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() && strings.EqualFold(path.Ext(f.Name), ".json") {
+			za.entries = append(za.entries, f)
+		}
+	}
+
+	if len(za.entries) == 0 {
+		_ = za.Close()
+		return nil, fmt.Errorf("no .json entries in %s", uri)
+	}
+
+	return za, nil
+}
+
+// downloadZip downloads the zip archive at the http(s) URL, cloud storage URI or ssh remote path to a temporary file in
+// tempDir, as it is stored, and opens it. The AsyncReader options configure how cloud storage is read.
+func downloadZip(uri, tempDir string, opts []AsyncReaderOption) (*zip.Reader, io.Closer, error) {
+	afr, err := newAsyncReader(1, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer afr.closeCloud()
+
+	var r io.ReadCloser
+
+	switch {
+	case isSSHPath(uri):
+		r, _, err = openSSHPath(context.Background(), uri)
+	case strings.HasPrefix(uri, "http") && !cloud.IsAzureBlobURI(uri):
+		var resp *http.Response
+
+		resp, err = httpGet(context.Background(), http.DefaultClient, uri)
+		if resp != nil {
+			r = resp.Body
+		}
+	default:
+		r, _, _, err = afr.openCloudObject(context.Background(), uri)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer r.Close()
+
+	f, err := os.CreateTemp(tempDir, "jsplit-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closer := closerFunc(func() error {
+		_ = f.Close()
+		return os.Remove(f.Name())
+	})
+
+	logger().Info(fmt.Sprintf("Downloading %s to %s", uri, f.Name()), "input", uri, "file", f.Name())
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		_ = closer.Close()
+		return nil, nil, fmt.Errorf("unable to download %s: %w", uri, err)
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		_ = closer.Close()
+		return nil, nil, fmt.Errorf("unable to read %s: %w", uri, err)
+	}
+
+	return zr, closer, nil
+}
+
+// Close closes the archive, removing it if it was downloaded
+func (za *zipArchive) Close() error {
+	return za.closer.Close()
+}
+
+// entryDir returns the name of the directory the entry of an archive with the given name is split into, which is its
+// name without the .json extension. Path separators are escaped, so entries in folders of the archive are split into
+// directories of outputPath. An error is returned for names such as .json or ...json, which would leave no name or
+// one referring to outputPath or its parent.
+func entryDir(name string) (string, error) {
+	dir := fileKey(strings.TrimSuffix(name, path.Ext(name)))
+
+	err := checkFileName(dir)
+	if err != nil {
+		return "", fmt.Errorf("the entry can't be split into a directory named %q", dir)
+	}
+
+	return dir, nil
+}
+
+// splitZip splits each .json entry of the zip archive filename as its own document, one after another, into a
+// directory of outputPath named after it. Errors name the entry which was being split.
+func splitZip(ctx context.Context, filename, outputPath string, opts Options) error {
+	za, err := openZip(filename, opts.TempDir, opts.ReaderOptions)
+	if err != nil {
+		return err
+	}
+
+	defer za.Close()
+
+	parent := &dirSink{ctx: ctx, dir: outputPath}
+
+	for _, f := range za.entries {
+		opts.source = filename + "/" + f.Name
+
+		dir, err := entryDir(f.Name)
+		if err == nil {
+			err = splitZipEntry(ctx, f, parent.path(dir), opts)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", filename, f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// splitZipEntry splits the entry of a zip archive into dir
+func splitZipEntry(ctx context.Context, f *zip.File, dir string, opts Options) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	return splitEntry(ctx, r, f.Name, dir, opts)
+}
+
+// splitEntry splits the entry of an archive with the given name, read from r, into dir, which is created for it
+func splitEntry(ctx context.Context, r io.Reader, name, dir string, opts Options) error {
+	if !cloud.IsCloudURI(dir) && !opts.DryRun {
+		err := mkdir(dir, opts.DirMode)
+		if err != nil {
+			return err
+		}
+	}
+
+	rd, err := AsyncReaderFromReader(r, 1024*1024, opts.ReaderOptions...)
+	if err != nil {
+		return err
+	}
+
+	defer rd.Close()
+
+	logger().Info("Reading "+name, "entry", name)
+
+	return splitFrom(rd.Start(ctx), rd, NewDirSink(ctx, dir), opts, nil, nil)
+}
@@ -0,0 +1,123 @@
+package jsplit
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// zipBytes returns a zip archive holding the entries, in order. Names ending in / are directories.
+func zipBytes(t *testing.T, entries ...[2]string) []byte {
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+
+	for _, entry := range entries {
+		w, err := zw.Create(entry[0])
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(entry[1]))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestIsZip(t *testing.T) {
+	require.True(t, isZip("data.zip"))
+	require.True(t, isZip("/exports/DATA.ZIP"))
+	require.True(t, isZip("gs://bucket/data.zip"))
+	require.True(t, isZip("https://example.com/data.zip?token=abc"))
+	require.False(t, isZip("data.json"))
+	require.False(t, isZip("data.zip.gz"))
+	require.False(t, isZip("https://example.com/data.json?name=a.zip"))
+}
+
+func TestSplitFileZip(t *testing.T) {
+	data := zipBytes(t,
+		[2]string{"users.json", `{"name": "users", "users": [{"id": 1}, {"id": 2}]}`},
+		[2]string{"exports/", ""},
+		[2]string{"exports/groups.JSON", `[{"id": "admin"}]`},
+		[2]string{"README.txt", "not json"},
+	)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "data.zip")
+	require.NoError(t, os.WriteFile(input, data, 0o644))
+
+	// each entry is split into its own directory, and the entries which aren't json are skipped
+	outputDir := filepath.Join(dir, "output")
+	require.NoError(t, SplitFile(context.Background(), input, outputDir, Options{}))
+
+	requireContents(t, filepath.Join(outputDir, "users", "users_00.jsonl"), "{\"id\":1}\n{\"id\":2}")
+	requireContents(t, filepath.Join(outputDir, "users", "root.json"), "{\n\t\"name\":\"users\"\n}")
+	requireContents(t, filepath.Join(outputDir, "exports%2Fgroups", "root_00.jsonl"), `{"id":"admin"}`)
+	require.FileExists(t, filepath.Join(outputDir, "exports%2Fgroups", ManifestFilename))
+
+	entries, err := os.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// archives served over http are downloaded first
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	outputDir = filepath.Join(dir, "http")
+	tempDir := t.TempDir()
+	require.NoError(t, SplitFile(context.Background(), srv.URL+"/data.zip", outputDir, Options{TempDir: tempDir}))
+	requireContents(t, filepath.Join(outputDir, "users", "users_00.jsonl"), "{\"id\":1}\n{\"id\":2}")
+
+	// the download is removed once the archive has been split
+	requireEmptyDir(t, tempDir)
+}
+
+func TestSplitFileZipErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	// errors name the entry being split
+	input := filepath.Join(dir, "bad.zip")
+	data := zipBytes(t, [2]string{"good.json", `{"a": [1]}`}, [2]string{"bad.json", `{"a": [1, `})
+	require.NoError(t, os.WriteFile(input, data, 0o644))
+
+	err := SplitFile(context.Background(), input, filepath.Join(dir, "bad"), Options{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), input+"/bad.json: ")
+	require.FileExists(t, filepath.Join(dir, "bad", "good", ManifestFilename))
+
+	// entries whose names leave no directory name, or one referring to the output or its parent, are refused
+	for name, entryDir := range map[string]string{"...json": "..", ".json": ""} {
+		input = filepath.Join(dir, "dots.zip")
+		data = zipBytes(t, [2]string{"good.json", `{"a": [1]}`}, [2]string{name, `{"b": [1]}`})
+		require.NoError(t, os.WriteFile(input, data, 0o644))
+
+		outputDir := filepath.Join(dir, "dots", "output")
+		err = SplitFile(context.Background(), input, outputDir, Options{})
+		require.EqualError(t, err, fmt.Sprintf("%s/%s: the entry can't be split into a directory named %q", input, name,
+			entryDir))
+		require.NoFileExists(t, filepath.Join(dir, "dots", "b_00.jsonl"))
+		require.NoFileExists(t, filepath.Join(outputDir, "b_00.jsonl"))
+		require.NoError(t, os.RemoveAll(filepath.Join(dir, "dots")))
+	}
+
+	input = filepath.Join(dir, "empty.zip")
+	require.NoError(t, os.WriteFile(input, zipBytes(t, [2]string{"notes.txt", "{}"}), 0o644))
+
+	err = SplitFile(context.Background(), input, filepath.Join(dir, "empty"), Options{})
+	require.EqualError(t, err, fmt.Sprintf("no .json entries in %s", input))
+
+	input = filepath.Join(dir, "invalid.zip")
+	require.NoError(t, os.WriteFile(input, []byte(`{"a": [1]}`), 0o644))
+	require.Error(t, SplitFile(context.Background(), input, filepath.Join(dir, "invalid"), Options{}))
+}